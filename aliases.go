@@ -0,0 +1,12 @@
+package blkchain
+
+// The types, parsing and hashing moved to core and the block sources
+// to source, so that a program only parsing blocks does not depend on
+// lib/pq. This package keeps the old names as aliases of those (and
+// wrappers of the functions) for the code written against it; new
+// code should import core and source. The variables (e.g. Limits,
+// MainNetParams) are not here but for the errors: a copy would not
+// see or make changes, so code using them has to move to core.
+
+//go:generate go run ./cmd/aliasgen -dir core -import github.com/blkchain/blkchain/core -package blkchain -o core_gen.go
+//go:generate go run ./cmd/aliasgen -dir source -import github.com/blkchain/blkchain/source -package blkchain -o source_gen.go
@@ -6,7 +6,7 @@ import (
 	"net"
 	"time"
 
-	"github.com/blkchain/blkchain"
+	"github.com/blkchain/blkchain/core"
 	"github.com/btcsuite/btcd/blockchain"
 	"github.com/btcsuite/btcd/chaincfg"
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
@@ -24,12 +24,12 @@ type btcNode struct {
 	height   int // current height
 	n        int // pos within height
 	count    int
-	byHeight map[int][]*blkchain.BlockHeader
+	byHeight map[int][]*core.BlockHeader
 }
 
 type heightBH struct {
 	height int
-	bh     *blkchain.BlockHeader
+	bh     *core.BlockHeader
 }
 
 func (b *btcNode) Count() int {
@@ -56,28 +56,28 @@ func (b *btcNode) Next() bool {
 	return true
 }
 
-func (b *btcNode) BlockHeader() *blkchain.BlockHeader {
+func (b *btcNode) BlockHeader() *core.BlockHeader {
 	if len(b.byHeight[b.height]) > 0 {
 		return b.byHeight[b.height][b.n]
 	}
 	return nil
 }
 
-func (b *btcNode) ReadBlock() (*blkchain.Block, error) {
+func (b *btcNode) ReadBlock() (*core.Block, error) {
 	bh := b.BlockHeader()
 	return b.getBlock(bh.Hash())
 }
 
-func (b *btcNode) getHeaders(startHashes map[int][]blkchain.Uint256) error {
+func (b *btcNode) getHeaders(startHashes map[int][]core.Uint256) error {
 
 	b.headersCh = make(chan []*wire.BlockHeader)
 
-	byPrevHash := make(map[blkchain.Uint256][]*heightBH, 2000)
+	byPrevHash := make(map[core.Uint256][]*heightBH, 2000)
 
 	// initial lastHashes, must be in height reverse order as
 	// (contrary to docs) it seems to start at the first recognized
 	// blockhash, not the last one
-	lastHashes := make([]blkchain.Uint256, 0, len(startHashes))
+	lastHashes := make([]core.Uint256, 0, len(startHashes))
 	var minHeight int
 	for h, _ := range startHashes {
 		if minHeight == 0 || minHeight > h {
@@ -117,13 +117,13 @@ func (b *btcNode) getHeaders(startHashes map[int][]blkchain.Uint256) error {
 		}
 
 		for _, h := range hdrs {
-			bh := &blkchain.BlockHeader{
-				Version:        blkchain.Uint32(h.Version),
-				PrevHash:       blkchain.Uint256(h.PrevBlock),
-				HashMerkleRoot: blkchain.Uint256(h.MerkleRoot),
-				Time:           blkchain.Uint32(h.Timestamp.Unix()),
-				Bits:           blkchain.Uint32(h.Bits),
-				Nonce:          blkchain.Uint32(h.Nonce),
+			bh := &core.BlockHeader{
+				Version:        core.Uint32(h.Version),
+				PrevHash:       core.Uint256(h.PrevBlock),
+				HashMerkleRoot: core.Uint256(h.MerkleRoot),
+				Time:           core.Uint32(h.Timestamp.Unix()),
+				Bits:           core.Uint32(h.Bits),
+				Nonce:          core.Uint32(h.Nonce),
 			}
 			if list, ok := byPrevHash[bh.PrevHash]; !ok {
 				byPrevHash[bh.PrevHash] = []*heightBH{&heightBH{0, bh}}
@@ -132,7 +132,7 @@ func (b *btcNode) getHeaders(startHashes map[int][]blkchain.Uint256) error {
 			}
 			b.count++
 
-			lastHashes = []blkchain.Uint256{bh.Hash()}
+			lastHashes = []core.Uint256{bh.Hash()}
 		}
 	}
 
@@ -145,13 +145,13 @@ func (b *btcNode) getHeaders(startHashes map[int][]blkchain.Uint256) error {
 	b.height = foundHeight // this is current height - 1
 
 	if b.byHeight == nil {
-		b.byHeight = make(map[int][]*blkchain.BlockHeader, len(byPrevHash))
+		b.byHeight = make(map[int][]*core.BlockHeader, len(byPrevHash))
 	}
 
 	for _, hbhs := range byPrevHash {
 		for _, hbh := range hbhs {
 			if list, ok := b.byHeight[hbh.height]; !ok {
-				b.byHeight[hbh.height] = []*blkchain.BlockHeader{hbh.bh}
+				b.byHeight[hbh.height] = []*core.BlockHeader{hbh.bh}
 			} else {
 				b.byHeight[hbh.height] = append(list, hbh.bh)
 			}
@@ -168,24 +168,24 @@ func (b *btcNode) getHeaders(startHashes map[int][]blkchain.Uint256) error {
 
 }
 
-func txFromMsgTx(mtx *wire.MsgTx) *blkchain.Tx {
-	tx := &blkchain.Tx{
+func txFromMsgTx(mtx *wire.MsgTx) *core.Tx {
+	tx := &core.Tx{
 		Version:  uint32(mtx.Version),
-		TxIns:    make(blkchain.TxInList, 0, len(mtx.TxIn)),
-		TxOuts:   make(blkchain.TxOutList, 0, len(mtx.TxOut)),
+		TxIns:    make(core.TxInList, 0, len(mtx.TxIn)),
+		TxOuts:   make(core.TxOutList, 0, len(mtx.TxOut)),
 		LockTime: uint32(mtx.LockTime),
 		SegWit:   false,
 	}
 	// TxIns
 	for _, in := range mtx.TxIn {
-		txin := &blkchain.TxIn{
-			PrevOut: blkchain.OutPoint{
-				Hash: blkchain.Uint256(in.PreviousOutPoint.Hash),
+		txin := &core.TxIn{
+			PrevOut: core.OutPoint{
+				Hash: core.Uint256(in.PreviousOutPoint.Hash),
 				N:    in.PreviousOutPoint.Index,
 			},
 			ScriptSig: in.SignatureScript,
 			Sequence:  in.Sequence,
-			Witness:   make(blkchain.Witness, 0, len(in.Witness)),
+			Witness:   make(core.Witness, 0, len(in.Witness)),
 		}
 		for _, w := range in.Witness {
 			txin.Witness = append(txin.Witness, w)
@@ -197,7 +197,7 @@ func txFromMsgTx(mtx *wire.MsgTx) *blkchain.Tx {
 	}
 	// TxOuts
 	for _, out := range mtx.TxOut {
-		tx.TxOuts = append(tx.TxOuts, &blkchain.TxOut{
+		tx.TxOuts = append(tx.TxOuts, &core.TxOut{
 			Value:        out.Value,
 			ScriptPubKey: out.PkScript,
 		})
@@ -205,18 +205,18 @@ func txFromMsgTx(mtx *wire.MsgTx) *blkchain.Tx {
 	return tx
 }
 
-func blockFromMsgBlock(mb *wire.MsgBlock, magic uint32) *blkchain.Block {
-	blk := &blkchain.Block{
+func blockFromMsgBlock(mb *wire.MsgBlock, magic uint32) *core.Block {
+	blk := &core.Block{
 		Magic: magic,
-		BlockHeader: &blkchain.BlockHeader{
-			Version:        blkchain.Uint32(mb.Header.Version),
-			PrevHash:       blkchain.Uint256(mb.Header.PrevBlock),
-			HashMerkleRoot: blkchain.Uint256(mb.Header.MerkleRoot),
-			Time:           blkchain.Uint32(mb.Header.Timestamp.Unix()),
-			Bits:           blkchain.Uint32(mb.Header.Bits),
-			Nonce:          blkchain.Uint32(mb.Header.Nonce),
+		BlockHeader: &core.BlockHeader{
+			Version:        core.Uint32(mb.Header.Version),
+			PrevHash:       core.Uint256(mb.Header.PrevBlock),
+			HashMerkleRoot: core.Uint256(mb.Header.MerkleRoot),
+			Time:           core.Uint32(mb.Header.Timestamp.Unix()),
+			Bits:           core.Uint32(mb.Header.Bits),
+			Nonce:          core.Uint32(mb.Header.Nonce),
 		},
-		Txs: make(blkchain.TxList, 0, len(mb.Transactions)),
+		Txs: make(core.TxList, 0, len(mb.Transactions)),
 	}
 	for _, mtx := range mb.Transactions {
 		blk.Txs = append(blk.Txs, txFromMsgTx(mtx))
@@ -225,7 +225,7 @@ func blockFromMsgBlock(mb *wire.MsgBlock, magic uint32) *blkchain.Block {
 }
 
 // Get a block from the node
-func (b *btcNode) getBlock(hash blkchain.Uint256) (*blkchain.Block, error) {
+func (b *btcNode) getBlock(hash core.Uint256) (*core.Block, error) {
 
 	if b.blockCh == nil {
 		b.blockCh = make(chan *wire.MsgBlock)
@@ -242,28 +242,28 @@ func (b *btcNode) getBlock(hash blkchain.Uint256) (*blkchain.Block, error) {
 		return nil, fmt.Errorf("Time out.")
 	}
 
-	return blockFromMsgBlock(block, blkchain.MainNetMagic), nil
+	return blockFromMsgBlock(block, core.MainNetMagic), nil
 }
 
 // Recursively (from lowest height) assign height. Return the
 // satisfied height from the given parentHashes (assuming all is well,
 // there should only be one because of how the nodes respond to
 // getheaders)
-func setChildrenHeight(byPrevHash map[blkchain.Uint256][]*heightBH, parentHashes map[int][]blkchain.Uint256) (foundHeight int) {
+func setChildrenHeight(byPrevHash map[core.Uint256][]*heightBH, parentHashes map[int][]core.Uint256) (foundHeight int) {
 	for parentHeight, parents := range parentHashes {
 		for _, parentHash := range parents {
 			for _, child := range byPrevHash[parentHash] {
 				child.height = parentHeight + 1
 				foundHeight = parentHeight // this height found children in the node response
 				// log.Printf("%v %v", child.height, child.bh.Hash())
-				setChildrenHeight(byPrevHash, map[int][]blkchain.Uint256{child.height: []blkchain.Uint256{child.bh.Hash()}})
+				setChildrenHeight(byPrevHash, map[int][]core.Uint256{child.height: []core.Uint256{child.bh.Hash()}})
 			}
 		}
 	}
 	return foundHeight
 }
 
-func ReadBtcnodeBlockHeaderIndex(addr string, tmout time.Duration, hashes map[int][]blkchain.Uint256) (blkchain.BlockHeaderIndex, error) {
+func ReadBtcnodeBlockHeaderIndex(addr string, tmout time.Duration, hashes map[int][]core.Uint256) (core.BlockHeaderIndex, error) {
 
 	node, err := ConnectToNode(addr, tmout)
 	if err != nil {
@@ -345,7 +345,7 @@ func (b *btcNode) Close() error {
 }
 
 // Wait for inventory of block(s) from the node.
-func (b *btcNode) WaitForBlock(interrupt chan bool) ([]*blkchain.Block, error) {
+func (b *btcNode) WaitForBlock(interrupt chan bool) ([]*core.Block, error) {
 
 	if interrupt == nil {
 		interrupt = make(chan bool)
@@ -369,10 +369,10 @@ func (b *btcNode) WaitForBlock(interrupt chan bool) ([]*blkchain.Block, error) {
 			return nil, fmt.Errorf("Received nil message?")
 		}
 
-		result := make([]*blkchain.Block, 0)
+		result := make([]*core.Block, 0)
 		for _, inv := range msg.InvList {
 
-			hash := blkchain.Uint256(inv.Hash)
+			hash := core.Uint256(inv.Hash)
 
 			if inv.Type == wire.InvTypeBlock || inv.Type == wire.InvTypeWitnessBlock {
 				// NB: Seems like it's always InvTypeBlock, never InvTypeWitnessBlock.
@@ -399,7 +399,7 @@ func (b *btcNode) WaitForBlock(interrupt chan bool) ([]*blkchain.Block, error) {
 // Eliminate orphans by walking the chan backwards and whenever we
 // see more than one block at a height, picking the one that
 // matches its descendant's PrevHash.
-func eliminateOrphans(m map[int][]*blkchain.BlockHeader) (int, error) {
+func eliminateOrphans(m map[int][]*core.BlockHeader) (int, error) {
 
 	minHeight, maxHeight, count := -1, -1, 0
 
@@ -428,7 +428,7 @@ func eliminateOrphans(m map[int][]*blkchain.BlockHeader) (int, error) {
 		if len(m[h]) > 1 { // More than one block at this height
 			for _, bh := range m[h] {
 				if bh.Hash() == prevHash {
-					m[h] = []*blkchain.BlockHeader{bh}
+					m[h] = []*core.BlockHeader{bh}
 				} else {
 					log.Printf("Ignoring orphan block %v", bh.Hash())
 					count--
@@ -0,0 +1,201 @@
+package btcnode
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/blkchain/blkchain/core"
+)
+
+// A BlockHeaderIndex of the blocks of a node with the REST interface
+// enabled (bitcoind -rest), which needs neither its files nor RPC
+// credentials, only access to its RPC port. The headers of the best
+// chain are walked from the last block known (GET
+// /rest/headers/<hash>.bin), then the blocks are read one by one (GET
+// /rest/block/<hash>.bin).
+
+// The most headers per request the node returns.
+const restMaxHeaders = 2000
+
+type restNode struct {
+	url    string // e.g. http://localhost:8332
+	client *http.Client
+
+	start   int // height of headers[0]
+	headers []*core.BlockHeader
+	idx     int
+}
+
+// ReadRestBlockHeaderIndex returns the index of the blocks of the node
+// at the URL after those of the hashes (as returned by
+// ChainWriter.HeightAndHashes), from the genesis block if there are
+// none. The last of the hashes still in the best chain of the node is
+// where it starts, an error if none is.
+func ReadRestBlockHeaderIndex(url string, tmout time.Duration, hashes map[int][]core.Uint256) (core.BlockHeaderIndex, error) {
+	n := &restNode{url: strings.TrimSuffix(url, "/"), client: &http.Client{Timeout: tmout}}
+
+	heights := make([]int, 0, len(hashes))
+	for h := range hashes {
+		heights = append(heights, h)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(heights)))
+	from, height := core.Uint256{}, -1
+	var lastErr error
+outer:
+	for _, h := range heights {
+		hash, err := n.blockHashByHeight(h)
+		if err != nil {
+			lastErr = err // e.g. the node is behind
+			continue
+		}
+		for _, known := range hashes[h] {
+			if known == hash {
+				from, height = hash, h
+				break outer
+			}
+		}
+	}
+	if len(hashes) > 0 && height < 0 {
+		if lastErr != nil {
+			return nil, lastErr
+		}
+		return nil, fmt.Errorf("None of the last blocks are in the best chain of the node")
+	}
+
+	skip := 1 // the last block known is the first header returned
+	if height < 0 {
+		var err error
+		if from, err = n.blockHashByHeight(0); err != nil {
+			return nil, err
+		}
+		height, skip = 0, 0
+	}
+	n.start, n.idx = height+skip, -1
+
+	for {
+		bhs, err := n.getHeaders(from)
+		if err != nil {
+			return nil, err
+		}
+		if len(bhs) < skip {
+			return nil, fmt.Errorf("Block %v is not in the best chain of the node", from)
+		}
+		n.headers = append(n.headers, bhs[skip:]...)
+		if len(bhs) < restMaxHeaders {
+			break
+		}
+		from, skip = bhs[len(bhs)-1].Hash(), 1
+		log.Printf("Read %d block headers over REST...", len(n.headers))
+	}
+	return n, nil
+}
+
+func (n *restNode) get(path string) ([]byte, error) {
+	resp, err := n.client.Get(n.url + path)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s: %s", path, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return body, nil
+}
+
+func (n *restNode) blockHashByHeight(height int) (core.Uint256, error) {
+	b, err := n.get(fmt.Sprintf("/rest/blockhashbyheight/%d.bin", height))
+	if err != nil {
+		return core.Uint256{}, err
+	}
+	if len(b) != 32 {
+		return core.Uint256{}, fmt.Errorf("Invalid block hash of height %d: %x", height, b)
+	}
+	return core.Uint256FromBytes(b), nil
+}
+
+// getHeaders returns the headers of the best chain from the block of
+// the hash on.
+func (n *restNode) getHeaders(from core.Uint256) ([]*core.BlockHeader, error) {
+	b, err := n.get(fmt.Sprintf("/rest/headers/%v.bin?count=%d", from, restMaxHeaders))
+	if err != nil {
+		// Before 24.0 the count was in the path.
+		var err2 error
+		if b, err2 = n.get(fmt.Sprintf("/rest/headers/%d/%v.bin", restMaxHeaders, from)); err2 != nil {
+			return nil, err
+		}
+	}
+	if len(b)%80 != 0 {
+		return nil, fmt.Errorf("Invalid headers response of %d bytes", len(b))
+	}
+	r := bytes.NewReader(b)
+	bhs := make([]*core.BlockHeader, len(b)/80)
+	for i := range bhs {
+		bhs[i] = &core.BlockHeader{}
+		if err := core.BinRead(bhs[i], r); err != nil {
+			return nil, err
+		}
+		if i > 0 && bhs[i].PrevHash != bhs[i-1].Hash() {
+			return nil, fmt.Errorf("Headers from %v are not a chain", from)
+		}
+	}
+	return bhs, nil
+}
+
+func (n *restNode) Count() int {
+	return len(n.headers)
+}
+
+func (n *restNode) CurrentHeight() int {
+	return n.start + n.idx
+}
+
+func (n *restNode) Next() bool {
+	if n.idx+1 >= len(n.headers) {
+		return false
+	}
+	n.idx++
+	return true
+}
+
+func (n *restNode) BlockHeader() *core.BlockHeader {
+	if n.idx < 0 || n.idx >= len(n.headers) {
+		return nil
+	}
+	return n.headers[n.idx]
+}
+
+func (n *restNode) ReadBlock() (*core.Block, error) {
+	hash := n.BlockHeader().Hash()
+	data, err := n.get(fmt.Sprintf("/rest/block/%v.bin", hash))
+	if err != nil {
+		return nil, err
+	}
+
+	// As in the block files but without magic and size.
+	r := bytes.NewReader(data)
+	b := &core.Block{BlockHeader: &core.BlockHeader{}}
+	if err := core.BinRead(b.BlockHeader, r); err != nil {
+		return nil, err
+	}
+	if err := core.BinRead(&b.Txs, r); err != nil {
+		return nil, err
+	}
+	if b.Hash() != hash {
+		return nil, fmt.Errorf("/rest/block/%v returned block %v", hash, b.Hash())
+	}
+	return b, nil
+}
+
+func (n *restNode) Close() error {
+	return nil
+}
@@ -0,0 +1,79 @@
+package btcnode
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/blkchain/blkchain/core"
+	"github.com/blkchain/blkchain/source"
+)
+
+// A BlockSource of the best chain of a node over RPC, by height
+// (getblockhash, getblock).
+
+type rpcSource struct {
+	rpc    *RPCClient
+	height int // of the next block
+	tip    int // as of the last getblockcount
+}
+
+// NewRPCSource returns the blocks of the best chain of the node from
+// the height on, until its tip.
+func NewRPCSource(rpc *RPCClient, height int) source.BlockSource {
+	return &rpcSource{rpc: rpc, height: height, tip: -1}
+}
+
+func (s *rpcSource) Next() (*source.BlockInfo, error) {
+	if s.height > s.tip {
+		tip, err := s.rpc.GetBlockCount()
+		if err != nil {
+			return nil, err
+		}
+		if s.tip = tip; s.height > s.tip {
+			return nil, io.EOF
+		}
+	}
+	hash, err := s.rpc.GetBlockHash(s.height)
+	if err != nil {
+		return nil, err
+	}
+	b, err := s.rpc.GetBlock(hash)
+	if err != nil {
+		return nil, err
+	}
+	s.height++
+	return &source.BlockInfo{Block: b, Height: s.height - 1}, nil
+}
+
+func (s *rpcSource) Close() error {
+	return nil
+}
+
+// RPCSourceAfter returns the blocks of the best chain of the node after
+// the last of the hashes (as returned by ChainWriter.HeightAndHashes)
+// still in it, from the genesis block if there are none.
+func RPCSourceAfter(rpc *RPCClient, hashes map[int][]core.Uint256) (source.BlockSource, error) {
+	heights := make([]int, 0, len(hashes))
+	for h := range hashes {
+		heights = append(heights, h)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(heights)))
+	for _, h := range heights {
+		hash, err := rpc.GetBlockHash(h)
+		if _, ok := err.(*RPCError); ok {
+			continue // beyond the tip of the node
+		} else if err != nil {
+			return nil, err
+		}
+		for _, known := range hashes[h] {
+			if known == hash {
+				return NewRPCSource(rpc, h+1), nil
+			}
+		}
+	}
+	if len(hashes) > 0 {
+		return nil, fmt.Errorf("None of the last blocks are in the best chain of the node")
+	}
+	return NewRPCSource(rpc, 0), nil
+}
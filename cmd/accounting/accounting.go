@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/blkchain/blkchain/pg"
+)
+
+// Export the history of a watch-only wallet (addresses and/or xpubs)
+// as accounting CSV, see pg/accounting.go.
+
+func main() {
+
+	connStr := flag.String("connstr", "host=/var/run/postgresql dbname=blocks sslmode=disable", "Db connection string")
+	chain := flag.String("chain", "", "The chain in this schema, e.g. testnet4, as imported with -chain (default mainnet, in public)")
+	fromHeight := flag.Int("from-height", 0, "First height")
+	toHeight := flag.Int("to-height", -1, "Last height (default the last height in address_events)")
+	gap := flag.Int("gap", pg.DefaultAccountingGap, "Unused addresses derived from an xpub before giving up")
+	sats := flag.Bool("sats", false, "Amounts in satoshis rather than BTC")
+
+	flag.Parse()
+
+	connstr, err := pg.ChainConnStr(*connStr, *chain)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+
+	if flag.NArg() == 0 {
+		log.Fatalf("Usage: accounting [flags] address|xpub ...")
+	}
+
+	amount := func(v int64) string {
+		if *sats {
+			return strconv.FormatInt(v, 10)
+		}
+		sign := ""
+		if v < 0 {
+			sign, v = "-", -v
+		}
+		return fmt.Sprintf("%s%d.%08d", sign, v/1e8, v%1e8)
+	}
+
+	w := csv.NewWriter(os.Stdout)
+	w.Write([]string{"date", "txid", "address", "amount", "fee", "balance"})
+	err = pg.AccountingExport(connstr, flag.Args(), *gap, *fromHeight, *toHeight, func(e *pg.AccountingEntry) error {
+		fee := ""
+		if e.Fee != 0 {
+			fee = amount(e.Fee)
+		}
+		return w.Write([]string{
+			e.Time.Format("2006-01-02 15:04:05"),
+			e.TxId.String(),
+			e.Address,
+			amount(e.Amount),
+			fee,
+			amount(e.Balance),
+		})
+	})
+	w.Flush()
+	if err == nil {
+		err = w.Error()
+	}
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+}
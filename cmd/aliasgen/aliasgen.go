@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Generate the compatibility aliases of a package which moved, e.g.
+// the blkchain package (now core) and db (now pg): every exported
+// type, constant, variable and function of the new package under the
+// same name in the old one, so that code importing the old path still
+// builds. Types are aliases, functions are wrappers (or variables
+// holding the function when the signature uses unexported types) and
+// constants are the same constants.
+//
+// Variables cannot be aliased, a copy would silently stop working for
+// the code assigning to it (e.g. blkchain.Limits), so they are left
+// out and such code does not compile. The exception are the errors
+// (Err...), whose copies compare equal to the originals.
+
+func main() {
+
+	dir := flag.String("dir", "", "Directory of the package to alias (required)")
+	importPath := flag.String("import", "", "Import path of the package to alias (required)")
+	pkgName := flag.String("package", "", "Name of the package of the aliases (required)")
+	out := flag.String("o", "", "Output file (default stdout)")
+
+	flag.Parse()
+
+	if *dir == "" || *importPath == "" || *pkgName == "" {
+		log.Fatalf("-dir, -import and -package are required")
+	}
+
+	src, err := aliases(*dir, *importPath, *pkgName)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+
+	if *out == "" {
+		os.Stdout.Write(src)
+		return
+	}
+	if err := os.WriteFile(*out, src, 0644); err != nil {
+		log.Fatalf("Error writing %s: %v", *out, err)
+	}
+}
+
+type generator struct {
+	fset    *token.FileSet
+	name    string            // of the package aliased
+	imports map[string]string // needed by the wrappers, path by name
+	b       bytes.Buffer
+}
+
+func aliases(dir, importPath, pkgName string) ([]byte, error) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, dir, func(fi os.FileInfo) bool {
+		return !strings.HasSuffix(fi.Name(), "_test.go")
+	}, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+	if len(pkgs) != 1 {
+		return nil, fmt.Errorf("Expected one package in %s, found %d.", dir, len(pkgs))
+	}
+	var pkg *ast.Package
+	for _, p := range pkgs {
+		pkg = p
+	}
+
+	g := &generator{fset: fset, name: pkg.Name, imports: map[string]string{pkg.Name: importPath}}
+
+	files := make([]string, 0, len(pkg.Files))
+	for name := range pkg.Files {
+		files = append(files, name)
+	}
+	sort.Strings(files)
+
+	var body bytes.Buffer
+	for _, name := range files {
+		g.b.Reset()
+		g.file(pkg.Files[name])
+		body.Write(g.b.Bytes())
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "// Code generated by cmd/aliasgen. DO NOT EDIT.\n\n")
+	fmt.Fprintf(&b, "package %s\n\nimport (\n", pkgName)
+	names := make([]string, 0, len(g.imports))
+	for name := range g.imports {
+		names = append(names, name)
+	}
+	// The standard library first, as goimports does.
+	std := func(p string) bool { return !strings.Contains(strings.Split(p, "/")[0], ".") }
+	sort.Slice(names, func(i, j int) bool {
+		pi, pj := g.imports[names[i]], g.imports[names[j]]
+		if std(pi) != std(pj) {
+			return std(pi)
+		}
+		return pi < pj
+	})
+	for i, name := range names {
+		p := g.imports[name]
+		if i > 0 && std(g.imports[names[i-1]]) && !std(p) {
+			fmt.Fprintf(&b, "\n")
+		}
+		if path.Base(p) == name {
+			fmt.Fprintf(&b, "\t%q\n", p)
+		} else {
+			fmt.Fprintf(&b, "\t%s %q\n", name, p)
+		}
+	}
+	fmt.Fprintf(&b, ")\n")
+	b.Write(body.Bytes())
+	return format.Source(b.Bytes())
+}
+
+// fileImports returns the paths of the imports of the file by name.
+func fileImports(f *ast.File) map[string]string {
+	m := make(map[string]string)
+	for _, imp := range f.Imports {
+		p, _ := strconv.Unquote(imp.Path.Value)
+		name := path.Base(p)
+		if imp.Name != nil {
+			name = imp.Name.Name
+		}
+		m[name] = p
+	}
+	return m
+}
+
+func (g *generator) file(f *ast.File) {
+	imports := fileImports(f)
+	for _, decl := range f.Decls {
+		switch d := decl.(type) {
+		case *ast.GenDecl:
+			g.genDecl(d)
+		case *ast.FuncDecl:
+			if d.Recv == nil && d.Name.IsExported() {
+				g.funcDecl(d, imports)
+			}
+		}
+	}
+}
+
+func (g *generator) genDecl(d *ast.GenDecl) {
+	for _, spec := range d.Specs {
+		switch s := spec.(type) {
+		case *ast.TypeSpec:
+			if s.Name.IsExported() && s.TypeParams == nil {
+				fmt.Fprintf(&g.b, "\ntype %s = %s.%s\n", s.Name, g.name, s.Name)
+			}
+		case *ast.ValueSpec:
+			kind := "var"
+			if d.Tok == token.CONST {
+				kind = "const"
+			}
+			for _, n := range s.Names {
+				if !n.IsExported() || kind == "var" && !strings.HasPrefix(n.Name, "Err") {
+					continue
+				}
+				fmt.Fprintf(&g.b, "\n%s %s = %s.%s\n", kind, n, g.name, n)
+			}
+		}
+	}
+}
+
+func (g *generator) funcDecl(d *ast.FuncDecl, imports map[string]string) {
+	if d.Type.TypeParams != nil {
+		return
+	}
+	if usesUnexported(d.Type) {
+		fmt.Fprintf(&g.b, "\nvar %s = %s.%s\n", d.Name, g.name, d.Name)
+		return
+	}
+
+	// The parameters get names if they have none.
+	var (
+		params []string
+		args   []string
+	)
+	i := 0
+	for _, field := range d.Type.Params.List {
+		typ := g.expr(field.Type, imports)
+		_, variadic := field.Type.(*ast.Ellipsis)
+		names := field.Names
+		if len(names) == 0 {
+			names = []*ast.Ident{nil}
+		}
+		for _, n := range names {
+			name := fmt.Sprintf("p%d", i)
+			if n != nil && n.Name != "_" {
+				name = n.Name
+			}
+			i++
+			params = append(params, name+" "+typ)
+			if variadic {
+				name += "..."
+			}
+			args = append(args, name)
+		}
+	}
+	var results string
+	if d.Type.Results != nil {
+		var rs []string
+		for _, field := range d.Type.Results.List {
+			typ := g.expr(field.Type, imports)
+			for range field.Names {
+				rs = append(rs, typ)
+			}
+			if len(field.Names) == 0 {
+				rs = append(rs, typ)
+			}
+		}
+		results = strings.Join(rs, ", ")
+		if len(rs) > 1 {
+			results = "(" + results + ")"
+		}
+	}
+
+	fmt.Fprintf(&g.b, "\nfunc %s(%s) %s {\n\t", d.Name, strings.Join(params, ", "), results)
+	if results != "" {
+		fmt.Fprintf(&g.b, "return ")
+	}
+	fmt.Fprintf(&g.b, "%s.%s(%s)\n}\n", g.name, d.Name, strings.Join(args, ", "))
+}
+
+// expr prints a type of the signature, noting the packages it needs.
+func (g *generator) expr(e ast.Expr, imports map[string]string) string {
+	ast.Inspect(e, func(n ast.Node) bool {
+		if sel, ok := n.(*ast.SelectorExpr); ok {
+			if x, ok := sel.X.(*ast.Ident); ok {
+				if p, ok := imports[x.Name]; ok {
+					g.imports[x.Name] = p
+				}
+			}
+			return false
+		}
+		return true
+	})
+	var b bytes.Buffer
+	printer.Fprint(&b, g.fset, e)
+	return b.String()
+}
+
+// usesUnexported tells whether the signature refers to an unexported
+// type of the package, which the aliases cannot name.
+func usesUnexported(t *ast.FuncType) bool {
+	found := false
+	var visit func(n ast.Node) bool
+	visit = func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.SelectorExpr:
+			return false // of another package
+		case *ast.Field:
+			// The names of parameters are not types, also those of
+			// the func types of parameters.
+			ast.Inspect(n.Type, visit)
+			return false
+		case *ast.Ident:
+			if !n.IsExported() && !predeclared[n.Name] {
+				found = true
+			}
+		}
+		return true
+	}
+	ast.Inspect(t, visit)
+	return found
+}
+
+var predeclared = map[string]bool{
+	"bool": true, "byte": true, "complex64": true, "complex128": true, "error": true,
+	"float32": true, "float64": true, "int": true, "int8": true, "int16": true,
+	"int32": true, "int64": true, "rune": true, "string": true, "uint": true,
+	"uint8": true, "uint16": true, "uint32": true, "uint64": true, "uintptr": true,
+	"any": true, "interface": true,
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/format"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/blkchain/blkchain/serve"
+)
+
+// Generate the methods of the Go client (serve/client) from the routes
+// of the server, or with -openapi write the OpenAPI document, see
+// serve/routes.go.
+
+func main() {
+
+	out := flag.String("o", "", "Output file (default stdout)")
+	openAPI := flag.Bool("openapi", false, "Write the OpenAPI document rather than the client")
+
+	flag.Parse()
+
+	var (
+		src []byte
+		err error
+	)
+	if *openAPI {
+		src, err = serve.OpenAPI()
+		src = append(src, '\n')
+	} else {
+		src, err = format.Source(client(serve.Routes))
+	}
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+
+	if *out == "" {
+		os.Stdout.Write(src)
+		return
+	}
+	if err := os.WriteFile(*out, src, 0644); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+}
+
+var goTypes = map[string]string{"string": "string", "integer": "int", "boolean": "bool"}
+
+var setters = map[string]string{"string": "setString", "integer": "setInt", "boolean": "setBool"}
+
+// goName turns a param name such as check_only into CheckOnly.
+func goName(name string) string {
+	parts := strings.Split(name, "_")
+	for i, p := range parts {
+		parts[i] = strings.ToUpper(p[:1]) + p[1:]
+	}
+	return strings.Join(parts, "")
+}
+
+func client(routes []*serve.Route) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "// Code generated by cmd/apigen. DO NOT EDIT.\n\n")
+	fmt.Fprintf(&b, "package client\n\n")
+	fmt.Fprintf(&b, "import (\n\"context\"\n\"encoding/json\"\n\"net/url\"\n)\n\n")
+
+	for _, rt := range routes {
+		var path, query []*serve.Param
+		for _, p := range rt.Params {
+			if p.In == "path" {
+				path = append(path, p)
+			} else {
+				query = append(query, p)
+			}
+		}
+
+		if len(query) > 0 {
+			fmt.Fprintf(&b, "// %sParams are the query params of %s, nil = the default.\n", rt.Name, rt.Name)
+			fmt.Fprintf(&b, "type %sParams struct {\n", rt.Name)
+			for _, p := range query {
+				fmt.Fprintf(&b, "%s *%s // %s\n", goName(p.Name), goTypes[p.Type], p.Desc)
+			}
+			fmt.Fprintf(&b, "}\n\n")
+		}
+
+		args := []string{"ctx context.Context"}
+		for _, p := range path {
+			args = append(args, p.Name+" string")
+		}
+		if len(query) > 0 {
+			args = append(args, "p *"+rt.Name+"Params")
+		}
+		body := `""`
+		if rt.Body != "" {
+			args = append(args, "body string")
+			body = "body"
+		}
+		result, zero := "json.RawMessage", "nil"
+		if rt.Result == "integer" {
+			result, zero = "int", "0"
+		}
+
+		urlPath := fmt.Sprintf("%q", rt.Path)
+		for _, p := range path {
+			urlPath = strings.Replace(urlPath, "{"+p.Name+"}", `"+url.PathEscape(`+p.Name+`)+"`, 1)
+		}
+		urlPath = strings.TrimSuffix(urlPath, `+""`)
+
+		summary := strings.ToLower(rt.Summary[:1]) + rt.Summary[1:]
+		fmt.Fprintf(&b, "// %s calls %s %s: %s.\n", rt.Name, rt.Method, rt.Path, summary)
+		fmt.Fprintf(&b, "func (c *Client) %s(%s) (%s, error) {\n", rt.Name, strings.Join(args, ", "), result)
+		fmt.Fprintf(&b, "q := url.Values{}\n")
+		if len(query) > 0 {
+			fmt.Fprintf(&b, "if p != nil {\n")
+			for _, p := range query {
+				fmt.Fprintf(&b, "%s(q, %q, p.%s)\n", setters[p.Type], p.Name, goName(p.Name))
+			}
+			fmt.Fprintf(&b, "}\n")
+		}
+		if result == "json.RawMessage" {
+			fmt.Fprintf(&b, "return c.do(ctx, %q, %s, q, %s)\n}\n\n", rt.Method, urlPath, body)
+			continue
+		}
+		fmt.Fprintf(&b, "r, err := c.do(ctx, %q, %s, q, %s)\n", rt.Method, urlPath, body)
+		fmt.Fprintf(&b, "if err != nil {\nreturn %s, err\n}\n", zero)
+		fmt.Fprintf(&b, "var v %s\nreturn v, json.Unmarshal(r, &v)\n}\n\n", result)
+	}
+	return b.Bytes()
+}
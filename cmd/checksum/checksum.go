@@ -0,0 +1,84 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/blkchain/blkchain/pg"
+)
+
+// Compute the checksum manifest of the blocks in the database, see
+// pg/checksum.go. With -compare, the differences with another manifest
+// are printed and the exit status is 1 if there are any.
+
+func main() {
+
+	connStr := flag.String("connstr", "host=/var/run/postgresql dbname=blocks sslmode=disable", "Db connection string")
+	chain := flag.String("chain", "", "The chain in this schema, e.g. testnet4, as imported with -chain (default mainnet, in public)")
+	rangeSize := flag.Int("range", pg.DefaultChecksumRange, "Blocks per range")
+	fromHeight := flag.Int("from-height", 0, "First block height")
+	toHeight := flag.Int("to-height", -1, "Last block height (default the tip)")
+	out := flag.String("out", "", "Write the manifest to this file (default stdout)")
+	store := flag.Bool("store", false, "Store the checksums in the block_checksums table, reusing those already there")
+	compare := flag.String("compare", "", "Manifest to compare with")
+
+	flag.Parse()
+
+	connstr, err := pg.ChainConnStr(*connStr, *chain)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+
+	if *rangeSize <= 0 {
+		log.Fatalf("-range must be positive")
+	}
+
+	var other []*pg.RangeChecksum
+	if *compare != "" {
+		f, err := os.Open(*compare)
+		if err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		other, err = pg.ReadManifest(f)
+		f.Close()
+		if err != nil {
+			log.Fatalf("Error reading %s: %v", *compare, err)
+		}
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			log.Fatalf("Error creating %s: %v", *out, err)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	fmt.Fprintln(w, "# from\tto\tblocks\tsha256\trolling")
+	var manifest []*pg.RangeChecksum
+	err = pg.ChecksumBlocks(connstr, *fromHeight, *toHeight, *rangeSize, *store, func(c *pg.RangeChecksum) error {
+		manifest = append(manifest, c)
+		_, err := fmt.Fprintln(w, c)
+		return err
+	})
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+
+	if *compare != "" {
+		diffs := pg.CompareManifests(manifest, other)
+		for _, d := range diffs {
+			fmt.Fprintln(os.Stderr, d)
+		}
+		if len(diffs) > 0 {
+			log.Printf("The manifests differ in %d ranges.", len(diffs))
+			os.Exit(1)
+		}
+		log.Printf("The manifests match.")
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"log"
+	"time"
+
+	"github.com/blkchain/blkchain/core"
+	"github.com/blkchain/blkchain/coredb"
+	"github.com/blkchain/blkchain/pg"
+)
+
+// Write the blocks of the main chain from the database to blk*.dat
+// files, e.g. to seed a node (bitcoind -loadblock=...) or to archive a
+// range of heights, see pg/blockexport.go.
+
+func main() {
+
+	connStr := flag.String("connstr", "host=/var/run/postgresql dbname=blocks sslmode=disable", "Db connection string")
+	chain := flag.String("chain", "", "The chain in this schema, e.g. testnet4, as imported with -chain (default mainnet, in public)")
+	out := flag.String("out", "", "Directory to write the block files to, or s3:// or gs:// prefix (required)")
+	network := flag.String("network", coredb.NetworkMain, "Network of the magic: main, testnet3, testnet4, signet or regtest")
+	fromHeight := flag.Int("from-height", 0, "First block height")
+	toHeight := flag.Int("to-height", -1, "Last block height (default the tip)")
+	startFile := flag.Int("start-file", 0, "Number of the first file, i.e. blk<n>.dat")
+	maxFileSize := flag.Int64("max-file-size", core.MaxBlockFileSize, "Start a new file when a block would make it larger (0 = a single file)")
+
+	flag.Parse()
+
+	connstr, err := pg.ChainConnStr(*connStr, *chain)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+
+	if *out == "" {
+		log.Fatalf("-out is required")
+	}
+	magic, ok := coredb.NetworkMagic(*network)
+	if !ok {
+		log.Fatalf("Unknown network: %q", *network)
+	}
+
+	var bw *core.BlockFileWriter
+	if pg.IsObjectURL(*out) {
+		bw = core.NewBlockFileWriterFunc(func(name string) (io.WriteCloser, error) {
+			return pg.CreateObject(pg.JoinOutput(*out, name))
+		}, magic, *startFile, *maxFileSize)
+	} else {
+		var err error
+		if bw, err = core.NewBlockFileWriter(*out, magic, *startFile, *maxFileSize); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+	}
+
+	start := time.Now()
+	report := start
+	_, err = pg.ExportBlocks(connstr, *fromHeight, *toHeight, func(b *core.Block, height int) error {
+		if time.Now().Sub(report) > 5*time.Second {
+			log.Printf("Height %d...", height)
+			report = time.Now()
+		}
+		return bw.WriteBlock(b)
+	})
+	if cerr := bw.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		log.Fatalf("Error exporting blocks: %v", err)
+	}
+	log.Printf("Wrote %d blocks to %d files in %s.", bw.Blocks, bw.Files, time.Now().Sub(start).Round(time.Millisecond))
+}
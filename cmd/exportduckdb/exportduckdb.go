@@ -0,0 +1,105 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/blkchain/blkchain/pg"
+)
+
+// Export a height range of the database to a DuckDB file with the same
+// tables, for local analysis, see pg/duckdb.go. The duckdb CLI builds
+// the file from the CSV files exported, with -csv-dir only those are
+// written (to load with duckdb.sql later or elsewhere). Either can be
+// an s3:// or gs:// URL, to upload to once written locally.
+
+func main() {
+
+	connStr := flag.String("connstr", "host=/var/run/postgresql dbname=blocks sslmode=disable", "Db connection string")
+	chain := flag.String("chain", "", "The chain in this schema, e.g. testnet4, as imported with -chain (default mainnet, in public)")
+	out := flag.String("out", "", "DuckDB file to write (required unless -csv-dir)")
+	csvDir := flag.String("csv-dir", "", "Only write the CSV files and duckdb.sql to this directory")
+	duckdb := flag.String("duckdb", "duckdb", "The duckdb CLI")
+	fromHeight := flag.Int("from-height", 0, "First block height")
+	toHeight := flag.Int("to-height", -1, "Last block height (default the tip)")
+
+	flag.Parse()
+
+	connstr, err := pg.ChainConnStr(*connStr, *chain)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+
+	if *out == "" && *csvDir == "" {
+		log.Fatalf("-out or -csv-dir is required")
+	}
+	dir := *csvDir
+	if dir == "" || pg.IsObjectURL(dir) {
+		if dir == "" {
+			if _, err := exec.LookPath(*duckdb); err != nil {
+				log.Fatalf("The duckdb CLI is needed (see -duckdb), or use -csv-dir: %v", err)
+			}
+		}
+		parent := ""
+		if !pg.IsObjectURL(*out) && dir == "" {
+			if _, err := os.Stat(*out); err == nil {
+				log.Fatalf("%s exists already", *out)
+			}
+			parent = filepath.Dir(*out)
+		}
+		tmp, err := os.MkdirTemp(parent, "exportduckdb")
+		if err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		defer os.RemoveAll(tmp)
+		dir = tmp
+	}
+
+	start := time.Now()
+	counts, err := pg.ExportDuckDB(connstr, dir, *fromHeight, *toHeight)
+	if err != nil {
+		log.Fatalf("Error exporting: %v", err)
+	}
+	log.Printf("Exported %d blocks, %d txs, %d inputs and %d outputs in %s.", counts["blocks"], counts["txs"],
+		counts["txins"], counts["txouts"], time.Now().Sub(start).Round(time.Millisecond))
+	if *csvDir != "" {
+		if pg.IsObjectURL(*csvDir) {
+			n, err := pg.UploadDir(dir, *csvDir)
+			if err != nil {
+				log.Fatalf("Error uploading to %s: %v", *csvDir, err)
+			}
+			log.Printf("Uploaded %d files to %s.", n, *csvDir)
+			return
+		}
+		log.Printf("Load them with: cd %s && duckdb <file> < duckdb.sql", dir)
+		return
+	}
+
+	path := filepath.Join(dir, "export.duckdb")
+	if !pg.IsObjectURL(*out) {
+		if path, err = filepath.Abs(*out); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+	}
+	script, err := os.Open(filepath.Join(dir, "duckdb.sql"))
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	defer script.Close()
+	cmd := exec.Command(*duckdb, path)
+	cmd.Dir, cmd.Stdin, cmd.Stdout, cmd.Stderr = dir, script, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		os.Remove(path)
+		log.Fatalf("Error loading into DuckDB: %v", err)
+	}
+	if pg.IsObjectURL(*out) {
+		if err := pg.UploadFile(path, *out); err != nil {
+			log.Fatalf("Error uploading to %s: %v", *out, err)
+		}
+	}
+	log.Printf("Wrote %s in %s.", *out, time.Now().Sub(start).Round(time.Millisecond))
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"flag"
+	"log"
+
+	"github.com/blkchain/blkchain/fuzz"
+)
+
+// Write the seed corpora for the fuzz targets of package fuzz, one
+// go-fuzz workdir per target.
+
+func main() {
+
+	dir := flag.String("dir", "workdir", "Directory for the workdirs of the targets")
+
+	flag.Parse()
+
+	if err := fuzz.WriteCorpus(*dir); err != nil {
+		log.Fatalf("Error writing corpus: %v", err)
+	}
+}
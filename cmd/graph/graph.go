@@ -0,0 +1,79 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"log"
+	"os"
+
+	"github.com/blkchain/blkchain/pg"
+)
+
+// Export the transaction graph in Neo4j bulk import or GraphML
+// format, see pg/graph.go.
+
+func main() {
+
+	connStr := flag.String("connstr", "host=/var/run/postgresql dbname=blocks sslmode=disable", "Db connection string")
+	chain := flag.String("chain", "", "The chain in this schema, e.g. testnet4, as imported with -chain (default mainnet, in public)")
+	kind := flag.String("kind", pg.GraphTx, "Graph kind: tx (tx to tx via prevouts) or address (address to address flows)")
+	format := flag.String("format", "graphml", "Output format: graphml or neo4j")
+	out := flag.String("out", "", "Output file for graphml (default stdout), directory for neo4j (required), or s3:// or gs:// URL")
+	fromHeight := flag.Int("from-height", 0, "First block height")
+	toHeight := flag.Int("to-height", -1, "Last block height (default the tip)")
+
+	flag.Parse()
+
+	connstr, err := pg.ChainConnStr(*connStr, *chain)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+
+	var (
+		gw pg.GraphWriter
+		f  io.WriteCloser // of graphml
+	)
+	switch *format {
+	case "graphml":
+		f = os.Stdout
+		if *out != "" {
+			if f, err = pg.CreateOutput(*out); err != nil {
+				log.Fatalf("Error creating %s: %v", *out, err)
+			}
+		}
+		gw, err = pg.NewGraphMLWriter(f)
+	case "neo4j":
+		if *out == "" {
+			log.Fatalf("-out directory required with -format neo4j")
+		}
+		if !pg.IsObjectURL(*out) {
+			if err := os.MkdirAll(*out, 0755); err != nil {
+				log.Fatalf("Error creating %s: %v", *out, err)
+			}
+		}
+		relType := "FUNDS"
+		if *kind == pg.GraphAddress {
+			relType = "SENDS"
+		}
+		gw, err = pg.NewNeo4jWriter(*out, relType)
+	default:
+		log.Fatalf("Unknown format: %q", *format)
+	}
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+
+	nodes, edges, err := pg.ExportGraph(connstr, *kind, *fromHeight, *toHeight, gw)
+	if err != nil {
+		log.Fatalf("Error exporting graph: %v", err)
+	}
+	if err := gw.Close(); err != nil {
+		log.Fatalf("Error writing graph: %v", err)
+	}
+	if f != nil && f != os.Stdout {
+		if err := f.Close(); err != nil {
+			log.Fatalf("Error writing %s: %v", *out, err)
+		}
+	}
+	log.Printf("Exported %d nodes and %d edges.", nodes, edges)
+}
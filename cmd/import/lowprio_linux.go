@@ -0,0 +1,38 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"syscall"
+)
+
+// https://github.com/torvalds/linux/blob/v6.1/include/uapi/linux/ioprio.h
+const (
+	ioprioWhoProcess = 1
+	ioprioClassBE    = 2 // best-effort, 7 is its lowest level
+	ioprioClassShift = 13
+)
+
+// lowerPriority sets the nice value and the I/O priority of every
+// thread of the process (both are per thread in Linux), those created
+// later inherit them.
+func lowerPriority(nice int) error {
+	tasks, err := os.ReadDir("/proc/self/task")
+	if err != nil {
+		return err
+	}
+	for _, t := range tasks {
+		tid, err := strconv.Atoi(t.Name())
+		if err != nil {
+			continue
+		}
+		if err := syscall.Setpriority(syscall.PRIO_PROCESS, tid, nice); err != nil {
+			return err
+		}
+		if _, _, errno := syscall.Syscall(syscall.SYS_IOPRIO_SET, ioprioWhoProcess, uintptr(tid),
+			ioprioClassBE<<ioprioClassShift|7); errno != 0 {
+			return errno
+		}
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/blkchain/blkchain/pg"
+	"github.com/blkchain/blkchain/regtest"
+)
+
+// Import the regtest fixture (see regtest/fixture.go) into a temporary
+// database and check every row written. With -connstr a database is
+// created on that server, otherwise a cluster is started in a temporary
+// directory. Exits with status 1 if the rows are not as expected.
+
+func main() {
+
+	connStr := flag.String("connstr", "", "Db connection string of a server to create the database on (default: start a temporary cluster)")
+	pgBin := flag.String("pg-bin", "", "PostgreSQL bin directory for the temporary cluster (default: PATH, /usr/lib/postgresql/*/bin)")
+	keep := flag.Bool("keep", false, "Do not drop the database (or stop the cluster) afterwards")
+	dedupScripts := flag.Bool("dedup-scripts", false, "Import with script deduplication")
+	writeFixture := flag.String("write-fixture", "", "Build the fixture chain, write it to this file and exit")
+
+	flag.Parse()
+
+	if *writeFixture != "" {
+		c, err := regtest.BuildChain()
+		if err != nil {
+			log.Fatalf("Error building chain: %v", err)
+		}
+		f, err := os.Create(*writeFixture)
+		if err != nil {
+			log.Fatalf("Error creating %s: %v", *writeFixture, err)
+		}
+		if err := regtest.WriteChain(c, f); err != nil {
+			log.Fatalf("Error writing %s: %v", *writeFixture, err)
+		}
+		if err := f.Close(); err != nil {
+			log.Fatalf("Error writing %s: %v", *writeFixture, err)
+		}
+		return
+	}
+
+	c, err := regtest.Fixture()
+	if err != nil {
+		log.Fatalf("Error reading fixture: %v", err)
+	}
+
+	var tmp *regtest.TempPostgres
+	if *connStr != "" {
+		tmp, err = regtest.TempDatabase(*connStr)
+	} else {
+		tmp, err = regtest.TempCluster(*pgBin)
+	}
+	if err != nil {
+		log.Fatalf("Error setting up postgres: %v", err)
+	}
+	if *keep {
+		log.Printf("Keeping the database: %s", tmp.ConnectString)
+	}
+
+	problems, err := importAndCheck(tmp.ConnectString, *dedupScripts, c)
+	if !*keep {
+		if err := tmp.Close(); err != nil {
+			log.Printf("Error cleaning up postgres: %v", err)
+		}
+	}
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+
+	for _, p := range problems {
+		fmt.Println(p)
+	}
+	if len(problems) > 0 {
+		os.Exit(1)
+	}
+	log.Printf("OK: %d blocks", len(c.Blocks))
+}
+
+func importAndCheck(connStr string, dedupScripts bool, c *regtest.Chain) ([]string, error) {
+	cfg := pg.WriterConfig{
+		ConnectString: connStr,
+		CacheSize:     1000,
+		DedupScripts:  dedupScripts,
+	}
+	if err := regtest.Import(cfg, c); err != nil {
+		return nil, fmt.Errorf("Import: %v", err)
+	}
+
+	conn, err := sql.Open("postgres", connStr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return regtest.Check(conn, c)
+}
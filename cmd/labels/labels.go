@@ -0,0 +1,49 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/blkchain/blkchain/pg"
+)
+
+// Load address labels (exchanges, miners, ...) from CSV files into
+// the address_labels table. See pg.ImportAddressLabels for the format.
+
+func main() {
+
+	connStr := flag.String("connstr", "host=/var/run/postgresql dbname=blocks sslmode=disable", "Db connection string")
+	chain := flag.String("chain", "", "The chain in this schema, e.g. testnet4, as imported with -chain (default mainnet, in public)")
+	source := flag.String("source", "", "Source of the labels (default the file name)")
+	replace := flag.Bool("replace", false, "Delete labels from the same source first")
+
+	flag.Parse()
+
+	connstr, err := pg.ChainConnStr(*connStr, *chain)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+
+	if flag.NArg() == 0 {
+		log.Fatalf("Usage: labels [flags] file.csv ...")
+	}
+
+	for _, path := range flag.Args() {
+		src := *source
+		if src == "" {
+			src = filepath.Base(path)
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			log.Fatalf("Error opening %s: %v", path, err)
+		}
+		n, err := pg.ImportAddressLabels(connstr, f, src, *replace)
+		f.Close()
+		if err != nil {
+			log.Fatalf("Error importing %s: %v", path, err)
+		}
+		log.Printf("Imported %d labels from %s (source %q).", n, path, src)
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"flag"
+	"log"
+
+	"github.com/blkchain/blkchain/pg"
+)
+
+// Prune the scripts of long spent outputs, see pg/prune.go. This can
+// run while the import is following the chain.
+
+func main() {
+
+	connStr := flag.String("connstr", "host=/var/run/postgresql dbname=blocks sslmode=disable", "Db connection string")
+	chain := flag.String("chain", "", "The chain in this schema, e.g. testnet4, as imported with -chain (default mainnet, in public)")
+	depth := flag.Int("depth", 1000, "Prune outputs spent more than this many blocks ago")
+
+	flag.Parse()
+
+	connstr, err := pg.ChainConnStr(*connStr, *chain)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+
+	if err := pg.PruneScripts(connstr, *depth); err != nil {
+		log.Fatalf("Error pruning: %v", err)
+	}
+	log.Printf("All done, run VACUUM to make the space reusable.")
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"text/tabwriter"
+
+	"github.com/blkchain/blkchain/core"
+	"github.com/blkchain/blkchain/pg"
+)
+
+// Decode a PSBT and look up its inputs in the database, see
+// pg/psbt.go. The PSBT (base64, hex or binary) is read from the file
+// given as the argument, or stdin. Exits with status 1 if there are
+// problems.
+
+func main() {
+
+	connStr := flag.String("connstr", "host=/var/run/postgresql dbname=blocks sslmode=disable", "Db connection string")
+	chain := flag.String("chain", "", "The chain in this schema, e.g. testnet4, as imported with -chain (default mainnet, in public)")
+	format := flag.String("format", "table", "Output format: table or json")
+
+	flag.Parse()
+
+	var (
+		data []byte
+		err  error
+	)
+	switch flag.NArg() {
+	case 0:
+		data, err = io.ReadAll(os.Stdin)
+	case 1:
+		data, err = os.ReadFile(flag.Arg(0))
+	default:
+		log.Fatalf("Usage: psbt [flags] [file]")
+	}
+	if err != nil {
+		log.Fatalf("Error reading PSBT: %v", err)
+	}
+	p, err := core.DecodePSBT(data)
+	if err != nil {
+		if p, err = core.DecodePSBTString(string(data)); err != nil {
+			log.Fatalf("Error decoding PSBT: %v", err)
+		}
+	}
+
+	e, err := pg.NewExplorer(pg.Config{ConnectString: *connStr, Chain: *chain})
+	if err != nil {
+		log.Fatalf("Error connecting to db: %v", err)
+	}
+	defer e.Close()
+
+	r, err := e.CheckPSBT(p)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+
+	switch *format {
+	case "table":
+		fmt.Printf("txid %v, PSBT version %d, database height %d\n\n", r.TxId, r.Version, r.Height)
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintf(w, "input\tprevout\tvalue (BTC)\tconfirmations\tspent\tsignatures\t\n")
+		for _, in := range r.Inputs {
+			value, confs, spent := "?", "-", "no"
+			if in.Value != nil {
+				value = fmt.Sprintf("%.8f", float64(*in.Value)/1e8)
+			}
+			if in.Height != nil {
+				confs = fmt.Sprint(in.Confirmations)
+			} else if !in.Found {
+				confs = "not found"
+			}
+			if in.SpentBy != nil {
+				spent = in.SpentBy.String()
+			} else if in.Spent {
+				spent = "yes"
+			}
+			sigs := fmt.Sprint(in.Signatures)
+			if in.Finalized {
+				sigs = "final"
+			}
+			fmt.Fprintf(w, "%d\t%v:%d\t%s\t%s\t%s\t%s\t\n", in.N, in.PrevOutHash, in.PrevOutN, value, confs, spent, sigs)
+		}
+		fmt.Fprintf(w, "\t\t\t\t\t\t\n")
+		fmt.Fprintf(w, "output\tscriptpubkey\tvalue (BTC)\t\t\t\t\n")
+		for _, out := range r.Outputs {
+			fmt.Fprintf(w, "%d\t%s\t%.8f\t\t\t\t\n", out.N, out.ScriptPubKey, float64(out.Value)/1e8)
+		}
+		w.Flush()
+		if r.Fee != nil {
+			fmt.Printf("\nfee %d sat", *r.Fee)
+			if r.FeeRate != nil {
+				fmt.Printf(", at most %.2f sat/vB", *r.FeeRate)
+			}
+			fmt.Println()
+		} else {
+			fmt.Printf("\nfee unknown\n")
+		}
+		for _, pr := range r.Problems {
+			fmt.Printf("PROBLEM: %s\n", pr)
+		}
+	case "json":
+		if err := json.NewEncoder(os.Stdout).Encode(r); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+	default:
+		log.Fatalf("Unknown format: %s", *format)
+	}
+
+	if len(r.Problems) > 0 {
+		os.Exit(1)
+	}
+}
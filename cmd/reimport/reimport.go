@@ -0,0 +1,106 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/blkchain/blkchain/core"
+	"github.com/blkchain/blkchain/coredb"
+	"github.com/blkchain/blkchain/pg"
+)
+
+// Re-import a range of heights from the Core block files, e.g. after
+// finding corrupted rows. Core must not be running.
+
+func main() {
+
+	connStr := flag.String("connstr", "host=/var/run/postgresql dbname=blocks sslmode=disable", "Db connection string")
+	chain := flag.String("chain", "", "The chain in this schema, e.g. testnet4, as imported with -chain (default mainnet, in public)")
+	blocksPath := flag.String("blocks", "", "/path/to/blocks")
+	indexPath := flag.String("index", "", "/path/to/blocks/index (levelDb)")
+	dataDir := flag.String("datadir", "", "Core data directory, to find -blocks and -index in")
+	network := flag.String("network", "", "main, testnet3, testnet4, signet or regtest (default detected from -datadir, else main)")
+	testNet := flag.Bool("testnet", false, "Use testnet magic (same as -network testnet3)")
+	fromHeight := flag.Int("from-height", -1, "First height to re-import")
+	toHeight := flag.Int("to-height", -1, "Last height to re-import (-1 = same as -from-height)")
+	columns := flag.String("columns", "", fmt.Sprintf("Comma separated column plugins, as given to the import, available: %s", strings.Join(pg.ColumnPluginNames(), ", ")))
+	sslMode := flag.String("sslmode", "", "TLS mode, e.g. verify-full (default as in -connstr)")
+	sslCert := flag.String("sslcert", "", "TLS client certificate file")
+	sslKey := flag.String("sslkey", "", "TLS client key file")
+	sslRootCert := flag.String("sslrootcert", "", "TLS CA certificate file")
+	passwordFile := flag.String("password-file", "", "Read the db password from this file for every new connection")
+	awsIamRegion := flag.String("aws-iam-region", "", "Authenticate with RDS IAM tokens for this AWS region (credentials from the environment)")
+
+	flag.Parse()
+
+	if *testNet {
+		*network = coredb.NetworkTestNet3
+	}
+
+	var magic uint32 = core.MainNetMagic
+	if *dataDir != "" {
+		dd, err := coredb.FindDataDir(*dataDir, *network)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		if *blocksPath == "" {
+			*blocksPath = dd.Blocks
+		}
+		if *indexPath == "" {
+			*indexPath = dd.Index
+		}
+		magic = dd.Magic
+	} else if *network != "" {
+		var ok bool
+		if magic, ok = coredb.NetworkMagic(*network); !ok {
+			log.Fatalf("Unknown network %q", *network)
+		}
+	}
+
+	if *blocksPath == "" {
+		log.Fatalf("-blocks or -datadir required.")
+	}
+	if *fromHeight < 0 {
+		log.Fatalf("-from-height required.")
+	}
+	if *toHeight < 0 {
+		*toHeight = *fromHeight
+	}
+	if *toHeight < *fromHeight {
+		log.Fatalf("-to-height must not be less than -from-height.")
+	}
+	if *indexPath == "" {
+		*indexPath = filepath.Join(*blocksPath, "index")
+	}
+
+	log.Printf("Reading block headers from LevelDb (%s)...", *indexPath)
+	bhs, err := coredb.ReadLevelDbBlockHeaderIndex(*indexPath, *blocksPath, magic, *fromHeight)
+	if err != nil {
+		log.Fatalf("Error reading block headers: %v", err)
+	}
+	defer bhs.Close()
+
+	start := time.Now()
+	cfg := pg.WriterConfig{
+		ConnectString: *connStr,
+		Chain:         *chain,
+		SSLMode:       *sslMode,
+		SSLCert:       *sslCert,
+		SSLKey:        *sslKey,
+		SSLRootCert:   *sslRootCert,
+		PasswordFile:  *passwordFile,
+		AwsIamRegion:  *awsIamRegion,
+	}
+	if *columns != "" {
+		cfg.Columns = strings.Split(*columns, ",")
+	}
+	n, err := pg.ReimportRange(cfg, bhs, *fromHeight, *toHeight)
+	if err != nil {
+		log.Fatalf("Re-import failed after %d blocks: %v", n, err)
+	}
+	log.Printf("Re-imported %d blocks in %s.", n, time.Now().Sub(start).Round(time.Millisecond))
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+
+	"github.com/blkchain/blkchain/pg"
+)
+
+// Delete the txs of the blocks older than a sliding window, archiving
+// them first, see pg/retention.go. This can run while the import is
+// following the chain. With -forecast, only print how much the chain
+// tables grow in a year and how big they would be with the window.
+
+func main() {
+
+	connStr := flag.String("connstr", "host=/var/run/postgresql dbname=blocks sslmode=disable", "Db connection string")
+	chain := flag.String("chain", "", "The chain in this schema, e.g. testnet4, as imported with -chain (default mainnet, in public)")
+	keep := flag.Int("keep", 0, fmt.Sprintf("Keep the txs of this many blocks below the tip (minimum %d)", pg.MinRetainBlocks))
+	archive := flag.String("archive", "", "Archive the blocks as CSV to this directory or s3:// or gs:// prefix before deleting them")
+	forecast := flag.Bool("forecast", false, "Only print the yearly growth of the tables (and the size with -keep)")
+
+	flag.Parse()
+
+	connstr, err := pg.ChainConnStr(*connStr, *chain)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+
+	if *forecast {
+		printForecast(connstr, *keep)
+		return
+	}
+	if *keep < pg.MinRetainBlocks {
+		log.Fatalf("-keep must be at least %d", pg.MinRetainBlocks)
+	}
+	if err := pg.Retain(connstr, *keep, *archive); err != nil {
+		log.Fatalf("Error applying retention: %v", err)
+	}
+	log.Printf("All done, run VACUUM to make the space reusable.")
+}
+
+func printForecast(connStr string, keep int) {
+	fs, err := pg.StorageForecast(connStr)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "table\tsize MB\trows\trows/year\tMB/year\t")
+	var size, growth float64
+	for _, f := range fs {
+		fmt.Fprintf(w, "%s\t%.0f\t%d\t%d\t%.0f\t\n", f.Table, float64(f.Bytes)/1e6, f.Rows, f.PerYear, f.Growth/1e6)
+		size, growth = size+float64(f.Bytes), growth+f.Growth
+	}
+	fmt.Fprintf(w, "total\t%.0f\t\t\t%.0f\t\n", size/1e6, growth/1e6)
+	w.Flush()
+	if keep > 0 {
+		// Not counting the unspent outputs older than the window.
+		fmt.Printf("With -keep %d: about %.0f MB plus the older unspent outputs.\n",
+			keep, growth*float64(keep)/float64(pg.BlocksPerYear)/1e6)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/blkchain/blkchain/pg"
+)
+
+// Print the top balances by address or entity at any height, see
+// pg/richlist.go.
+
+func main() {
+
+	connStr := flag.String("connstr", "host=/var/run/postgresql dbname=blocks sslmode=disable", "Db connection string")
+	chain := flag.String("chain", "", "The chain in this schema, e.g. testnet4, as imported with -chain (default mainnet, in public)")
+	height := flag.Int("height", -1, "Height (default the tip, other heights require address_events)")
+	top := flag.Int("top", 100, "Number of entries")
+	by := flag.String("by", pg.RichListByAddress, "Rank by address or entity (address_labels)")
+	format := flag.String("format", "table", "Output format: table, csv or json")
+
+	flag.Parse()
+
+	connstr, err := pg.ChainConnStr(*connStr, *chain)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+
+	list, err := pg.RichList(connstr, *height, *top, *by)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+
+	name := func(e *pg.RichListEntry) string {
+		if *by == pg.RichListByEntity {
+			return e.Entity
+		}
+		return e.Address
+	}
+
+	switch *format {
+	case "table":
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', tabwriter.AlignRight)
+		fmt.Fprintf(w, "rank\t%s\tbalance (BTC)\t\n", *by)
+		for _, e := range list {
+			fmt.Fprintf(w, "%d\t%s\t%.8f\t\n", e.Rank, name(e), float64(e.Balance)/1e8)
+		}
+		w.Flush()
+	case "csv":
+		w := csv.NewWriter(os.Stdout)
+		header := []string{"rank", *by, "balance"}
+		if *by == pg.RichListByEntity {
+			header = append(header, "addresses")
+		}
+		w.Write(header)
+		for _, e := range list {
+			rec := []string{strconv.Itoa(e.Rank), name(e), strconv.FormatInt(e.Balance, 10)}
+			if *by == pg.RichListByEntity {
+				rec = append(rec, strconv.Itoa(e.Addresses))
+			}
+			w.Write(rec)
+		}
+		w.Flush()
+		if err := w.Error(); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(list); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+	default:
+		log.Fatalf("Unknown format: %q", *format)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/csv"
+	"flag"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/blkchain/blkchain/pg"
+)
+
+// Count the outputs per script type and period into the
+// script_type_stats table, and optionally write them as CSV, one row
+// per period and a column per script type, see pg/scripttypes.go.
+
+func main() {
+
+	connStr := flag.String("connstr", "host=/var/run/postgresql dbname=blocks sslmode=disable", "Db connection string")
+	chain := flag.String("chain", "", "The chain in this schema, e.g. testnet4, as imported with -chain (default mainnet, in public)")
+	period := flag.String("period", "month", "Period: day, week, month or year")
+	sinceHeight := flag.Int("since-height", 0, "Only recompute the periods from the one of this height on (0 = everything)")
+	noUpdate := flag.Bool("no-update", false, "Do not recompute, only write what is in the table")
+	csvPath := flag.String("csv", "", "Write the statistics as CSV to this file (- for stdout)")
+	share := flag.Bool("share", false, "In the CSV, the share of the outputs of the period rather than the count")
+
+	flag.Parse()
+
+	connstr, err := pg.ChainConnStr(*connStr, *chain)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+
+	if !*noUpdate {
+		if err := pg.UpdateScriptTypeStats(connstr, *period, *sinceHeight); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+	}
+	if *csvPath == "" {
+		return
+	}
+
+	stats, err := pg.ScriptTypeStats(connstr, *period)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+
+	f := os.Stdout
+	if *csvPath != "-" {
+		if f, err = os.Create(*csvPath); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+	}
+	col := make(map[string]int, len(pg.ScriptTypes))
+	for i, t := range pg.ScriptTypes {
+		col[t] = i + 2
+	}
+	w := csv.NewWriter(f)
+	w.Write(append([]string{"start", "outputs"}, pg.ScriptTypes...))
+	var rec []string
+	var total int64
+	flush := func() {
+		if rec != nil {
+			rec[1] = strconv.FormatInt(total, 10)
+			w.Write(rec)
+		}
+	}
+	for i, s := range stats {
+		if i == 0 || !s.Start.Equal(stats[i-1].Start) {
+			flush()
+			rec, total = make([]string, len(pg.ScriptTypes)+2), 0
+			rec[0] = s.Start.Format("2006-01-02")
+			for j := 2; j < len(rec); j++ {
+				rec[j] = "0"
+			}
+		}
+		total += s.Outputs
+		if *share {
+			rec[col[s.ScriptType]] = strconv.FormatFloat(s.Share, 'f', 6, 64)
+		} else {
+			rec[col[s.ScriptType]] = strconv.FormatInt(s.Outputs, 10)
+		}
+	}
+	flush()
+	w.Flush()
+	if err := w.Error(); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	"github.com/blkchain/blkchain/pg"
+)
+
+// Report database size by table and index, and project the size at
+// the chain tip based on the size of the Core block files.
+
+func main() {
+
+	connStr := flag.String("connstr", "host=/var/run/postgresql dbname=blocks sslmode=disable", "Db connection string")
+	chain := flag.String("chain", "", "The chain in this schema, e.g. testnet4, as imported with -chain (default mainnet, in public)")
+	blocksPath := flag.String("blocks", "", "/path/to/blocks (optional, for the projected size)")
+
+	flag.Parse()
+
+	connstr, err := pg.ChainConnStr(*connStr, *chain)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+
+	stats, err := pg.DatabaseStats(connstr)
+	if err != nil {
+		log.Fatalf("Error getting stats: %v", err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(w, "table\tindex\trows\ttable size\tindex size\tbloat\t\n")
+	for _, t := range stats.Tables {
+		fmt.Fprintf(w, "%s\t\t%d\t%s\t%s\t%.1f%%\t\n",
+			t.Name, t.Rows, size(t.TableBytes), size(t.IndexBytes), t.Bloat()*100)
+		for _, idx := range t.Indexes {
+			fmt.Fprintf(w, "\t%s\t\t\t%s\t\t\n", idx.Name, size(idx.Bytes))
+		}
+	}
+	w.Flush()
+
+	fmt.Printf("\nDatabase total: %s, height: %d, blocks imported: %s\n",
+		size(stats.TotalBytes), stats.Height, size(stats.ChainBytes))
+
+	if *blocksPath != "" {
+		chainBytes, err := blockFilesSize(*blocksPath)
+		if err != nil {
+			log.Fatalf("Error reading block files: %v", err)
+		}
+		fmt.Printf("Block files: %s, projected database size at the tip: %s\n",
+			size(chainBytes), size(stats.ProjectedBytes(chainBytes)))
+	}
+}
+
+func blockFilesSize(path string) (int64, error) {
+	files, err := filepath.Glob(filepath.Join(path, "blk*.dat"))
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, f := range files {
+		fi, err := os.Stat(f)
+		if err != nil {
+			return 0, err
+		}
+		total += fi.Size()
+	}
+	return total, nil
+}
+
+func size(b int64) string {
+	const unit = 1024
+	if b < unit {
+		return fmt.Sprintf("%d B", b)
+	}
+	div, exp := int64(unit), 0
+	for n := b / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(b)/float64(div), "KMGTPE"[exp])
+}
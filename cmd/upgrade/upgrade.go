@@ -0,0 +1,54 @@
+package main
+
+import (
+	"flag"
+	"log"
+
+	"github.com/blkchain/blkchain/pg"
+)
+
+// Upgrade a database created by an older version of the import in
+// place. The import refuses to catch up until this is done.
+
+func main() {
+
+	connStr := flag.String("connstr", "host=/var/run/postgresql dbname=blocks sslmode=disable", "Db connection string")
+	chain := flag.String("chain", "", "The chain in this schema, e.g. testnet4, as imported with -chain (default mainnet, in public)")
+	dryRun := flag.Bool("dry-run", false, "Only list the needed upgrades")
+	addressHistory := flag.Bool("address-history", false, "Also create the address history indexes (slow)")
+	txidPrefixIndex := flag.Bool("txid-prefix-index", false, "Also create the txid prefix search index (slow)")
+	inputValues := flag.Bool("input-values", false, "Also add and fill in txins.input_value (slow, rewrites txins)")
+	scriptPayloads := flag.Bool("script-payloads", false, "Also add, fill in and index txouts.script_payload (slow, rewrites txouts)")
+
+	flag.Parse()
+
+	connstr, err := pg.ChainConnStr(*connStr, *chain)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+
+	if err := pg.UpgradeDatabase(connstr, *dryRun); err != nil {
+		log.Fatalf("Upgrade failed: %v", err)
+	}
+	if *addressHistory && !*dryRun {
+		if err := pg.CreateAddressHistoryIndexes(connstr); err != nil {
+			log.Fatalf("Error creating address history indexes: %v", err)
+		}
+	}
+	if *txidPrefixIndex && !*dryRun {
+		if err := pg.CreateTxidPrefixIndex(connstr); err != nil {
+			log.Fatalf("Error creating the txid prefix index: %v", err)
+		}
+	}
+	if *inputValues && !*dryRun {
+		if err := pg.AddInputValues(connstr); err != nil {
+			log.Fatalf("Error adding input values: %v", err)
+		}
+	}
+	if *scriptPayloads && !*dryRun {
+		if err := pg.AddScriptPayloads(connstr); err != nil {
+			log.Fatalf("Error adding script payloads: %v", err)
+		}
+	}
+	log.Printf("All done.")
+}
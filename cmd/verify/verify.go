@@ -0,0 +1,82 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"time"
+
+	"github.com/blkchain/blkchain/pg"
+)
+
+// Logical validation of the database, meant to be run periodically on
+// databases imported with -no-constraints. Exits with status 1 if any
+// check fails.
+
+func main() {
+
+	connStr := flag.String("connstr", "host=/var/run/postgresql dbname=blocks sslmode=disable", "Db connection string")
+	chain := flag.String("chain", "", "The chain in this schema, e.g. testnet4, as imported with -chain (default mainnet, in public)")
+	sinceHeight := flag.Int("since-height", 0, "Only check data at or above this height (0 = everything)")
+	scripts := flag.Int("scripts", 0, "Also validate the scripts of this many randomly sampled inputs (0 = none)")
+	assumeValid := flag.String("assume-valid", "", "Do not validate the scripts of the inputs of the blocks up to this block hash (0 = none)")
+
+	flag.Parse()
+
+	connstr, err := pg.ChainConnStr(*connStr, *chain)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+
+	results, err := pg.VerifyDatabase(connstr, *sinceHeight)
+	if err != nil {
+		log.Fatalf("Verify failed: %v", err)
+	}
+
+	failed, total := 0, len(results)
+	for _, r := range results {
+		status := "OK"
+		if r.Count > 0 {
+			status = "FAILED"
+			failed++
+		}
+		log.Printf("%-6s %s: %d (%s)", status, r.Desc, r.Count, r.Duration.Round(time.Millisecond))
+	}
+
+	hr, err := pg.VerifyHeaders(connstr, *sinceHeight)
+	if err != nil {
+		log.Fatalf("Header verification failed: %v", err)
+	}
+	status := "OK"
+	if hr.Count > 0 {
+		status = "FAILED"
+		failed++
+	}
+	log.Printf("%-6s %s: %d (%s)", status, hr.Desc, hr.Count, hr.Duration.Round(time.Millisecond))
+	total++
+
+	if *scripts > 0 {
+		log.Printf("Validating the scripts of %d sampled inputs...", *scripts)
+		sr, err := pg.VerifyScripts(connstr, *sinceHeight, *scripts, *assumeValid)
+		if err != nil {
+			log.Fatalf("Script validation failed: %v", err)
+		}
+		for _, f := range sr.Failed {
+			log.Printf("FAILED %s", f)
+		}
+		status := "OK"
+		if len(sr.Failed) > 0 {
+			status = "FAILED"
+			failed++
+		}
+		log.Printf("%-6s inputs failing script validation: %d of %d, %d skipped, %d assumed valid (%s)", status,
+			len(sr.Failed), sr.Checked+len(sr.Failed), sr.Skipped, sr.Assumed, sr.Duration.Round(time.Millisecond))
+		total++
+	}
+
+	if failed > 0 {
+		log.Printf("%d of %d checks failed.", failed, total)
+		os.Exit(1)
+	}
+	log.Printf("All %d checks passed.", total)
+}
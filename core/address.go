@@ -0,0 +1,191 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Addresses of the standard scriptPubKeys: base58check for P2PKH and
+// P2SH (the version byte followed by the hash), segwit addresses for
+// the witness programs, bech32 for v0 (P2WPKH, P2WSH) and bech32m for
+// v1 and above (P2TR). The hash of a P2PK output is given as a P2PKH
+// address, like extract_address() in the database does.
+
+type AddressParams struct {
+	PubKeyHash byte   // base58check version of P2PKH addresses
+	ScriptHash byte   // and of P2SH addresses
+	HRP        string // of segwit addresses
+}
+
+var (
+	MainNetAddressParams = &AddressParams{PubKeyHash: 0x00, ScriptHash: 0x05, HRP: "bc"}
+	TestNetAddressParams = &AddressParams{PubKeyHash: 0x6f, ScriptHash: 0xc4, HRP: "tb"} // also testnet4 and signet
+	RegTestAddressParams = &AddressParams{PubKeyHash: 0x6f, ScriptHash: 0xc4, HRP: "bcrt"}
+)
+
+// AddressParamsFor returns the address params of the network of the
+// magic, nil if it is not known.
+func AddressParamsFor(magic uint32) *AddressParams {
+	switch magic {
+	case MainNetMagic:
+		return MainNetAddressParams
+	case TestNetMagic, TestNet4Magic, SigNetMagic:
+		return TestNetAddressParams
+	case RegTestMagic:
+		return RegTestAddressParams
+	}
+	return nil
+}
+
+// EncodeSegwitAddress encodes a witness program, with bech32 or
+// bech32m depending on the version.
+func EncodeSegwitAddress(hrp string, version byte, program []byte) (string, error) {
+	if err := checkWitnessProgram(version, program); err != nil {
+		return "", err
+	}
+	data, err := ConvertBits(program, 8, 5, true)
+	if err != nil {
+		return "", err
+	}
+	enc := Bech32m
+	if version == 0 {
+		enc = Bech32
+	}
+	return Bech32Encode(hrp, append([]byte{version}, data...), enc)
+}
+
+// DecodeSegwitAddress returns the hrp, witness version and program of
+// a segwit address, an error if the encoding is not the one for the
+// version.
+func DecodeSegwitAddress(address string) (string, byte, []byte, error) {
+	hrp, data, enc, err := Bech32Decode(address)
+	if err != nil {
+		return "", 0, nil, err
+	}
+	if len(data) == 0 {
+		return "", 0, nil, fmt.Errorf("Invalid segwit address, no witness version.")
+	}
+	version := data[0]
+	switch {
+	case version > 16:
+		return "", 0, nil, fmt.Errorf("Invalid segwit address, witness version %d.", version)
+	case version == 0 && enc != Bech32:
+		return "", 0, nil, fmt.Errorf("Segwit v0 address encoded with bech32m, it must be bech32 (BIP173).")
+	case version > 0 && enc != Bech32m:
+		return "", 0, nil, fmt.Errorf("Segwit v%d address encoded with bech32, it must be bech32m (BIP350).", version)
+	}
+	program, err := ConvertBits(data[1:], 5, 8, false)
+	if err != nil {
+		return "", 0, nil, fmt.Errorf("Invalid segwit address: %v", err)
+	}
+	if err := checkWitnessProgram(version, program); err != nil {
+		return "", 0, nil, err
+	}
+	return hrp, version, program, nil
+}
+
+func checkWitnessProgram(version byte, program []byte) error {
+	switch {
+	case version > 16:
+		return fmt.Errorf("Invalid witness version %d.", version)
+	case len(program) < 2 || len(program) > 40:
+		return fmt.Errorf("Invalid witness program length %d, must be 2 to 40 bytes.", len(program))
+	case version == 0 && len(program) != 20 && len(program) != 32:
+		return fmt.Errorf("Invalid v0 witness program length %d, must be 20 or 32 bytes.", len(program))
+	}
+	return nil
+}
+
+// ScriptAddress returns the address of a scriptPubKey, "" if it does
+// not have one (multisig, OP_RETURN, non-standard).
+func ScriptAddress(script []byte, p *AddressParams) string {
+	n := len(script)
+	switch {
+	case n == 25 && script[0] == 0x76 && script[1] == 0xa9 && script[2] == 20 && script[23] == 0x88 && script[24] == 0xac:
+		return Base58CheckEncode(append([]byte{p.PubKeyHash}, script[3:23]...))
+	case n == 23 && script[0] == 0xa9 && script[1] == 20 && script[22] == 0x87:
+		return Base58CheckEncode(append([]byte{p.ScriptHash}, script[2:22]...))
+	case (n == 35 || n == 67) && int(script[0]) == n-2 && script[n-1] == 0xac:
+		if (n == 35 && (script[1] == 2 || script[1] == 3)) || (n == 67 && script[1] == 4) {
+			return Base58CheckEncode(append([]byte{p.PubKeyHash}, Hash160(script[1:n-1])...))
+		}
+	case n >= 4 && n <= 42 && int(script[1]) == n-2 && (script[0] == OP_0 || script[0] >= OP_1 && script[0] <= OP_16):
+		version := script[0]
+		if version != OP_0 {
+			version -= OP_1 - 1
+		}
+		if addr, err := EncodeSegwitAddress(p.HRP, version, script[2:]); err == nil {
+			return addr
+		}
+	}
+	return ""
+}
+
+// ScriptPayload returns what identifies the owner of a scriptPubKey,
+// nil if nothing does: the hash of P2PKH and P2SH, the witness program
+// (the 20 or 32 byte hash of P2WPKH and P2WSH, the key of P2TR) and the
+// public key of P2PK. The types are those of script_type() in the
+// database, so P2A, whoever can spend it, has none. The result is a
+// slice of the script.
+func ScriptPayload(script []byte) []byte {
+	n := len(script)
+	switch {
+	case n == 25 && script[0] == 0x76 && script[1] == 0xa9 && script[2] == 20 && script[23] == 0x88 && script[24] == 0xac:
+		return script[3:23]
+	case n == 23 && script[0] == 0xa9 && script[1] == 20 && script[22] == 0x87:
+		return script[2:22]
+	case (n == 22 || n == 34) && script[0] == OP_0 && int(script[1]) == n-2:
+		return script[2:]
+	case n == 4 && script[0] == OP_1 && script[1] == 2 && script[2] == 0x4e && script[3] == 0x73: // P2A
+		return nil
+	case n >= 4 && n <= 42 && script[0] >= OP_1 && script[0] <= OP_16 && int(script[1]) == n-2:
+		return script[2:]
+	case (n == 35 || n == 67) && int(script[0]) == n-2 && script[n-1] == 0xac:
+		return script[1 : n-1]
+	}
+	return nil
+}
+
+// IsSegwitAddress tells whether the address looks like a segwit one,
+// i.e. begins with a known hrp and the separator, rather than base58.
+func IsSegwitAddress(address string) bool {
+	lower := strings.ToLower(address)
+	for _, p := range []*AddressParams{MainNetAddressParams, TestNetAddressParams, RegTestAddressParams} {
+		if strings.HasPrefix(lower, p.HRP+"1") {
+			return true
+		}
+	}
+	return false
+}
+
+// AddressScript returns the scriptPubKey of an address of the network
+// of the params.
+func AddressScript(address string, p *AddressParams) ([]byte, error) {
+	if IsSegwitAddress(address) {
+		hrp, version, program, err := DecodeSegwitAddress(address)
+		if err != nil {
+			return nil, err
+		}
+		if hrp != p.HRP {
+			return nil, fmt.Errorf("Wrong network, the address prefix is %q rather than %q.", hrp, p.HRP)
+		}
+		if version > 0 {
+			version += OP_1 - 1
+		}
+		return append([]byte{version, byte(len(program))}, program...), nil
+	}
+	data, err := Base58CheckDecode(address)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) != 21 {
+		return nil, fmt.Errorf("Invalid address length, %d bytes rather than 21.", len(data))
+	}
+	switch data[0] {
+	case p.PubKeyHash:
+		return append(append([]byte{0x76, 0xa9, 20}, data[1:]...), 0x88, 0xac), nil
+	case p.ScriptHash:
+		return append(append([]byte{0xa9, 20}, data[1:]...), 0x87), nil
+	}
+	return nil, fmt.Errorf("Unknown address version %d.", data[0])
+}
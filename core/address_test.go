@@ -0,0 +1,119 @@
+package core_test
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/blkchain/blkchain/core"
+)
+
+// The segwit addresses of BIP350 (which include those of BIP173 still
+// valid), and the base58check addresses of the other scriptPubKeys.
+
+func addressParams(address string) *core.AddressParams {
+	if strings.HasPrefix(strings.ToLower(address), "tb1") {
+		return core.TestNetAddressParams
+	}
+	return core.MainNetAddressParams
+}
+
+func TestSegwitAddressValid(t *testing.T) {
+	for _, test := range []struct {
+		address, script string
+	}{
+		{"BC1QW508D6QEJXTDG4Y5R3ZARVARY0C5XW7KV8F3T4", "0014751e76e8199196d454941c45d1b3a323f1433bd6"},
+		{"tb1qrp33g0q5c5txsp9arysrx4k6zdkfs4nce4xj0gdcccefvpysxf3q0sl5k7", "00201863143c14c5166804bd19203356da136c985678cd4d27a1b8c6329604903262"},
+		{"bc1pw508d6qejxtdg4y5r3zarvary0c5xw7kw508d6qejxtdg4y5r3zarvary0c5xw7kt5nd6y", "5128751e76e8199196d454941c45d1b3a323f1433bd6751e76e8199196d454941c45d1b3a323f1433bd6"},
+		{"BC1SW50QGDZ25J", "6002751e"},
+		{"bc1zw508d6qejxtdg4y5r3zarvaryvaxxpcs", "5210751e76e8199196d454941c45d1b3a323"},
+		{"tb1qqqqqp399et2xygdj5xreqhjjvcmzhxw4aywxecjdzew6hylgvsesrxh6hy", "0020000000c4a5cad46221b2a187905e5266362b99d5e91c6ce24d165dab93e86433"},
+		{"tb1pqqqqp399et2xygdj5xreqhjjvcmzhxw4aywxecjdzew6hylgvsesf3hn0c", "5120000000c4a5cad46221b2a187905e5266362b99d5e91c6ce24d165dab93e86433"},
+		{"bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqzk5jj0", "512079be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798"},
+	} {
+		p := addressParams(test.address)
+		script, err := core.AddressScript(test.address, p)
+		if err != nil {
+			t.Errorf("%s: %v", test.address, err)
+			continue
+		}
+		if got := hex.EncodeToString(script); got != test.script {
+			t.Errorf("Script of %s: %s, expected %s", test.address, got, test.script)
+		}
+		if got := core.ScriptAddress(script, p); got != strings.ToLower(test.address) {
+			t.Errorf("Address of %s: %s, expected %s", test.script, got, strings.ToLower(test.address))
+		}
+	}
+}
+
+func TestSegwitAddressInvalid(t *testing.T) {
+	for _, address := range []string{
+		"tc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vq5zuyut", // invalid hrp
+		"bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqh2y7hd", // bech32 for v1
+		"tb1z0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqglt7rf", // bech32 for v2
+		"BC1S0XLXVLHEMJA6C4DQV22UAPCTQUPFHLXM9H8Z3K2E72Q4K9HCZ7VQ54WELL", // bech32 for v16
+		"bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kemeawh",                     // bech32m for v0
+		"tb1q0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vq24jc47", // bech32m for v0
+		"bc1p38j9r5y49hruaue7wxjce0updqjuyyx0kh56v8s25huc6995vvpql3jow4", // invalid character
+		"BC130XLXVLHEMJA6C4DQV22UAPCTQUPFHLXM9H8Z3K2E72Q4K9HCZ7VQ7ZWS8R", // version 17
+		"bc1pw5dgrnzv", // 1 byte program
+		"bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7v8n0nx0muaewav253zgeav", // 41 byte program
+		"BC1QR508D6QEJXTDG4Y5R3ZARVARYV98GJ9P",                                         // 16 byte v0 program
+		"tb1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vq47Zagq",               // mixed case
+		"bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7v07qwwzcrf",             // more than 4 bits of padding
+		"tb1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vpggkg4j",               // non-zero padding
+		"bc1gmk9yu", // empty data
+	} {
+		if _, err := core.AddressScript(address, addressParams(address)); err == nil {
+			t.Errorf("%s decoded", address)
+		}
+	}
+	// The network is checked.
+	if _, err := core.AddressScript("BC1QW508D6QEJXTDG4Y5R3ZARVARY0C5XW7KV8F3T4", core.TestNetAddressParams); err == nil {
+		t.Errorf("Mainnet address decoded for testnet")
+	}
+}
+
+func TestBase58Address(t *testing.T) {
+	for _, test := range []struct {
+		address, script string
+		p               *core.AddressParams
+	}{
+		// The genesis coinbase, P2PK given as P2PKH.
+		{"1A1zP1eP5QGefi2DMPTfTL5SLmv7DivfNa", "76a91462e907b15cbf27d5425399ebf6f0fb50ebb88f1888ac", core.MainNetAddressParams},
+		{"3P14159f73E4gFr7JterCCQh9QjiTjiZrG", "", core.MainNetAddressParams},
+		{"mipcBbFg9gMiCh81Kj8tqqdgoZub1ZJRfn", "", core.TestNetAddressParams},
+	} {
+		script, err := core.AddressScript(test.address, test.p)
+		if err != nil {
+			t.Errorf("%s: %v", test.address, err)
+			continue
+		}
+		if got := hex.EncodeToString(script); test.script != "" && got != test.script {
+			t.Errorf("Script of %s: %s, expected %s", test.address, got, test.script)
+		}
+		if got := core.ScriptAddress(script, test.p); got != test.address {
+			t.Errorf("Address of %x: %s, expected %s", script, got, test.address)
+		}
+	}
+
+	genesisKey, _ := hex.DecodeString("04678afdb0fe5548271967f1a67130b7105cd6a828e03909a67962e0ea1f61deb649f6bc3f4cef38c4f35504e51ec112de5c384df7ba0b8d578a4c702b6bf11d5f")
+	p2pk := append(append([]byte{65}, genesisKey...), core.OP_CHECKSIG)
+	if got := core.ScriptAddress(p2pk, core.MainNetAddressParams); got != "1A1zP1eP5QGefi2DMPTfTL5SLmv7DivfNa" {
+		t.Errorf("Address of the genesis P2PK: %s", got)
+	}
+
+	for _, address := range []string{
+		"1A1zP1eP5QGefi2DMPTfTL5SLmv7DivfNb", // checksum
+		"1A1zP1eP5QGefi2DMPTfTL5SLmv7DivfN",  // length
+		"1A1zP1eP5QGefi2DMPTfTL5SLmv7DivfN0", // character
+	} {
+		if _, err := core.AddressScript(address, core.MainNetAddressParams); err == nil {
+			t.Errorf("%s decoded", address)
+		}
+	}
+	// The version is checked.
+	if _, err := core.AddressScript("1A1zP1eP5QGefi2DMPTfTL5SLmv7DivfNa", core.TestNetAddressParams); err == nil {
+		t.Errorf("Mainnet address decoded for testnet")
+	}
+}
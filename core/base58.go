@@ -0,0 +1,120 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// Base58 and base58check, the encoding of the legacy (P2PKH and P2SH)
+// addresses, WIF private keys and extended keys. Base58check is the
+// base58 of the data (version byte(s) first) followed by the first 4
+// bytes of its double SHA256. Leading zero bytes are encoded as '1's,
+// one each, the rest as a big endian base 58 number.
+
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+var base58Digits [256]int8 // -1 if not in the alphabet
+
+func init() {
+	for i := range base58Digits {
+		base58Digits[i] = -1
+	}
+	for i := 0; i < len(base58Alphabet); i++ {
+		base58Digits[base58Alphabet[i]] = int8(i)
+	}
+}
+
+var ErrBase58Checksum = errors.New("Bad base58check checksum")
+
+// A Base58CharError is a character not in the base58 alphabet (which
+// has no 0, O, I or l) at Pos, starting at 0.
+type Base58CharError struct {
+	Char byte
+	Pos  int
+}
+
+func (e *Base58CharError) Error() string {
+	return fmt.Sprintf("Invalid base58 character %q at position %d", e.Char, e.Pos)
+}
+
+func Base58Encode(b []byte) string {
+	zeros := 0
+	for zeros < len(b) && b[zeros] == 0 {
+		zeros++
+	}
+	// Base 58 digits, least significant first.
+	digits := make([]byte, 0, (len(b)-zeros)*138/100+1)
+	for _, c := range b[zeros:] {
+		carry := int(c)
+		for i := range digits {
+			carry += int(digits[i]) << 8
+			digits[i] = byte(carry % 58)
+			carry /= 58
+		}
+		for ; carry > 0; carry /= 58 {
+			digits = append(digits, byte(carry%58))
+		}
+	}
+	result := make([]byte, zeros+len(digits))
+	for i := 0; i < zeros; i++ {
+		result[i] = '1'
+	}
+	for i, d := range digits {
+		result[len(result)-1-i] = base58Alphabet[d]
+	}
+	return string(result)
+}
+
+func Base58Decode(s string) ([]byte, error) {
+	zeros := 0
+	for zeros < len(s) && s[zeros] == '1' {
+		zeros++
+	}
+	// Bytes, least significant first.
+	var b []byte
+	for i := zeros; i < len(s); i++ {
+		d := base58Digits[s[i]]
+		if d < 0 {
+			return nil, &Base58CharError{Char: s[i], Pos: i}
+		}
+		carry := int(d)
+		for j := range b {
+			carry += int(b[j]) * 58
+			b[j] = byte(carry)
+			carry >>= 8
+		}
+		for ; carry > 0; carry >>= 8 {
+			b = append(b, byte(carry))
+		}
+	}
+	result := make([]byte, zeros+len(b))
+	for i, c := range b {
+		result[len(result)-1-i] = c
+	}
+	return result, nil
+}
+
+// Base58CheckEncode encodes data, which begins with the version, with
+// its checksum.
+func Base58CheckEncode(data []byte) string {
+	sum := ShaSha256(data)
+	return Base58Encode(append(append([]byte(nil), data...), sum[:4]...))
+}
+
+// Base58CheckDecode returns the data (version first) without the
+// checksum, ErrBase58Checksum if the checksum does not match.
+func Base58CheckDecode(s string) ([]byte, error) {
+	b, err := Base58Decode(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) < 5 {
+		return nil, fmt.Errorf("Base58check string too short: %d bytes", len(b))
+	}
+	data := b[:len(b)-4]
+	if sum := ShaSha256(data); !bytes.Equal(sum[:4], b[len(b)-4:]) {
+		return nil, ErrBase58Checksum
+	}
+	return data, nil
+}
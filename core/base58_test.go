@@ -0,0 +1,84 @@
+package core_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/blkchain/blkchain/core"
+)
+
+// The base58 vectors of Core (base58_encode_decode.json).
+func TestBase58(t *testing.T) {
+	for _, test := range []struct {
+		hex, base58 string
+	}{
+		{"", ""},
+		{"61", "2g"},
+		{"626262", "a3gV"},
+		{"636363", "aPEr"},
+		{"73696d706c792061206c6f6e6720737472696e67", "2cFupjhnEsSn59qHXstmK2ffpLv2"},
+		{"00eb15231dfceb60925886b67d065299925915aeb172c06647", "1NS17iag9jJgTHD1VXjvLCEnZuQ3rJDE9L"},
+		{"516b6fcd0f", "ABnLTmg"},
+		{"bf4f89001e670274dd", "3SEo3LWLoPntC"},
+		{"572e4794", "3EFU7m"},
+		{"ecac89cad93923c02321", "EJDM8drfXA6uyA"},
+		{"10c8511e", "Rt5zm"},
+		{"00000000000000000000", "1111111111"},
+		{"000111d38e5fc9071ffcd20b4a763cc9ae4f252bb4e48fd66a835e252ada93ff480d6dd43dc62a641155a5", "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"},
+	} {
+		b := decodeHex(t, test.hex)
+		if got := core.Base58Encode(b); got != test.base58 {
+			t.Errorf("Base58 of %s: %s, expected %s", test.hex, got, test.base58)
+		}
+		if got, err := core.Base58Decode(test.base58); err != nil || !bytes.Equal(got, b) {
+			t.Errorf("%s decoded: %x (%v), expected %s", test.base58, got, err, test.hex)
+		}
+	}
+
+	var charErr *core.Base58CharError
+	for _, s := range []string{"0", "O", "I", "l", "3mJr0", "O3yxU", "3sNI", "4kl8", "0OIl", "!@#$%^&*()-_=+~`"} {
+		if _, err := core.Base58Decode(s); !errors.As(err, &charErr) {
+			t.Errorf("%q: %v, expected a character error", s, err)
+		}
+	}
+}
+
+func TestBase58Check(t *testing.T) {
+	for _, data := range []string{
+		"00",
+		"0062e907b15cbf27d5425399ebf6f0fb50ebb88f18",
+		"05ffffffffffffffffffffffffffffffffffffffff",
+		"0488ade4000000000000000000873dff81c02f525623fd1fe5167eac3a55a049de3d314bb42ee227ffed37d508",
+	} {
+		b := decodeHex(t, data)
+		s := core.Base58CheckEncode(b)
+		got, err := core.Base58CheckDecode(s)
+		if err != nil || !bytes.Equal(got, b) {
+			t.Errorf("%s (%s) decoded: %x (%v)", data, s, got, err)
+		}
+
+		// Every byte of the checksum is checked.
+		raw, _ := core.Base58Decode(s)
+		for i := len(raw) - 4; i < len(raw); i++ {
+			bad := append([]byte(nil), raw...)
+			bad[i] ^= 1
+			if _, err := core.Base58CheckDecode(core.Base58Encode(bad)); err != core.ErrBase58Checksum {
+				t.Errorf("%s with byte %d changed: %v, expected %v", s, i, err, core.ErrBase58Checksum)
+			}
+		}
+		// And the data.
+		bad := append([]byte(nil), raw...)
+		bad[0] ^= 0x80
+		if _, err := core.Base58CheckDecode(core.Base58Encode(bad)); err != core.ErrBase58Checksum {
+			t.Errorf("%s with the data changed: %v, expected %v", s, err, core.ErrBase58Checksum)
+		}
+	}
+
+	if got := core.Base58CheckEncode(decodeHex(t, "0062e907b15cbf27d5425399ebf6f0fb50ebb88f18")); got != "1A1zP1eP5QGefi2DMPTfTL5SLmv7DivfNa" {
+		t.Errorf("Base58check of the genesis key hash: %s", got)
+	}
+	if _, err := core.Base58CheckDecode("1111"); err == nil || err == core.ErrBase58Checksum {
+		t.Errorf("4 bytes: %v, expected too short", err)
+	}
+}
@@ -0,0 +1,187 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Bech32 (BIP173) and bech32m (BIP350), the encodings of the segwit
+// addresses, v0 in bech32 and v1 (taproot) and above in bech32m. They
+// only differ by the constant the checksum is xored with. A bech32
+// string is the human readable part (hrp, "bc" on mainnet), the
+// separator '1' and the data, 5 bits per character, the last 6 of which
+// are the checksum.
+// https://github.com/bitcoin/bips/blob/master/bip-0173.mediawiki
+// https://github.com/bitcoin/bips/blob/master/bip-0350.mediawiki
+
+const bech32Charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+var bech32Values [256]int8 // -1 if not in the charset
+
+func init() {
+	for i := range bech32Values {
+		bech32Values[i] = -1
+	}
+	for i := 0; i < len(bech32Charset); i++ {
+		bech32Values[bech32Charset[i]] = int8(i)
+		bech32Values[strings.ToUpper(bech32Charset)[i]] = int8(i)
+	}
+}
+
+// The encoding, its value is the checksum constant.
+type Bech32Encoding uint32
+
+const (
+	Bech32  Bech32Encoding = 1
+	Bech32m Bech32Encoding = 0x2bc830a3
+)
+
+func (e Bech32Encoding) String() string {
+	switch e {
+	case Bech32:
+		return "bech32"
+	case Bech32m:
+		return "bech32m"
+	}
+	return fmt.Sprintf("Bech32Encoding(%#x)", uint32(e))
+}
+
+var ErrBech32Checksum = errors.New("Bad bech32 checksum")
+
+// A Bech32CharError is a character not in the bech32 charset (which
+// has no 1, b, i or o) at Pos, starting at 0.
+type Bech32CharError struct {
+	Char byte
+	Pos  int
+}
+
+func (e *Bech32CharError) Error() string {
+	return fmt.Sprintf("Invalid bech32 character %q at position %d", e.Char, e.Pos)
+}
+
+func bech32Polymod(values []byte) uint32 {
+	gen := [5]uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+	chk := uint32(1)
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i := 0; i < 5; i++ {
+			if (top>>i)&1 == 1 {
+				chk ^= gen[i]
+			}
+		}
+	}
+	return chk
+}
+
+// The hrp is part of the checksum, the high bits of each character,
+// a zero, then the low bits.
+func bech32HrpExpand(hrp string) []byte {
+	result := make([]byte, 0, len(hrp)*2+1)
+	for i := 0; i < len(hrp); i++ {
+		result = append(result, hrp[i]>>5)
+	}
+	result = append(result, 0)
+	for i := 0; i < len(hrp); i++ {
+		result = append(result, hrp[i]&31)
+	}
+	return result
+}
+
+// Bech32Encode encodes data, 5 bit values (see ConvertBits), with the
+// lower case hrp.
+func Bech32Encode(hrp string, data []byte, enc Bech32Encoding) (string, error) {
+	hrp = strings.ToLower(hrp)
+	if len(hrp) == 0 || len(hrp)+1+len(data)+6 > 90 {
+		return "", fmt.Errorf("Invalid bech32 length, hrp %d and data %d.", len(hrp), len(data))
+	}
+	values := append(bech32HrpExpand(hrp), data...)
+	mod := bech32Polymod(append(values, 0, 0, 0, 0, 0, 0)) ^ uint32(enc)
+
+	var sb strings.Builder
+	sb.WriteString(hrp)
+	sb.WriteByte('1')
+	for _, d := range data {
+		if d > 31 {
+			return "", fmt.Errorf("Invalid bech32 data value %d, more than 5 bits.", d)
+		}
+		sb.WriteByte(bech32Charset[d])
+	}
+	for i := 0; i < 6; i++ {
+		sb.WriteByte(bech32Charset[(mod>>(5*(5-i)))&31])
+	}
+	return sb.String(), nil
+}
+
+// Bech32Decode returns the lower case hrp, the data as 5 bit values
+// without the checksum and which of the encodings the checksum is for.
+// A checksum matching neither is ErrBech32Checksum.
+func Bech32Decode(s string) (string, []byte, Bech32Encoding, error) {
+	if len(s) > 90 {
+		return "", nil, 0, fmt.Errorf("Bech32 string too long: %d characters", len(s))
+	}
+	// Bytes, not runes, the characters outside of US-ASCII are
+	// invalid.
+	var lower, upper bool
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c < 33 || c > 126 {
+			return "", nil, 0, &Bech32CharError{Char: c, Pos: i}
+		}
+		lower = lower || c >= 'a' && c <= 'z'
+		upper = upper || c >= 'A' && c <= 'Z'
+	}
+	if lower && upper {
+		return "", nil, 0, fmt.Errorf("Mixed case bech32 string")
+	}
+	sep := strings.LastIndexByte(s, '1')
+	if sep < 1 || sep+7 > len(s) {
+		return "", nil, 0, fmt.Errorf("Invalid bech32 separator position")
+	}
+	hrp := strings.ToLower(s[:sep])
+	data := make([]byte, 0, len(s)-sep-1)
+	for i := sep + 1; i < len(s); i++ {
+		v := bech32Values[s[i]]
+		if v < 0 {
+			return "", nil, 0, &Bech32CharError{Char: s[i], Pos: i}
+		}
+		data = append(data, byte(v))
+	}
+	enc := Bech32Encoding(bech32Polymod(append(bech32HrpExpand(hrp), data...)))
+	if enc != Bech32 && enc != Bech32m {
+		return "", nil, 0, ErrBech32Checksum
+	}
+	return hrp, data[:len(data)-6], enc, nil
+}
+
+// ConvertBits regroups data from groups of fromBits into groups of
+// toBits, e.g. bytes into 5 bit values. When not padding (decoding),
+// the left over bits must be fewer than fromBits and zero.
+func ConvertBits(data []byte, fromBits, toBits uint, pad bool) ([]byte, error) {
+	var (
+		acc    uint32
+		bits   uint
+		result = make([]byte, 0, len(data)*int(fromBits)/int(toBits)+1)
+		maxv   = uint32(1)<<toBits - 1
+	)
+	for _, d := range data {
+		if d>>fromBits != 0 {
+			return nil, fmt.Errorf("Invalid value %d, more than %d bits.", d, fromBits)
+		}
+		acc = acc<<fromBits | uint32(d)
+		bits += fromBits
+		for bits >= toBits {
+			bits -= toBits
+			result = append(result, byte(acc>>bits&maxv))
+		}
+	}
+	if pad {
+		if bits > 0 {
+			result = append(result, byte(acc<<(toBits-bits)&maxv))
+		}
+	} else if bits >= fromBits || acc<<(toBits-bits)&maxv != 0 {
+		return nil, fmt.Errorf("Invalid padding in bit conversion.")
+	}
+	return result, nil
+}
@@ -0,0 +1,129 @@
+package core_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/blkchain/blkchain/core"
+)
+
+// The bech32 strings of BIP173 and the bech32m ones of BIP350, with
+// the additional vectors of Core.
+
+const bech32Charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+var validBech32 = []struct {
+	s   string
+	enc core.Bech32Encoding
+}{
+	{"A12UEL5L", core.Bech32},
+	{"a12uel5l", core.Bech32},
+	{"an83characterlonghumanreadablepartthatcontainsthenumber1andtheexcludedcharactersbio1tt5tgs", core.Bech32},
+	{"abcdef1qpzry9x8gf2tvdw0s3jn54khce6mua7lmqqqxw", core.Bech32},
+	{"11qqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqc8247j", core.Bech32},
+	{"split1checkupstagehandshakeupstreamerranterredcaperred2y9e3w", core.Bech32},
+	{"?1ezyfcl", core.Bech32},
+
+	{"A1LQFN3A", core.Bech32m},
+	{"a1lqfn3a", core.Bech32m},
+	{"an83characterlonghumanreadablepartthatcontainsthetheexcludedcharactersbioandnumber11sg7hg6", core.Bech32m},
+	{"abcdef1l7aum6echk45nj3s0wdvt2fg8x9yrzpqzd3ryx", core.Bech32m},
+	{"11llllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllludsr8", core.Bech32m},
+	{"split1checkupstagehandshakeupstreamerranterredcaperredlc445v", core.Bech32m},
+	{"?1v759aa", core.Bech32m},
+}
+
+func TestBech32Valid(t *testing.T) {
+	for _, test := range validBech32 {
+		hrp, data, enc, err := core.Bech32Decode(test.s)
+		if err != nil {
+			t.Errorf("%s: %v", test.s, err)
+			continue
+		}
+		if enc != test.enc {
+			t.Errorf("%s: %v, expected %v", test.s, enc, test.enc)
+		}
+		if s, err := core.Bech32Encode(hrp, data, enc); err != nil || s != strings.ToLower(test.s) {
+			t.Errorf("%s encoded again: %s (%v)", test.s, s, err)
+		}
+		// A character changed is caught by the checksum.
+		pos := strings.LastIndexByte(test.s, '1') + 1
+		flipped := []byte(strings.ToLower(test.s))
+		flipped[pos] = bech32Charset[(strings.IndexByte(bech32Charset, flipped[pos])+1)%32]
+		if _, _, _, err := core.Bech32Decode(string(flipped)); err != core.ErrBech32Checksum {
+			t.Errorf("%s: %v, expected %v", flipped, err, core.ErrBech32Checksum)
+		}
+	}
+}
+
+func TestBech32Invalid(t *testing.T) {
+	var charErr *core.Bech32CharError
+	for _, test := range []struct {
+		s    string
+		want string // checksum, char or other
+	}{
+		{"\x201nwldj5", "char"},
+		{"\x7f1axkwrx", "char"},
+		{"\x801eym55h", "char"},
+		{"an84characterslonghumanreadablepartthatcontainsthenumber1andtheexcludedcharactersbio1569pvx", "other"},
+		{"pzry9x0s0muk", "other"},
+		{"1pzry9x0s0muk", "other"},
+		{"x1b4n0q5v", "char"},
+		{"li1dgmt3", "other"},
+		{"de1lg7wt\xff", "char"},
+		{"A1G7SGD8", "checksum"},
+		{"10a06t8", "other"},
+		{"1qzzfhee", "other"},
+		{"a12UEL5L", "other"},
+		{"A12uEL5L", "other"},
+		{"split1checkupstagehandshakeupstreamerranterredcaperred2y9e2w", "checksum"},
+		{"split1cheo2y9e2w", "char"},
+		{"split1a2y9w", "other"},
+
+		{"\x201xj0phk", "char"},
+		{"\x7f1g6xzxy", "char"},
+		{"\x801vctc34", "char"},
+		{"an84characterslonghumanreadablepartthatcontainsthetheexcludedcharactersbioandnumber11d6pts4", "other"},
+		{"qyrz8wqd2c9m", "other"},
+		{"1qyrz8wqd2c9m", "other"},
+		{"y1b0jsk6g", "char"},
+		{"lt1igcx5c0", "char"},
+		{"in1muywd", "other"},
+		{"mm1crxm3i", "char"},
+		{"au1s5cgom", "char"},
+		{"M1VUXWEZ", "checksum"},
+		{"16plkw9", "other"},
+		{"1p2gdwpf", "other"},
+	} {
+		_, _, _, err := core.Bech32Decode(test.s)
+		switch {
+		case err == nil:
+			t.Errorf("%q decoded", test.s)
+		case test.want == "checksum" && err != core.ErrBech32Checksum,
+			test.want == "char" && !errors.As(err, &charErr),
+			test.want == "other" && (err == core.ErrBech32Checksum || errors.As(err, &charErr)):
+			t.Errorf("%q: %v, expected a %s error", test.s, err, test.want)
+		}
+	}
+}
+
+func TestConvertBits(t *testing.T) {
+	data := []byte{0x00, 0xff, 0x75, 0x1e, 0x76}
+	five, err := core.ConvertBits(data, 8, 5, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(five) != 8 {
+		t.Errorf("%d 5 bit values for %d bytes, expected 8", len(five), len(data))
+	}
+	if back, err := core.ConvertBits(five, 5, 8, false); err != nil || string(back) != string(data) {
+		t.Errorf("Converted back: %x (%v), expected %x", back, err, data)
+	}
+	if _, err := core.ConvertBits([]byte{32}, 5, 8, true); err == nil {
+		t.Errorf("A 6 bit value was converted")
+	}
+	if _, err := core.ConvertBits([]byte{1}, 5, 8, false); err == nil {
+		t.Errorf("Non-zero padding was accepted")
+	}
+}
@@ -1,7 +1,8 @@
-package blkchain
+package core
 
 import (
 	"encoding/binary"
+	"fmt"
 	"io"
 	"math"
 )
@@ -71,6 +72,12 @@ func readCompactSize(r io.Reader) (uint64, error) {
 	return result, nil
 }
 
+// ReadCompactSize reads the CompactSize of the length of vectors,
+// as opposed to the VarInt of Core's own databases (see ReadVarInt).
+func ReadCompactSize(r io.Reader) (uint64, error) {
+	return readCompactSize(r)
+}
+
 func compactSizeSize(i uint64) int {
 	// https://en.bitcoin.it/wiki/Protocol_documentation#Variable_length_integer
 	switch {
@@ -107,19 +114,12 @@ func writeCompactSize(i uint64, w io.Writer) (err error) {
 	return binary.Write(w, binary.LittleEndian, i)
 }
 
-func readString(r io.Reader) ([]byte, error) {
-	size, err := readCompactSize(r)
-	if err != nil {
-		return nil, err
-	}
-
-	buf := make([]byte, int(size))
-	_, err = io.ReadFull(r, buf)
-	if err != nil {
-		return nil, err
-	}
+// Larger strings are invalid, as in Core (MAX_SIZE), it keeps a
+// corrupt or hostile size from allocating all the memory.
+const maxStringSize = 0x02000000
 
-	return buf, nil
+func readString(r io.Reader) ([]byte, error) {
+	return readStringMax(r, maxStringSize, "String")
 }
 
 func writeString(s []byte, w io.Writer) (err error) {
@@ -131,17 +131,7 @@ func writeString(s []byte, w io.Writer) (err error) {
 }
 
 func readList(r io.Reader, doRead func(io.Reader) error) error {
-	size, err := readCompactSize(r)
-	if err != nil {
-		return err
-	}
-
-	for i := uint64(0); i < size; i++ {
-		if err = doRead(r); err != nil {
-			return err
-		}
-	}
-	return nil
+	return readListMax(r, 0, "", doRead)
 }
 
 func writeList(w io.Writer, size int, doWrite func(io.Writer, int) error) error {
@@ -170,18 +160,19 @@ func ReadVarInt(r io.Reader) (uint64, error) {
 		if err != nil {
 			return 0, err
 		}
-		// TODO - overflow check
+		if n > math.MaxUint64>>7 {
+			return 0, fmt.Errorf("VarInt too large")
+		}
 		n = (n << 7) | uint64(buf[0]&0x7F)
 		if (buf[0] & 0x80) != 0 {
-			// TODO - overflow check
+			if n == math.MaxUint64 {
+				return 0, fmt.Errorf("VarInt too large")
+			}
 			n++
 		} else {
 			return n, nil
 		}
-
 	}
-	panic("unreachable")
-	return 0, nil
 }
 
 // https://github.com/bitcoin/bitcoin/blob/0.15/src/serialize.h#L317
@@ -0,0 +1,89 @@
+package core
+
+import (
+	"fmt"
+	"io"
+)
+
+const (
+	MainNetMagic  = 0xd9b4bef9
+	TestNetMagic  = 0x0709110b // testnet3
+	TestNet4Magic = 0x283f161c
+	SigNetMagic   = 0x40cf030a // the default signet
+	RegTestMagic  = 0xdab5bffa
+)
+
+type Block struct {
+	Magic uint32
+	*BlockHeader
+	Txs TxList
+
+	// The values of the outputs spent by the inputs of each tx but the
+	// coinbase, from the undo data, if the reader of the block has it
+	// (see coredb.UndoReader), nil otherwise. Not part of the block.
+	PrevOutValues [][]int64
+}
+
+func (b *Block) BaseSize() int {
+	return b.BlockHeader.Size() + b.Txs.BaseSize()
+}
+
+func (b *Block) Size() int {
+	return b.BlockHeader.Size() + b.Txs.Size()
+}
+
+func (b *Block) Weight() int {
+	return b.BaseSize()*3 + b.Size()
+}
+
+func (b *Block) VirtualSize() int {
+	return b.BlockHeader.Size() + b.Txs.VirtualSize()
+}
+
+func (b *Block) BinRead(r io.Reader) error {
+	m, err := readMagic(r)
+	if err != nil {
+		return err
+	}
+
+	if b.Magic > 0 && b.Magic != m {
+		return fmt.Errorf("Bad magic: %d", m)
+	}
+
+	var size uint32
+	err = BinRead(&size, r)
+	if err != nil {
+		return err
+	}
+	if Limits.MaxBlockSize > 0 && int64(size) > int64(Limits.MaxBlockSize) {
+		return fmt.Errorf("Block of %d bytes exceeds the maximum of %d", size, Limits.MaxBlockSize)
+	}
+
+	var bh BlockHeader
+	err = BinRead(&bh, r)
+	if err != nil {
+		return err
+	}
+	b.BlockHeader = &bh
+
+	err = BinRead(&b.Txs, r)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// BinWrite writes the block as BinRead reads it, i.e. as in the
+// blk*.dat files.
+func (b *Block) BinWrite(w io.Writer) error {
+	if err := BinWrite(b.Magic, w); err != nil {
+		return err
+	}
+	if err := BinWrite(uint32(b.Size()), w); err != nil {
+		return err
+	}
+	if err := BinWrite(b.BlockHeader, w); err != nil {
+		return err
+	}
+	return BinWrite(&b.Txs, w)
+}
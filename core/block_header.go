@@ -1,4 +1,4 @@
-package blkchain
+package core
 
 import (
 	"bytes"
@@ -0,0 +1,91 @@
+package core
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Bitcoin Core 28+ obfuscates the blk*.dat (and rev*.dat) files with a
+// random per-datadir key, kept in blocks/xor.dat: every byte of a file
+// is XOR'ed with key[offset % len(key)]. Older data directories have no
+// xor.dat (or an all-zero key), and are read as is.
+
+const xorKeyFile = "xor.dat"
+
+// ReadXorKey returns the obfuscation key of the blocks directory, nil
+// if there is none.
+func ReadXorKey(blocksDir string) ([]byte, error) {
+	key, err := os.ReadFile(filepath.Join(blocksDir, xorKeyFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if len(key) == 0 {
+		return nil, fmt.Errorf("Empty %s in %s.", xorKeyFile, blocksDir)
+	}
+	for _, b := range key {
+		if b != 0 {
+			return key, nil
+		}
+	}
+	return nil, nil // all zeros, i.e. not obfuscated
+}
+
+// A BlockFile is a block file which is de-obfuscated as it is read.
+type BlockFile struct {
+	f   *os.File
+	key []byte
+	pos int64
+}
+
+// OpenBlockFile opens the file for reading with the key, which can be
+// nil.
+func OpenBlockFile(path string, key []byte) (*BlockFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &BlockFile{f: f, key: key}, nil
+}
+
+// Xor applies the key to b, as read at offset off. Being its own
+// inverse, it turns de-obfuscated bytes back into the raw ones.
+func (bf *BlockFile) Xor(b []byte, off int64) {
+	if bf.key == nil {
+		return
+	}
+	n := int64(len(bf.key))
+	for i := range b {
+		b[i] ^= bf.key[(off+int64(i))%n]
+	}
+}
+
+func (bf *BlockFile) Read(b []byte) (int, error) {
+	n, err := bf.f.Read(b)
+	bf.Xor(b[:n], bf.pos)
+	bf.pos += int64(n)
+	return n, err
+}
+
+func (bf *BlockFile) ReadAt(b []byte, off int64) (int, error) {
+	n, err := bf.f.ReadAt(b, off)
+	bf.Xor(b[:n], off)
+	return n, err
+}
+
+func (bf *BlockFile) Seek(offset int64, whence int) (int64, error) {
+	pos, err := bf.f.Seek(offset, whence)
+	if err == nil {
+		bf.pos = pos
+	}
+	return pos, err
+}
+
+func (bf *BlockFile) Close() error {
+	return bf.f.Close()
+}
+
+var _ io.ReadSeeker = (*BlockFile)(nil)
@@ -0,0 +1,95 @@
+package core
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// BlockFileWriter writes blocks framed as in the blk*.dat files (magic,
+// size, block), starting a new file when the current one would exceed
+// the maximum size, as Core does. The files are not obfuscated, Core
+// reads them as is with -loadblock, or as its own blocks if there is no
+// xor.dat.
+
+// As MAX_BLOCKFILE_SIZE in Core.
+const MaxBlockFileSize = 0x8000000 // 128 MiB
+
+type BlockFileWriter struct {
+	create  func(name string) (io.WriteCloser, error)
+	magic   uint32
+	maxSize int64 // 0 = one file
+	idx     int
+	size    int64
+	f       io.WriteCloser
+	w       *bufio.Writer
+	Blocks  int
+	Files   int
+}
+
+// NewBlockFileWriter writes to blk<start>.dat and on in dir, existing
+// files are overwritten.
+func NewBlockFileWriter(dir string, magic uint32, start int, maxSize int64) (*BlockFileWriter, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return NewBlockFileWriterFunc(func(name string) (io.WriteCloser, error) {
+		return os.Create(filepath.Join(dir, name))
+	}, magic, start, maxSize), nil
+}
+
+// NewBlockFileWriterFunc writes to the files created by create, given
+// their names, e.g. to write them elsewhere than to a directory.
+func NewBlockFileWriterFunc(create func(name string) (io.WriteCloser, error), magic uint32, start int, maxSize int64) *BlockFileWriter {
+	return &BlockFileWriter{create: create, magic: magic, maxSize: maxSize, idx: start - 1}
+}
+
+func (bw *BlockFileWriter) next() error {
+	if err := bw.closeFile(); err != nil {
+		return err
+	}
+	bw.idx++
+	f, err := bw.create(fmt.Sprintf("blk%05d.dat", bw.idx))
+	if err != nil {
+		return err
+	}
+	bw.f, bw.w, bw.size = f, bufio.NewWriterSize(f, 1<<20), 0
+	bw.Files++
+	return nil
+}
+
+func (bw *BlockFileWriter) closeFile() error {
+	if bw.f == nil {
+		return nil
+	}
+	err := bw.w.Flush()
+	if cerr := bw.f.Close(); err == nil {
+		err = cerr
+	}
+	bw.f, bw.w = nil, nil
+	return err
+}
+
+// WriteBlock appends the block, its magic is ignored.
+func (bw *BlockFileWriter) WriteBlock(b *Block) error {
+	size := int64(8 + b.Size())
+	if bw.f == nil || (bw.maxSize > 0 && bw.size > 0 && bw.size+size > bw.maxSize) {
+		if err := bw.next(); err != nil {
+			return err
+		}
+	}
+	framed := *b
+	framed.Magic = bw.magic
+	if err := BinWrite(&framed, bw.w); err != nil {
+		return err
+	}
+	bw.size += size
+	bw.Blocks++
+	return nil
+}
+
+func (bw *BlockFileWriter) Close() error {
+	return bw.closeFile()
+}
@@ -0,0 +1,111 @@
+package core
+
+import (
+	"encoding/json"
+	"math/big"
+	"regexp"
+	"strings"
+)
+
+// BRC-20 operations are JSON inscriptions like
+//
+//   {"p":"brc-20","op":"deploy","tick":"ordi","max":"21000000","lim":"1000"}
+//   {"p":"brc-20","op":"mint","tick":"ordi","amt":"1000"}
+//   {"p":"brc-20","op":"transfer","tick":"ordi","amt":"100"}
+//
+// Amounts are decimal strings. This only parses the JSON, the rules
+// (first deploy wins, mints within the limits) are applied by the
+// indexer.
+
+const (
+	Brc20Deploy   = "deploy"
+	Brc20Mint     = "mint"
+	Brc20Transfer = "transfer"
+
+	Brc20MaxDecimals = 18
+)
+
+var brc20AmountRe = regexp.MustCompile(`^[0-9]+(\.[0-9]+)?$`)
+
+type Brc20Op struct {
+	Op       string
+	Tick     string // lower case
+	Max      *big.Rat
+	Lim      *big.Rat
+	Amt      *big.Rat
+	Decimals int
+}
+
+type brc20Json struct {
+	P    string `json:"p"`
+	Op   string `json:"op"`
+	Tick string `json:"tick"`
+	Max  string `json:"max"`
+	Lim  string `json:"lim"`
+	Amt  string `json:"amt"`
+	Dec  string `json:"dec"`
+}
+
+// Brc20Amount parses a decimal amount, nil if invalid or if it has
+// more than the given number of decimals.
+func Brc20Amount(s string, decimals int) *big.Rat {
+	if !brc20AmountRe.MatchString(s) {
+		return nil
+	}
+	if i := strings.IndexByte(s, '.'); i >= 0 && len(s)-i-1 > decimals {
+		return nil
+	}
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return nil
+	}
+	return r
+}
+
+// ParseBrc20 returns the operation, or nil if the inscription is not
+// a well formed BRC-20 operation. Amounts are checked against the
+// maximum decimals, the indexer must recheck them against those of
+// the deploy.
+func (ins *Inscription) ParseBrc20() *Brc20Op {
+	ct := strings.ToLower(string(ins.ContentType))
+	if !strings.HasPrefix(ct, "text/plain") && !strings.HasPrefix(ct, "application/json") {
+		return nil
+	}
+	if len(ins.ContentEncoding) > 0 {
+		return nil
+	}
+	var j brc20Json
+	if err := json.Unmarshal(ins.Body, &j); err != nil {
+		return nil
+	}
+	if j.P != "brc-20" || len([]rune(j.Tick)) != 4 {
+		return nil
+	}
+	op := &Brc20Op{Op: j.Op, Tick: strings.ToLower(j.Tick), Decimals: Brc20MaxDecimals}
+	switch j.Op {
+	case Brc20Deploy:
+		if j.Dec != "" {
+			d, ok := new(big.Int).SetString(j.Dec, 10)
+			if !ok || d.Sign() < 0 || d.Cmp(big.NewInt(Brc20MaxDecimals)) > 0 {
+				return nil
+			}
+			op.Decimals = int(d.Int64())
+		}
+		if op.Max = Brc20Amount(j.Max, op.Decimals); op.Max == nil || op.Max.Sign() == 0 {
+			return nil
+		}
+		op.Lim = op.Max
+		if j.Lim != "" {
+			if op.Lim = Brc20Amount(j.Lim, op.Decimals); op.Lim == nil || op.Lim.Sign() == 0 {
+				return nil
+			}
+		}
+	case Brc20Mint, Brc20Transfer:
+		if op.Amt = Brc20Amount(j.Amt, op.Decimals); op.Amt == nil || op.Amt.Sign() == 0 {
+			return nil
+		}
+	default:
+		return nil
+	}
+	return op
+}
@@ -0,0 +1,79 @@
+package core
+
+import "fmt"
+
+// Checkpoints: the hashes of the genesis block and of some historical
+// blocks of each network, as in the chain params of Core and btcd, for
+// the import to check as it passes their heights, so that the blocks
+// of another network or a fork are not mistaken for the chain. Regtest
+// chains are local and have none.
+// https://github.com/bitcoin/bitcoin/blob/v22.0/src/chainparams.cpp
+// https://github.com/btcsuite/btcd/blob/v0.23.3/chaincfg/params.go
+
+var checkpoints = map[uint32]map[int]string{
+	MainNetMagic: {
+		0:      "000000000019d6689c085ae165831e934ff763ae46a2a6c172b3f1b60a8ce26f",
+		11111:  "0000000069e244f73d78e8fd29ba2fd2ed618bd6fa2ee92559f542fdb26e7c1d",
+		33333:  "000000002dd5588a74784eaa7ab0507a18ad16a236e7b1ce69f00d7ddfb5d0a6",
+		74000:  "0000000000573993a3c9e41ce34471c079dcf5f52a0e824a81e7f953b8661a20",
+		105000: "00000000000291ce28027faea320c8d2b054b2e0fe44a773f3eefb151d6bdc97",
+		134444: "00000000000005b12ffd4cd315cd34ffd4a594f430ac814c91184a0d42d2b0fe",
+		168000: "000000000000099e61ea72015e79632f216fe6cb33d7899acb35b75c8303b763",
+		193000: "000000000000059f452a5f7340de6682a977387c17010ff6e6c3bd83ca8b1317",
+		210000: "000000000000048b95347e83192f69cf0366076336c639f9b7228e9ba171342e",
+		216116: "00000000000001b4f4b433e81ee46494af945cf96014816a4e2370f11b23df4e",
+		225430: "00000000000001c108384350f74090433e7fcf79a606b8e797f065b130575932",
+		250000: "000000000000003887df1f29024b06fc2200b55f8af8f35453d7be294df2d214",
+		279000: "0000000000000001ae8c72a0b0c301f67e3afca10e819efa9041e458e9bd7e40",
+		295000: "00000000000000004d9b4ef50f0f9d686fd69db2e03af35a100370c64632a983",
+		300255: "0000000000000000162804527c6e9b9f0563a280525f9d08c12041def0a0f3b2",
+		319400: "000000000000000021c6052e9becade189495d1c539aa37c58917305fd15f13b",
+		343185: "0000000000000000072b8bf361d01a6ba7d445dd024203fafc78768ed4368554",
+		352940: "000000000000000010755df42dba556bb72be6a32f3ce0b6941ce4430152c9ff",
+		382320: "00000000000000000a8dc6ed5b133d0eb2fd6af56203e4159789b092defd8ab2",
+		400000: "000000000000000004ec466ce4732fe6f1ed1cddc2ed4b328fff5224276e3f6f",
+		430000: "000000000000000001868b2bb3a285f3cc6b33ea234eb70facf4dcdf22186b87",
+		460000: "000000000000000000ef751bbce8e744ad303c47ece06c8d863e4d417efc258c",
+		490000: "000000000000000000de069137b17b8d5a3dfbd5b145b2dcfb203f15d0c4de90",
+		520000: "0000000000000000000d26984c0229c9f6962dc74db0a6d525f2f1640396f69c",
+		550000: "000000000000000000223b7a2298fb1c6c75fb0efc28a4c56853ff4112ec6bc9",
+		560000: "0000000000000000002c7b276daf6efb2b6aa68e2ce3be67ef925b3264ae7122",
+	},
+	TestNetMagic: {
+		0:       "000000000933ea01ad0ee984209779baaec3ced90fa3f408719526f8d77f4943",
+		546:     "000000002a936ca763904c3c35fce2f3556c559c0214345d31b1bcebf76acb70",
+		100000:  "00000000009e2958c15ff9290d571bf9459e93b19765c6801ddeccadbb160a1e",
+		500011:  "00000000000929f63977fbac92ff570a9bd9e7715401ee96f2848f7b07750b02",
+		1000007: "00000000001ccb893d8a1f25b70ad173ce955e5f50124261bbbc50379a612ddf",
+	},
+	TestNet4Magic: {
+		0: "00000000da84f2bafbbc53dee25a72ae507ff4914b867c565be350b0da8bf043",
+	},
+	SigNetMagic: {
+		0: "00000008819873e925422c1ff0f99f7cc9bbb232af63a077a480a3633bee1ef6",
+	},
+}
+
+// CheckpointAt returns the hash of the block of the network of the
+// magic at height, if it is a checkpoint.
+func CheckpointAt(magic uint32, height int) (Uint256, bool) {
+	s, ok := checkpoints[magic][height]
+	if !ok {
+		return Uint256{}, false
+	}
+	hash, err := Uint256FromString(s)
+	if err != nil {
+		panic(err) // the table above is wrong
+	}
+	return hash, true
+}
+
+// VerifyCheckpoint returns an error if height is a checkpoint of the
+// network of the magic and hash is not its block.
+func VerifyCheckpoint(magic uint32, height int, hash Uint256) error {
+	if want, ok := CheckpointAt(magic, height); ok && want != hash {
+		return fmt.Errorf("Block %v at height %d does not match the checkpoint %v, this is not the expected chain.",
+			hash, height, want)
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package core
+
+// Structural heuristics for recognizing coinjoins. These only look at
+// the shape of the transaction (counts and values), so there will be
+// false positives and negatives, especially for JoinMarket, which
+// looks like an ordinary batched payment with a few equal outputs.
+
+const (
+	CoinjoinWhirlpool  = "whirlpool"
+	CoinjoinWasabi     = "wasabi"
+	CoinjoinWasabi2    = "wasabi2"
+	CoinjoinJoinMarket = "joinmarket"
+)
+
+// Whirlpool pool denominations, in satoshis
+var whirlpoolPools = map[int64]bool{
+	100000:   true,
+	1000000:  true,
+	5000000:  true,
+	50000000: true,
+}
+
+// CoinjoinType returns the kind of coinjoin this looks like, or "".
+func (tx *Tx) CoinjoinType() string {
+	nIn, nOut := len(tx.TxIns), len(tx.TxOuts)
+	if nIn < 2 || nOut < 2 {
+		return ""
+	}
+
+	counts := make(map[int64]int, nOut)
+	for _, out := range tx.TxOuts {
+		counts[out.Value]++
+	}
+	var (
+		value int64 // the most common output value
+		equal int   // how many outputs have it
+	)
+	for v, c := range counts {
+		if c > equal || (c == equal && v > value) {
+			value, equal = v, c
+		}
+	}
+
+	// Whirlpool: 5 in, 5 out, all outputs equal to a pool denomination
+	// (and each input slightly more than that).
+	if nIn == 5 && nOut == 5 && equal == 5 && whirlpoolPools[value] {
+		return CoinjoinWhirlpool
+	}
+
+	// Wasabi 1.x: at least 10 equal outputs of ~0.1 BTC, plus change
+	// and the coordinator fee.
+	if equal >= 10 && value >= 9000000 && value <= 11000000 && nIn >= equal {
+		return CoinjoinWasabi
+	}
+
+	// WabiSabi (Wasabi 2): lots of inputs and outputs, with several
+	// repeated standard denominations.
+	if nIn >= 50 && nOut >= 50 {
+		repeated := 0
+		for _, c := range counts {
+			if c >= 2 {
+				repeated++
+			}
+		}
+		if repeated >= 5 {
+			return CoinjoinWasabi2
+		}
+	}
+
+	// JoinMarket: n >= 3 equal outputs, each participant adding a
+	// change output (so at most 2n+1 outputs) and at least one input.
+	if equal >= 3 && nIn >= equal && nOut <= 2*equal+1 {
+		return CoinjoinJoinMarket
+	}
+
+	return ""
+}
@@ -0,0 +1,83 @@
+package core
+
+import (
+	"math/big"
+)
+
+// The nBits of difficulty 1, the proof of work limit of mainnet and
+// testnet, which the min-difficulty blocks of testnet have.
+const DiffOneBits = 0x1d00ffff
+
+// The difficulty 1 target.
+var diff1Target = CompactToBig(DiffOneBits)
+
+// CompactToBig converts the compact (nBits) representation of the
+// target to a big.Int. This is the same as arith_uint256::SetCompact()
+// in Core: the top byte is the exponent (number of bytes), the lower
+// 23 bits are the mantissa and bit 24 is the sign.
+// https://github.com/bitcoin/bitcoin/blob/v22.0/src/arith_uint256.cpp#L203
+func CompactToBig(compact uint32) *big.Int {
+	exponent := uint(compact >> 24)
+	mantissa := int64(compact & 0x007fffff)
+	negative := compact&0x00800000 != 0
+
+	result := big.NewInt(mantissa)
+	if exponent <= 3 {
+		result.Rsh(result, 8*(3-exponent))
+	} else {
+		result.Lsh(result, 8*(exponent-3))
+	}
+	if negative {
+		result.Neg(result)
+	}
+	return result
+}
+
+// Target returns the proof of work target encoded in the header bits.
+func (bh *BlockHeader) Target() *big.Int {
+	return CompactToBig(uint32(bh.Bits))
+}
+
+// Difficulty as it is commonly displayed, i.e. the difficulty 1
+// target divided by the current target.
+func Difficulty(bits uint32) float64 {
+	target := CompactToBig(bits)
+	if target.Sign() <= 0 {
+		return 0
+	}
+	d, _ := new(big.Float).Quo(new(big.Float).SetInt(diff1Target), new(big.Float).SetInt(target)).Float64()
+	return d
+}
+
+func (bh *BlockHeader) Difficulty() float64 {
+	return Difficulty(uint32(bh.Bits))
+}
+
+// HashRate estimates the network hash rate (hashes per second)
+// needed to produce blocks at the given difficulty every
+// blockInterval seconds on average. On average a block requires
+// difficulty * 2^32 hashes.
+func HashRate(bits uint32, blockInterval float64) float64 {
+	if blockInterval <= 0 {
+		return 0
+	}
+	return Difficulty(bits) * (1 << 32) / blockInterval
+}
+
+// BlockWork is the expected number of hashes for a block with the
+// target of bits, 2^256 / (target+1), as GetBlockProof() in Core. The
+// chainwork of a block is the sum of it over the block and all its
+// ancestors, the best chain is the one with the most of it.
+// https://github.com/bitcoin/bitcoin/blob/v22.0/src/chain.cpp
+func BlockWork(bits uint32) *big.Int {
+	target := CompactToBig(bits)
+	if target.Sign() <= 0 {
+		return new(big.Int)
+	}
+	work := new(big.Int).Lsh(big.NewInt(1), 256)
+	return work.Div(work, target.Add(target, big.NewInt(1)))
+}
+
+func (bh *BlockHeader) Work() *big.Int {
+	return BlockWork(uint32(bh.Bits))
+}
@@ -0,0 +1,79 @@
+package core_test
+
+import (
+	"math"
+	"math/big"
+	"testing"
+
+	"github.com/blkchain/blkchain/core"
+)
+
+// GetBlockProof of Core computes 2^256 / (target+1) as
+// ~target / (target+1) + 1, which does not overflow 256 bits.
+func coreBlockProof(bits uint32) *big.Int {
+	var u core.Uint256
+	if negative, overflow := u.SetCompact(bits); negative || overflow || u.IsZero() {
+		return new(big.Int)
+	}
+	target := u.Big()
+	not := new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 256), big.NewInt(1))
+	not.Xor(not, target)
+	return not.Div(not, target.Add(target, big.NewInt(1))).Add(not, big.NewInt(1))
+}
+
+func TestBlockWork(t *testing.T) {
+	for _, test := range []struct {
+		bits uint32
+		work string // hex, as chainwork
+	}{
+		{core.DiffOneBits, "100010001"},
+		{0x207fffff, "2"}, // regtest
+		{0x1b04864c, ""},  // 100000
+		{0x1d00d86a, ""},  // the first retarget, 32256
+		{0x17034219, ""},  // 840000
+		{0x00123456, "0"}, // no target
+		{0x04923456, "0"}, // negative
+		{0xff123456, "0"}, // overflow
+	} {
+		got := core.BlockWork(test.bits)
+		if want := coreBlockProof(test.bits); got.Cmp(want) != 0 {
+			t.Errorf("Work of %#08x: %x, expected %x", test.bits, got, want)
+		}
+		if test.work != "" && got.Text(16) != test.work {
+			t.Errorf("Work of %#08x: %x, expected %s", test.bits, got, test.work)
+		}
+	}
+
+	if d := core.Difficulty(core.DiffOneBits); d != 1 {
+		t.Errorf("Difficulty of %#08x: %v, expected 1", core.DiffOneBits, d)
+	}
+	if d := core.Difficulty(0x1b04864c); math.Abs(d-14484.162361225399) > 1e-6 {
+		t.Errorf("Difficulty of block 100000: %v, expected 14484.162361225399", d)
+	}
+}
+
+// The chainwork of the first blocks, as getblockheader gives it: every
+// block of mainnet until the first retarget, and of regtest, has the
+// same bits.
+func TestChainwork(t *testing.T) {
+	for _, test := range []struct {
+		bits   uint32
+		height int
+		work   string
+	}{
+		{core.DiffOneBits, 0, "0000000000000000000000000000000000000000000000000000000100010001"},
+		{core.DiffOneBits, 1, "0000000000000000000000000000000000000000000000000000000200020002"},
+		{core.DiffOneBits, 2015, "000000000000000000000000000000000000000000000000000007e007e007e0"},
+		{core.DiffOneBits, 32255, "00000000000000000000000000000000000000000000000000007e007e007e00"},
+		{0x207fffff, 0, "0000000000000000000000000000000000000000000000000000000000000002"},
+		{0x207fffff, 106, "00000000000000000000000000000000000000000000000000000000000000d6"},
+	} {
+		work := new(big.Int)
+		for h := 0; h <= test.height; h++ {
+			work.Add(work, core.BlockWork(test.bits))
+		}
+		if got := core.Uint256FromBig(work).String(); got != test.work {
+			t.Errorf("Chainwork at height %d with %#08x: %s, expected %s", test.height, test.bits, got, test.work)
+		}
+	}
+}
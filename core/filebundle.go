@@ -1,10 +1,9 @@
-package blkchain
+package core
 
 import (
 	"fmt"
 	"io"
 	"log"
-	"os"
 	"path/filepath"
 	"strings"
 )
@@ -13,14 +12,20 @@ type fileBundle struct {
 	dir    string
 	idx    int
 	prefix string
-	f      *os.File
+	key    []byte // see blockfile.go
+	f      *BlockFile
 }
 
 func newFileBundle(dir string, start int) (*fileBundle, error) {
+	key, err := ReadXorKey(dir)
+	if err != nil {
+		return nil, err
+	}
 	fb := &fileBundle{
 		dir:    dir,
 		prefix: "blk",
 		idx:    start,
+		key:    key,
 	}
 	if err := fb.next(); err != nil {
 		return nil, err
@@ -35,7 +40,7 @@ func (f *fileBundle) next() (err error) {
 	}
 	path := filepath.Join(f.dir, fmt.Sprintf("%s%05d.dat", f.prefix, f.idx))
 	log.Printf("Scanning file: %v", path)
-	f.f, err = os.Open(path)
+	f.f, err = OpenBlockFile(path, f.key)
 
 	return err
 }
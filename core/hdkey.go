@@ -0,0 +1,150 @@
+package core
+
+import (
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+)
+
+// Extended public keys (BIP32), only as much as watching a wallet
+// needs: parsing the base58check xpub (and the ypub and zpub of the
+// nested and native segwit wallets) and the public derivation of
+// non-hardened children. The prefix tells which addresses the wallet
+// uses (see ExtendedKey.Address).
+// https://github.com/bitcoin/bips/blob/master/bip-0032.mediawiki
+
+// Address types of the wallets of the extended key prefixes.
+const (
+	HDAddressP2PKH      = "p2pkh"       // xpub, tpub
+	HDAddressP2SHP2WPKH = "p2sh-p2wpkh" // ypub, upub (BIP49)
+	HDAddressP2WPKH     = "p2wpkh"      // zpub, vpub (BIP84)
+)
+
+var hdVersions = map[uint32]struct {
+	addrType string
+	params   *AddressParams
+}{
+	0x0488b21e: {HDAddressP2PKH, MainNetAddressParams},
+	0x049d7cb2: {HDAddressP2SHP2WPKH, MainNetAddressParams},
+	0x04b24746: {HDAddressP2WPKH, MainNetAddressParams},
+	0x043587cf: {HDAddressP2PKH, TestNetAddressParams},
+	0x044a5262: {HDAddressP2SHP2WPKH, TestNetAddressParams},
+	0x045f1cf6: {HDAddressP2WPKH, TestNetAddressParams},
+}
+
+type ExtendedKey struct {
+	AddressType string
+	Params      *AddressParams
+	Depth       byte
+	ChildNum    uint32
+	chainCode   []byte
+	pubKey      []byte // compressed
+}
+
+// ParseExtendedKey parses an extended public key, private ones are
+// refused.
+func ParseExtendedKey(s string) (*ExtendedKey, error) {
+	data, err := Base58CheckDecode(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) != 78 {
+		return nil, fmt.Errorf("Invalid extended key length, %d bytes rather than 78.", len(data))
+	}
+	version := binary.BigEndian.Uint32(data[:4])
+	v, ok := hdVersions[version]
+	if !ok {
+		if data[45] == 0 {
+			return nil, fmt.Errorf("This is an extended private key, only public ones are accepted.")
+		}
+		return nil, fmt.Errorf("Unknown extended key version %#08x.", version)
+	}
+	if _, err := btcec.ParsePubKey(data[45:]); err != nil {
+		return nil, fmt.Errorf("Invalid extended key public key: %v", err)
+	}
+	return &ExtendedKey{
+		AddressType: v.addrType,
+		Params:      v.params,
+		Depth:       data[4],
+		ChildNum:    binary.BigEndian.Uint32(data[9:13]),
+		chainCode:   data[13:45],
+		pubKey:      data[45:],
+	}, nil
+}
+
+// PubKey returns the compressed public key.
+func (k *ExtendedKey) PubKey() []byte {
+	return k.pubKey
+}
+
+// Child derives the non-hardened child i (CKDpub).
+func (k *ExtendedKey) Child(i uint32) (*ExtendedKey, error) {
+	if i >= 0x80000000 {
+		return nil, fmt.Errorf("Cannot derive hardened child %d from a public key.", i)
+	}
+	mac := hmac.New(sha512.New, k.chainCode)
+	mac.Write(k.pubKey)
+	binary.Write(mac, binary.BigEndian, i)
+	sum := mac.Sum(nil)
+
+	var tweak btcec.ModNScalar
+	if overflow := tweak.SetByteSlice(sum[:32]); overflow {
+		return nil, fmt.Errorf("Invalid child %d, try the next one.", i)
+	}
+	p, err := btcec.ParsePubKey(k.pubKey)
+	if err != nil {
+		return nil, err
+	}
+	var pj, tg, q btcec.JacobianPoint
+	p.AsJacobian(&pj)
+	btcec.ScalarBaseMultNonConst(&tweak, &tg)
+	btcec.AddNonConst(&pj, &tg, &q)
+	if (q.X.IsZero() && q.Y.IsZero()) || q.Z.IsZero() {
+		return nil, fmt.Errorf("Invalid child %d, try the next one.", i)
+	}
+	q.ToAffine()
+
+	return &ExtendedKey{
+		AddressType: k.AddressType,
+		Params:      k.Params,
+		Depth:       k.Depth + 1,
+		ChildNum:    i,
+		chainCode:   sum[32:],
+		pubKey:      btcec.NewPublicKey(&q.X, &q.Y).SerializeCompressed(),
+	}, nil
+}
+
+// Derive derives the children down the path of non-hardened indexes,
+// e.g. 0, 5 for the sixth receiving address of an account key.
+func (k *ExtendedKey) Derive(path ...uint32) (*ExtendedKey, error) {
+	var err error
+	for _, i := range path {
+		if k, err = k.Child(i); err != nil {
+			return nil, err
+		}
+	}
+	return k, nil
+}
+
+// ScriptPubKey returns the scriptPubKey of the key for the address type
+// of the wallet.
+func (k *ExtendedKey) ScriptPubKey() []byte {
+	h := Hash160(k.pubKey)
+	switch k.AddressType {
+	case HDAddressP2SHP2WPKH:
+		redeem := append([]byte{OP_0, 20}, h...)
+		return append(append([]byte{0xa9, 20}, Hash160(redeem)...), 0x87)
+	case HDAddressP2WPKH:
+		return append([]byte{OP_0, 20}, h...)
+	}
+	return append(append([]byte{0x76, 0xa9, 20}, h...), 0x88, 0xac)
+}
+
+// Address returns the address of the key for the address type of the
+// wallet.
+func (k *ExtendedKey) Address() string {
+	return ScriptAddress(k.ScriptPubKey(), k.Params)
+}
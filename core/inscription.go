@@ -0,0 +1,121 @@
+package core
+
+import "bytes"
+
+// Ordinals inscriptions are stored in taproot script path spends in
+// an "envelope", a branch that is never executed:
+//
+//   OP_FALSE OP_IF "ord" <tag> <value> ... OP_0 <body> ... OP_ENDIF
+//
+// Tags are single pushes (OP_1 counts as a push of 1), the body is the
+// concatenation of all the pushes following OP_0. Only the tags we
+// care about are kept. See https://docs.ordinals.com/inscriptions.html
+
+const (
+	INSCRIPTION_TAG_CONTENT_TYPE     = 1
+	INSCRIPTION_TAG_CONTENT_ENCODING = 9
+)
+
+var inscriptionProtocolId = []byte("ord")
+
+type Inscription struct {
+	Input           int // index of the tx input
+	Index           int // envelope number within the input
+	ContentType     []byte
+	ContentEncoding []byte
+	Body            []byte
+}
+
+// Inscriptions returns the inscriptions found in the envelopes of all
+// inputs, nil if there are none.
+func (tx *Tx) Inscriptions() []*Inscription {
+	if !tx.SegWit {
+		return nil
+	}
+	var result []*Inscription
+	for n, txin := range tx.TxIns {
+		script := txin.Witness.TapScript()
+		if len(script) == 0 {
+			continue
+		}
+		for i, ins := range parseEnvelopes(script) {
+			ins.Input, ins.Index = n, i
+			result = append(result, ins)
+		}
+	}
+	return result
+}
+
+func parseEnvelopes(script []byte) []*Inscription {
+	if !bytes.Contains(script, inscriptionProtocolId) { // fast path
+		return nil
+	}
+	// A parse error is fine, whatever came before it counts
+	ops, _ := ParseScript(script)
+
+	var result []*Inscription
+	for i := 0; i+2 < len(ops); i++ {
+		if !(ops[i].Op == OP_0 && ops[i+1].Op == OP_IF &&
+			ops[i+2].IsPush() && bytes.Equal(ops[i+2].Data, inscriptionProtocolId)) {
+			continue
+		}
+		ins, end := parseEnvelope(ops, i+3)
+		if ins != nil {
+			result = append(result, ins)
+		}
+		i = end
+	}
+	return result
+}
+
+// Parses the envelope starting after "ord", returns the inscription
+// (nil if malformed) and the position of the last op consumed.
+func parseEnvelope(ops []*ScriptOp, i int) (*Inscription, int) {
+	ins := &Inscription{}
+	for i < len(ops) {
+		op := ops[i]
+		if op.Op == OP_ENDIF {
+			return ins, i
+		}
+		if op.Op == OP_0 { // body
+			body := []byte{}
+			for i++; i < len(ops); i++ {
+				if ops[i].Op == OP_ENDIF {
+					ins.Body = body
+					return ins, i
+				}
+				data, ok := ops[i].PushData()
+				if !ok {
+					return nil, i
+				}
+				body = append(body, data...)
+			}
+			return nil, i // no OP_ENDIF
+		}
+		if i+1 >= len(ops) {
+			return nil, i
+		}
+		tag, ok := op.PushData()
+		if !ok {
+			return nil, i
+		}
+		value, ok := ops[i+1].PushData()
+		if !ok {
+			return nil, i + 1
+		}
+		if len(tag) == 1 {
+			switch tag[0] {
+			case INSCRIPTION_TAG_CONTENT_TYPE:
+				if ins.ContentType == nil { // the first one counts
+					ins.ContentType = value
+				}
+			case INSCRIPTION_TAG_CONTENT_ENCODING:
+				if ins.ContentEncoding == nil {
+					ins.ContentEncoding = value
+				}
+			}
+		}
+		i += 2
+	}
+	return nil, i
+}
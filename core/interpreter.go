@@ -0,0 +1,1258 @@
+package core
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/ripemd160"
+)
+
+// A minimal Script interpreter, enough to validate the inputs of the
+// chain against their prevouts: P2SH, segwit v0 and taproot (key and
+// script path), the opcodes in use, and the consensus limits. It is
+// not a consensus implementation, policy (standardness) rules are not
+// checked.
+//
+// Everything which takes elliptic curve operations, i.e. checking
+// signatures and the taproot output key commitment, is left to a
+// SigChecker, as is computing what is signed. SigEncodingChecker
+// only checks the encodings.
+
+// The rest of the opcodes, see script.go.
+const (
+	OP_RESERVED = 0x50
+	OP_NOP      = 0x61
+	OP_VER      = 0x62
+	OP_NOTIF    = 0x64
+	OP_VERIF    = 0x65
+	OP_VERNOTIF = 0x66
+	OP_ELSE     = 0x67
+	OP_VERIFY   = 0x69
+
+	OP_TOALTSTACK   = 0x6b
+	OP_FROMALTSTACK = 0x6c
+	OP_2DROP        = 0x6d
+	OP_2DUP         = 0x6e
+	OP_3DUP         = 0x6f
+	OP_2OVER        = 0x70
+	OP_2ROT         = 0x71
+	OP_2SWAP        = 0x72
+	OP_IFDUP        = 0x73
+	OP_DEPTH        = 0x74
+	OP_DROP         = 0x75
+	OP_DUP          = 0x76
+	OP_NIP          = 0x77
+	OP_OVER         = 0x78
+	OP_PICK         = 0x79
+	OP_ROLL         = 0x7a
+	OP_ROT          = 0x7b
+	OP_SWAP         = 0x7c
+	OP_TUCK         = 0x7d
+
+	OP_CAT    = 0x7e
+	OP_SUBSTR = 0x7f
+	OP_LEFT   = 0x80
+	OP_RIGHT  = 0x81
+	OP_SIZE   = 0x82
+	OP_INVERT = 0x83
+	OP_AND    = 0x84
+	OP_OR     = 0x85
+	OP_XOR    = 0x86
+
+	OP_EQUAL       = 0x87
+	OP_EQUALVERIFY = 0x88
+	OP_RESERVED1   = 0x89
+	OP_RESERVED2   = 0x8a
+
+	OP_1ADD               = 0x8b
+	OP_1SUB               = 0x8c
+	OP_2MUL               = 0x8d
+	OP_2DIV               = 0x8e
+	OP_NEGATE             = 0x8f
+	OP_ABS                = 0x90
+	OP_NOT                = 0x91
+	OP_0NOTEQUAL          = 0x92
+	OP_ADD                = 0x93
+	OP_SUB                = 0x94
+	OP_MUL                = 0x95
+	OP_DIV                = 0x96
+	OP_MOD                = 0x97
+	OP_LSHIFT             = 0x98
+	OP_RSHIFT             = 0x99
+	OP_BOOLAND            = 0x9a
+	OP_BOOLOR             = 0x9b
+	OP_NUMEQUAL           = 0x9c
+	OP_NUMEQUALVERIFY     = 0x9d
+	OP_NUMNOTEQUAL        = 0x9e
+	OP_LESSTHAN           = 0x9f
+	OP_GREATERTHAN        = 0xa0
+	OP_LESSTHANOREQUAL    = 0xa1
+	OP_GREATERTHANOREQUAL = 0xa2
+	OP_MIN                = 0xa3
+	OP_MAX                = 0xa4
+	OP_WITHIN             = 0xa5
+
+	OP_RIPEMD160           = 0xa6
+	OP_SHA1                = 0xa7
+	OP_SHA256              = 0xa8
+	OP_HASH160             = 0xa9
+	OP_HASH256             = 0xaa
+	OP_CODESEPARATOR       = 0xab
+	OP_CHECKSIG            = 0xac
+	OP_CHECKSIGVERIFY      = 0xad
+	OP_CHECKMULTISIG       = 0xae
+	OP_CHECKMULTISIGVERIFY = 0xaf
+
+	OP_NOP1                = 0xb0
+	OP_CHECKLOCKTIMEVERIFY = 0xb1
+	OP_CHECKSEQUENCEVERIFY = 0xb2
+	OP_NOP4                = 0xb3
+	OP_NOP10               = 0xb9
+	OP_CHECKSIGADD         = 0xba
+)
+
+// Consensus limits
+const (
+	maxScriptSize         = 10000
+	maxScriptElementSize  = 520
+	maxOpsPerScript       = 201
+	maxStackSize          = 1000
+	maxPubKeysPerMultisig = 20
+	maxTaprootDepth       = 128
+	sigOpsBudgetOffset    = 50 // BIP342 validation weight
+	sigOpsBudgetPerSigOp  = 50
+	lockTimeThreshold     = 500000000
+
+	TapLeafVersion = 0xc0 // the only one defined, BIP342
+)
+
+// ScriptFlags selects the soft forks in effect.
+type ScriptFlags uint32
+
+const (
+	ScriptVerifyP2SH ScriptFlags = 1 << iota
+	ScriptVerifyCheckLockTime
+	ScriptVerifyCheckSequence
+	ScriptVerifyWitness
+	ScriptVerifyTaproot
+	ScriptVerifyDERSig    // BIP66, strict DER signatures
+	ScriptVerifyNullDummy // BIP147, the multisig dummy is empty
+
+	ScriptVerifyAll = ScriptVerifyP2SH | ScriptVerifyCheckLockTime | ScriptVerifyCheckSequence |
+		ScriptVerifyWitness | ScriptVerifyTaproot | ScriptVerifyDERSig | ScriptVerifyNullDummy
+)
+
+// ScriptFlagsAt returns the flags in effect at the mainnet height.
+func ScriptFlagsAt(height int) ScriptFlags {
+	var flags ScriptFlags
+	for _, sf := range []struct {
+		height int
+		flag   ScriptFlags
+	}{
+		{173805, ScriptVerifyP2SH}, // BIP16 switched by time, about here
+		{363725, ScriptVerifyDERSig},
+		{388381, ScriptVerifyCheckLockTime},
+		{419328, ScriptVerifyCheckSequence},
+		{481824, ScriptVerifyWitness | ScriptVerifyNullDummy},
+		{709632, ScriptVerifyTaproot},
+	} {
+		if height >= sf.height {
+			flags |= sf.flag
+		}
+	}
+	return flags
+}
+
+// SigVersion is the signature scheme of the script being executed.
+type SigVersion int
+
+const (
+	SigVersionBase      SigVersion = iota // legacy and P2SH
+	SigVersionWitnessV0                   // BIP143
+	SigVersionTaproot                     // key path, BIP341
+	SigVersionTapscript                   // script path, BIP342
+)
+
+// SigContext is what a signature commits to besides the tx and the
+// input, as far as the interpreter knows it.
+type SigContext struct {
+	Version SigVersion
+	// Legacy and witness v0: the script from the last executed
+	// OP_CODESEPARATOR on. For legacy the signatures are removed from
+	// it (FindAndDelete), OP_CODESEPARATORs are not.
+	ScriptCode []byte
+	// Tapscript: the leaf hash and the position (in opcodes) of the
+	// last executed OP_CODESEPARATOR, 0xffffffff if none.
+	LeafHash   Uint256
+	CodeSepPos uint32
+	// Taproot and tapscript: the annex, nil if none.
+	Annex []byte
+}
+
+// A SigChecker does the elliptic curve part of the validation of an
+// input.
+type SigChecker interface {
+	// CheckSig tells whether sig (including the hash type byte, if
+	// any) is a valid signature of the input by pubKey, ECDSA for
+	// SigVersionBase and SigVersionWitnessV0, Schnorr (with a 32 byte
+	// pubKey) for SigVersionTaproot and SigVersionTapscript.
+	CheckSig(sig, pubKey []byte, ctx *SigContext) bool
+	// CheckTapTweak tells whether outputKey is internalKey tweaked
+	// with merkleRoot (nil for no script path) and has an odd Y if
+	// oddY (BIP341).
+	CheckTapTweak(outputKey, internalKey []byte, merkleRoot *Uint256, oddY bool) bool
+}
+
+// ErrScriptFailed is wrapped by the errors of invalid inputs.
+var ErrScriptFailed = errors.New("Script failed")
+
+func scriptFailed(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrScriptFailed, fmt.Sprintf(format, args...))
+}
+
+// VerifyScript validates input n of tx against the output it spends,
+// with the given soft forks in effect. Returns an error wrapping
+// ErrScriptFailed if the input is invalid.
+func VerifyScript(tx *Tx, n int, prevOut *TxOut, flags ScriptFlags, checker SigChecker) error {
+	if n < 0 || n >= len(tx.TxIns) {
+		return fmt.Errorf("No input %d in tx %v", n, tx.Hash())
+	}
+	in := tx.TxIns[n]
+	scriptSig, scriptPubKey := in.ScriptSig, prevOut.ScriptPubKey
+
+	e := &scriptEngine{tx: tx, n: n, flags: flags, checker: checker}
+
+	if flags&ScriptVerifyP2SH != 0 && isP2SH(scriptPubKey) && !isPushOnly(scriptSig) {
+		return scriptFailed("P2SH scriptsig is not push only")
+	}
+
+	if err := e.eval(scriptSig, SigVersionBase); err != nil {
+		return err
+	}
+	stackCopy := append([][]byte{}, e.stack...)
+	if err := e.eval(scriptPubKey, SigVersionBase); err != nil {
+		return err
+	}
+	if len(e.stack) == 0 || !castToBool(e.top(0)) {
+		return scriptFailed("scriptpubkey evaluated to false")
+	}
+
+	hadWitness := false
+	if flags&ScriptVerifyWitness != 0 {
+		if version, program, ok := witnessProgram(scriptPubKey); ok {
+			hadWitness = true
+			if len(scriptSig) != 0 {
+				return scriptFailed("scriptsig of a native witness program is not empty")
+			}
+			if err := e.verifyWitnessProgram(in.Witness, version, program, false); err != nil {
+				return err
+			}
+		}
+	}
+
+	if flags&ScriptVerifyP2SH != 0 && isP2SH(scriptPubKey) {
+		e.stack = stackCopy
+		redeem := e.top(0)
+		e.pop()
+		if err := e.eval(redeem, SigVersionBase); err != nil {
+			return err
+		}
+		if len(e.stack) == 0 || !castToBool(e.top(0)) {
+			return scriptFailed("redeem script evaluated to false")
+		}
+		if flags&ScriptVerifyWitness != 0 {
+			if version, program, ok := witnessProgram(redeem); ok {
+				hadWitness = true
+				var push bytes.Buffer
+				pushData(&push, redeem)
+				if !bytes.Equal(scriptSig, push.Bytes()) {
+					return scriptFailed("scriptsig of a P2SH witness program is not a single push")
+				}
+				if err := e.verifyWitnessProgram(in.Witness, version, program, true); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	if flags&ScriptVerifyWitness != 0 && !hadWitness && len(in.Witness) > 0 {
+		return scriptFailed("unexpected witness")
+	}
+	return nil
+}
+
+func (e *scriptEngine) verifyWitnessProgram(witness Witness, version int, program []byte, p2sh bool) error {
+	stack := make([][]byte, len(witness))
+	for i, item := range witness {
+		stack[i] = item
+	}
+
+	switch {
+	case version == 0 && len(program) == 32: // P2WSH
+		if len(stack) == 0 {
+			return scriptFailed("empty P2WSH witness")
+		}
+		script := stack[len(stack)-1]
+		if h := sha256.Sum256(script); !bytes.Equal(h[:], program) {
+			return scriptFailed("P2WSH witness script does not match the program")
+		}
+		return e.execWitnessScript(stack[:len(stack)-1], script, SigVersionWitnessV0)
+
+	case version == 0 && len(program) == 20: // P2WPKH
+		if len(stack) != 2 {
+			return scriptFailed("P2WPKH witness of %d items", len(stack))
+		}
+		script := append(append([]byte{OP_DUP, OP_HASH160, 20}, program...), OP_EQUALVERIFY, OP_CHECKSIG)
+		return e.execWitnessScript(stack, script, SigVersionWitnessV0)
+
+	case version == 0:
+		return scriptFailed("witness v0 program of %d bytes", len(program))
+
+	case version == 1 && len(program) == 32 && !p2sh && e.flags&ScriptVerifyTaproot != 0:
+		if len(stack) == 0 {
+			return scriptFailed("empty taproot witness")
+		}
+		if len(stack) >= 2 && len(stack[len(stack)-1]) > 0 && stack[len(stack)-1][0] == ANNEX_TAG {
+			e.annex = stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+		}
+		if len(stack) == 1 { // key path
+			ctx := &SigContext{Version: SigVersionTaproot, CodeSepPos: 0xffffffff, Annex: e.annex}
+			if !e.checker.CheckSig(stack[0], program, ctx) {
+				return scriptFailed("invalid taproot key path signature")
+			}
+			return nil
+		}
+
+		control, script := stack[len(stack)-1], stack[len(stack)-2]
+		stack = stack[:len(stack)-2]
+		if len(control) < 33 || (len(control)-33)%32 != 0 || (len(control)-33)/32 > maxTaprootDepth {
+			return scriptFailed("control block of %d bytes", len(control))
+		}
+		leafVersion := control[0] &^ 1
+		leafHash := TapLeafHash(leafVersion, script)
+		root := leafHash
+		for i := 33; i < len(control); i += 32 {
+			root = TapBranchHash(root[:], control[i:i+32])
+		}
+		if !e.checker.CheckTapTweak(program, control[1:33], &root, control[0]&1 == 1) {
+			return scriptFailed("taproot commitment does not match the program")
+		}
+		if leafVersion != TapLeafVersion {
+			return nil // unknown leaf versions are anyone can spend
+		}
+		if hasOpSuccess(script) {
+			return nil
+		}
+		e.leafHash = leafHash
+		e.sigOpsBudget = witness.Size() + sigOpsBudgetOffset
+		return e.execWitnessScript(stack, script, SigVersionTapscript)
+	}
+	return nil // future versions are anyone can spend
+}
+
+// The witness scripts must leave exactly one true item.
+func (e *scriptEngine) execWitnessScript(stack [][]byte, script []byte, version SigVersion) error {
+	if version == SigVersionTapscript && len(stack) > maxStackSize {
+		return scriptFailed("witness stack of %d items", len(stack))
+	}
+	for _, item := range stack {
+		if len(item) > maxScriptElementSize {
+			return scriptFailed("witness item of %d bytes", len(item))
+		}
+	}
+	e.stack = append([][]byte{}, stack...)
+	if err := e.eval(script, version); err != nil {
+		return err
+	}
+	if len(e.stack) != 1 {
+		return scriptFailed("witness script left %d items", len(e.stack))
+	}
+	if !castToBool(e.top(0)) {
+		return scriptFailed("witness script evaluated to false")
+	}
+	return nil
+}
+
+type scriptEngine struct {
+	tx      *Tx
+	n       int
+	flags   ScriptFlags
+	checker SigChecker
+
+	stack        [][]byte
+	alt          [][]byte
+	annex        []byte
+	leafHash     Uint256
+	sigOpsBudget int // tapscript
+}
+
+func (e *scriptEngine) top(i int) []byte {
+	return e.stack[len(e.stack)-1-i]
+}
+
+func (e *scriptEngine) pop() []byte {
+	item := e.stack[len(e.stack)-1]
+	e.stack = e.stack[:len(e.stack)-1]
+	return item
+}
+
+func (e *scriptEngine) push(item []byte) {
+	e.stack = append(e.stack, item)
+}
+
+func (e *scriptEngine) pushBool(b bool) {
+	if b {
+		e.push([]byte{1})
+	} else {
+		e.push(nil)
+	}
+}
+
+func (e *scriptEngine) need(n int, op byte) error {
+	if len(e.stack) < n {
+		return scriptFailed("opcode 0x%02x with %d stack items", op, len(e.stack))
+	}
+	return nil
+}
+
+func (e *scriptEngine) popNum(maxLen int) (int64, error) {
+	v, err := scriptNum(e.top(0), maxLen)
+	if err != nil {
+		return 0, err
+	}
+	e.pop()
+	return v, nil
+}
+
+// eval executes script on the stack.
+func (e *scriptEngine) eval(script []byte, version SigVersion) error {
+	tapscript := version == SigVersionTapscript
+	if !tapscript && len(script) > maxScriptSize {
+		return scriptFailed("script of %d bytes", len(script))
+	}
+
+	var (
+		exec       []bool // the IF/ELSE conditions
+		codeSep    = 0    // byte offset after the last OP_CODESEPARATOR
+		codeSepPos = uint32(0xffffffff)
+		opCount    = 0
+	)
+
+	for pos, opIdx := 0, 0; pos < len(script); opIdx++ {
+		op, data, next, err := nextOp(script, pos)
+		if err != nil {
+			return scriptFailed("%v", err)
+		}
+		start := pos
+		pos = next
+
+		if len(data) > maxScriptElementSize {
+			return scriptFailed("push of %d bytes", len(data))
+		}
+		if !tapscript && op > OP_16 {
+			if opCount++; opCount > maxOpsPerScript {
+				return scriptFailed("more than %d opcodes", maxOpsPerScript)
+			}
+		}
+		switch op {
+		case OP_CAT, OP_SUBSTR, OP_LEFT, OP_RIGHT, OP_INVERT, OP_AND, OP_OR, OP_XOR,
+			OP_2MUL, OP_2DIV, OP_MUL, OP_DIV, OP_MOD, OP_LSHIFT, OP_RSHIFT, OP_VERIF, OP_VERNOTIF:
+			return scriptFailed("disabled opcode 0x%02x", op)
+		}
+
+		if !executingAll(exec) && (op < OP_IF || op > OP_ENDIF) {
+			continue
+		}
+
+		switch {
+		case op <= OP_PUSHDATA4:
+			e.push(data)
+		case op == OP_1NEGATE:
+			e.push([]byte{0x81})
+		case op >= OP_1 && op <= OP_16:
+			e.push([]byte{op - OP_1 + 1})
+		default:
+			if err := e.exec(op, script, start, codeSep, codeSepPos, version, &exec); err != nil {
+				return err
+			}
+			if op == OP_CODESEPARATOR {
+				codeSep, codeSepPos = pos, uint32(opIdx)
+			}
+		}
+
+		if len(e.stack)+len(e.alt) > maxStackSize {
+			return scriptFailed("stack of more than %d items", maxStackSize)
+		}
+	}
+	if len(exec) != 0 {
+		return scriptFailed("unbalanced conditional")
+	}
+	e.alt = nil
+	return nil
+}
+
+// exec executes a non-push op (start is its offset in script).
+func (e *scriptEngine) exec(op byte, script []byte, start, codeSep int, codeSepPos uint32, version SigVersion, exec *[]bool) error {
+	tapscript := version == SigVersionTapscript
+
+	switch op {
+	case OP_NOP, OP_NOP1, OP_NOP4, OP_NOP4 + 1, OP_NOP4 + 2, OP_NOP4 + 3, OP_NOP4 + 4, OP_NOP4 + 5, OP_NOP10:
+	case OP_CODESEPARATOR:
+
+	case OP_IF, OP_NOTIF:
+		cond := false
+		if executingAll(*exec) {
+			if err := e.need(1, op); err != nil {
+				return err
+			}
+			top := e.pop()
+			if tapscript && (len(top) > 1 || len(top) == 1 && top[0] != 1) {
+				return scriptFailed("OP_IF argument not minimal in tapscript")
+			}
+			cond = castToBool(top)
+			if op == OP_NOTIF {
+				cond = !cond
+			}
+		}
+		*exec = append(*exec, cond)
+	case OP_ELSE:
+		if len(*exec) == 0 {
+			return scriptFailed("OP_ELSE without OP_IF")
+		}
+		(*exec)[len(*exec)-1] = !(*exec)[len(*exec)-1]
+	case OP_ENDIF:
+		if len(*exec) == 0 {
+			return scriptFailed("OP_ENDIF without OP_IF")
+		}
+		*exec = (*exec)[:len(*exec)-1]
+
+	case OP_VERIFY:
+		if err := e.need(1, op); err != nil {
+			return err
+		}
+		if !castToBool(e.pop()) {
+			return scriptFailed("OP_VERIFY failed")
+		}
+	case OP_RETURN:
+		return scriptFailed("OP_RETURN")
+
+	case OP_TOALTSTACK:
+		if err := e.need(1, op); err != nil {
+			return err
+		}
+		e.alt = append(e.alt, e.pop())
+	case OP_FROMALTSTACK:
+		if len(e.alt) == 0 {
+			return scriptFailed("OP_FROMALTSTACK with an empty alt stack")
+		}
+		e.push(e.alt[len(e.alt)-1])
+		e.alt = e.alt[:len(e.alt)-1]
+	case OP_2DROP:
+		if err := e.need(2, op); err != nil {
+			return err
+		}
+		e.stack = e.stack[:len(e.stack)-2]
+	case OP_2DUP:
+		if err := e.need(2, op); err != nil {
+			return err
+		}
+		e.stack = append(e.stack, e.top(1), e.top(0))
+	case OP_3DUP:
+		if err := e.need(3, op); err != nil {
+			return err
+		}
+		e.stack = append(e.stack, e.top(2), e.top(1), e.top(0))
+	case OP_2OVER:
+		if err := e.need(4, op); err != nil {
+			return err
+		}
+		e.stack = append(e.stack, e.top(3), e.top(2))
+	case OP_2ROT:
+		if err := e.need(6, op); err != nil {
+			return err
+		}
+		i := len(e.stack) - 6
+		a, b := e.stack[i], e.stack[i+1]
+		e.stack = append(append(e.stack[:i:i], e.stack[i+2:]...), a, b)
+	case OP_2SWAP:
+		if err := e.need(4, op); err != nil {
+			return err
+		}
+		s, i := e.stack, len(e.stack)-4
+		s[i], s[i+1], s[i+2], s[i+3] = s[i+2], s[i+3], s[i], s[i+1]
+	case OP_IFDUP:
+		if err := e.need(1, op); err != nil {
+			return err
+		}
+		if castToBool(e.top(0)) {
+			e.push(e.top(0))
+		}
+	case OP_DEPTH:
+		e.push(numBytes(int64(len(e.stack))))
+	case OP_DROP:
+		if err := e.need(1, op); err != nil {
+			return err
+		}
+		e.pop()
+	case OP_DUP:
+		if err := e.need(1, op); err != nil {
+			return err
+		}
+		e.push(e.top(0))
+	case OP_NIP:
+		if err := e.need(2, op); err != nil {
+			return err
+		}
+		top := e.pop()
+		e.stack[len(e.stack)-1] = top
+	case OP_OVER:
+		if err := e.need(2, op); err != nil {
+			return err
+		}
+		e.push(e.top(1))
+	case OP_PICK, OP_ROLL:
+		if err := e.need(2, op); err != nil {
+			return err
+		}
+		n, err := e.popNum(4)
+		if err != nil {
+			return err
+		}
+		if n < 0 || int(n) >= len(e.stack) {
+			return scriptFailed("OP_PICK/OP_ROLL of %d", n)
+		}
+		i := len(e.stack) - 1 - int(n)
+		item := e.stack[i]
+		if op == OP_ROLL {
+			e.stack = append(e.stack[:i], e.stack[i+1:]...)
+		}
+		e.push(item)
+	case OP_ROT:
+		if err := e.need(3, op); err != nil {
+			return err
+		}
+		s, i := e.stack, len(e.stack)-3
+		s[i], s[i+1], s[i+2] = s[i+1], s[i+2], s[i]
+	case OP_SWAP:
+		if err := e.need(2, op); err != nil {
+			return err
+		}
+		s, i := e.stack, len(e.stack)-2
+		s[i], s[i+1] = s[i+1], s[i]
+	case OP_TUCK:
+		if err := e.need(2, op); err != nil {
+			return err
+		}
+		top := e.top(0)
+		i := len(e.stack) - 2
+		e.stack = append(e.stack[:i], append([][]byte{top}, e.stack[i:]...)...)
+	case OP_SIZE:
+		if err := e.need(1, op); err != nil {
+			return err
+		}
+		e.push(numBytes(int64(len(e.top(0)))))
+
+	case OP_EQUAL, OP_EQUALVERIFY:
+		if err := e.need(2, op); err != nil {
+			return err
+		}
+		eq := bytes.Equal(e.pop(), e.pop())
+		if op == OP_EQUALVERIFY {
+			if !eq {
+				return scriptFailed("OP_EQUALVERIFY failed")
+			}
+		} else {
+			e.pushBool(eq)
+		}
+
+	case OP_1ADD, OP_1SUB, OP_NEGATE, OP_ABS, OP_NOT, OP_0NOTEQUAL:
+		if err := e.need(1, op); err != nil {
+			return err
+		}
+		a, err := e.popNum(4)
+		if err != nil {
+			return err
+		}
+		switch op {
+		case OP_1ADD:
+			a++
+		case OP_1SUB:
+			a--
+		case OP_NEGATE:
+			a = -a
+		case OP_ABS:
+			if a < 0 {
+				a = -a
+			}
+		case OP_NOT:
+			a = boolNum(a == 0)
+		case OP_0NOTEQUAL:
+			a = boolNum(a != 0)
+		}
+		e.push(numBytes(a))
+
+	case OP_ADD, OP_SUB, OP_BOOLAND, OP_BOOLOR, OP_NUMEQUAL, OP_NUMEQUALVERIFY, OP_NUMNOTEQUAL,
+		OP_LESSTHAN, OP_GREATERTHAN, OP_LESSTHANOREQUAL, OP_GREATERTHANOREQUAL, OP_MIN, OP_MAX:
+		if err := e.need(2, op); err != nil {
+			return err
+		}
+		b, err := e.popNum(4)
+		if err != nil {
+			return err
+		}
+		a, err := e.popNum(4)
+		if err != nil {
+			return err
+		}
+		var r int64
+		switch op {
+		case OP_ADD:
+			r = a + b
+		case OP_SUB:
+			r = a - b
+		case OP_BOOLAND:
+			r = boolNum(a != 0 && b != 0)
+		case OP_BOOLOR:
+			r = boolNum(a != 0 || b != 0)
+		case OP_NUMEQUAL, OP_NUMEQUALVERIFY:
+			r = boolNum(a == b)
+		case OP_NUMNOTEQUAL:
+			r = boolNum(a != b)
+		case OP_LESSTHAN:
+			r = boolNum(a < b)
+		case OP_GREATERTHAN:
+			r = boolNum(a > b)
+		case OP_LESSTHANOREQUAL:
+			r = boolNum(a <= b)
+		case OP_GREATERTHANOREQUAL:
+			r = boolNum(a >= b)
+		case OP_MIN:
+			if r = a; b < a {
+				r = b
+			}
+		case OP_MAX:
+			if r = a; b > a {
+				r = b
+			}
+		}
+		if op == OP_NUMEQUALVERIFY {
+			if r == 0 {
+				return scriptFailed("OP_NUMEQUALVERIFY failed")
+			}
+		} else {
+			e.push(numBytes(r))
+		}
+	case OP_WITHIN:
+		if err := e.need(3, op); err != nil {
+			return err
+		}
+		max, err := e.popNum(4)
+		if err != nil {
+			return err
+		}
+		min, err := e.popNum(4)
+		if err != nil {
+			return err
+		}
+		x, err := e.popNum(4)
+		if err != nil {
+			return err
+		}
+		e.pushBool(min <= x && x < max)
+
+	case OP_RIPEMD160, OP_SHA1, OP_SHA256, OP_HASH160, OP_HASH256:
+		if err := e.need(1, op); err != nil {
+			return err
+		}
+		item := e.pop()
+		switch op {
+		case OP_RIPEMD160:
+			h := ripemd160.New()
+			h.Write(item)
+			e.push(h.Sum(nil))
+		case OP_SHA1:
+			h := sha1.Sum(item)
+			e.push(h[:])
+		case OP_SHA256:
+			h := sha256.Sum256(item)
+			e.push(h[:])
+		case OP_HASH160:
+			e.push(Hash160(item))
+		case OP_HASH256:
+			h := ShaSha256(item)
+			e.push(h[:])
+		}
+
+	case OP_CHECKSIG, OP_CHECKSIGVERIFY:
+		if err := e.need(2, op); err != nil {
+			return err
+		}
+		pubKey, sig := e.pop(), e.pop()
+		scriptCode := script[codeSep:]
+		if version == SigVersionBase {
+			scriptCode = findAndDelete(scriptCode, sig)
+		}
+		ok, err := e.checkSig(sig, pubKey, scriptCode, codeSepPos, version)
+		if err != nil {
+			return err
+		}
+		if op == OP_CHECKSIGVERIFY {
+			if !ok {
+				return scriptFailed("OP_CHECKSIGVERIFY failed")
+			}
+		} else {
+			e.pushBool(ok)
+		}
+
+	case OP_CHECKSIGADD:
+		if !tapscript {
+			return scriptFailed("OP_CHECKSIGADD outside of tapscript")
+		}
+		if err := e.need(3, op); err != nil {
+			return err
+		}
+		pubKey := e.pop()
+		n, err := scriptNum(e.top(0), 4)
+		if err != nil {
+			return err
+		}
+		e.pop()
+		sig := e.pop()
+		ok, err := e.checkSig(sig, pubKey, nil, codeSepPos, version)
+		if err != nil {
+			return err
+		}
+		if ok {
+			n++
+		}
+		e.push(numBytes(n))
+
+	case OP_CHECKMULTISIG, OP_CHECKMULTISIGVERIFY:
+		if tapscript {
+			return scriptFailed("OP_CHECKMULTISIG in tapscript")
+		}
+		ok, err := e.checkMultisig(op, script[codeSep:], version)
+		if err != nil {
+			return err
+		}
+		if op == OP_CHECKMULTISIGVERIFY {
+			if !ok {
+				return scriptFailed("OP_CHECKMULTISIGVERIFY failed")
+			}
+		} else {
+			e.pushBool(ok)
+		}
+
+	case OP_CHECKLOCKTIMEVERIFY:
+		if e.flags&ScriptVerifyCheckLockTime == 0 {
+			return nil // OP_NOP2
+		}
+		if err := e.need(1, op); err != nil {
+			return err
+		}
+		lockTime, err := scriptNum(e.top(0), 5)
+		if err != nil {
+			return err
+		}
+		txLockTime := int64(e.tx.LockTime)
+		switch {
+		case lockTime < 0:
+			return scriptFailed("negative locktime")
+		case (lockTime < lockTimeThreshold) != (txLockTime < lockTimeThreshold):
+			return scriptFailed("locktime of a different type")
+		case lockTime > txLockTime:
+			return scriptFailed("locktime %d not reached", lockTime)
+		case e.tx.TxIns[e.n].Sequence == 0xffffffff:
+			return scriptFailed("locktime with a final input")
+		}
+
+	case OP_CHECKSEQUENCEVERIFY:
+		if e.flags&ScriptVerifyCheckSequence == 0 {
+			return nil // OP_NOP3
+		}
+		if err := e.need(1, op); err != nil {
+			return err
+		}
+		seq, err := scriptNum(e.top(0), 5)
+		if err != nil {
+			return err
+		}
+		if seq < 0 {
+			return scriptFailed("negative sequence")
+		}
+		if seq&(1<<31) != 0 {
+			return nil // disabled
+		}
+		txSeq := int64(e.tx.TxIns[e.n].Sequence)
+		const typeFlag, mask = 1 << 22, 1<<22 | 0xffff
+		switch {
+		case e.tx.Version < 2:
+			return scriptFailed("sequence lock in a version %d tx", e.tx.Version)
+		case txSeq&(1<<31) != 0:
+			return scriptFailed("sequence lock with disabled input sequence")
+		case seq&typeFlag != txSeq&typeFlag:
+			return scriptFailed("sequence lock of a different type")
+		case seq&mask > txSeq&mask:
+			return scriptFailed("sequence lock %d not reached", seq&mask)
+		}
+
+	default:
+		// OP_VER, OP_RESERVED... and undefined (in tapscript these are
+		// OP_SUCCESSx, which never get here)
+		return scriptFailed("bad opcode 0x%02x", op)
+	}
+	return nil
+}
+
+func executingAll(exec []bool) bool {
+	for _, c := range exec {
+		if !c {
+			return false
+		}
+	}
+	return true
+}
+
+func (e *scriptEngine) checkSig(sig, pubKey, scriptCode []byte, codeSepPos uint32, version SigVersion) (bool, error) {
+	if version != SigVersionTapscript {
+		if len(sig) == 0 {
+			return false, nil
+		}
+		if e.flags&ScriptVerifyDERSig != 0 && !isValidDER(sig[:len(sig)-1]) {
+			return false, scriptFailed("signature is not strict DER")
+		}
+		ctx := &SigContext{Version: version, ScriptCode: scriptCode}
+		return e.checker.CheckSig(sig, pubKey, ctx), nil
+	}
+
+	// BIP342
+	switch {
+	case len(pubKey) == 0:
+		return false, scriptFailed("empty public key in tapscript")
+	case len(sig) == 0:
+		return false, nil
+	}
+	if e.sigOpsBudget -= sigOpsBudgetPerSigOp; e.sigOpsBudget < 0 {
+		return false, scriptFailed("tapscript signature operations budget exceeded")
+	}
+	if len(pubKey) != 32 {
+		return true, nil // unknown public key type
+	}
+	ctx := &SigContext{Version: version, LeafHash: e.leafHash, CodeSepPos: codeSepPos, Annex: e.annex}
+	if !e.checker.CheckSig(sig, pubKey, ctx) {
+		return false, scriptFailed("invalid tapscript signature")
+	}
+	return true, nil
+}
+
+func (e *scriptEngine) checkMultisig(op byte, scriptCode []byte, version SigVersion) (bool, error) {
+	if err := e.need(1, op); err != nil {
+		return false, err
+	}
+	nKeys, err := e.popNum(4)
+	if err != nil {
+		return false, err
+	}
+	if nKeys < 0 || nKeys > maxPubKeysPerMultisig {
+		return false, scriptFailed("multisig with %d keys", nKeys)
+	}
+	if err := e.need(int(nKeys)+1, op); err != nil {
+		return false, err
+	}
+	keys := make([][]byte, nKeys)
+	for i := range keys {
+		keys[i] = e.pop()
+	}
+	nSigs, err := e.popNum(4)
+	if err != nil {
+		return false, err
+	}
+	if nSigs < 0 || nSigs > nKeys {
+		return false, scriptFailed("multisig with %d of %d signatures", nSigs, nKeys)
+	}
+	if err := e.need(int(nSigs)+1, op); err != nil { // one more for the dummy
+		return false, err
+	}
+	sigs := make([][]byte, nSigs)
+	for i := range sigs {
+		sigs[i] = e.pop()
+	}
+	if dummy := e.pop(); len(dummy) != 0 && e.flags&ScriptVerifyNullDummy != 0 { // Satoshi's off by one
+		return false, scriptFailed("multisig dummy is not empty")
+	}
+
+	if version == SigVersionBase {
+		for _, sig := range sigs {
+			scriptCode = findAndDelete(scriptCode, sig)
+		}
+	}
+
+	// The signatures in the order of the keys, every key checked at
+	// most once.
+	for i, k := 0, 0; i < len(sigs); k++ {
+		if len(keys)-k < len(sigs)-i {
+			return false, nil
+		}
+		ok, err := e.checkSig(sigs[i], keys[k], scriptCode, 0xffffffff, version)
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			i++
+		}
+	}
+	return true, nil
+}
+
+// findAndDelete removes the pushes of data from script.
+func findAndDelete(script, data []byte) []byte {
+	if len(data) == 0 {
+		return script
+	}
+	var push bytes.Buffer
+	pushData(&push, data)
+	p := push.Bytes()
+	if !bytes.Contains(script, p) {
+		return script
+	}
+	result := make([]byte, 0, len(script))
+	for pos := 0; pos < len(script); {
+		if bytes.HasPrefix(script[pos:], p) {
+			pos += len(p)
+			continue
+		}
+		_, _, next, err := nextOp(script, pos)
+		if err != nil {
+			return append(result, script[pos:]...)
+		}
+		result = append(result, script[pos:next]...)
+		pos = next
+	}
+	return result
+}
+
+func pushData(buf *bytes.Buffer, data []byte) {
+	switch n := len(data); {
+	case n < OP_PUSHDATA1:
+		buf.WriteByte(byte(n))
+	case n <= 0xff:
+		buf.Write([]byte{OP_PUSHDATA1, byte(n)})
+	case n <= 0xffff:
+		buf.Write([]byte{OP_PUSHDATA2, byte(n), byte(n >> 8)})
+	default:
+		buf.Write([]byte{OP_PUSHDATA4, byte(n), byte(n >> 8), byte(n >> 16), byte(n >> 24)})
+	}
+	buf.Write(data)
+}
+
+func isPushOnly(script []byte) bool {
+	for pos := 0; pos < len(script); {
+		op, _, next, err := nextOp(script, pos)
+		if err != nil || op > OP_16 {
+			return false
+		}
+		pos = next
+	}
+	return true
+}
+
+func isP2SH(script []byte) bool {
+	return len(script) == 23 && script[0] == OP_HASH160 && script[1] == 20 && script[22] == OP_EQUAL
+}
+
+// witnessProgram returns the version and program of a witness output
+// script (BIP141).
+func witnessProgram(script []byte) (int, []byte, bool) {
+	if len(script) < 4 || len(script) > 42 || int(script[1])+2 != len(script) {
+		return 0, nil, false
+	}
+	switch {
+	case script[0] == OP_0:
+		return 0, script[2:], true
+	case script[0] >= OP_1 && script[0] <= OP_16:
+		return int(script[0]-OP_1) + 1, script[2:], true
+	}
+	return 0, nil, false
+}
+
+// OP_SUCCESSx (BIP342) make a tapscript succeed, if it parses.
+func hasOpSuccess(script []byte) bool {
+	for pos := 0; pos < len(script); {
+		op, _, next, err := nextOp(script, pos)
+		if err != nil {
+			return false
+		}
+		if op == 80 || op == 98 || op >= 126 && op <= 129 || op >= 131 && op <= 134 ||
+			op >= 137 && op <= 138 || op >= 141 && op <= 142 || op >= 149 && op <= 153 || op >= 187 && op <= 254 {
+			return true
+		}
+		pos = next
+	}
+	return false
+}
+
+func castToBool(b []byte) bool {
+	for i, c := range b {
+		if c != 0 {
+			return !(i == len(b)-1 && c == 0x80) // negative zero
+		}
+	}
+	return false
+}
+
+// scriptNum decodes a number of at most maxLen bytes, little-endian
+// with the sign in the top bit.
+func scriptNum(b []byte, maxLen int) (int64, error) {
+	if len(b) > maxLen {
+		return 0, scriptFailed("number of %d bytes", len(b))
+	}
+	if len(b) == 0 {
+		return 0, nil
+	}
+	var v int64
+	for i, c := range b {
+		v |= int64(c) << (8 * i)
+	}
+	if b[len(b)-1]&0x80 != 0 {
+		v &^= int64(0x80) << (8 * (len(b) - 1))
+		v = -v
+	}
+	return v, nil
+}
+
+func numBytes(v int64) []byte {
+	if v == 0 {
+		return nil
+	}
+	neg := v < 0
+	if neg {
+		v = -v
+	}
+	var b []byte
+	for v > 0 {
+		b = append(b, byte(v))
+		v >>= 8
+	}
+	if b[len(b)-1]&0x80 != 0 {
+		if neg {
+			b = append(b, 0x80)
+		} else {
+			b = append(b, 0)
+		}
+	} else if neg {
+		b[len(b)-1] |= 0x80
+	}
+	return b
+}
+
+func boolNum(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// Hash160 is RIPEMD160(SHA256(b)).
+func Hash160(b []byte) []byte {
+	s := sha256.Sum256(b)
+	h := ripemd160.New()
+	h.Write(s[:])
+	return h.Sum(nil)
+}
+
+// TaggedHash is the BIP340 tagged hash of the concatenated msgs.
+func TaggedHash(tag string, msgs ...[]byte) Uint256 {
+	t := sha256.Sum256([]byte(tag))
+	h := sha256.New()
+	h.Write(t[:])
+	h.Write(t[:])
+	for _, m := range msgs {
+		h.Write(m)
+	}
+	var result Uint256
+	copy(result[:], h.Sum(nil))
+	return result
+}
+
+// TapLeafHash is the BIP341 hash of a script tree leaf.
+func TapLeafHash(leafVersion byte, script []byte) Uint256 {
+	var buf bytes.Buffer
+	buf.WriteByte(leafVersion)
+	writeCompactSize(uint64(len(script)), &buf)
+	buf.Write(script)
+	return TaggedHash("TapLeaf", buf.Bytes())
+}
+
+// TapBranchHash is the BIP341 hash of a script tree branch, the
+// children are sorted.
+func TapBranchHash(a, b []byte) Uint256 {
+	if bytes.Compare(a, b) > 0 {
+		a, b = b, a
+	}
+	return TaggedHash("TapBranch", a, b)
+}
+
+// SigEncodingChecker is a SigChecker which does not verify anything,
+// it accepts every signature and public key which are well encoded:
+// DER signatures (BIP66) with a defined hash type and compressed or
+// uncompressed keys, or BIP340 signatures and x-only keys.
+type SigEncodingChecker struct{}
+
+func (SigEncodingChecker) CheckSig(sig, pubKey []byte, ctx *SigContext) bool {
+	if ctx.Version == SigVersionTaproot || ctx.Version == SigVersionTapscript {
+		switch len(sig) {
+		case 64:
+		case 65:
+			if ht := sig[64]; ht == 0 || ht&^0x80 > 3 {
+				return false
+			}
+		default:
+			return false
+		}
+		return len(pubKey) == 32
+	}
+	if len(sig) == 0 || !isValidDER(sig[:len(sig)-1]) {
+		return false
+	}
+	if ht := sig[len(sig)-1] &^ 0x80; ht < 1 || ht > 3 {
+		return false
+	}
+	switch {
+	case len(pubKey) == 33 && (pubKey[0] == 2 || pubKey[0] == 3):
+	case len(pubKey) == 65 && pubKey[0] == 4:
+	default:
+		return false
+	}
+	return true
+}
+
+func (SigEncodingChecker) CheckTapTweak(outputKey, internalKey []byte, merkleRoot *Uint256, oddY bool) bool {
+	return len(outputKey) == 32 && len(internalKey) == 32
+}
+
+// isValidDER is IsValidSignatureEncoding of Core, without the hash
+// type.
+func isValidDER(sig []byte) bool {
+	if len(sig) < 8 || len(sig) > 72 || sig[0] != 0x30 || int(sig[1]) != len(sig)-2 {
+		return false
+	}
+	lenR := int(sig[3])
+	if sig[2] != 0x02 || lenR == 0 || 5+lenR >= len(sig) {
+		return false
+	}
+	lenS := int(sig[5+lenR])
+	if sig[4+lenR] != 0x02 || lenS == 0 || lenR+lenS+6 != len(sig) {
+		return false
+	}
+	r, s := sig[4:4+lenR], sig[6+lenR:]
+	for _, n := range [][]byte{r, s} {
+		if n[0]&0x80 != 0 || len(n) > 1 && n[0] == 0 && n[1]&0x80 == 0 {
+			return false
+		}
+	}
+	return true
+}
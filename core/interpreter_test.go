@@ -0,0 +1,251 @@
+package core_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/blkchain/blkchain/core"
+)
+
+// The transaction tests of Core (see testdata/README): every input of
+// the transactions of tx_valid.json must verify, and at least one of
+// each in tx_invalid.json must not, with their signatures checked by
+// TxSigChecker.
+
+func loadJSON(t *testing.T, name string, v interface{}) {
+	b, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+}
+
+func decodeHex(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatalf("%q: %v", s, err)
+	}
+	return b
+}
+
+func readTx(t *testing.T, s string) *core.Tx {
+	var tx core.Tx
+	if err := tx.BinRead(bytes.NewReader(decodeHex(t, s))); err != nil {
+		t.Fatalf("Reading tx %s: %v", s, err)
+	}
+	return &tx
+}
+
+// The names of the opcodes from OP_NOP (0x61) to OP_CHECKSIGADD.
+var opNames = strings.Fields(`NOP VER IF NOTIF VERIF VERNOTIF ELSE ENDIF VERIFY RETURN
+	TOALTSTACK FROMALTSTACK 2DROP 2DUP 3DUP 2OVER 2ROT 2SWAP IFDUP DEPTH DROP DUP NIP
+	OVER PICK ROLL ROT SWAP TUCK CAT SUBSTR LEFT RIGHT SIZE INVERT AND OR XOR EQUAL
+	EQUALVERIFY RESERVED1 RESERVED2 1ADD 1SUB 2MUL 2DIV NEGATE ABS NOT 0NOTEQUAL ADD
+	SUB MUL DIV MOD LSHIFT RSHIFT BOOLAND BOOLOR NUMEQUAL NUMEQUALVERIFY NUMNOTEQUAL
+	LESSTHAN GREATERTHAN LESSTHANOREQUAL GREATERTHANOREQUAL MIN MAX WITHIN RIPEMD160
+	SHA1 SHA256 HASH160 HASH256 CODESEPARATOR CHECKSIG CHECKSIGVERIFY CHECKMULTISIG
+	CHECKMULTISIGVERIFY NOP1 CHECKLOCKTIMEVERIFY CHECKSEQUENCEVERIFY NOP4 NOP5 NOP6
+	NOP7 NOP8 NOP9 NOP10 CHECKSIGADD`)
+
+var opCodes = func() map[string]byte {
+	m := map[string]byte{
+		"PUSHDATA1": core.OP_PUSHDATA1, "PUSHDATA2": core.OP_PUSHDATA2, "PUSHDATA4": core.OP_PUSHDATA4,
+		"1NEGATE": core.OP_1NEGATE, "RESERVED": core.OP_RESERVED, "FALSE": core.OP_0, "TRUE": core.OP_1,
+		"NOP2": core.OP_CHECKLOCKTIMEVERIFY, "NOP3": core.OP_CHECKSEQUENCEVERIFY,
+	}
+	for i, name := range opNames {
+		m[name] = byte(core.OP_NOP + i)
+	}
+	return m
+}()
+
+// pushData appends the minimal push of data.
+func pushData(script, data []byte) []byte {
+	switch n := len(data); {
+	case n < core.OP_PUSHDATA1:
+		script = append(script, byte(n))
+	case n <= 0xff:
+		script = append(script, core.OP_PUSHDATA1, byte(n))
+	case n <= 0xffff:
+		script = binary.LittleEndian.AppendUint16(append(script, core.OP_PUSHDATA2), uint16(n))
+	default:
+		script = binary.LittleEndian.AppendUint32(append(script, core.OP_PUSHDATA4), uint32(n))
+	}
+	return append(script, data...)
+}
+
+// scriptNum is the minimal encoding of a script number.
+func scriptNum(v int64) []byte {
+	neg := v < 0
+	if neg {
+		v = -v
+	}
+	var b []byte
+	for ; v > 0; v >>= 8 {
+		b = append(b, byte(v))
+	}
+	if len(b) > 0 && b[len(b)-1]&0x80 != 0 {
+		b = append(b, 0)
+	}
+	if neg {
+		b[len(b)-1] |= 0x80
+	}
+	return b
+}
+
+// parseScript is ParseScript of Core: numbers, 0x raw bytes, 'string'
+// pushes and opcodes with or without the OP_ prefix.
+func parseScript(t *testing.T, asm string) []byte {
+	script := []byte{}
+	for _, w := range strings.Fields(asm) {
+		if n, err := strconv.ParseInt(w, 10, 64); err == nil {
+			switch {
+			case n == 0:
+				script = append(script, core.OP_0)
+			case n == -1 || n >= 1 && n <= 16:
+				script = append(script, byte(core.OP_1+n-1))
+			default:
+				script = pushData(script, scriptNum(n))
+			}
+			continue
+		}
+		switch {
+		case strings.HasPrefix(w, "0x"):
+			script = append(script, decodeHex(t, w[2:])...)
+		case len(w) >= 2 && w[0] == '\'' && w[len(w)-1] == '\'':
+			script = pushData(script, []byte(w[1:len(w)-1]))
+		default:
+			op, ok := opCodes[strings.TrimPrefix(w, "OP_")]
+			if !ok {
+				t.Fatalf("Unknown opcode %q in %q", w, asm)
+			}
+			script = append(script, op)
+		}
+	}
+	return script
+}
+
+// The flags of the vectors the interpreter knows, the soft forks. The
+// others are policy (standardness), which is not checked.
+var scriptFlagNames = map[string]core.ScriptFlags{
+	"P2SH":                core.ScriptVerifyP2SH,
+	"DERSIG":              core.ScriptVerifyDERSig,
+	"CHECKLOCKTIMEVERIFY": core.ScriptVerifyCheckLockTime,
+	"CHECKSEQUENCEVERIFY": core.ScriptVerifyCheckSequence,
+	"WITNESS":             core.ScriptVerifyWitness,
+	"NULLDUMMY":           core.ScriptVerifyNullDummy,
+	"TAPROOT":             core.ScriptVerifyTaproot,
+}
+
+// hasPolicyFlags tells whether the flags include policy flags, i.e. ones
+// the interpreter does not know.
+func hasPolicyFlags(flags string) bool {
+	for _, name := range strings.Split(flags, ",") {
+		if _, ok := scriptFlagNames[name]; !ok && name != "NONE" && name != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func parseFlags(s string) core.ScriptFlags {
+	var flags core.ScriptFlags
+	for _, name := range strings.Split(s, ",") {
+		flags |= scriptFlagNames[name]
+	}
+	return flags
+}
+
+type txTest struct {
+	prevOuts map[core.OutPoint]*core.TxOut
+	tx       string
+	flags    string
+}
+
+// txTests returns the tests of a tx_valid.json or tx_invalid.json,
+// the entries of one string are comments.
+func txTests(t *testing.T, name string) []*txTest {
+	var entries [][]json.RawMessage
+	loadJSON(t, name, &entries)
+	var tests []*txTest
+	for _, e := range entries {
+		if len(e) == 1 {
+			continue
+		}
+		var (
+			inputs [][]interface{}
+			test   = &txTest{prevOuts: make(map[core.OutPoint]*core.TxOut)}
+		)
+		if len(e) != 3 || json.Unmarshal(e[0], &inputs) != nil ||
+			json.Unmarshal(e[1], &test.tx) != nil || json.Unmarshal(e[2], &test.flags) != nil {
+			t.Fatalf("%s: bad test %s", name, e)
+		}
+		for _, in := range inputs {
+			hash, err := core.Uint256FromString(in[0].(string))
+			if err != nil {
+				t.Fatal(err)
+			}
+			out := &core.TxOut{ScriptPubKey: parseScript(t, in[2].(string))}
+			if len(in) > 3 {
+				out.Value = int64(in[3].(float64))
+			}
+			test.prevOuts[core.OutPoint{Hash: hash, N: uint32(int64(in[1].(float64)))}] = out
+		}
+		tests = append(tests, test)
+	}
+	return tests
+}
+
+// verifyTx returns the error of the first input which does not verify.
+func verifyTx(t *testing.T, test *txTest) error {
+	tx := readTx(t, test.tx)
+	prevOuts := make([]*core.TxOut, len(tx.TxIns))
+	for i, in := range tx.TxIns {
+		if prevOuts[i] = test.prevOuts[in.PrevOut]; prevOuts[i] == nil {
+			t.Fatalf("No prevout for input %d of %s", i, test.tx)
+		}
+	}
+	hasher := core.NewSigHasher(tx, prevOuts)
+	for i := range tx.TxIns {
+		if err := core.VerifyScript(tx, i, prevOuts[i], parseFlags(test.flags), hasher.SigChecker(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestTxValid(t *testing.T) {
+	tests := txTests(t, "tx_valid.json")
+	for _, test := range tests {
+		if err := verifyTx(t, test); err != nil {
+			t.Errorf("Valid tx %s (%s): %v", test.tx, test.flags, err)
+		}
+	}
+	t.Logf("%d valid txs", len(tests))
+}
+
+// An invalid tx may verify if it is invalid only by policy, e.g. the
+// spends of unknown witness programs with
+// DISCOURAGE_UPGRADABLE_WITNESS_PROGRAM.
+func TestTxInvalid(t *testing.T) {
+	tests := txTests(t, "tx_invalid.json")
+	policy := 0
+	for _, test := range tests {
+		if err := verifyTx(t, test); err == nil {
+			if !hasPolicyFlags(test.flags) {
+				t.Errorf("Invalid tx %s (%s) verified", test.tx, test.flags)
+			}
+			policy++
+		}
+	}
+	t.Logf("%d invalid txs, %d only by policy", len(tests), policy)
+}
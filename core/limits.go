@@ -0,0 +1,76 @@
+package core
+
+import (
+	"fmt"
+	"io"
+)
+
+// Limits on what is read, so that a corrupt block file fails with an
+// error rather than a huge allocation or a read until EOF of a bogus
+// count. The defaults are the most a valid block can have, the
+// non-witness data being limited to 1MB and the whole to 4MB (BIP141).
+// Set them before reading anything, they are not synchronized. A zero
+// limit is no limit.
+
+type ParseLimits struct {
+	MaxBlockSize       int // as in the blk*.dat framing, with witness
+	MaxBlockTxs        int
+	MaxScriptSize      int // scriptSig and scriptPubKey
+	MaxWitnessItems    int // per input
+	MaxWitnessItemSize int
+}
+
+const (
+	maxBlockBaseSize = 1_000_000
+	maxBlockSize     = 4_000_000
+	minTxSize        = 60 // without witness, MIN_TRANSACTION_WEIGHT / 4 in Core
+)
+
+var DefaultParseLimits = ParseLimits{
+	MaxBlockSize:       maxBlockSize,
+	MaxBlockTxs:        maxBlockBaseSize / minTxSize,
+	MaxScriptSize:      maxBlockBaseSize,
+	MaxWitnessItems:    maxBlockSize,
+	MaxWitnessItemSize: maxBlockSize,
+}
+
+var Limits = DefaultParseLimits
+
+// readStringMax is readString with at most max bytes, what is for the
+// error message.
+func readStringMax(r io.Reader, max int, what string) ([]byte, error) {
+	size, err := readCompactSize(r)
+	if err != nil {
+		return nil, err
+	}
+	if max <= 0 || max > maxStringSize {
+		max = maxStringSize
+	}
+	if size > uint64(max) {
+		return nil, fmt.Errorf("%s of %d bytes exceeds the maximum of %d", what, size, max)
+	}
+
+	buf := make([]byte, int(size))
+	if _, err = io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// readListMax is readList with at most max items.
+func readListMax(r io.Reader, max int, what string, doRead func(io.Reader) error) error {
+	size, err := readCompactSize(r)
+	if err != nil {
+		return err
+	}
+	if max > 0 && size > uint64(max) {
+		return fmt.Errorf("%d %s exceed the maximum of %d", size, what, max)
+	}
+
+	for i := uint64(0); i < size; i++ {
+		if err = doRead(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package core
+
+// MerkleRoot is the root of the merkle tree of the hashes as in the
+// block header, an odd hash at any level being paired with itself.
+func MerkleRoot(hashes []Uint256) Uint256 {
+	if len(hashes) == 0 {
+		return Uint256{}
+	}
+	for len(hashes) > 1 {
+		if len(hashes)%2 == 1 {
+			hashes = append(hashes, hashes[len(hashes)-1])
+		}
+		next := make([]Uint256, len(hashes)/2)
+		for i := range next {
+			next[i] = ShaSha256(append(hashes[2*i][:], hashes[2*i+1][:]...))
+		}
+		hashes = next
+	}
+	return hashes[0]
+}
+
+// MerkleRoot of the txids.
+func (tl *TxList) MerkleRoot() Uint256 {
+	hashes := make([]Uint256, len(*tl))
+	for i, tx := range *tl {
+		hashes[i] = tx.Hash()
+	}
+	return MerkleRoot(hashes)
+}
+
+// WitnessMerkleRoot is the root of the wtxids (BIP141), that of the
+// coinbase being zero.
+func (tl *TxList) WitnessMerkleRoot() Uint256 {
+	hashes := make([]Uint256, len(*tl))
+	for i, tx := range *tl {
+		if i > 0 {
+			hashes[i] = tx.WitnessHash()
+		}
+	}
+	return MerkleRoot(hashes)
+}
@@ -0,0 +1,321 @@
+package core
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Decoding of Partially Signed Bitcoin Transactions, version 0
+// (BIP174) and 2 (BIP370), as far as needed to look at the
+// transaction and what its inputs spend. Fields which are not
+// interpreted are kept as they are.
+
+var psbtMagic = []byte("psbt\xff")
+
+// Key types
+const (
+	psbtGlobalUnsignedTx    = 0x00
+	psbtGlobalTxVersion     = 0x02
+	psbtGlobalLockTime      = 0x03
+	psbtGlobalInputCount    = 0x04
+	psbtGlobalOutputCount   = 0x05
+	psbtGlobalVersion       = 0xfb
+	psbtInNonWitnessUtxo    = 0x00
+	psbtInWitnessUtxo       = 0x01
+	psbtInPartialSig        = 0x02
+	psbtInSigHashType       = 0x03
+	psbtInRedeemScript      = 0x04
+	psbtInWitnessScript     = 0x05
+	psbtInFinalScriptSig    = 0x07
+	psbtInFinalWitness      = 0x08
+	psbtInPrevTxId          = 0x0e
+	psbtInOutputIndex       = 0x0f
+	psbtInSequence          = 0x10
+	psbtInTapKeySig         = 0x13
+	psbtInTapScriptSig      = 0x14
+	psbtOutRedeemScript     = 0x00
+	psbtOutWitnessScript    = 0x01
+	psbtOutAmount           = 0x03
+	psbtOutScript           = 0x04
+	psbtDefaultPSBTVersion  = 0
+	psbtMaxMapEntries       = 1 << 16
+	psbtMaxInputsAndOutputs = 1 << 16
+)
+
+// A PSBTField is a key-value pair of a map, Key includes the type.
+type PSBTField struct {
+	Key   []byte
+	Value []byte
+}
+
+func (f *PSBTField) Type() byte {
+	return f.Key[0]
+}
+
+type PSBT struct {
+	Version uint32
+	// The unsigned tx, for version 2 built from the fields of the
+	// maps (with the fallback locktime).
+	Tx      *Tx
+	Global  []*PSBTField
+	Inputs  []*PSBTInput
+	Outputs []*PSBTOutput
+}
+
+type PSBTInput struct {
+	NonWitnessUtxo *Tx
+	WitnessUtxo    *TxOut
+	PartialSigs    int // ECDSA and taproot signatures
+	SigHashType    uint32
+	RedeemScript   []byte
+	WitnessScript  []byte
+	FinalScriptSig []byte
+	FinalWitness   Witness
+	Fields         []*PSBTField
+}
+
+type PSBTOutput struct {
+	RedeemScript  []byte
+	WitnessScript []byte
+	Fields        []*PSBTField
+}
+
+// IsFinalized tells whether the input has its final scriptsig or
+// witness.
+func (in *PSBTInput) IsFinalized() bool {
+	return in.FinalScriptSig != nil || in.FinalWitness != nil
+}
+
+// Utxo returns the output spent by the input from the PSBT, nil if
+// it is not there or does not match the prevout.
+func (in *PSBTInput) Utxo(prevOut OutPoint) *TxOut {
+	if in.WitnessUtxo != nil {
+		return in.WitnessUtxo
+	}
+	if in.NonWitnessUtxo != nil && in.NonWitnessUtxo.Hash() == prevOut.Hash && int(prevOut.N) < len(in.NonWitnessUtxo.TxOuts) {
+		return in.NonWitnessUtxo.TxOuts[prevOut.N]
+	}
+	return nil
+}
+
+// DecodePSBTString decodes a PSBT in base64 (as Core has them) or hex.
+func DecodePSBTString(s string) (*PSBT, error) {
+	s = strings.TrimSpace(s)
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		if b, err = base64.StdEncoding.DecodeString(s); err != nil {
+			return nil, fmt.Errorf("PSBT is neither base64 nor hex.")
+		}
+	}
+	return DecodePSBT(b)
+}
+
+// DecodePSBT decodes a binary PSBT.
+func DecodePSBT(b []byte) (*PSBT, error) {
+	if !bytes.HasPrefix(b, psbtMagic) {
+		return nil, fmt.Errorf("Not a PSBT (bad magic).")
+	}
+	r := bytes.NewReader(b[len(psbtMagic):])
+
+	p := &PSBT{Version: psbtDefaultPSBTVersion}
+	global, err := readPSBTMap(r)
+	if err != nil {
+		return nil, fmt.Errorf("Global map: %v", err)
+	}
+	p.Global = global
+
+	var (
+		nIns, nOuts int
+		tx          = &Tx{Version: 2}
+	)
+	for _, f := range global {
+		switch f.Type() {
+		case psbtGlobalUnsignedTx:
+			if err := BinRead(tx, bytes.NewReader(f.Value)); err != nil {
+				return nil, fmt.Errorf("Unsigned tx: %v", err)
+			}
+			nIns, nOuts = len(tx.TxIns), len(tx.TxOuts)
+			p.Tx = tx
+		case psbtGlobalVersion:
+			if p.Version, err = psbtUint32(f); err != nil {
+				return nil, err
+			}
+		case psbtGlobalTxVersion:
+			if tx.Version, err = psbtUint32(f); err != nil {
+				return nil, err
+			}
+		case psbtGlobalLockTime:
+			if tx.LockTime, err = psbtUint32(f); err != nil {
+				return nil, err
+			}
+		case psbtGlobalInputCount, psbtGlobalOutputCount:
+			n, err := readCompactSize(bytes.NewReader(f.Value))
+			if err != nil || n > psbtMaxInputsAndOutputs {
+				return nil, fmt.Errorf("Invalid input or output count.")
+			}
+			if f.Type() == psbtGlobalInputCount {
+				nIns = int(n)
+			} else {
+				nOuts = int(n)
+			}
+		}
+	}
+	switch p.Version {
+	case 0:
+		if p.Tx == nil {
+			return nil, fmt.Errorf("PSBT without an unsigned tx.")
+		}
+	case 2:
+		if p.Tx != nil {
+			return nil, fmt.Errorf("Version 2 PSBT with an unsigned tx.")
+		}
+		p.Tx = tx
+		tx.TxIns = make(TxInList, nIns)
+		tx.TxOuts = make(TxOutList, nOuts)
+	default:
+		return nil, fmt.Errorf("Unsupported PSBT version %d.", p.Version)
+	}
+
+	for i := 0; i < nIns; i++ {
+		fields, err := readPSBTMap(r)
+		if err != nil {
+			return nil, fmt.Errorf("Input %d: %v", i, err)
+		}
+		in, err := newPSBTInput(fields)
+		if err != nil {
+			return nil, fmt.Errorf("Input %d: %v", i, err)
+		}
+		if p.Version == 2 {
+			if tx.TxIns[i], err = psbtV2TxIn(fields); err != nil {
+				return nil, fmt.Errorf("Input %d: %v", i, err)
+			}
+		}
+		p.Inputs = append(p.Inputs, in)
+	}
+	for i := 0; i < nOuts; i++ {
+		fields, err := readPSBTMap(r)
+		if err != nil {
+			return nil, fmt.Errorf("Output %d: %v", i, err)
+		}
+		out := &PSBTOutput{Fields: fields}
+		var amount, script *PSBTField
+		for _, f := range fields {
+			switch f.Type() {
+			case psbtOutRedeemScript:
+				out.RedeemScript = f.Value
+			case psbtOutWitnessScript:
+				out.WitnessScript = f.Value
+			case psbtOutAmount:
+				amount = f
+			case psbtOutScript:
+				script = f
+			}
+		}
+		if p.Version == 2 {
+			if amount == nil || script == nil || len(amount.Value) != 8 {
+				return nil, fmt.Errorf("Output %d: missing or invalid amount or script.", i)
+			}
+			txOut := &TxOut{ScriptPubKey: script.Value}
+			BinRead(&txOut.Value, bytes.NewReader(amount.Value))
+			tx.TxOuts[i] = txOut
+		}
+		p.Outputs = append(p.Outputs, out)
+	}
+	return p, nil
+}
+
+func newPSBTInput(fields []*PSBTField) (*PSBTInput, error) {
+	in := &PSBTInput{Fields: fields}
+	for _, f := range fields {
+		var err error
+		switch f.Type() {
+		case psbtInNonWitnessUtxo:
+			in.NonWitnessUtxo = &Tx{}
+			err = BinRead(in.NonWitnessUtxo, bytes.NewReader(f.Value))
+		case psbtInWitnessUtxo:
+			in.WitnessUtxo = &TxOut{}
+			err = BinRead(in.WitnessUtxo, bytes.NewReader(f.Value))
+		case psbtInPartialSig, psbtInTapKeySig, psbtInTapScriptSig:
+			in.PartialSigs++
+		case psbtInSigHashType:
+			in.SigHashType, err = psbtUint32(f)
+		case psbtInRedeemScript:
+			in.RedeemScript = f.Value
+		case psbtInWitnessScript:
+			in.WitnessScript = f.Value
+		case psbtInFinalScriptSig:
+			in.FinalScriptSig = f.Value
+		case psbtInFinalWitness:
+			err = BinRead(&in.FinalWitness, bytes.NewReader(f.Value))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Field 0x%02x: %v", f.Type(), err)
+		}
+	}
+	return in, nil
+}
+
+func psbtV2TxIn(fields []*PSBTField) (*TxIn, error) {
+	in := &TxIn{Sequence: 0xffffffff}
+	var haveHash, haveN bool
+	for _, f := range fields {
+		switch f.Type() {
+		case psbtInPrevTxId:
+			if len(f.Value) != 32 {
+				return nil, fmt.Errorf("Invalid previous txid.")
+			}
+			copy(in.PrevOut.Hash[:], f.Value)
+			haveHash = true
+		case psbtInOutputIndex:
+			n, err := psbtUint32(f)
+			if err != nil {
+				return nil, err
+			}
+			in.PrevOut.N, haveN = n, true
+		case psbtInSequence:
+			seq, err := psbtUint32(f)
+			if err != nil {
+				return nil, err
+			}
+			in.Sequence = seq
+		}
+	}
+	if !haveHash || !haveN {
+		return nil, fmt.Errorf("Missing previous txid or output index.")
+	}
+	return in, nil
+}
+
+// readPSBTMap reads the key-value pairs up to the separator.
+func readPSBTMap(r io.Reader) ([]*PSBTField, error) {
+	var fields []*PSBTField
+	for {
+		key, err := readString(r)
+		if err != nil {
+			return nil, err
+		}
+		if len(key) == 0 {
+			return fields, nil
+		}
+		value, err := readString(r)
+		if err != nil {
+			return nil, err
+		}
+		if fields = append(fields, &PSBTField{Key: key, Value: value}); len(fields) > psbtMaxMapEntries {
+			return nil, fmt.Errorf("Too many fields.")
+		}
+	}
+}
+
+func psbtUint32(f *PSBTField) (uint32, error) {
+	if len(f.Value) != 4 {
+		return 0, fmt.Errorf("Field 0x%02x: expected 4 bytes, got %d", f.Type(), len(f.Value))
+	}
+	var v uint32
+	BinRead(&v, bytes.NewReader(f.Value))
+	return v, nil
+}
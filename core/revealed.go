@@ -0,0 +1,93 @@
+package core
+
+// The scripts revealed by the inputs which spend P2SH and P2WSH
+// outputs: the redeem script, the last push of the scriptSig, or the
+// witness script, the last witness item. Which kind of output an input
+// spends is not known without the output, so this goes by the shape of
+// the input, the usual spends of P2PKH, P2PK, bare multisig and P2WPKH
+// outputs end with a public key or a signature, those are not taken
+// for scripts.
+
+// RevealedScript returns the script the input reveals and the type of
+// the output it spends, "p2sh", "p2wsh" or "p2sh-p2wsh" (the witness
+// script of P2WSH nested in P2SH), nil and "" if there is none.
+func (tin *TxIn) RevealedScript() ([]byte, string) {
+	if tin.PrevOut.N == 0xffffffff { // coinbase
+		return nil, ""
+	}
+	redeem := lastPush(tin.ScriptSig)
+	if len(tin.ScriptSig) > 0 && len(redeem) == 0 {
+		return nil, ""
+	}
+	if len(redeem) == 34 && redeem[0] == OP_0 && redeem[1] == 32 {
+		if ws := tin.witnessScript(); ws != nil {
+			return ws, "p2sh-p2wsh"
+		}
+	}
+	if redeem != nil {
+		if looksLikeKeyOrSig(redeem) {
+			return nil, ""
+		}
+		return redeem, "p2sh"
+	}
+	if ws := tin.witnessScript(); ws != nil {
+		return ws, "p2wsh"
+	}
+	return nil, ""
+}
+
+// lastPush returns the data of the last op of a push only scriptSig,
+// nil if the script is empty or not push only.
+func lastPush(scriptSig []byte) []byte {
+	ops, err := ParseScript(scriptSig)
+	if err != nil || len(ops) == 0 {
+		return nil
+	}
+	for _, op := range ops {
+		if _, ok := op.PushData(); !ok {
+			return nil
+		}
+	}
+	data, _ := ops[len(ops)-1].PushData()
+	return data
+}
+
+// witnessScript returns the last witness item if it could be a
+// witness script, i.e. is not the key of a P2WPKH spend nor the
+// signature, annex or control block of a taproot spend.
+func (tin *TxIn) witnessScript() []byte {
+	wits := tin.Witness
+	n := len(wits)
+	if n == 0 {
+		return nil
+	}
+	ws := wits[n-1]
+	if n == 1 && (len(ws) == 64 || len(ws) == 65) {
+		return nil
+	}
+	if n >= 2 && len(ws) > 0 && ws[0] == ANNEX_TAG {
+		return nil // taproot
+	}
+	if n >= 2 && len(ws) >= 33 && (len(ws)-33)%32 == 0 && ws[0]&0xfe == 0xc0 {
+		return nil // taproot control block
+	}
+	if looksLikeKeyOrSig(ws) {
+		return nil
+	}
+	return ws
+}
+
+// looksLikeKeyOrSig tells whether the data is shaped like a public key
+// or a DER signature with the sighash type.
+func looksLikeKeyOrSig(b []byte) bool {
+	n := len(b)
+	switch {
+	case n == 33 && (b[0] == 2 || b[0] == 3):
+		return true
+	case n == 65 && b[0] == 4:
+		return true
+	case n >= 9 && n <= 73 && b[0] == 0x30 && int(b[1]) == n-3:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,355 @@
+package core
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// Runes (https://docs.ordinals.com/runes.html) messages, "runestones",
+// live in an OP_RETURN OP_13 output. The payload is the concatenation
+// of the data pushes following OP_13, and is a sequence of LEB128
+// u128 integers: tag/value pairs, then, after tag 0, edicts in groups
+// of four. A malformed runestone is a "cenotaph", which burns all the
+// runes going into the transaction.
+//
+// All integers are u128, represented as *big.Int.
+//
+// NB: This follows the spec closely but is not a port of ord, a few
+// edge cases differ (e.g. the etching and mint of a cenotaph are
+// discarded rather than burned, etching commitments are not checked).
+
+const OP_13 = 0x5d
+
+const (
+	runeTagBody         = 0
+	runeTagDivisibility = 1
+	runeTagFlags        = 2
+	runeTagSpacers      = 3
+	runeTagRune         = 4
+	runeTagSymbol       = 5
+	runeTagPremine      = 6
+	runeTagCap          = 8
+	runeTagAmount       = 10
+	runeTagHeightStart  = 12
+	runeTagHeightEnd    = 14
+	runeTagOffsetStart  = 16
+	runeTagOffsetEnd    = 18
+	runeTagMint         = 20
+	runeTagPointer      = 22
+	runeTagCenotaph     = 126
+
+	runeFlagEtching  = 0
+	runeFlagTerms    = 1
+	runeFlagTurbo    = 2
+	runeFlagCenotaph = 127
+
+	RuneMaxDivisibility = 38
+	RuneMaxSpacers      = 0x07ffffff
+)
+
+var (
+	maxU128 = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 128), big.NewInt(1))
+
+	// Names at or above this are reserved, and assigned to etchings
+	// which do not specify a name.
+	RuneReserved, _ = new(big.Int).SetString("6402364363415443603228541259936211926", 10)
+)
+
+type RuneId struct {
+	Block uint64
+	Tx    uint32
+}
+
+func (id RuneId) String() string {
+	return fmt.Sprintf("%d:%d", id.Block, id.Tx)
+}
+
+type RuneEdict struct {
+	Id     RuneId
+	Amount *big.Int
+	Output uint32
+}
+
+type RuneTerms struct {
+	Cap         *big.Int
+	Amount      *big.Int
+	HeightStart *uint64
+	HeightEnd   *uint64
+	OffsetStart *uint64
+	OffsetEnd   *uint64
+}
+
+type RuneEtching struct {
+	Divisibility uint8
+	Premine      *big.Int
+	Rune         *big.Int // the name as a number, nil = reserved
+	Spacers      uint32
+	Symbol       *rune
+	Terms        *RuneTerms
+	Turbo        bool
+}
+
+type Runestone struct {
+	Edicts   []*RuneEdict
+	Etching  *RuneEtching
+	Mint     *RuneId
+	Pointer  *uint32
+	Cenotaph bool
+	Flaw     string // why it is a cenotaph
+}
+
+// DecodeRunestone returns nil if the transaction has no runestone,
+// otherwise the runestone, which may be a cenotaph.
+func (tx *Tx) DecodeRunestone() *Runestone {
+	for _, out := range tx.TxOuts {
+		s := out.ScriptPubKey
+		if len(s) < 2 || s[0] != OP_RETURN || s[1] != OP_13 {
+			continue
+		}
+		// Only the first matching output counts
+		return decodeRunestone(s[2:], len(tx.TxOuts))
+	}
+	return nil
+}
+
+func cenotaph(flaw string) *Runestone {
+	return &Runestone{Cenotaph: true, Flaw: flaw}
+}
+
+func decodeRunestone(script []byte, nOuts int) *Runestone {
+	ops, err := ParseScript(script)
+	if err != nil {
+		return cenotaph("invalid script")
+	}
+	var payload []byte
+	for _, op := range ops {
+		if !op.IsPush() {
+			return cenotaph("opcode")
+		}
+		payload = append(payload, op.Data...)
+	}
+
+	var ints []*big.Int
+	for len(payload) > 0 {
+		n, size, ok := decodeLEB128(payload)
+		if !ok {
+			return cenotaph("varint")
+		}
+		ints = append(ints, n)
+		payload = payload[size:]
+	}
+
+	fields := make(map[uint64][]*big.Int)
+	var edicts []*RuneEdict
+	unrecognizedEven := false
+	for i := 0; i < len(ints); i += 2 {
+		tag := ints[i]
+		if tag.Sign() == 0 { // body
+			var id RuneId
+			rest := ints[i+1:]
+			if len(rest)%4 != 0 {
+				return cenotaph("trailing integers")
+			}
+			for j := 0; j < len(rest); j += 4 {
+				block, tx := rest[j], rest[j+1]
+				if !block.IsUint64() || !tx.IsUint64() || tx.Uint64() > 0xffffffff {
+					return cenotaph("edict rune id")
+				}
+				if block.Sign() == 0 {
+					id.Tx += uint32(tx.Uint64())
+				} else {
+					id.Block += block.Uint64()
+					id.Tx = uint32(tx.Uint64())
+				}
+				if id.Block == 0 && id.Tx > 0 {
+					return cenotaph("edict rune id")
+				}
+				out := rest[j+3]
+				if !out.IsUint64() || out.Uint64() > uint64(nOuts) {
+					return cenotaph("edict output")
+				}
+				edicts = append(edicts, &RuneEdict{Id: id, Amount: rest[j+2], Output: uint32(out.Uint64())})
+			}
+			break
+		}
+		if i+1 >= len(ints) {
+			return cenotaph("truncated field")
+		}
+		if !tag.IsUint64() {
+			if tag.Bit(0) == 0 {
+				unrecognizedEven = true
+			}
+			continue
+		}
+		fields[tag.Uint64()] = append(fields[tag.Uint64()], ints[i+1])
+	}
+
+	rs := &Runestone{Edicts: edicts}
+
+	take := func(tag uint64) *big.Int {
+		v := fields[tag]
+		if len(v) == 0 {
+			return nil
+		}
+		fields[tag] = v[1:]
+		if len(fields[tag]) == 0 {
+			delete(fields, tag)
+		}
+		return v[0]
+	}
+	takeUint64 := func(tag uint64, max uint64) (*uint64, bool) {
+		v := take(tag)
+		if v == nil {
+			return nil, true
+		}
+		if !v.IsUint64() || v.Uint64() > max {
+			return nil, false
+		}
+		n := v.Uint64()
+		return &n, true
+	}
+
+	flags := take(runeTagFlags)
+	if flags == nil {
+		flags = new(big.Int)
+	}
+	flags = new(big.Int).Set(flags)
+	hasFlag := func(bit int) bool {
+		if flags.Bit(bit) == 1 {
+			flags.SetBit(flags, bit, 0)
+			return true
+		}
+		return false
+	}
+
+	if hasFlag(runeFlagEtching) {
+		e := &RuneEtching{}
+		if v, ok := takeUint64(runeTagDivisibility, RuneMaxDivisibility); ok && v != nil {
+			e.Divisibility = uint8(*v)
+		}
+		e.Premine = take(runeTagPremine)
+		e.Rune = take(runeTagRune)
+		if v, ok := takeUint64(runeTagSpacers, RuneMaxSpacers); ok && v != nil {
+			e.Spacers = uint32(*v)
+		}
+		if v, ok := takeUint64(runeTagSymbol, 0x10ffff); ok && v != nil {
+			r := rune(*v)
+			e.Symbol = &r
+		}
+		if hasFlag(runeFlagTerms) {
+			t := &RuneTerms{}
+			t.Cap = take(runeTagCap)
+			t.Amount = take(runeTagAmount)
+			var ok [4]bool
+			t.HeightStart, ok[0] = takeUint64(runeTagHeightStart, 1<<64-1)
+			t.HeightEnd, ok[1] = takeUint64(runeTagHeightEnd, 1<<64-1)
+			t.OffsetStart, ok[2] = takeUint64(runeTagOffsetStart, 1<<64-1)
+			t.OffsetEnd, ok[3] = takeUint64(runeTagOffsetEnd, 1<<64-1)
+			if ok != [4]bool{true, true, true, true} {
+				return cenotaph("terms")
+			}
+			e.Terms = t
+		}
+		e.Turbo = hasFlag(runeFlagTurbo)
+		rs.Etching = e
+
+		// premine + cap * amount must fit in u128
+		supply := new(big.Int)
+		if e.Premine != nil {
+			supply.Set(e.Premine)
+		}
+		if e.Terms != nil && e.Terms.Cap != nil && e.Terms.Amount != nil {
+			supply.Add(supply, new(big.Int).Mul(e.Terms.Cap, e.Terms.Amount))
+		}
+		if supply.Cmp(maxU128) > 0 {
+			return cenotaph("supply overflow")
+		}
+	}
+
+	if mint := fields[runeTagMint]; len(mint) >= 2 {
+		if !mint[0].IsUint64() || !mint[1].IsUint64() || mint[1].Uint64() > 0xffffffff {
+			return cenotaph("mint")
+		}
+		rs.Mint = &RuneId{Block: mint[0].Uint64(), Tx: uint32(mint[1].Uint64())}
+		take(runeTagMint)
+		take(runeTagMint)
+	}
+
+	if v, ok := takeUint64(runeTagPointer, uint64(nOuts)-1); ok && v != nil {
+		p := uint32(*v)
+		rs.Pointer = &p
+	} else if !ok {
+		return cenotaph("pointer")
+	}
+
+	if take(runeTagCenotaph) != nil {
+		return cenotaph("cenotaph tag")
+	}
+	if flags.Sign() != 0 {
+		return cenotaph("unrecognized flag")
+	}
+	if unrecognizedEven {
+		return cenotaph("unrecognized even tag")
+	}
+	for tag := range fields {
+		if tag%2 == 0 {
+			return cenotaph("unrecognized even tag")
+		}
+	}
+	return rs
+}
+
+// u128 LEB128, returns the value, the number of bytes consumed and
+// whether it is valid (not truncated, no overflow, at most 19 bytes).
+func decodeLEB128(b []byte) (*big.Int, int, bool) {
+	n := new(big.Int)
+	for i := 0; i < len(b) && i < 19; i++ {
+		v := new(big.Int).SetUint64(uint64(b[i] & 0x7f))
+		if i == 18 && b[i]&0x7c != 0 { // more than 128 bits
+			return nil, 0, false
+		}
+		n.Or(n, v.Lsh(v, uint(7*i)))
+		if b[i]&0x80 == 0 {
+			return n, i + 1, true
+		}
+	}
+	return nil, 0, false
+}
+
+// RuneName converts the rune number to its name, e.g. 0 is "A", 26
+// is "AA".
+func RuneName(n *big.Int) string {
+	var sb []byte
+	n = new(big.Int).Add(n, big.NewInt(1))
+	twentySix, m := big.NewInt(26), new(big.Int)
+	for n.Sign() > 0 {
+		n.Sub(n, big.NewInt(1))
+		n.DivMod(n, twentySix, m)
+		sb = append(sb, byte('A'+m.Int64()))
+	}
+	for i, j := 0, len(sb)-1; i < j; i, j = i+1, j-1 {
+		sb[i], sb[j] = sb[j], sb[i]
+	}
+	return string(sb)
+}
+
+// SpacedRuneName inserts a "•" after each letter whose bit is set in
+// spacers.
+func SpacedRuneName(name string, spacers uint32) string {
+	var sb strings.Builder
+	for i, c := range name {
+		sb.WriteRune(c)
+		if i < len(name)-1 && spacers&(1<<uint(i)) != 0 {
+			sb.WriteRune('•')
+		}
+	}
+	return sb.String()
+}
+
+// ReservedRune is the name given to an etching without one.
+func ReservedRune(id RuneId) *big.Int {
+	n := new(big.Int).SetUint64(id.Block)
+	n.Lsh(n, 32).Or(n, new(big.Int).SetUint64(uint64(id.Tx)))
+	return n.Add(n, RuneReserved)
+}
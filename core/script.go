@@ -0,0 +1,142 @@
+package core
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Just enough of Script to split it into operations, the opcodes are
+// named the way Core names them (see interpreter.go for the rest).
+
+const (
+	OP_0         = 0x00
+	OP_PUSHDATA1 = 0x4c
+	OP_PUSHDATA2 = 0x4d
+	OP_PUSHDATA4 = 0x4e
+	OP_1NEGATE   = 0x4f
+	OP_1         = 0x51
+	OP_16        = 0x60
+	OP_IF        = 0x63
+	OP_ENDIF     = 0x68
+	OP_RETURN    = 0x6a
+)
+
+// Taproot annex prefix (BIP341)
+const ANNEX_TAG = 0x50
+
+type ScriptOp struct {
+	Op   byte
+	Data []byte // for pushes, nil otherwise
+}
+
+// IsPush is true for data pushes including OP_0 (an empty push).
+func (o *ScriptOp) IsPush() bool {
+	return o.Op <= OP_PUSHDATA4
+}
+
+// PushData returns the data pushed by the op, treating OP_1NEGATE
+// and OP_1 through OP_16 as pushes of the number, the way
+// inscription envelopes and runestones use them.
+func (o *ScriptOp) PushData() ([]byte, bool) {
+	switch {
+	case o.IsPush():
+		return o.Data, true
+	case o.Op == OP_1NEGATE:
+		return []byte{0x81}, true
+	case o.Op >= OP_1 && o.Op <= OP_16:
+		return []byte{o.Op - OP_1 + 1}, true
+	}
+	return nil, false
+}
+
+// ParseScript splits the script into ops. A truncated push is an
+// error, but the ops parsed up to that point are returned.
+func ParseScript(script []byte) ([]*ScriptOp, error) {
+	var ops []*ScriptOp
+	for pos := 0; pos < len(script); {
+		op, data, next, err := nextOp(script, pos)
+		if err != nil {
+			return ops, err
+		}
+		ops = append(ops, &ScriptOp{Op: op, Data: data})
+		pos = next
+	}
+	return ops, nil
+}
+
+// nextOp returns the op at pos, the data if it is a push, and the
+// position of the next op.
+func nextOp(script []byte, pos int) (byte, []byte, int, error) {
+	op := script[pos]
+	pos++
+	if op > OP_PUSHDATA4 {
+		return op, nil, pos, nil
+	}
+	var n int
+	switch op {
+	case OP_PUSHDATA1:
+		if pos+1 > len(script) {
+			return op, nil, pos, fmt.Errorf("Truncated OP_PUSHDATA1 at %d", pos-1)
+		}
+		n, pos = int(script[pos]), pos+1
+	case OP_PUSHDATA2:
+		if pos+2 > len(script) {
+			return op, nil, pos, fmt.Errorf("Truncated OP_PUSHDATA2 at %d", pos-1)
+		}
+		n, pos = int(binary.LittleEndian.Uint16(script[pos:])), pos+2
+	case OP_PUSHDATA4:
+		if pos+4 > len(script) {
+			return op, nil, pos, fmt.Errorf("Truncated OP_PUSHDATA4 at %d", pos-1)
+		}
+		n, pos = int(binary.LittleEndian.Uint32(script[pos:])), pos+4
+	default:
+		n = int(op)
+	}
+	if n < 0 || pos+n > len(script) {
+		return op, nil, pos, fmt.Errorf("Push of %d bytes at %d past the end of script", n, pos)
+	}
+	return op, script[pos : pos+n], pos + n, nil
+}
+
+// TapScript returns the leaf script of a taproot script path spend,
+// i.e. the second to last witness item once the annex, if any, is
+// removed. For other witnesses the result is a guess, nil if there
+// are not enough items.
+func (wits Witness) TapScript() []byte {
+	n := len(wits)
+	if n >= 2 && len(wits[n-1]) > 0 && wits[n-1][0] == ANNEX_TAG {
+		n--
+	}
+	if n < 2 {
+		return nil
+	}
+	return wits[n-2]
+}
+
+// ParseMultisig returns the number of signatures required and the
+// public keys of a multisig script, OP_m <key>... OP_n
+// OP_CHECKMULTISIG, ok false if the script is not one.
+func ParseMultisig(script []byte) (m int, keys [][]byte, ok bool) {
+	if len(script) < 3 || script[len(script)-1] != OP_CHECKMULTISIG {
+		return 0, nil, false
+	}
+	ops, err := ParseScript(script)
+	if err != nil || len(ops) < 4 {
+		return 0, nil, false
+	}
+	first, last := ops[0].Op, ops[len(ops)-2].Op
+	if first < OP_1 || first > OP_16 || last < OP_1 || last > OP_16 {
+		return 0, nil, false
+	}
+	m, n := int(first-OP_1+1), int(last-OP_1+1)
+	if m > n || len(ops) != n+3 {
+		return 0, nil, false
+	}
+	for _, op := range ops[1 : n+1] {
+		if !op.IsPush() || (len(op.Data) != 33 && len(op.Data) != 65) {
+			return 0, nil, false
+		}
+		keys = append(keys, op.Data)
+	}
+	return m, keys, true
+}
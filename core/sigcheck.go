@@ -0,0 +1,129 @@
+package core
+
+import (
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/ecdsa"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+)
+
+// Signature verification for the interpreter, with the secp256k1 of
+// btcec (pure Go). ECDSA signatures are parsed leniently, the way the
+// chain had them before BIP66 (ScriptVerifyDERSig is checked by the
+// interpreter), and high S values are accepted, as in consensus.
+
+// TxSigChecker is the SigChecker of input n of tx. prevOuts are the
+// outputs spent by the inputs of tx, only the one of input n is
+// needed unless it is a taproot spend.
+type TxSigChecker struct {
+	hasher *SigHasher
+	n      int
+}
+
+func NewTxSigChecker(tx *Tx, n int, prevOuts []*TxOut) *TxSigChecker {
+	return NewSigHasher(tx, prevOuts).SigChecker(n)
+}
+
+// SigChecker returns the TxSigChecker of input n, sharing the hashes
+// with the other inputs.
+func (h *SigHasher) SigChecker(n int) *TxSigChecker {
+	return &TxSigChecker{hasher: h, n: n}
+}
+
+func (c *TxSigChecker) CheckSig(sig, pubKey []byte, ctx *SigContext) bool {
+	switch ctx.Version {
+	case SigVersionBase, SigVersionWitnessV0:
+		if len(sig) == 0 {
+			return false
+		}
+		hashType := uint32(sig[len(sig)-1])
+		s, err := ecdsa.ParseSignature(sig[:len(sig)-1])
+		if err != nil {
+			return false
+		}
+		pk, err := btcec.ParsePubKey(pubKey)
+		if err != nil {
+			return false
+		}
+		var hash Uint256
+		if ctx.Version == SigVersionBase {
+			hash = c.hasher.Legacy(c.n, ctx.ScriptCode, hashType)
+		} else {
+			hash = c.hasher.WitnessV0(c.n, ctx.ScriptCode, c.hasher.prevOuts[c.n].Value, hashType)
+		}
+		return s.Verify(hash[:], pk)
+
+	case SigVersionTaproot, SigVersionTapscript:
+		hashType := byte(SigHashDefault)
+		switch len(sig) {
+		case 64:
+		case 65:
+			if hashType = sig[64]; hashType == SigHashDefault {
+				return false // must be a 64 byte signature
+			}
+		default:
+			return false
+		}
+		s, err := schnorr.ParseSignature(sig[:64])
+		if err != nil {
+			return false
+		}
+		pk, err := schnorr.ParsePubKey(pubKey)
+		if err != nil {
+			return false
+		}
+		var leafHash *Uint256
+		if ctx.Version == SigVersionTapscript {
+			leafHash = &ctx.LeafHash
+		}
+		hash, err := c.hasher.Taproot(c.n, hashType, ctx.Annex, leafHash, ctx.CodeSepPos)
+		if err != nil {
+			return false
+		}
+		return s.Verify(hash[:], pk)
+	}
+	return false
+}
+
+// CheckTapTweak checks that outputKey is internalKey + t*G with t the
+// BIP341 TapTweak hash.
+func (c *TxSigChecker) CheckTapTweak(outputKey, internalKey []byte, merkleRoot *Uint256, oddY bool) bool {
+	p, err := schnorr.ParsePubKey(internalKey)
+	if err != nil {
+		return false
+	}
+	var t Uint256
+	if merkleRoot != nil {
+		t = TaggedHash("TapTweak", internalKey, merkleRoot[:])
+	} else {
+		t = TaggedHash("TapTweak", internalKey)
+	}
+	var tweak btcec.ModNScalar
+	if overflow := tweak.SetByteSlice(t[:]); overflow {
+		return false
+	}
+	var pj, tg, q btcec.JacobianPoint
+	p.AsJacobian(&pj)
+	btcec.ScalarBaseMultNonConst(&tweak, &tg)
+	btcec.AddNonConst(&pj, &tg, &q)
+	if (q.X.IsZero() && q.Y.IsZero()) || q.Z.IsZero() {
+		return false // infinity
+	}
+	q.ToAffine()
+	x := q.X.Bytes()
+	return string(x[:]) == string(outputKey) && q.Y.IsOdd() == oddY
+}
+
+// VerifyTxInput validates input n of tx, signatures included, with
+// all the soft forks in effect. prevOuts are the outputs spent by the
+// inputs of tx (see TxSigChecker).
+func VerifyTxInput(tx *Tx, n int, prevOuts []*TxOut) error {
+	if len(prevOuts) != len(tx.TxIns) {
+		return fmt.Errorf("%d prevouts for %d inputs", len(prevOuts), len(tx.TxIns))
+	}
+	if n < 0 || n >= len(tx.TxIns) || prevOuts[n] == nil {
+		return fmt.Errorf("No prevout for input %d", n)
+	}
+	return VerifyScript(tx, n, prevOuts[n], ScriptVerifyAll, NewTxSigChecker(tx, n, prevOuts))
+}
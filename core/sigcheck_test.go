@@ -0,0 +1,114 @@
+package core_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/blkchain/blkchain/core"
+)
+
+// A sample of the script assets of Core (see testdata/README): spends
+// of every kind, whose success must verify and whose failure must not,
+// with the flags given, which are all soft forks.
+func TestScriptAssets(t *testing.T) {
+	type spend struct {
+		ScriptSig string
+		Witness   []string
+	}
+	var tests []struct {
+		Tx       string
+		Prevouts []string
+		Index    int
+		Flags    string
+		Comment  string
+		Success  *spend
+		Failure  *spend
+	}
+	loadJSON(t, "script_assets.json", &tests)
+
+	for _, test := range tests {
+		tx := readTx(t, test.Tx)
+		prevOuts := make([]*core.TxOut, len(test.Prevouts))
+		for i, s := range test.Prevouts {
+			prevOuts[i] = new(core.TxOut)
+			if err := prevOuts[i].BinRead(bytes.NewReader(decodeHex(t, s))); err != nil {
+				t.Fatalf("%s: prevout %s: %v", test.Comment, s, err)
+			}
+		}
+		verify := func(sp *spend) error {
+			in := tx.TxIns[test.Index]
+			in.ScriptSig, in.Witness = decodeHex(t, sp.ScriptSig), nil
+			for _, w := range sp.Witness {
+				in.Witness = append(in.Witness, decodeHex(t, w))
+			}
+			checker := core.NewTxSigChecker(tx, test.Index, prevOuts)
+			return core.VerifyScript(tx, test.Index, prevOuts[test.Index], parseFlags(test.Flags), checker)
+		}
+		if test.Success != nil {
+			if err := verify(test.Success); err != nil {
+				t.Errorf("%s: success of input %d of %s: %v", test.Comment, test.Index, test.Tx, err)
+			}
+		}
+		if test.Failure != nil {
+			if err := verify(test.Failure); err == nil {
+				t.Errorf("%s: failure of input %d of %s verified", test.Comment, test.Index, test.Tx)
+			}
+		}
+	}
+	t.Logf("%d script assets", len(tests))
+}
+
+// The scriptPubKey vectors of BIP341: the output key and address of an
+// internal key with no scripts, and with a single leaf.
+func TestTapTweak(t *testing.T) {
+	for _, test := range []struct {
+		internalKey, leafScript, leafHash, outputKey, address string
+		oddY                                                  bool
+	}{
+		{
+			internalKey: "d6889cb081036e0faefa3a35157ad71086b123b2b144b649798b494c300a961d",
+			outputKey:   "53a1f6e454df1aa2776a2814a721372d6258050de330b3c6d10ee8f4e0dda343",
+			address:     "bc1p2wsldez5mud2yam29q22wgfh9439spgduvct83k3pm50fcxa5dps59h4z5",
+		},
+		{
+			internalKey: "187791b6f712a8ea41c8ecdd0ee77fab3e85263b37e1ec18a3651926b3a6cf27",
+			leafScript:  "20d85a959b0290bf19bb89ed43c916be835475d013da4b362117393e25a48229b8ac",
+			leafHash:    "5b75adecf53548f3ec6ad7d78383bf84cc57b55a3127c72b9a2481752dd88b21",
+			outputKey:   "147c9c57132f6e7ecddba9800bb0c4449251c92a1e60371ee77557b6620f3ea3",
+			address:     "bc1pz37fc4cn9ah8anwm4xqqhvxygjf9rjf2resrw8h8w4tmvcs0863sa2e586",
+			oddY:        true,
+		},
+	} {
+		internalKey, outputKey := decodeHex(t, test.internalKey), decodeHex(t, test.outputKey)
+		var root *core.Uint256
+		if test.leafScript != "" {
+			h := core.TapLeafHash(core.TapLeafVersion, decodeHex(t, test.leafScript))
+			if got := hex.EncodeToString(h[:]); got != test.leafHash {
+				t.Errorf("Leaf hash of %s: %s, expected %s", test.leafScript, got, test.leafHash)
+			}
+			root = &h
+		}
+		c := core.NewTxSigChecker(nil, 0, nil)
+		if root == nil {
+			// The parity is only needed for script path spends, the
+			// vector does not have it.
+			if !c.CheckTapTweak(outputKey, internalKey, nil, false) && !c.CheckTapTweak(outputKey, internalKey, nil, true) {
+				t.Errorf("%s is not the output key of %s", test.outputKey, test.internalKey)
+			}
+		} else {
+			if !c.CheckTapTweak(outputKey, internalKey, root, test.oddY) {
+				t.Errorf("%s is not the output key of %s", test.outputKey, test.internalKey)
+			}
+			if c.CheckTapTweak(outputKey, internalKey, root, !test.oddY) {
+				t.Errorf("%s: the parity is not checked", test.outputKey)
+			}
+		}
+		if c.CheckTapTweak(outputKey, outputKey, root, test.oddY) {
+			t.Errorf("%s is the output key of itself", test.outputKey)
+		}
+		if got, err := core.EncodeSegwitAddress("bc", 1, outputKey); err != nil || got != test.address {
+			t.Errorf("Address of %s: %s (%v), expected %s", test.outputKey, got, err, test.address)
+		}
+	}
+}
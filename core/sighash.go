@@ -0,0 +1,287 @@
+package core
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+)
+
+// What the signatures of an input sign, in the three schemes: legacy
+// (the modified copy of the tx), BIP143 (segwit v0) and BIP341
+// (taproot, key and script path). The digests are what the ECDSA and
+// Schnorr signatures are made over.
+//
+// A SigHasher keeps the hashes of the whole tx which the BIP143 and
+// BIP341 digests of every input share, so that signing or verifying
+// all the inputs of a tx takes linear time. The functions are for a
+// single input.
+
+const (
+	SigHashDefault      = 0x00 // taproot only, as SigHashAll
+	SigHashAll          = 0x01
+	SigHashNone         = 0x02
+	SigHashSingle       = 0x03
+	SigHashAnyoneCanPay = 0x80
+)
+
+type SigHasher struct {
+	tx       *Tx
+	prevOuts []*TxOut
+
+	v0 *struct {
+		prevOuts, sequences, outputs Uint256 // double SHA256
+	}
+	taproot *struct {
+		prevOuts, amounts, scriptPubKeys, sequences, outputs [32]byte // single SHA256
+	}
+}
+
+// NewSigHasher returns a SigHasher for tx. prevOuts are the outputs
+// spent by its inputs, needed for taproot only (else may be nil).
+func NewSigHasher(tx *Tx, prevOuts []*TxOut) *SigHasher {
+	return &SigHasher{tx: tx, prevOuts: prevOuts}
+}
+
+// LegacySigHash is the digest of input n for SigVersionBase, i.e.
+// SignatureHash of Core. scriptCode is the script being executed from
+// the last OP_CODESEPARATOR on, without the signature (the
+// OP_CODESEPARATORs are removed here). hashType is the last byte of
+// the signature.
+func LegacySigHash(tx *Tx, n int, scriptCode []byte, hashType uint32) Uint256 {
+	return NewSigHasher(tx, nil).Legacy(n, scriptCode, hashType)
+}
+
+// WitnessV0SigHash is the BIP143 digest of input n, amount the value
+// of the output spent. For P2WPKH the scriptCode is the P2PKH script
+// of the key hash.
+func WitnessV0SigHash(tx *Tx, n int, scriptCode []byte, amount int64, hashType uint32) Uint256 {
+	return NewSigHasher(tx, nil).WitnessV0(n, scriptCode, amount, hashType)
+}
+
+// TaprootSigHash is the BIP341 digest of input n, prevOuts the outputs
+// spent by all the inputs. annex is nil if none. For a script path
+// spend (BIP342) leafHash is the TapLeafHash of the script and
+// codeSepPos the position of the last executed OP_CODESEPARATOR
+// (0xffffffff if none), for the key path leafHash is nil.
+func TaprootSigHash(tx *Tx, n int, prevOuts []*TxOut, hashType byte, annex []byte, leafHash *Uint256, codeSepPos uint32) (Uint256, error) {
+	return NewSigHasher(tx, prevOuts).Taproot(n, hashType, annex, leafHash, codeSepPos)
+}
+
+// Legacy is LegacySigHash.
+func (h *SigHasher) Legacy(n int, scriptCode []byte, hashType uint32) Uint256 {
+	tx := h.tx
+	anyoneCanPay := hashType&SigHashAnyoneCanPay != 0
+	base := hashType & 0x1f
+
+	if base == SigHashSingle && n >= len(tx.TxOuts) {
+		return Uint256{1} // the famous bug
+	}
+
+	var buf bytes.Buffer
+	BinWrite(tx.Version, &buf)
+
+	if anyoneCanPay {
+		writeCompactSize(1, &buf)
+	} else {
+		writeCompactSize(uint64(len(tx.TxIns)), &buf)
+	}
+	for i, in := range tx.TxIns {
+		if anyoneCanPay && i != n {
+			continue
+		}
+		BinWrite(in.PrevOut, &buf)
+		if i == n {
+			writeString(removeCodeSeparators(scriptCode), &buf)
+		} else {
+			writeCompactSize(0, &buf)
+		}
+		if i != n && (base == SigHashNone || base == SigHashSingle) {
+			BinWrite(uint32(0), &buf)
+		} else {
+			BinWrite(in.Sequence, &buf)
+		}
+	}
+
+	switch base {
+	case SigHashNone:
+		writeCompactSize(0, &buf)
+	case SigHashSingle:
+		writeCompactSize(uint64(n+1), &buf)
+		for i := 0; i < n; i++ {
+			BinWrite(int64(-1), &buf)
+			writeCompactSize(0, &buf)
+		}
+		tx.TxOuts[n].BinWrite(&buf)
+	default:
+		tx.TxOuts.BinWrite(&buf)
+	}
+
+	BinWrite(tx.LockTime, &buf)
+	BinWrite(hashType, &buf)
+	return ShaSha256(buf.Bytes())
+}
+
+func removeCodeSeparators(script []byte) []byte {
+	if bytes.IndexByte(script, OP_CODESEPARATOR) < 0 {
+		return script
+	}
+	result := make([]byte, 0, len(script))
+	for pos := 0; pos < len(script); {
+		op, _, next, err := nextOp(script, pos)
+		if err != nil {
+			return append(result, script[pos:]...)
+		}
+		if op != OP_CODESEPARATOR {
+			result = append(result, script[pos:next]...)
+		}
+		pos = next
+	}
+	return result
+}
+
+// WitnessV0 is WitnessV0SigHash.
+func (h *SigHasher) WitnessV0(n int, scriptCode []byte, amount int64, hashType uint32) Uint256 {
+	tx := h.tx
+	anyoneCanPay := hashType&SigHashAnyoneCanPay != 0
+	base := hashType & 0x1f
+
+	if h.v0 == nil {
+		h.v0 = &struct{ prevOuts, sequences, outputs Uint256 }{}
+		var prevs, seqs, outs bytes.Buffer
+		for _, in := range tx.TxIns {
+			BinWrite(in.PrevOut, &prevs)
+			BinWrite(in.Sequence, &seqs)
+		}
+		for _, out := range tx.TxOuts {
+			out.BinWrite(&outs)
+		}
+		h.v0.prevOuts = ShaSha256(prevs.Bytes())
+		h.v0.sequences = ShaSha256(seqs.Bytes())
+		h.v0.outputs = ShaSha256(outs.Bytes())
+	}
+
+	var hashPrevOuts, hashSequence, hashOutputs Uint256
+	if !anyoneCanPay {
+		hashPrevOuts = h.v0.prevOuts
+	}
+	if !anyoneCanPay && base != SigHashSingle && base != SigHashNone {
+		hashSequence = h.v0.sequences
+	}
+	if base != SigHashSingle && base != SigHashNone {
+		hashOutputs = h.v0.outputs
+	} else if base == SigHashSingle && n < len(tx.TxOuts) {
+		var buf bytes.Buffer
+		tx.TxOuts[n].BinWrite(&buf)
+		hashOutputs = ShaSha256(buf.Bytes())
+	}
+
+	in := tx.TxIns[n]
+	var buf bytes.Buffer
+	BinWrite(tx.Version, &buf)
+	buf.Write(hashPrevOuts[:])
+	buf.Write(hashSequence[:])
+	BinWrite(in.PrevOut, &buf)
+	writeString(scriptCode, &buf)
+	BinWrite(amount, &buf)
+	BinWrite(in.Sequence, &buf)
+	buf.Write(hashOutputs[:])
+	BinWrite(tx.LockTime, &buf)
+	BinWrite(hashType, &buf)
+	return ShaSha256(buf.Bytes())
+}
+
+// Taproot is TaprootSigHash.
+func (h *SigHasher) Taproot(n int, hashType byte, annex []byte, leafHash *Uint256, codeSepPos uint32) (Uint256, error) {
+	tx := h.tx
+	switch hashType {
+	case SigHashDefault, SigHashAll, SigHashNone, SigHashSingle,
+		SigHashAnyoneCanPay | SigHashAll, SigHashAnyoneCanPay | SigHashNone, SigHashAnyoneCanPay | SigHashSingle:
+	default:
+		return Uint256{}, fmt.Errorf("Invalid hash type 0x%02x", hashType)
+	}
+	if len(h.prevOuts) != len(tx.TxIns) {
+		return Uint256{}, fmt.Errorf("%d prevouts for %d inputs", len(h.prevOuts), len(tx.TxIns))
+	}
+	anyoneCanPay := hashType&SigHashAnyoneCanPay != 0
+	base := hashType & 3 // SigHashDefault is SigHashAll
+	if base == SigHashSingle && n >= len(tx.TxOuts) {
+		return Uint256{}, fmt.Errorf("SIGHASH_SINGLE for input %d without an output", n)
+	}
+
+	if h.taproot == nil {
+		var prevs, amounts, scripts, seqs, outs bytes.Buffer
+		for i, in := range tx.TxIns {
+			if h.prevOuts[i] == nil {
+				return Uint256{}, fmt.Errorf("Missing prevout of input %d", i)
+			}
+			BinWrite(in.PrevOut, &prevs)
+			BinWrite(h.prevOuts[i].Value, &amounts)
+			writeString(h.prevOuts[i].ScriptPubKey, &scripts)
+			BinWrite(in.Sequence, &seqs)
+		}
+		for _, out := range tx.TxOuts {
+			out.BinWrite(&outs)
+		}
+		h.taproot = &struct {
+			prevOuts, amounts, scriptPubKeys, sequences, outputs [32]byte
+		}{
+			sha256.Sum256(prevs.Bytes()),
+			sha256.Sum256(amounts.Bytes()),
+			sha256.Sum256(scripts.Bytes()),
+			sha256.Sum256(seqs.Bytes()),
+			sha256.Sum256(outs.Bytes()),
+		}
+	}
+
+	var msg bytes.Buffer
+	msg.WriteByte(0) // epoch
+	msg.WriteByte(hashType)
+	BinWrite(tx.Version, &msg)
+	BinWrite(tx.LockTime, &msg)
+	if !anyoneCanPay {
+		msg.Write(h.taproot.prevOuts[:])
+		msg.Write(h.taproot.amounts[:])
+		msg.Write(h.taproot.scriptPubKeys[:])
+		msg.Write(h.taproot.sequences[:])
+	}
+	if base != SigHashNone && base != SigHashSingle {
+		msg.Write(h.taproot.outputs[:])
+	}
+
+	spendType := byte(0)
+	if leafHash != nil {
+		spendType |= 2
+	}
+	if annex != nil {
+		spendType |= 1
+	}
+	msg.WriteByte(spendType)
+
+	in := tx.TxIns[n]
+	if anyoneCanPay {
+		BinWrite(in.PrevOut, &msg)
+		BinWrite(h.prevOuts[n].Value, &msg)
+		writeString(h.prevOuts[n].ScriptPubKey, &msg)
+		BinWrite(in.Sequence, &msg)
+	} else {
+		BinWrite(uint32(n), &msg)
+	}
+	if annex != nil {
+		var b bytes.Buffer
+		writeString(annex, &b)
+		sum := sha256.Sum256(b.Bytes())
+		msg.Write(sum[:])
+	}
+	if base == SigHashSingle {
+		var b bytes.Buffer
+		tx.TxOuts[n].BinWrite(&b)
+		sum := sha256.Sum256(b.Bytes())
+		msg.Write(sum[:])
+	}
+	if leafHash != nil {
+		msg.Write(leafHash[:])
+		msg.WriteByte(0) // key version
+		BinWrite(codeSepPos, &msg)
+	}
+	return TaggedHash("TapSighash", msg.Bytes()), nil
+}
@@ -0,0 +1,38 @@
+package core_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/blkchain/blkchain/core"
+)
+
+// The legacy digests of sighash.json of Core (see testdata/README),
+// random txs, scripts and hash types. The BIP143 and BIP341 digests
+// are checked with the signatures of the script assets (see
+// sigcheck_test.go).
+func TestLegacySigHash(t *testing.T) {
+	var entries [][]json.RawMessage
+	loadJSON(t, "sighash.json", &entries)
+	n := 0
+	for _, e := range entries {
+		if len(e) == 1 {
+			continue
+		}
+		var (
+			rawTx, script, want string
+			index               int
+			hashType            int32
+		)
+		if len(e) != 5 || json.Unmarshal(e[0], &rawTx) != nil || json.Unmarshal(e[1], &script) != nil ||
+			json.Unmarshal(e[2], &index) != nil || json.Unmarshal(e[3], &hashType) != nil || json.Unmarshal(e[4], &want) != nil {
+			t.Fatalf("Bad test %s", e)
+		}
+		tx := readTx(t, rawTx)
+		if got := core.LegacySigHash(tx, index, decodeHex(t, script), uint32(hashType)); got.String() != want {
+			t.Errorf("Sighash of input %d of %s with %s, hash type %d: %v, expected %s", index, rawTx, script, hashType, got, want)
+		}
+		n++
+	}
+	t.Logf("%d sighashes", n)
+}
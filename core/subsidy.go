@@ -0,0 +1,90 @@
+package core
+
+// The block subsidy, which halves every HalvingInterval blocks, from
+// 50 BTC, until it is shifted down to nothing after 33 halvings. This
+// is GetBlockSubsidy() in Core. The supply is the sum of the subsidies,
+// which is an upper bound, the genesis block output cannot be spent
+// and miners can (and did) claim less than they could.
+// https://github.com/bitcoin/bitcoin/blob/v22.0/src/validation.cpp#L1246
+
+const (
+	Coin           = 100_000_000
+	InitialSubsidy = 50 * Coin
+	MaxMoney       = 21_000_000 * Coin // a sanity limit, the supply never gets there
+
+	// Confirmations before the outputs of a coinbase tx can be spent.
+	CoinbaseMaturity = 100
+)
+
+type ChainParams struct {
+	Name            string
+	Magic           uint32
+	HalvingInterval int
+	Addresses       *AddressParams
+}
+
+var (
+	MainNetParams  = &ChainParams{"mainnet", MainNetMagic, 210000, MainNetAddressParams}
+	TestNetParams  = &ChainParams{"testnet3", TestNetMagic, 210000, TestNetAddressParams}
+	TestNet4Params = &ChainParams{"testnet4", TestNet4Magic, 210000, TestNetAddressParams}
+	SigNetParams   = &ChainParams{"signet", SigNetMagic, 210000, TestNetAddressParams}
+	RegTestParams  = &ChainParams{"regtest", RegTestMagic, 150, RegTestAddressParams}
+)
+
+// ChainParamsFor returns the params of the network of the magic, nil
+// if it is not known.
+func ChainParamsFor(magic uint32) *ChainParams {
+	for _, p := range []*ChainParams{MainNetParams, TestNetParams, TestNet4Params, SigNetParams, RegTestParams} {
+		if p.Magic == magic {
+			return p
+		}
+	}
+	return nil
+}
+
+// HalvingEpoch returns the number of halvings before the block at
+// height.
+func (p *ChainParams) HalvingEpoch(height int) int {
+	return height / p.HalvingInterval
+}
+
+// SubsidyAt returns the subsidy of the block at height, in satoshis.
+func (p *ChainParams) SubsidyAt(height int) int64 {
+	halvings := p.HalvingEpoch(height)
+	if height < 0 || halvings >= 64 {
+		return 0
+	}
+	return InitialSubsidy >> uint(halvings)
+}
+
+// ExpectedSupply returns the sum of the subsidies of the blocks up to
+// and including the one at height.
+func (p *ChainParams) ExpectedSupply(height int) int64 {
+	var supply int64
+	for epoch := 0; epoch*p.HalvingInterval <= height; epoch++ {
+		subsidy := p.SubsidyAt(epoch * p.HalvingInterval)
+		if subsidy == 0 {
+			break
+		}
+		blocks := p.HalvingInterval
+		if last := (epoch+1)*p.HalvingInterval - 1; last > height {
+			blocks = height - epoch*p.HalvingInterval + 1
+		}
+		supply += subsidy * int64(blocks)
+	}
+	return supply
+}
+
+// The mainnet schedule.
+
+func SubsidyAt(height int) int64 {
+	return MainNetParams.SubsidyAt(height)
+}
+
+func HalvingEpoch(height int) int {
+	return MainNetParams.HalvingEpoch(height)
+}
+
+func ExpectedSupply(height int) int64 {
+	return MainNetParams.ExpectedSupply(height)
+}
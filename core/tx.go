@@ -1,4 +1,4 @@
-package blkchain
+package core
 
 import (
 	"bytes"
@@ -20,6 +20,18 @@ func (tx *Tx) Hash() Uint256 {
 	return ShaSha256(buf.Bytes())
 }
 
+// WitnessHash is the wtxid (BIP141), i.e. the hash of the transaction
+// including the witness. For non-segwit transactions it is the same as
+// the txid.
+func (tx *Tx) WitnessHash() Uint256 {
+	if !tx.SegWit {
+		return tx.Hash()
+	}
+	buf := new(bytes.Buffer)
+	tx.BinWrite(buf)
+	return ShaSha256(buf.Bytes())
+}
+
 func (tx *Tx) BaseSize() int {
 	if !tx.SegWit {
 		return tx.Size()
@@ -90,7 +102,11 @@ func (tx *Tx) BinRead(r io.Reader) (err error) {
 		if err = BinRead(&tx.TxIns, r); err != nil { // Read txins again
 			return err
 		}
-		wcnt = len(tx.TxIns)
+		if wcnt = len(tx.TxIns); wcnt == 0 {
+			// It would be written as a tx with no inputs, as Core
+			// we don't take it.
+			return fmt.Errorf("Superfluous witness record")
+		}
 	}
 
 	if err = BinRead(&tx.TxOuts, r); err != nil {
@@ -162,7 +178,7 @@ func (tx *Tx) binWriteWithoutWitness(w io.Writer) (err error) {
 type TxList []*Tx
 
 func (tl *TxList) BinRead(r io.Reader) error {
-	return readList(r, func(r io.Reader) error {
+	return readListMax(r, Limits.MaxBlockTxs, "transactions in block", func(r io.Reader) error {
 		var tx Tx
 		if err := BinRead(&tx, r); err != nil {
 			return err
@@ -172,6 +188,12 @@ func (tl *TxList) BinRead(r io.Reader) error {
 	})
 }
 
+func (tl *TxList) BinWrite(w io.Writer) error {
+	return writeList(w, len(*tl), func(w io.Writer, i int) error {
+		return BinWrite((*tl)[i], w)
+	})
+}
+
 func (tl *TxList) BaseSize() int {
 	result := compactSizeSize(uint64(len(*tl)))
 	for _, t := range *tl {
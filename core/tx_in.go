@@ -1,4 +1,4 @@
-package blkchain
+package core
 
 import "io"
 
@@ -34,7 +34,7 @@ func (tin *TxIn) BinRead(r io.Reader) (err error) {
 	if err = BinRead(&tin.PrevOut, r); err != nil {
 		return err
 	}
-	if tin.ScriptSig, err = readString(r); err != nil {
+	if tin.ScriptSig, err = readStringMax(r, Limits.MaxScriptSize, "ScriptSig"); err != nil {
 		return err
 	}
 	if err = BinRead(&tin.Sequence, r); err != nil {
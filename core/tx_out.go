@@ -1,4 +1,4 @@
-package blkchain
+package core
 
 import "io"
 
@@ -15,7 +15,7 @@ func (tout *TxOut) BinRead(r io.Reader) (err error) {
 	if err = BinRead(&tout.Value, r); err != nil {
 		return err
 	}
-	if tout.ScriptPubKey, err = readString(r); err != nil {
+	if tout.ScriptPubKey, err = readStringMax(r, Limits.MaxScriptSize, "ScriptPubKey"); err != nil {
 		return err
 	}
 	return nil
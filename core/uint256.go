@@ -0,0 +1,168 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+)
+
+// We're sticking with value rather than pointer for now, we think it's
+// faster and safer. TODO: is it?
+type Uint256 [32]byte
+
+func (u Uint256) String() string {
+	for i := 0; i < 16; i++ {
+		u[i], u[31-i] = u[31-i], u[i]
+	}
+	return hex.EncodeToString(u[:])
+}
+
+// TODO This may be wrong, do we want this here?
+func (u Uint256) MarshalJSON() ([]byte, error) {
+	return json.Marshal(u.String())
+}
+
+// sql.Scanner so that pq can scan these values from postgres
+func (u *Uint256) Scan(value interface{}) error {
+	if b, ok := value.([]byte); !ok {
+		return fmt.Errorf("Unexpected type: %T", value)
+	} else {
+		copy(u[:], b)
+	}
+	return nil
+}
+
+// NB: we interpret this as little-endian. Traditionally Bitcoin
+// transaction ids are printed in big-endian, i.e. reverse of this.
+func ShaSha256(b []byte) Uint256 {
+	first := sha256.Sum256(b)
+	return sha256.Sum256(first[:])
+}
+
+func Uint256FromBytes(from []byte) Uint256 {
+	var result Uint256
+	copy(result[:], from)
+	return result
+}
+
+func Uint256FromString(from string) (Uint256, error) {
+	if len(from) != 32*2 {
+		return Uint256{}, fmt.Errorf("Incorrect length.")
+	}
+	b, err := hex.DecodeString(from)
+	if err != nil {
+		return Uint256{}, err
+	}
+	for i := 0; i < 16; i++ {
+		b[i], b[31-i] = b[31-i], b[i]
+	}
+	return Uint256FromBytes(b), nil
+}
+
+// Big returns the value as a big.Int. Uint256 is little-endian, i.e.
+// the byte order is reversed compared to big.Int.SetBytes().
+func (u Uint256) Big() *big.Int {
+	for i := 0; i < 16; i++ {
+		u[i], u[31-i] = u[31-i], u[i]
+	}
+	return new(big.Int).SetBytes(u[:])
+}
+
+// Uint256FromBig converts a big.Int to Uint256. Negative numbers
+// and numbers that do not fit in 256 bits are truncated to the lower
+// 256 bits of their absolute value.
+func Uint256FromBig(b *big.Int) Uint256 {
+	var result Uint256
+	bs := b.Bytes() // big-endian
+	for i := 0; i < len(bs) && i < 32; i++ {
+		result[i] = bs[len(bs)-1-i]
+	}
+	return result
+}
+
+// Cmp compares u and v as numbers and returns -1, 0 or +1.
+func (u Uint256) Cmp(v Uint256) int {
+	for i := 31; i >= 0; i-- {
+		if u[i] < v[i] {
+			return -1
+		} else if u[i] > v[i] {
+			return 1
+		}
+	}
+	return 0
+}
+
+func (u Uint256) IsZero() bool {
+	return u == Uint256{}
+}
+
+// Add returns u + v modulo 2^256.
+func (u Uint256) Add(v Uint256) Uint256 {
+	var carry uint16
+	for i := 0; i < 32; i++ {
+		sum := uint16(u[i]) + uint16(v[i]) + carry
+		u[i] = byte(sum)
+		carry = sum >> 8
+	}
+	return u
+}
+
+// Sub returns u - v modulo 2^256.
+func (u Uint256) Sub(v Uint256) Uint256 {
+	var borrow int16
+	for i := 0; i < 32; i++ {
+		diff := int16(u[i]) - int16(v[i]) - borrow
+		if diff < 0 {
+			diff += 256
+			borrow = 1
+		} else {
+			borrow = 0
+		}
+		u[i] = byte(diff)
+	}
+	return u
+}
+
+// SetCompact sets u to the value of the compact (nBits)
+// representation. The negative and overflow results are the same as
+// in Core arith_uint256::SetCompact(), such values are not valid
+// targets.
+// https://github.com/bitcoin/bitcoin/blob/v22.0/src/arith_uint256.cpp#L203
+func (u *Uint256) SetCompact(compact uint32) (negative, overflow bool) {
+	size := compact >> 24
+	mantissa := compact & 0x007fffff
+	if size <= 3 { // the flags are of what is left of it
+		mantissa >>= 8 * (3 - size)
+	}
+
+	negative = mantissa != 0 && compact&0x00800000 != 0
+	overflow = mantissa != 0 && (size > 34 ||
+		(mantissa > 0xff && size > 33) ||
+		(mantissa > 0xffff && size > 32))
+
+	*u = Uint256FromBig(new(big.Int).Abs(CompactToBig(compact)))
+	return negative, overflow
+}
+
+// ToCompact returns the compact (nBits) representation of u. This is
+// lossy, only the 3 most significant bytes are preserved.
+// https://github.com/bitcoin/bitcoin/blob/v22.0/src/arith_uint256.cpp#L223
+func (u Uint256) ToCompact() uint32 {
+	b := u.Big()
+	size := uint32((b.BitLen() + 7) / 8)
+	var compact uint32
+	if size <= 3 {
+		compact = uint32(b.Uint64() << (8 * (3 - size)))
+	} else {
+		compact = uint32(new(big.Int).Rsh(b, uint(8*(size-3))).Uint64())
+	}
+	// The 0x00800000 bit denotes the sign, if it is already set,
+	// divide the mantissa by 256 and increase the exponent.
+	if compact&0x00800000 != 0 {
+		compact >>= 8
+		size++
+	}
+	return compact | size<<24
+}
@@ -0,0 +1,75 @@
+package core_test
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/blkchain/blkchain/core"
+)
+
+// The compact vectors of arith_uint256_tests.cpp of Core
+// (bignum_SetCompact): the value, the flags, and the compact the value
+// gives back, with the mantissa normalised and no sign.
+func TestCompact(t *testing.T) {
+	for _, test := range []struct {
+		compact            uint32
+		value              string // hex
+		negative, overflow bool
+		back               uint32
+	}{
+		{0, "0", false, false, 0},
+		{0x00123456, "0", false, false, 0},
+		{0x01003456, "0", false, false, 0},
+		{0x02000056, "0", false, false, 0},
+		{0x03000000, "0", false, false, 0},
+		{0x04000000, "0", false, false, 0},
+		{0x00923456, "0", false, false, 0},
+		{0x01803456, "0", false, false, 0},
+		{0x02800056, "0", false, false, 0},
+		{0x03800000, "0", false, false, 0},
+		{0x04800000, "0", false, false, 0},
+		{0x01123456, "12", false, false, 0x01120000},
+		{0x01fedcba, "7e", true, false, 0x017e0000},
+		{0x02123456, "1234", false, false, 0x02123400},
+		{0x03123456, "123456", false, false, 0x03123456},
+		{0x04123456, "12345600", false, false, 0x04123456},
+		{0x04923456, "12345600", true, false, 0x04123456},
+		{0x05009234, "92340000", false, false, 0x05009234},
+		{0x20123456, "1234560000000000000000000000000000000000000000000000000000000000", false, false, 0x20123456},
+		{0xff123456, "", false, true, 0},
+	} {
+		var u core.Uint256
+		negative, overflow := u.SetCompact(test.compact)
+		if negative != test.negative || overflow != test.overflow {
+			t.Errorf("%#08x: negative %v, overflow %v, expected %v, %v", test.compact, negative, overflow, test.negative, test.overflow)
+		}
+		if overflow {
+			continue
+		}
+		want, _ := new(big.Int).SetString(test.value, 16)
+		if u.Big().Cmp(want) != 0 {
+			t.Errorf("%#08x: %x, expected %s", test.compact, u.Big(), test.value)
+		}
+		if back := u.ToCompact(); back != test.back {
+			t.Errorf("%#08x: back to %#08x, expected %#08x", test.compact, back, test.back)
+		}
+	}
+
+	// The sign bit is never set, a mantissa with the top bit set is
+	// shifted into the next byte.
+	for _, test := range []struct {
+		value   int64
+		compact uint32
+	}{
+		{0x80, 0x02008000},
+		{0x7f, 0x017f0000},
+		{0x8000, 0x03008000},
+		{0x800000, 0x04008000},
+		{0x12345678, 0x04123456},
+	} {
+		u := core.Uint256FromBig(big.NewInt(test.value))
+		if got := u.ToCompact(); got != test.compact {
+			t.Errorf("%#x: %#08x, expected %#08x", test.value, got, test.compact)
+		}
+	}
+}
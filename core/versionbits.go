@@ -0,0 +1,58 @@
+package core
+
+// BIP9 version bits signaling.
+// https://github.com/bitcoin/bips/blob/master/bip-0009.mediawiki
+
+const (
+	VersionBitsTopMask = 0xe0000000
+	VersionBitsTopBits = 0x20000000
+	VersionBitsNumBits = 29
+
+	// Blocks per retarget period, also the BIP9 signaling window.
+	RetargetInterval = 2016
+)
+
+type Deployment struct {
+	Name      string
+	Bit       int
+	StartTime int64
+	Timeout   int64
+	Threshold int // blocks out of RetargetInterval needed to lock in
+}
+
+// Known mainnet deployments. Taproot was activated via Speedy Trial
+// (BIP341 deployment, 90% threshold), the others with the 95% BIP9
+// threshold.
+var MainNetDeployments = []Deployment{
+	{Name: "csv", Bit: 0, StartTime: 1462060800, Timeout: 1493596800, Threshold: 1916},
+	{Name: "segwit", Bit: 1, StartTime: 1479168000, Timeout: 1510704000, Threshold: 1916},
+	{Name: "taproot", Bit: 2, StartTime: 1619222400, Timeout: 1628640000, Threshold: 1815},
+}
+
+// HasVersionBits is true if the block version uses the BIP9 top bits
+// pattern, otherwise none of the bits should be considered signals.
+func (bh *BlockHeader) HasVersionBits() bool {
+	return uint32(bh.Version)&VersionBitsTopMask == VersionBitsTopBits
+}
+
+// Signals returns true if the block signals readiness for the given bit.
+func (bh *BlockHeader) Signals(bit int) bool {
+	if bit < 0 || bit >= VersionBitsNumBits || !bh.HasVersionBits() {
+		return false
+	}
+	return uint32(bh.Version)&(1<<uint(bit)) != 0
+}
+
+// SignalBits returns all the bits signaled by the block.
+func (bh *BlockHeader) SignalBits() []int {
+	if !bh.HasVersionBits() {
+		return nil
+	}
+	var bits []int
+	for bit := 0; bit < VersionBitsNumBits; bit++ {
+		if uint32(bh.Version)&(1<<uint(bit)) != 0 {
+			bits = append(bits, bit)
+		}
+	}
+	return bits
+}
@@ -1,4 +1,4 @@
-package blkchain
+package core
 
 import "io"
 
@@ -11,9 +11,9 @@ func (wi WitnessItem) Size() int {
 type Witness []WitnessItem
 
 func (wits *Witness) BinRead(r io.Reader) error {
-	return readList(r, func(r io.Reader) error {
+	return readListMax(r, Limits.MaxWitnessItems, "witness items", func(r io.Reader) error {
 		var wit WitnessItem
-		wit, err := readString(r)
+		wit, err := readStringMax(r, Limits.MaxWitnessItemSize, "Witness item")
 		if err != nil {
 			return err
 		}
@@ -0,0 +1,636 @@
+// Code generated by cmd/aliasgen. DO NOT EDIT.
+
+package blkchain
+
+import (
+	"io"
+	"math/big"
+
+	"github.com/blkchain/blkchain/core"
+)
+
+type AddressParams = core.AddressParams
+
+func AddressParamsFor(magic uint32) *AddressParams {
+	return core.AddressParamsFor(magic)
+}
+
+func EncodeSegwitAddress(hrp string, version byte, program []byte) (string, error) {
+	return core.EncodeSegwitAddress(hrp, version, program)
+}
+
+func DecodeSegwitAddress(address string) (string, byte, []byte, error) {
+	return core.DecodeSegwitAddress(address)
+}
+
+func ScriptAddress(script []byte, p *AddressParams) string {
+	return core.ScriptAddress(script, p)
+}
+
+func ScriptPayload(script []byte) []byte {
+	return core.ScriptPayload(script)
+}
+
+func IsSegwitAddress(address string) bool {
+	return core.IsSegwitAddress(address)
+}
+
+func AddressScript(address string, p *AddressParams) ([]byte, error) {
+	return core.AddressScript(address, p)
+}
+
+var ErrBase58Checksum = core.ErrBase58Checksum
+
+type Base58CharError = core.Base58CharError
+
+func Base58Encode(b []byte) string {
+	return core.Base58Encode(b)
+}
+
+func Base58Decode(s string) ([]byte, error) {
+	return core.Base58Decode(s)
+}
+
+func Base58CheckEncode(data []byte) string {
+	return core.Base58CheckEncode(data)
+}
+
+func Base58CheckDecode(s string) ([]byte, error) {
+	return core.Base58CheckDecode(s)
+}
+
+type Bech32Encoding = core.Bech32Encoding
+
+const Bech32 = core.Bech32
+
+const Bech32m = core.Bech32m
+
+var ErrBech32Checksum = core.ErrBech32Checksum
+
+type Bech32CharError = core.Bech32CharError
+
+func Bech32Encode(hrp string, data []byte, enc Bech32Encoding) (string, error) {
+	return core.Bech32Encode(hrp, data, enc)
+}
+
+func Bech32Decode(s string) (string, []byte, Bech32Encoding, error) {
+	return core.Bech32Decode(s)
+}
+
+func ConvertBits(data []byte, fromBits uint, toBits uint, pad bool) ([]byte, error) {
+	return core.ConvertBits(data, fromBits, toBits, pad)
+}
+
+type BinReader = core.BinReader
+
+type BinWriter = core.BinWriter
+
+func BinRead(s interface{}, r io.Reader) error {
+	return core.BinRead(s, r)
+}
+
+func BinWrite(s interface{}, w io.Writer) error {
+	return core.BinWrite(s, w)
+}
+
+func ReadCompactSize(r io.Reader) (uint64, error) {
+	return core.ReadCompactSize(r)
+}
+
+func ReadVarInt(r io.Reader) (uint64, error) {
+	return core.ReadVarInt(r)
+}
+
+func WriteVarInt(n uint64, w io.Writer) error {
+	return core.WriteVarInt(n, w)
+}
+
+const MainNetMagic = core.MainNetMagic
+
+const TestNetMagic = core.TestNetMagic
+
+const TestNet4Magic = core.TestNet4Magic
+
+const SigNetMagic = core.SigNetMagic
+
+const RegTestMagic = core.RegTestMagic
+
+type Block = core.Block
+
+type BlockHeader = core.BlockHeader
+
+type BlockHeaderIndex = core.BlockHeaderIndex
+
+func ReadXorKey(blocksDir string) ([]byte, error) {
+	return core.ReadXorKey(blocksDir)
+}
+
+type BlockFile = core.BlockFile
+
+func OpenBlockFile(path string, key []byte) (*BlockFile, error) {
+	return core.OpenBlockFile(path, key)
+}
+
+const MaxBlockFileSize = core.MaxBlockFileSize
+
+type BlockFileWriter = core.BlockFileWriter
+
+func NewBlockFileWriter(dir string, magic uint32, start int, maxSize int64) (*BlockFileWriter, error) {
+	return core.NewBlockFileWriter(dir, magic, start, maxSize)
+}
+
+func NewBlockFileWriterFunc(create func(name string) (io.WriteCloser, error), magic uint32, start int, maxSize int64) *BlockFileWriter {
+	return core.NewBlockFileWriterFunc(create, magic, start, maxSize)
+}
+
+const Brc20Deploy = core.Brc20Deploy
+
+const Brc20Mint = core.Brc20Mint
+
+const Brc20Transfer = core.Brc20Transfer
+
+const Brc20MaxDecimals = core.Brc20MaxDecimals
+
+type Brc20Op = core.Brc20Op
+
+func Brc20Amount(s string, decimals int) *big.Rat {
+	return core.Brc20Amount(s, decimals)
+}
+
+func CheckpointAt(magic uint32, height int) (Uint256, bool) {
+	return core.CheckpointAt(magic, height)
+}
+
+func VerifyCheckpoint(magic uint32, height int, hash Uint256) error {
+	return core.VerifyCheckpoint(magic, height, hash)
+}
+
+const CoinjoinWhirlpool = core.CoinjoinWhirlpool
+
+const CoinjoinWasabi = core.CoinjoinWasabi
+
+const CoinjoinWasabi2 = core.CoinjoinWasabi2
+
+const CoinjoinJoinMarket = core.CoinjoinJoinMarket
+
+func NewCoreStore(dir string, start int, magic uint32) (io.Reader, error) {
+	return core.NewCoreStore(dir, start, magic)
+}
+
+const DiffOneBits = core.DiffOneBits
+
+func CompactToBig(compact uint32) *big.Int {
+	return core.CompactToBig(compact)
+}
+
+func Difficulty(bits uint32) float64 {
+	return core.Difficulty(bits)
+}
+
+func HashRate(bits uint32, blockInterval float64) float64 {
+	return core.HashRate(bits, blockInterval)
+}
+
+func BlockWork(bits uint32) *big.Int {
+	return core.BlockWork(bits)
+}
+
+const HDAddressP2PKH = core.HDAddressP2PKH
+
+const HDAddressP2SHP2WPKH = core.HDAddressP2SHP2WPKH
+
+const HDAddressP2WPKH = core.HDAddressP2WPKH
+
+type ExtendedKey = core.ExtendedKey
+
+func ParseExtendedKey(s string) (*ExtendedKey, error) {
+	return core.ParseExtendedKey(s)
+}
+
+const INSCRIPTION_TAG_CONTENT_TYPE = core.INSCRIPTION_TAG_CONTENT_TYPE
+
+const INSCRIPTION_TAG_CONTENT_ENCODING = core.INSCRIPTION_TAG_CONTENT_ENCODING
+
+type Inscription = core.Inscription
+
+const OP_RESERVED = core.OP_RESERVED
+
+const OP_NOP = core.OP_NOP
+
+const OP_VER = core.OP_VER
+
+const OP_NOTIF = core.OP_NOTIF
+
+const OP_VERIF = core.OP_VERIF
+
+const OP_VERNOTIF = core.OP_VERNOTIF
+
+const OP_ELSE = core.OP_ELSE
+
+const OP_VERIFY = core.OP_VERIFY
+
+const OP_TOALTSTACK = core.OP_TOALTSTACK
+
+const OP_FROMALTSTACK = core.OP_FROMALTSTACK
+
+const OP_2DROP = core.OP_2DROP
+
+const OP_2DUP = core.OP_2DUP
+
+const OP_3DUP = core.OP_3DUP
+
+const OP_2OVER = core.OP_2OVER
+
+const OP_2ROT = core.OP_2ROT
+
+const OP_2SWAP = core.OP_2SWAP
+
+const OP_IFDUP = core.OP_IFDUP
+
+const OP_DEPTH = core.OP_DEPTH
+
+const OP_DROP = core.OP_DROP
+
+const OP_DUP = core.OP_DUP
+
+const OP_NIP = core.OP_NIP
+
+const OP_OVER = core.OP_OVER
+
+const OP_PICK = core.OP_PICK
+
+const OP_ROLL = core.OP_ROLL
+
+const OP_ROT = core.OP_ROT
+
+const OP_SWAP = core.OP_SWAP
+
+const OP_TUCK = core.OP_TUCK
+
+const OP_CAT = core.OP_CAT
+
+const OP_SUBSTR = core.OP_SUBSTR
+
+const OP_LEFT = core.OP_LEFT
+
+const OP_RIGHT = core.OP_RIGHT
+
+const OP_SIZE = core.OP_SIZE
+
+const OP_INVERT = core.OP_INVERT
+
+const OP_AND = core.OP_AND
+
+const OP_OR = core.OP_OR
+
+const OP_XOR = core.OP_XOR
+
+const OP_EQUAL = core.OP_EQUAL
+
+const OP_EQUALVERIFY = core.OP_EQUALVERIFY
+
+const OP_RESERVED1 = core.OP_RESERVED1
+
+const OP_RESERVED2 = core.OP_RESERVED2
+
+const OP_1ADD = core.OP_1ADD
+
+const OP_1SUB = core.OP_1SUB
+
+const OP_2MUL = core.OP_2MUL
+
+const OP_2DIV = core.OP_2DIV
+
+const OP_NEGATE = core.OP_NEGATE
+
+const OP_ABS = core.OP_ABS
+
+const OP_NOT = core.OP_NOT
+
+const OP_0NOTEQUAL = core.OP_0NOTEQUAL
+
+const OP_ADD = core.OP_ADD
+
+const OP_SUB = core.OP_SUB
+
+const OP_MUL = core.OP_MUL
+
+const OP_DIV = core.OP_DIV
+
+const OP_MOD = core.OP_MOD
+
+const OP_LSHIFT = core.OP_LSHIFT
+
+const OP_RSHIFT = core.OP_RSHIFT
+
+const OP_BOOLAND = core.OP_BOOLAND
+
+const OP_BOOLOR = core.OP_BOOLOR
+
+const OP_NUMEQUAL = core.OP_NUMEQUAL
+
+const OP_NUMEQUALVERIFY = core.OP_NUMEQUALVERIFY
+
+const OP_NUMNOTEQUAL = core.OP_NUMNOTEQUAL
+
+const OP_LESSTHAN = core.OP_LESSTHAN
+
+const OP_GREATERTHAN = core.OP_GREATERTHAN
+
+const OP_LESSTHANOREQUAL = core.OP_LESSTHANOREQUAL
+
+const OP_GREATERTHANOREQUAL = core.OP_GREATERTHANOREQUAL
+
+const OP_MIN = core.OP_MIN
+
+const OP_MAX = core.OP_MAX
+
+const OP_WITHIN = core.OP_WITHIN
+
+const OP_RIPEMD160 = core.OP_RIPEMD160
+
+const OP_SHA1 = core.OP_SHA1
+
+const OP_SHA256 = core.OP_SHA256
+
+const OP_HASH160 = core.OP_HASH160
+
+const OP_HASH256 = core.OP_HASH256
+
+const OP_CODESEPARATOR = core.OP_CODESEPARATOR
+
+const OP_CHECKSIG = core.OP_CHECKSIG
+
+const OP_CHECKSIGVERIFY = core.OP_CHECKSIGVERIFY
+
+const OP_CHECKMULTISIG = core.OP_CHECKMULTISIG
+
+const OP_CHECKMULTISIGVERIFY = core.OP_CHECKMULTISIGVERIFY
+
+const OP_NOP1 = core.OP_NOP1
+
+const OP_CHECKLOCKTIMEVERIFY = core.OP_CHECKLOCKTIMEVERIFY
+
+const OP_CHECKSEQUENCEVERIFY = core.OP_CHECKSEQUENCEVERIFY
+
+const OP_NOP4 = core.OP_NOP4
+
+const OP_NOP10 = core.OP_NOP10
+
+const OP_CHECKSIGADD = core.OP_CHECKSIGADD
+
+const TapLeafVersion = core.TapLeafVersion
+
+type ScriptFlags = core.ScriptFlags
+
+const ScriptVerifyP2SH = core.ScriptVerifyP2SH
+
+const ScriptVerifyCheckLockTime = core.ScriptVerifyCheckLockTime
+
+const ScriptVerifyCheckSequence = core.ScriptVerifyCheckSequence
+
+const ScriptVerifyWitness = core.ScriptVerifyWitness
+
+const ScriptVerifyTaproot = core.ScriptVerifyTaproot
+
+const ScriptVerifyDERSig = core.ScriptVerifyDERSig
+
+const ScriptVerifyNullDummy = core.ScriptVerifyNullDummy
+
+const ScriptVerifyAll = core.ScriptVerifyAll
+
+func ScriptFlagsAt(height int) ScriptFlags {
+	return core.ScriptFlagsAt(height)
+}
+
+type SigVersion = core.SigVersion
+
+const SigVersionBase = core.SigVersionBase
+
+const SigVersionWitnessV0 = core.SigVersionWitnessV0
+
+const SigVersionTaproot = core.SigVersionTaproot
+
+const SigVersionTapscript = core.SigVersionTapscript
+
+type SigContext = core.SigContext
+
+type SigChecker = core.SigChecker
+
+var ErrScriptFailed = core.ErrScriptFailed
+
+func VerifyScript(tx *Tx, n int, prevOut *TxOut, flags ScriptFlags, checker SigChecker) error {
+	return core.VerifyScript(tx, n, prevOut, flags, checker)
+}
+
+func Hash160(b []byte) []byte {
+	return core.Hash160(b)
+}
+
+func TaggedHash(tag string, msgs ...[]byte) Uint256 {
+	return core.TaggedHash(tag, msgs...)
+}
+
+func TapLeafHash(leafVersion byte, script []byte) Uint256 {
+	return core.TapLeafHash(leafVersion, script)
+}
+
+func TapBranchHash(a []byte, b []byte) Uint256 {
+	return core.TapBranchHash(a, b)
+}
+
+type SigEncodingChecker = core.SigEncodingChecker
+
+type ParseLimits = core.ParseLimits
+
+func MerkleRoot(hashes []Uint256) Uint256 {
+	return core.MerkleRoot(hashes)
+}
+
+type PSBTField = core.PSBTField
+
+type PSBT = core.PSBT
+
+type PSBTInput = core.PSBTInput
+
+type PSBTOutput = core.PSBTOutput
+
+func DecodePSBTString(s string) (*PSBT, error) {
+	return core.DecodePSBTString(s)
+}
+
+func DecodePSBT(b []byte) (*PSBT, error) {
+	return core.DecodePSBT(b)
+}
+
+const OP_13 = core.OP_13
+
+const RuneMaxDivisibility = core.RuneMaxDivisibility
+
+const RuneMaxSpacers = core.RuneMaxSpacers
+
+type RuneId = core.RuneId
+
+type RuneEdict = core.RuneEdict
+
+type RuneTerms = core.RuneTerms
+
+type RuneEtching = core.RuneEtching
+
+type Runestone = core.Runestone
+
+func RuneName(n *big.Int) string {
+	return core.RuneName(n)
+}
+
+func SpacedRuneName(name string, spacers uint32) string {
+	return core.SpacedRuneName(name, spacers)
+}
+
+func ReservedRune(id RuneId) *big.Int {
+	return core.ReservedRune(id)
+}
+
+const OP_0 = core.OP_0
+
+const OP_PUSHDATA1 = core.OP_PUSHDATA1
+
+const OP_PUSHDATA2 = core.OP_PUSHDATA2
+
+const OP_PUSHDATA4 = core.OP_PUSHDATA4
+
+const OP_1NEGATE = core.OP_1NEGATE
+
+const OP_1 = core.OP_1
+
+const OP_16 = core.OP_16
+
+const OP_IF = core.OP_IF
+
+const OP_ENDIF = core.OP_ENDIF
+
+const OP_RETURN = core.OP_RETURN
+
+const ANNEX_TAG = core.ANNEX_TAG
+
+type ScriptOp = core.ScriptOp
+
+func ParseScript(script []byte) ([]*ScriptOp, error) {
+	return core.ParseScript(script)
+}
+
+func ParseMultisig(script []byte) (int, [][]byte, bool) {
+	return core.ParseMultisig(script)
+}
+
+type TxSigChecker = core.TxSigChecker
+
+func NewTxSigChecker(tx *Tx, n int, prevOuts []*TxOut) *TxSigChecker {
+	return core.NewTxSigChecker(tx, n, prevOuts)
+}
+
+func VerifyTxInput(tx *Tx, n int, prevOuts []*TxOut) error {
+	return core.VerifyTxInput(tx, n, prevOuts)
+}
+
+const SigHashDefault = core.SigHashDefault
+
+const SigHashAll = core.SigHashAll
+
+const SigHashNone = core.SigHashNone
+
+const SigHashSingle = core.SigHashSingle
+
+const SigHashAnyoneCanPay = core.SigHashAnyoneCanPay
+
+type SigHasher = core.SigHasher
+
+func NewSigHasher(tx *Tx, prevOuts []*TxOut) *SigHasher {
+	return core.NewSigHasher(tx, prevOuts)
+}
+
+func LegacySigHash(tx *Tx, n int, scriptCode []byte, hashType uint32) Uint256 {
+	return core.LegacySigHash(tx, n, scriptCode, hashType)
+}
+
+func WitnessV0SigHash(tx *Tx, n int, scriptCode []byte, amount int64, hashType uint32) Uint256 {
+	return core.WitnessV0SigHash(tx, n, scriptCode, amount, hashType)
+}
+
+func TaprootSigHash(tx *Tx, n int, prevOuts []*TxOut, hashType byte, annex []byte, leafHash *Uint256, codeSepPos uint32) (Uint256, error) {
+	return core.TaprootSigHash(tx, n, prevOuts, hashType, annex, leafHash, codeSepPos)
+}
+
+const Coin = core.Coin
+
+const InitialSubsidy = core.InitialSubsidy
+
+const MaxMoney = core.MaxMoney
+
+const CoinbaseMaturity = core.CoinbaseMaturity
+
+type ChainParams = core.ChainParams
+
+func ChainParamsFor(magic uint32) *ChainParams {
+	return core.ChainParamsFor(magic)
+}
+
+func SubsidyAt(height int) int64 {
+	return core.SubsidyAt(height)
+}
+
+func HalvingEpoch(height int) int {
+	return core.HalvingEpoch(height)
+}
+
+func ExpectedSupply(height int) int64 {
+	return core.ExpectedSupply(height)
+}
+
+type Tx = core.Tx
+
+type TxList = core.TxList
+
+type OutPoint = core.OutPoint
+
+type TxIn = core.TxIn
+
+type TxInList = core.TxInList
+
+type TxOut = core.TxOut
+
+type TxOutList = core.TxOutList
+
+type Uint256 = core.Uint256
+
+func ShaSha256(b []byte) Uint256 {
+	return core.ShaSha256(b)
+}
+
+func Uint256FromBytes(from []byte) Uint256 {
+	return core.Uint256FromBytes(from)
+}
+
+func Uint256FromString(from string) (Uint256, error) {
+	return core.Uint256FromString(from)
+}
+
+func Uint256FromBig(b *big.Int) Uint256 {
+	return core.Uint256FromBig(b)
+}
+
+type Uint32 = core.Uint32
+
+const VersionBitsTopMask = core.VersionBitsTopMask
+
+const VersionBitsTopBits = core.VersionBitsTopBits
+
+const VersionBitsNumBits = core.VersionBitsNumBits
+
+const RetargetInterval = core.RetargetInterval
+
+type Deployment = core.Deployment
+
+type WitnessItem = core.WitnessItem
+
+type Witness = core.Witness
@@ -0,0 +1,149 @@
+package coredb
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/blkchain/blkchain/core"
+)
+
+// The layout of a Core data directory: the mainnet files are in the
+// directory itself, those of the other networks in a subdirectory of
+// it named after the network. Each has blocks (with the LevelDb block
+// index in blocks/index) and chainstate.
+
+const (
+	NetworkMain     = "main"
+	NetworkTestNet3 = "testnet3"
+	NetworkTestNet4 = "testnet4"
+	NetworkSigNet   = "signet"
+	NetworkRegTest  = "regtest"
+)
+
+var networkMagic = map[string]uint32{
+	NetworkMain:     core.MainNetMagic,
+	NetworkTestNet3: core.TestNetMagic,
+	NetworkTestNet4: core.TestNet4Magic,
+	NetworkSigNet:   core.SigNetMagic,
+	NetworkRegTest:  core.RegTestMagic,
+}
+
+// In the order in which they are tried when the network is not given.
+var subdirNetworks = []string{NetworkTestNet3, NetworkTestNet4, NetworkSigNet, NetworkRegTest}
+
+type DataDir struct {
+	Path       string // of the network, i.e. including the subdirectory
+	Network    string
+	Magic      uint32
+	Blocks     string
+	Index      string
+	ChainState string
+}
+
+// NetworkMagic returns the magic of network ("testnet" meaning
+// testnet3).
+func NetworkMagic(network string) (uint32, bool) {
+	if network == "testnet" {
+		network = NetworkTestNet3
+	}
+	magic, ok := networkMagic[network]
+	return magic, ok
+}
+
+// DefaultDataDir returns the platform default data directory of Core,
+// or "" if the home directory is unknown.
+func DefaultDataDir() string {
+	switch runtime.GOOS {
+	case "windows":
+		if appData := os.Getenv("APPDATA"); appData != "" {
+			return filepath.Join(appData, "Bitcoin")
+		}
+		return ""
+	case "darwin":
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, "Library", "Application Support", "Bitcoin")
+		}
+		return ""
+	default:
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, ".bitcoin")
+		}
+		return ""
+	}
+}
+
+// FindDataDir locates the files of network (one of the Network
+// constants, "testnet" meaning testnet3) in the data directory path,
+// which may also be the network subdirectory itself. With an empty
+// network, mainnet is used if path has blocks, else the only network
+// subdirectory there is.
+func FindDataDir(path, network string) (*DataDir, error) {
+	if network == "testnet" {
+		network = NetworkTestNet3
+	}
+	if _, ok := networkMagic[network]; network != "" && !ok {
+		return nil, fmt.Errorf("Unknown network %q, expecting one of %s.", network, strings.Join(networkNames(), ", "))
+	}
+	if !isDir(path) {
+		return nil, fmt.Errorf("Data directory %s does not exist.", path)
+	}
+
+	// The network subdirectory itself
+	base := filepath.Base(filepath.Clean(path))
+	if _, ok := networkMagic[base]; ok && base != NetworkMain && isDir(filepath.Join(path, "blocks")) {
+		if network != "" && network != base {
+			return nil, fmt.Errorf("Data directory %s is for %s, not %s.", path, base, network)
+		}
+		return newDataDir(path, base), nil
+	}
+
+	switch network {
+	case "":
+		if isDir(filepath.Join(path, "blocks")) {
+			return newDataDir(path, NetworkMain), nil
+		}
+		var found []string
+		for _, n := range subdirNetworks {
+			if isDir(filepath.Join(path, n, "blocks")) {
+				found = append(found, n)
+			}
+		}
+		if len(found) == 0 {
+			return nil, fmt.Errorf("No blocks directory in %s.", path)
+		}
+		if len(found) > 1 {
+			return nil, fmt.Errorf("Data directory %s has several networks (%s), specify one.", path, strings.Join(found, ", "))
+		}
+		return newDataDir(filepath.Join(path, found[0]), found[0]), nil
+	case NetworkMain:
+	default:
+		path = filepath.Join(path, network)
+	}
+	if !isDir(filepath.Join(path, "blocks")) {
+		return nil, fmt.Errorf("No blocks directory in %s.", path)
+	}
+	return newDataDir(path, network), nil
+}
+
+func newDataDir(path, network string) *DataDir {
+	return &DataDir{
+		Path:       path,
+		Network:    network,
+		Magic:      networkMagic[network],
+		Blocks:     filepath.Join(path, "blocks"),
+		Index:      filepath.Join(path, "blocks", "index"),
+		ChainState: filepath.Join(path, "chainstate"),
+	}
+}
+
+func networkNames() []string {
+	return append([]string{NetworkMain}, subdirNetworks...)
+}
+
+func isDir(path string) bool {
+	fi, err := os.Stat(path)
+	return err == nil && fi.IsDir()
+}
@@ -5,10 +5,9 @@ import (
 	"fmt"
 	"io"
 	"log"
-	"os"
 	"path/filepath"
 
-	"github.com/blkchain/blkchain"
+	"github.com/blkchain/blkchain/core"
 	"github.com/syndtr/goleveldb/leveldb"
 	"github.com/syndtr/goleveldb/leveldb/iterator"
 	"github.com/syndtr/goleveldb/leveldb/opt"
@@ -24,7 +23,7 @@ type leveldbBlockHeader struct {
 	FileN   VarInt32
 	DataPos VarInt32
 	UndoPos VarInt32
-	blkchain.BlockHeader
+	core.BlockHeader
 }
 
 // https://github.com/bitcoin/bitcoin/blob/0.15/src/chain.h#L125
@@ -45,34 +44,34 @@ const (
 )
 
 func (ibh *leveldbBlockHeader) BinRead(r io.Reader) (err error) {
-	if err := blkchain.BinRead(&ibh.Version, r); err != nil {
+	if err := core.BinRead(&ibh.Version, r); err != nil {
 		return err
 	}
-	if err := blkchain.BinRead(&ibh.Height, r); err != nil {
+	if err := core.BinRead(&ibh.Height, r); err != nil {
 		return err
 	}
-	if err := blkchain.BinRead(&ibh.Status, r); err != nil {
+	if err := core.BinRead(&ibh.Status, r); err != nil {
 		return err
 	}
-	if err := blkchain.BinRead(&ibh.TxN, r); err != nil {
+	if err := core.BinRead(&ibh.TxN, r); err != nil {
 		return err
 	}
 	if (ibh.Status & (BLOCK_HAVE_DATA | BLOCK_HAVE_UNDO)) != 0 {
-		if err := blkchain.BinRead(&ibh.FileN, r); err != nil {
+		if err := core.BinRead(&ibh.FileN, r); err != nil {
 			return err
 		}
 	}
 	if (ibh.Status & BLOCK_HAVE_DATA) != 0 {
-		if err := blkchain.BinRead(&ibh.DataPos, r); err != nil {
+		if err := core.BinRead(&ibh.DataPos, r); err != nil {
 			return err
 		}
 	}
 	if (ibh.Status & BLOCK_HAVE_UNDO) != 0 {
-		if err := blkchain.BinRead(&ibh.UndoPos, r); err != nil {
+		if err := core.BinRead(&ibh.UndoPos, r); err != nil {
 			return err
 		}
 	}
-	if err = blkchain.BinRead(&ibh.BlockHeader, r); err != nil {
+	if err = core.BinRead(&ibh.BlockHeader, r); err != nil {
 		return err
 	}
 	return nil
@@ -81,10 +80,12 @@ func (ibh *leveldbBlockHeader) BinRead(r io.Reader) (err error) {
 type levelDbBlockHeaderIndex struct {
 	m          map[int][]*leveldbBlockHeader
 	blocksPath string
+	xorKey     []byte // see core.ReadXorKey
 	magic      uint32
 	height     int // current height
 	n          int // pos within height
 	count      int
+	undo       bool // see SetReadUndo
 }
 
 func (bi *levelDbBlockHeaderIndex) Next() bool {
@@ -106,7 +107,7 @@ func (bi *levelDbBlockHeaderIndex) Next() bool {
 type VarInt32 uint32
 
 func (v *VarInt32) BinRead(r io.Reader) error {
-	if i, err := blkchain.ReadVarInt(r); err != nil {
+	if i, err := core.ReadVarInt(r); err != nil {
 		return err
 	} else {
 		*v = VarInt32(i)
@@ -121,7 +122,7 @@ func (bi *levelDbBlockHeaderIndex) blockHeader() *leveldbBlockHeader {
 	return nil
 }
 
-func (bi *levelDbBlockHeaderIndex) BlockHeader() *blkchain.BlockHeader {
+func (bi *levelDbBlockHeaderIndex) BlockHeader() *core.BlockHeader {
 	bh := bi.blockHeader()
 	if bh != nil {
 		return &bh.BlockHeader
@@ -141,12 +142,12 @@ func (bi *levelDbBlockHeaderIndex) Close() error {
 	return nil
 }
 
-func (bi *levelDbBlockHeaderIndex) ReadBlock() (*blkchain.Block, error) {
+func (bi *levelDbBlockHeaderIndex) ReadBlock() (*core.Block, error) {
 
 	ibh := bi.blockHeader()
 
 	path := filepath.Join(bi.blocksPath, fmt.Sprintf("%s%05d.dat", "blk", int(ibh.FileN)))
-	f, err := os.Open(path)
+	f, err := core.OpenBlockFile(path, bi.xorKey)
 	if err != nil {
 		return nil, fmt.Errorf("Opening file %v: %v", path, err)
 	}
@@ -157,11 +158,15 @@ func (bi *levelDbBlockHeaderIndex) ReadBlock() (*blkchain.Block, error) {
 		return nil, fmt.Errorf("Seeking to pos %d in file %v: %v", pos, path, err)
 	}
 
-	b := blkchain.Block{Magic: bi.magic}
-	if err := blkchain.BinRead(&b, f); err != nil {
+	b := core.Block{Magic: bi.magic}
+	if err := core.BinRead(&b, f); err != nil {
 		return nil, fmt.Errorf("Reading block: %v", err)
 	}
 
+	if bi.undo {
+		bi.setPrevOutValues(ibh, &b)
+	}
+
 	return &b, nil
 }
 
@@ -171,7 +176,15 @@ func (bi *levelDbBlockHeaderIndex) ReadBlock() (*blkchain.Block, error) {
 // but that should be no longer an issue since we import UTXO set
 // separately?) Core cannot be running during this (TODO why?), but as
 // soon as it is done you should be able to start it back up.
-func ReadLevelDbBlockHeaderIndex(path, blocksPath string, magic uint32, startHeight int) (blkchain.BlockHeaderIndex, error) {
+func ReadLevelDbBlockHeaderIndex(path, blocksPath string, magic uint32, startHeight int) (core.BlockHeaderIndex, error) {
+
+	xorKey, err := core.ReadXorKey(blocksPath)
+	if err != nil {
+		return nil, err
+	}
+	if xorKey != nil {
+		log.Printf("Block files are obfuscated, using the key in %s.", blocksPath)
+	}
 
 	db, err := leveldb.OpenFile(path, &opt.Options{ReadOnly: true})
 	if err != nil {
@@ -183,6 +196,7 @@ func ReadLevelDbBlockHeaderIndex(path, blocksPath string, magic uint32, startHei
 		m:          make(map[int][]*leveldbBlockHeader, 500000),
 		height:     startHeight - 1, // because Next() will +1 height
 		blocksPath: blocksPath,
+		xorKey:     xorKey,
 		magic:      magic,
 	}
 
@@ -190,7 +204,7 @@ func ReadLevelDbBlockHeaderIndex(path, blocksPath string, magic uint32, startHei
 	for iter.Next() {
 
 		var bh leveldbBlockHeader
-		if err := blkchain.BinRead(&bh, bytes.NewReader(iter.Value())); err != nil {
+		if err := core.BinRead(&bh, bytes.NewReader(iter.Value())); err != nil {
 			return nil, err
 		}
 
@@ -233,11 +247,11 @@ func NewChainStateChecker(path string) (*ChainStateReader, error) {
 	return &ChainStateReader{db, nil}, nil
 }
 
-func (r *ChainStateReader) IsUTXO(hash blkchain.Uint256, n uint32) (bool, error) {
+func (r *ChainStateReader) IsUTXO(hash core.Uint256, n uint32) (bool, error) {
 	var buf [40]byte
 	buf[0] = 'C'
 	w := bytes.NewBuffer(buf[:1])
-	if err := blkchain.BinWrite(&DbOutPoint{hash, n}, w); err != nil {
+	if err := core.BinWrite(&DbOutPoint{hash, n}, w); err != nil {
 		return false, err
 	}
 	return r.Has(w.Bytes(), nil)
@@ -256,10 +270,10 @@ func NewChainStateIterator(path string) (*ChainStateReader, error) {
 // TODO unused
 func (r *ChainStateReader) GetUTXO() (*UTXO, error) {
 	var u UTXO
-	if err := blkchain.BinRead(&u.DbOutPoint, bytes.NewReader(r.Key()[1:])); err != nil {
+	if err := core.BinRead(&u.DbOutPoint, bytes.NewReader(r.Key()[1:])); err != nil {
 		return nil, err
 	}
-	if err := blkchain.BinRead(&u, bytes.NewReader(r.Value())); err != nil {
+	if err := core.BinRead(&u, bytes.NewReader(r.Value())); err != nil {
 		return nil, err
 	}
 	return &u, nil
@@ -0,0 +1,284 @@
+package coredb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"os"
+
+	"github.com/blkchain/blkchain/core"
+)
+
+// Reading the blk*.dat files without the LevelDb block index, e.g. when
+// the files were copied without it. The index is where the heights come
+// from, so instead a pre-pass reads the header of every block in the
+// files, remembering where it is, and links the blocks by PrevHash from
+// the genesis block (the one with no PrevHash) to work out their
+// heights. The best chain is the one with the most work, the blocks of
+// the other branches are orphans. Blocks which do not connect to the
+// genesis block, e.g. their parent is missing or in a corrupt stretch
+// of a file, are left out.
+
+// Block information found in the pre-pass.
+type rawBlockHeader struct {
+	core.BlockHeader
+	hash   core.Uint256
+	fileN  int
+	pos    int64    // of the block, after the magic and size
+	work   *big.Int // of the chain up to and including this block
+	orphan bool
+}
+
+type rawBlockHeaderIndex struct {
+	m          map[int][]*rawBlockHeader // the best chain block first
+	blocksPath string
+	xorKey     []byte
+	magic      uint32
+	height     int // current height
+	n          int // pos within height
+	count      int
+}
+
+func (bi *rawBlockHeaderIndex) Next() bool {
+	if len(bi.m) == 0 {
+		return false
+	}
+	if bi.n < len(bi.m[bi.height])-1 {
+		bi.n++
+	} else {
+		if len(bi.m[bi.height+1]) == 0 {
+			return false
+		}
+		bi.height++
+		bi.n = 0
+	}
+	return true
+}
+
+func (bi *rawBlockHeaderIndex) blockHeader() *rawBlockHeader {
+	if len(bi.m[bi.height]) > 0 {
+		return bi.m[bi.height][bi.n]
+	}
+	return nil
+}
+
+func (bi *rawBlockHeaderIndex) BlockHeader() *core.BlockHeader {
+	bh := bi.blockHeader()
+	if bh != nil {
+		return &bh.BlockHeader
+	}
+	return nil
+}
+
+// Orphan tells whether the current block is not on the best chain.
+func (bi *rawBlockHeaderIndex) Orphan() bool {
+	bh := bi.blockHeader()
+	return bh != nil && bh.orphan
+}
+
+func (bi *rawBlockHeaderIndex) Count() int {
+	return bi.count
+}
+
+func (bi *rawBlockHeaderIndex) CurrentHeight() int {
+	return bi.height
+}
+
+func (bi *rawBlockHeaderIndex) Close() error {
+	return nil
+}
+
+func (bi *rawBlockHeaderIndex) ReadBlock() (*core.Block, error) {
+
+	bh := bi.blockHeader()
+
+	path := blockFilePath(bi.blocksPath, bh.fileN)
+	f, err := core.OpenBlockFile(path, bi.xorKey)
+	if err != nil {
+		return nil, fmt.Errorf("Opening file %v: %v", path, err)
+	}
+	defer f.Close()
+
+	pos := bh.pos - 8 // magic + size = 8
+	if _, err := f.Seek(pos, 0); err != nil {
+		return nil, fmt.Errorf("Seeking to pos %d in file %v: %v", pos, path, err)
+	}
+
+	b := core.Block{Magic: bi.magic}
+	if err := core.BinRead(&b, f); err != nil {
+		return nil, fmt.Errorf("Reading block: %v", err)
+	}
+	if b.Hash() != bh.hash {
+		return nil, fmt.Errorf("Block at pos %d in file %v changed, expected %v, got %v.", pos, path, bh.hash, b.Hash())
+	}
+
+	return &b, nil
+}
+
+// ReadRawBlockHeaderIndex returns an index of the blocks in the block
+// files of blocksPath, by the heights worked out from the blocks
+// themselves (see above), starting at startHeight. Unlike the LevelDb
+// index, the orphans are included, at their heights, after the block of
+// the best chain.
+func ReadRawBlockHeaderIndex(blocksPath string, magic uint32, startHeight int) (core.BlockHeaderIndex, error) {
+
+	xorKey, err := core.ReadXorKey(blocksPath)
+	if err != nil {
+		return nil, err
+	}
+	if xorKey != nil {
+		log.Printf("Block files are obfuscated, using the key in %s.", blocksPath)
+	}
+
+	var headers []*rawBlockHeader
+	for idx := 0; ; idx++ {
+		path := blockFilePath(blocksPath, idx)
+		if _, err := os.Stat(path); err != nil {
+			if idx == 0 {
+				return nil, fmt.Errorf("No block files in %s.", blocksPath)
+			}
+			break
+		}
+		if headers, err = scanBlockFile(headers, path, idx, magic, xorKey); err != nil {
+			return nil, err
+		}
+		if idx%100 == 99 {
+			log.Printf(" - read the headers of %d files, %d blocks...", idx+1, len(headers))
+		}
+	}
+	log.Printf("Read %d block headers from the block files.", len(headers))
+
+	m, count, err := linkRawBlockHeaders(headers)
+	if err != nil {
+		return nil, err
+	}
+
+	return &rawBlockHeaderIndex{
+		m:          m,
+		height:     startHeight - 1, // because Next() will +1 height
+		blocksPath: blocksPath,
+		xorKey:     xorKey,
+		magic:      magic,
+		count:      count,
+	}, nil
+}
+
+// scanBlockFile appends the headers of the blocks in the file to
+// headers. It stops at the zeros the node preallocated, or at a bad
+// magic, which is logged.
+func scanBlockFile(headers []*rawBlockHeader, path string, idx int, magic uint32, xorKey []byte) ([]*rawBlockHeader, error) {
+	f, err := core.OpenBlockFile(path, xorKey)
+	if err != nil {
+		return nil, fmt.Errorf("Opening file %v: %v", path, err)
+	}
+	defer f.Close()
+
+	buf := make([]byte, 8+80) // magic, size and header
+	for pos := int64(0); ; {
+		n, err := f.ReadAt(buf, pos)
+		if n < len(buf) {
+			if err != nil && err != io.EOF {
+				return nil, fmt.Errorf("Reading file %v: %v", path, err)
+			}
+			return headers, nil
+		}
+		m := binary.LittleEndian.Uint32(buf[:4])
+		if m == 0 {
+			return headers, nil
+		}
+		if m != magic {
+			log.Printf("Bad magic %x at pos %d in file %v, ignoring the rest of it.", m, pos, path)
+			return headers, nil
+		}
+		size := int64(binary.LittleEndian.Uint32(buf[4:8]))
+		bh := &rawBlockHeader{fileN: idx, pos: pos + 8}
+		if err := core.BinRead(&bh.BlockHeader, bytes.NewReader(buf[8:])); err != nil {
+			return nil, err
+		}
+		bh.hash = bh.BlockHeader.Hash()
+		headers = append(headers, bh)
+		pos += 8 + size
+	}
+}
+
+// linkRawBlockHeaders works out the heights of the headers and which
+// are orphans, returning them by height and the number of them.
+func linkRawBlockHeaders(headers []*rawBlockHeader) (map[int][]*rawBlockHeader, int, error) {
+
+	byHash := make(map[core.Uint256]*rawBlockHeader, len(headers))
+	children := make(map[core.Uint256][]*rawBlockHeader, len(headers))
+	var genesis *rawBlockHeader
+	for _, bh := range headers {
+		if byHash[bh.hash] != nil {
+			continue // stored twice
+		}
+		byHash[bh.hash] = bh
+		if bh.PrevHash == (core.Uint256{}) {
+			if genesis != nil {
+				return nil, 0, fmt.Errorf("More than one genesis block: %v and %v.", genesis.hash, bh.hash)
+			}
+			genesis = bh
+			continue
+		}
+		children[bh.PrevHash] = append(children[bh.PrevHash], bh)
+	}
+	if genesis == nil {
+		return nil, 0, fmt.Errorf("No genesis block in the block files.")
+	}
+
+	// Breadth first from the genesis block, so a height is done before
+	// the next one.
+	m := make(map[int][]*rawBlockHeader)
+	genesis.work = genesis.Work()
+	best, bestHeight := genesis, 0
+	level, count := []*rawBlockHeader{genesis}, 0
+	for height := 0; len(level) > 0; height++ {
+		var next []*rawBlockHeader
+		for _, bh := range level {
+			m[height] = append(m[height], bh)
+			count++
+			if bh.work.Cmp(best.work) > 0 {
+				best, bestHeight = bh, height
+			}
+			for _, child := range children[bh.hash] {
+				child.work = new(big.Int).Add(bh.work, child.Work())
+				next = append(next, child)
+			}
+		}
+		level = next
+	}
+	if left := len(byHash) - count; left > 0 {
+		log.Printf("Ignoring %d blocks which do not connect to the genesis block.", left)
+	}
+
+	// Everything not on the way back from the best block is an orphan,
+	// and goes after the best chain block at its height.
+	for h := bestHeight; h >= 0; h-- {
+		blocks := m[h]
+		for i, bh := range blocks {
+			if bh != best {
+				bh.orphan = true
+				continue
+			}
+			blocks[0], blocks[i] = blocks[i], blocks[0]
+			if best.PrevHash != (core.Uint256{}) {
+				best = byHash[best.PrevHash]
+			}
+		}
+	}
+	for h := 0; len(m[h]) > 0; h++ {
+		for _, bh := range m[h] {
+			if h > bestHeight {
+				bh.orphan = true
+			}
+			if bh.orphan {
+				log.Printf("Orphan block %v at height %d.", bh.hash, h)
+			}
+		}
+	}
+
+	return m, count, nil
+}
@@ -0,0 +1,316 @@
+package coredb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/blkchain/blkchain/core"
+	"github.com/blkchain/blkchain/source"
+)
+
+// BlockFileTail follows the blk*.dat files of a running node, returning
+// the blocks as the node appends them, which is a way of following the
+// chain without RPC or P2P. The blocks and blocks/index directories are
+// watched for changes (inotify on Linux, polling elsewhere), the index
+// changes when the node has stored a block.
+//
+// The node writes a block at the position right after the previous one
+// in space it has preallocated with zeros, so a zero magic means there
+// is nothing new yet. Blocks are in the order in which the node got
+// them, which is not necessarily chain order.
+//
+// A corrupt stretch of a file (a bad magic, or a block which does not
+// parse with another one written after it) is skipped up to the next
+// magic, and recorded as a gap, see Gaps. The blocks lost in it can be
+// fetched from the node by RPC.
+
+const (
+	tailPollInterval = 10 * time.Second
+	tailMaxFilesBack = 3 // block files to search for the starting point
+)
+
+type BlockFileTail struct {
+	dir     string
+	magic   uint32
+	xorKey  []byte
+	idx     int   // current blk file
+	pos     int64 // offset of the next block
+	f       *core.BlockFile
+	watcher *dirWatcher
+	gaps    []BlockFileGap
+}
+
+// A BlockFileGap is a stretch of a block file which was skipped.
+type BlockFileGap struct {
+	File       string
+	Start, End int64 // offsets, End is that of the next magic (or the file size)
+}
+
+func (g BlockFileGap) String() string {
+	return fmt.Sprintf("%s offset %d-%d (%d bytes)", g.File, g.Start, g.End, g.End-g.Start)
+}
+
+func blockFilePath(dir string, idx int) string {
+	return filepath.Join(dir, fmt.Sprintf("blk%05d.dat", idx))
+}
+
+// NewBlockFileTail returns a tail which starts right after the last
+// block for which known returns true, searched for in the last few
+// block files.
+func NewBlockFileTail(blocksPath string, magic uint32, known func(core.Uint256) bool) (*BlockFileTail, error) {
+	last := -1
+	for idx := 0; ; idx++ {
+		if _, err := os.Stat(blockFilePath(blocksPath, idx)); err != nil {
+			break
+		}
+		last = idx
+	}
+	if last < 0 {
+		return nil, fmt.Errorf("No block files in %s.", blocksPath)
+	}
+
+	xorKey, err := core.ReadXorKey(blocksPath)
+	if err != nil {
+		return nil, err
+	}
+	t := &BlockFileTail{dir: blocksPath, magic: magic, xorKey: xorKey, idx: -1}
+	for idx := last; idx >= 0 && idx > last-tailMaxFilesBack; idx-- {
+		pos, err := t.lastKnown(idx, known)
+		if err != nil {
+			return nil, err
+		}
+		if pos >= 0 {
+			t.idx, t.pos = idx, pos
+			break
+		}
+	}
+	if t.idx < 0 {
+		return nil, fmt.Errorf("None of the last blocks in the database are in the last %d block files, catch up from the index first.", tailMaxFilesBack)
+	}
+	log.Printf("Following block files from %s offset %d.", blockFilePath(t.dir, t.idx), t.pos)
+
+	w, err := newDirWatcher(blocksPath, filepath.Join(blocksPath, "index"))
+	if err != nil {
+		return nil, err
+	}
+	t.watcher = w
+	return t, nil
+}
+
+// lastKnown returns the offset after the last known block in the file,
+// or -1 if there is none. Only the headers are read.
+func (t *BlockFileTail) lastKnown(idx int, known func(core.Uint256) bool) (int64, error) {
+	f, err := core.OpenBlockFile(blockFilePath(t.dir, idx), t.xorKey)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	result := int64(-1)
+	var pos int64
+	for {
+		var hdr [8 + 80]byte
+		if _, err := f.ReadAt(hdr[:], pos); err != nil {
+			break // EOF
+		}
+		magic, size := binary.LittleEndian.Uint32(hdr[:4]), binary.LittleEndian.Uint32(hdr[4:8])
+		if magic != t.magic {
+			break // zeros, i.e. the end
+		}
+		var bh core.BlockHeader
+		if err := core.BinRead(&bh, bytes.NewReader(hdr[8:])); err != nil {
+			return 0, err
+		}
+		pos += 8 + int64(size)
+		if known(bh.Hash()) {
+			result = pos
+		}
+	}
+	return result, nil
+}
+
+// Next waits for the next block, returns nil on interrupt.
+func (t *BlockFileTail) Next(interrupt chan bool) (*core.Block, error) {
+	for {
+		b, err := t.read()
+		if err != nil || b != nil {
+			return b, err
+		}
+		select {
+		case <-t.watcher.events:
+		case <-time.After(tailPollInterval):
+		case <-interrupt:
+			interrupt <- true // to keep len() > 0
+			return nil, nil
+		}
+	}
+}
+
+// tailSource is the tail as a BlockSource.
+type tailSource struct {
+	*BlockFileTail
+	interrupt chan bool
+}
+
+// Source returns the blocks of the tail as a BlockSource, without
+// their heights, io.EOF on interrupt.
+func (t *BlockFileTail) Source(interrupt chan bool) source.BlockSource {
+	return &tailSource{t, interrupt}
+}
+
+func (s *tailSource) Next() (*source.BlockInfo, error) {
+	b, err := s.BlockFileTail.Next(s.interrupt)
+	if err != nil {
+		return nil, err
+	}
+	if b == nil {
+		return nil, io.EOF
+	}
+	return &source.BlockInfo{Block: b, Height: -1}, nil
+}
+
+// read returns the block at the current position, or nil if it has not
+// been (completely) written yet.
+func (t *BlockFileTail) read() (*core.Block, error) {
+	for {
+		if t.f == nil {
+			f, err := core.OpenBlockFile(blockFilePath(t.dir, t.idx), t.xorKey)
+			if os.IsNotExist(err) {
+				return nil, nil
+			} else if err != nil {
+				return nil, err
+			}
+			t.f = f
+		}
+
+		var hdr [8]byte
+		n, err := t.f.ReadAt(hdr[:], t.pos)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		magic, size := binary.LittleEndian.Uint32(hdr[:4]), binary.LittleEndian.Uint32(hdr[4:8])
+		t.f.Xor(hdr[:], t.pos) // the raw bytes, preallocated space is zeros
+		if n < len(hdr) || binary.LittleEndian.Uint32(hdr[:4]) == 0 {
+			// Nothing here (yet), unless the node moved on to the
+			// next file.
+			if _, err := os.Stat(blockFilePath(t.dir, t.idx+1)); err != nil {
+				return nil, nil
+			}
+			t.f.Close()
+			t.f, t.idx, t.pos = nil, t.idx+1, 0
+			continue
+		}
+		if magic != t.magic {
+			if ok, err := t.skip(fmt.Sprintf("bad magic %x", magic)); !ok || err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if max := core.Limits.MaxBlockSize; max > 0 && int64(size) > int64(max) {
+			if ok, err := t.skip(fmt.Sprintf("block size %d exceeds the maximum of %d", size, max)); !ok || err != nil {
+				return nil, err
+			}
+			continue
+		}
+		rec := make([]byte, 8+int(size))
+		if n, err := t.f.ReadAt(rec, t.pos); n < len(rec) {
+			if err != nil && err != io.EOF {
+				return nil, err
+			}
+			if _, err := os.Stat(blockFilePath(t.dir, t.idx+1)); err != nil {
+				return nil, nil // partially written
+			}
+			// The node has moved on, the size is wrong.
+			if ok, err := t.skip(fmt.Sprintf("block size %d beyond the end of the file", size)); !ok || err != nil {
+				return nil, err
+			}
+			continue
+		}
+		b := core.Block{Magic: t.magic}
+		if err := core.BinRead(&b, bytes.NewReader(rec)); err != nil {
+			// Partially written, presumably, unless there is
+			// another block after it.
+			var next [4]byte
+			if n, _ := t.f.ReadAt(next[:], t.pos+int64(len(rec))); n < len(next) || binary.LittleEndian.Uint32(next[:]) != t.magic {
+				return nil, nil
+			}
+			if ok, err := t.skip(err.Error()); !ok || err != nil {
+				return nil, err
+			}
+			continue
+		}
+		t.pos += int64(len(rec))
+		return &b, nil
+	}
+}
+
+// skip skips to the next magic after the current position, or the next
+// file if there is none in this one, recording the gap. It returns
+// false if there is neither yet. The reason is logged.
+func (t *BlockFileTail) skip(reason string) (bool, error) {
+	var m [4]byte
+	binary.LittleEndian.PutUint32(m[:], t.magic)
+
+	path := blockFilePath(t.dir, t.idx)
+	end := int64(-1)
+	buf := make([]byte, 64*1024)
+	for off := t.pos + 1; end < 0; {
+		n, err := t.f.ReadAt(buf, off)
+		if i := bytes.Index(buf[:n], m[:]); i >= 0 {
+			end = off + int64(i)
+		} else if err == io.EOF {
+			break
+		} else if err != nil {
+			return false, err
+		} else {
+			off += int64(n - len(m) + 1) // it could span the chunks
+		}
+	}
+
+	if end < 0 {
+		if _, err := os.Stat(blockFilePath(t.dir, t.idx+1)); err != nil {
+			return false, nil
+		}
+		fi, err := os.Stat(path)
+		if err != nil {
+			return false, err
+		}
+		end = fi.Size()
+	}
+
+	gap := BlockFileGap{File: path, Start: t.pos, End: end}
+	if l := len(t.gaps) - 1; l >= 0 && t.gaps[l].File == gap.File && t.gaps[l].End == gap.Start {
+		t.gaps[l].End = gap.End // one corrupt stretch with a false magic in it
+	} else {
+		t.gaps = append(t.gaps, gap)
+	}
+	log.Printf("Skipping %v: %s", gap, reason)
+
+	t.pos = end
+	if fi, err := os.Stat(path); err == nil && end >= fi.Size() {
+		t.f.Close()
+		t.f, t.idx, t.pos = nil, t.idx+1, 0
+	}
+	return true, nil
+}
+
+// Gaps returns the stretches skipped since the last call.
+func (t *BlockFileTail) Gaps() []BlockFileGap {
+	gaps := t.gaps
+	t.gaps = nil
+	return gaps
+}
+
+func (t *BlockFileTail) Close() error {
+	if t.f != nil {
+		t.f.Close()
+	}
+	return t.watcher.close()
+}
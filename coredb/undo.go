@@ -0,0 +1,144 @@
+package coredb
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"path/filepath"
+
+	"github.com/blkchain/blkchain/core"
+)
+
+// Block undo data: for every block it connects, Core writes to the
+// rev*.dat file next to the blk*.dat one the outputs spent by the
+// block, so that it can put them back in the UTXO set should the block
+// be disconnected. The outputs are in the order of the inputs of the
+// txs, the coinbase excepted, which makes them the only place where the
+// value of an input is to be had without looking up the output.
+// https://github.com/bitcoin/bitcoin/blob/v22.0/src/undo.h
+
+// UndoReader is implemented by the block header indexes which can
+// fill in the core.Block PrevOutValues from the undo data.
+type UndoReader interface {
+	SetReadUndo(bool)
+}
+
+// SetReadUndo tells ReadBlock to read the undo data of the block.
+func (bi *levelDbBlockHeaderIndex) SetReadUndo(undo bool) {
+	bi.undo = undo
+}
+
+// readUndo returns the values spent by each tx of the block but the
+// coinbase.
+func (bi *levelDbBlockHeaderIndex) readUndo(ibh *leveldbBlockHeader) ([][]int64, error) {
+
+	path := filepath.Join(bi.blocksPath, fmt.Sprintf("%s%05d.dat", "rev", int(ibh.FileN)))
+	f, err := core.OpenBlockFile(path, bi.xorKey)
+	if err != nil {
+		return nil, fmt.Errorf("Opening file %v: %v", path, err)
+	}
+	defer f.Close()
+
+	pos := int64(ibh.UndoPos) - 8 // magic + size = 8
+	if _, err := f.Seek(pos, 0); err != nil {
+		return nil, fmt.Errorf("Seeking to pos %d in file %v: %v", pos, path, err)
+	}
+
+	r := bufio.NewReader(f)
+	var magic, size uint32
+	if err := core.BinRead(&magic, r); err != nil {
+		return nil, err
+	}
+	if magic != bi.magic {
+		return nil, fmt.Errorf("Bad magic %x at pos %d in file %v.", magic, pos, path)
+	}
+	if err := core.BinRead(&size, r); err != nil {
+		return nil, err
+	}
+
+	ntx, err := core.ReadCompactSize(r)
+	if err != nil {
+		return nil, err
+	}
+	if ntx > uint64(size) {
+		return nil, fmt.Errorf("Bad undo tx count %d for %d bytes.", ntx, size)
+	}
+	result := make([][]int64, ntx)
+	for i := range result {
+		nin, err := core.ReadCompactSize(r)
+		if err != nil {
+			return nil, err
+		}
+		if nin > uint64(size) {
+			return nil, fmt.Errorf("Bad undo input count %d for %d bytes.", nin, size)
+		}
+		values := make([]int64, nin)
+		for j := range values {
+			if values[j], err = readTxInUndo(r); err != nil {
+				return nil, err
+			}
+		}
+		result[i] = values
+	}
+	return result, nil
+}
+
+// readTxInUndo reads a spent output as the UTXO one (see
+// UTXO.BinRead), but with a dummy version after the height, and
+// returns its value, the script is skipped.
+// https://github.com/bitcoin/bitcoin/blob/v22.0/src/undo.h#L25
+func readTxInUndo(r io.Reader) (int64, error) {
+	var code VarInt32
+	if err := core.BinRead(&code, r); err != nil {
+		return 0, err
+	}
+	if code>>1 > 0 {
+		if _, err := core.ReadVarInt(r); err != nil { // the version, always 0
+			return 0, err
+		}
+	}
+
+	vv, err := core.ReadVarInt(r)
+	if err != nil {
+		return 0, err
+	}
+
+	var vs VarInt32
+	if err := core.BinRead(&vs, r); err != nil {
+		return 0, err
+	}
+	skip := int64(getSpecialSize(vs))
+	if vs >= 6 { // specialScripts
+		skip = int64(vs) - 6
+	}
+	if _, err := io.CopyN(io.Discard, r, skip); err != nil {
+		return 0, err
+	}
+	return int64(decompressAmount(vv)), nil
+}
+
+// setPrevOutValues reads the undo data of the block into b, leaving
+// PrevOutValues nil if there is none or it does not match.
+func (bi *levelDbBlockHeaderIndex) setPrevOutValues(ibh *leveldbBlockHeader, b *core.Block) {
+	if ibh.Status&BLOCK_HAVE_UNDO == 0 || len(b.Txs) < 2 {
+		return
+	}
+	values, err := bi.readUndo(ibh)
+	if err != nil {
+		log.Printf("Error reading the undo data of block %v at height %d: %v", b.Hash(), ibh.Height, err)
+		return
+	}
+	if len(values) != len(b.Txs)-1 {
+		log.Printf("Undo data of block %v has %d txs rather than %d, ignored.", b.Hash(), len(values), len(b.Txs)-1)
+		return
+	}
+	for i, v := range values {
+		if len(v) != len(b.Txs[i+1].TxIns) {
+			log.Printf("Undo data of block %v has %d inputs rather than %d for tx %d, ignored.",
+				b.Hash(), len(v), len(b.Txs[i+1].TxIns), i+1)
+			return
+		}
+	}
+	b.PrevOutValues = values
+}
@@ -0,0 +1,47 @@
+package coredb
+
+import (
+	"os"
+	"syscall"
+)
+
+// dirWatcher signals changes in directories with inotify.
+type dirWatcher struct {
+	f      *os.File
+	events chan bool
+}
+
+func newDirWatcher(dirs ...string) (*dirWatcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+	for _, dir := range dirs {
+		if _, err := syscall.InotifyAddWatch(fd, dir, syscall.IN_MODIFY|syscall.IN_CREATE|syscall.IN_CLOSE_WRITE|syscall.IN_MOVED_TO); err != nil {
+			syscall.Close(fd)
+			return nil, err
+		}
+	}
+	// Non-blocking, so that reads go through the runtime poller and
+	// Close interrupts them.
+	w := &dirWatcher{f: os.NewFile(uintptr(fd), "inotify"), events: make(chan bool, 1)}
+	go w.run()
+	return w, nil
+}
+
+func (w *dirWatcher) run() {
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		if _, err := w.f.Read(buf); err != nil {
+			return
+		}
+		select {
+		case w.events <- true:
+		default: // one pending is enough
+		}
+	}
+}
+
+func (w *dirWatcher) close() error {
+	return w.f.Close()
+}
@@ -0,0 +1,16 @@
+//go:build !linux
+
+package coredb
+
+// dirWatcher without inotify, BlockFileTail falls back to polling.
+type dirWatcher struct {
+	events chan bool
+}
+
+func newDirWatcher(dirs ...string) (*dirWatcher, error) {
+	return &dirWatcher{events: make(chan bool)}, nil
+}
+
+func (w *dirWatcher) close() error {
+	return nil
+}
@@ -0,0 +1,9 @@
+package db
+
+// The writer and the schema moved to pg. This package keeps the old
+// names as aliases (and wrappers of the functions) for the code
+// written against it; new code should import pg. The variables (e.g.
+// AddrHistoryStart) are not here but for the errors: a copy would not
+// see or make changes, so code using them has to move to pg.
+
+//go:generate go run ../cmd/aliasgen -dir ../pg -import github.com/blkchain/blkchain/pg -package db -o pg_gen.go
@@ -0,0 +1,385 @@
+// Code generated by cmd/aliasgen. DO NOT EDIT.
+
+package db
+
+import (
+	"database/sql"
+	"io"
+
+	"github.com/blkchain/blkchain/core"
+	"github.com/blkchain/blkchain/pg"
+)
+
+const DefaultAccountingGap = pg.DefaultAccountingGap
+
+type AccountingEntry = pg.AccountingEntry
+
+func AccountingExport(connstr string, watch []string, gap int, fromHeight int, toHeight int, fn func(*AccountingEntry) error) error {
+	return pg.AccountingExport(connstr, watch, gap, fromHeight, toHeight, fn)
+}
+
+type AddressInfo = pg.AddressInfo
+
+func ParseAddress(address string) (*AddressInfo, error) {
+	return pg.ParseAddress(address)
+}
+
+const AsOfTip = pg.AsOfTip
+
+func VerifyHeaders(connstr string, sinceHeight int) (*CheckResult, error) {
+	return pg.VerifyHeaders(connstr, sinceHeight)
+}
+
+const AddressEventsConfirmations = pg.AddressEventsConfirmations
+
+func ExportBlocks(connstr string, from int, to int, fn func(b *core.Block, height int) error) (int, error) {
+	return pg.ExportBlocks(connstr, from, to, fn)
+}
+
+const IndexStrategyBtree = pg.IndexStrategyBtree
+
+const IndexStrategyBrin = pg.IndexStrategyBrin
+
+const MinRelayFeeRate = pg.MinRelayFeeRate
+
+const DefaultMaxFeeRate = pg.DefaultMaxFeeRate
+
+type TxCheck = pg.TxCheck
+
+const MaxBulkAddresses = pg.MaxBulkAddresses
+
+type AddrBalance = pg.AddrBalance
+
+type AddrUtxo = pg.AddrUtxo
+
+type CacheRange = pg.CacheRange
+
+type CacheReport = pg.CacheReport
+
+func ReadCacheReport(path string) (*CacheReport, error) {
+	return pg.ReadCacheReport(path)
+}
+
+type ChainBlock = pg.ChainBlock
+
+type ChainPage = pg.ChainPage
+
+func ChainSchema(chain string) (string, error) {
+	return pg.ChainSchema(chain)
+}
+
+func ChainConnStr(connstr string, chain string) (string, error) {
+	return pg.ChainConnStr(connstr, chain)
+}
+
+type ChainWriter = pg.ChainWriter
+
+var NewChainWriter = pg.NewChainWriter
+
+const DefaultChecksumRange = pg.DefaultChecksumRange
+
+type RangeChecksum = pg.RangeChecksum
+
+func ChecksumBlocks(connstr string, from int, to int, size int, store bool, fn func(*RangeChecksum) error) error {
+	return pg.ChecksumBlocks(connstr, from, to, size, store, fn)
+}
+
+func ReadManifest(r io.Reader) ([]*RangeChecksum, error) {
+	return pg.ReadManifest(r)
+}
+
+func CompareManifests(a []*RangeChecksum, b []*RangeChecksum) []string {
+	return pg.CompareManifests(a, b)
+}
+
+type ExtraColumn = pg.ExtraColumn
+
+type ColumnPlugin = pg.ColumnPlugin
+
+func RegisterColumns(name string, newPlugin func() ColumnPlugin) {
+	pg.RegisterColumns(name, newPlugin)
+}
+
+func ColumnPluginNames() []string {
+	return pg.ColumnPluginNames()
+}
+
+type PipelineStatus = pg.PipelineStatus
+
+type StageStatus = pg.StageStatus
+
+type SkipStatus = pg.SkipStatus
+
+type CacheStatus = pg.CacheStatus
+
+func ImportPipelineStatus() *PipelineStatus {
+	return pg.ImportPipelineStatus()
+}
+
+func ExportDuckDB(connstr string, dir string, fromHeight int, toHeight int) (map[string]int64, error) {
+	return pg.ExportDuckDB(connstr, dir, fromHeight, toHeight)
+}
+
+type ElasticWriter = pg.ElasticWriter
+
+func NewElasticWriter(url string, prefix string, policy string) (*ElasticWriter, error) {
+	return pg.NewElasticWriter(url, prefix, policy)
+}
+
+type Config = pg.Config
+
+type Explorer = pg.Explorer
+
+func NewExplorer(cfg Config) (*Explorer, error) {
+	return pg.NewExplorer(cfg)
+}
+
+const DefaultFixPrevoutWorkers = pg.DefaultFixPrevoutWorkers
+
+const GraphTx = pg.GraphTx
+
+const GraphAddress = pg.GraphAddress
+
+type GraphEdge = pg.GraphEdge
+
+type GraphWriter = pg.GraphWriter
+
+func ExportGraph(connstr string, kind string, fromHeight int, toHeight int, gw GraphWriter) (int, int, error) {
+	return pg.ExportGraph(connstr, kind, fromHeight, toHeight, gw)
+}
+
+func NewNeo4jWriter(dir string, relType string) (GraphWriter, error) {
+	return pg.NewNeo4jWriter(dir, relType)
+}
+
+func NewGraphMLWriter(w io.Writer) (GraphWriter, error) {
+	return pg.NewGraphMLWriter(w)
+}
+
+type QLBlock = pg.QLBlock
+
+type QLTx = pg.QLTx
+
+type QLOutPoint = pg.QLOutPoint
+
+type QLTxIn = pg.QLTxIn
+
+type QLTxOut = pg.QLTxOut
+
+type AddrHistoryKey = pg.AddrHistoryKey
+
+func ParseAddrHistoryKey(s string) (AddrHistoryKey, error) {
+	return pg.ParseAddrHistoryKey(s)
+}
+
+func CreateAddressHistoryIndexes(connstr string) error {
+	return pg.CreateAddressHistoryIndexes(connstr)
+}
+
+type BlockHook = pg.BlockHook
+
+func AddInputValues(connstr string) error {
+	return pg.AddInputValues(connstr)
+}
+
+func AddressKey(address string) ([]byte, error) {
+	return pg.AddressKey(address)
+}
+
+func ImportAddressLabels(connstr string, r io.Reader, source string, replace bool) (int, error) {
+	return pg.ImportAddressLabels(connstr, r, source, replace)
+}
+
+type NDJSONWriter = pg.NDJSONWriter
+
+func NewNDJSONWriter(path string, perTx bool) (*NDJSONWriter, error) {
+	return pg.NewNDJSONWriter(path, perTx)
+}
+
+type NewBlock = pg.NewBlock
+
+type BlockAddrEvent = pg.BlockAddrEvent
+
+const ObjectPartSize = pg.ObjectPartSize
+
+func IsObjectURL(path string) bool {
+	return pg.IsObjectURL(path)
+}
+
+func JoinOutput(dir string, name string) string {
+	return pg.JoinOutput(dir, name)
+}
+
+func CreateOutput(path string) (io.WriteCloser, error) {
+	return pg.CreateOutput(path)
+}
+
+func ReadObject(objURL string) ([]byte, error) {
+	return pg.ReadObject(objURL)
+}
+
+func CreateObject(objURL string) (io.WriteCloser, error) {
+	return pg.CreateObject(objURL)
+}
+
+func UploadFile(path string, objURL string) error {
+	return pg.UploadFile(path, objURL)
+}
+
+func UploadDir(dir string, objURL string) (int, error) {
+	return pg.UploadDir(dir, objURL)
+}
+
+const PanicRecover = pg.PanicRecover
+
+const PanicCrash = pg.PanicCrash
+
+var ErrWriterStopped = pg.ErrWriterStopped
+
+const ParquetConfirmations = pg.ParquetConfirmations
+
+type EmbeddedParser = pg.EmbeddedParser
+
+func RegisterParser(name string, newParser func() EmbeddedParser) {
+	pg.RegisterParser(name, newParser)
+}
+
+func ParserNames() []string {
+	return pg.ParserNames()
+}
+
+type PGWriter = pg.PGWriter
+
+type WriterConfig = pg.WriterConfig
+
+var NewPGWriter = pg.NewPGWriter
+
+var NewPGWriterConfig = pg.NewPGWriterConfig
+
+const MinPruneDepth = pg.MinPruneDepth
+
+func PruneScripts(connstr string, depth int) error {
+	return pg.PruneScripts(connstr, depth)
+}
+
+type PSBTReport = pg.PSBTReport
+
+type PSBTInputReport = pg.PSBTInputReport
+
+type PSBTOutputReport = pg.PSBTOutputReport
+
+type BlockInfo = pg.BlockInfo
+
+type TxInfo = pg.TxInfo
+
+type TxOutInfo = pg.TxOutInfo
+
+func ReimportRange(cfg WriterConfig, bhs core.BlockHeaderIndex, from int, to int) (int, error) {
+	return pg.ReimportRange(cfg, bhs, from, to)
+}
+
+const DefaultHealthCheckInterval = pg.DefaultHealthCheckInterval
+
+const MinRetainBlocks = pg.MinRetainBlocks
+
+const BlocksPerYear = pg.BlocksPerYear
+
+func Retain(connstr string, keep int, archive string) error {
+	return pg.Retain(connstr, keep, archive)
+}
+
+type TableForecast = pg.TableForecast
+
+func StorageForecast(connstr string) ([]*TableForecast, error) {
+	return pg.StorageForecast(connstr)
+}
+
+type RichListEntry = pg.RichListEntry
+
+const RichListByAddress = pg.RichListByAddress
+
+const RichListByEntity = pg.RichListByEntity
+
+func RichList(connstr string, height int, n int, by string) ([]*RichListEntry, error) {
+	return pg.RichList(connstr, height, n, by)
+}
+
+type ScriptCheckResult = pg.ScriptCheckResult
+
+func VerifyScripts(connstr string, sinceHeight int, sample int, assumeValid string) (*ScriptCheckResult, error) {
+	return pg.VerifyScripts(connstr, sinceHeight, sample, assumeValid)
+}
+
+func AddScriptPayloads(connstr string) error {
+	return pg.AddScriptPayloads(connstr)
+}
+
+type ScriptTypeStat = pg.ScriptTypeStat
+
+func UpdateScriptTypeStats(connstr string, period string, sinceHeight int) error {
+	return pg.UpdateScriptTypeStats(connstr, period, sinceHeight)
+}
+
+func ScriptTypeStats(connstr string, period string) ([]*ScriptTypeStat, error) {
+	return pg.ScriptTypeStats(connstr, period)
+}
+
+func ShardOf(txId int64, shards int) int {
+	return pg.ShardOf(txId, shards)
+}
+
+func ShardHeights(connstr string) ([]int, error) {
+	return pg.ShardHeights(connstr)
+}
+
+func QueryShards(connstrs []string, query string, args []interface{}, fn func(shard int, rows *sql.Rows) error) error {
+	return pg.QueryShards(connstrs, query, args, fn)
+}
+
+type Spool = pg.Spool
+
+func OpenSpool(dir string) (*Spool, error) {
+	return pg.OpenSpool(dir)
+}
+
+type IndexStats = pg.IndexStats
+
+type TableStats = pg.TableStats
+
+type Stats = pg.Stats
+
+const DefaultDbToChainRatio = pg.DefaultDbToChainRatio
+
+func DatabaseStats(connstr string) (*Stats, error) {
+	return pg.DatabaseStats(connstr)
+}
+
+func ParseThrottle(s string) (int, int64, error) {
+	return pg.ParseThrottle(s)
+}
+
+const HASH_PREFIX_SIZE = pg.HASH_PREFIX_SIZE
+
+const RECENT_RING_SIZE = pg.RECENT_RING_SIZE
+
+const MinTxidPrefix = pg.MinTxidPrefix
+
+func CreateTxidPrefixIndex(connstr string) error {
+	return pg.CreateTxidPrefixIndex(connstr)
+}
+
+func RegisterTxTable(name string, state bool) {
+	pg.RegisterTxTable(name, state)
+}
+
+type BlockRec = pg.BlockRec
+
+func UpgradeDatabase(connstr string, dryRun bool) error {
+	return pg.UpgradeDatabase(connstr, dryRun)
+}
+
+type CheckResult = pg.CheckResult
+
+func VerifyDatabase(connstr string, sinceHeight int) ([]*CheckResult, error) {
+	return pg.VerifyDatabase(connstr, sinceHeight)
+}
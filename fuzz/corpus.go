@@ -0,0 +1,77 @@
+package fuzz
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/blkchain/blkchain/core"
+	"github.com/blkchain/blkchain/regtest"
+)
+
+// Seeds returns the seeds for the targets by target (block, tx, txin,
+// txout and varint), taken from the regtest fixture.
+func Seeds() (map[string][][]byte, error) {
+	c, err := regtest.Fixture()
+	if err != nil {
+		return nil, err
+	}
+
+	seeds := make(map[string][][]byte)
+	add := func(target string, v interface{}) {
+		var buf bytes.Buffer
+		core.BinWrite(v, &buf)
+		seeds[target] = append(seeds[target], buf.Bytes())
+	}
+	// The blocks with more than a coinbase, and the last one.
+	for i, b := range c.Blocks {
+		if len(b.Txs) > 1 || i == len(c.Blocks)-1 {
+			add("block", b)
+		}
+		for _, tx := range b.Txs {
+			add("tx", tx)
+			for _, in := range tx.TxIns {
+				add("txin", in)
+			}
+			for _, out := range tx.TxOuts {
+				add("txout", out)
+			}
+		}
+	}
+	for _, n := range []uint64{0, 0x7f, 0x80, 0x407f, 0x4080, 1<<32 - 1, 1<<64 - 1} {
+		var buf bytes.Buffer
+		core.WriteVarInt(n, &buf)
+		seeds["varint"] = append(seeds["varint"], buf.Bytes())
+	}
+	return seeds, nil
+}
+
+// WriteCorpus writes the seeds to dir/<target>/corpus, i.e.
+// dir/<target> is the go-fuzz workdir.
+func WriteCorpus(dir string) error {
+	seeds, err := Seeds()
+	if err != nil {
+		return err
+	}
+
+	for target, list := range seeds {
+		corpus := filepath.Join(dir, target, "corpus")
+		if err := os.MkdirAll(corpus, 0755); err != nil {
+			return err
+		}
+		written := make(map[string]bool)
+		for _, seed := range list {
+			hash := core.ShaSha256(seed)
+			name := fmt.Sprintf("%x", hash[:])
+			if written[name] {
+				continue
+			}
+			written[name] = true
+			if err := os.WriteFile(filepath.Join(corpus, name), seed, 0644); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
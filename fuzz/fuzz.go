@@ -0,0 +1,133 @@
+package fuzz
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+
+	"github.com/blkchain/blkchain/core"
+)
+
+// Fuzz targets for the deserialization of the data the importer reads
+// from the blk*.dat files (and nodes), in the go-fuzz form: each
+// returns 1 if the input parsed, 0 if not, and panics if an invariant
+// does not hold. What parsed is written and read again, which must
+// give the same value, the sizes computed must be those written, and
+// the hashes must not panic. See WriteCorpus for the seeds.
+//
+//   go-fuzz-build -func FuzzTx github.com/blkchain/blkchain/fuzz
+//   go-fuzz -bin fuzz-fuzz.zip -workdir workdir/tx
+
+// FuzzBlock reads a block as coredb does, the magic is regtest as in
+// the fixture the seeds are taken from.
+func FuzzBlock(data []byte) int {
+	b := &core.Block{Magic: core.RegTestMagic}
+	if core.BinRead(b, bytes.NewReader(data)) != nil {
+		return 0
+	}
+	b.Hash()
+	b.Weight()
+	b.VirtualSize()
+	for _, tx := range b.Txs {
+		tx.Hash()
+		tx.WitnessHash()
+	}
+	buf := write(b)
+	check("block size", b.Size()+8, buf.Len())
+	b2 := &core.Block{Magic: core.RegTestMagic}
+	read(b2, buf)
+	same("block", b, b2)
+	return 1
+}
+
+func FuzzTx(data []byte) int {
+	var tx core.Tx
+	if core.BinRead(&tx, bytes.NewReader(data)) != nil {
+		return 0
+	}
+	tx.Hash()
+	tx.WitnessHash()
+	tx.VirtualSize()
+	buf := write(&tx)
+	check("tx size", tx.Size(), buf.Len())
+	if !tx.SegWit {
+		check("tx base size", tx.BaseSize(), buf.Len())
+	}
+	var tx2 core.Tx
+	read(&tx2, buf)
+	same("tx", &tx, &tx2)
+	return 1
+}
+
+func FuzzTxIn(data []byte) int {
+	var in core.TxIn
+	if core.BinRead(&in, bytes.NewReader(data)) != nil {
+		return 0
+	}
+	buf := write(&in)
+	check("txin size", in.BaseSize(), buf.Len())
+	var in2 core.TxIn
+	read(&in2, buf)
+	same("txin", &in, &in2)
+	return 1
+}
+
+func FuzzTxOut(data []byte) int {
+	var out core.TxOut
+	if core.BinRead(&out, bytes.NewReader(data)) != nil {
+		return 0
+	}
+	buf := write(&out)
+	check("txout size", out.Size(), buf.Len())
+	var out2 core.TxOut
+	read(&out2, buf)
+	same("txout", &out, &out2)
+	return 1
+}
+
+// FuzzVarInt is for the varints of the Core LevelDb values. Every
+// number has exactly one encoding, so it must be what was read.
+func FuzzVarInt(data []byte) int {
+	r := bytes.NewReader(data)
+	n, err := core.ReadVarInt(r)
+	if err != nil {
+		return 0
+	}
+	var buf bytes.Buffer
+	if err := core.WriteVarInt(n, &buf); err != nil {
+		panic(err)
+	}
+	if !bytes.Equal(buf.Bytes(), data[:len(data)-r.Len()]) {
+		panic(fmt.Sprintf("varint %d: written as %x, read from %x", n, buf.Bytes(), data[:len(data)-r.Len()]))
+	}
+	return 1
+}
+
+func write(v interface{}) *bytes.Buffer {
+	var buf bytes.Buffer
+	if err := core.BinWrite(v, &buf); err != nil {
+		panic(err)
+	}
+	return &buf
+}
+
+func read(v interface{}, buf *bytes.Buffer) {
+	if err := core.BinRead(v, buf); err != nil {
+		panic(fmt.Sprintf("reading %T again: %v", v, err))
+	}
+	if buf.Len() > 0 {
+		panic(fmt.Sprintf("reading %T again: %d bytes left", v, buf.Len()))
+	}
+}
+
+func check(what string, exp, got int) {
+	if exp != got {
+		panic(fmt.Sprintf("%s: %d != %d", what, exp, got))
+	}
+}
+
+func same(what string, a, b interface{}) {
+	if !reflect.DeepEqual(a, b) {
+		panic(fmt.Sprintf("%s: not the same after writing and reading it again", what))
+	}
+}
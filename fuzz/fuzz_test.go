@@ -0,0 +1,34 @@
+package fuzz_test
+
+import (
+	"testing"
+
+	"github.com/blkchain/blkchain/fuzz"
+)
+
+// The targets in the native form, seeded from the regtest fixture:
+//
+//   go test ./fuzz                            # the seeds only
+//   go test ./fuzz -run - -fuzz FuzzTx$       # fuzzing one target
+
+func run(f *testing.F, target string, fn func([]byte) int) {
+	seeds, err := fuzz.Seeds()
+	if err != nil {
+		f.Fatal(err)
+	}
+	if len(seeds[target]) == 0 {
+		f.Fatalf("No seeds for %s", target)
+	}
+	for _, seed := range seeds[target] {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		fn(data)
+	})
+}
+
+func FuzzBlock(f *testing.F)  { run(f, "block", fuzz.FuzzBlock) }
+func FuzzTx(f *testing.F)     { run(f, "tx", fuzz.FuzzTx) }
+func FuzzTxIn(f *testing.F)   { run(f, "txin", fuzz.FuzzTxIn) }
+func FuzzTxOut(f *testing.F)  { run(f, "txout", fuzz.FuzzTxOut) }
+func FuzzVarInt(f *testing.F) { run(f, "varint", fuzz.FuzzVarInt) }
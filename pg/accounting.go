@@ -0,0 +1,180 @@
+package pg
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/blkchain/blkchain/core"
+	"github.com/lib/pq"
+)
+
+// Accounting export of a watch-only wallet: every change to the
+// balance of a set of addresses over a range of heights, one entry per
+// tx and address, with the fee of the txs the wallet spends from and
+// the running balance of the wallet, for tax and audit. It comes from
+// address_events (see balances.go), which needs to have been populated
+// with -address-events.
+//
+// The wallet is given as addresses and/or extended public keys (see
+// core.ExtendedKey), the receiving (0/i) and change (1/i)
+// addresses of which are derived until gap consecutive ones have no
+// events. Since extract_address() gives P2PKH and P2WPKH addresses of
+// the same key the same addr, the events of both are those of whichever
+// was asked for.
+
+const DefaultAccountingGap = 20
+
+type AccountingEntry struct {
+	Time    time.Time
+	Height  int
+	TxId    core.Uint256
+	Address string
+	Amount  int64 // net, negative if spent
+	Fee     int64 // on the first entry of a tx the wallet spends from, 0 otherwise
+	Balance int64 // of the wallet, after this entry
+}
+
+// watchKeys returns the addresses of the watched addresses and
+// extended keys by their extract_address() addr.
+func watchKeys(db *sql.DB, watch []string, gap int) (map[string]string, error) {
+	result := make(map[string]string)
+	add := func(address string) ([]byte, error) {
+		a, err := ParseAddress(address)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", address, err)
+		}
+		if !a.Indexed {
+			return nil, fmt.Errorf("%s: %s addresses are not in address_events.", address, a.Type)
+		}
+		if _, ok := result[string(a.Key())]; !ok {
+			result[string(a.Key())] = a.Address
+		}
+		return a.Key(), nil
+	}
+
+	for _, w := range watch {
+		w = strings.TrimSpace(w)
+		if len(w) != 111 { // the length of a base58 extended key
+			if _, err := add(w); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		k, err := core.ParseExtendedKey(w)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", w, err)
+		}
+		if k.Params != core.MainNetAddressParams {
+			return nil, fmt.Errorf("%s: Testnet key, only mainnet keys are supported.", w)
+		}
+		for chain := uint32(0); chain <= 1; chain++ {
+			for i, unused := uint32(0), 0; unused < gap; i++ {
+				child, err := k.Derive(chain, i)
+				if err != nil {
+					continue // invalid child, skipped as per BIP32
+				}
+				key, err := add(child.Address())
+				if err != nil {
+					return nil, err
+				}
+				var used bool
+				if err := db.QueryRow("SELECT EXISTS (SELECT 1 FROM address_events WHERE addr = $1)", key).Scan(&used); err != nil {
+					return nil, err
+				}
+				if used {
+					unused = 0
+				} else {
+					unused++
+				}
+			}
+		}
+	}
+	return result, nil
+}
+
+// AccountingExport calls fn with the entries of the wallet of the
+// watched addresses and extended keys between the heights, in chain
+// order. A negative toHeight is the last height in address_events.
+func AccountingExport(connstr string, watch []string, gap, fromHeight, toHeight int, fn func(*AccountingEntry) error) error {
+	db, err := sql.Open("postgres", connstr)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var last int
+	if err := db.QueryRow("SELECT COALESCE(MAX(height), -1) FROM address_events_height").Scan(&last); err != nil {
+		return fmt.Errorf("Cannot read address_events (was it populated with -address-events?): %v", err)
+	}
+	if toHeight < 0 {
+		toHeight = last
+	}
+	if toHeight > last {
+		return fmt.Errorf("Height %d is beyond the last height in address_events (%d).", toHeight, last)
+	}
+
+	addresses, err := watchKeys(db, watch, gap)
+	if err != nil {
+		return err
+	}
+	keys := make([][]byte, 0, len(addresses))
+	for k := range addresses {
+		keys = append(keys, []byte(k))
+	}
+
+	var balance int64
+	if err := db.QueryRow(`
+SELECT COALESCE(SUM(address_balance(a, $2)), 0)::BIGINT
+  FROM unnest($1::BYTEA[]) a`, pq.ByteaArray(keys), fromHeight-1).Scan(&balance); err != nil {
+		return err
+	}
+
+	rows, err := db.Query(`
+SELECT e.addr, e.height, b.time, t.txid, e.tx_id, SUM(e.value)::BIGINT, bool_or(e.spending)
+  FROM address_events e
+  JOIN txs t ON t.id = e.tx_id
+  JOIN blocks b ON b.height = e.height AND NOT b.orphan
+ WHERE e.addr = ANY($1)
+   AND e.height BETWEEN $2 AND $3
+ GROUP BY e.addr, e.height, b.time, t.txid, e.tx_id
+ ORDER BY e.height, e.tx_id, e.addr`, pq.ByteaArray(keys), fromHeight, toHeight)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	lastFeeTxId := int64(-1)
+	for rows.Next() {
+		var (
+			addr     []byte
+			t        int64
+			txId     int64
+			spending bool
+			e        AccountingEntry
+		)
+		if err := rows.Scan(&addr, &e.Height, &t, &e.TxId, &txId, &e.Amount, &spending); err != nil {
+			return err
+		}
+		e.Time = time.Unix(t, 0).UTC()
+		e.Address = addresses[string(addr)]
+		if spending && txId != lastFeeTxId {
+			if err := db.QueryRow(`
+SELECT (SELECT COALESCE(SUM(po.value), 0)
+          FROM txins i
+          JOIN txouts po ON po.tx_id = i.prevout_tx_id AND po.n = i.prevout_n
+         WHERE i.tx_id = $1)::BIGINT -
+       (SELECT COALESCE(SUM(value), 0) FROM txouts WHERE tx_id = $1)::BIGINT`, txId).Scan(&e.Fee); err != nil {
+				return err
+			}
+			lastFeeTxId = txId
+		}
+		balance += e.Amount
+		e.Balance = balance
+		if err := fn(&e); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
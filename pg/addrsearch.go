@@ -0,0 +1,128 @@
+package pg
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/blkchain/blkchain/core"
+)
+
+// Searching by an address as typed: base58 (P2PKH, P2SH), bech32
+// (segwit v0) or bech32m (taproot). ParseAddress tells what is wrong
+// with an address which cannot be used in terms a user can act on, a
+// mistyped character (bad checksum), a testnet address, a taproot
+// address encoded with bech32 rather than bech32m and so on, rather
+// than the decoder's "decoded address is of unknown format".
+//
+// Only mainnet addresses are accepted, and taproot addresses are
+// recognized but have no history, extract_address() does not index
+// them.
+
+type AddressInfo struct {
+	Address      string `json:"address"`
+	Type         string `json:"type"` // p2pkh, p2sh, p2wpkh, p2wsh or p2tr
+	ScriptPubKey string `json:"scriptpubkey"`
+	Indexed      bool   `json:"indexed"` // by extract_address()
+
+	key []byte // what extract_address() returns, nil if not indexed
+}
+
+// Key is what extract_address() returns for the address, nil if it is
+// not indexed.
+func (a *AddressInfo) Key() []byte {
+	return a.key
+}
+
+// ParseAddress decodes a mainnet address.
+func ParseAddress(address string) (*AddressInfo, error) {
+	address = strings.TrimSpace(address)
+	if address == "" {
+		return nil, fmt.Errorf("Empty address.")
+	}
+	if core.IsSegwitAddress(address) {
+		return parseSegwitAddress(address)
+	}
+	return parseBase58Address(address)
+}
+
+func parseBase58Address(address string) (*AddressInfo, error) {
+	data, err := core.Base58CheckDecode(address)
+	var cerr *core.Base58CharError
+	switch {
+	case errors.As(err, &cerr):
+		return nil, fmt.Errorf("Invalid character %q at position %d, base58 has no 0, O, I or l.", cerr.Char, cerr.Pos+1)
+	case errors.Is(err, core.ErrBase58Checksum):
+		return nil, fmt.Errorf("Bad checksum, the address is mistyped.")
+	case err != nil:
+		return nil, fmt.Errorf("Not an address, too short.")
+	}
+	version, payload := data[0], data[1:]
+	var typ string
+	switch version {
+	case core.MainNetAddressParams.PubKeyHash:
+		typ = "p2pkh"
+	case core.MainNetAddressParams.ScriptHash:
+		typ = "p2sh"
+	case core.TestNetAddressParams.PubKeyHash, core.TestNetAddressParams.ScriptHash:
+		return nil, fmt.Errorf("Testnet address, only mainnet addresses are supported.")
+	case 0x80:
+		return nil, fmt.Errorf("This is a private key (WIF), not an address!")
+	default:
+		return nil, fmt.Errorf("Not an address (base58 version %d).", version)
+	}
+	if len(payload) != 20 {
+		return nil, fmt.Errorf("Invalid %s address, %d bytes rather than 20.", typ, len(payload))
+	}
+	return newAddressInfo(address, typ, payload)
+}
+
+func parseSegwitAddress(address string) (*AddressInfo, error) {
+	if address != strings.ToLower(address) && address != strings.ToUpper(address) {
+		return nil, fmt.Errorf("Mixed case, a bech32 address is either all lower or all upper case.")
+	}
+	hrp, version, program, err := core.DecodeSegwitAddress(address)
+	var cerr *core.Bech32CharError
+	switch {
+	case errors.As(err, &cerr):
+		return nil, fmt.Errorf("Invalid character %q, bech32 has no 1 (other than after the prefix), b, i or o.", cerr.Char)
+	case errors.Is(err, core.ErrBech32Checksum):
+		return nil, fmt.Errorf("Bad checksum, the address is mistyped.")
+	case err != nil:
+		return nil, err
+	}
+	if hrp != core.MainNetAddressParams.HRP {
+		return nil, fmt.Errorf("Testnet address, only mainnet addresses are supported.")
+	}
+	var typ string
+	switch {
+	case version == 0 && len(program) == 20:
+		typ = "p2wpkh"
+	case version == 0:
+		typ = "p2wsh"
+	case version == 1 && len(program) == 32:
+		typ = "p2tr"
+	default:
+		return nil, fmt.Errorf("Segwit v%d address (%d byte program), not used yet.", version, len(program))
+	}
+	return newAddressInfo(address, typ, program)
+}
+
+// Taproot outputs are not indexed, the others are by their hash or
+// witness program.
+func newAddressInfo(address, typ string, key []byte) (*AddressInfo, error) {
+	script, err := core.AddressScript(address, core.MainNetAddressParams)
+	if err != nil {
+		return nil, err
+	}
+	info := &AddressInfo{
+		Address:      address,
+		Type:         typ,
+		ScriptPubKey: hex.EncodeToString(script),
+	}
+	if typ != "p2tr" {
+		info.Indexed, info.key = true, key
+	}
+	return info, nil
+}
@@ -0,0 +1,55 @@
+package pg
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Statistics after the bulk phases of the first import. Autovacuum and
+// autoanalyze are off for the import (see setTableStorageParams), and a
+// freshly loaded table of billions of rows has no statistics at all,
+// so the plans of the queries which follow (the prevout_tx_id fix, the
+// orphans, the address events...) can be terrible. The tables are
+// ANALYZEd after the COPY phase, again after the indexes are created
+// (the expression indexes have statistics of their own), and txins
+// after the prevout_tx_id fix, which rewrites a good part of it.
+//
+// With WriterConfig.Vacuum the ANALYZE after the COPY phase and after
+// the fix is a VACUUM ANALYZE, which also sets the hint bits and the
+// visibility map (so that index-only scans work from the start) and,
+// after the fix, makes the space of the old rows reusable. It is
+// slower, a VACUUM reads the whole table.
+//
+// NB: With shards, the txins and txouts on the shards are not covered.
+
+// The tables of the first import.
+func bulkTables(cfg *WriterConfig) []string {
+	tables := []string{"blocks", "txs", "block_txs", "txins", "txouts"}
+	if cfg.DedupScripts {
+		tables = append(tables, "scripts")
+	}
+	if cfg.Inscriptions {
+		tables = append(tables, "inscriptions")
+	}
+	return tables
+}
+
+func analyzeTables(db *sql.DB, phase string, tables []string, vacuum bool) error {
+	cmd := "ANALYZE"
+	if vacuum {
+		cmd = "VACUUM ANALYZE"
+	}
+	log.Printf("Running %s %s...", cmd, phase)
+	start := time.Now()
+	for _, table := range tables {
+		tstart := time.Now()
+		if _, err := db.Exec(fmt.Sprintf("%s %s", cmd, table)); err != nil {
+			return fmt.Errorf("%s %s: %v", cmd, table, err)
+		}
+		log.Printf("  - %s in %s.", table, time.Now().Sub(tstart).Round(time.Millisecond))
+	}
+	log.Printf("...done in %s.", time.Now().Sub(start).Round(time.Millisecond))
+	return nil
+}
@@ -0,0 +1,120 @@
+package pg
+
+import (
+	"database/sql"
+	"math"
+)
+
+// Queries as of a height. The tables hold the chain as of now: a txout
+// is spent if anything spends it, and the orphans are there alongside
+// the main chain. For reproducible historical analysis, these
+// functions give the data as it was at the end of the block at a
+// height, i.e. the main chain up to it, with the outputs spent only by
+// the txins of the main chain up to it:
+//
+//   blocks_asof(h)               the main chain blocks
+//   txs_asof(h)                  the main chain txs, with their height
+//   txouts_asof(h)               the outputs, with spent as of h
+//   utxos_asof(h)                the unspent outputs as of h
+//   address_utxos_asof(addr, h)  the same for an address, by index
+//   tx_height(tx_id)             main chain height of a tx
+//
+// e.g. the UTXO set value as of height 500000:
+//
+//   SELECT SUM(value) FROM utxos_asof(500000);
+//
+// The convention in the Explorer and the HTTP API is the same, a
+// height parameter, the tip (AsOfTip) if not given. address_balance()
+// (see balances.go) is the fast way to a balance as of a height.
+
+// The height meaning the tip.
+const AsOfTip = math.MaxInt32
+
+func createAsOfFunctions(db *sql.DB) error {
+	_, err := db.Exec(`
+  CREATE OR REPLACE FUNCTION blocks_asof(_h INT) RETURNS SETOF blocks AS $$
+    SELECT * FROM blocks WHERE height <= _h AND NOT orphan
+  $$ LANGUAGE sql STABLE;
+
+  CREATE OR REPLACE FUNCTION tx_height(_tx_id BIGINT) RETURNS INT AS $$
+    SELECT b.height
+      FROM block_txs bt
+      JOIN blocks b ON b.id = bt.block_id
+     WHERE bt.tx_id = _tx_id
+       AND NOT b.orphan
+     LIMIT 1
+  $$ LANGUAGE sql STABLE;
+
+  CREATE OR REPLACE FUNCTION txs_asof(_h INT) RETURNS TABLE (id BIGINT, txid BYTEA, height INT, n INT) AS $$
+    SELECT t.id, t.txid, b.height, bt.n
+      FROM blocks b
+      JOIN block_txs bt ON bt.block_id = b.id
+      JOIN txs t ON t.id = bt.tx_id
+     WHERE b.height <= _h
+       AND NOT b.orphan
+  $$ LANGUAGE sql STABLE;
+
+  -- Whether the output is spent by a tx of the main chain up to _h
+  CREATE OR REPLACE FUNCTION spent_asof(_tx_id BIGINT, _n SMALLINT, _h INT) RETURNS BOOLEAN AS $$
+    SELECT EXISTS (
+      SELECT 1
+        FROM txins i
+        JOIN block_txs bt ON bt.tx_id = i.tx_id
+        JOIN blocks b ON b.id = bt.block_id
+       WHERE i.prevout_tx_id = _tx_id
+         AND i.prevout_n = _n
+         AND b.height <= _h
+         AND NOT b.orphan)
+  $$ LANGUAGE sql STABLE;
+
+  CREATE OR REPLACE FUNCTION txouts_asof(_h INT)
+    RETURNS TABLE (tx_id BIGINT, n SMALLINT, value BIGINT, scriptpubkey BYTEA, spent BOOLEAN, height INT) AS $$
+    SELECT o.tx_id, o.n, o.value, o.scriptpubkey,
+           o.spent AND spent_asof(o.tx_id, o.n, _h), -- not spent now, not spent then
+           b.height
+      FROM blocks b
+      JOIN block_txs bt ON bt.block_id = b.id
+      JOIN txouts_v o ON o.tx_id = bt.tx_id
+     WHERE b.height <= _h
+       AND NOT b.orphan
+  $$ LANGUAGE sql STABLE;
+
+  CREATE OR REPLACE FUNCTION utxos_asof(_h INT)
+    RETURNS TABLE (tx_id BIGINT, n SMALLINT, value BIGINT, scriptpubkey BYTEA, height INT) AS $$
+    SELECT o.tx_id, o.n, o.value, o.scriptpubkey, o.height
+      FROM txouts_asof(_h) o
+     WHERE NOT o.spent
+  $$ LANGUAGE sql STABLE;
+
+  -- By the address prefix index on txouts (none with deduplicated
+  -- scripts), addr as returned by extract_address()
+  CREATE OR REPLACE FUNCTION address_utxos_asof(_addr BYTEA, _h INT)
+    RETURNS TABLE (tx_id BIGINT, n SMALLINT, value BIGINT, height INT) AS $$
+    SELECT o.tx_id, o.n, o.value, h.height
+      FROM txouts_v o
+     CROSS JOIN LATERAL (SELECT tx_height(o.tx_id) AS height) h
+     WHERE addr_prefix(o.scriptpubkey) = bytes2int8(_addr)
+       AND extract_address(o.scriptpubkey) = _addr
+       AND h.height <= _h
+       AND NOT (o.spent AND spent_asof(o.tx_id, o.n, _h))
+  $$ LANGUAGE sql STABLE;
+`)
+	return err
+}
+
+// SelectAddrUtxosAsOfJson returns the unspent outputs of the address
+// (as returned by extract_address()) as of the end of the block at
+// height, oldest first. With matureOnly, the immature coinbase outputs
+// are left out (see maturity.go).
+func (e *Explorer) SelectAddrUtxosAsOfJson(addr []byte, height int, matureOnly bool) ([]string, error) {
+	var utxos []string
+	err := e.readSelect(&utxos, `
+SELECT to_json(u.*) FROM (
+  SELECT t.txid, o.n, o.value, o.height
+    FROM address_utxos_asof($1, $2) o
+    JOIN txs t ON t.id = o.tx_id
+   WHERE NOT ($3 AND coinbase_immature(o.tx_id, $2))
+   ORDER BY o.tx_id, o.n
+) u`, addr, height, matureOnly)
+	return utxos, err
+}
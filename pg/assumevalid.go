@@ -0,0 +1,93 @@
+package pg
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/blkchain/blkchain/core"
+)
+
+// Assume-valid, as -assumevalid in Core: the script and signature
+// validation of cmd/verify -scripts is skipped for the inputs of the
+// blocks up to the given (non-orphan) block, which is trusted to have
+// been validated by whoever chose its hash, e.g. from the
+// defaultAssumeValid of the Core release. Their transactions are still
+// rebuilt from the rows and checked against the txid, and the headers
+// of all the blocks are checked by VerifyHeaders (the proof of work
+// and the hash), so only the costly part is skipped.
+// https://github.com/bitcoin/bitcoin/blob/v22.0/src/validation.cpp
+
+// assumeValidHeight returns the height of the assume-valid block, -1
+// if there is none ("" or "0", as in Core).
+func assumeValidHeight(db *sql.DB, hash string) (int, error) {
+	if hash == "" || hash == "0" {
+		return -1, nil
+	}
+	h, err := core.Uint256FromString(hash)
+	if err != nil {
+		return -1, fmt.Errorf("Invalid assume-valid block hash %q: %v", hash, err)
+	}
+	var height int
+	err = db.QueryRow("SELECT height FROM blocks WHERE hash = $1 AND NOT orphan", h[:]).Scan(&height)
+	if err == sql.ErrNoRows {
+		return -1, fmt.Errorf("The assume-valid block %v is not in the chain.", h)
+	}
+	return height, err
+}
+
+// VerifyHeaders checks that the header of each block at or above the
+// given height (0 = everything), rebuilt from its row, hashes to the
+// hash of the block and that the hash meets the target of its bits.
+func VerifyHeaders(connstr string, sinceHeight int) (*CheckResult, error) {
+	db, err := sql.Open("postgres", connstr)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	log.Printf("Checking the block headers...")
+	start := time.Now()
+	r := &CheckResult{Name: "blocks_header", Desc: "blocks whose header does not hash to the hash or misses the target"}
+	rows, err := db.Query(`
+SELECT height, hash, version, prevhash, merkleroot, time, bits, nonce
+  FROM blocks
+ WHERE height >= $1`, sinceHeight)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			height                     int
+			hash, prevHash, merkleRoot []byte
+			version, tm, bits, nonce   int32
+		)
+		if err := rows.Scan(&height, &hash, &version, &prevHash, &merkleRoot, &tm, &bits, &nonce); err != nil {
+			return nil, err
+		}
+		bh := &core.BlockHeader{
+			Version:        core.Uint32(version),
+			PrevHash:       core.Uint256FromBytes(prevHash),
+			HashMerkleRoot: core.Uint256FromBytes(merkleRoot),
+			Time:           core.Uint32(tm),
+			Bits:           core.Uint32(bits),
+			Nonce:          core.Uint32(nonce),
+		}
+		h := bh.Hash()
+		switch {
+		case h != core.Uint256FromBytes(hash):
+			log.Printf("Block %v at height %d: the header hashes to %v.", core.Uint256FromBytes(hash), height, h)
+			r.Count++
+		case h.Big().Cmp(bh.Target()) > 0:
+			log.Printf("Block %v at height %d: the hash is above the target of bits %08x.", h, height, uint32(bits))
+			r.Count++
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	r.Duration = time.Now().Sub(start)
+	return r, nil
+}
@@ -0,0 +1,176 @@
+package pg
+
+import (
+	"database/sql"
+	"log"
+	"time"
+)
+
+// Historical balances. address_events is an append-only log of every
+// change to the balance of an address (as returned by
+// extract_address()), in chain order, with the running balance after
+// each one, so that the balance at any height is a single index lookup.
+// Spends are attributed to the address of the output being spent.
+// Outputs without an address (P2PK, bare multisig, taproot, ...) are
+// not included.
+//
+// Since the log is never rewritten, only blocks with at least
+// AddressEventsConfirmations confirmations are added, which is assumed
+// to be beyond the reach of a reorg.
+
+const (
+	AddressEventsConfirmations = 6
+	addressEventsBatch         = 1000 // blocks per INSERT
+)
+
+func createAddressEventsTable(db *sql.DB) error {
+	_, err := db.Exec(`
+  CREATE TABLE IF NOT EXISTS address_events (
+   addr           BYTEA NOT NULL -- as returned by extract_address()
+  ,height         INT NOT NULL
+  ,tx_id          BIGINT NOT NULL
+  ,n              SMALLINT NOT NULL -- txin n if spending, txout n otherwise
+  ,spending       BOOLEAN NOT NULL
+  ,value          BIGINT NOT NULL -- negative if spending
+  ,balance        BIGINT NOT NULL -- after this event
+  );
+  CREATE INDEX IF NOT EXISTS address_events_addr_height_idx ON address_events(addr, height, tx_id);
+
+  CREATE TABLE IF NOT EXISTS address_events_height (
+   height         INT NOT NULL -- last height added to address_events
+  );
+
+  -- Balance of an address at the end of the block at height
+  CREATE OR REPLACE FUNCTION address_balance(addr BYTEA, height INT) RETURNS BIGINT AS $$
+    SELECT COALESCE((
+      SELECT balance
+        FROM address_events e
+       WHERE e.addr = $1
+         AND e.height <= $2
+       ORDER BY e.height DESC, e.tx_id DESC, e.spending, e.n DESC
+       LIMIT 1), 0);
+  $$ LANGUAGE sql STABLE;
+
+  -- Current balance of every address with events
+  CREATE OR REPLACE VIEW address_balances_v AS
+    SELECT DISTINCT ON (addr) addr, height, balance
+      FROM address_events
+     ORDER BY addr, height DESC, tx_id DESC, spending, n DESC;
+`)
+	return err
+}
+
+// UpdateAddressEvents adds the blocks since the last update to
+// address_events, if enabled in the WriterConfig. Orphan blocks are
+// excluded, so this should be called after SetOrphans().
+func (w *PGWriter) UpdateAddressEvents() error {
+	if w.db == nil || !w.cfg.AddressEvents {
+		return nil
+	}
+	return updateAddressEvents(w.db, false)
+}
+
+func updateAddressEvents(db *sql.DB, verbose bool) error {
+	if err := createAddressEventsTable(db); err != nil {
+		return err
+	}
+
+	var from, tip int
+	if err := db.QueryRow("SELECT COALESCE(MAX(height), -1) + 1 FROM address_events_height").Scan(&from); err != nil {
+		return err
+	}
+	if err := db.QueryRow("SELECT COALESCE(MAX(height), -1) FROM blocks WHERE NOT orphan").Scan(&tip); err != nil {
+		return err
+	}
+
+	for to := tip - AddressEventsConfirmations; from <= to; from += addressEventsBatch {
+		last := from + addressEventsBatch - 1
+		if last > to {
+			last = to
+		}
+		start := time.Now()
+		if err := addAddressEvents(db, from, last); err != nil {
+			return err
+		}
+		if verbose {
+			log.Printf("  Address events up to height %d done in %s.", last, time.Now().Sub(start).Round(time.Millisecond))
+		}
+	}
+	return nil
+}
+
+func addAddressEvents(db *sql.DB, from, to int) error {
+	txn, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	// Within a tx the inputs come first, hence spending DESC.
+	if _, err := txn.Exec(`
+INSERT INTO address_events (addr, height, tx_id, n, spending, value, balance)
+SELECT e.addr, e.height, e.tx_id, e.n, e.spending, e.value,
+       COALESCE(p.balance, 0) + SUM(e.value) OVER (PARTITION BY e.addr ORDER BY e.tx_id, e.spending DESC, e.n)
+  FROM (
+    SELECT extract_address(o.scriptpubkey) AS addr, b.height, o.tx_id, o.n, false AS spending, o.value
+      FROM blocks b
+      JOIN block_txs bt ON bt.block_id = b.id
+      JOIN txouts_v o ON o.tx_id = bt.tx_id
+     WHERE b.height BETWEEN $1 AND $2
+       AND NOT b.orphan
+    UNION ALL
+    SELECT extract_address(po.scriptpubkey) AS addr, b.height, i.tx_id, i.n, true AS spending, -po.value
+      FROM blocks b
+      JOIN block_txs bt ON bt.block_id = b.id
+      JOIN txins i ON i.tx_id = bt.tx_id
+      JOIN txouts_v po ON po.tx_id = i.prevout_tx_id AND po.n = i.prevout_n
+     WHERE b.height BETWEEN $1 AND $2
+       AND NOT b.orphan
+  ) e
+  LEFT JOIN LATERAL (
+    SELECT balance
+      FROM address_events a
+     WHERE a.addr = e.addr
+     ORDER BY a.height DESC, a.tx_id DESC, a.spending, a.n DESC
+     LIMIT 1
+  ) p ON true
+ WHERE e.addr IS NOT NULL`, from, to); err != nil {
+		txn.Rollback()
+		return err
+	}
+	if _, err := txn.Exec("DELETE FROM address_events_height"); err != nil {
+		txn.Rollback()
+		return err
+	}
+	if _, err := txn.Exec("INSERT INTO address_events_height (height) VALUES ($1)", to); err != nil {
+		txn.Rollback()
+		return err
+	}
+	return txn.Commit()
+}
+
+// SelectAddrBalanceAt returns the balance of the address (as returned
+// by extract_address()) at the end of the block at height. The balance
+// is only known up to the last height in address_events, which is
+// returned as well. With matureOnly, the immature coinbase outputs are
+// left out (see maturity.go).
+func (e *Explorer) SelectAddrBalanceAt(addr []byte, height int, matureOnly bool) (int64, int, error) {
+	var last int
+	if err := e.readGet(&last, "SELECT COALESCE(MAX(height), -1) FROM address_events_height"); err != nil {
+		return 0, 0, err
+	}
+	var balance int64
+	if err := e.readGet(&balance, "SELECT address_balance($1, $2)", addr, height); err != nil {
+		return 0, 0, err
+	}
+	if matureOnly {
+		if height > last {
+			height = last
+		}
+		immature, err := e.immatureAddrEvents(addr, height)
+		if err != nil {
+			return 0, 0, err
+		}
+		balance -= immature
+	}
+	return balance, last, nil
+}
@@ -0,0 +1,231 @@
+package pg
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+
+	"github.com/blkchain/blkchain/core"
+	"github.com/lib/pq"
+)
+
+// Rebuilding the blocks of the main chain from the database, e.g. to
+// write them to block files (see core.BlockFileWriter). A block is
+// only returned if its merkle root, witness commitment and hash are
+// those of the header, so pruned scripts, header-only blocks or
+// missing prevout ids make it fail. Sharding is not supported.
+
+// ExportBlocks calls fn with every block of the main chain from height
+// from to to (inclusive, negative = the tip), in height order. Returns
+// the number of blocks.
+func ExportBlocks(connstr string, from, to int, fn func(b *core.Block, height int) error) (int, error) {
+	db, err := sql.Open("postgres", connstr)
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	if to < 0 {
+		if to, err = mainChainTip(db); err != nil {
+			return 0, err
+		}
+	}
+	return exportBlocks(db, from, to, fn)
+}
+
+func mainChainTip(db *sql.DB) (int, error) {
+	var tip int
+	err := db.QueryRow("SELECT COALESCE(MAX(height), -1) FROM blocks WHERE NOT orphan").Scan(&tip)
+	return tip, err
+}
+
+func exportBlocks(db *sql.DB, from, to int, fn func(b *core.Block, height int) error) (int, error) {
+	var sharded bool
+	if err := db.QueryRow("SELECT to_regclass(current_schema() || '.shard_heights') IS NOT NULL").Scan(&sharded); err != nil {
+		return 0, err
+	}
+	if sharded {
+		return 0, fmt.Errorf("Exporting blocks is not supported with sharding.")
+	}
+
+	cnt := 0
+	for height := from; height <= to; height++ {
+		b, err := readBlock(db, height)
+		if err != nil {
+			return cnt, fmt.Errorf("Height %d: %v", height, err)
+		}
+		if err := fn(b, height); err != nil {
+			return cnt, err
+		}
+		cnt++
+	}
+	return cnt, nil
+}
+
+// readBlock rebuilds the main chain block at height.
+func readBlock(db *sql.DB, height int) (*core.Block, error) {
+	b, err := readBlockWhere(db, "height = $1 AND NOT orphan", height)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("No block")
+	}
+	return b, err
+}
+
+// readBlockWhere rebuilds the block of the condition on blocks, with
+// the argument as $1, sql.ErrNoRows if there is none.
+func readBlockWhere(db *sql.DB, cond string, arg interface{}) (*core.Block, error) {
+	var (
+		id   int
+		size int
+		hash core.Uint256
+	)
+	bh := &core.BlockHeader{}
+	err := db.QueryRow(`
+SELECT id, hash, version, prevhash, merkleroot, time, bits, nonce, size
+  FROM blocks
+ WHERE `+cond, arg).Scan(&id, &hash, &bh.Version, &bh.PrevHash, &bh.HashMerkleRoot, &bh.Time, &bh.Bits, &bh.Nonce, &size)
+	if err != nil {
+		return nil, err
+	}
+	if size == 0 {
+		return nil, fmt.Errorf("Block %v is header-only", hash)
+	}
+	b := &core.Block{BlockHeader: bh}
+
+	rows, err := db.Query(`
+SELECT t.id, t.version, t.locktime
+  FROM block_txs bt
+  JOIN txs t ON t.id = bt.tx_id
+ WHERE bt.block_id = $1
+ ORDER BY bt.n`, id)
+	if err != nil {
+		return nil, err
+	}
+	var ids []int64
+	txs := make(map[int64]*core.Tx)
+	for rows.Next() {
+		var (
+			txId              int64
+			version, lockTime int32
+		)
+		if err := rows.Scan(&txId, &version, &lockTime); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		tx := &core.Tx{Version: uint32(version), LockTime: uint32(lockTime)}
+		b.Txs = append(b.Txs, tx)
+		ids = append(ids, txId)
+		txs[txId] = tx
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if err := readTxs(db, ids, txs); err != nil {
+		return nil, err
+	}
+
+	if b.Hash() != hash {
+		return nil, fmt.Errorf("Header hash is %v, not %v", b.Hash(), hash)
+	}
+	if root := b.Txs.MerkleRoot(); root != bh.HashMerkleRoot {
+		return nil, fmt.Errorf("Block %v cannot be rebuilt (pruned scripts or unresolved prevouts?), merkle root %v", hash, root)
+	}
+	if err := checkWitnessCommitment(b); err != nil {
+		return nil, fmt.Errorf("Block %v: %v", hash, err)
+	}
+	return b, nil
+}
+
+// readTxs adds the inputs and outputs to the txs of the ids.
+func readTxs(db *sql.DB, ids []int64, txs map[int64]*core.Tx) error {
+	rows, err := db.Query(`
+SELECT i.tx_id, p.txid, i.prevout_n, i.scriptsig, i.sequence, i.witness
+  FROM txins i
+  LEFT JOIN txs p ON p.id = i.prevout_tx_id
+ WHERE i.tx_id = ANY($1)
+ ORDER BY i.tx_id, i.n`, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var (
+			txId                         int64
+			prevHash, scriptSig, witness []byte
+			prevN, sequence              int32
+		)
+		if err := rows.Scan(&txId, &prevHash, &prevN, &scriptSig, &sequence, &witness); err != nil {
+			rows.Close()
+			return err
+		}
+		tx := txs[txId]
+		in := &core.TxIn{
+			PrevOut:   core.OutPoint{Hash: core.Uint256FromBytes(prevHash), N: uint32(prevN)},
+			ScriptSig: scriptSig,
+			Sequence:  uint32(sequence),
+		}
+		if witness != nil {
+			if err := core.BinRead(&in.Witness, bytes.NewReader(witness)); err != nil {
+				rows.Close()
+				return err
+			}
+			tx.SegWit = tx.SegWit || len(in.Witness) > 0
+		}
+		tx.TxIns = append(tx.TxIns, in)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	rows, err = db.Query(`
+SELECT tx_id, value, scriptpubkey
+  FROM txouts_v
+ WHERE tx_id = ANY($1)
+ ORDER BY tx_id, n`, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var txId int64
+		out := &core.TxOut{}
+		if err := rows.Scan(&txId, &out.Value, &out.ScriptPubKey); err != nil {
+			rows.Close()
+			return err
+		}
+		txs[txId].TxOuts = append(txs[txId].TxOuts, out)
+	}
+	rows.Close()
+	return rows.Err()
+}
+
+// checkWitnessCommitment checks the BIP141 commitment of a block with
+// segwit transactions.
+func checkWitnessCommitment(b *core.Block) error {
+	segwit := false
+	for _, tx := range b.Txs {
+		segwit = segwit || tx.SegWit
+	}
+	if !segwit {
+		return nil
+	}
+	coinbase := b.Txs[0]
+	if len(coinbase.TxIns) != 1 || len(coinbase.TxIns[0].Witness) != 1 || len(coinbase.TxIns[0].Witness[0]) != 32 {
+		return fmt.Errorf("No witness reserved value in the coinbase")
+	}
+	prefix := []byte{core.OP_RETURN, 36, 0xaa, 0x21, 0xa9, 0xed}
+	var commitment []byte
+	for _, out := range coinbase.TxOuts { // the last one counts
+		if len(out.ScriptPubKey) >= 38 && bytes.HasPrefix(out.ScriptPubKey, prefix) {
+			commitment = out.ScriptPubKey[6:38]
+		}
+	}
+	if commitment == nil {
+		return fmt.Errorf("No witness commitment in the coinbase")
+	}
+	root := b.Txs.WitnessMerkleRoot()
+	if h := core.ShaSha256(append(root[:], coinbase.TxIns[0].Witness[0]...)); !bytes.Equal(h[:], commitment) {
+		return fmt.Errorf("Witness data does not match the commitment")
+	}
+	return nil
+}
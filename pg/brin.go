@@ -0,0 +1,63 @@
+package pg
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+)
+
+// The bulk loaded data is naturally ordered by insertion, i.e. by
+// height, time and id, which makes BRIN indexes a good fit: they are
+// a tiny fraction of the size of a B-tree at the cost of scanning a
+// few pages per lookup. With IndexStrategy "brin" the following
+// B-trees are replaced by BRIN indexes:
+//
+//   blocks(time)         (a B-tree on time is created otherwise)
+//   block_txs(block_id)  instead of the (block_id, n) primary key
+//   txins(tx_id)         instead of the (tx_id, n) primary key
+//
+// Uniqueness of block_txs and txins is then not enforced. Indexes
+// used for random lookups (hashes, txids, prevouts, the txouts
+// primary key used by the spent trigger) remain B-trees, as do the
+// primary keys referenced by foreign keys.
+
+const (
+	IndexStrategyBtree = "btree"
+	IndexStrategyBrin  = "brin"
+)
+
+func checkIndexStrategy(strategy string) error {
+	switch strategy {
+	case "", IndexStrategyBtree, IndexStrategyBrin:
+		return nil
+	}
+	return fmt.Errorf("Invalid index strategy: %q (must be %q or %q)", strategy, IndexStrategyBtree, IndexStrategyBrin)
+}
+
+// Called from createIndexes.
+func createOrderedIndexes(db *sql.DB, strategy string, verbose bool) error {
+	var stmts []string
+	if strategy == IndexStrategyBrin {
+		// autosummarize so that ranges added on catch up get summarized by autovacuum
+		stmts = []string{
+			"CREATE INDEX IF NOT EXISTS blocks_time_brin_idx ON blocks USING BRIN(time) WITH (autosummarize=on);",
+			"CREATE INDEX IF NOT EXISTS block_txs_block_id_brin_idx ON block_txs USING BRIN(block_id) WITH (autosummarize=on);",
+			"CREATE INDEX IF NOT EXISTS txins_tx_id_brin_idx ON txins USING BRIN(tx_id) WITH (autosummarize=on);",
+		}
+	} else {
+		stmts = []string{
+			"CREATE INDEX IF NOT EXISTS blocks_time_idx ON blocks(time);",
+		}
+	}
+	for _, stmt := range stmts {
+		start := time.Now()
+		if _, err := db.Exec(stmt); err != nil {
+			return err
+		}
+		if verbose {
+			log.Printf("  %s done in %s.", stmt, time.Now().Sub(start).Round(time.Millisecond))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package pg
+
+import (
+	"fmt"
+
+	"github.com/blkchain/blkchain/core"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/lib/pq"
+)
+
+// Checks of a transaction before it is relayed to the node (see
+// serve.go), against the indexed UTXO set: the prevouts exist and are
+// unspent, the scripts are standard and the fee is sane. The node does
+// the full validation, this is to catch the usual mistakes early and
+// explain them. Outputs which are not yet in the database, e.g. of
+// unconfirmed parents, are reported as not found.
+
+const (
+	MinRelayFeeRate   = 1.0     // sat/vB
+	DefaultMaxFeeRate = 10000.0 // sat/vB, as Core's maxfeerate
+	maxNullDataSize   = 83      // OP_RETURN script, as Core's datacarriersize
+)
+
+type TxCheck struct {
+	TxId     core.Uint256 `json:"txid"`
+	Fee      int64        `json:"fee"`
+	VirtSize int          `json:"virt_size"`
+	FeeRate  float64      `json:"fee_rate"` // sat/vB
+	Problems []string     `json:"problems,omitempty"`
+}
+
+func (c *TxCheck) problem(format string, args ...interface{}) {
+	c.Problems = append(c.Problems, fmt.Sprintf(format, args...))
+}
+
+// CheckTx checks tx, a fee rate over maxFeeRate (sat/vB) is a
+// problem. The result has the problems found, an error is returned
+// only if the check could not be made.
+func (e *Explorer) CheckTx(tx *core.Tx, maxFeeRate float64) (*TxCheck, error) {
+	c := &TxCheck{TxId: tx.Hash(), VirtSize: tx.VirtualSize()}
+
+	if len(tx.TxIns) == 0 {
+		c.problem("No inputs")
+	}
+	if len(tx.TxOuts) == 0 {
+		c.problem("No outputs")
+	}
+
+	var outTotal int64
+	for n, out := range tx.TxOuts {
+		if out.Value < 0 {
+			c.problem("Output %d: negative value", n)
+		}
+		outTotal += out.Value
+		switch txscript.GetScriptClass(out.ScriptPubKey) {
+		case txscript.NonStandardTy:
+			c.problem("Output %d: non-standard script", n)
+		case txscript.NullDataTy:
+			if len(out.ScriptPubKey) > maxNullDataSize {
+				c.problem("Output %d: OP_RETURN script over %d bytes", n, maxNullDataSize)
+			}
+		}
+	}
+
+	seen := make(map[core.OutPoint]bool, len(tx.TxIns))
+	hashes := make([][]byte, 0, len(tx.TxIns))
+	ns := make([]int64, 0, len(tx.TxIns))
+	for n, in := range tx.TxIns {
+		if in.PrevOut.N == 0xffffffff {
+			c.problem("Input %d: coinbase", n)
+			continue
+		}
+		if seen[in.PrevOut] {
+			c.problem("Input %d: duplicate of an earlier input", n)
+		}
+		seen[in.PrevOut] = true
+		if len(in.ScriptSig) > 0 && !txscript.IsPushOnlyScript(in.ScriptSig) {
+			c.problem("Input %d: scriptsig is not push only", n)
+		}
+		hash := in.PrevOut.Hash
+		hashes = append(hashes, hash[:])
+		ns = append(ns, int64(in.PrevOut.N))
+	}
+	if len(hashes) == 0 {
+		return c, nil
+	}
+
+	// From the primary, a replica may not have seen the latest spends.
+	var prevOuts []struct {
+		N     int   `db:"n"`
+		Value int64 `db:"value"`
+		Spent bool  `db:"spent"`
+	}
+	if err := e.db.Select(&prevOuts, `
+SELECT i.n - 1 AS n, o.value, o.spent
+  FROM unnest($1::bytea[], $2::int[]) WITH ORDINALITY AS i(txid, vout, n)
+  JOIN txs t ON t.txid = i.txid
+  JOIN txouts o ON o.tx_id = t.id AND o.n = i.vout`, pq.Array(hashes), pq.Array(ns)); err != nil {
+		return nil, err
+	}
+
+	found := make(map[int]bool, len(prevOuts))
+	var inTotal int64
+	for _, po := range prevOuts {
+		found[po.N] = true
+		inTotal += po.Value
+		if po.Spent {
+			c.problem("Prevout %v:%d is already spent", core.Uint256FromBytes(hashes[po.N]), ns[po.N])
+		}
+	}
+	if len(found) < len(hashes) {
+		for i := range hashes {
+			if !found[i] {
+				c.problem("Prevout %v:%d not found", core.Uint256FromBytes(hashes[i]), ns[i])
+			}
+		}
+		return c, nil // the fee is unknown
+	}
+
+	c.Fee = inTotal - outTotal
+	if c.VirtSize > 0 {
+		c.FeeRate = float64(c.Fee) / float64(c.VirtSize)
+	}
+	switch {
+	case c.Fee < 0:
+		c.problem("Outputs exceed inputs by %d sat", -c.Fee)
+	case c.FeeRate < MinRelayFeeRate:
+		c.problem("Fee rate %.2f sat/vB is below the minimum relay fee rate of %.0f", c.FeeRate, MinRelayFeeRate)
+	case maxFeeRate > 0 && c.FeeRate > maxFeeRate:
+		c.problem("Fee rate %.2f sat/vB is over the maximum of %.0f", c.FeeRate, maxFeeRate)
+	}
+	return c, nil
+}
@@ -0,0 +1,107 @@
+package pg
+
+import (
+	"database/sql"
+
+	"github.com/blkchain/blkchain/core"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// Balances (and UTXOs) of many addresses at once, for wallet backends,
+// with a single query however many addresses there are. The balances
+// are those of SelectAddrBalanceAt, as of the last height in
+// address_events at most, the UTXOs those of SelectAddrUtxosAsOfJson.
+
+// The most addresses in one SelectAddrsBalances.
+const MaxBulkAddresses = 1000
+
+type AddrBalance struct {
+	Balance int64       `json:"balance"`
+	Utxos   []*AddrUtxo `json:"utxos,omitempty"`
+}
+
+type AddrUtxo struct {
+	Txid   string `json:"txid"` // display order
+	N      int    `json:"n"`
+	Value  int64  `json:"value"`
+	Height int    `json:"height"`
+}
+
+// SelectAddrsBalances returns the balances of the addresses (as
+// returned by extract_address()) at the end of the block at height, in
+// the order of the addresses, and the height the balances are as of.
+// With withUtxos, their unspent outputs too, an empty list rather than
+// nil if none. With matureOnly, the immature coinbase outputs are left
+// out.
+func (e *Explorer) SelectAddrsBalances(addrs [][]byte, height int, matureOnly, withUtxos bool) ([]*AddrBalance, int, error) {
+	var (
+		balances []*AddrBalance
+		last     int
+	)
+	err := e.read(func(db *sqlx.DB) error {
+		rows, err := db.Query(`
+SELECT a.i, l.last, b.balance - m.immature, u.txid, u.n, u.value, u.height
+  FROM UNNEST($1::BYTEA[]) WITH ORDINALITY a(addr, i)
+ CROSS JOIN (SELECT COALESCE(MAX(height), -1) AS last FROM address_events_height) l
+ CROSS JOIN LATERAL (SELECT address_balance(a.addr, $2) AS balance) b
+ CROSS JOIN LATERAL (
+   SELECT COALESCE(SUM(e.value), 0)::BIGINT AS immature
+     FROM address_events e
+     JOIN block_txs bt ON bt.tx_id = e.tx_id AND bt.n = 0
+    WHERE $3
+      AND e.addr = a.addr
+      AND NOT e.spending
+      AND e.height <= LEAST($2, l.last)
+      AND LEAST($2, l.last) - e.height + 1 < $5
+ ) m
+  LEFT JOIN LATERAL (
+   SELECT o.tx_id, t.txid, o.n, o.value, o.height
+     FROM address_utxos_asof(a.addr, $2) o
+     JOIN txs t ON t.id = o.tx_id
+    WHERE $4
+      AND NOT ($3 AND coinbase_immature(o.tx_id, $2))
+ ) u ON true
+ ORDER BY a.i, u.tx_id, u.n`, pq.ByteaArray(addrs), height, matureOnly, withUtxos, core.CoinbaseMaturity)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		balances = make([]*AddrBalance, len(addrs))
+		for rows.Next() {
+			var (
+				i, balance  int64
+				n, value, h sql.NullInt64 // NULL without UTXOs
+				txid        []byte
+			)
+			if err := rows.Scan(&i, &last, &balance, &txid, &n, &value, &h); err != nil {
+				return err
+			}
+			b := balances[i-1]
+			if b == nil {
+				b = &AddrBalance{Balance: balance}
+				if withUtxos {
+					b.Utxos = []*AddrUtxo{}
+				}
+				balances[i-1] = b
+			}
+			if txid != nil {
+				b.Utxos = append(b.Utxos, &AddrUtxo{
+					Txid:   core.Uint256FromBytes(txid).String(),
+					N:      int(n.Int64),
+					Value:  value.Int64,
+					Height: int(h.Int64),
+				})
+			}
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	if height > last {
+		height = last
+	}
+	return balances, height, nil
+}
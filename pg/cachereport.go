@@ -0,0 +1,181 @@
+package pg
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+// The txid cache report: the hits, misses and evictions of the txid
+// cache (see txidcache.go) per range of heights, written as JSON at
+// the end of the import to WriterConfig.CacheReportPath, with the
+// cache size recommended for the next run. Misses are what the end of
+// the first import has to fix with a big UPDATE (see fixprevout.go),
+// and they come from the cache being full (dropped entries) or from
+// txs imported before this run. cmd/import -cache-report reads the
+// recommendation back from the file when -cache-size is not given.
+
+// Heights per range in the report.
+const cacheReportInterval = 10000
+
+// Roughly what an entry costs, the map overhead and the boxed count
+// included.
+const cacheEntryBytes = 48
+
+// Lookups missing more often than this with a full cache call for a
+// bigger one.
+const cacheMissThreshold = 0.01
+
+type CacheRange struct {
+	FromHeight int `json:"from_height"`
+	ToHeight   int `json:"to_height"`
+	Hits       int `json:"hits"`
+	Misses     int `json:"misses"`
+	Evictions  int `json:"evictions"`
+	Dropped    int `json:"dropped"`
+	Size       int `json:"size"` // at the end of the range
+}
+
+type CacheReport struct {
+	Start      time.Time `json:"start"`
+	End        time.Time `json:"end"`
+	FromHeight int       `json:"from_height"`
+	ToHeight   int       `json:"to_height"`
+	Capacity   int       `json:"capacity"`
+	PeakSize   int       `json:"peak_size"`
+	Hits       int       `json:"hits"`
+	Misses     int       `json:"misses"`
+	Evictions  int       `json:"evictions"`
+	Dropped    int       `json:"dropped"`
+	Collisions int       `json:"collisions"`
+	Dupes      int       `json:"dupes"`
+
+	Ranges []*CacheRange `json:"ranges"`
+
+	RecommendedSize int    `json:"recommended_cache_size"`
+	RecommendedMiB  int    `json:"recommended_cache_mib"` // approximately
+	Recommendation  string `json:"recommendation"`
+}
+
+// ReadCacheReport reads a report written by an earlier import.
+func ReadCacheReport(path string) (*CacheReport, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var r CacheReport
+	if err := json.Unmarshal(b, &r); err != nil {
+		return nil, fmt.Errorf("Reading cache report %s: %v", path, err)
+	}
+	return &r, nil
+}
+
+type cacheReporter struct {
+	path   string
+	c      *txIdCache
+	report CacheReport
+	last   CacheStatus // at the start of the current range
+	from   int         // -1 = no range yet
+	height int
+}
+
+// newCacheReporter returns nil if there is no path, which is a no-op.
+func newCacheReporter(path string, c *txIdCache) *cacheReporter {
+	if path == "" {
+		return nil
+	}
+	return &cacheReporter{
+		path:   path,
+		c:      c,
+		report: CacheReport{Start: time.Now(), FromHeight: -1, Capacity: c.sz},
+		last:   *c.status(),
+		from:   -1,
+	}
+}
+
+// block is called after each block is sent to the writers.
+func (r *cacheReporter) block(height int) {
+	if r == nil {
+		return
+	}
+	if r.from < 0 {
+		r.from = height
+		if r.report.FromHeight < 0 {
+			r.report.FromHeight = height
+		}
+	}
+	r.height = height
+	if height-r.from+1 >= cacheReportInterval {
+		r.endRange()
+	}
+}
+
+func (r *cacheReporter) endRange() {
+	st := r.c.status()
+	r.report.Ranges = append(r.report.Ranges, &CacheRange{
+		FromHeight: r.from,
+		ToHeight:   r.height,
+		Hits:       st.Hits - r.last.Hits,
+		Misses:     st.Misses - r.last.Misses,
+		Evictions:  st.Evictions - r.last.Evictions,
+		Dropped:    st.Dropped - r.last.Dropped,
+		Size:       st.Size,
+	})
+	r.last, r.from = *st, -1
+}
+
+// finish writes the report, logging rather than returning errors, a
+// report is not worth failing the import for.
+func (r *cacheReporter) finish() {
+	if r == nil || r.report.FromHeight < 0 {
+		return
+	}
+	if r.from >= 0 {
+		r.endRange()
+	}
+
+	st := r.c.status()
+	rep := &r.report
+	rep.End = time.Now()
+	rep.ToHeight = r.height
+	rep.Hits, rep.Misses, rep.Evictions, rep.Dropped = st.Hits, st.Misses, st.Evictions, st.Dropped
+	rep.Collisions, rep.Dupes = st.Collisions, st.Dupes
+	r.c.Lock()
+	rep.PeakSize = r.c.peak
+	r.c.Unlock()
+	rep.recommend()
+
+	b, err := json.MarshalIndent(rep, "", "  ")
+	if err == nil {
+		err = os.WriteFile(r.path, append(b, '\n'), 0644)
+	}
+	if err != nil {
+		log.Printf("Error writing the txid cache report: %v", err)
+		return
+	}
+	log.Printf("Wrote the txid cache report to %s: %s", r.path, rep.Recommendation)
+}
+
+func (rep *CacheReport) recommend() {
+	var missRate float64
+	if rep.Hits+rep.Misses > 0 {
+		missRate = float64(rep.Misses) / float64(rep.Hits+rep.Misses)
+	}
+	switch {
+	case rep.Dropped == 0:
+		rep.RecommendedSize = rep.PeakSize + rep.PeakSize/4
+		rep.Recommendation = fmt.Sprintf("The cache never filled up (peak %d of %d), %d leaves a margin.",
+			rep.PeakSize, rep.Capacity, rep.RecommendedSize)
+	case missRate > cacheMissThreshold:
+		rep.RecommendedSize = rep.Capacity * 2
+		rep.Recommendation = fmt.Sprintf("The cache was full and %.02f%% of the lookups missed, try %d if the memory allows.",
+			missRate*100, rep.RecommendedSize)
+	default:
+		rep.RecommendedSize = rep.Capacity
+		rep.Recommendation = fmt.Sprintf("The cache was full but only %.02f%% of the lookups missed, %d is about right.",
+			missRate*100, rep.RecommendedSize)
+	}
+	rep.RecommendedMiB = rep.RecommendedSize * cacheEntryBytes / 1024 / 1024
+}
@@ -0,0 +1,81 @@
+package pg
+
+import (
+	"github.com/blkchain/blkchain/core"
+)
+
+// Paging through the main chain from the tip, with the confirmations of
+// every block as of the tip at the time of the query.
+
+type ChainBlock struct {
+	Height        int          `db:"height" json:"height"`
+	Hash          core.Uint256 `db:"hash" json:"hash"`
+	PrevHash      core.Uint256 `db:"prevhash" json:"prevhash"`
+	Time          int64        `db:"time" json:"time"`
+	Size          int          `db:"size" json:"size"`
+	Weight        int          `db:"weight" json:"weight"`
+	Confirmations int          `db:"confirmations" json:"confirmations"`
+	Tip           int          `db:"tip" json:"-"`
+}
+
+// A ChainPage is a page of blocks, highest first. Next is the height to
+// pass as before for the page of lower blocks, Prev as after for the
+// higher ones, nil at either end of the chain.
+type ChainPage struct {
+	Tip    int           `json:"tip"`
+	Blocks []*ChainBlock `json:"blocks"`
+	Next   *int          `json:"next"`
+	Prev   *int          `json:"prev"`
+}
+
+// SelectChainPage returns up to limit blocks of the main chain below
+// the height before, or, if after is true, above it.
+func (e *Explorer) SelectChainPage(height int, after bool, limit int) (*ChainPage, error) {
+	cond, order := "b.height < $1", "DESC"
+	if after {
+		cond, order = "b.height > $1", "ASC"
+	}
+	stmt := `
+WITH tip AS (SELECT MAX(height) AS h FROM blocks WHERE NOT orphan)
+SELECT b.height, b.hash, b.prevhash, b.time::BIGINT & 4294967295 AS time, b.size, b.weight,
+       tip.h - b.height + 1 AS confirmations, tip.h AS tip
+  FROM blocks b, tip
+ WHERE NOT b.orphan
+   AND ` + cond + `
+ ORDER BY b.height ` + order + `
+ LIMIT $2`
+
+	var blocks []*ChainBlock
+	if err := e.readSelect(&blocks, stmt, height, limit); err != nil {
+		return nil, err
+	}
+	if after { // highest first
+		for i, j := 0, len(blocks)-1; i < j; i, j = i+1, j-1 {
+			blocks[i], blocks[j] = blocks[j], blocks[i]
+		}
+	}
+
+	page := &ChainPage{Blocks: blocks}
+	if len(blocks) == 0 {
+		if err := e.readGet(&page.Tip, "SELECT COALESCE(MAX(height), -1) FROM blocks WHERE NOT orphan"); err != nil {
+			return nil, err
+		}
+		page.Blocks = []*ChainBlock{}
+		return page, nil
+	}
+	page.Tip = blocks[0].Tip
+	if last := blocks[len(blocks)-1].Height; last > 0 {
+		page.Next = &last
+	}
+	if first := blocks[0].Height; first < page.Tip {
+		page.Prev = &first
+	}
+	return page, nil
+}
+
+// SelectBlockHeight returns the height of the block with the hash.
+func (e *Explorer) SelectBlockHeight(hash core.Uint256) (int, error) {
+	var height int
+	err := e.readGet(&height, "SELECT height FROM blocks WHERE hash = $1", hash[:])
+	return height, err
+}
@@ -0,0 +1,59 @@
+package pg
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+)
+
+// Several chains in one database: each chain other than mainnet has
+// its tables, views and functions in a schema of its own, named after
+// it (e.g. "testnet4"), mainnet staying in public as before. The
+// chain is chosen per connection by its search_path, which is set from
+// WriterConfig.Chain and Config.Chain (and -chain of the commands),
+// so every query works as is. There is no chain_id column and no
+// composite keys: a chain_id would have to be a part of every key,
+// index and join, and of every writer and query API, which is not
+// done. The ids are per chain.
+//
+// The search_path is the chain's schema alone, not followed by public,
+// so that a table the chain does not have (e.g. of a parser it was not
+// imported with) is an error instead of silently being mainnet's.
+// pgcrypto is shared in public and called as public.digest(). The
+// checks for existing tables, constraints and triggers are restricted
+// to current_schema() all the same.
+
+var chainNameRe = regexp.MustCompile(`^[a-z][a-z0-9_]{0,62}$`)
+
+// ChainSchema returns the schema of the chain, "" for mainnet (i.e.
+// public).
+func ChainSchema(chain string) (string, error) {
+	switch chain {
+	case "", "main", "mainnet", "public":
+		return "", nil
+	}
+	if !chainNameRe.MatchString(chain) {
+		return "", fmt.Errorf("Invalid chain name %q, expected e.g. testnet4 or signet.", chain)
+	}
+	return chain, nil
+}
+
+// ChainConnStr returns the connect string with the search_path of the
+// chain.
+func ChainConnStr(connstr, chain string) (string, error) {
+	schema, err := ChainSchema(chain)
+	if err != nil || schema == "" {
+		return connstr, err
+	}
+	return withSessionSettings(connstr, map[string]string{"search_path": schema}), nil
+}
+
+// createChainSchema creates the schema of the chain, before the tables.
+func createChainSchema(db *sql.DB, chain string) error {
+	schema, err := ChainSchema(chain)
+	if err != nil || schema == "" {
+		return err
+	}
+	_, err = db.Exec(fmt.Sprintf("CREATE SCHEMA IF NOT EXISTS %s", schema))
+	return err
+}
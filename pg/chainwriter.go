@@ -0,0 +1,88 @@
+package pg
+
+import (
+	"time"
+
+	"github.com/blkchain/blkchain/core"
+)
+
+// ChainWriter is what the import needs of a destination for blocks:
+// the PGWriter, or a stream such as the NDJSONWriter.
+type ChainWriter interface {
+	WriteBlock(b *BlockRec, sync bool) error
+	// The hashes of the last blocks written, by height, to know where
+	// to continue from.
+	HeightAndHashes(back int) (map[int][]core.Uint256, error)
+	// Whether the destination is available, and has the block.
+	Ping() error
+	HasBlock(hash core.Uint256) (bool, error)
+	// Finish writing, the error is that of a failed write, if any.
+	Close() error
+	Uptime() time.Duration
+}
+
+// NewChainWriter returns the writer for cfg, the ElasticWriter if
+// ElasticURL is set, the NDJSONWriter if NDJSONPath is set, otherwise
+// the PGWriter.
+func NewChainWriter(cfg WriterConfig, utxo isUTXOer) (ChainWriter, error) {
+	if cfg.ElasticURL != "" {
+		return NewElasticWriter(cfg.ElasticURL, cfg.ElasticIndexPrefix, cfg.ElasticILMPolicy)
+	}
+	if cfg.NDJSONPath != "" {
+		return NewNDJSONWriter(cfg.NDJSONPath, cfg.NDJSONTxs)
+	}
+	return NewPGWriterConfig(cfg, utxo)
+}
+
+// Heights kept by the writers which do not read back what they wrote,
+// to work out the height of blocks written with -1, and for
+// HeightAndHashes.
+const recentHeights = 100
+
+type recentBlocks struct {
+	byHeight map[int][]core.Uint256
+	height   map[core.Uint256]int
+}
+
+func newRecentBlocks() *recentBlocks {
+	return &recentBlocks{
+		byHeight: make(map[int][]core.Uint256),
+		height:   make(map[core.Uint256]int),
+	}
+}
+
+func (r *recentBlocks) add(height int, hash core.Uint256) {
+	r.byHeight[height] = append(r.byHeight[height], hash)
+	r.height[hash] = height
+	for h, hashes := range r.byHeight {
+		if h <= height-recentHeights {
+			for _, hash := range hashes {
+				delete(r.height, hash)
+			}
+			delete(r.byHeight, h)
+		}
+	}
+}
+
+func (r *recentBlocks) heightOf(hash core.Uint256) (int, bool) {
+	h, ok := r.height[hash]
+	return h, ok
+}
+
+// last returns the hashes of the last back heights, as
+// getHeightAndHashes.
+func (r *recentBlocks) last(back int) map[int][]core.Uint256 {
+	max := -1
+	for h := range r.byHeight {
+		if h > max {
+			max = h
+		}
+	}
+	result := make(map[int][]core.Uint256)
+	for h, hashes := range r.byHeight {
+		if h > max-back {
+			result[h] = hashes
+		}
+	}
+	return result
+}
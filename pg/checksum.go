@@ -0,0 +1,216 @@
+package pg
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/blkchain/blkchain/core"
+)
+
+// A checksum manifest of the main chain in the database, to compare two
+// independently built databases without comparing the rows. The chain
+// is cut into ranges of heights (aligned to multiples of the range
+// size), and the checksum of a range is the SHA256 of the blocks in it
+// as serialized in the block files (without magic and size), rebuilt
+// from the rows as in blockexport.go. The rolling checksum of a range
+// is the SHA256 of that of the previous range and its own, so the last
+// one covers everything from the first height.
+//
+// With store, the checksums of the ranges below the tip are saved in
+// the block_checksums table, and those already there are not computed
+// again.
+
+const DefaultChecksumRange = 10000
+
+type RangeChecksum struct {
+	From, To int // heights, inclusive
+	Blocks   int
+	Sum      []byte
+	Rolling  []byte
+}
+
+func (c *RangeChecksum) String() string {
+	return fmt.Sprintf("%d\t%d\t%d\t%x\t%x", c.From, c.To, c.Blocks, c.Sum, c.Rolling)
+}
+
+// ChecksumBlocks computes the checksums of the main chain from height
+// from to to (inclusive, negative = the tip) in ranges of size, calling
+// fn with every one.
+func ChecksumBlocks(connstr string, from, to, size int, store bool, fn func(*RangeChecksum) error) error {
+	db, err := sql.Open("postgres", connstr)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	tip, err := mainChainTip(db)
+	if err != nil {
+		return err
+	}
+	if to < 0 || to > tip {
+		to = tip
+	}
+
+	stored := make(map[int]*RangeChecksum)
+	if store {
+		if stored, err = storedChecksums(db); err != nil {
+			return err
+		}
+	}
+
+	var rolling []byte
+	for start := from; start <= to; {
+		end := (start/size+1)*size - 1
+		if end > to {
+			end = to
+		}
+
+		c := stored[start]
+		if c == nil || c.To != end {
+			h := sha256.New()
+			c = &RangeChecksum{From: start, To: end}
+			if c.Blocks, err = exportBlocks(db, start, end, func(b *core.Block, height int) error {
+				if err := core.BinWrite(b.BlockHeader, h); err != nil {
+					return err
+				}
+				return core.BinWrite(&b.Txs, h)
+			}); err != nil {
+				return err
+			}
+			c.Sum = h.Sum(nil)
+			if store && end < tip { // the tip could be reorged
+				if err := storeChecksum(db, c); err != nil {
+					return err
+				}
+			}
+		}
+
+		r := sha256.Sum256(append(append([]byte(nil), rolling...), c.Sum...))
+		rolling = r[:]
+		c.Rolling = rolling
+		if err := fn(c); err != nil {
+			return err
+		}
+		start = end + 1
+	}
+	return nil
+}
+
+func createChecksumTable(db *sql.DB) error {
+	_, err := db.Exec(`
+CREATE TABLE IF NOT EXISTS block_checksums (
+   from_height INT NOT NULL PRIMARY KEY
+  ,to_height   INT NOT NULL
+  ,blocks      INT NOT NULL
+  ,sha256      BYTEA NOT NULL
+  ,computed    TIMESTAMPTZ NOT NULL DEFAULT now()
+)`)
+	return err
+}
+
+func storedChecksums(db *sql.DB) (map[int]*RangeChecksum, error) {
+	if err := createChecksumTable(db); err != nil {
+		return nil, err
+	}
+	rows, err := db.Query("SELECT from_height, to_height, blocks, sha256 FROM block_checksums")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	result := make(map[int]*RangeChecksum)
+	for rows.Next() {
+		c := &RangeChecksum{}
+		if err := rows.Scan(&c.From, &c.To, &c.Blocks, &c.Sum); err != nil {
+			return nil, err
+		}
+		result[c.From] = c
+	}
+	return result, rows.Err()
+}
+
+func storeChecksum(db *sql.DB, c *RangeChecksum) error {
+	_, err := db.Exec(`
+INSERT INTO block_checksums (from_height, to_height, blocks, sha256) VALUES ($1, $2, $3, $4)
+ON CONFLICT (from_height) DO UPDATE
+  SET to_height = EXCLUDED.to_height, blocks = EXCLUDED.blocks, sha256 = EXCLUDED.sha256, computed = now()`,
+		c.From, c.To, c.Blocks, c.Sum)
+	return err
+}
+
+// ReadManifest reads checksums as written by RangeChecksum.String, one
+// per line.
+func ReadManifest(r io.Reader) ([]*RangeChecksum, error) {
+	var result []*RangeChecksum
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		f := strings.Split(line, "\t")
+		if len(f) != 5 {
+			return nil, fmt.Errorf("Line %d: expected 5 fields, got %d", n, len(f))
+		}
+		c := &RangeChecksum{}
+		var err error
+		for i, p := range []*int{&c.From, &c.To, &c.Blocks} {
+			if *p, err = strconv.Atoi(f[i]); err != nil {
+				return nil, fmt.Errorf("Line %d: %v", n, err)
+			}
+		}
+		if c.Sum, err = hex.DecodeString(f[3]); err != nil {
+			return nil, fmt.Errorf("Line %d: %v", n, err)
+		}
+		if c.Rolling, err = hex.DecodeString(f[4]); err != nil {
+			return nil, fmt.Errorf("Line %d: %v", n, err)
+		}
+		result = append(result, c)
+	}
+	return result, s.Err()
+}
+
+// CompareManifests returns the differences between two manifests, by
+// range. Ranges in only one of them are reported, unless beyond the
+// last height of the other (i.e. one database is further along).
+func CompareManifests(a, b []*RangeChecksum) []string {
+	var diffs []string
+	last := func(m []*RangeChecksum) int {
+		if len(m) == 0 {
+			return -1
+		}
+		return m[len(m)-1].To
+	}
+	lastA, lastB := last(a), last(b)
+	byFrom := make(map[int]*RangeChecksum)
+	for _, c := range b {
+		byFrom[c.From] = c
+	}
+	for _, ca := range a {
+		cb := byFrom[ca.From]
+		delete(byFrom, ca.From)
+		switch {
+		case cb == nil:
+			if ca.From <= lastB {
+				diffs = append(diffs, fmt.Sprintf("%d-%d: only in the first", ca.From, ca.To))
+			}
+		case ca.To != cb.To:
+			if ca.To <= lastB && cb.To <= lastA {
+				diffs = append(diffs, fmt.Sprintf("%d: ranges end at %d and %d", ca.From, ca.To, cb.To))
+			}
+		case ca.Blocks != cb.Blocks || string(ca.Sum) != string(cb.Sum):
+			diffs = append(diffs, fmt.Sprintf("%d-%d: %d blocks %x, %d blocks %x", ca.From, ca.To, ca.Blocks, ca.Sum, cb.Blocks, cb.Sum))
+		}
+	}
+	for _, cb := range b {
+		if byFrom[cb.From] != nil && cb.From <= lastA {
+			diffs = append(diffs, fmt.Sprintf("%d-%d: only in the second", cb.From, cb.To))
+		}
+	}
+	return diffs
+}
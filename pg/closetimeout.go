@@ -0,0 +1,61 @@
+package pg
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// CloseWithTimeout is Close giving up after d, for when a writer is
+// stuck, e.g. on a dead connection, so that a supervising process can
+// restart the import. The transactions in progress are cancelled, which
+// rolls them back, what was not flushed is logged and the error says
+// so. The goroutines still stuck are left behind. d must allow for the
+// indexes and constraints after a first import.
+func (p *PGWriter) CloseWithTimeout(d time.Duration) error {
+	close(p.blockCh)
+
+	done := make(chan bool)
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		p.fail.cancel()
+		return p.fail.failed()
+	case <-time.After(d):
+	}
+
+	// Report first, the writers unblocked by the cancel rush through
+	// their channels.
+	unflushed := notFlushed()
+	p.fail.fail(fmt.Errorf("Close timed out after %v", d))
+	p.fail.cancel()
+	for _, u := range unflushed {
+		log.Printf("Not flushed: %s", u)
+	}
+	if len(unflushed) == 0 {
+		return fmt.Errorf("Close timed out after %v.", d)
+	}
+	return fmt.Errorf("Close timed out after %v, transactions rolled back, not flushed: %s", d, strings.Join(unflushed, "; "))
+}
+
+// notFlushed describes the stages of the pipeline which have not
+// finished, by their state, the records queued and when they last
+// committed.
+func notFlushed() []string {
+	ps := ImportPipelineStatus()
+	var result []string
+	for _, st := range ps.Stages {
+		s := fmt.Sprintf("%s: %s since %s, %d queued, %d commits",
+			st.Name, st.State, st.Since.Format(time.RFC3339), st.Queued, st.Commits)
+		if st.Name == "worker" {
+			s += fmt.Sprintf(", up to height %d", ps.Height)
+		}
+		result = append(result, s)
+	}
+	return result
+}
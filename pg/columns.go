@@ -0,0 +1,183 @@
+package pg
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+
+	"github.com/blkchain/blkchain/core"
+	"github.com/lib/pq"
+)
+
+// Column plugins add columns of their own to txs and txouts, e.g. the
+// score of a classifier, whose values are written along with the rows
+// rather than filled in after the import. A ColumnPlugin declares its
+// columns and gives the values of every new transaction and output, on
+// the goroutine parsing the blocks; the writer adds the columns to the
+// tables (if they are not there already) and to the COPY. Plugins are
+// registered by name like the parsers (see parsers.go), a package
+// calling RegisterColumns from init() and a blank import of it in
+// cmd/import, then -columns <name> on the command line. Choose the
+// same plugins every time, also for cmd/reimport (see reimport.go),
+// the rows written without a plugin have NULL in its columns. Not
+// supported with shards.
+
+type ExtraColumn struct {
+	Table string // txs or txouts
+	Name  string
+	Type  string // SQL, e.g. REAL
+}
+
+type ColumnPlugin interface {
+	// Columns returns the columns of the plugin, always the same.
+	Columns() []ExtraColumn
+	// TxValues returns the values of the txs columns of the plugin,
+	// in their order, for a new transaction. It must not block.
+	TxValues(height, n int, txId int64, tx *core.Tx) []interface{}
+	// TxOutValues does the same for output n of the transaction.
+	TxOutValues(height int, txId int64, tx *core.Tx, n int) []interface{}
+}
+
+var (
+	columnsMu      sync.Mutex
+	columnPlugins  = make(map[string]func() ColumnPlugin)
+	builtinColumns = map[string][]string{
+		"txs":    {"id", "txid", "version", "locktime", "size", "base_size", "weight", "virt_size", "n_inputs", "n_outputs"},
+		"txouts": {"tx_id", "n", "value", "scriptpubkey", "spent", "spent_same_block", "script_id", "script_payload"},
+	}
+)
+
+// RegisterColumns makes a column plugin available by name. It panics
+// if the name is already taken.
+func RegisterColumns(name string, newPlugin func() ColumnPlugin) {
+	columnsMu.Lock()
+	defer columnsMu.Unlock()
+	if _, dup := columnPlugins[name]; dup {
+		panic("RegisterColumns called twice for plugin " + name)
+	}
+	columnPlugins[name] = newPlugin
+}
+
+// ColumnPluginNames returns the sorted names of the registered column
+// plugins.
+func ColumnPluginNames() []string {
+	columnsMu.Lock()
+	defer columnsMu.Unlock()
+	names := make([]string, 0, len(columnPlugins))
+	for name := range columnPlugins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// The columns of the plugins chosen, nil if none.
+type extraColumns struct {
+	plugins []ColumnPlugin
+	counts  []map[string]int // of each plugin, by table
+	cols    map[string][]*ExtraColumn
+}
+
+func newExtraColumns(names []string) (*extraColumns, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	columnsMu.Lock()
+	defer columnsMu.Unlock()
+
+	e := &extraColumns{cols: make(map[string][]*ExtraColumn)}
+	taken := make(map[string]bool)
+	for table, cols := range builtinColumns {
+		for _, c := range cols {
+			taken[table+"."+c] = true
+		}
+	}
+	for _, name := range names {
+		newPlugin, ok := columnPlugins[name]
+		if !ok {
+			return nil, fmt.Errorf("Unknown column plugin: %q", name)
+		}
+		p := newPlugin()
+		counts := make(map[string]int)
+		for _, c := range p.Columns() {
+			c := c
+			if c.Table != "txs" && c.Table != "txouts" {
+				return nil, fmt.Errorf("Column plugin %s: invalid table %q, expected txs or txouts.", name, c.Table)
+			}
+			if taken[c.Table+"."+c.Name] {
+				return nil, fmt.Errorf("Column plugin %s: column %s.%s exists already.", name, c.Table, c.Name)
+			}
+			taken[c.Table+"."+c.Name] = true
+			e.cols[c.Table] = append(e.cols[c.Table], &c)
+			counts[c.Table]++
+		}
+		e.plugins = append(e.plugins, p)
+		e.counts = append(e.counts, counts)
+	}
+	return e, nil
+}
+
+// create adds the columns to the tables.
+func (e *extraColumns) create(db *sql.DB) error {
+	if e == nil {
+		return nil
+	}
+	for table, cols := range e.cols {
+		for _, c := range cols {
+			if _, err := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS %s %s",
+				table, pq.QuoteIdentifier(c.Name), c.Type)); err != nil {
+				return fmt.Errorf("Adding column %s.%s: %v", table, c.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// names returns the names of the columns of the table, in the order of
+// the values.
+func (e *extraColumns) names(table string) []string {
+	if e == nil {
+		return nil
+	}
+	var names []string
+	for _, c := range e.cols[table] {
+		names = append(names, c.Name)
+	}
+	return names
+}
+
+func (e *extraColumns) txValues(height, n int, txId int64, tx *core.Tx) []interface{} {
+	if e == nil {
+		return nil
+	}
+	var vals []interface{}
+	for i, p := range e.plugins {
+		vals = appendValues(vals, p, "txs", e.counts[i]["txs"], p.TxValues(height, n, txId, tx))
+	}
+	return vals
+}
+
+func (e *extraColumns) txOutValues(height int, txId int64, tx *core.Tx, n int) []interface{} {
+	if e == nil {
+		return nil
+	}
+	var vals []interface{}
+	for i, p := range e.plugins {
+		vals = appendValues(vals, p, "txouts", e.counts[i]["txouts"], p.TxOutValues(height, txId, tx, n))
+	}
+	return vals
+}
+
+// appendValues appends the values of a plugin, NULLs if it did not
+// return as many as it has columns.
+func appendValues(vals []interface{}, p ColumnPlugin, table string, count int, pv []interface{}) []interface{} {
+	if len(pv) != count {
+		if pv != nil {
+			log.Printf("Column plugin %T returned %d values for %s, expected %d.", p, len(pv), table, count)
+		}
+		pv = make([]interface{}, count)
+	}
+	return append(vals, pv...)
+}
@@ -0,0 +1,243 @@
+package pg
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Connection security and credentials for the import, which can run
+// for days and outlive short-lived credentials:
+//
+//  - TLS client certificates (SSLCert, SSLKey, SSLRootCert, SSLMode)
+//    are passed on to lib/pq as the sslcert etc. parameters.
+//  - With PasswordFile, the password is read from the file (e.g. a
+//    Kubernetes or Docker secret) every time a new connection is made,
+//    so a rotated password is picked up without a restart.
+//  - With AwsIamRegion, an RDS IAM authentication token is generated
+//    for every new connection from the AWS_ACCESS_KEY_ID,
+//    AWS_SECRET_ACCESS_KEY and (optional) AWS_SESSION_TOKEN
+//    environment variables. Tokens are only valid for 15 minutes.
+//    PasswordFile takes precedence.
+//
+// The connect string must contain the host, port (default 5432) and
+// user for IAM authentication.
+
+// Returns the connect string with the TLS parameters of the config.
+func (cfg *WriterConfig) tlsConnstr(connstr string) string {
+	for _, p := range [][2]string{
+		{"sslmode", cfg.SSLMode},
+		{"sslcert", cfg.SSLCert},
+		{"sslkey", cfg.SSLKey},
+		{"sslrootcert", cfg.SSLRootCert},
+	} {
+		if p[1] != "" {
+			connstr += " " + connParam(p[0], p[1])
+		}
+	}
+	return connstr
+}
+
+// openDB opens the database with the TLS settings and credentials of
+// the config.
+func (cfg *WriterConfig) openDB(connstr string) (*sql.DB, error) {
+	if strings.HasPrefix(connstr, "postgres://") || strings.HasPrefix(connstr, "postgresql://") {
+		var err error
+		if connstr, err = pq.ParseURL(connstr); err != nil {
+			return nil, err
+		}
+	}
+	connstr = cfg.tlsConnstr(connstr)
+	connstr, err := ChainConnStr(connstr, cfg.Chain)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.PasswordFile == "" && cfg.AwsIamRegion == "" {
+		return sql.Open("postgres", connstr)
+	}
+	c := &credConnector{connstr: connstr, passwordFile: cfg.PasswordFile, awsRegion: cfg.AwsIamRegion}
+	if _, err := c.dsn(); err != nil { // fail early
+		return nil, err
+	}
+	return sql.OpenDB(c), nil
+}
+
+// A driver.Connector which adds a fresh password to the connect string
+// of every new connection.
+type credConnector struct {
+	connstr      string
+	passwordFile string
+	awsRegion    string
+}
+
+func (c *credConnector) dsn() (string, error) {
+	var password string
+	if c.passwordFile != "" {
+		b, err := os.ReadFile(c.passwordFile)
+		if err != nil {
+			return "", err
+		}
+		password = strings.TrimRight(string(b), "\r\n")
+	} else {
+		params := parseConnstr(c.connstr)
+		host, port, user := params["host"], params["port"], params["user"]
+		if port == "" {
+			port = "5432"
+		}
+		if host == "" || user == "" {
+			return "", fmt.Errorf("IAM authentication requires host and user in the connect string")
+		}
+		token, err := rdsAuthToken(host+":"+port, c.awsRegion, user, time.Now())
+		if err != nil {
+			return "", err
+		}
+		password = token
+	}
+	return c.connstr + " " + connParam("password", password), nil
+}
+
+func (c *credConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	dsn, err := c.dsn()
+	if err != nil {
+		return nil, err
+	}
+	connector, err := pq.NewConnector(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return connector.Connect(ctx)
+}
+
+func (c *credConnector) Driver() driver.Driver {
+	return &pq.Driver{}
+}
+
+// connParam quotes a key=value connect string parameter.
+func connParam(key, value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, `'`, `\'`)
+	return fmt.Sprintf("%s='%s'", key, value)
+}
+
+// parseConnstr parses a key=value connect string, later values win.
+func parseConnstr(connstr string) map[string]string {
+	params := make(map[string]string)
+	s := strings.TrimSpace(connstr)
+	for len(s) > 0 {
+		eq := strings.IndexByte(s, '=')
+		if eq < 0 {
+			break
+		}
+		key := strings.TrimSpace(s[:eq])
+		s = strings.TrimLeft(s[eq+1:], " ")
+		var value strings.Builder
+		if strings.HasPrefix(s, "'") {
+			i := 1
+			for ; i < len(s) && s[i] != '\''; i++ {
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+				}
+				value.WriteByte(s[i])
+			}
+			if i < len(s) {
+				i++ // closing quote
+			}
+			s = s[i:]
+		} else {
+			end := strings.IndexByte(s, ' ')
+			if end < 0 {
+				end = len(s)
+			}
+			value.WriteString(s[:end])
+			s = s[end:]
+		}
+		params[key] = value.String()
+		s = strings.TrimLeft(s, " ")
+	}
+	return params
+}
+
+// rdsAuthToken generates an RDS IAM authentication token, which is a
+// presigned (AWS Signature Version 4) rds-db:connect request.
+func rdsAuthToken(endpoint, region, user string, now time.Time) (string, error) {
+	accessKey, secretKey := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY")
+	if accessKey == "" || secretKey == "" {
+		return "", fmt.Errorf("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set for IAM authentication")
+	}
+
+	now = now.UTC()
+	date, amzDate := now.Format("20060102"), now.Format("20060102T150405Z")
+	scope := date + "/" + region + "/rds-db/aws4_request"
+
+	query := map[string]string{
+		"Action":              "connect",
+		"DBUser":              user,
+		"X-Amz-Algorithm":     "AWS4-HMAC-SHA256",
+		"X-Amz-Credential":    accessKey + "/" + scope,
+		"X-Amz-Date":          amzDate,
+		"X-Amz-Expires":       "900",
+		"X-Amz-SignedHeaders": "host",
+	}
+	if token := os.Getenv("AWS_SESSION_TOKEN"); token != "" {
+		query["X-Amz-Security-Token"] = token
+	}
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = awsEscape(k) + "=" + awsEscape(query[k])
+	}
+	canonicalQuery := strings.Join(parts, "&")
+
+	emptyHash := sha256.Sum256(nil)
+	canonicalRequest := strings.Join([]string{
+		"GET", "/", canonicalQuery, "host:" + endpoint, "", "host", hex.EncodeToString(emptyHash[:]),
+	}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256", amzDate, scope, hex.EncodeToString(requestHash[:]),
+	}, "\n")
+
+	key := []byte("AWS4" + secretKey)
+	for _, s := range []string{date, region, "rds-db", "aws4_request"} {
+		key = hmacSha256(key, s)
+	}
+	signature := hex.EncodeToString(hmacSha256(key, stringToSign))
+
+	return endpoint + "/?" + canonicalQuery + "&X-Amz-Signature=" + signature, nil
+}
+
+func hmacSha256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// awsEscape is URI encoding as SigV4 wants it: everything but the
+// unreserved characters is percent encoded.
+func awsEscape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
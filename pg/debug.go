@@ -0,0 +1,213 @@
+package pg
+
+import (
+	"reflect"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Runtime introspection of the import pipeline, for diagnosing stalls
+// (see serve.NewDebugHandler). The block worker and each writer
+// goroutine register a stage with the channel they read from, and
+// keep their state up to date; the state of a writer copying rows is
+// not tracked per row, it is reported as waiting if its channel is
+// empty.
+
+// States of a stage.
+const (
+	stateRunning    = iota // a writer, copying or waiting
+	stateWaiting           // the worker, for a block
+	stateSending           // the worker, handing records to the writers
+	stateCommitting        // or the worker waiting for the commits
+	stateThrottled         // the worker, pacing itself (see throttle.go)
+)
+
+var stateNames = map[int32]string{
+	stateWaiting:    "waiting",
+	stateSending:    "sending",
+	stateCommitting: "committing",
+	stateThrottled:  "throttled",
+}
+
+type PipelineStatus struct {
+	Height     int            `json:"height"` // last block handed to the writers
+	Goroutines int            `json:"goroutines"`
+	HeapAlloc  uint64         `json:"heap_alloc"`
+	Sys        uint64         `json:"sys"`
+	NumGC      uint32         `json:"num_gc"`
+	Stages     []*StageStatus `json:"stages"`
+	TxIdCache  *CacheStatus   `json:"txid_cache,omitempty"`
+	ScriptDict *CacheStatus   `json:"script_dict,omitempty"`
+	Skip       *SkipStatus    `json:"skip,omitempty"` // during the skip phase
+}
+
+type StageStatus struct {
+	Name     string    `json:"name"` // the table for writers
+	State    string    `json:"state"`
+	Since    time.Time `json:"since,omitempty"` // of the last commit or state change of the worker
+	Queued   int       `json:"queued"`          // in the channel read from
+	Capacity int       `json:"capacity"`
+	Rows     int64     `json:"rows"` // blocks for the worker
+	Commits  int64     `json:"commits"`
+}
+
+// SkipStatus is the progress of the skip phase (see skipprogress.go).
+type SkipStatus struct {
+	Target  string `json:"target"` // the hash looked for
+	Height  int    `json:"height"` // of the last block ignored, -1 if not known
+	Skipped int    `json:"skipped"`
+}
+
+type CacheStatus struct {
+	Size       int `json:"size"`
+	Capacity   int `json:"capacity"`
+	Hits       int `json:"hits"`
+	Misses     int `json:"misses"`
+	Collisions int `json:"collisions,omitempty"`
+	Dupes      int `json:"dupes,omitempty"`
+	Evictions  int `json:"evictions,omitempty"`
+	Dropped    int `json:"dropped,omitempty"`
+}
+
+type stageStatus struct {
+	name    string
+	ch      reflect.Value
+	state   int32 // atomic
+	since   int64 // atomic, unix nanoseconds
+	rows    int64 // atomic
+	commits int64 // atomic
+}
+
+type pipelineRegistry struct {
+	sync.Mutex
+	stages  []*stageStatus
+	height  int
+	idCache *txIdCache
+	scripts *CacheStatus // copied by the worker, the dict is not locked
+	skip    *SkipStatus
+}
+
+var pipeline pipelineRegistry
+
+// stage registers a stage reading from ch, which must be a channel.
+func (p *pipelineRegistry) stage(name string, ch interface{}, state int32) *stageStatus {
+	s := &stageStatus{name: name, ch: reflect.ValueOf(ch), state: state, since: time.Now().UnixNano()}
+	p.Lock()
+	p.stages = append(p.stages, s)
+	p.Unlock()
+	return s
+}
+
+func (p *pipelineRegistry) remove(s *stageStatus) {
+	p.Lock()
+	for i, st := range p.stages {
+		if st == s {
+			p.stages = append(p.stages[:i], p.stages[i+1:]...)
+			break
+		}
+	}
+	p.Unlock()
+}
+
+// block is called by the worker with every block handed on.
+func (p *pipelineRegistry) block(height int, idCache *txIdCache, scripts *scriptDict) {
+	p.Lock()
+	p.height, p.idCache = height, idCache
+	if scripts != nil {
+		p.scripts = &CacheStatus{
+			Size:     len(scripts.cur) + len(scripts.prev),
+			Capacity: scripts.sz,
+			Hits:     scripts.hits,
+			Misses:   scripts.miss,
+		}
+	}
+	p.Unlock()
+}
+
+// skipping is called by the worker with every block ignored, with nil
+// once done.
+func (p *pipelineRegistry) skipping(st *SkipStatus) {
+	p.Lock()
+	p.skip = st
+	p.Unlock()
+}
+
+func (s *stageStatus) set(state int32) {
+	atomic.StoreInt32(&s.state, state)
+	atomic.StoreInt64(&s.since, time.Now().UnixNano())
+}
+
+func (s *stageStatus) row() {
+	atomic.AddInt64(&s.rows, 1)
+}
+
+func (s *stageStatus) committing() {
+	s.set(stateCommitting)
+}
+
+func (s *stageStatus) committed() {
+	atomic.AddInt64(&s.commits, 1)
+	s.set(stateRunning)
+}
+
+func (c *txIdCache) status() *CacheStatus {
+	c.Lock()
+	st := &CacheStatus{
+		Size:       len(c.m),
+		Capacity:   c.sz,
+		Hits:       c.hits,
+		Misses:     c.miss,
+		Collisions: c.cols,
+		Dupes:      c.dups,
+		Evictions:  c.evic,
+		Dropped:    c.drop,
+	}
+	c.Unlock()
+	return st
+}
+
+// ImportPipelineStatus returns the state of the import running in this
+// process, if any, and of the Go runtime.
+func ImportPipelineStatus() *PipelineStatus {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	ps := &PipelineStatus{
+		Goroutines: runtime.NumGoroutine(),
+		HeapAlloc:  m.HeapAlloc,
+		Sys:        m.Sys,
+		NumGC:      m.NumGC,
+		Stages:     []*StageStatus{},
+	}
+
+	pipeline.Lock()
+	stages := append([]*stageStatus(nil), pipeline.stages...)
+	ps.Height, ps.ScriptDict, ps.Skip = pipeline.height, pipeline.scripts, pipeline.skip
+	idCache := pipeline.idCache
+	pipeline.Unlock()
+
+	if idCache != nil {
+		ps.TxIdCache = idCache.status()
+	}
+	for _, s := range stages {
+		st := &StageStatus{
+			Name:     s.name,
+			Since:    time.Unix(0, atomic.LoadInt64(&s.since)),
+			Queued:   s.ch.Len(),
+			Capacity: s.ch.Cap(),
+			Rows:     atomic.LoadInt64(&s.rows),
+			Commits:  atomic.LoadInt64(&s.commits),
+		}
+		state := atomic.LoadInt32(&s.state)
+		if state == stateRunning {
+			if st.State = "copying"; st.Queued == 0 {
+				st.State = "waiting"
+			}
+		} else {
+			st.State = stateNames[state]
+		}
+		ps.Stages = append(ps.Stages, st)
+	}
+	return ps
+}
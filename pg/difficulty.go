@@ -0,0 +1,109 @@
+package pg
+
+import (
+	"database/sql"
+
+	"github.com/blkchain/blkchain/core"
+)
+
+// A difficulty epoch is a retarget period of 2016 blocks, during
+// which nBits (and therefore difficulty) stays the same on mainnet.
+// On testnet a block more than 20 minutes after the previous one may
+// be mined at difficulty 1, and on testnet3 even the first block of an
+// epoch can be, since the retarget starts from the bits of the last
+// block of the previous epoch. The bits of an epoch are therefore
+// those of its first block not at difficulty 1, or of its first block
+// if all are (e.g. the early mainnet epochs). The hash rate is
+// estimated from the average block interval within the epoch.
+
+func createDifficultyEpochsTable(db *sql.DB) error {
+	_, err := db.Exec(`
+  CREATE TABLE IF NOT EXISTS difficulty_epochs (
+   epoch          INT NOT NULL PRIMARY KEY -- height / 2016
+  ,start_height   INT NOT NULL
+  ,end_height     INT NOT NULL
+  ,blocks         INT NOT NULL
+  ,start_time     INT NOT NULL
+  ,end_time       INT NOT NULL
+  ,bits           INT NOT NULL
+  ,difficulty     DOUBLE PRECISION NOT NULL
+  ,block_interval DOUBLE PRECISION -- average seconds between blocks, NULL if unknown
+  ,hashrate       DOUBLE PRECISION -- hashes per second, NULL if unknown
+  );
+`)
+	return err
+}
+
+// UpdateDifficultyEpochs (re)computes difficulty_epochs starting with
+// the last epoch already in the table (which may have been
+// incomplete), or from the beginning if the table is empty. Orphan
+// blocks are excluded, so this should be called after SetOrphans().
+func (w *PGWriter) UpdateDifficultyEpochs() error {
+	if w.db == nil {
+		return nil
+	}
+	return updateDifficultyEpochs(w.db)
+}
+
+func updateDifficultyEpochs(db *sql.DB) error {
+	var fromEpoch int
+	if err := db.QueryRow("SELECT COALESCE(MAX(epoch), 0) FROM difficulty_epochs").Scan(&fromEpoch); err != nil {
+		return err
+	}
+
+	rows, err := db.Query(`
+SELECT height / $1 AS epoch
+      ,MIN(height), MAX(height), COUNT(1)
+      ,MIN(time), MAX(time)
+      ,COALESCE((ARRAY_AGG(bits ORDER BY height) FILTER (WHERE bits <> $3))[1],
+                (ARRAY_AGG(bits ORDER BY height))[1])
+  FROM blocks
+ WHERE NOT orphan
+   AND height >= $2
+ GROUP BY 1
+ ORDER BY 1`, core.RetargetInterval, fromEpoch*core.RetargetInterval, int32(core.DiffOneBits))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	type epochRec struct {
+		epoch, startHeight, endHeight, blocks int
+		startTime, endTime                    int64
+		bits                                  int32
+	}
+	var epochs []*epochRec
+	for rows.Next() {
+		var e epochRec
+		if err := rows.Scan(&e.epoch, &e.startHeight, &e.endHeight, &e.blocks, &e.startTime, &e.endTime, &e.bits); err != nil {
+			return err
+		}
+		epochs = append(epochs, &e)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, e := range epochs {
+		var interval, hashrate *float64
+		if e.blocks > 1 && e.endTime > e.startTime {
+			i := float64(e.endTime-e.startTime) / float64(e.blocks-1)
+			h := core.HashRate(uint32(e.bits), i)
+			interval, hashrate = &i, &h
+		}
+		if _, err := db.Exec(`
+            INSERT INTO difficulty_epochs
+                   (epoch, start_height, end_height, blocks, start_time, end_time, bits, difficulty, block_interval, hashrate)
+            VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+            ON CONFLICT (epoch) DO UPDATE
+               SET start_height = EXCLUDED.start_height, end_height = EXCLUDED.end_height,
+                   blocks = EXCLUDED.blocks, start_time = EXCLUDED.start_time, end_time = EXCLUDED.end_time,
+                   bits = EXCLUDED.bits, difficulty = EXCLUDED.difficulty,
+                   block_interval = EXCLUDED.block_interval, hashrate = EXCLUDED.hashrate`,
+			e.epoch, e.startHeight, e.endHeight, e.blocks, e.startTime, e.endTime, e.bits,
+			core.Difficulty(uint32(e.bits)), interval, hashrate); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,197 @@
+package pg
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Export of a height range to DuckDB, for analysis in Python or R
+// without access to Postgres. The blocks in the range (orphans
+// included), their block_txs, txs, txins and txouts are written as CSV
+// files to a directory, along with duckdb.sql, which loads them into
+// tables of the same names and columns (scripts and hashes as BLOB, in
+// the same byte order as here) when run by the duckdb CLI in that
+// directory (see cmd/exportduckdb). The ids are those of this
+// database, so the tables join the same way.
+
+type duckColumn struct {
+	name string
+	typ  string // DuckDB type
+}
+
+type duckTable struct {
+	name    string
+	columns []duckColumn
+	query   string // of the rows in the range, $1 and $2 the heights
+}
+
+// The txs of the blocks in the range.
+const duckTxIds = `
+SELECT bt.tx_id
+  FROM blocks b
+  JOIN block_txs bt ON bt.block_id = b.id
+ WHERE b.height BETWEEN $1 AND $2`
+
+var duckTables = []*duckTable{
+	{"blocks", []duckColumn{{"id", "INTEGER"}, {"height", "INTEGER"}, {"hash", "BLOB"}, {"version", "INTEGER"},
+		{"prevhash", "BLOB"}, {"prev_block_id", "INTEGER"}, {"merkleroot", "BLOB"}, {"time", "INTEGER"},
+		{"bits", "INTEGER"}, {"nonce", "INTEGER"}, {"orphan", "BOOLEAN"}, {"size", "INTEGER"},
+		{"base_size", "INTEGER"}, {"weight", "INTEGER"}, {"virt_size", "INTEGER"}},
+		"FROM blocks WHERE height BETWEEN $1 AND $2 ORDER BY id"},
+	{"block_txs", []duckColumn{{"block_id", "INTEGER"}, {"n", "SMALLINT"}, {"tx_id", "BIGINT"}},
+		"FROM block_txs WHERE block_id IN (SELECT id FROM blocks WHERE height BETWEEN $1 AND $2) ORDER BY block_id, n"},
+	{"txs", []duckColumn{{"id", "BIGINT"}, {"txid", "BLOB"}, {"version", "INTEGER"}, {"locktime", "INTEGER"},
+		{"size", "INTEGER"}, {"base_size", "INTEGER"}, {"weight", "INTEGER"}, {"virt_size", "INTEGER"},
+		{"n_inputs", "INTEGER"}, {"n_outputs", "INTEGER"}},
+		"FROM txs WHERE id IN (" + duckTxIds + ") ORDER BY id"},
+	{"txins", []duckColumn{{"tx_id", "BIGINT"}, {"n", "SMALLINT"}, {"prevout_tx_id", "BIGINT"}, {"prevout_n", "SMALLINT"},
+		{"scriptsig", "BLOB"}, {"sequence", "INTEGER"}, {"witness", "BLOB"}},
+		"FROM txins WHERE tx_id IN (" + duckTxIds + ") ORDER BY tx_id, n"},
+	{"txouts", []duckColumn{{"tx_id", "BIGINT"}, {"n", "SMALLINT"}, {"value", "BIGINT"}, {"scriptpubkey", "BLOB"},
+		{"spent", "BOOLEAN"}},
+		"FROM txouts_v WHERE tx_id IN (" + duckTxIds + ") ORDER BY tx_id, n"},
+}
+
+// The NULL of the CSV files, as opposed to an empty script.
+const duckNull = `\N`
+
+// ExportDuckDB writes the CSV files and duckdb.sql of the blocks from
+// fromHeight to toHeight (negative = the tip) to dir, returning the
+// number of rows of each table.
+func ExportDuckDB(connstr, dir string, fromHeight, toHeight int) (map[string]int64, error) {
+	db, err := sql.Open("postgres", connstr)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	if toHeight < 0 {
+		if err := db.QueryRow("SELECT COALESCE(MAX(height), -1) FROM blocks WHERE NOT orphan").Scan(&toHeight); err != nil {
+			return nil, err
+		}
+	}
+	return exportDuckDB(db, dir, fromHeight, toHeight)
+}
+
+func exportDuckDB(db *sql.DB, dir string, fromHeight, toHeight int) (map[string]int64, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]int64)
+	for _, t := range duckTables {
+		n, err := exportDuckTable(db, dir, t, fromHeight, toHeight)
+		if err != nil {
+			return nil, fmt.Errorf("Exporting %s: %v", t.name, err)
+		}
+		counts[t.name] = n
+	}
+	return counts, os.WriteFile(filepath.Join(dir, "duckdb.sql"), []byte(duckScript()), 0644)
+}
+
+func exportDuckTable(db *sql.DB, dir string, t *duckTable, fromHeight, toHeight int) (int64, error) {
+	names := make([]string, len(t.columns))
+	for i, c := range t.columns {
+		names[i] = c.name
+	}
+	rows, err := db.Query("SELECT "+strings.Join(names, ", ")+" "+t.query, fromHeight, toHeight)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	f, err := os.Create(filepath.Join(dir, t.name+".csv"))
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	bw := bufio.NewWriterSize(f, 1<<20)
+	cw := csv.NewWriter(bw)
+	if err := cw.Write(names); err != nil {
+		return 0, err
+	}
+
+	vals := make([]interface{}, len(t.columns))
+	ptrs := make([]interface{}, len(t.columns))
+	for i := range vals {
+		ptrs[i] = &vals[i]
+	}
+	record := make([]string, len(t.columns))
+	var n int64
+	for rows.Next() {
+		if err := rows.Scan(ptrs...); err != nil {
+			return 0, err
+		}
+		for i, v := range vals {
+			switch v := v.(type) {
+			case nil:
+				record[i] = duckNull
+			case []byte:
+				if t.columns[i].typ == "BLOB" {
+					record[i] = hex.EncodeToString(v)
+				} else {
+					record[i] = string(v)
+				}
+			case int64:
+				record[i] = strconv.FormatInt(v, 10)
+			case bool:
+				record[i] = strconv.FormatBool(v)
+			default:
+				record[i] = fmt.Sprint(v)
+			}
+		}
+		if err := cw.Write(record); err != nil {
+			return 0, err
+		}
+		n++
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return 0, err
+	}
+	if err := bw.Flush(); err != nil {
+		return 0, err
+	}
+	return n, f.Close()
+}
+
+// duckScript returns the DuckDB SQL loading the CSV files.
+func duckScript() string {
+	var b strings.Builder
+	for _, t := range duckTables {
+		defs := make([]string, len(t.columns))
+		for i, c := range t.columns {
+			defs[i] = c.name + " " + c.typ
+		}
+		fmt.Fprintf(&b, "CREATE TABLE %s (%s);\n", t.name, strings.Join(defs, ", "))
+		fmt.Fprintf(&b, "INSERT INTO %s %s;\n\n", t.name, duckRead(t))
+	}
+	return b.String()
+}
+
+// duckRead returns the DuckDB SELECT of the rows of the CSV file of
+// the table, the BLOBs being read as hex.
+func duckRead(t *duckTable) string {
+	var types, sel []string
+	for _, c := range t.columns {
+		if c.typ == "BLOB" {
+			types = append(types, fmt.Sprintf("'%s': 'VARCHAR'", c.name))
+			sel = append(sel, fmt.Sprintf("unhex(%s) AS %s", c.name, c.name))
+		} else {
+			types = append(types, fmt.Sprintf("'%s': '%s'", c.name, c.typ))
+			sel = append(sel, c.name)
+		}
+	}
+	return fmt.Sprintf("SELECT %s\n  FROM read_csv('%s.csv', header = true, nullstr = '%s', columns = {%s})",
+		strings.Join(sel, ", "), t.name, duckNull, strings.Join(types, ", "))
+}
@@ -0,0 +1,55 @@
+package pg
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+
+	"github.com/blkchain/blkchain/core"
+)
+
+// Feeding the same blocks twice, e.g. by restarting an import with an
+// earlier -start-height, or a node sending a block again, must not
+// create a second row for a block. The unique index on blocks(hash)
+// guarantees that, but the first import only creates it at the very
+// end, so when catching up it is created right away if missing (an
+// interrupted first import), and the block worker ignores the blocks
+// it has seen already rather than have the COPY fail on the index.
+// Without the index (during the first import) only the recent blocks
+// are looked at, the blocks then come in height order.
+
+func createBlocksHashIndex(db *sql.DB) error {
+	var exists bool
+	if err := db.QueryRow(`
+SELECT EXISTS (SELECT 1 FROM pg_indexes
+                WHERE schemaname = current_schema() AND indexname = 'blocks_hash_idx')`).Scan(&exists); err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+	log.Printf("Creating the blocks hash index, left out by an interrupted first import...")
+	if _, err := db.Exec("CREATE UNIQUE INDEX blocks_hash_idx ON blocks(hash)"); err != nil {
+		var dups int
+		if qerr := db.QueryRow(`
+SELECT COUNT(1) FROM (SELECT hash FROM blocks GROUP BY hash HAVING COUNT(1) > 1) d`).Scan(&dups); qerr == nil && dups > 0 {
+			return fmt.Errorf("There are %d blocks imported more than once, which need to be deleted (with their txs) first: %v", dups, err)
+		}
+		return err
+	}
+	return nil
+}
+
+// imported tells whether the block with the hash was imported already,
+// looking in the database too if lookup and not recent.
+func (c *blockIdCache) imported(db *sql.DB, hash core.Uint256, lookup bool) (bool, error) {
+	if _, ok := c.blocks[hash]; ok {
+		return true, nil
+	}
+	if !lookup || db == nil {
+		return false, nil
+	}
+	var exists bool
+	err := db.QueryRow("SELECT EXISTS (SELECT 1 FROM blocks WHERE hash = $1)", hash[:]).Scan(&exists)
+	return exists, err
+}
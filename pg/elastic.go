@@ -0,0 +1,380 @@
+package pg
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/blkchain/blkchain/core"
+)
+
+// Elasticsearch (or OpenSearch) instead of Postgres, for explorers
+// built around search. The blocks and the txs go into two indexes, as
+// the objects of the NDJSON output (see ndjson.go), with the inputs
+// and outputs of a tx as nested documents, so that e.g. an output can
+// be searched by script and value together. The document ids are the
+// block hashes and txids.
+//
+// Documents are sent with the bulk API in batches of about
+// elasticBulkSize, and at every sync. The indexes are created if they
+// do not exist, with a long refresh interval and, if given, a
+// lifecycle policy (ILM in Elasticsearch, ISM in OpenSearch), which
+// must exist. Credentials can be given in the URL.
+
+const (
+	elasticBulkSize        = 8 << 20
+	elasticRefreshInterval = "30s"
+	elasticNestedLimit     = 100_000 // inputs or outputs of a tx
+)
+
+type ElasticWriter struct {
+	url    string
+	blocks string // index names
+	txs    string
+	client *http.Client
+	buf    bytes.Buffer
+	start  time.Time
+	recent *recentBlocks
+}
+
+const elasticBlocksMapping = `{
+  "properties": {
+    "type":       {"type": "keyword"},
+    "height":     {"type": "long"},
+    "hash":       {"type": "keyword"},
+    "version":    {"type": "integer"},
+    "prevhash":   {"type": "keyword"},
+    "merkleroot": {"type": "keyword"},
+    "time":       {"type": "date", "format": "epoch_second"},
+    "bits":       {"type": "long"},
+    "nonce":      {"type": "long"},
+    "size":       {"type": "integer"},
+    "base_size":  {"type": "integer"},
+    "weight":     {"type": "integer"},
+    "virt_size":  {"type": "integer"},
+    "n_tx":       {"type": "integer"}
+  }
+}`
+
+// Scripts longer than ignore_above are stored, not indexed.
+const elasticTxsMapping = `{
+  "properties": {
+    "type":       {"type": "keyword"},
+    "height":     {"type": "long"},
+    "block_hash": {"type": "keyword"},
+    "n":          {"type": "integer"},
+    "txid":       {"type": "keyword"},
+    "version":    {"type": "integer"},
+    "locktime":   {"type": "long"},
+    "size":       {"type": "integer"},
+    "base_size":  {"type": "integer"},
+    "weight":     {"type": "integer"},
+    "virt_size":  {"type": "integer"},
+    "inputs": {
+      "type": "nested",
+      "properties": {
+        "n":            {"type": "integer"},
+        "prevout_hash": {"type": "keyword"},
+        "prevout_n":    {"type": "long"},
+        "scriptsig":    {"type": "keyword", "ignore_above": 1024},
+        "sequence":     {"type": "long"},
+        "witness":      {"type": "keyword", "index": false, "doc_values": false}
+      }
+    },
+    "outputs": {
+      "type": "nested",
+      "properties": {
+        "n":            {"type": "integer"},
+        "value":        {"type": "long"},
+        "scriptpubkey": {"type": "keyword", "ignore_above": 1024}
+      }
+    }
+  }
+}`
+
+// NewElasticWriter returns a writer to the Elasticsearch or OpenSearch
+// at url, creating the indexes if needed (see above).
+func NewElasticWriter(url, prefix, policy string) (*ElasticWriter, error) {
+	if prefix == "" {
+		prefix = "blkchain"
+	}
+	w := &ElasticWriter{
+		url:    strings.TrimRight(url, "/"),
+		blocks: prefix + "-blocks",
+		txs:    prefix + "-txs",
+		client: &http.Client{Timeout: 5 * time.Minute},
+		start:  time.Now(),
+		recent: newRecentBlocks(),
+	}
+
+	var info struct {
+		Version struct {
+			Number       string `json:"number"`
+			Distribution string `json:"distribution"`
+		} `json:"version"`
+	}
+	if err := w.do("GET", "/", nil, &info); err != nil {
+		return nil, err
+	}
+	openSearch := info.Version.Distribution == "opensearch"
+	log.Printf("Connected to %s %s.", map[bool]string{false: "Elasticsearch", true: "OpenSearch"}[openSearch], info.Version.Number)
+
+	for _, idx := range []struct{ name, mapping string }{
+		{w.blocks, elasticBlocksMapping},
+		{w.txs, elasticTxsMapping},
+	} {
+		if err := w.createIndex(idx.name, idx.mapping, policy, openSearch); err != nil {
+			return nil, err
+		}
+	}
+	return w, nil
+}
+
+func (w *ElasticWriter) createIndex(name, mapping, policy string, openSearch bool) error {
+	exists, err := w.exists("/" + name)
+	if err != nil || exists {
+		return err
+	}
+	settings := map[string]interface{}{
+		"index.refresh_interval":             elasticRefreshInterval,
+		"index.mapping.nested_objects.limit": elasticNestedLimit,
+	}
+	if policy != "" {
+		if openSearch {
+			settings["plugins.index_state_management.policy_id"] = policy
+		} else {
+			settings["index.lifecycle.name"] = policy
+		}
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"settings": settings,
+		"mappings": json.RawMessage(mapping),
+	})
+	if err != nil {
+		return err
+	}
+	log.Printf("Creating index %s...", name)
+	return w.do("PUT", "/"+name, body, nil)
+}
+
+// WriteBlock adds the block and its txs to the bulk request, which is
+// sent if sync or big enough.
+func (w *ElasticWriter) WriteBlock(b *BlockRec, sync bool) error {
+	height := b.Height
+	if height < 0 {
+		var err error
+		if height, err = w.heightOf(b.PrevHash); err != nil {
+			return err
+		}
+		if height >= 0 {
+			height++
+		}
+	}
+
+	rec, txs := newNDJSONBlock(b.Block, height)
+	if err := w.add(w.blocks, rec.Hash.String(), rec); err != nil {
+		return err
+	}
+	for _, tx := range txs {
+		tx.Type, tx.Height, tx.BlockHash = "tx", rec.Height, &rec.Hash
+		if err := w.add(w.txs, tx.TxId.String(), tx); err != nil {
+			return err
+		}
+	}
+
+	if height >= 0 {
+		w.recent.add(height, rec.Hash)
+	}
+	if sync || w.buf.Len() >= elasticBulkSize {
+		return w.flush()
+	}
+	return nil
+}
+
+func (w *ElasticWriter) add(index, id string, doc interface{}) error {
+	action := map[string]interface{}{"index": map[string]string{"_index": index, "_id": id}}
+	enc := json.NewEncoder(&w.buf)
+	if err := enc.Encode(action); err != nil {
+		return err
+	}
+	return enc.Encode(doc)
+}
+
+// flush sends the bulk request, if any.
+func (w *ElasticWriter) flush() error {
+	if w.buf.Len() == 0 {
+		return nil
+	}
+	var resp struct {
+		Errors bool `json:"errors"`
+		Items  []map[string]struct {
+			Error interface{} `json:"error"`
+		} `json:"items"`
+	}
+	err := w.do("POST", "/_bulk", w.buf.Bytes(), &resp)
+	w.buf.Reset()
+	if err != nil {
+		return err
+	}
+	if resp.Errors {
+		cnt, first := 0, interface{}(nil)
+		for _, item := range resp.Items {
+			for _, res := range item {
+				if res.Error != nil {
+					if cnt == 0 {
+						first = res.Error
+					}
+					cnt++
+				}
+			}
+		}
+		return fmt.Errorf("Bulk request: %d documents failed, first error: %v", cnt, first)
+	}
+	return nil
+}
+
+// heightOf returns the height of the block, -1 if unknown.
+func (w *ElasticWriter) heightOf(hash core.Uint256) (int, error) {
+	if h, ok := w.recent.heightOf(hash); ok {
+		return h, nil
+	}
+	var doc struct {
+		Source struct {
+			Height *int `json:"height"`
+		} `json:"_source"`
+	}
+	found, err := w.get(fmt.Sprintf("/%s/_doc/%s", w.blocks, hash), &doc)
+	if err != nil || !found || doc.Source.Height == nil {
+		return -1, err
+	}
+	return *doc.Source.Height, nil
+}
+
+// HeightAndHashes returns the blocks of the last back heights in the
+// index, as the PGWriter.
+func (w *ElasticWriter) HeightAndHashes(back int) (map[int][]core.Uint256, error) {
+	if err := w.flush(); err != nil {
+		return nil, err
+	}
+	if err := w.do("POST", "/"+w.blocks+"/_refresh", nil, nil); err != nil {
+		return nil, err
+	}
+
+	var max struct {
+		Aggregations struct {
+			Max struct {
+				Value *float64 `json:"value"`
+			} `json:"max"`
+		} `json:"aggregations"`
+	}
+	q := `{"size": 0, "aggs": {"max": {"max": {"field": "height"}}}}`
+	if err := w.do("POST", "/"+w.blocks+"/_search", []byte(q), &max); err != nil {
+		return nil, err
+	}
+	if max.Aggregations.Max.Value == nil {
+		return nil, nil // empty
+	}
+
+	var res struct {
+		Hits struct {
+			Hits []struct {
+				Source struct {
+					Height int    `json:"height"`
+					Hash   string `json:"hash"`
+				} `json:"_source"`
+			} `json:"hits"`
+		} `json:"hits"`
+	}
+	q = fmt.Sprintf(`{"size": 10000, "_source": ["height", "hash"], "query": {"range": {"height": {"gt": %d}}}}`,
+		int(*max.Aggregations.Max.Value)-back)
+	if err := w.do("POST", "/"+w.blocks+"/_search", []byte(q), &res); err != nil {
+		return nil, err
+	}
+	hashes := make(map[int][]core.Uint256)
+	for _, hit := range res.Hits.Hits {
+		hash, err := core.Uint256FromString(hit.Source.Hash)
+		if err != nil {
+			return nil, err
+		}
+		hashes[hit.Source.Height] = append(hashes[hit.Source.Height], hash)
+	}
+	return hashes, nil
+}
+
+func (w *ElasticWriter) Ping() error {
+	return w.do("GET", "/", nil, nil)
+}
+
+func (w *ElasticWriter) HasBlock(hash core.Uint256) (bool, error) {
+	if _, ok := w.recent.heightOf(hash); ok {
+		return true, nil
+	}
+	return w.exists(fmt.Sprintf("/%s/_doc/%s", w.blocks, hash))
+}
+
+func (w *ElasticWriter) Close() error {
+	if err := w.flush(); err != nil {
+		return fmt.Errorf("Error writing to Elasticsearch: %v", err)
+	}
+	return nil
+}
+
+func (w *ElasticWriter) Uptime() time.Duration {
+	return time.Now().Sub(w.start)
+}
+
+// do sends a request, decoding the response into result if not nil.
+func (w *ElasticWriter) do(method, path string, body []byte, result interface{}) error {
+	req, err := http.NewRequest(method, w.url+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if strings.HasSuffix(path, "/_bulk") {
+		req.Header.Set("Content-Type", "application/x-ndjson")
+	} else if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, msg)
+	}
+	if result == nil {
+		_, err = io.Copy(io.Discard, resp.Body)
+		return err
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+// get is do for a GET of a document, which may not exist.
+func (w *ElasticWriter) get(path string, result interface{}) (bool, error) {
+	exists, err := w.exists(path)
+	if err != nil || !exists {
+		return false, err
+	}
+	return true, w.do("GET", path, nil, result)
+}
+
+func (w *ElasticWriter) exists(path string) (bool, error) {
+	resp, err := w.client.Head(w.url + path)
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	}
+	return false, fmt.Errorf("HEAD %s: %s", path, resp.Status)
+}
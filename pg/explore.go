@@ -1,32 +1,74 @@
-package db
+package pg
 
 import (
+	"database/sql"
 	"fmt"
+	"time"
 
-	"github.com/blkchain/blkchain"
+	"github.com/blkchain/blkchain/core"
 	"github.com/jmoiron/sqlx"
 )
 
 type Config struct {
 	ConnectString string
+	Chain         string // a chain other than mainnet (see chains.go)
+	// Read replicas (see replicas.go), reads go to the primary
+	// (ConnectString) only when none of these are available.
+	ReplicaConnectStrings []string
+	MaxReplicaLag         time.Duration // 0 = do not check
+	HealthCheckInterval   time.Duration // 0 = DefaultHealthCheckInterval
+	// Keep a bloom filter of the txids at this many bits per txid
+	// (see txidfilter.go) to answer lookups of unknown txids without
+	// a query, 0 = none.
+	TxidFilterBits int
 }
 
 type Explorer struct {
-	db *sqlx.DB
+	db       *sqlx.DB
+	connstr  string
+	replicas *replicaSet // nil = no replicas
+	txids    *txidFilter // nil = none
 }
 
 func NewExplorer(cfg Config) (*Explorer, error) {
-	if conn, err := sqlx.Connect("postgres", cfg.ConnectString); err != nil {
+	connstr, err := ChainConnStr(cfg.ConnectString, cfg.Chain)
+	if err != nil {
+		return nil, err
+	}
+	replicas := make([]string, len(cfg.ReplicaConnectStrings))
+	for i, r := range cfg.ReplicaConnectStrings {
+		replicas[i], _ = ChainConnStr(r, cfg.Chain) // the chain is valid by now
+	}
+	if conn, err := sqlx.Connect("postgres", connstr); err != nil {
 		return nil, err
 	} else {
-		e := &Explorer{db: conn}
+		e := &Explorer{db: conn, connstr: connstr}
 		if err := e.db.Ping(); err != nil {
 			return nil, err
 		}
+		if len(replicas) > 0 {
+			if e.replicas, err = newReplicaSet(replicas, cfg.MaxReplicaLag, cfg.HealthCheckInterval); err != nil {
+				e.db.Close()
+				return nil, err
+			}
+		}
+		if cfg.TxidFilterBits > 0 {
+			if e.txids, err = loadTxidFilter(e.db.DB, cfg.TxidFilterBits); err != nil {
+				e.Close()
+				return nil, err
+			}
+		}
 		return e, nil
 	}
 }
 
+func (e *Explorer) Close() error {
+	if e.replicas != nil {
+		e.replicas.close()
+	}
+	return e.db.Close()
+}
+
 func (e *Explorer) SelectBlocksJson(height, limit int) ([]string, error) {
 	stmt := "SELECT to_json(b.*) AS block " +
 		"FROM (SELECT height, hash, version, prevhash, merkleroot, time, bits, nonce, orphan " +
@@ -35,7 +77,7 @@ func (e *Explorer) SelectBlocksJson(height, limit int) ([]string, error) {
 		"ORDER BY height DESC LIMIT $2 ) b"
 
 	var blocks []string
-	if err := e.db.Select(&blocks, stmt, height, limit); err != nil {
+	if err := e.readSelect(&blocks, stmt, height, limit); err != nil {
 		return nil, err
 	}
 
@@ -47,14 +89,14 @@ func (e *Explorer) SelectMaxHeight() (int, error) {
 	stmt := "SELECT MAX(height) AS height FROM blocks"
 
 	var height int
-	if err := e.db.Get(&height, stmt); err != nil {
+	if err := e.readGet(&height, stmt); err != nil {
 		return 0, err
 	}
 
 	return height, nil
 }
 
-func (e *Explorer) SelectBlockByHashJson(hash blkchain.Uint256) (*string, error) {
+func (e *Explorer) SelectBlockByHashJson(hash core.Uint256) (*string, error) {
 	stmt := "SELECT to_json(b.*) AS block FROM ( " +
 		"SELECT height, hash, version, prevhash, merkleroot, time, bits, nonce, orphan " +
 		"FROM blocks " +
@@ -62,14 +104,14 @@ func (e *Explorer) SelectBlockByHashJson(hash blkchain.Uint256) (*string, error)
 		") b"
 
 	var block string
-	if err := e.db.Get(&block, stmt, hash[:]); err != nil {
+	if err := e.readGet(&block, stmt, hash[:]); err != nil {
 		return nil, err
 	}
 
 	return &block, nil
 }
 
-func (e *Explorer) SelectTxsJson(blockHash blkchain.Uint256, startN, limit int) ([]string, error) {
+func (e *Explorer) SelectTxsJson(blockHash core.Uint256, startN, limit int) ([]string, error) {
 	// NB: unlike with SelectTxByHashJson, we do not support negative
 	// limit here. This is because the block url structure in order to
 	// be cacheable is such that there is only one way to denote a
@@ -87,14 +129,14 @@ func (e *Explorer) SelectTxsJson(blockHash blkchain.Uint256, startN, limit int)
   ) t`
 
 	var txs []string
-	if err := e.db.Select(&txs, stmt, blockHash[:], startN, limit); err != nil {
+	if err := e.readSelect(&txs, stmt, blockHash[:], startN, limit); err != nil {
 		return nil, err
 	}
 
 	return txs, nil
 }
 
-func (e *Explorer) SelectTxByHashJson(hash blkchain.Uint256) (*string, error) {
+func (e *Explorer) SelectTxByHashJson(hash core.Uint256) (*string, error) {
 	// This statement uses a bit of cleverness to hide the internal
 	// db ids, not sure if it was necessary.
 	stmt := `
@@ -119,7 +161,7 @@ SELECT txid
     SELECT ARRAY_AGG(o.*  ORDER BY n) AS outs
       FROM (
         SELECT n, value, scriptpubkey, spent
-          FROM txouts
+          FROM txouts_v
          WHERE tx_id = t.id
       ) o
   ) o ON true
@@ -132,19 +174,25 @@ SELECT txid
 WHERE t.txid = $1
 ) t;
 `
+	if known, err := e.txids.knows(e.db.DB, hash); err != nil {
+		return nil, err
+	} else if !known {
+		return nil, sql.ErrNoRows
+	}
+
 	var tx string
-	if err := e.db.Get(&tx, stmt, hash[:]); err != nil {
+	if err := e.readGet(&tx, stmt, hash[:]); err != nil {
 		return nil, err
 	}
 
 	return &tx, nil
 }
 
-func (e *Explorer) SelectHashType(hash blkchain.Uint256) (*string, error) {
+func (e *Explorer) SelectHashType(hash core.Uint256) (*string, error) {
 	stmt := "SELECT hash_type($1)"
 
 	var typ *string
-	if err := e.db.Get(&typ, stmt, hash[:]); err != nil {
+	if err := e.readGet(&typ, stmt, hash[:]); err != nil {
 		return nil, err
 	}
 
@@ -207,7 +255,7 @@ JOIN LATERAL (
     SELECT ARRAY_AGG(o.*  ORDER BY n) AS outs
       FROM (
         SELECT n, value, scriptpubkey, spent
-          FROM txouts
+          FROM txouts_v
          WHERE tx_id = t.id
       ) o
   ) o ON true
@@ -223,7 +271,7 @@ JOIN LATERAL (
 ) txs;
 `, operator, order)
 	var txs []string
-	if err := e.db.Select(&txs, stmt, addr, startTxId, limit); err != nil {
+	if err := e.readSelect(&txs, stmt, addr, startTxId, limit); err != nil {
 		return nil, err
 	}
 
@@ -258,7 +306,7 @@ SELECT SUM(recv) AS recv, COUNT(1) AS cnt FROM (
 		Cnt  int
 	}
 	var recv recvCnt
-	if err := e.db.Get(&recv, stmt, addr, limit+1); err != nil {
+	if err := e.readGet(&recv, stmt, addr, limit+1); err != nil {
 		return 0, err
 	}
 
@@ -268,3 +316,109 @@ SELECT SUM(recv) AS recv, COUNT(1) AS cnt FROM (
 
 	return recv.Recv, nil
 }
+
+func (e *Explorer) SelectAddrLabelsJson(addr []byte) ([]string, error) {
+	stmt := "SELECT to_json(l.*) FROM ( " +
+		"SELECT address, label, entity, category, source " +
+		"FROM address_labels " +
+		"WHERE addr = $1 " +
+		"ORDER BY label ) l"
+
+	var labels []string
+	if err := e.readSelect(&labels, stmt, addr); err != nil {
+		return nil, err
+	}
+
+	return labels, nil
+}
+
+// AddAddressLabel tags a single address, see ImportAddressLabels for
+// loading many from a file.
+func (e *Explorer) AddAddressLabel(address, label, entity, category, source string) error {
+	addr, err := AddressKey(address)
+	if err != nil {
+		return err
+	}
+	if err := createAddressLabelsTable(e.db.DB); err != nil {
+		return err
+	}
+	stmt := "INSERT INTO address_labels (addr, address, label, entity, category, source) " +
+		"VALUES ($1, $2, $3, NULLIF($4, ''), NULLIF($5, ''), $6)"
+	_, err = e.db.Exec(stmt, addr, address, label, entity, category, source)
+	return err
+}
+
+func (e *Explorer) SelectTxLabels(txId int64) ([]string, error) {
+	stmt := "SELECT DISTINCT label FROM tx_labels WHERE tx_id = $1 ORDER BY label"
+
+	var labels []string
+	if err := e.readSelect(&labels, stmt, txId); err != nil {
+		return nil, err
+	}
+
+	return labels, nil
+}
+
+func (e *Explorer) SelectLabeledFlowsJson(entity string, startTxId int, limit int) ([]string, error) {
+	// Funds received by (outputs to) and sent from (inputs spending)
+	// the addresses of an entity, or of a label if no addresses have
+	// that entity. Pagination is by tx_id, the same as with
+	// SelectTxsByAddrJson. The per address subqueries each use the
+	// address prefix indexes, so entities with thousands of addresses
+	// are going to be slow.
+
+	operator, order := "<", "DESC"
+	if limit < 0 {
+		operator, limit, order = ">", -limit, "ASC"
+	}
+	stmt := fmt.Sprintf(`
+WITH l AS (
+  SELECT DISTINCT ON (addr) addr, address, label, COALESCE(entity, label) AS entity
+    FROM address_labels
+   WHERE entity = $1
+      OR (label = $1 AND NOT EXISTS (SELECT 1 FROM address_labels WHERE entity = $1))
+)
+SELECT to_json(f.*) FROM (
+  SELECT f.tx_id, t.txid, f.flow, f.n, f.address, f.label, f.entity, f.value FROM (
+    ( SELECT o.tx_id, 'received' AS flow, o.n, l.address, l.label, l.entity, o.value
+        FROM l
+        JOIN LATERAL (
+          SELECT tx_id, n, value
+            FROM txouts o
+           WHERE addr_prefix(scriptpubkey) = bytes2int8(l.addr)
+             AND extract_address(scriptpubkey) = l.addr
+             AND o.tx_id %[1]s $2
+           ORDER BY tx_id %[2]s
+          LIMIT $3
+        ) o ON true
+    )
+    UNION ALL
+    ( SELECT i.tx_id, 'sent' AS flow, i.n, l.address, l.label, l.entity, po.value
+        FROM l
+        JOIN LATERAL (
+          SELECT tx_id, n, prevout_tx_id, prevout_n
+            FROM txins i
+           WHERE addr_prefix(scriptsig, witness) = bytes2int8(l.addr)
+             AND prevout_tx_id IS NOT NULL
+             AND extract_address(scriptsig, witness) = l.addr
+             AND i.tx_id %[1]s $2
+           ORDER BY tx_id %[2]s
+          LIMIT $3
+        ) i ON true
+        JOIN txouts po ON po.tx_id = i.prevout_tx_id AND po.n = i.prevout_n
+    )
+  ORDER BY tx_id %[2]s, flow, n
+  LIMIT $3
+  ) f
+  JOIN txs t ON t.id = f.tx_id
+) f
+ORDER BY tx_id DESC, flow, n
+`, operator, order)
+
+	var flows []string
+	if err := e.readSelect(&flows, stmt, entity, startTxId, limit); err != nil {
+		return nil, err
+	}
+
+	return flows, nil
+}
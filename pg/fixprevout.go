@@ -0,0 +1,132 @@
+package pg
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The prevout_tx_id misses of the first import are fixed at the end
+// (see fixPrevoutTxId). As one UPDATE joining txins to txs that can
+// run for a day on mainnet with nothing to show for it, and, with
+// autovacuum off for the import, leaves txins twice the size. Instead
+// the misses are split into ranges of tx_id, updated each in its own
+// transaction by a few workers in parallel, with the progress logged.
+// With WriterConfig.Vacuum, txins is vacuumed every 25% so that the
+// space of the old row versions is reused by the next batches rather
+// than added to, skipping the indexes, which would otherwise be read
+// in full each time. The full VACUUM is once at the end (see
+// analyzeTables).
+
+const (
+	DefaultFixPrevoutWorkers = 4
+	fixPrevoutBatch          = 1_000_000 // tx ids
+	fixPrevoutVacuums        = 4         // per fix, the last is analyzeTables
+)
+
+func fixPrevoutTxIdBatched(db *sql.DB, workers int, vacuum bool) error {
+	if workers <= 0 {
+		workers = DefaultFixPrevoutWorkers
+	}
+
+	var minId, maxId sql.NullInt64
+	if err := db.QueryRow("SELECT MIN(tx_id), MAX(tx_id) FROM _prevout_miss").Scan(&minId, &maxId); err != nil {
+		return err
+	}
+	if !minId.Valid {
+		return nil
+	}
+	if _, err := db.Exec("CREATE INDEX IF NOT EXISTS _prevout_miss_tx_id_idx ON _prevout_miss(tx_id)"); err != nil {
+		return err
+	}
+
+	nBatches := (maxId.Int64-minId.Int64)/fixPrevoutBatch + 1
+	batches := make(chan int64, workers)
+	go func() {
+		for lo := minId.Int64; lo <= maxId.Int64; lo += fixPrevoutBatch {
+			batches <- lo
+		}
+		close(batches)
+	}()
+
+	var (
+		wg        sync.WaitGroup
+		done      int64 // batches
+		rows      int64 // updated
+		errOnce   sync.Once
+		failed    error
+		stop      = make(chan struct{})
+		start     = time.Now()
+		finishing = make(chan struct{})
+	)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for lo := range batches {
+				select {
+				case <-stop:
+					continue // drain
+				default:
+				}
+				res, err := db.Exec(`
+UPDATE txins i
+   SET prevout_tx_id = t.id
+  FROM _prevout_miss m
+  JOIN txs t ON m.prevout_hash = t.txid
+ WHERE m.tx_id >= $1 AND m.tx_id < $2
+   AND i.tx_id = m.tx_id
+   AND i.n = m.n`, lo, lo+fixPrevoutBatch)
+				if err != nil {
+					errOnce.Do(func() {
+						failed = fmt.Errorf("Fixing prevout_tx_id of tx ids %d to %d: %v", lo, lo+fixPrevoutBatch-1, err)
+						close(stop)
+					})
+					continue
+				}
+				n, _ := res.RowsAffected()
+				atomic.AddInt64(&rows, n)
+				atomic.AddInt64(&done, 1)
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(finishing)
+	}()
+
+	// Progress and vacuuming
+	lastVacuum := int64(0)
+	tick := time.NewTicker(30 * time.Second)
+	defer tick.Stop()
+	for {
+		select {
+		case <-finishing:
+			if failed != nil {
+				return failed
+			}
+			log.Printf("  fixed prevout_tx_id of %d txins in %s.", atomic.LoadInt64(&rows), time.Now().Sub(start).Round(time.Second))
+			return clearPrevoutMissTable(db)
+		case <-tick.C:
+			d := atomic.LoadInt64(&done)
+			pct := float64(d) / float64(nBatches) * 100
+			var eta time.Duration
+			if d > 0 {
+				eta = time.Duration(float64(time.Now().Sub(start)) / float64(d) * float64(nBatches-d))
+			}
+			log.Printf("  - prevout_tx_id: %d of %d batches (%.1f%%), %d txins, ETA %s", d, nBatches, pct, atomic.LoadInt64(&rows), eta.Round(time.Second))
+			if vacuum && d*fixPrevoutVacuums/nBatches > lastVacuum && d < nBatches {
+				lastVacuum = d * fixPrevoutVacuums / nBatches
+				vstart := time.Now()
+				if _, err := db.Exec("VACUUM (INDEX_CLEANUP OFF) txins"); err != nil {
+					log.Printf("Error vacuuming txins: %v", err)
+				} else {
+					log.Printf("  - vacuumed txins in %s.", time.Now().Sub(vstart).Round(time.Second))
+				}
+			}
+		}
+	}
+}
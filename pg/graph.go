@@ -0,0 +1,233 @@
+package pg
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/blkchain/blkchain/core"
+)
+
+// Export of the transaction graph for network analysis in tools better
+// suited to it than Postgres (Neo4j, Gephi, networkx, ...). There are
+// two kinds of graph:
+//
+//   tx       an edge from the tx whose outputs are spent to the tx
+//            spending them, with the total value spent
+//   address  an edge from every input address to every output address
+//            of a tx, the value of each output is split among the
+//            inputs in proportion to their value
+//
+// Only the main chain within the given heights is exported. Nodes
+// referred to by the edges are written once, the first time they are
+// seen, which requires keeping all of them in memory, so very large
+// ranges need a lot of it.
+
+const (
+	GraphTx      = "tx"
+	GraphAddress = "address"
+)
+
+type GraphEdge struct {
+	From, To string
+	Value    int64
+	Height   int
+}
+
+// A GraphWriter writes the graph in some format, see graphio.go.
+type GraphWriter interface {
+	Node(id, label string) error
+	Edge(e *GraphEdge) error
+	Close() error
+}
+
+type graphExport struct {
+	gw    GraphWriter
+	seen  map[string]bool
+	label string
+	Nodes int
+	Edges int
+}
+
+func (g *graphExport) edge(e *GraphEdge) error {
+	for _, id := range []string{e.From, e.To} {
+		if !g.seen[id] {
+			g.seen[id] = true
+			g.Nodes++
+			if err := g.gw.Node(id, g.label); err != nil {
+				return err
+			}
+		}
+	}
+	g.Edges++
+	return g.gw.Edge(e)
+}
+
+// ExportGraph writes the graph of the given kind for the blocks from
+// fromHeight to toHeight inclusive, a negative toHeight means the tip.
+// Returns the number of nodes and edges written. The GraphWriter is
+// not closed.
+func ExportGraph(connstr, kind string, fromHeight, toHeight int, gw GraphWriter) (int, int, error) {
+	db, err := sql.Open("postgres", connstr)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer db.Close()
+
+	if toHeight < 0 {
+		if err := db.QueryRow("SELECT COALESCE(MAX(height), -1) FROM blocks WHERE NOT orphan").Scan(&toHeight); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	g := &graphExport{gw: gw, seen: make(map[string]bool)}
+	switch kind {
+	case GraphTx:
+		g.label = "Tx"
+		err = exportTxGraph(db, fromHeight, toHeight, g)
+	case GraphAddress:
+		g.label = "Address"
+		err = exportAddressGraph(db, fromHeight, toHeight, g)
+	default:
+		err = fmt.Errorf("Unknown graph kind: %q (must be %q or %q)", kind, GraphTx, GraphAddress)
+	}
+	return g.Nodes, g.Edges, err
+}
+
+func exportTxGraph(db *sql.DB, fromHeight, toHeight int, g *graphExport) error {
+	rows, err := db.Query(`
+SELECT b.height, pt.txid, t.txid, SUM(po.value)
+  FROM blocks b
+  JOIN block_txs bt ON bt.block_id = b.id
+  JOIN txins i ON i.tx_id = bt.tx_id
+  JOIN txouts po ON po.tx_id = i.prevout_tx_id AND po.n = i.prevout_n
+  JOIN txs t ON t.id = i.tx_id
+  JOIN txs pt ON pt.id = i.prevout_tx_id
+ WHERE b.height BETWEEN $1 AND $2
+   AND NOT b.orphan
+ GROUP BY b.height, bt.n, pt.txid, t.txid
+ ORDER BY b.height, bt.n`, fromHeight, toHeight)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			e        GraphEdge
+			from, to core.Uint256
+		)
+		if err := rows.Scan(&e.Height, &from, &to, &e.Value); err != nil {
+			return err
+		}
+		e.From, e.To = from.String(), to.String()
+		if err := g.edge(&e); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// One side (inputs or outputs) of a tx, by address.
+type addrValues struct {
+	addrs  []string
+	values map[string]int64
+	total  int64
+}
+
+func (a *addrValues) add(addr string, value int64) {
+	if a.values == nil {
+		a.values = make(map[string]int64)
+	}
+	if _, ok := a.values[addr]; !ok {
+		a.addrs = append(a.addrs, addr)
+	}
+	a.values[addr] += value
+	a.total += value
+}
+
+func exportAddressGraph(db *sql.DB, fromHeight, toHeight int, g *graphExport) error {
+	// Inputs use the address of the output they spend, which works for
+	// any kind of script, unlike the scriptsig and witness.
+	rows, err := db.Query(`
+SELECT bt.tx_id, b.height, false AS output, po.value, po.scriptpubkey
+  FROM blocks b
+  JOIN block_txs bt ON bt.block_id = b.id
+  JOIN txins i ON i.tx_id = bt.tx_id
+  JOIN txouts_v po ON po.tx_id = i.prevout_tx_id AND po.n = i.prevout_n
+ WHERE b.height BETWEEN $1 AND $2
+   AND NOT b.orphan
+UNION ALL
+SELECT bt.tx_id, b.height, true AS output, o.value, o.scriptpubkey
+  FROM blocks b
+  JOIN block_txs bt ON bt.block_id = b.id
+  JOIN txouts_v o ON o.tx_id = bt.tx_id
+ WHERE b.height BETWEEN $1 AND $2
+   AND NOT b.orphan
+ORDER BY tx_id, output`, fromHeight, toHeight)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var (
+		lastTxId  int64 = -1
+		height    int
+		ins, outs addrValues
+	)
+	flush := func() error {
+		if ins.total == 0 {
+			ins.addrs = nil
+		}
+		for _, from := range ins.addrs {
+			for _, to := range outs.addrs {
+				if from == to { // change
+					continue
+				}
+				value := int64(float64(outs.values[to]) * float64(ins.values[from]) / float64(ins.total))
+				if err := g.edge(&GraphEdge{From: from, To: to, Value: value, Height: height}); err != nil {
+					return err
+				}
+			}
+		}
+		ins, outs = addrValues{}, addrValues{}
+		return nil
+	}
+
+	for rows.Next() {
+		var (
+			txId   int64
+			h      int
+			output bool
+			value  int64
+			script []byte
+		)
+		if err := rows.Scan(&txId, &h, &output, &value, &script); err != nil {
+			return err
+		}
+		if txId != lastTxId {
+			if err := flush(); err != nil {
+				return err
+			}
+			lastTxId, height = txId, h
+		}
+		addr := scriptAddress(script)
+		if addr == "" {
+			continue
+		}
+		if output {
+			outs.add(addr, value)
+		} else {
+			ins.add(addr, value)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	return flush()
+}
+
+// scriptAddress returns the mainnet address of a scriptPubKey, or ""
+// if it does not have exactly one (multisig, OP_RETURN, non-standard).
+func scriptAddress(script []byte) string {
+	return core.ScriptAddress(script, core.MainNetAddressParams)
+}
@@ -0,0 +1,122 @@
+package pg
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Graph output formats for ExportGraph.
+
+// Neo4j bulk import (neo4j-admin database import) CSV files, nodes.csv
+// and edges.csv in a directory:
+//
+//	neo4j-admin database import full --nodes=nodes.csv --relationships=edges.csv
+type neo4jWriter struct {
+	files        []io.WriteCloser
+	nodes, edges *csv.Writer
+	relType      string
+}
+
+// NewNeo4jWriter creates nodes.csv and edges.csv in dir, which must
+// exist, or under an s3:// or gs:// prefix. relType is the relationship
+// type of the edges.
+func NewNeo4jWriter(dir, relType string) (GraphWriter, error) {
+	w := &neo4jWriter{relType: relType}
+	for _, name := range []string{"nodes.csv", "edges.csv"} {
+		f, err := CreateOutput(JoinOutput(dir, name))
+		if err != nil {
+			w.Close()
+			return nil, err
+		}
+		w.files = append(w.files, f)
+	}
+	w.nodes, w.edges = csv.NewWriter(w.files[0]), csv.NewWriter(w.files[1])
+	if err := w.nodes.Write([]string{"id:ID", ":LABEL"}); err != nil {
+		w.Close()
+		return nil, err
+	}
+	if err := w.edges.Write([]string{":START_ID", ":END_ID", ":TYPE", "value:long", "height:int"}); err != nil {
+		w.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *neo4jWriter) Node(id, label string) error {
+	return w.nodes.Write([]string{id, label})
+}
+
+func (w *neo4jWriter) Edge(e *GraphEdge) error {
+	return w.edges.Write([]string{e.From, e.To, w.relType,
+		strconv.FormatInt(e.Value, 10), strconv.Itoa(e.Height)})
+}
+
+func (w *neo4jWriter) Close() error {
+	var result error
+	for _, cw := range []*csv.Writer{w.nodes, w.edges} {
+		if cw == nil {
+			continue
+		}
+		cw.Flush()
+		if err := cw.Error(); err != nil && result == nil {
+			result = err
+		}
+	}
+	for _, f := range w.files {
+		if err := f.Close(); err != nil && result == nil {
+			result = err
+		}
+	}
+	return result
+}
+
+// GraphML, a single XML file which Gephi, networkx, igraph and yEd
+// can read. Nodes and edges are interleaved, which GraphML allows.
+type graphMLWriter struct {
+	w *bufio.Writer
+}
+
+// NewGraphMLWriter writes the graph to w, which is not closed by Close.
+func NewGraphMLWriter(w io.Writer) (GraphWriter, error) {
+	gw := &graphMLWriter{w: bufio.NewWriter(w)}
+	_, err := gw.w.WriteString(xml.Header +
+		`<graphml xmlns="http://graphml.graphdrawing.org/xmlns">` + "\n" +
+		`  <key id="label" for="node" attr.name="label" attr.type="string"/>` + "\n" +
+		`  <key id="value" for="edge" attr.name="value" attr.type="long"/>` + "\n" +
+		`  <key id="height" for="edge" attr.name="height" attr.type="int"/>` + "\n" +
+		`  <graph edgedefault="directed">` + "\n")
+	if err != nil {
+		return nil, err
+	}
+	return gw, nil
+}
+
+func (gw *graphMLWriter) Node(id, label string) error {
+	_, err := fmt.Fprintf(gw.w, "    <node id=\"%s\"><data key=\"label\">%s</data></node>\n",
+		xmlEscape(id), xmlEscape(label))
+	return err
+}
+
+func (gw *graphMLWriter) Edge(e *GraphEdge) error {
+	_, err := fmt.Fprintf(gw.w, "    <edge source=\"%s\" target=\"%s\"><data key=\"value\">%d</data><data key=\"height\">%d</data></edge>\n",
+		xmlEscape(e.From), xmlEscape(e.To), e.Value, e.Height)
+	return err
+}
+
+func (gw *graphMLWriter) Close() error {
+	if _, err := gw.w.WriteString("  </graph>\n</graphml>\n"); err != nil {
+		return err
+	}
+	return gw.w.Flush()
+}
+
+func xmlEscape(s string) string {
+	var sb strings.Builder
+	xml.EscapeText(&sb, []byte(s))
+	return sb.String()
+}
@@ -0,0 +1,264 @@
+package pg
+
+import (
+	"github.com/blkchain/blkchain/core"
+	"github.com/lib/pq"
+)
+
+// The batch loaders of the QLQL layer (see serve/graphql.go): each
+// loads the rows of many keys in one query, the keys of all the
+// parents of a field at one level of the query, rather than a query
+// per parent, the way a dataloader does. The rows carry the internal
+// ids for the next level.
+
+type QLBlock struct {
+	Id          int64        `db:"id"`
+	Height      int          `db:"height"`
+	Hash        core.Uint256 `db:"hash"`
+	Version     int32        `db:"version"`
+	PrevHash    core.Uint256 `db:"prevhash"`
+	PrevBlockId *int64       `db:"prev_block_id"`
+	MerkleRoot  core.Uint256 `db:"merkleroot"`
+	Time        int64        `db:"time"`
+	Bits        int32        `db:"bits"`
+	Nonce       int64        `db:"nonce"`
+	Orphan      bool         `db:"orphan"`
+	Size        int          `db:"size"`
+	BaseSize    int          `db:"base_size"`
+	Weight      int          `db:"weight"`
+	NTx         int          `db:"n_tx"`
+}
+
+type QLTx struct {
+	Id       int64        `db:"id"`
+	Txid     core.Uint256 `db:"txid"`
+	Version  int32        `db:"version"`
+	LockTime int64        `db:"locktime"`
+	Size     int          `db:"size"`
+	VSize    int          `db:"virt_size"`
+	Weight   int          `db:"weight"`
+	BlockId  *int64       `db:"block_id"` // preferably in the main chain
+}
+
+type QLOutPoint struct {
+	TxId int64
+	N    int
+}
+
+type QLTxIn struct {
+	TxId        int64  `db:"tx_id"`
+	N           int    `db:"n"`
+	PrevoutTxId *int64 `db:"prevout_tx_id"` // nil for the coinbase
+	PrevoutTxid []byte `db:"prevout_txid"`
+	PrevoutN    int    `db:"prevout_n"`
+	ScriptSig   []byte `db:"scriptsig"`
+	Sequence    int64  `db:"sequence"`
+	Witness     []byte `db:"witness"`
+}
+
+type QLTxOut struct {
+	TxId         int64  `db:"tx_id"`
+	N            int    `db:"n"`
+	Value        int64  `db:"value"`
+	ScriptPubKey []byte `db:"scriptpubkey"`
+	Spent        bool   `db:"spent"`
+}
+
+// QLBlockId returns the id of the block of the hash.
+func (e *Explorer) QLBlockId(hash core.Uint256) (int64, error) {
+	var id int64
+	err := e.readGet(&id, "SELECT id FROM blocks WHERE hash = $1", hash[:])
+	return id, err
+}
+
+// QLMainChainBlockIds returns the ids of up to limit main chain
+// blocks at and below height (negative = the tip), highest first.
+func (e *Explorer) QLMainChainBlockIds(height, limit int) ([]int64, error) {
+	var ids []int64
+	err := e.readSelect(&ids, `
+SELECT id FROM blocks
+ WHERE NOT orphan
+   AND height <= CASE WHEN $1 < 0 THEN (SELECT MAX(height) FROM blocks) ELSE $1 END
+ ORDER BY height DESC
+ LIMIT $2`, height, limit)
+	return ids, err
+}
+
+func (e *Explorer) QLBlocks(ids []int64) (map[int64]*QLBlock, error) {
+	var rows []*QLBlock
+	if err := e.readSelect(&rows, `
+SELECT b.id, b.height, b.hash, b.version, b.prevhash, b.prev_block_id, b.merkleroot, uint32(b.time) AS time,
+       b.bits, uint32(b.nonce) AS nonce, b.orphan, b.size, b.base_size, b.weight,
+       (SELECT COUNT(1) FROM block_txs bt WHERE bt.block_id = b.id) AS n_tx
+  FROM blocks b
+ WHERE b.id = ANY($1)`, pq.Array(ids)); err != nil {
+		return nil, err
+	}
+	result := make(map[int64]*QLBlock, len(rows))
+	for _, r := range rows {
+		result[r.Id] = r
+	}
+	return result, nil
+}
+
+// QLBlockTxIds returns the ids of the txs of each block, from
+// position start on, up to limit per block.
+func (e *Explorer) QLBlockTxIds(blockIds []int64, start, limit int) (map[int64][]int64, error) {
+	var rows []struct {
+		BlockId int64 `db:"block_id"`
+		TxId    int64 `db:"tx_id"`
+	}
+	if err := e.readSelect(&rows, `
+SELECT b.block_id, bt.tx_id
+  FROM UNNEST($1::INT[]) b(block_id)
+  JOIN LATERAL (
+    SELECT tx_id FROM block_txs
+     WHERE block_id = b.block_id AND n >= $2
+     ORDER BY n
+     LIMIT $3
+  ) bt ON true`, pq.Array(blockIds), start, limit); err != nil {
+		return nil, err
+	}
+	result := make(map[int64][]int64)
+	for _, r := range rows {
+		result[r.BlockId] = append(result[r.BlockId], r.TxId)
+	}
+	return result, nil
+}
+
+// QLTxId returns the id of the tx of the txid.
+func (e *Explorer) QLTxId(txid core.Uint256) (int64, error) {
+	var id int64
+	err := e.readGet(&id, "SELECT id FROM txs WHERE txid = $1", txid[:])
+	return id, err
+}
+
+func (e *Explorer) QLTxs(ids []int64) (map[int64]*QLTx, error) {
+	var rows []*QLTx
+	if err := e.readSelect(&rows, `
+SELECT t.id, t.txid, t.version, uint32(t.locktime) AS locktime, t.size, t.virt_size, t.weight, b.block_id
+  FROM txs t
+  LEFT JOIN LATERAL (
+    SELECT bt.block_id
+      FROM block_txs bt
+      JOIN blocks b ON b.id = bt.block_id
+     WHERE bt.tx_id = t.id
+     ORDER BY b.orphan -- prefer the main chain
+     LIMIT 1
+  ) b ON true
+ WHERE t.id = ANY($1)`, pq.Array(ids)); err != nil {
+		return nil, err
+	}
+	result := make(map[int64]*QLTx, len(rows))
+	for _, r := range rows {
+		result[r.Id] = r
+	}
+	return result, nil
+}
+
+// QLTxIns returns the inputs of each tx, in order.
+func (e *Explorer) QLTxIns(txIds []int64) (map[int64][]*QLTxIn, error) {
+	var rows []*QLTxIn
+	if err := e.readSelect(&rows, `
+SELECT i.tx_id, i.n, i.prevout_tx_id, p.txid AS prevout_txid, uint32(i.prevout_n::INT) AS prevout_n,
+       i.scriptsig, uint32(i.sequence) AS sequence, i.witness
+  FROM txins i
+  LEFT JOIN txs p ON p.id = i.prevout_tx_id
+ WHERE i.tx_id = ANY($1)
+ ORDER BY i.tx_id, i.n`, pq.Array(txIds)); err != nil {
+		return nil, err
+	}
+	result := make(map[int64][]*QLTxIn)
+	for _, r := range rows {
+		result[r.TxId] = append(result[r.TxId], r)
+	}
+	return result, nil
+}
+
+// QLTxOuts returns the outputs of each tx, in order.
+func (e *Explorer) QLTxOuts(txIds []int64) (map[int64][]*QLTxOut, error) {
+	var rows []*QLTxOut
+	if err := e.readSelect(&rows, `
+SELECT tx_id, n, value, scriptpubkey, spent
+  FROM txouts_v
+ WHERE tx_id = ANY($1)
+ ORDER BY tx_id, n`, pq.Array(txIds)); err != nil {
+		return nil, err
+	}
+	result := make(map[int64][]*QLTxOut)
+	for _, r := range rows {
+		result[r.TxId] = append(result[r.TxId], r)
+	}
+	return result, nil
+}
+
+func outPointArrays(points []QLOutPoint) (interface{}, interface{}) {
+	txIds, ns := make([]int64, len(points)), make([]int64, len(points))
+	for i, p := range points {
+		txIds[i], ns[i] = p.TxId, int64(p.N)
+	}
+	return pq.Array(txIds), pq.Array(ns)
+}
+
+// QLTxOutsAt returns the outputs of the outpoints, e.g. the
+// prevouts of inputs.
+func (e *Explorer) QLTxOutsAt(points []QLOutPoint) (map[QLOutPoint]*QLTxOut, error) {
+	txIds, ns := outPointArrays(points)
+	var rows []*QLTxOut
+	if err := e.readSelect(&rows, `
+SELECT o.tx_id, o.n, o.value, o.scriptpubkey, o.spent
+  FROM UNNEST($1::BIGINT[], $2::INT[]) p(tx_id, n)
+  JOIN txouts_v o ON o.tx_id = p.tx_id AND o.n = p.n`, txIds, ns); err != nil {
+		return nil, err
+	}
+	result := make(map[QLOutPoint]*QLTxOut, len(rows))
+	for _, r := range rows {
+		result[QLOutPoint{r.TxId, r.N}] = r
+	}
+	return result, nil
+}
+
+// QLSpendingTxIns returns the inputs spending the outpoints.
+func (e *Explorer) QLSpendingTxIns(points []QLOutPoint) (map[QLOutPoint]*QLTxIn, error) {
+	txIds, ns := outPointArrays(points)
+	var rows []*QLTxIn
+	if err := e.readSelect(&rows, `
+SELECT i.tx_id, i.n, i.prevout_tx_id, t.txid AS prevout_txid, uint32(i.prevout_n::INT) AS prevout_n,
+       i.scriptsig, uint32(i.sequence) AS sequence, i.witness
+  FROM UNNEST($1::BIGINT[], $2::INT[]) p(tx_id, n)
+  JOIN txins i ON i.prevout_tx_id = p.tx_id AND i.prevout_n = p.n
+  JOIN txs t ON t.id = i.prevout_tx_id`, txIds, ns); err != nil {
+		return nil, err
+	}
+	result := make(map[QLOutPoint]*QLTxIn, len(rows))
+	for _, r := range rows {
+		result[QLOutPoint{*r.PrevoutTxId, r.PrevoutN}] = r
+	}
+	return result, nil
+}
+
+// QLAddrTxIds returns the ids of the last limit txs funding or
+// spending from the address (see AddressKey), newest first.
+func (e *Explorer) QLAddrTxIds(addr []byte, limit int) ([]int64, error) {
+	var ids []int64
+	err := e.readSelect(&ids, `
+( SELECT tx_id
+    FROM txins
+   WHERE addr_prefix(scriptsig, witness) = bytes2int8($1)
+     AND prevout_tx_id IS NOT NULL
+     AND extract_address(scriptsig, witness) = $1
+   ORDER BY tx_id DESC
+   LIMIT $2
+)
+UNION
+( SELECT tx_id
+    FROM txouts
+   WHERE addr_prefix(scriptpubkey) = bytes2int8($1)
+     AND extract_address(scriptpubkey) = $1
+   ORDER BY tx_id DESC
+   LIMIT $2
+)
+ORDER BY tx_id DESC
+LIMIT $2`, addr, limit)
+	return ids, err
+}
@@ -0,0 +1,179 @@
+package pg
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Address history: the funding (outputs to) and spending (inputs
+// from) events of an address, newest first, one page at a time. Pages
+// are keyed by the last event of the previous page (keyset
+// pagination), so that deep pages cost the same as the first one.
+//
+// tx_id follows height and position in the block, so ordering by
+// (tx_id, spending, n) is chain order. The covering indexes created by
+// createAddressHistoryIndexes are in that order and include the value
+// and prevout, which makes a page a short index range scan on each
+// side. The regular address prefix indexes work too, but need more
+// heap access for busy addresses.
+//
+// The outputs are read through txouts_v, with deduplicated scripts
+// (see scripts.go) this works but there are no indexes to help.
+//
+// Spends are recognized by the scriptsig or witness, as with the other
+// Explorer address queries, so spends of P2PK and bare multisig outputs
+// are not found.
+
+// AddrHistoryKey identifies an event, it is the pagination cursor.
+type AddrHistoryKey struct {
+	TxId     int64
+	Spending bool
+	N        int
+}
+
+// The cursor for the first page.
+var AddrHistoryStart = AddrHistoryKey{TxId: math.MaxInt64}
+
+func (k AddrHistoryKey) String() string {
+	kind := "f"
+	if k.Spending {
+		kind = "s"
+	}
+	return fmt.Sprintf("%d%s%d", k.TxId, kind, k.N)
+}
+
+// ParseAddrHistoryKey parses what AddrHistoryKey.String() returns.
+func ParseAddrHistoryKey(s string) (AddrHistoryKey, error) {
+	var k AddrHistoryKey
+	i := strings.IndexAny(s, "fs")
+	if i < 1 {
+		return k, fmt.Errorf("Invalid history key: %q", s)
+	}
+	txId, err := strconv.ParseInt(s[:i], 10, 64)
+	if err != nil {
+		return k, fmt.Errorf("Invalid history key: %q", s)
+	}
+	n, err := strconv.Atoi(s[i+1:])
+	if err != nil {
+		return k, fmt.Errorf("Invalid history key: %q", s)
+	}
+	return AddrHistoryKey{TxId: txId, Spending: s[i] == 's', N: n}, nil
+}
+
+func createAddressHistoryIndexes(db *sql.DB, verbose bool) error {
+	// The index would be of NULLs
+	if dedup, err := haveScriptDict(db); err != nil {
+		return err
+	} else if dedup {
+		return fmt.Errorf("Address history indexes are not supported with deduplicated scripts, txouts.scriptpubkey is NULL.")
+	}
+	for _, stmt := range []string{
+		"CREATE INDEX IF NOT EXISTS txouts_addr_history_idx ON txouts(addr_prefix(scriptpubkey), tx_id, n) INCLUDE (value);",
+		"CREATE INDEX IF NOT EXISTS txins_addr_history_idx ON txins(addr_prefix(scriptsig, witness), tx_id, n) INCLUDE (prevout_tx_id, prevout_n);",
+	} {
+		if verbose {
+			log.Printf("  Starting %s", stmt)
+		}
+		start := time.Now()
+		if _, err := db.Exec(stmt); err != nil {
+			return err
+		}
+		if verbose {
+			log.Printf("  ...done in %s.", time.Now().Sub(start).Round(time.Millisecond))
+		}
+	}
+	return nil
+}
+
+// CreateAddressHistoryIndexes adds the address history indexes to an
+// existing database. This takes a long time on a full chain.
+func CreateAddressHistoryIndexes(connstr string) error {
+	db, err := sql.Open("postgres", connstr)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return createAddressHistoryIndexes(db, true)
+}
+
+// SelectAddrHistoryJson returns up to limit events of the address
+// (as returned by extract_address()) older than the after key, and the
+// key to pass to get the next page, which is nil after the last page.
+func (e *Explorer) SelectAddrHistoryJson(addr []byte, after AddrHistoryKey, limit int) ([]string, *AddrHistoryKey, error) {
+	// Within a tx, outputs (funding) come before inputs (spending)
+	// in this order. Translate the key into a (tx_id, n) bound for
+	// each side so that both can use the index.
+	outN, inN := -1, math.MaxInt32
+	if after.Spending {
+		inN = after.N
+	} else {
+		outN = after.N
+	}
+
+	stmt := `
+SELECT to_json(h.*) FROM (
+SELECT e.tx_id, e.spending, e.n, t.txid, b.height, b.tx_n, e.value FROM (
+  ( SELECT tx_id, false AS spending, n, value
+      FROM txouts_v
+     WHERE addr_prefix(scriptpubkey) = bytes2int8($1)
+       AND extract_address(scriptpubkey) = $1
+       AND (tx_id, n) < ($2, $3)
+     ORDER BY tx_id DESC, n DESC
+    LIMIT $5
+  )
+  UNION ALL
+  ( SELECT i.tx_id, true AS spending, i.n, po.value
+      FROM (
+        SELECT tx_id, n, prevout_tx_id, prevout_n
+          FROM txins
+         WHERE addr_prefix(scriptsig, witness) = bytes2int8($1)
+           AND prevout_tx_id IS NOT NULL
+           AND extract_address(scriptsig, witness) = $1
+           AND (tx_id, n) < ($2, $4)
+         ORDER BY tx_id DESC, n DESC
+        LIMIT $5
+      ) i
+      JOIN txouts_v po ON po.tx_id = i.prevout_tx_id AND po.n = i.prevout_n
+  )
+  ORDER BY tx_id DESC, spending, n DESC
+  LIMIT $5
+) e
+JOIN txs t ON t.id = e.tx_id
+LEFT JOIN LATERAL (
+  SELECT b.height, bt.n AS tx_n
+    FROM block_txs bt
+    JOIN blocks b ON b.id = bt.block_id
+   WHERE bt.tx_id = e.tx_id
+     AND NOT b.orphan
+   LIMIT 1
+) b ON true
+ORDER BY e.tx_id DESC, e.spending, e.n DESC
+) h`
+
+	type row struct {
+		TxId     int64 `json:"tx_id"`
+		Spending bool  `json:"spending"`
+		N        int   `json:"n"`
+	}
+
+	var events []string
+	if err := e.readSelect(&events, stmt, addr, after.TxId, outN, inN, limit+1); err != nil {
+		return nil, nil, err
+	}
+
+	if len(events) <= limit {
+		return events, nil, nil
+	}
+	events = events[:limit]
+	var last row
+	if err := json.Unmarshal([]byte(events[limit-1]), &last); err != nil {
+		return nil, nil, err
+	}
+	return events, &AddrHistoryKey{TxId: last.TxId, Spending: last.Spending, N: last.N}, nil
+}
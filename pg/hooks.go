@@ -0,0 +1,87 @@
+package pg
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/blkchain/blkchain/core"
+)
+
+// Hooks for programs embedding the import: a BlockHook in
+// WriterConfig.Hooks is given every new block and every new
+// transaction on the goroutine parsing them, before they are handed to
+// the writers, along with the ids they get in the database, and keeps
+// whatever it computes in its own tables. Unlike an EmbeddedParser
+// (see parsers.go), which is chosen by name on the command line, a
+// hook is code of the program creating the PGWriter:
+//
+//   w, err := pg.NewPGWriterConfig(pg.WriterConfig{
+//       ConnectString: connstr,
+//       Hooks:         []pg.BlockHook{myHook},
+//   }, utxo)
+//
+// Whatever the hooks have collected is flushed every time the import
+// commits, i.e. after every block once caught up, every 1024 blocks
+// during the first import, and at the end, all the hooks in a single
+// transaction, committed right after the txs and txouts of the same
+// blocks and before their txins. An error of a hook rolls back the
+// writes of all of them and stops the import like an error of a
+// writer (see panics.go), since the blocks are already committed and
+// will not be given to the hooks again. A hook only sees the blocks as
+// they are written, the orphans are only marked later (see
+// SetOrphans).
+
+type BlockHook interface {
+	// Start is called once before the first block to create tables
+	// and load any state. db is nil with nulldb.
+	Start(db *sql.DB) error
+	// Block is called for every new block before its transactions
+	// (none with HeadersOnly). It must not block.
+	Block(b *BlockRec)
+	// Tx is called for every new transaction in chain order, n is the
+	// position of the transaction within the block. A transaction
+	// already imported (e.g. the duplicate coinbases) is skipped. It
+	// must not block.
+	Tx(b *BlockRec, n int, txId int64, tx *core.Tx)
+	// Flush writes out what has been collected in txn, which is nil
+	// with nulldb. An error rolls back the writes of all the hooks
+	// and stops the import.
+	Flush(txn *sql.Tx) error
+}
+
+func startHooks(hooks []BlockHook, db *sql.DB) error {
+	for _, h := range hooks {
+		if err := h.Start(db); err != nil {
+			return fmt.Errorf("Starting hook %T: %v", h, err)
+		}
+	}
+	return nil
+}
+
+func flushHooks(hooks []BlockHook, db *sql.DB) error {
+	if len(hooks) == 0 {
+		return nil
+	}
+	if db == nil {
+		for _, h := range hooks {
+			if err := h.Flush(nil); err != nil {
+				return fmt.Errorf("Flushing hook %T: %v", h, err)
+			}
+		}
+		return nil
+	}
+	txn, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("Flushing hooks: %v", err)
+	}
+	for _, h := range hooks {
+		if err := h.Flush(txn); err != nil {
+			txn.Rollback()
+			return fmt.Errorf("Flushing hook %T: %v", h, err)
+		}
+	}
+	if err := txn.Commit(); err != nil {
+		return fmt.Errorf("Committing hooks: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package pg
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/blkchain/blkchain/core"
+)
+
+// Input values: with -input-values the txins have an input_value
+// column, the value of the output spent, so that the fee of a tx or
+// the flows between addresses need not join txouts by prevout_tx_id.
+// The values are written along with the txins from the block undo data
+// when the blocks are read from the Core LevelDb index (see
+// coredb/undo.go) or from the outputs of the same block, the rest
+// (e.g. when following the node, which sends no undo data) are filled
+// in from txouts after the block is committed (FillInputValues) and at
+// the end of the import. Once the column exists, the import keeps it up
+// to date whether or not the option is given again; cmd/upgrade
+// -input-values adds it to an existing database. Not supported with
+// shards.
+
+// How many blocks back from the tip FillInputValues looks for inputs
+// without a value.
+const inputValuesWindow = 10
+
+func createInputValueColumn(db *sql.DB) error {
+	_, err := db.Exec("ALTER TABLE txins ADD COLUMN IF NOT EXISTS input_value BIGINT")
+	return err
+}
+
+func haveInputValues(db *sql.DB) (bool, error) {
+	return columnExists(db, "txins", "input_value")
+}
+
+// inputValue returns the value spent by input i of tx n of the block,
+// if it is known without looking it up, nil otherwise. inBlock has the
+// outputs of the txs of the block before tx n, by their hash.
+func inputValue(b *core.Block, inBlock map[core.Uint256]core.TxOutList, n, i int, txin *core.TxIn) *int64 {
+	if n == 0 { // coinbase
+		return nil
+	}
+	if b.PrevOutValues != nil {
+		return &b.PrevOutValues[n-1][i]
+	}
+	if outs, ok := inBlock[txin.PrevOut.Hash]; ok && int(txin.PrevOut.N) < len(outs) {
+		return &outs[txin.PrevOut.N].Value
+	}
+	return nil
+}
+
+// fillInputValues sets the input_value of the txins from fromTxId on
+// which have none from the outputs they spend.
+func fillInputValues(db *sql.DB, fromTxId int64, verbose bool) error {
+	start := time.Now()
+	res, err := db.Exec(`
+UPDATE txins i
+   SET input_value = o.value
+  FROM txouts o
+ WHERE i.tx_id >= $1
+   AND i.input_value IS NULL
+   AND i.prevout_tx_id = o.tx_id
+   AND i.prevout_n = o.n`, fromTxId)
+	if err != nil {
+		return fmt.Errorf("Filling in input_value: %v", err)
+	}
+	if verbose {
+		n, _ := res.RowsAffected()
+		log.Printf("  Filled in the input_value of %d txins in %s.", n, time.Now().Sub(start).Round(time.Millisecond))
+	}
+	return nil
+}
+
+// WritesInputValues tells whether the txins have input_value, i.e.
+// whether the blocks should come with their undo data.
+func (w *PGWriter) WritesInputValues() bool {
+	return w.cfg.InputValues
+}
+
+// FillInputValues fills in the input_value of the txins of the last
+// blocks which have none, if the txins have the column.
+func (w *PGWriter) FillInputValues() error {
+	if w.db == nil || !w.cfg.InputValues {
+		return nil
+	}
+	var from sql.NullInt64
+	if err := w.db.QueryRow(`
+SELECT MIN(bt.tx_id)
+  FROM block_txs bt
+  JOIN (SELECT id FROM blocks ORDER BY id DESC LIMIT $1) b ON b.id = bt.block_id`, inputValuesWindow).Scan(&from); err != nil {
+		return err
+	}
+	if !from.Valid {
+		return nil
+	}
+	return fillInputValues(w.db, from.Int64, false)
+}
+
+// AddInputValues adds the input_value column to an existing database
+// and fills it in, which is a rewrite of the whole txins table.
+func AddInputValues(connstr string) error {
+	db, err := sql.Open("postgres", connstr)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var sharded bool
+	if err := db.QueryRow("SELECT to_regclass(current_schema() || '.shard_heights') IS NOT NULL").Scan(&sharded); err != nil {
+		return err
+	}
+	if sharded {
+		return fmt.Errorf("Input values are not supported with shards.")
+	}
+	if err := createInputValueColumn(db); err != nil {
+		return err
+	}
+	log.Printf("Filling in input_value, this may take a long time...")
+	if err := fillInputValues(db, 0, true); err != nil {
+		return err
+	}
+	return analyzeTables(db, "after filling in input_value", []string{"txins"}, false)
+}
@@ -0,0 +1,100 @@
+package pg
+
+import (
+	"database/sql"
+	"log"
+	"strings"
+)
+
+// With WriterConfig.Inscriptions, ord inscription envelopes found in
+// taproot witnesses (see core/inscription.go) are written to the
+// inscriptions table. The witness itself is still in txins, this only
+// saves having to parse it in SQL.
+
+func createInscriptionsTable(db *sql.DB) error {
+	_, err := db.Exec(`
+  CREATE TABLE IF NOT EXISTS inscriptions (
+   tx_id            BIGINT NOT NULL
+  ,n                SMALLINT NOT NULL -- txin
+  ,idx              SMALLINT NOT NULL -- envelope within the txin
+  ,content_type     TEXT
+  ,content_encoding TEXT
+  ,body             BYTEA
+  );
+  CREATE INDEX IF NOT EXISTS inscriptions_tx_id_idx ON inscriptions(tx_id);
+  CREATE INDEX IF NOT EXISTS inscriptions_content_type_idx ON inscriptions(content_type);
+`)
+	return err
+}
+
+// Content types are whatever the inscriber put there, TEXT cannot
+// have invalid UTF-8 or NULs.
+func inscriptionText(b []byte) interface{} {
+	if b == nil {
+		return nil
+	}
+	return strings.ReplaceAll(strings.ToValidUTF8(string(b), "�"), "\x00", "")
+}
+
+func pgInscriptionWriter(c chan *inscriptionRec, db *sql.DB, upsert bool, fail *writerFailure) {
+	defer writerWg.Done()
+	defer fail.catch("inscriptions writer", func() {
+		for ir := range c {
+			if ir != nil && ir.sync != nil {
+				ir.sync <- false
+			}
+		}
+	})
+
+	stage := pipeline.stage("inscriptions", c, stateRunning)
+	defer pipeline.remove(stage)
+
+	cols := []string{"tx_id", "n", "idx", "content_type", "content_encoding", "body"}
+
+	txn, stmt, err := begin(fail.ctx, db, upsert, "inscriptions", cols)
+	if err != nil {
+		log.Printf("ERROR (16): %v", err)
+	}
+
+	for ir := range c {
+
+		if ir == nil || ir.ins == nil { // commit signal
+			stage.committing()
+			if err = commit(stmt, txn, upsert, nil); err != nil {
+				log.Printf("Inscription commit error: %v", err)
+			}
+			txn, stmt, err = begin(fail.ctx, db, upsert, "inscriptions", cols)
+			if err != nil {
+				log.Printf("ERROR (17): %v", err)
+			}
+			stage.committed()
+			if ir != nil && ir.sync != nil {
+				ir.sync <- true
+			}
+			continue
+		}
+
+		stage.row()
+		if stmt != nil {
+			ins := ir.ins
+			_, err = stmt.Exec(
+				ir.txId,
+				ins.Input,
+				ins.Index,
+				inscriptionText(ins.ContentType),
+				inscriptionText(ins.ContentEncoding),
+				ins.Body,
+			)
+		}
+		if err != nil {
+			log.Printf("ERROR (17.5): %v\n", err)
+		}
+	}
+
+	log.Printf("Inscription writer channel closed, committing transaction.")
+	stage.committing()
+	if err = commit(stmt, txn, upsert, nil); err != nil {
+		log.Printf("Inscription commit error: %v", err)
+	}
+	log.Printf("Inscription writer done.")
+}
@@ -0,0 +1,181 @@
+package pg
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+
+	"github.com/blkchain/blkchain/core"
+)
+
+// The "coinjoin" parser labels probable coinjoin transactions (see
+// core/coinjoin.go) in the tx_labels table. It is off unless selected with
+// -parsers since it looks at every transaction.
+
+type coinjoinParser struct {
+	rows [][]interface{}
+}
+
+func createTxLabelsTable(db *sql.DB) error {
+	_, err := db.Exec(`
+  CREATE TABLE IF NOT EXISTS tx_labels (
+   tx_id          BIGINT NOT NULL
+  ,label          TEXT NOT NULL
+  ,source         TEXT NOT NULL -- what assigned the label
+  );
+  CREATE INDEX IF NOT EXISTS tx_labels_tx_id_idx ON tx_labels(tx_id);
+  CREATE INDEX IF NOT EXISTS tx_labels_label_idx ON tx_labels(label);
+`)
+	return err
+}
+
+func (p *coinjoinParser) Start(db *sql.DB) error {
+	if db == nil {
+		return nil
+	}
+	return createTxLabelsTable(db)
+}
+
+func (p *coinjoinParser) ParseTx(height, n int, txId int64, tx *core.Tx) {
+	if n == 0 { // coinbase
+		return
+	}
+	if kind := tx.CoinjoinType(); kind != "" {
+		p.rows = append(p.rows, []interface{}{txId, kind, "coinjoin"})
+	}
+}
+
+func (p *coinjoinParser) Flush(db *sql.DB) error {
+	if db == nil || len(p.rows) == 0 {
+		p.rows = nil
+		return nil
+	}
+	txn, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := copyRows(txn, "tx_labels", []string{"tx_id", "label", "source"}, p.rows); err != nil {
+		txn.Rollback()
+		return fmt.Errorf("tx_labels: %v", err)
+	}
+	if err := txn.Commit(); err != nil {
+		return err
+	}
+	p.rows = nil
+	return nil
+}
+
+// Address labels are known-entity tags (exchanges, miners, services)
+// loaded from CSV with cmd/labels. An address is stored the same way
+// extract_address() returns it (the hash or witness program), so the
+// labels can be joined against txins and txouts using the address
+// prefix indexes. All addresses with the same entity are considered a
+// cluster, i.e. the same owner.
+
+func createAddressLabelsTable(db *sql.DB) error {
+	_, err := db.Exec(`
+  CREATE TABLE IF NOT EXISTS address_labels (
+   addr           BYTEA NOT NULL -- as returned by extract_address()
+  ,address        TEXT NOT NULL  -- as given
+  ,label          TEXT NOT NULL
+  ,entity         TEXT           -- the owner, for clustering
+  ,category       TEXT           -- exchange, miner, ...
+  ,source         TEXT NOT NULL  -- where the label came from
+  );
+  CREATE INDEX IF NOT EXISTS address_labels_addr_idx ON address_labels(addr);
+  CREATE INDEX IF NOT EXISTS address_labels_label_idx ON address_labels(label);
+  CREATE INDEX IF NOT EXISTS address_labels_entity_idx ON address_labels(entity);
+`)
+	return err
+}
+
+// AddressKey converts a mainnet address to what extract_address()
+// would return for its scriptPubKey. Only the address types that
+// extract_address() knows about are accepted.
+func AddressKey(address string) ([]byte, error) {
+	a, err := ParseAddress(address)
+	if err != nil {
+		return nil, err
+	}
+	if !a.Indexed {
+		return nil, fmt.Errorf("Unsupported address type %s: %s", a.Type, address)
+	}
+	return a.key, nil
+}
+
+// ImportAddressLabels loads address labels from CSV with the columns
+//
+//   address,label[,entity[,category]]
+//
+// A first line starting with "address" is taken as a header. Rows
+// with an address that cannot be used are logged and skipped. With
+// replace, labels previously imported from the same source are
+// deleted first. Returns the number of labels imported.
+func ImportAddressLabels(connstr string, r io.Reader, source string, replace bool) (int, error) {
+	db, err := sql.Open("postgres", connstr)
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	if err := createAddressLabelsTable(db); err != nil {
+		return 0, err
+	}
+
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	var rows [][]interface{}
+	for line := 1; ; line++ {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+		if line == 1 && strings.EqualFold(rec[0], "address") {
+			continue
+		}
+		if len(rec) < 2 || rec[1] == "" {
+			log.Printf("Line %d: expecting at least address and label, skipping.", line)
+			continue
+		}
+		addr, err := AddressKey(rec[0])
+		if err != nil {
+			log.Printf("Line %d: %v, skipping.", line, err)
+			continue
+		}
+		row := []interface{}{addr, rec[0], rec[1], nil, nil, source}
+		for i := 2; i < len(rec) && i < 4; i++ {
+			if rec[i] != "" {
+				row[i+1] = rec[i]
+			}
+		}
+		rows = append(rows, row)
+	}
+
+	txn, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	if replace {
+		if _, err := txn.Exec("DELETE FROM address_labels WHERE source = $1", source); err != nil {
+			txn.Rollback()
+			return 0, err
+		}
+	}
+	cols := []string{"addr", "address", "label", "entity", "category", "source"}
+	if err := copyRows(txn, "address_labels", cols, rows); err != nil {
+		txn.Rollback()
+		return 0, fmt.Errorf("address_labels: %v", err)
+	}
+	if err := txn.Commit(); err != nil {
+		return 0, err
+	}
+	return len(rows), nil
+}
@@ -0,0 +1,48 @@
+package pg
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/blkchain/blkchain/core"
+)
+
+// Coinbase maturity: the outputs of a coinbase tx (n = 0 in block_txs)
+// cannot be spent until it has core.CoinbaseMaturity (100)
+// confirmations, so until then they are not quite part of a balance.
+// The balance and UTXO queries take a flag to leave them out, and the
+// UTXO snapshots (see utxosnap.go) have an 'immature' row with their
+// count and value. Confirmations are counted as of the height asked
+// for, or the tip if it is beyond it.
+
+func createMaturityFunctions(db *sql.DB) error {
+	_, err := db.Exec(fmt.Sprintf(`
+  -- Whether the tx is a coinbase with fewer than %[1]d confirmations as of _h
+  CREATE OR REPLACE FUNCTION coinbase_immature(_tx_id BIGINT, _h INT) RETURNS BOOLEAN AS $$
+    SELECT EXISTS (
+      SELECT 1
+        FROM block_txs bt
+        JOIN blocks b ON b.id = bt.block_id
+       WHERE bt.tx_id = _tx_id
+         AND bt.n = 0
+         AND NOT b.orphan
+         AND LEAST(_h, (SELECT MAX(height) FROM blocks WHERE NOT orphan)) - b.height + 1 < %[1]d)
+  $$ LANGUAGE sql STABLE;
+`, core.CoinbaseMaturity))
+	return err
+}
+
+// immatureAddrEvents returns the value of the immature coinbase
+// outputs of the address as of height in address_events.
+func (e *Explorer) immatureAddrEvents(addr []byte, height int) (int64, error) {
+	var value int64
+	err := e.readGet(&value, `
+SELECT COALESCE(SUM(e.value), 0)::BIGINT
+  FROM address_events e
+  JOIN block_txs bt ON bt.tx_id = e.tx_id AND bt.n = 0
+ WHERE e.addr = $1
+   AND NOT e.spending
+   AND e.height <= $2
+   AND $2 - e.height + 1 < $3`, addr, height, core.CoinbaseMaturity)
+	return value, err
+}
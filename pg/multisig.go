@@ -0,0 +1,84 @@
+package pg
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/blkchain/blkchain/core"
+	"github.com/lib/pq"
+)
+
+// The "multisig" parser decomposes the multisig scripts into the
+// multisig table: m, n and the public keys of every bare multisig
+// output, and of every P2SH, P2WSH or P2SH-P2WSH multisig redeem or
+// witness script at the time it is revealed by the input spending it
+// (see core.TxIn.RevealedScript). A row is either an output or an
+// input of the tx, as the type tells. E.g. the adoption of m-of-n:
+//
+//   SELECT m, n_keys, COUNT(1) FROM multisig GROUP BY 1, 2 ORDER BY 3 DESC;
+//
+// The keys are indexed, so finding the multisigs a key is part of is
+// pubkeys @> ARRAY['\x02...'::BYTEA]. Data hidden in the keys of bare
+// multisig outputs (Counterparty, Stamps) is there as well.
+
+type multisigParser struct {
+	rows [][]interface{}
+}
+
+func (p *multisigParser) Start(db *sql.DB) error {
+	if db == nil {
+		return nil
+	}
+	_, err := db.Exec(`
+  CREATE TABLE IF NOT EXISTS multisig (
+   tx_id          BIGINT NOT NULL
+  ,n              SMALLINT NOT NULL -- of the output or the input, see type
+  ,type           TEXT NOT NULL -- multisig (an output), p2sh, p2wsh or p2sh-p2wsh (an input)
+  ,m              SMALLINT NOT NULL
+  ,n_keys         SMALLINT NOT NULL
+  ,pubkeys        BYTEA[] NOT NULL
+  );
+  CREATE INDEX IF NOT EXISTS multisig_tx_id_idx ON multisig(tx_id);
+  CREATE INDEX IF NOT EXISTS multisig_pubkeys_idx ON multisig USING gin(pubkeys);
+`)
+	return err
+}
+
+func (p *multisigParser) ParseTx(height, n int, txId int64, tx *core.Tx) {
+	if n > 0 {
+		for i, in := range tx.TxIns {
+			script, kind := in.RevealedScript()
+			if script == nil {
+				continue
+			}
+			if m, keys, ok := core.ParseMultisig(script); ok {
+				p.rows = append(p.rows, []interface{}{txId, i, kind, m, len(keys), pq.ByteaArray(keys)})
+			}
+		}
+	}
+	for i, out := range tx.TxOuts {
+		if m, keys, ok := core.ParseMultisig(out.ScriptPubKey); ok {
+			p.rows = append(p.rows, []interface{}{txId, i, "multisig", m, len(keys), pq.ByteaArray(keys)})
+		}
+	}
+}
+
+func (p *multisigParser) Flush(db *sql.DB) error {
+	if db == nil || len(p.rows) == 0 {
+		p.rows = nil
+		return nil
+	}
+	txn, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := copyRows(txn, "multisig", []string{"tx_id", "n", "type", "m", "n_keys", "pubkeys"}, p.rows); err != nil {
+		txn.Rollback()
+		return fmt.Errorf("multisig: %v", err)
+	}
+	if err := txn.Commit(); err != nil {
+		return err
+	}
+	p.rows = nil
+	return nil
+}
@@ -0,0 +1,227 @@
+package pg
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/blkchain/blkchain/core"
+)
+
+// The NDJSON output mode, instead of Postgres: one JSON object per line
+// for each block, with its transactions in "txs", or, per tx, a block
+// object without "txs" followed by one object for each of its
+// transactions. See the README for the schema. Hashes are hex in the
+// usual (reversed) display order, scripts and witness items plain hex.
+
+type NDJSONWriter struct {
+	f      *os.File
+	w      *bufio.Writer
+	enc    *json.Encoder
+	perTx  bool
+	start  time.Time
+	recent *recentBlocks
+}
+
+type ndjsonBlock struct {
+	Type       string       `json:"type"` // "block"
+	Height     *int         `json:"height"`
+	Hash       core.Uint256 `json:"hash"`
+	Version    int32        `json:"version"`
+	PrevHash   core.Uint256 `json:"prevhash"`
+	MerkleRoot core.Uint256 `json:"merkleroot"`
+	Time       uint32       `json:"time"`
+	Bits       uint32       `json:"bits"`
+	Nonce      uint32       `json:"nonce"`
+	Size       int          `json:"size"`
+	BaseSize   int          `json:"base_size"`
+	Weight     int          `json:"weight"`
+	VirtSize   int          `json:"virt_size"`
+	NTx        int          `json:"n_tx"`
+	Txs        []*ndjsonTx  `json:"txs,omitempty"`
+}
+
+type ndjsonTx struct {
+	Type      string        `json:"type,omitempty"` // "tx" per tx
+	Height    *int          `json:"height,omitempty"`
+	BlockHash *core.Uint256 `json:"block_hash,omitempty"`
+	N         int           `json:"n"` // position within block
+	TxId      core.Uint256  `json:"txid"`
+	Version   int32         `json:"version"`
+	LockTime  uint32        `json:"locktime"`
+	Size      int           `json:"size"`
+	BaseSize  int           `json:"base_size"`
+	Weight    int           `json:"weight"`
+	VirtSize  int           `json:"virt_size"`
+	Inputs    []ndjsonTxIn  `json:"inputs"`
+	Outputs   []ndjsonTxOut `json:"outputs"`
+}
+
+type ndjsonTxIn struct {
+	N         int          `json:"n"`
+	PrevHash  core.Uint256 `json:"prevout_hash"`
+	PrevN     uint32       `json:"prevout_n"`
+	ScriptSig string       `json:"scriptsig"`
+	Sequence  uint32       `json:"sequence"`
+	Witness   []string     `json:"witness,omitempty"`
+}
+
+type ndjsonTxOut struct {
+	N            int    `json:"n"`
+	Value        int64  `json:"value"`
+	ScriptPubKey string `json:"scriptpubkey"`
+}
+
+// NewNDJSONWriter writes to the file path ("-" is stdout), with an
+// object per tx rather than per block if perTx.
+func NewNDJSONWriter(path string, perTx bool) (*NDJSONWriter, error) {
+	w := &NDJSONWriter{
+		perTx:  perTx,
+		start:  time.Now(),
+		recent: newRecentBlocks(),
+	}
+	if path == "-" {
+		w.w = bufio.NewWriter(os.Stdout)
+	} else {
+		f, err := os.Create(path)
+		if err != nil {
+			return nil, err
+		}
+		w.f = f
+		w.w = bufio.NewWriter(f)
+	}
+	w.enc = json.NewEncoder(w.w)
+	return w, nil
+}
+
+// WriteBlock writes the block, flushing the output if sync.
+func (w *NDJSONWriter) WriteBlock(b *BlockRec, sync bool) error {
+	height := b.Height
+	if height < 0 {
+		if h, ok := w.recent.heightOf(b.PrevHash); ok {
+			height = h + 1
+		}
+	}
+
+	rec, txs := newNDJSONBlock(b.Block, height)
+	if !w.perTx {
+		rec.Txs = txs
+	}
+	if err := w.enc.Encode(rec); err != nil {
+		return err
+	}
+	if w.perTx {
+		for _, tx := range txs {
+			tx.Type, tx.Height, tx.BlockHash = "tx", rec.Height, &rec.Hash
+			if err := w.enc.Encode(tx); err != nil {
+				return err
+			}
+		}
+	}
+
+	if height >= 0 {
+		w.recent.add(height, rec.Hash)
+	}
+	if sync {
+		return w.w.Flush()
+	}
+	return nil
+}
+
+// newNDJSONBlock returns the block object without its transactions,
+// which are returned separately, height -1 is unknown.
+func newNDJSONBlock(b *core.Block, height int) (*ndjsonBlock, []*ndjsonTx) {
+	rec := &ndjsonBlock{
+		Type:       "block",
+		Hash:       b.Hash(),
+		Version:    int32(b.Version),
+		PrevHash:   b.PrevHash,
+		MerkleRoot: b.HashMerkleRoot,
+		Time:       uint32(b.Time),
+		Bits:       uint32(b.Bits),
+		Nonce:      uint32(b.Nonce),
+		NTx:        len(b.Txs),
+	}
+	if len(b.Txs) > 0 { // not headers only
+		rec.Size, rec.BaseSize, rec.Weight, rec.VirtSize = b.Size(), b.BaseSize(), b.Weight(), b.VirtualSize()
+	}
+	if height >= 0 {
+		rec.Height = &height
+	}
+
+	txs := make([]*ndjsonTx, 0, len(b.Txs))
+	for n, tx := range b.Txs {
+		txs = append(txs, newNDJSONTx(n, tx))
+	}
+	return rec, txs
+}
+
+func newNDJSONTx(n int, tx *core.Tx) *ndjsonTx {
+	rec := &ndjsonTx{
+		N:        n,
+		TxId:     tx.Hash(),
+		Version:  int32(tx.Version),
+		LockTime: tx.LockTime,
+		Size:     tx.Size(),
+		BaseSize: tx.BaseSize(),
+		Weight:   tx.Weight(),
+		VirtSize: tx.VirtualSize(),
+		Inputs:   make([]ndjsonTxIn, len(tx.TxIns)),
+		Outputs:  make([]ndjsonTxOut, len(tx.TxOuts)),
+	}
+	for i, in := range tx.TxIns {
+		rec.Inputs[i] = ndjsonTxIn{
+			N:         i,
+			PrevHash:  in.PrevOut.Hash,
+			PrevN:     in.PrevOut.N,
+			ScriptSig: hex.EncodeToString(in.ScriptSig),
+			Sequence:  in.Sequence,
+		}
+		for _, item := range in.Witness {
+			rec.Inputs[i].Witness = append(rec.Inputs[i].Witness, hex.EncodeToString(item))
+		}
+	}
+	for i, out := range tx.TxOuts {
+		rec.Outputs[i] = ndjsonTxOut{
+			N:            i,
+			Value:        out.Value,
+			ScriptPubKey: hex.EncodeToString(out.ScriptPubKey),
+		}
+	}
+	return rec
+}
+
+// HeightAndHashes returns the last blocks written by this writer, the
+// output is not read back, so this is empty at the start.
+func (w *NDJSONWriter) HeightAndHashes(back int) (map[int][]core.Uint256, error) {
+	return w.recent.last(back), nil
+}
+
+func (w *NDJSONWriter) Ping() error {
+	return nil
+}
+
+func (w *NDJSONWriter) HasBlock(hash core.Uint256) (bool, error) {
+	_, ok := w.recent.heightOf(hash)
+	return ok, nil
+}
+
+func (w *NDJSONWriter) Close() error {
+	err := w.w.Flush()
+	if err != nil {
+		err = fmt.Errorf("Error writing NDJSON: %v", err)
+	}
+	if w.f != nil {
+		if cerr := w.f.Close(); cerr != nil && err == nil {
+			err = fmt.Errorf("Error closing NDJSON file: %v", cerr)
+		}
+	}
+	return err
+}
+
+func (w *NDJSONWriter) Uptime() time.Duration {
+	return time.Now().Sub(w.start)
+}
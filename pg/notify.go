@@ -0,0 +1,127 @@
+package pg
+
+import (
+	"database/sql"
+	"encoding/hex"
+	"log"
+	"time"
+
+	"github.com/blkchain/blkchain/core"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// New block notifications, for pushing them to clients of the server
+// (see serve/push.go): while following the chain, the import sends the
+// hash of the main chain tip with NOTIFY new_block after each new block
+// (see NotifyNewBlock), and the server LISTENs for it. The payload is
+// the hash only, so a server of another chain (schema) in the same
+// database does not find the block and ignores it.
+
+const newBlockChannel = "new_block"
+
+type NewBlock struct {
+	Hash   core.Uint256 `db:"-"`
+	Height int          `db:"height"`
+	Time   int64        `db:"time"`
+	NTx    int          `db:"n_tx"`
+}
+
+// The changes to watched addresses in a new block, see
+// SelectBlockAddrEvents.
+type BlockAddrEvent struct {
+	Addr     []byte // as returned by extract_address()
+	Txid     core.Uint256
+	N        int // txin n if spending, txout n otherwise
+	Spending bool
+	Value    int64 // negative if spending
+}
+
+// NotifyNewBlock notifies the listeners of the main chain tip, to be
+// called after SetOrphans().
+func (w *PGWriter) NotifyNewBlock() error {
+	if w.db == nil {
+		return nil
+	}
+	_, err := w.db.Exec(`
+SELECT pg_notify($1, (SELECT encode(hash, 'hex') FROM blocks WHERE NOT orphan ORDER BY height DESC LIMIT 1))`, newBlockChannel)
+	return err
+}
+
+// ListenNewBlocks calls fn with every new block notified, reconnecting
+// as needed. It does not return unless listening fails at the start.
+func (e *Explorer) ListenNewBlocks(fn func(*NewBlock)) error {
+	l := pq.NewListener(e.connstr, 10*time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("New block listener: %v", err)
+		}
+	})
+	if err := l.Listen(newBlockChannel); err != nil {
+		l.Close()
+		return err
+	}
+	for n := range l.Notify {
+		if n == nil { // reconnected, notifications may have been lost
+			continue
+		}
+		b, err := hex.DecodeString(n.Extra)
+		if err != nil || len(b) != 32 {
+			log.Printf("Invalid new block notification %q", n.Extra)
+			continue
+		}
+		nb := &NewBlock{Hash: core.Uint256FromBytes(b)}
+		// Not from a replica, which may not have the block yet
+		if err := e.db.Get(nb, `
+SELECT b.height, uint32(b.time) AS time, (SELECT COUNT(1) FROM block_txs bt WHERE bt.block_id = b.id) AS n_tx
+  FROM blocks b
+ WHERE b.hash = $1`, b); err != nil {
+			if err != sql.ErrNoRows {
+				log.Printf("Error reading new block %v: %v", nb.Hash, err)
+			}
+			continue
+		}
+		fn(nb)
+	}
+	return nil
+}
+
+// SelectBlockAddrEvents returns the outputs to and the spends from the
+// addresses (see AddressKey) in the block.
+func (e *Explorer) SelectBlockAddrEvents(hash core.Uint256, addrs [][]byte) ([]*BlockAddrEvent, error) {
+	var events []*BlockAddrEvent
+	err := e.read(func(db *sqlx.DB) error {
+		rows, err := db.Query(`
+SELECT e.addr, t.txid, e.n, e.spending, e.value
+  FROM (
+    SELECT extract_address(o.scriptpubkey) AS addr, o.tx_id, o.n, false AS spending, o.value
+      FROM blocks b
+      JOIN block_txs bt ON bt.block_id = b.id
+      JOIN txouts_v o ON o.tx_id = bt.tx_id
+     WHERE b.hash = $1
+    UNION ALL
+    SELECT extract_address(po.scriptpubkey) AS addr, i.tx_id, i.n, true AS spending, -po.value
+      FROM blocks b
+      JOIN block_txs bt ON bt.block_id = b.id
+      JOIN txins i ON i.tx_id = bt.tx_id
+      JOIN txouts_v po ON po.tx_id = i.prevout_tx_id AND po.n = i.prevout_n
+     WHERE b.hash = $1
+  ) e
+  JOIN txs t ON t.id = e.tx_id
+ WHERE e.addr = ANY($2)
+ ORDER BY e.tx_id, e.spending DESC, e.n`, hash[:], pq.ByteaArray(addrs))
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		events = nil
+		for rows.Next() {
+			ev := &BlockAddrEvent{}
+			if err := rows.Scan(&ev.Addr, &ev.Txid, &ev.N, &ev.Spending, &ev.Value); err != nil {
+				return err
+			}
+			events = append(events, ev)
+		}
+		return rows.Err()
+	})
+	return events, err
+}
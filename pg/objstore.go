@@ -0,0 +1,532 @@
+package pg
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Export outputs written directly to object storage, at URLs
+// s3://bucket/key (AWS S3 or compatible, e.g. MinIO) or gs://bucket/key
+// (Google Cloud Storage, by its XML API), with the credentials of the
+// environment as the SDKs find them:
+//
+//  - s3://: AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and (optional)
+//    AWS_SESSION_TOKEN, the region in AWS_REGION or AWS_DEFAULT_REGION
+//    (default us-east-1), and AWS_ENDPOINT_URL for another service than
+//    AWS, e.g. http://localhost:9000 for MinIO (path-style).
+//  - gs://: the service account key file in
+//    GOOGLE_APPLICATION_CREDENTIALS, or else the metadata server of the
+//    instance.
+//
+// Objects are uploaded in parts of ObjectPartSize as they are written
+// (multipart upload), small ones with a single PUT. Each request is
+// retried with a backoff on network errors and 5xx or 429 responses,
+// and an upload which fails is aborted, leaving no partial object.
+
+const ObjectPartSize = 16 << 20 // the minimum is 5 MiB
+
+const (
+	objectRetries = 5
+	objectBackoff = time.Second // doubled on each retry
+)
+
+var objectClient = &http.Client{Timeout: 5 * time.Minute}
+
+// IsObjectURL tells whether the output path is an object storage URL.
+func IsObjectURL(path string) bool {
+	return strings.HasPrefix(path, "s3://") || strings.HasPrefix(path, "gs://")
+}
+
+// JoinOutput returns the path or object URL of name in the directory
+// or prefix dir.
+func JoinOutput(dir, name string) string {
+	if IsObjectURL(dir) {
+		return strings.TrimSuffix(dir, "/") + "/" + name
+	}
+	return filepath.Join(dir, name)
+}
+
+// CreateOutput creates the file, or the object at the URL, which must
+// be closed for it to exist.
+func CreateOutput(path string) (io.WriteCloser, error) {
+	if IsObjectURL(path) {
+		return CreateObject(path)
+	}
+	return os.Create(path)
+}
+
+type objectStore struct {
+	bucket string
+	gcs    bool
+	base   string // URL of the bucket, ending with /
+	region string
+}
+
+func parseObjectURL(objURL string) (*objectStore, string, error) {
+	u, err := url.Parse(objURL)
+	if err != nil {
+		return nil, "", err
+	}
+	key := strings.TrimPrefix(u.Path, "/")
+	if u.Host == "" || key == "" {
+		return nil, "", fmt.Errorf("Invalid object URL %q, expected %s://bucket/key", objURL, u.Scheme)
+	}
+	s := &objectStore{bucket: u.Host}
+	switch u.Scheme {
+	case "gs":
+		s.gcs, s.base = true, "https://storage.googleapis.com/"+u.Host+"/"
+	case "s3":
+		if s.region = os.Getenv("AWS_REGION"); s.region == "" {
+			if s.region = os.Getenv("AWS_DEFAULT_REGION"); s.region == "" {
+				s.region = "us-east-1"
+			}
+		}
+		if endpoint := os.Getenv("AWS_ENDPOINT_URL"); endpoint != "" {
+			s.base = strings.TrimSuffix(endpoint, "/") + "/" + u.Host + "/"
+		} else {
+			s.base = "https://" + u.Host + ".s3." + s.region + ".amazonaws.com/"
+		}
+	default:
+		return nil, "", fmt.Errorf("Unsupported object URL scheme: %q", u.Scheme)
+	}
+	return s, key, nil
+}
+
+// objectPath escapes the key as SigV4 wants it, each segment once.
+func objectPath(key string) string {
+	segs := strings.Split(key, "/")
+	for i, s := range segs {
+		segs[i] = awsEscape(s)
+	}
+	return strings.Join(segs, "/")
+}
+
+// objectError is a response of the storage other than a 2xx.
+type objectError struct {
+	status int
+	body   string
+}
+
+func (e *objectError) Error() string {
+	return fmt.Sprintf("Object storage: %d %s: %s", e.status, http.StatusText(e.status), e.body)
+}
+
+// do makes the request of the object with the query (keys sorted, no
+// escaping needed), retrying, and returns the body and the header of
+// the response.
+func (s *objectStore) do(method, key, query string, body []byte) ([]byte, http.Header, error) {
+	backoff := objectBackoff
+	for attempt := 1; ; attempt++ {
+		resp, header, err := s.try(method, key, query, body)
+		if err == nil {
+			return resp, header, nil
+		}
+		if oe, ok := err.(*objectError); ok && oe.status < 500 && oe.status != http.StatusTooManyRequests {
+			return nil, nil, err
+		}
+		if attempt == objectRetries {
+			return nil, nil, err
+		}
+		log.Printf("Retrying %s %s in %s: %v", method, key, backoff, err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func (s *objectStore) try(method, key, query string, body []byte) ([]byte, http.Header, error) {
+	rawURL := s.base + objectPath(key)
+	if query != "" {
+		rawURL += "?" + query
+	}
+	req, err := http.NewRequest(method, rawURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, err
+	}
+	if s.gcs {
+		token, err := gcsAccessToken()
+		if err != nil {
+			return nil, nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	} else {
+		hash := sha256.Sum256(body)
+		if err := signV4(req, hex.EncodeToString(hash[:]), s.region, "s3", time.Now()); err != nil {
+			return nil, nil, err
+		}
+	}
+	resp, err := objectClient.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		if len(b) > 512 {
+			b = b[:512]
+		}
+		return nil, nil, &objectError{resp.StatusCode, strings.TrimSpace(string(b))}
+	}
+	return b, resp.Header, nil
+}
+
+// signV4 signs the request (AWS Signature Version 4) with the
+// credentials of the environment, the signed headers being the host
+// and those of the request so far.
+func signV4(req *http.Request, payloadHash, region, service string, now time.Time) error {
+	accessKey, secretKey := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY")
+	if accessKey == "" || secretKey == "" {
+		return fmt.Errorf("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set for s3://")
+	}
+
+	now = now.UTC()
+	date, amzDate := now.Format("20060102"), now.Format("20060102T150405Z")
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if token := os.Getenv("AWS_SESSION_TOKEN"); token != "" {
+		req.Header.Set("X-Amz-Security-Token", token)
+	}
+
+	values := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		values[strings.ToLower(k)] = strings.TrimSpace(strings.Join(v, ","))
+	}
+	names := make([]string, 0, len(values))
+	for k := range values {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var headers strings.Builder
+	for _, k := range names {
+		headers.WriteString(k + ":" + values[k] + "\n")
+	}
+	signed := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method, req.URL.EscapedPath(), req.URL.RawQuery, headers.String(), signed, payloadHash,
+	}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256", amzDate, scope, hex.EncodeToString(requestHash[:]),
+	}, "\n")
+
+	key := []byte("AWS4" + secretKey)
+	for _, s := range []string{date, region, service, "aws4_request"} {
+		key = hmacSha256(key, s)
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKey, scope, signed, hex.EncodeToString(hmacSha256(key, stringToSign))))
+	return nil
+}
+
+// The OAuth access token for GCS, until it expires.
+var gcsToken struct {
+	sync.Mutex
+	token   string
+	expires time.Time
+}
+
+type gcsTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+func gcsAccessToken() (string, error) {
+	gcsToken.Lock()
+	defer gcsToken.Unlock()
+	if gcsToken.token != "" && time.Now().Before(gcsToken.expires) {
+		return gcsToken.token, nil
+	}
+
+	var req *http.Request
+	if path := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"); path != "" {
+		assertion, tokenURI, err := gcsAssertion(path, time.Now())
+		if err != nil {
+			return "", err
+		}
+		form := url.Values{
+			"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+			"assertion":  {assertion},
+		}
+		if req, err = http.NewRequest("POST", tokenURI, strings.NewReader(form.Encode())); err != nil {
+			return "", err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		var err error
+		req, err = http.NewRequest("GET", "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token", nil)
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("Metadata-Flavor", "Google")
+	}
+	resp, err := objectClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("Getting a GCS access token (set GOOGLE_APPLICATION_CREDENTIALS?): %v", err)
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Getting a GCS access token: %s: %s", resp.Status, strings.TrimSpace(string(b)))
+	}
+	var tr gcsTokenResponse
+	if err := json.Unmarshal(b, &tr); err != nil {
+		return "", err
+	}
+	gcsToken.token = tr.AccessToken
+	gcsToken.expires = time.Now().Add(time.Duration(tr.ExpiresIn)*time.Second - time.Minute)
+	return gcsToken.token, nil
+}
+
+type gcsServiceAccount struct {
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+}
+
+// gcsAssertion returns the signed JWT of the service account key file
+// to exchange for an access token, and where.
+func gcsAssertion(path string, now time.Time) (string, string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", "", err
+	}
+	var sa gcsServiceAccount
+	if err := json.Unmarshal(b, &sa); err != nil {
+		return "", "", fmt.Errorf("Invalid service account key %s: %v", path, err)
+	}
+	if sa.TokenURI == "" {
+		sa.TokenURI = "https://oauth2.googleapis.com/token"
+	}
+	block, _ := pem.Decode([]byte(sa.PrivateKey))
+	if block == nil {
+		return "", "", fmt.Errorf("No private key in %s", path)
+	}
+	var key *rsa.PrivateKey
+	if k, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		var ok bool
+		if key, ok = k.(*rsa.PrivateKey); !ok {
+			return "", "", fmt.Errorf("Not an RSA private key in %s", path)
+		}
+	} else if key, err = x509.ParsePKCS1PrivateKey(block.Bytes); err != nil {
+		return "", "", fmt.Errorf("Invalid private key in %s: %v", path, err)
+	}
+
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	claims, _ := json.Marshal(map[string]interface{}{
+		"iss":   sa.ClientEmail,
+		"scope": "https://www.googleapis.com/auth/devstorage.read_write",
+		"aud":   sa.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	enc := base64.RawURLEncoding
+	unsigned := enc.EncodeToString(header) + "." + enc.EncodeToString(claims)
+	hash := sha256.Sum256([]byte(unsigned))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hash[:])
+	if err != nil {
+		return "", "", err
+	}
+	return unsigned + "." + enc.EncodeToString(sig), sa.TokenURI, nil
+}
+
+// ReadObject returns the content of the object, os.ErrNotExist if there
+// is none.
+func ReadObject(objURL string) ([]byte, error) {
+	s, key, err := parseObjectURL(objURL)
+	if err != nil {
+		return nil, err
+	}
+	b, _, err := s.do("GET", key, "", nil)
+	if oe, ok := err.(*objectError); ok && oe.status == http.StatusNotFound {
+		return nil, os.ErrNotExist
+	}
+	return b, err
+}
+
+// objectWriter uploads an object as it is written.
+type objectWriter struct {
+	s        *objectStore
+	key      string
+	buf      []byte
+	uploadId string
+	etags    []string // of the parts uploaded
+	err      error
+}
+
+// CreateObject returns a writer of the object at the URL, which exists
+// once the writer is closed without error.
+func CreateObject(objURL string) (io.WriteCloser, error) {
+	return newObjectWriter(objURL)
+}
+
+func newObjectWriter(objURL string) (*objectWriter, error) {
+	s, key, err := parseObjectURL(objURL)
+	if err != nil {
+		return nil, err
+	}
+	if !s.gcs {
+		if os.Getenv("AWS_ACCESS_KEY_ID") == "" || os.Getenv("AWS_SECRET_ACCESS_KEY") == "" {
+			return nil, fmt.Errorf("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set for s3://")
+		}
+	}
+	return &objectWriter{s: s, key: key}, nil
+}
+
+func (w *objectWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	w.buf = append(w.buf, p...)
+	for len(w.buf) >= ObjectPartSize {
+		if w.err = w.uploadPart(w.buf[:ObjectPartSize]); w.err != nil {
+			return 0, w.err
+		}
+		w.buf = append(w.buf[:0], w.buf[ObjectPartSize:]...)
+	}
+	return len(p), nil
+}
+
+type initiateMultipartUploadResult struct {
+	UploadId string
+}
+
+type completeMultipartUpload struct {
+	XMLName xml.Name `xml:"CompleteMultipartUpload"`
+	Parts   []completePart
+}
+
+type completePart struct {
+	XMLName    xml.Name `xml:"Part"`
+	PartNumber int
+	ETag       string
+}
+
+func (w *objectWriter) uploadPart(part []byte) error {
+	if w.uploadId == "" {
+		b, _, err := w.s.do("POST", w.key, "uploads=", nil)
+		if err != nil {
+			return err
+		}
+		var r initiateMultipartUploadResult
+		if err := xml.Unmarshal(b, &r); err != nil || r.UploadId == "" {
+			return fmt.Errorf("Invalid multipart upload response: %q", b)
+		}
+		w.uploadId = r.UploadId
+	}
+	query := "partNumber=" + strconv.Itoa(len(w.etags)+1) + "&uploadId=" + awsEscape(w.uploadId)
+	_, header, err := w.s.do("PUT", w.key, query, part)
+	if err != nil {
+		return err
+	}
+	w.etags = append(w.etags, header.Get("ETag"))
+	return nil
+}
+
+func (w *objectWriter) Close() error {
+	if w.err == nil {
+		w.err = w.finish()
+	}
+	if w.err != nil && w.uploadId != "" {
+		if _, _, err := w.s.do("DELETE", w.key, "uploadId="+awsEscape(w.uploadId), nil); err != nil {
+			log.Printf("Error aborting the upload of %s: %v", w.key, err)
+		}
+		w.uploadId = ""
+	}
+	return w.err
+}
+
+func (w *objectWriter) finish() error {
+	if w.uploadId == "" {
+		_, _, err := w.s.do("PUT", w.key, "", w.buf)
+		return err
+	}
+	if len(w.buf) > 0 {
+		if err := w.uploadPart(w.buf); err != nil {
+			return err
+		}
+	}
+	complete := completeMultipartUpload{}
+	for i, etag := range w.etags {
+		complete.Parts = append(complete.Parts, completePart{PartNumber: i + 1, ETag: etag})
+	}
+	body, err := xml.Marshal(complete)
+	if err != nil {
+		return err
+	}
+	b, _, err := w.s.do("POST", w.key, "uploadId="+awsEscape(w.uploadId), body)
+	if err != nil {
+		return err
+	}
+	// S3 can fail the completion with a 200.
+	if bytes.Contains(b, []byte("<Error>")) {
+		return fmt.Errorf("Completing the upload of %s: %s", w.key, b)
+	}
+	w.uploadId = ""
+	return nil
+}
+
+// UploadFile uploads the file to the object at the URL.
+func UploadFile(path, objURL string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w, err := newObjectWriter(objURL)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, f); err != nil && w.err == nil {
+		w.err = err // not to complete the upload
+	}
+	return w.Close()
+}
+
+// UploadDir uploads the files in dir and its subdirectories under the
+// prefix at the URL, returning how many.
+func UploadDir(dir, objURL string) (int, error) {
+	n := 0
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if err := UploadFile(path, JoinOutput(objURL, filepath.ToSlash(rel))); err != nil {
+			return fmt.Errorf("Uploading %s: %v", rel, err)
+		}
+		n++
+		return nil
+	})
+	return n, err
+}
@@ -0,0 +1,117 @@
+package pg
+
+import (
+	"bytes"
+	"crypto/rc4"
+	"database/sql"
+	"fmt"
+
+	"github.com/blkchain/blkchain/core"
+)
+
+// The "opreturn" parser records the data of every OP_RETURN output in
+// the op_returns table, along with the protocol it belongs to when it
+// can tell:
+//
+//   runes         OP_RETURN OP_13 (see core/runestone.go)
+//   omni          "omni" prefix (Omni Layer, e.g. USDT)
+//   counterparty  "CNTRPRTY" prefix once ARC4 decrypted with the txid
+//                 of the first input as the key
+//
+// Counterparty also encodes data in multisig outputs (as do Stamps),
+// those are not recognized here.
+
+var (
+	omniPrefix         = []byte("omni")
+	counterpartyPrefix = []byte("CNTRPRTY")
+)
+
+type opReturnParser struct {
+	rows [][]interface{}
+}
+
+func newOpReturnParser() *opReturnParser {
+	return &opReturnParser{}
+}
+
+func (p *opReturnParser) Start(db *sql.DB) error {
+	if db == nil {
+		return nil
+	}
+	_, err := db.Exec(`
+  CREATE TABLE IF NOT EXISTS op_returns (
+   tx_id          BIGINT NOT NULL
+  ,n              SMALLINT NOT NULL
+  ,protocol       TEXT
+  ,data           BYTEA NOT NULL -- the pushes, concatenated
+  );
+  CREATE INDEX IF NOT EXISTS op_returns_tx_id_idx ON op_returns(tx_id);
+  CREATE INDEX IF NOT EXISTS op_returns_protocol_idx ON op_returns(protocol);
+`)
+	return err
+}
+
+func (p *opReturnParser) ParseTx(height, n int, txId int64, tx *core.Tx) {
+	for i, out := range tx.TxOuts {
+		s := out.ScriptPubKey
+		if len(s) == 0 || s[0] != core.OP_RETURN {
+			continue
+		}
+		var protocol interface{}
+		if len(s) > 1 && s[1] == core.OP_13 {
+			protocol = "runes"
+			s = s[1:] // OP_13 is not a push
+		}
+		ops, _ := core.ParseScript(s[1:])
+		data := []byte{}
+		for _, op := range ops {
+			data = append(data, op.Data...)
+		}
+		if protocol == nil {
+			protocol = opReturnProtocol(tx, data)
+		}
+		p.rows = append(p.rows, []interface{}{txId, i, protocol, data})
+	}
+}
+
+func opReturnProtocol(tx *core.Tx, data []byte) interface{} {
+	if bytes.HasPrefix(data, omniPrefix) {
+		return "omni"
+	}
+	if len(data) >= len(counterpartyPrefix) && len(tx.TxIns) > 0 {
+		// The key is the txid in display (reversed) byte order
+		hash := tx.TxIns[0].PrevOut.Hash
+		key := make([]byte, len(hash))
+		for i := range hash {
+			key[i] = hash[len(hash)-1-i]
+		}
+		if c, err := rc4.NewCipher(key); err == nil {
+			prefix := make([]byte, len(counterpartyPrefix))
+			c.XORKeyStream(prefix, data[:len(prefix)])
+			if bytes.Equal(prefix, counterpartyPrefix) {
+				return "counterparty"
+			}
+		}
+	}
+	return nil
+}
+
+func (p *opReturnParser) Flush(db *sql.DB) error {
+	if db == nil || len(p.rows) == 0 {
+		p.rows = nil
+		return nil
+	}
+	txn, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := copyRows(txn, "op_returns", []string{"tx_id", "n", "protocol", "data"}, p.rows); err != nil {
+		txn.Rollback()
+		return fmt.Errorf("op_returns: %v", err)
+	}
+	if err := txn.Commit(); err != nil {
+		return err
+	}
+	p.rows = nil
+	return nil
+}
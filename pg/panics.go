@@ -0,0 +1,89 @@
+package pg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"sync"
+)
+
+// What a panic in one of the goroutines of the PGWriter (the block
+// worker, the table writers and the shard routers) does.
+const (
+	// The panic is logged with its stack and becomes the error of the
+	// import: the other goroutines commit what they have and finish,
+	// WriteBlock and Close return the error. The default.
+	PanicRecover = "recover"
+	// The panic is not recovered, the program crashes with the stack
+	// of the goroutine, for a core dump or a debugger.
+	PanicCrash = "crash"
+)
+
+// ErrWriterStopped is returned by WriteBlock once the PGWriter has
+// failed, Close returns the reason.
+var ErrWriterStopped = errors.New("Writer stopped")
+
+func checkPanicPolicy(policy string) error {
+	switch policy {
+	case "", PanicRecover, PanicCrash:
+		return nil
+	}
+	return fmt.Errorf("Unknown panic policy %q, must be %s or %s.", policy, PanicRecover, PanicCrash)
+}
+
+// writerFailure is the first error which stops the PGWriter, shared by
+// all its goroutines. Once it is set done is closed, so that nothing
+// waits on a goroutine which is gone. The transactions of the writers
+// are begun with ctx, cancelling it rolls them back (see
+// CloseWithTimeout).
+type writerFailure struct {
+	policy string
+	once   sync.Once
+	err    error
+	done   chan struct{}
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func newWriterFailure(policy string) *writerFailure {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &writerFailure{policy: policy, done: make(chan struct{}), ctx: ctx, cancel: cancel}
+}
+
+func (f *writerFailure) fail(err error) {
+	f.once.Do(func() {
+		f.err = err
+		close(f.done)
+	})
+}
+
+// failed returns the error, nil if there has been none.
+func (f *writerFailure) failed() error {
+	select {
+	case <-f.done:
+		return f.err
+	default:
+		return nil
+	}
+}
+
+// catch must be deferred by the goroutine called name. After a panic
+// drain takes care of the channels of the goroutine, it must consume
+// the input until it is closed, answering the commit signals, and close
+// the output channels if any.
+func (f *writerFailure) catch(name string, drain func()) {
+	if f.policy == PanicCrash {
+		return // recover() not called, the panic goes on
+	}
+	r := recover()
+	if r == nil {
+		return
+	}
+	log.Printf("PANIC in the %s goroutine: %v\n%s", name, r, debug.Stack())
+	f.fail(fmt.Errorf("Panic in the %s goroutine: %v", name, r))
+	if drain != nil {
+		drain()
+	}
+}
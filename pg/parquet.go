@@ -0,0 +1,203 @@
+package pg
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A data lake mirror of the chain in Parquet, appended to while
+// following the chain: the tables of the DuckDB export (see duckdb.go)
+// as files <dir>/<table>/day=YYYY-MM-DD/<from>-<to>.parquet, the day
+// being that of the block time (UTC) and from-to the heights, so that
+// e.g. read_parquet('<dir>/txs/*/*.parquet', hive_partitioning = true)
+// reads them and a query of a few days only opens those. There is no
+// Parquet writer here, the files are written by the duckdb CLI from
+// the CSV export of the new blocks.
+//
+// The heights exported so far are kept in <dir>/last_height, the
+// first time the mirror starts at the current tip. Only blocks at
+// least ParquetConfirmations deep are exported, for the orphans to
+// be marked by then. The directory can be an s3:// or gs:// URL (see
+// objstore.go).
+
+const ParquetConfirmations = 6
+
+// Only one append at a time, afterNewBlock may overlap.
+var parquetMu sync.Mutex
+
+// AppendParquet appends the blocks confirmed since the last time to
+// the Parquet mirror in the ParquetDir of the WriterConfig, if any.
+func (w *PGWriter) AppendParquet() error {
+	if w.db == nil || w.cfg.ParquetDir == "" {
+		return nil
+	}
+	duckdb := w.cfg.DuckDBPath
+	if duckdb == "" {
+		duckdb = "duckdb"
+	}
+	return appendParquet(w.db, w.cfg.ParquetDir, duckdb)
+}
+
+func appendParquet(db *sql.DB, dir, duckdb string) error {
+	parquetMu.Lock()
+	defer parquetMu.Unlock()
+
+	var tip int
+	if err := db.QueryRow("SELECT COALESCE(MAX(height), -1) FROM blocks WHERE NOT orphan").Scan(&tip); err != nil {
+		return err
+	}
+	tip -= ParquetConfirmations
+	if tip < 0 {
+		return nil
+	}
+
+	last, err := readParquetState(dir)
+	if os.IsNotExist(err) {
+		last = tip - 1
+		log.Printf("Starting the Parquet mirror in %s at height %d.", dir, tip)
+	} else if err != nil {
+		return err
+	}
+	if last >= tip {
+		return nil
+	}
+
+	// The runs of heights of the same day.
+	rows, err := db.Query(`
+SELECT height, time
+  FROM blocks
+ WHERE NOT orphan
+   AND height BETWEEN $1 AND $2
+ ORDER BY height`, last+1, tip)
+	if err != nil {
+		return err
+	}
+	type dayRun struct {
+		day      string
+		from, to int
+	}
+	var runs []*dayRun
+	for rows.Next() {
+		var height int
+		var t int64
+		if err := rows.Scan(&height, &t); err != nil {
+			rows.Close()
+			return err
+		}
+		day := time.Unix(t, 0).UTC().Format("2006-01-02")
+		if n := len(runs); n > 0 && runs[n-1].day == day {
+			runs[n-1].to = height
+		} else {
+			runs = append(runs, &dayRun{day, height, height})
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, r := range runs {
+		if err := appendParquetRun(db, dir, duckdb, r.day, r.from, r.to); err != nil {
+			return fmt.Errorf("Appending heights %d to %d to Parquet: %v", r.from, r.to, err)
+		}
+		if err := writeParquetState(dir, r.to); err != nil {
+			return err
+		}
+	}
+	if len(runs) > 0 {
+		log.Printf("Appended heights %d to %d to the Parquet mirror.", runs[0].from, runs[len(runs)-1].to)
+	}
+	return nil
+}
+
+// readParquetState returns the last height in the mirror,
+// os.ErrNotExist if it is new.
+func readParquetState(dir string) (int, error) {
+	var (
+		b   []byte
+		err error
+	)
+	if IsObjectURL(dir) {
+		b, err = ReadObject(JoinOutput(dir, "last_height"))
+	} else {
+		b, err = os.ReadFile(filepath.Join(dir, "last_height"))
+	}
+	if err != nil {
+		return -1, err
+	}
+	last, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		return -1, fmt.Errorf("Invalid last_height of the Parquet mirror: %v", err)
+	}
+	return last, nil
+}
+
+func writeParquetState(dir string, last int) error {
+	if !IsObjectURL(dir) {
+		return os.WriteFile(filepath.Join(dir, "last_height"), []byte(strconv.Itoa(last)+"\n"), 0644)
+	}
+	w, err := CreateObject(JoinOutput(dir, "last_height"))
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(w, last)
+	return w.Close()
+}
+
+// appendParquetRun writes the Parquet files of the heights from to to,
+// all of the day. With object storage they are written to a temporary
+// directory first, then uploaded.
+func appendParquetRun(db *sql.DB, dir, duckdb, day string, from, to int) error {
+	remote := IsObjectURL(dir)
+	parent := dir
+	if remote {
+		parent = ""
+	} else if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	tmp, err := os.MkdirTemp(parent, ".append")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	local := dir
+	if remote {
+		local = filepath.Join(tmp, "parquet")
+	}
+	var script strings.Builder
+	for _, t := range duckTables {
+		if _, err := exportDuckTable(db, tmp, t, from, to); err != nil {
+			return fmt.Errorf("Exporting %s: %v", t.name, err)
+		}
+		partition, err := filepath.Abs(filepath.Join(local, t.name, "day="+day))
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(partition, 0755); err != nil {
+			return err
+		}
+		path := filepath.Join(partition, fmt.Sprintf("%d-%d.parquet", from, to))
+		fmt.Fprintf(&script, "COPY (%s) TO '%s' (FORMAT parquet);\n", duckRead(t), strings.ReplaceAll(path, "'", "''"))
+	}
+
+	cmd := exec.Command(duckdb)
+	cmd.Dir, cmd.Stdin = tmp, strings.NewReader(script.String())
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(out)))
+	}
+	if remote {
+		if _, err := UploadDir(local, dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package pg
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+
+	"github.com/blkchain/blkchain/core"
+)
+
+// Embedded protocols (Counterparty, Omni, Runes, ...) hide their data
+// in ordinary transactions. An EmbeddedParser is given every new
+// transaction during the import and keeps whatever it finds in its own
+// tables. Parsers are registered by name, in the same manner as
+// database/sql drivers, so a third party parser only needs a package
+// which calls RegisterParser from init() and a copy of cmd/import with
+// a blank import of that package:
+//
+//   import _ "example.com/counterparty"
+//
+// and then -parsers counterparty on the command line.
+//
+// Built in are "opreturn" (see opreturn.go), "protocols" (Runes and
+// BRC-20, see protocols.go), "coinjoin" (see labels.go), "multisig"
+// (see multisig.go) and "revealed" (see revealed.go).
+
+type EmbeddedParser interface {
+	// Start is called once before the first transaction to create
+	// tables and load any state. db is nil with nulldb.
+	Start(db *sql.DB) error
+	// ParseTx is called for every new transaction in chain order, n
+	// is the position of the transaction within the block. It must
+	// not block.
+	ParseTx(height, n int, txId int64, tx *core.Tx)
+	// Flush writes out what has been collected, it is called every
+	// time the import commits and at the end. db is nil with nulldb.
+	Flush(db *sql.DB) error
+}
+
+var (
+	parsersMu sync.Mutex
+	parsers   = make(map[string]func() EmbeddedParser)
+)
+
+// RegisterParser makes a parser available by name. It panics if the
+// name is already taken.
+func RegisterParser(name string, newParser func() EmbeddedParser) {
+	parsersMu.Lock()
+	defer parsersMu.Unlock()
+	if _, dup := parsers[name]; dup {
+		panic("RegisterParser called twice for parser " + name)
+	}
+	parsers[name] = newParser
+}
+
+// ParserNames returns the sorted names of the registered parsers.
+func ParserNames() []string {
+	parsersMu.Lock()
+	defer parsersMu.Unlock()
+	names := make([]string, 0, len(parsers))
+	for name := range parsers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Returns a parser per name, a name given more than once (e.g.
+// -protocols and -parsers protocols) still gets only one, since two
+// would write everything twice.
+func newParsers(names []string) ([]EmbeddedParser, error) {
+	parsersMu.Lock()
+	defer parsersMu.Unlock()
+	result := make([]EmbeddedParser, 0, len(names))
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		newParser, ok := parsers[name]
+		if !ok {
+			return nil, fmt.Errorf("Unknown parser: %q", name)
+		}
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		result = append(result, newParser())
+	}
+	return result, nil
+}
+
+func flushParsers(parsers []EmbeddedParser, db *sql.DB) {
+	for _, p := range parsers {
+		if err := p.Flush(db); err != nil {
+			log.Printf("Error flushing parser %T: %v", p, err)
+		}
+	}
+}
+
+func init() {
+	RegisterParser("opreturn", func() EmbeddedParser { return newOpReturnParser() })
+	RegisterParser("protocols", func() EmbeddedParser { return newProtocolIndex() })
+	RegisterParser("coinjoin", func() EmbeddedParser { return &coinjoinParser{} })
+	RegisterParser("multisig", func() EmbeddedParser { return &multisigParser{} })
+	RegisterParser("revealed", func() EmbeddedParser { return &revealedParser{} })
+}
@@ -0,0 +1,2679 @@
+package pg
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/blkchain/blkchain/core"
+	"github.com/lib/pq"
+)
+
+// Explanation of how we handle integers. In Bitcoin structures most
+// integers are uint32. Postgres does not have an unsigned int type,
+// but using a bigint to store integers seems like a waste of
+// space. So we cast all uints to int32, and thus 0xFFFFFFFF would
+// become -1 in Postgres, which is fine as long as we know all the
+// bits are correct.
+
+var writerWg sync.WaitGroup
+
+type blockRecSync struct {
+	*BlockRec
+	sync chan bool
+	hw   *highWater // with a commit signal
+
+	metrics *blockMetrics // when profiling
+}
+
+type PGWriter struct {
+	blockCh chan *blockRecSync
+	wg      *sync.WaitGroup
+	db      *sql.DB
+	shards  *shardSet // nil unless sharding
+	start   time.Time
+	cfg     WriterConfig
+	fail    *writerFailure
+	columns *extraColumns // nil unless column plugins
+	upsert  bool          // INSERT ... ON CONFLICT rather than COPY (see upsert.go)
+}
+
+// WriterConfig has the PGWriter settings. Other than ConnectString,
+// the zero value of every field is the default behavior.
+type WriterConfig struct {
+	ConnectString string // 'nulldb' == /dev/null
+	Magic         uint32 // of the network, to verify its checkpoints (see core.VerifyCheckpoint), 0 = none
+	Chain         string // the schema of a chain other than mainnet (see chains.go)
+	CacheSize     int    // txid cache entries
+	ZfsDataset    string // ZFS dataset to take snapshots of (empty = no snapshots)
+
+	// Import block headers only, leaving the transactions to be
+	// imported later with Backfill. Header-only blocks have a size of
+	// 0. Since there are no outputs, no UTXO checker is needed.
+	HeadersOnly bool
+	// Fill in the transactions of header-only blocks. Blocks that
+	// are not yet in the database are imported as usual.
+	Backfill bool
+	// Apply the recommended session-level settings (see tuning.go).
+	Tune bool
+	// Turn off durability settings and triggers for the duration of
+	// the import (see unsafe.go).
+	FastUnsafe bool
+	// Store each unique scriptpubkey once in the scripts table (see
+	// scripts.go). Only takes effect on the first import.
+	DedupScripts bool
+	// Storage ("external", "main", ...) and compression ("pglz",
+	// "lz4", PG14+) of the script and witness columns (see
+	// storage.go). Empty leaves the defaults.
+	ColumnStorage     string
+	ColumnCompression string
+	// "btree" (default) or "brin" for the naturally ordered columns
+	// (see brin.go). Only takes effect on the first import.
+	IndexStrategy string
+	// Do not create the foreign keys at the end of the first import,
+	// use VerifyDatabase (cmd/verify) to validate instead.
+	NoConstraints bool
+	// Extract ord inscriptions into the inscriptions table (see
+	// inscriptions.go).
+	Inscriptions bool
+	// Index the Runes and BRC-20 protocols (see protocols.go), same
+	// as adding "protocols" to Parsers.
+	Protocols bool
+	// Called with every new block and transaction (see hooks.go).
+	Hooks []BlockHook
+	// Names of the column plugins adding columns to txs and txouts
+	// (see columns.go).
+	Columns []string
+	// Names of the embedded protocol parsers to run (see parsers.go).
+	Parsers []string
+	// Create the covering address history indexes (see history.go)
+	// at the end of the first import.
+	AddressHistory bool
+	// Create the index for searching by txid prefix (see
+	// txidsearch.go) at the end of the first import.
+	TxidPrefixIndex bool
+	// Maintain the address_events table of historical balances (see
+	// balances.go).
+	AddressEvents bool
+	// Take a UTXO snapshot (see utxosnap.go) every this many blocks,
+	// 0 = never.
+	UtxoSnapshotInterval int
+	// Prune the scripts of outputs spent more than this many blocks
+	// ago (see prune.go), 0 = never.
+	PruneDepth int
+	// Delete the txs of the blocks more than this many blocks below
+	// the tip (see retention.go), archiving them first to
+	// RetainArchive unless empty, 0 = keep everything.
+	RetainBlocks  int
+	RetainArchive string
+	// Append the blocks to the Parquet mirror in this directory while
+	// following (see parquet.go), with the duckdb CLI at DuckDBPath
+	// (default duckdb in the PATH).
+	ParquetDir string
+	DuckDBPath string
+	// Record the time each block takes to read, hash and copy per
+	// table in the import_metrics table (see profile.go), and log the
+	// blocks which take longer than ProfileSlowBlock (0 = none).
+	// Profiling is not supported with shards.
+	Profile          bool
+	ProfileSlowBlock time.Duration
+	// TLS and credentials (see credentials.go), all optional.
+	SSLMode      string // e.g. verify-full
+	SSLCert      string // client certificate file
+	SSLKey       string // client key file
+	SSLRootCert  string // CA certificate file
+	PasswordFile string // re-read for every new connection
+	AwsIamRegion string // use RDS IAM authentication tokens
+	// Write txins and txouts to these databases by tx_id (see
+	// shard.go). Only takes effect on the first import, after which
+	// the same list must always be given.
+	ShardConnectStrings []string
+	// Write the blocks as NDJSON to this file ("-" is stdout) instead
+	// of the database (see ndjson.go), an object per tx rather than
+	// per block if NDJSONTxs. Used by NewChainWriter, the other
+	// settings do not apply.
+	NDJSONPath string
+	NDJSONTxs  bool
+	// Index the blocks in Elasticsearch or OpenSearch at this URL
+	// instead of the database (see elastic.go), in the indexes
+	// <ElasticIndexPrefix>-blocks and -txs (default prefix
+	// "blkchain"), which are created with the ILM (ISM in OpenSearch)
+	// policy ElasticILMPolicy if not empty. Used by NewChainWriter,
+	// the other settings do not apply.
+	ElasticURL         string
+	ElasticIndexPrefix string
+	ElasticILMPolicy   string
+	// What a panic in a writer goroutine does, PanicRecover (default)
+	// or PanicCrash (see panics.go).
+	PanicPolicy string
+	// Close gives up after this long (see closetimeout.go), 0 = never.
+	CloseTimeout time.Duration
+	// VACUUM ANALYZE rather than only ANALYZE after the bulk phases
+	// of the first import (see analyze.go), and vacuum txins while
+	// fixing prevout_tx_id (see fixprevout.go).
+	Vacuum bool
+	// Fix the prevout_tx_id misses at the end of the first import
+	// with this many workers (see fixprevout.go), 0 =
+	// DefaultFixPrevoutWorkers.
+	FixPrevoutWorkers int
+	// After the first import, keep a bloom filter of the txids at
+	// this many bits per txid (see txidfilter.go), 0 = none.
+	TxidFilterBits int
+	// After the first import, INSERT ... ON CONFLICT DO NOTHING
+	// rather than COPY (see upsert.go).
+	Upsert bool
+	// Send no more than this many rows or bytes of blocks per second
+	// to the writers (see throttle.go), 0 = no limit.
+	ThrottleRows  int
+	ThrottleBytes int64
+	// Write the txid cache report (see cachereport.go) to this file
+	// at the end of the import, empty = none.
+	CacheReportPath string
+	// Write the value of the output spent in txins.input_value (see
+	// inputvalues.go). Set if the column exists. Not supported with
+	// shards.
+	InputValues bool
+	// Write the payload of the scriptPubKey in txouts.script_payload
+	// (see scriptpayload.go). Set if the column exists. Not supported
+	// with shards.
+	ScriptPayloads bool
+}
+
+type isUTXOer interface {
+	IsUTXO(core.Uint256, uint32) (bool, error)
+}
+
+func NewPGWriter(connstr string, cacheSize int, utxo isUTXOer, zfsDataset string) (*PGWriter, error) {
+	return NewPGWriterConfig(WriterConfig{
+		ConnectString: connstr,
+		CacheSize:     cacheSize,
+		ZfsDataset:    zfsDataset,
+	}, utxo)
+}
+
+func NewPGWriterConfig(cfg WriterConfig, utxo isUTXOer) (*PGWriter, error) {
+
+	start := time.Now()
+
+	var (
+		wg sync.WaitGroup
+
+		firstImport = true
+
+		db     *sql.DB
+		shards *shardSet
+		err    error
+	)
+
+	if err := checkIndexStrategy(cfg.IndexStrategy); err != nil {
+		return nil, err
+	}
+	if err := checkShardConfig(&cfg); err != nil {
+		return nil, err
+	}
+	if err := checkPanicPolicy(cfg.PanicPolicy); err != nil {
+		return nil, err
+	}
+	if cfg.Profile && len(cfg.ShardConnectStrings) > 0 {
+		return nil, fmt.Errorf("Profiling is not supported with shards.")
+	}
+	if cfg.InputValues && len(cfg.ShardConnectStrings) > 0 {
+		return nil, fmt.Errorf("Input values are not supported with shards.")
+	}
+	if cfg.ScriptPayloads && len(cfg.ShardConnectStrings) > 0 {
+		return nil, fmt.Errorf("Script payloads are not supported with shards.")
+	}
+	if cfg.AddressHistory && cfg.DedupScripts {
+		return nil, fmt.Errorf("Address history indexes are not supported with deduplicated scripts.")
+	}
+	if cfg.Protocols {
+		// A copy, not to append to the caller's slice
+		cfg.Parsers = append(append([]string(nil), cfg.Parsers...), "protocols")
+	}
+	if _, err := newParsers(cfg.Parsers); err != nil { // check the names early
+		return nil, err
+	}
+	columns, err := newExtraColumns(cfg.Columns)
+	if err != nil {
+		return nil, err
+	}
+	if columns != nil && len(cfg.ShardConnectStrings) > 0 {
+		return nil, fmt.Errorf("Column plugins are not supported with shards.")
+	}
+	if cfg.IndexStrategy == "" {
+		cfg.IndexStrategy = IndexStrategyBtree
+	}
+	if _, err := ChainSchema(cfg.Chain); err != nil {
+		return nil, err
+	}
+
+	connstr := cfg.ConnectString
+	if cfg.FastUnsafe && connstr != "nulldb" {
+		connstr = withSessionSettings(connstr, fastUnsafeSettings)
+		log.Printf("Fast-unsafe mode: synchronous_commit=off.")
+	}
+	if connstr != "nulldb" {
+		db, err = cfg.openDB(connstr)
+		if err != nil {
+			return nil, err
+		}
+
+		if cfg.FastUnsafe {
+			if db, connstr, err = walCompression(db, connstr, cfg.openDB); err != nil {
+				return nil, err
+			}
+		}
+
+		if db, err = tuneSettings(db, connstr, cfg.Tune, cfg.openDB); err != nil {
+			return nil, err
+		}
+
+		if err := createChainSchema(db, cfg.Chain); err != nil {
+			return nil, err
+		}
+
+		if err := createPgcrypto(db); err != nil {
+			return nil, err
+		}
+
+		if err := createTables(db); err != nil {
+			if strings.Contains(err.Error(), "already exists") {
+				// this is fine, cancel deferred index/constraint creation
+				firstImport = false
+				log.Printf("Tables already exist, catching up.")
+			} else {
+				return nil, err
+			}
+		}
+
+		if firstImport {
+			if utxo == nil && !cfg.HeadersOnly {
+				return nil, fmt.Errorf("First import must be done with UTXO checker, i.e. from LevelDb directly. (utxo == nil)")
+			}
+			log.Printf("Tables created without indexes, which are created at the very end.")
+			if err := setTableStorageParams(db); err != nil {
+				return nil, err
+			}
+			log.Printf("Disabled autovacuum/analyze for the initial import.")
+			if cfg.DedupScripts {
+				if err := createScriptsTable(db); err != nil {
+					return nil, err
+				}
+				log.Printf("Scripts will be stored deduplicated in the scripts table.")
+			}
+		}
+
+		if !firstImport {
+			if err := checkUpgrades(db); err != nil {
+				return nil, err
+			}
+			if err := createBlocksHashIndex(db); err != nil {
+				return nil, err
+			}
+			if cfg.FastUnsafe {
+				log.Printf("Fast-unsafe mode: disabling txins trigger, spent flags will be set at the end.")
+				if err := disableTriggers(db); err != nil {
+					return nil, err
+				}
+			} else if err := restoreTriggers(db); err != nil { // left over from a crash
+				return nil, err
+			}
+			dedup, err := haveScriptDict(db)
+			if err != nil {
+				return nil, err
+			}
+			if cfg.DedupScripts && !dedup {
+				return nil, fmt.Errorf("Script deduplication can only be chosen at the first import.")
+			}
+			cfg.DedupScripts = dedup
+			if len(cfg.ShardConnectStrings) == 0 {
+				have, err := haveInputValues(db)
+				if err != nil {
+					return nil, err
+				}
+				cfg.InputValues = cfg.InputValues || have
+				if have, err = haveScriptPayloads(db); err != nil {
+					return nil, err
+				}
+				cfg.ScriptPayloads = cfg.ScriptPayloads || have
+			}
+		}
+
+		if cfg.InputValues {
+			if err := createInputValueColumn(db); err != nil {
+				return nil, err
+			}
+			log.Printf("Writing the input values in txins.input_value.")
+		}
+
+		if cfg.ScriptPayloads {
+			if err := createScriptPayloadColumn(db); err != nil {
+				return nil, err
+			}
+			log.Printf("Writing the script payloads in txouts.script_payload.")
+		}
+
+		if err := columns.create(db); err != nil {
+			return nil, err
+		}
+
+		if err := createPrevoutMissTable(db); err != nil {
+			return nil, err
+		}
+
+		if err := createVersionBitsTables(db); err != nil {
+			return nil, err
+		}
+
+		if err := createDifficultyEpochsTable(db); err != nil {
+			return nil, err
+		}
+
+		if err := createViews(db); err != nil {
+			return nil, err
+		}
+
+		if err := createTxoutsView(db, cfg.DedupScripts); err != nil {
+			return nil, err
+		}
+
+		if err := createAsOfFunctions(db); err != nil {
+			return nil, err
+		}
+
+		if err := createMaturityFunctions(db); err != nil {
+			return nil, err
+		}
+
+		if err := setColumnStorage(db, cfg.ColumnStorage, cfg.ColumnCompression); err != nil {
+			return nil, err
+		}
+
+		if cfg.Inscriptions {
+			if err := createInscriptionsTable(db); err != nil {
+				return nil, err
+			}
+		}
+
+		if len(cfg.ShardConnectStrings) > 0 {
+			if err := createShardHeightsTable(db, len(cfg.ShardConnectStrings)); err != nil {
+				return nil, err
+			}
+			if shards, err = openShards(&cfg, firstImport); err != nil {
+				return nil, err
+			}
+			log.Printf("Writing txins and txouts to %d shards.", len(shards.dbs))
+		}
+
+		if err := createImportProgressTable(db); err != nil {
+			return nil, err
+		}
+		if err := createSkipProgressTable(db); err != nil {
+			return nil, err
+		}
+		if cfg.Profile {
+			if err := createImportMetricsTable(db); err != nil {
+				return nil, err
+			}
+			log.Printf("Profiling, the time spent on each block is recorded in import_metrics.")
+		}
+		if !firstImport {
+			if err := trimToProgress(db, shards); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if cfg.Upsert && !firstImport && db != nil {
+		if err := checkUpsert(db); err != nil {
+			return nil, err
+		}
+	}
+	upsert := cfg.Upsert && !firstImport && db != nil
+	if upsert {
+		log.Printf("Upsert mode, existing rows are left as they are.")
+	}
+
+	bch := make(chan *blockRecSync, 2)
+	wg.Add(1)
+
+	w := &PGWriter{
+		blockCh: bch,
+		wg:      &wg,
+		db:      db,
+		shards:  shards,
+		start:   start,
+		cfg:     cfg,
+		fail:    newWriterFailure(cfg.PanicPolicy),
+		columns: columns,
+		upsert:  upsert,
+	}
+
+	go w.pgBlockWorker(bch, &wg, firstImport, cfg.CacheSize, utxo)
+
+	return w, nil
+}
+
+// Close waits for all the blocks to be written, and returns the error
+// which stopped the writer early, if any.
+func (p *PGWriter) Close() error {
+	if p.cfg.CloseTimeout > 0 {
+		return p.CloseWithTimeout(p.cfg.CloseTimeout)
+	}
+	close(p.blockCh)
+	p.wg.Wait()
+	p.fail.cancel()
+	return p.fail.failed()
+}
+
+func (p *PGWriter) Uptime() time.Duration {
+	return time.Now().Sub(p.start)
+}
+
+func (p *PGWriter) WriteBlock(b *BlockRec, sync bool) error {
+	if err := p.fail.failed(); err != nil {
+		return fmt.Errorf("%w: %v", ErrWriterStopped, err)
+	}
+	bs := &blockRecSync{BlockRec: b}
+	if sync {
+		bs.sync = make(chan bool, 1) // the worker may be gone by then
+	}
+	select {
+	case p.blockCh <- bs:
+	case <-p.fail.done:
+		return fmt.Errorf("%w: %v", ErrWriterStopped, p.fail.err)
+	}
+	if sync {
+		select {
+		case ok := <-bs.sync:
+			if !ok {
+				log.Printf("Error writing block: %v", b.Block.Hash())
+				return fmt.Errorf("Error writing block: %v", b.Block.Hash())
+			}
+		case <-p.fail.done:
+			return fmt.Errorf("%w: %v", ErrWriterStopped, p.fail.err)
+		}
+	}
+	return nil
+}
+
+func (w *PGWriter) HeightAndHashes(back int) (map[int][]core.Uint256, error) {
+	return getHeightAndHashes(w.db, back)
+}
+
+// Ping checks that the database is reachable.
+func (w *PGWriter) Ping() error {
+	if w.db == nil {
+		return nil
+	}
+	return w.db.Ping()
+}
+
+// HasBlock tells whether the block is in the database already.
+func (w *PGWriter) HasBlock(hash core.Uint256) (bool, error) {
+	if w.db == nil {
+		return false, nil
+	}
+	var exists bool
+	err := w.db.QueryRow("SELECT EXISTS (SELECT 1 FROM blocks WHERE hash = $1)", hash[:]).Scan(&exists)
+	return exists, err
+}
+
+func (w *PGWriter) pgBlockWorker(ch <-chan *blockRecSync, wg *sync.WaitGroup, firstImport bool, cacheSize int, utxo isUTXOer) {
+	defer wg.Done()
+
+	bid, err := getLastBlockId(w.db)
+	if err != nil {
+		log.Printf("Error getting last block id, exiting: %v", err)
+		w.fail.fail(err)
+		return
+	}
+	txid, err := getLastTxId(w.db)
+	if err != nil {
+		log.Printf("Error getting last tx id, exiting: %v", err)
+		w.fail.fail(err)
+		return
+	}
+	firstTxId := txid + 1
+	noValues := 0 // txins written without an input value
+
+	blockCh := make(chan *blockRecSync, 2)
+	go pgBlockWriter(blockCh, w.db, w.upsert, w.fail)
+
+	txCh := make(chan *txRec, 64)
+	go pgTxWriter(txCh, w.db, w.columns.names("txs"), w.upsert, w.fail)
+
+	txInCh := make(chan *txInRec, 64)
+	txOutCh := make(chan *txOutRec, 64)
+	if w.shards != nil {
+		// the routers start a writer per shard each
+		go w.shards.routeTxIns(txInCh, w.db, firstImport, w.upsert, w.fail)
+		go w.shards.routeTxOuts(txOutCh, utxo, w.upsert, w.fail)
+		writerWg.Add(2 * len(w.shards.dbs))
+	} else {
+		go pgTxInWriter(txInCh, w.db, firstImport, w.cfg.InputValues, w.upsert, w.fail)
+		go pgTxOutWriter(txOutCh, w.db, utxo, w.cfg.DedupScripts, w.cfg.ScriptPayloads, w.columns.names("txouts"), w.upsert, w.fail)
+	}
+
+	writerWg.Add(4)
+
+	var (
+		scriptCh chan *scriptRec
+		scripts  *scriptDict
+	)
+	if w.cfg.DedupScripts {
+		lastId, err := getLastScriptId(w.db)
+		if err != nil {
+			log.Printf("Error getting last script id, exiting: %v", err)
+			w.fail.fail(err)
+			return
+		}
+		var lookupDb *sql.DB
+		if !firstImport {
+			lookupDb = w.db
+		}
+		scripts = newScriptDict(scriptDictSize, lastId, lookupDb)
+		scriptCh = make(chan *scriptRec, 64)
+		go pgScriptWriter(scriptCh, w.db, w.upsert, w.fail)
+		writerWg.Add(1)
+	}
+
+	var insCh chan *inscriptionRec
+	if w.cfg.Inscriptions {
+		insCh = make(chan *inscriptionRec, 64)
+		go pgInscriptionWriter(insCh, w.db, w.upsert, w.fail)
+		writerWg.Add(1)
+	}
+
+	// Closing the channels tells the writers to commit and finish,
+	// which must happen even if this goroutine panics.
+	writersClosed := false
+	closeWriters := func() {
+		writersClosed = true
+		close(blockCh)
+		close(txInCh)
+		close(txOutCh)
+		close(txCh)
+		if scriptCh != nil {
+			close(scriptCh)
+		}
+		if insCh != nil {
+			close(insCh)
+		}
+	}
+	defer w.fail.catch("worker", func() {
+		if !writersClosed {
+			closeWriters()
+			writerWg.Wait()
+		}
+	})
+
+	parsers, err := newParsers(w.cfg.Parsers)
+	if err != nil {
+		log.Printf("Error creating parsers, exiting: %v", err)
+		w.fail.fail(err)
+		return
+	}
+	for _, p := range parsers {
+		if err := p.Start(w.db); err != nil {
+			log.Printf("Error starting parser %T, exiting: %v", p, err)
+			w.fail.fail(err)
+			return
+		}
+	}
+	if err := startHooks(w.cfg.Hooks, w.db); err != nil {
+		log.Printf("Error: %v, exiting.", err)
+		w.fail.fail(err)
+		return
+	}
+
+	hashes, err := getHeightAndHashes(w.db, 1)
+	if err != nil {
+		log.Printf("Error getting last hash and height, exiting: %v", err)
+		w.fail.fail(err)
+		return
+	}
+
+	// nil utxo means this is coming from a btcnode, we do not need to
+	// skip blocks, and neither do we when backfilling
+	if utxo != nil && len(hashes) > 0 && !w.cfg.Backfill {
+		var bhash core.Uint256
+		for _, hh := range hashes {
+			bhash = hh[len(hh)-1] // last hash in the list is the last hash
+		}
+		log.Printf("PGWriter ignoring blocks up to hash %v", bhash)
+		skip, last, found := 0, time.Now(), false
+		var (
+			st       *SkipStatus
+			lastHash core.Uint256
+		)
+		for b := range ch {
+			hash := b.Block.Hash()
+			if bhash == hash {
+				found = true
+				break
+			} else {
+				skip++
+				st, lastHash = &SkipStatus{Target: bhash.String(), Height: b.Height, Skipped: skip}, hash
+				pipeline.skipping(st)
+				if skip%10 == 0 && time.Now().Sub(last) > 5*time.Second {
+					log.Printf(" - ignored %d blocks...", skip)
+					if err := saveSkipProgress(w.db, st, bhash, hash); err != nil {
+						log.Printf("Error saving skip progress: %v", err)
+					}
+					last = time.Now()
+				}
+			}
+		}
+		pipeline.skipping(nil)
+		if skip > 0 {
+			log.Printf("Ignored %d total blocks.", skip)
+		}
+		if found {
+			if err := clearSkipProgress(w.db); err != nil {
+				log.Printf("Error clearing skip progress: %v", err)
+			}
+		} else if st != nil { // interrupted
+			if err := saveSkipProgress(w.db, st, bhash, lastHash); err != nil {
+				log.Printf("Error saving skip progress: %v", err)
+			}
+		}
+	}
+
+	idCache := newTxIdCache(cacheSize)
+	cacheReport := newCacheReporter(w.cfg.CacheReportPath, idCache)
+	var txids *txidFilter // see txidfilter.go
+	blockIds := newBlockIdCache()
+	vbits := newVersionBitsCounter()
+
+	var syncCh chan bool
+	if !firstImport {
+		// no firstImport means that the constraints already
+		// exist, and we need to wait for a tx to be commited before
+		// ins/outs can be inserted. Same with block/tx.
+		syncCh = make(chan bool, 1) // never blocks a writer
+
+		// The cache must be warmed up in (a rare) case when we encounter a chain split
+		// soon after we start to avoid duplicate txid key errors
+		nBlocks := 6
+		log.Printf("Warming up the txid cache going back %d blocks...", nBlocks)
+		if err := warmupCache(w.db, idCache, nBlocks); err != nil {
+			log.Printf("Error warming up the idCache: %v", err)
+		}
+		log.Printf("Warming up the cache done.")
+
+		if w.cfg.TxidFilterBits > 0 && w.db != nil {
+			if txids, err = loadTxidFilter(w.db, w.cfg.TxidFilterBits); err != nil {
+				log.Printf("Error building the txid filter, going without: %v", err)
+				txids = nil
+			}
+		}
+	}
+
+	throttle := newThrottle(w.cfg.ThrottleRows, w.cfg.ThrottleBytes)
+	if throttle != nil {
+		log.Printf("Throttling to %d rows/s, %d bytes/s (0 = no limit).", w.cfg.ThrottleRows, w.cfg.ThrottleBytes)
+	}
+
+	var prof *importProfiler
+	if w.cfg.Profile {
+		prof = newImportProfiler(w.db, w.cfg.ProfileSlowBlock)
+	}
+
+	stage := pipeline.stage("worker", ch, stateWaiting)
+	defer pipeline.remove(stage)
+
+	txcnt, start, lastStatus, lastCacheStatus, lastHeight := 0, time.Now(), time.Now(), 0, -1
+	blkCnt, blkSz := 0, 0
+	for br := range ch {
+		if err := w.fail.failed(); err != nil {
+			log.Printf("pgBlockWorker: stopping, %v", err)
+			if br.sync != nil {
+				br.sync <- false
+			}
+			break
+		}
+		stage.set(stateSending)
+		stage.row()
+
+		m := prof.begin(br)
+		br.Hash = m.blockHash(br.Block)
+		br.headerOnly = w.cfg.HeadersOnly
+
+		var dup bool
+		if !w.cfg.Backfill { // backfilling is writing to existing rows
+			if dup, err = blockIds.imported(w.db, br.Hash, !firstImport); err != nil {
+				log.Printf("pgBlockWorker: error checking for block %v: %v", br.Hash, err)
+			}
+		} else {
+			// A header-only block already has a row, reuse its id,
+			// a block imported in full is left alone.
+			var id int
+			if id, dup, err = getBackfillBlockId(w.db, br.Hash); err != nil {
+				log.Printf("pgBlockWorker: error looking up header-only block %v: %v", br.Hash, err)
+			}
+			br.Id, br.backfill = id, id > 0
+		}
+		if dup {
+			log.Printf("pgBlockWorker: block %v is already imported, ignoring it.", br.Hash)
+			if br.sync != nil {
+				br.sync <- true
+			}
+			stage.set(stateWaiting)
+			continue
+		}
+		blkCnt++
+
+		if !br.backfill {
+			bid++
+			br.Id = bid
+		}
+
+		if br.Height < 0 { // We have to look it up
+
+			hashes, err := getHeightAndHashes(w.db, 5)
+			if err != nil {
+				log.Printf("pgBlockWorker() error: %v", err)
+			}
+
+		hloop:
+			for height, hh := range hashes {
+				for _, h := range hh {
+					if h == br.PrevHash {
+						br.Height = height + 1
+						break hloop
+					}
+				}
+			}
+
+			if br.Height < 0 {
+				log.Printf("pgBlockWorker: Could not connect block to a previous block on our chain, ignoring it.")
+				if br.sync != nil {
+					br.sync <- false
+				}
+				stage.set(stateWaiting)
+				continue
+			}
+		}
+		if !br.Orphan {
+			if err := core.VerifyCheckpoint(w.cfg.Magic, br.Height, br.Hash); err != nil {
+				log.Printf("pgBlockWorker: %v Refusing to continue.", err)
+				w.fail.fail(err)
+				if br.sync != nil {
+					br.sync <- false
+				}
+				break
+			}
+		}
+		lastHeight = br.Height
+
+		if !br.backfill {
+			var prevWork *big.Int
+			if br.prevId, prevWork, err = blockIds.prev(w.db, br.PrevHash, !firstImport); err != nil {
+				log.Printf("pgBlockWorker: error looking up the previous block %v: %v", br.PrevHash, err)
+			}
+			br.chainwork = chainwork(br.BlockHeader, prevWork)
+		}
+		blockIds.add(br.Hash, br.Id, br.chainwork)
+
+		for _, h := range w.cfg.Hooks {
+			h.Block(br.BlockRec)
+		}
+
+		blkSz += br.Size()
+		if br.backfill {
+			// The row exists, it only lacks the sizes
+			if err := updateBlockSizes(w.db, br.BlockRec); err != nil {
+				log.Printf("pgBlockWorker: error updating block sizes: %v", err)
+			}
+		} else {
+			vbits.add(br.Height)
+			br.metrics = m.add()
+			blockCh <- br
+		}
+
+		// Without undo data, the inputs spending outputs of the same
+		// block at least need no lookup.
+		var inBlock map[core.Uint256]core.TxOutList
+		if w.cfg.InputValues && br.PrevOutValues == nil {
+			inBlock = make(map[core.Uint256]core.TxOutList, len(br.Txs))
+		}
+		spent := spentInBlock(br.Block)
+
+		rows := 1 // for the throttle
+		for n, tx := range br.Txs {
+			txid++
+			txcnt++
+			rows += 1 + len(tx.TxIns) + len(tx.TxOuts)
+
+			hash := m.txHash(tx)
+			if inBlock != nil {
+				inBlock[hash] = tx.TxOuts
+			}
+
+			// Check if recently seen and add to cache.
+			recentId := idCache.add(hash, txid, len(tx.TxOuts))
+			if recentId == txid {
+				for _, h := range w.cfg.Hooks {
+					h.Tx(br.BlockRec, n, txid, tx)
+				}
+			}
+			var extra []interface{}
+			if recentId == txid {
+				extra = w.columns.txValues(br.Height, n, txid, tx)
+			}
+			txCh <- &txRec{
+				id:      recentId,
+				n:       n,
+				blockId: br.Id,
+				tx:      tx,
+				hash:    hash,
+				dupe:    recentId != txid,
+				extra:   extra,
+				metrics: m.add(),
+			}
+
+			if recentId != txid {
+				// This is a recent transaction, nothing to do
+				continue
+			}
+			if txids != nil {
+				txids.add(hash)
+			}
+
+			for _, p := range parsers {
+				p.ParseTx(br.Height, n, txid, tx)
+			}
+
+			if insCh != nil {
+				for _, ins := range tx.Inscriptions() {
+					insCh <- &inscriptionRec{
+						txId: txid,
+						ins:  ins,
+					}
+				}
+			}
+
+			for i, txin := range tx.TxIns {
+				var value *int64
+				if w.cfg.InputValues {
+					if value = inputValue(br.Block, inBlock, n, i, txin); value == nil && n > 0 {
+						noValues++
+					}
+				}
+				txInCh <- &txInRec{
+					txId:    txid,
+					n:       i,
+					txIn:    txin,
+					idCache: idCache,
+					txids:   txids,
+					value:   value,
+					metrics: m.add(),
+				}
+			}
+
+			for n, txout := range tx.TxOuts {
+				var scriptId int64
+				if scripts != nil {
+					var isNew bool
+					if scriptId, isNew = scripts.id(txout.ScriptPubKey); isNew {
+						scriptCh <- &scriptRec{
+							id:     scriptId,
+							script: txout.ScriptPubKey,
+						}
+					}
+				}
+				txOutCh <- &txOutRec{
+					txId:      txid,
+					n:         n,
+					txOut:     txout,
+					hash:      hash,
+					scriptId:  scriptId,
+					sameBlock: spent[core.OutPoint{Hash: hash, N: uint32(n)}],
+					extra:     w.columns.txOutValues(br.Height, txid, tx, n),
+					metrics:   m.add(),
+				}
+			}
+		}
+
+		hw := &highWater{blockId: bid, txId: txid}
+		pipeline.block(br.Height, idCache, scripts)
+		cacheReport.block(br.Height)
+		if !firstImport {
+			// commit after every block
+			stage.set(stateCommitting)
+			blockCh <- &blockRecSync{
+				sync:    syncCh,
+				hw:      hw,
+				metrics: m.add(),
+			}
+			if syncCh != nil {
+				<-syncCh
+			}
+			txCh <- &txRec{
+				sync:    syncCh,
+				hw:      hw,
+				metrics: m.add(),
+			}
+			if syncCh != nil {
+				<-syncCh
+			}
+			if scriptCh != nil {
+				scriptCh <- &scriptRec{
+					sync: syncCh,
+				}
+				if syncCh != nil {
+					<-syncCh
+				}
+			}
+			if insCh != nil {
+				insCh <- &inscriptionRec{
+					sync: syncCh,
+				}
+				if syncCh != nil {
+					<-syncCh
+				}
+			}
+			// NB: Outputs must be commited before inputs!
+			txOutCh <- &txOutRec{
+				sync:    syncCh,
+				hw:      hw,
+				metrics: m.add(),
+			}
+			if syncCh != nil {
+				<-syncCh
+			}
+			if err := vbits.flush(w.db); err != nil {
+				log.Printf("Error writing version bits: %v", err)
+			}
+			flushParsers(parsers, w.db)
+			if err := flushHooks(w.cfg.Hooks, w.db); err != nil {
+				log.Printf("pgBlockWorker: %v", err)
+				w.fail.fail(err)
+			}
+			if br.sync != nil {
+				// wait for it to finish
+				txInCh <- &txInRec{
+					sync:    syncCh,
+					hw:      hw,
+					metrics: m.add(),
+				}
+				if syncCh != nil {
+					<-syncCh
+					br.sync <- w.fail.failed() == nil
+				}
+			} else {
+				// we don't care when it finishes
+				txInCh <- &txInRec{hw: hw, metrics: m.add()}
+			}
+		} else if bid%1024 == 0 {
+			// commit every N blocks
+			blockCh <- &blockRecSync{hw: hw, metrics: m.add()}
+			txCh <- &txRec{hw: hw, metrics: m.add()}
+			txInCh <- &txInRec{hw: hw, metrics: m.add()}
+			txOutCh <- &txOutRec{hw: hw, metrics: m.add()}
+			if scriptCh != nil {
+				scriptCh <- nil
+			}
+			if insCh != nil {
+				insCh <- nil
+			}
+			if err := vbits.flush(w.db); err != nil {
+				log.Printf("Error writing version bits: %v", err)
+			}
+			flushParsers(parsers, w.db)
+			if err := flushHooks(w.cfg.Hooks, w.db); err != nil {
+				log.Printf("pgBlockWorker: %v", err)
+				w.fail.fail(err)
+			}
+		}
+		prof.record(m, br)
+		if throttle != nil {
+			stage.set(stateThrottled)
+			throttle.wait(rows, int64(br.Size()))
+		}
+		stage.set(stateWaiting)
+
+		// report progress
+		if time.Now().Sub(lastStatus) > 5*time.Second {
+			uptime := w.Uptime().Round(time.Second)
+			log.Printf("Height: %d Txs: %d Time: %v Tx/s: %02f KB/s: %02f Runtime: %s",
+				br.Height, txcnt,
+				time.Unix(int64(br.Time), 0),
+				float64(txcnt)/time.Now().Sub(start).Seconds(),
+				float64(blkSz/1024)/time.Now().Sub(start).Seconds(),
+				uptime)
+			lastStatus = time.Now()
+			lastCacheStatus++
+			if lastCacheStatus == 5 {
+				idCache.reportStats()
+				if scripts != nil {
+					scripts.reportStats()
+				}
+				lastCacheStatus = 0
+			}
+		}
+	}
+
+	// a last commit signal, for the high-water marks
+	if blkCnt > 0 {
+		hw := &highWater{blockId: bid, txId: txid}
+		blockCh <- &blockRecSync{hw: hw}
+		txCh <- &txRec{hw: hw}
+		txOutCh <- &txOutRec{hw: hw}
+		txInCh <- &txInRec{hw: hw}
+	}
+
+	closeWriters()
+
+	log.Printf("Closed db channels, waiting for workers to finish...")
+	stage.set(stateCommitting)
+	writerWg.Wait()
+	prof.close()
+	pipeline.block(lastHeight, nil, nil)
+	log.Printf("Workers finished.")
+
+	if err := w.fail.failed(); err != nil {
+		log.Printf("Import stopped early, skipping the finishing steps: %v", err)
+		return
+	}
+
+	flushParsers(parsers, w.db)
+	if err := flushHooks(w.cfg.Hooks, w.db); err != nil {
+		log.Printf("pgBlockWorker: %v", err)
+		w.fail.fail(err)
+		return
+	}
+
+	if blkCnt == 0 {
+		return
+	}
+
+	idCache.reportStats() // Final stats ane last time
+	txids.reportStats()
+	cacheReport.finish()
+
+	if w.db == nil {
+		return
+	}
+
+	verbose := firstImport
+
+	if firstImport {
+		idCache.clear()
+		log.Printf("Cleared the cache.")
+
+		if err := analyzeTables(w.db, "after the COPY phase", bulkTables(&w.cfg), w.cfg.Vacuum); err != nil {
+			log.Printf("Error: %v", err)
+		}
+
+		if len(w.cfg.ZfsDataset) > 0 {
+			takeSnapshot(w.db, w.cfg.ZfsDataset, lastHeight, "-preindex")
+		}
+
+		log.Printf("Creating indexes (if needed), please be patient, this may take a long time...")
+		if err := createIndexes(w.db, w.cfg.IndexStrategy, verbose); err != nil {
+			log.Printf("Error creating indexes: %v", err)
+		}
+
+		if w.shards != nil {
+			log.Printf("Creating shard indexes...")
+			w.shards.finish(w.db, w.cfg.IndexStrategy, verbose)
+		}
+
+		if w.cfg.AddressHistory {
+			log.Printf("Creating address history indexes...")
+			if err := createAddressHistoryIndexes(w.db, verbose); err != nil {
+				log.Printf("Error creating address history indexes: %v", err)
+			}
+		}
+
+		if w.cfg.TxidPrefixIndex {
+			log.Printf("Creating the txid prefix index...")
+			if err := createTxidPrefixIndex(w.db, verbose); err != nil {
+				log.Printf("Error creating the txid prefix index: %v", err)
+			}
+		}
+
+		if w.cfg.ScriptPayloads {
+			log.Printf("Creating the script payload index...")
+			if err := createScriptPayloadIndex(w.db, verbose); err != nil {
+				log.Printf("Error creating the script payload index: %v", err)
+			}
+		}
+
+		if w.cfg.DedupScripts {
+			log.Printf("Merging duplicate scripts and creating scripts indexes...")
+			if err := finishScripts(w.db, verbose); err != nil {
+				log.Printf("Error finishing scripts: %v", err)
+			}
+		}
+
+		if w.cfg.NoConstraints {
+			log.Printf("NOT creating constraints, use cmd/verify to validate the database.")
+		} else {
+			log.Printf("Creating constraints (if needed), please be patient, this may take a long time...")
+			if err := createConstraints(w.db, verbose); err != nil {
+				log.Printf("Error creating constraints: %v", err)
+			}
+		}
+
+		if err := analyzeTables(w.db, "after creating the indexes", bulkTables(&w.cfg), false); err != nil {
+			log.Printf("Error: %v", err)
+		}
+
+		if idCache.miss > 0 {
+			log.Printf("Running ANALYZE _prevout_miss to ensure the next step selects the optimal plan...")
+			start := time.Now()
+			if err := fixPrevoutTxIdAnalyze(w.db); err != nil {
+				log.Printf("Error running ANALYZE: %v", err)
+			}
+			log.Printf("...done in %s. Fixing missing prevout_tx_id entries (if needed), this may take a long time..",
+				time.Now().Sub(start).Round(time.Millisecond))
+			start = time.Now()
+			if w.shards != nil {
+				if err := w.shards.fixPrevoutTxIds(w.db); err != nil {
+					log.Printf("Error fixing prevout_tx_id: %v", err)
+				}
+			} else if err := fixPrevoutTxIdBatched(w.db, w.cfg.FixPrevoutWorkers, w.cfg.Vacuum); err != nil {
+				log.Printf("Error fixing prevout_tx_id: %v", err)
+			}
+			log.Printf("...done in %s.", time.Now().Sub(start).Round(time.Millisecond))
+			if err := analyzeTables(w.db, "after fixing prevout_tx_id", []string{"txins"}, w.cfg.Vacuum); err != nil {
+				log.Printf("Error: %v", err)
+			}
+		} else {
+			log.Printf("NOT fixing missing prevout_tx_id entries because there were 0 cache misses.")
+		}
+
+		// Before the trigger too, and after fixPrevoutTxId(), which it needs.
+		if noValues > 0 {
+			log.Printf("Filling in the input values of %d txins, this may take a long time...", noValues)
+			if err := fillInputValues(w.db, firstTxId, verbose); err != nil {
+				log.Printf("Error: %v", err)
+			}
+			noValues = 0
+		}
+
+		// NOTE: It is imperative that this trigger is created *after* the fixPrevoutTxId() call, or else these
+		// triggers will be needlessly triggered slowing fixPrevoutTxId() tremendously. The trigger sets the spent
+		// column, which should anyway be correctly set during the initial import based on the LevelDb UTXO set.
+		log.Printf("Creating txins triggers.")
+		if err := createTxinsTriggers(w.db); err != nil {
+			log.Printf("Error creating txins triggers: %v", err)
+		}
+
+		if err := resetTableStorageParams(w.db); err != nil {
+			log.Printf("Error resetting storage parameters: %v", err)
+		}
+		log.Printf("Autovacuum/analyze re-enabled.")
+	}
+
+	if w.cfg.FastUnsafe && !firstImport {
+		if err := restoreTriggers(w.db); err != nil {
+			log.Printf("Error restoring txins trigger: %v", err)
+		}
+	}
+
+	log.Printf("Dropping _prevout_miss table.")
+	if err := dropPrevoutMissTable(w.db); err != nil {
+		log.Printf("Error dropping _prevout_miss table: %v", err)
+	}
+
+	if noValues > 0 {
+		log.Printf("Filling in the input values of %d txins...", noValues)
+		if err := fillInputValues(w.db, firstTxId, verbose); err != nil {
+			log.Printf("Error: %v", err)
+		}
+	}
+
+	orphanWindow, start := 0, time.Now()
+	if !firstImport {
+		// No need to walk back the entire chain
+		orphanWindow = blkCnt + 50
+		log.Printf("Marking orphan blocks (going back %d heights)...", orphanWindow)
+	} else {
+		log.Printf("Marking orphan blocks (whole chain)...")
+	}
+	if err := w.SetOrphans(orphanWindow); err != nil {
+		log.Printf("Error marking orphans: %v", err)
+	}
+	log.Printf("Done marking orphan blocks in %s.", time.Now().Sub(start).Round(time.Millisecond))
+
+	// Not before, the stale blocks would be counted (see versionbits.go).
+	if err := vbits.flush(w.db); err != nil {
+		log.Printf("Error writing version bits: %v", err)
+	}
+
+	log.Printf("Updating difficulty epochs...")
+	if err := w.UpdateDifficultyEpochs(); err != nil {
+		log.Printf("Error updating difficulty epochs: %v", err)
+	}
+
+	if w.cfg.AddressEvents {
+		log.Printf("Updating address events...")
+		if err := updateAddressEvents(w.db, verbose); err != nil {
+			log.Printf("Error updating address events: %v", err)
+		}
+	}
+
+	if err := w.UpdateUtxoSnapshots(); err != nil {
+		log.Printf("Error taking UTXO snapshot: %v", err)
+	}
+
+	if w.cfg.PruneDepth > 0 {
+		log.Printf("Pruning scripts spent more than %d blocks ago...", w.cfg.PruneDepth)
+		if err := pruneScripts(w.db, w.cfg.PruneDepth, verbose); err != nil {
+			log.Printf("Error pruning scripts: %v", err)
+		}
+	}
+
+	if w.cfg.RetainBlocks > 0 {
+		log.Printf("Deleting the txs of the blocks more than %d below the tip...", w.cfg.RetainBlocks)
+		if err := retain(w.db, w.cfg.RetainBlocks, w.cfg.RetainArchive, verbose); err != nil {
+			log.Printf("Error applying retention: %v", err)
+		}
+	}
+
+	if firstImport {
+		log.Printf("Indexes and constraints created.")
+		if len(w.cfg.ZfsDataset) > 0 {
+			takeSnapshot(w.db, w.cfg.ZfsDataset, lastHeight, "-postindex")
+		}
+	}
+}
+
+func pgBlockWriter(c chan *blockRecSync, db *sql.DB, upsert bool, fail *writerFailure) {
+	defer writerWg.Done()
+	defer fail.catch("blocks writer", func() {
+		for br := range c {
+			if br != nil && br.sync != nil {
+				br.sync <- false
+			}
+		}
+	})
+
+	stage := pipeline.stage("blocks", c, stateRunning)
+	defer pipeline.remove(stage)
+
+	cols := []string{"id", "height", "hash", "version", "prevhash", "prev_block_id", "merkleroot", "time", "bits", "nonce", "chainwork", "orphan", "size", "base_size", "weight", "virt_size"}
+
+	txn, stmt, err := begin(fail.ctx, db, upsert, "blocks", cols)
+	if err != nil {
+		log.Printf("ERROR (1): %v", err)
+	}
+
+	for br := range c {
+
+		if br == nil || br.BlockRec == nil { // commit signal
+			var (
+				hw *highWater
+				m  *blockMetrics
+			)
+			if br != nil {
+				hw, m = br.hw, br.metrics
+			}
+			start := m.now()
+			stage.committing()
+			if err = commitProgress(stmt, txn, upsert, nil, "blocks", hw); err != nil {
+				log.Printf("Block commit error: %v", err)
+			}
+			txn, stmt, err = begin(fail.ctx, db, upsert, "blocks", cols)
+			if err != nil {
+				log.Printf("ERROR (2): %v", err)
+			}
+			stage.committed()
+			m.done(metricsBlocks, start)
+			if br != nil && br.sync != nil {
+				br.sync <- true
+			}
+			continue
+		}
+
+		stage.row()
+		start := br.metrics.now()
+		if stmt != nil {
+			b := br.Block
+			size, baseSize, weight, virtSize := br.Size(), br.BaseSize(), br.Weight(), br.VirtualSize()
+			if br.headerOnly {
+				size, baseSize, weight, virtSize = 0, 0, 0, 0
+			}
+			_, err = stmt.Exec(
+				br.Id,
+				br.Height,
+				br.Hash[:],
+				int32(b.Version),
+				b.PrevHash[:],
+				br.prevId,
+				b.HashMerkleRoot[:],
+				int32(b.Time),
+				int32(b.Bits),
+				int32(b.Nonce),
+				numeric(br.chainwork),
+				br.Orphan,
+				size,
+				baseSize,
+				weight,
+				virtSize,
+			)
+		}
+		br.metrics.done(metricsBlocks, start)
+		if err != nil {
+			log.Printf("ERROR (3): %v", err)
+		}
+	}
+
+	log.Printf("Block writer channel closed, commiting transaction.")
+	stage.committing()
+	if err = commit(stmt, txn, upsert, nil); err != nil {
+		log.Printf("Block commit error: %v", err)
+	}
+	log.Printf("Block writer done.")
+}
+
+func pgTxWriter(c chan *txRec, db *sql.DB, extra []string, upsert bool, fail *writerFailure) {
+	defer writerWg.Done()
+	defer fail.catch("txs writer", func() {
+		for tr := range c {
+			if tr != nil && tr.sync != nil {
+				tr.sync <- false
+			}
+		}
+	})
+
+	stage := pipeline.stage("txs", c, stateRunning)
+	defer pipeline.remove(stage)
+
+	cols := []string{"id", "txid", "version", "locktime", "size", "base_size", "weight", "virt_size", "n_inputs", "n_outputs"}
+	cols = append(cols, extra...)
+	bcols := []string{"block_id", "n", "tx_id"}
+
+	txn, stmt, err := begin(fail.ctx, db, upsert, "txs", cols)
+	if err != nil {
+		log.Printf("ERROR (3): %v", err)
+	}
+
+	btxn, bstmt, err := begin(fail.ctx, db, upsert, "block_txs", bcols)
+	if err != nil {
+		log.Printf("ERROR (4): %v", err)
+	}
+
+	for tr := range c {
+		if tr == nil || tr.tx == nil { // commit signal
+			var (
+				hw *highWater
+				m  *blockMetrics
+			)
+			if tr != nil {
+				hw, m = tr.hw, tr.metrics
+			}
+			start := m.now()
+			stage.committing()
+			if err = commitProgress(stmt, txn, upsert, nil, "txs", hw); err != nil {
+				log.Printf("Tx commit error: %v", err)
+			}
+			if err = commitProgress(bstmt, btxn, upsert, nil, "block_txs", hw); err != nil {
+				log.Printf("Block Txs commit error: %v", err)
+			}
+			txn, stmt, err = begin(fail.ctx, db, upsert, "txs", cols)
+			if err != nil {
+				log.Printf("ERROR (5): %v", err)
+			}
+			btxn, bstmt, err = begin(fail.ctx, db, upsert, "block_txs", bcols)
+			if err != nil {
+				log.Printf("ERROR (6): %v", err)
+			}
+			stage.committed()
+			m.done(metricsTxs, start)
+			if tr != nil && tr.sync != nil {
+				tr.sync <- true
+			}
+			continue
+		}
+
+		stage.row()
+		start := tr.metrics.now()
+		if !tr.dupe {
+
+			if stmt != nil {
+				t := tr.tx
+				args := []interface{}{
+					tr.id,
+					tr.hash[:],
+					int32(t.Version),
+					int32(t.LockTime),
+					t.Size(),
+					t.BaseSize(),
+					t.Weight(),
+					t.VirtualSize(),
+					len(t.TxIns),
+					len(t.TxOuts),
+				}
+				_, err = stmt.Exec(append(args, tr.extra...)...)
+			}
+			if err != nil {
+				log.Printf("ERROR (7): %v", err)
+			}
+			// It can still be a dupe if we are catching up and the
+			// cache is empty, which is why we warmupCache.
+		}
+
+		if bstmt != nil {
+			_, err = bstmt.Exec(
+				tr.blockId,
+				tr.n,
+				tr.id,
+			)
+		}
+		tr.metrics.done(metricsTxs, start)
+		if err != nil {
+			log.Printf("ERROR (7.5): %v", err)
+		}
+	}
+
+	log.Printf("Tx writer channel closed, committing transaction.")
+	stage.committing()
+
+	if err = commit(stmt, txn, upsert, nil); err != nil {
+		log.Printf("Tx commit error: %v", err)
+	}
+	if err = commit(bstmt, btxn, upsert, nil); err != nil {
+		log.Printf("Block Txs commit error: %v", err)
+	}
+
+	log.Printf("Tx writer done.")
+}
+
+func pgTxInWriter(c chan *txInRec, db *sql.DB, firstImport, inputValues bool, upsert bool, fail *writerFailure) {
+	defer writerWg.Done()
+	defer fail.catch("txins writer", func() {
+		for tr := range c {
+			if tr != nil && tr.sync != nil {
+				tr.sync <- false
+			}
+		}
+	})
+
+	stage := pipeline.stage("txins", c, stateRunning)
+	defer pipeline.remove(stage)
+
+	cols := []string{"tx_id", "n", "prevout_tx_id", "prevout_n", "scriptsig", "sequence", "witness"}
+	if inputValues {
+		cols = append(cols, "input_value")
+	}
+
+	txn, stmt, err := begin(fail.ctx, db, upsert, "txins", cols)
+	if err != nil {
+		log.Printf("ERROR (9): %v", err)
+	}
+
+	misses := make([]*prevoutMiss, 0, 2000)
+
+	write := func(tr *txInRec, prevOutTxId *int64) {
+		t := tr.txIn
+		var wb interface{}
+		if t.Witness != nil {
+			var b bytes.Buffer
+			core.BinWrite(&t.Witness, &b)
+			wb = b.Bytes()
+		}
+		args := []interface{}{
+			tr.txId,
+			tr.n,
+			prevOutTxId,
+			int32(t.PrevOut.N),
+			t.ScriptSig,
+			int32(t.Sequence),
+			wb,
+		}
+		if inputValues {
+			args = append(args, tr.value)
+		}
+		start := tr.metrics.now()
+		if stmt != nil {
+			if _, err := stmt.Exec(args...); err != nil {
+				log.Printf("ERROR (11): %v", err)
+			}
+		}
+		tr.metrics.done(metricsTxIns, start)
+	}
+
+	// After the first import, the txins whose prevout missed the cache
+	// are held until the commit, see prevoutbatch.go.
+	var held []*txInRec
+	writeHeld := func() {
+		if len(held) == 0 {
+			return
+		}
+		ids, err := resolvePrevoutTxIds(db, held)
+		if err != nil {
+			log.Printf("Error resolving prevout_tx_id, fixing it at the commit: %v", err)
+		}
+		for _, tr := range held {
+			if id, ok := ids[tr.txIn.PrevOut.Hash]; ok {
+				write(tr, &id)
+				continue
+			}
+			write(tr, nil)
+			if err != nil {
+				misses = append(misses, &prevoutMiss{tr.txId, tr.n, tr.txIn.PrevOut.Hash})
+			}
+		}
+		held = held[:0]
+	}
+
+	for tr := range c {
+		if tr == nil || tr.txIn == nil { // commit signal
+			var (
+				hw *highWater
+				m  *blockMetrics
+			)
+			if tr != nil {
+				hw, m = tr.hw, tr.metrics
+			}
+			start := m.now()
+			stage.committing()
+			writeHeld()
+			if err = commitProgress(stmt, txn, upsert, misses, "txins", hw); err != nil {
+				log.Printf("Txin commit error: %v", err)
+			}
+			misses = misses[:0]
+			txn, stmt, err = begin(fail.ctx, db, upsert, "txins", cols)
+			if err != nil {
+				log.Printf("ERROR (10): %v", err)
+			}
+			stage.committed()
+			m.done(metricsTxIns, start)
+			if tr != nil && tr.sync != nil {
+				tr.sync <- true
+			}
+			continue
+		}
+
+		stage.row()
+		t := tr.txIn
+
+		var prevOutTxId *int64 = nil
+		if tr.idCache == nil {
+			prevOutTxId = tr.prevOutTxId
+		} else if t.PrevOut.N != 0xffffffff { // coinbase
+			prevOutTxId = tr.idCache.check(t.PrevOut.Hash)
+
+			if prevOutTxId == nil { // cache miss
+				if firstImport {
+					// write it to the DB directly
+					if err := recordPrevoutMiss(db, tr.txId, tr.n, t.PrevOut.Hash); err != nil {
+						log.Printf("ERROR (10.7): %v", err)
+					}
+				} else if tr.txids.mayContain(t.PrevOut.Hash) {
+					// resolved along with the others at the commit
+					held = append(held, tr)
+					continue
+				}
+			}
+		}
+
+		write(tr, prevOutTxId)
+	}
+
+	log.Printf("TxIn writer channel closed, committing transaction.")
+	stage.committing()
+	writeHeld()
+	if err = commit(stmt, txn, upsert, misses); err != nil {
+		log.Printf("TxIn commit error: %v", err)
+	}
+	log.Printf("TxIn writer done.")
+}
+
+func pgTxOutWriter(c chan *txOutRec, db *sql.DB, utxo isUTXOer, dedup, payloads bool, extra []string, upsert bool, fail *writerFailure) {
+	defer writerWg.Done()
+	defer fail.catch("txouts writer", func() {
+		for tr := range c {
+			if tr != nil && tr.sync != nil {
+				tr.sync <- false
+			}
+		}
+	})
+
+	stage := pipeline.stage("txouts", c, stateRunning)
+	defer pipeline.remove(stage)
+
+	cols := []string{"tx_id", "n", "value", "scriptpubkey", "spent", "spent_same_block"}
+	if dedup {
+		cols[3] = "script_id"
+	}
+	if payloads {
+		cols = append(cols, "script_payload")
+	}
+	cols = append(cols, extra...)
+
+	txn, stmt, err := begin(fail.ctx, db, upsert, "txouts", cols)
+	if err != nil {
+		log.Printf("ERROR (12): %v", err)
+	}
+
+	for tr := range c {
+
+		if tr == nil || tr.txOut == nil { // commit signal
+			var (
+				hw *highWater
+				m  *blockMetrics
+			)
+			if tr != nil {
+				hw, m = tr.hw, tr.metrics
+			}
+			start := m.now()
+			stage.committing()
+			if err = commitProgress(stmt, txn, upsert, nil, "txouts", hw); err != nil {
+				log.Printf("TxOut commit error: %v", err)
+			}
+			txn, stmt, err = begin(fail.ctx, db, upsert, "txouts", cols)
+			if err != nil {
+				log.Printf("ERROR (13): %v", err)
+			}
+			stage.committed()
+			m.done(metricsTxOuts, start)
+			if tr != nil && tr.sync != nil {
+				tr.sync <- true
+			}
+			continue
+		}
+
+		stage.row()
+		spent := tr.sameBlock // no need to ask the UTXO set
+		if utxo != nil && !spent {
+			// NB: Some early unspent coins are not in the UTXO set,
+			// probably because they are not spendable? So the spent
+			// flag could also be interpeted as unspendable.
+			isUTXO, err := utxo.IsUTXO(tr.hash, uint32(tr.n))
+			if err != nil {
+				log.Printf("ERROR (13.5): %v", err)
+			}
+			spent = !isUTXO
+		}
+
+		start := tr.metrics.now()
+		if stmt != nil {
+			t := tr.txOut
+			var script interface{} = t.ScriptPubKey
+			if dedup {
+				script = tr.scriptId
+			}
+			args := []interface{}{
+				tr.txId,
+				tr.n,
+				t.Value,
+				script,
+				spent,
+				tr.sameBlock,
+			}
+			if payloads {
+				args = append(args, scriptPayload(t.ScriptPubKey))
+			}
+			_, err = stmt.Exec(append(args, tr.extra...)...)
+		}
+		tr.metrics.done(metricsTxOuts, start)
+		if err != nil {
+			log.Printf("ERROR (13.6): %v\n", err)
+		}
+	}
+
+	log.Printf("TxOut writer channel closed, committing transaction.")
+	stage.committing()
+	if err = commit(stmt, txn, upsert, nil); err != nil {
+		log.Printf("TxOut commit error: %v", err)
+	}
+	log.Printf("TxOut writer done.")
+}
+
+func begin(ctx context.Context, db *sql.DB, upsert bool, table string, cols []string) (*sql.Tx, *sql.Stmt, error) {
+	if db == nil {
+		return nil, nil, nil
+	}
+
+	txn, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err := txn.Exec("SET CONSTRAINTS ALL DEFERRED"); err != nil {
+		return nil, nil, err
+	}
+	query := pq.CopyIn(table, cols...)
+	if upsert {
+		query = upsertStmt(table, cols)
+	}
+	stmt, err := txn.Prepare(query)
+	if err != nil {
+		return nil, nil, err
+	}
+	return txn, stmt, nil
+}
+
+func commit(stmt *sql.Stmt, txn *sql.Tx, upsert bool, misses []*prevoutMiss) error {
+	return commitProgress(stmt, txn, upsert, misses, "", nil)
+}
+
+// commitProgress is commit which also records the high-water mark of
+// the table (see progress.go), if hw is not nil.
+func commitProgress(stmt *sql.Stmt, txn *sql.Tx, upsert bool, misses []*prevoutMiss, table string, hw *highWater) (err error) {
+	if stmt == nil {
+		return nil
+	}
+	if !upsert { // flush the COPY
+		if _, err = stmt.Exec(); err != nil {
+			return err
+		}
+	}
+	if err = stmt.Close(); err != nil {
+		return err
+	}
+	// We do this here because it is not possible to use Exec() during
+	// a pq.CopyIn operation, but we do want this done prior to the
+	// Commit.
+	if len(misses) > 0 {
+		if err = recordPrevoutMisses(txn, misses); err != nil {
+			return err
+		}
+		if err = fixPrevoutTxId(txn); err != nil { // also truncates _prevout_miss
+			return err
+		}
+	}
+	if hw != nil {
+		if err = recordProgress(txn, table, hw); err != nil {
+			return err
+		}
+	}
+	err = txn.Commit()
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func getHeightAndHashes(db *sql.DB, back int) (map[int][]core.Uint256, error) {
+	if db == nil {
+		return nil, nil
+	}
+
+	stmt := `SELECT height, hash FROM blocks
+		WHERE height > (SELECT MAX(height) FROM blocks) - $1`
+
+	rows, err := db.Query(stmt, back)
+	if err != nil {
+		return nil, fmt.Errorf("getHeightAndHashes: %v", err)
+	}
+	defer rows.Close()
+
+	hashes := make(map[int][]core.Uint256)
+
+	for rows.Next() {
+		var (
+			height int
+			hash   core.Uint256
+		)
+		if err := rows.Scan(&height, &hash); err != nil {
+			return nil, err
+		}
+		if list, ok := hashes[height]; ok {
+			hashes[height] = append(list, hash)
+		} else {
+			hashes[height] = []core.Uint256{hash}
+		}
+	}
+	if len(hashes) > 0 {
+		return hashes, nil
+	}
+	return nil, rows.Err()
+}
+
+func getLastBlockId(db *sql.DB) (int, error) {
+	if db == nil {
+		return 0, nil
+	}
+
+	rows, err := db.Query("SELECT id FROM blocks ORDER BY id DESC LIMIT 1")
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return 0, err
+		}
+		return id, nil
+	}
+	return 0, rows.Err()
+}
+
+func getLastTxId(db *sql.DB) (int64, error) {
+	if db == nil {
+		return 0, nil
+	}
+
+	rows, err := db.Query("SELECT id FROM txs ORDER BY id DESC LIMIT 1")
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return 0, err
+		}
+		return id, nil
+	}
+	return 0, rows.Err()
+}
+
+// Returns the id of the block with this hash if it was imported
+// header-only, otherwise 0, and whether it was imported in full.
+func getBackfillBlockId(db *sql.DB, hash core.Uint256) (int, bool, error) {
+	if db == nil {
+		return 0, false, nil
+	}
+
+	rows, err := db.Query("SELECT id, size FROM blocks WHERE hash = $1", hash[:])
+	if err != nil {
+		return 0, false, err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		var id, size int
+		if err := rows.Scan(&id, &size); err != nil {
+			return 0, false, err
+		}
+		if size != 0 {
+			return 0, true, nil
+		}
+		return id, false, nil
+	}
+	return 0, false, rows.Err()
+}
+
+func updateBlockSizes(db *sql.DB, br *BlockRec) error {
+	if db == nil {
+		return nil
+	}
+	_, err := db.Exec("UPDATE blocks SET size = $1, base_size = $2, weight = $3, virt_size = $4 WHERE id = $5",
+		br.Size(), br.BaseSize(), br.Weight(), br.VirtualSize(), br.Id)
+	return err
+}
+
+// This could be a db connection or a transaction
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+func createPrevoutMissTable(db execer) error {
+	sql := `
+     CREATE UNLOGGED TABLE IF NOT EXISTS _prevout_miss (
+          tx_id         BIGINT NOT NULL
+         ,n             INT NOT NULL
+         ,prevout_hash  BYTEA NOT NULL
+     );
+     TRUNCATE _prevout_miss;
+`
+	_, err := db.Exec(sql)
+	return err
+}
+
+func clearPrevoutMissTable(db execer) error {
+	_, err := db.Exec("TRUNCATE _prevout_miss")
+	return err
+}
+
+func dropPrevoutMissTable(db *sql.DB) error {
+	_, err := db.Exec("DROP TABLE IF EXISTS _prevout_miss")
+	return err
+}
+
+func recordPrevoutMiss(db *sql.DB, txId int64, n int, prevoutHash core.Uint256) error {
+	stmt := "INSERT INTO _prevout_miss (tx_id, n, prevout_hash) VALUES($1, $2, $3)"
+	_, err := db.Exec(stmt, txId, n, prevoutHash[:])
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+type prevoutMiss struct {
+	txId        int64
+	n           int
+	prevOutHash core.Uint256
+}
+
+func recordPrevoutMisses(txn *sql.Tx, misses []*prevoutMiss) error {
+	stmt, err := txn.Prepare(pq.CopyIn("_prevout_miss", "tx_id", "n", "prevout_hash"))
+	if err != nil {
+		return err
+	}
+	for i, m := range misses {
+		if _, err = stmt.Exec(m.txId, m.n, m.prevOutHash[:]); err != nil {
+			return err
+		}
+		misses[i] = nil // so that they can be freed
+	}
+	if _, err = stmt.Exec(); err != nil {
+		return err
+	}
+	if err = stmt.Close(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Most of the prevout_tx_id's should be already set during the
+// import, but we need to correct the remaining ones. You can likely
+// avoid it by increasing the txIdCache size. After the first import
+// it will be looked up on the fly as needed, but a larger cache is
+// still important.
+func fixPrevoutTxId(db execer) error {
+	if _, err := db.Exec(`
+           UPDATE txins i
+              SET prevout_tx_id = t.id
+             FROM _prevout_miss m
+             JOIN txs t ON m.prevout_hash = t.txid
+            WHERE i.tx_id = m.tx_id
+              AND i.n = m.n
+        `); err != nil {
+		return err
+	}
+	return clearPrevoutMissTable(db)
+}
+
+func fixPrevoutTxIdAnalyze(db execer) error {
+	_, err := db.Exec(`ANALYZE _prevout_miss`) // the others are done (see analyze.go)
+	return err
+}
+
+func createTables(db *sql.DB) error {
+	sqlTables := `
+  CREATE TABLE blocks (
+   id           INT NOT NULL
+  ,height       INT NOT NULL -- not same as id, because orphans.
+  ,hash         BYTEA NOT NULL
+  ,version      INT NOT NULL
+  ,prevhash     BYTEA NOT NULL
+  ,prev_block_id INT -- the id of the prevhash block, see prevblock.go
+  ,merkleroot   BYTEA NOT NULL
+  ,time         INT NOT NULL
+  ,bits         INT NOT NULL
+  ,nonce        INT NOT NULL
+  ,chainwork    NUMERIC -- the total work up to this block, see prevblock.go
+  ,orphan       BOOLEAN NOT NULL DEFAULT false
+  ,size         INT NOT NULL
+  ,base_size    INT NOT NULL
+  ,weight       INT NOT NULL
+  ,virt_size    INT NOT NULL
+  );
+
+  CREATE TABLE txs (
+   id            BIGINT NOT NULL
+  ,txid          BYTEA NOT NULL
+  ,version       INT NOT NULL
+  ,locktime      INT NOT NULL
+  ,size          INT NOT NULL
+  ,base_size     INT NOT NULL
+  ,weight        INT NOT NULL
+  ,virt_size     INT NOT NULL
+  ,n_inputs      INT NOT NULL
+  ,n_outputs     INT NOT NULL
+  );
+
+  CREATE TABLE block_txs (
+   block_id      INT NOT NULL
+  ,n             SMALLINT NOT NULL
+  ,tx_id         BIGINT NOT NULL
+  );
+
+  CREATE TABLE txins (
+   tx_id         BIGINT NOT NULL
+  ,n             SMALLINT NOT NULL
+  ,prevout_tx_id BIGINT   -- can be NULL for coinbase
+  ,prevout_n     SMALLINT NOT NULL
+  ,scriptsig     BYTEA NOT NULL
+  ,sequence      INT NOT NULL
+  ,witness       BYTEA
+  );
+
+  CREATE TABLE txouts (
+   tx_id        BIGINT NOT NULL
+  ,n            SMALLINT NOT NULL
+  ,value        BIGINT NOT NULL
+  ,scriptpubkey BYTEA NOT NULL
+  ,spent        BOOL NOT NULL
+  ,spent_same_block BOOL NOT NULL DEFAULT false -- see sameblock.go
+  );
+`
+	_, err := db.Exec(sqlTables)
+	return err
+}
+
+func setTableStorageParams(db *sql.DB) error {
+	for _, table := range []string{"blocks", "txs", "block_txs", "txins", "txouts"} {
+		stmt := fmt.Sprintf(`ALTER TABLE %s SET (autovacuum_enabled=false)`, table)
+		if _, err := db.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func resetTableStorageParams(db *sql.DB) error {
+	for _, table := range []string{"blocks", "txs", "block_txs", "txins", "txouts"} {
+		stmt := fmt.Sprintf(`ALTER TABLE %s RESET (autovacuum_enabled)`, table)
+		if _, err := db.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func createPgcrypto(db *sql.DB) error {
+	_, err := db.Exec("CREATE EXTENSION IF NOT EXISTS pgcrypto WITH SCHEMA public") // shared by the chains
+	return err
+}
+
+func createIndexes(db *sql.DB, strategy string, verbose bool) error {
+	var start time.Time
+	// Adding a constraint or index if it does not exist is a little tricky in PG
+	if verbose {
+		log.Printf("  Starting blocks primary key...")
+	}
+	start = time.Now()
+	if _, err := db.Exec(`
+       DO $$
+       BEGIN
+         IF NOT EXISTS (SELECT constraint_name FROM information_schema.constraint_column_usage
+                         WHERE table_schema = current_schema() AND table_name = 'blocks' AND constraint_name = 'blocks_pkey') THEN
+            ALTER TABLE blocks ADD CONSTRAINT blocks_pkey PRIMARY KEY(id);
+         END IF;
+       END
+       $$;`); err != nil {
+		return err
+	}
+	if verbose {
+		log.Printf("  ...done in %s. Starting blocks prevhash index...", time.Now().Sub(start).Round(time.Millisecond))
+	}
+	start = time.Now()
+	if _, err := db.Exec("CREATE INDEX IF NOT EXISTS blocks_prevhash_idx ON blocks(prevhash);"); err != nil {
+		return err
+	}
+	if verbose {
+		log.Printf("  ...done in %s. Starting blocks prev_block_id index...", time.Now().Sub(start).Round(time.Millisecond))
+	}
+	start = time.Now()
+	if _, err := db.Exec("CREATE INDEX IF NOT EXISTS blocks_prev_block_id_idx ON blocks(prev_block_id);"); err != nil {
+		return err
+	}
+	if verbose {
+		log.Printf("  ...done in %s. Starting blocks chainwork index...", time.Now().Sub(start).Round(time.Millisecond))
+	}
+	start = time.Now()
+	if _, err := db.Exec("CREATE INDEX IF NOT EXISTS blocks_chainwork_idx ON blocks(chainwork DESC NULLS LAST);"); err != nil {
+		return err
+	}
+	if verbose {
+		log.Printf("  ...done in %s. Starting blocks hash index...", time.Now().Sub(start).Round(time.Millisecond))
+	}
+	start = time.Now()
+	if _, err := db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS blocks_hash_idx ON blocks(hash);"); err != nil {
+		return err
+	}
+	if verbose {
+		log.Printf("  ...done in %s. Starting blocks height index...", time.Now().Sub(start).Round(time.Millisecond))
+	}
+	start = time.Now()
+	if _, err := db.Exec("CREATE INDEX IF NOT EXISTS blocks_height_idx ON blocks(height);"); err != nil {
+		return err
+	}
+	if verbose {
+		log.Printf("  ...done in %s. Starting txs primary key...", time.Now().Sub(start).Round(time.Millisecond))
+	}
+	start = time.Now()
+	if _, err := db.Exec(`
+       DO $$
+       BEGIN
+         IF NOT EXISTS (SELECT constraint_name FROM information_schema.constraint_column_usage
+                         WHERE table_schema = current_schema() AND table_name = 'txs' AND constraint_name = 'txs_pkey') THEN
+            ALTER TABLE txs ADD CONSTRAINT txs_pkey PRIMARY KEY(id);
+         END IF;
+       END
+       $$;`); err != nil {
+		return err
+	}
+	if verbose {
+		log.Printf("  ...done in %s. Starting txs txid (hash) index...", time.Now().Sub(start).Round(time.Millisecond))
+	}
+	start = time.Now()
+	if _, err := db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS txs_txid_idx ON txs(txid);"); err != nil {
+		return err
+	}
+	if strategy != IndexStrategyBrin { // see brin.go
+		if verbose {
+			log.Printf("  ...done in %s. Starting block_txs block_id, n primary key...", time.Now().Sub(start).Round(time.Millisecond))
+		}
+		start = time.Now()
+		if _, err := db.Exec(`
+       DO $$
+       BEGIN
+         IF NOT EXISTS (SELECT constraint_name FROM information_schema.constraint_column_usage
+                         WHERE table_schema = current_schema() AND table_name = 'block_txs' AND constraint_name = 'block_txs_pkey') THEN
+            ALTER TABLE block_txs ADD CONSTRAINT block_txs_pkey PRIMARY KEY(block_id, n);
+         END IF;
+       END
+       $$;`); err != nil {
+			return err
+		}
+	}
+	if verbose {
+		log.Printf("  ...done in %s. Starting block_txs tx_id index...", time.Now().Sub(start).Round(time.Millisecond))
+	}
+	start = time.Now()
+	if _, err := db.Exec("CREATE INDEX IF NOT EXISTS block_txs_tx_id_idx ON block_txs(tx_id);"); err != nil {
+		return err
+	}
+	if verbose {
+		log.Printf("  ...done in %s. Creatng hash_type function...", time.Now().Sub(start).Round(time.Millisecond))
+	}
+	start = time.Now()
+	if _, err := db.Exec(`
+       CREATE OR REPLACE FUNCTION hash_type(_hash BYTEA) RETURNS TEXT AS $$
+       BEGIN
+         IF EXISTS (SELECT 1 FROM blocks WHERE hash = _hash) THEN
+           RETURN 'block';
+         ELSIF EXISTS (SELECT 1 FROM txs WHERE txid = _hash) THEN
+           RETURN 'tx';
+         END IF;
+         RETURN NULL;
+       END;
+       $$ LANGUAGE plpgsql;
+    `); err != nil {
+		return err
+	}
+	if verbose {
+		log.Printf("  ...done in %s. Starting txins (prevout_tx_id, prevout_tx_n) index...", time.Now().Sub(start).Round(time.Millisecond))
+	}
+	start = time.Now()
+	if _, err := db.Exec("CREATE INDEX IF NOT EXISTS txins_prevout_tx_id_prevout_n_idx ON txins(prevout_tx_id, prevout_n);"); err != nil {
+		return err
+	}
+	if strategy != IndexStrategyBrin {
+		if verbose {
+			log.Printf("  ...done in %s. Starting txins primary key...", time.Now().Sub(start).Round(time.Millisecond))
+		}
+		start = time.Now()
+		if _, err := db.Exec(`
+       DO $$
+       BEGIN
+         IF NOT EXISTS (SELECT constraint_name FROM information_schema.constraint_column_usage
+                         WHERE table_schema = current_schema() AND table_name = 'txins' AND constraint_name = 'txins_pkey') THEN
+            ALTER TABLE txins ADD CONSTRAINT txins_pkey PRIMARY KEY(tx_id, n);
+         END IF;
+       END
+       $$;`); err != nil {
+			return err
+		}
+	}
+	if verbose {
+		log.Printf("  ...done in %s. Starting txouts primary key...", time.Now().Sub(start).Round(time.Millisecond))
+	}
+	start = time.Now()
+	if _, err := db.Exec(`
+       DO $$
+       BEGIN
+         IF NOT EXISTS (SELECT constraint_name FROM information_schema.constraint_column_usage
+                         WHERE table_schema = current_schema() AND table_name = 'txouts' AND constraint_name = 'txouts_pkey') THEN
+            ALTER TABLE txouts ADD CONSTRAINT txouts_pkey PRIMARY KEY(tx_id, n);
+         END IF;
+       END
+       $$;`); err != nil {
+		return err
+	}
+	if verbose {
+		log.Printf("  ...done in %s. Starting txouts address prefix index...", time.Now().Sub(start).Round(time.Millisecond))
+	}
+	start = time.Now()
+	// The functions of the address indexes refer to each other by
+	// schema, since indexes are not always evaluated with the
+	// search_path (e.g. by pg_restore), the chain's (see chains.go).
+	var schema string
+	if err := db.QueryRow("SELECT quote_ident(current_schema())").Scan(&schema); err != nil {
+		return err
+	}
+	if _, err := db.Exec(fmt.Sprintf(`
+           CREATE OR REPLACE FUNCTION extract_address(scriptPubKey BYTEA) RETURNS BYTEA AS $$
+           BEGIN
+             IF SUBSTR(scriptPubKey, 1, 3) = E'\\x76a914' THEN  -- P2PKH
+               RETURN SUBSTR(scriptPubKey, 4, 20);
+             ELSIF SUBSTR(scriptPubKey, 1, 2) = E'\\xa914' THEN -- P2SH
+               RETURN SUBSTR(scriptPubKey, 3, 20);
+             ELSIF SUBSTR(scriptPubKey, 1, 2) = E'\\x0014' THEN -- P2WPKH
+               RETURN SUBSTR(scriptPubKey, 3, 20);
+             ELSIF SUBSTR(scriptPubKey, 1, 2) = E'\\x0020' THEN -- P2WSH
+               RETURN SUBSTR(scriptPubKey, 3, 32);
+             END IF;
+             RETURN NULL;
+           END;
+           $$ LANGUAGE plpgsql IMMUTABLE;
+
+           -- Cast the first 8 bytes of a BYTEA as a BIGINT
+           CREATE OR REPLACE FUNCTION bytes2int8(bytes BYTEA) RETURNS BIGINT AS $$
+           BEGIN
+             RETURN SUBSTR(bytes::text, 2, 16)::bit(64)::bigint;
+           END;
+           $$ LANGUAGE plpgsql IMMUTABLE;
+
+           -- Address prefix (txout)
+           CREATE OR REPLACE FUNCTION addr_prefix(scriptPubKey BYTEA) RETURNS BIGINT AS $$
+           BEGIN
+             RETURN %[1]s.bytes2int8(%[1]s.extract_address(scriptPubKey));
+           END;
+           $$ LANGUAGE plpgsql IMMUTABLE;
+       `, schema)); err != nil {
+		return err
+	}
+	// With deduplicated scripts txouts.scriptpubkey is NULL, the index
+	// is on the scripts table instead (see finishScripts).
+	if dedup, err := haveScriptDict(db); err != nil {
+		return err
+	} else if !dedup {
+		if _, err := db.Exec("CREATE INDEX IF NOT EXISTS txouts_addr_prefix_tx_id_idx ON txouts(addr_prefix(scriptpubkey), tx_id);"); err != nil {
+			return err
+		}
+	}
+	if verbose {
+		log.Printf("  ...done in %s. Starting txins address prefix index...", time.Now().Sub(start).Round(time.Millisecond))
+	}
+	start = time.Now()
+	if _, err := db.Exec(fmt.Sprintf(`
+        CREATE OR REPLACE FUNCTION parse_witness(witness BYTEA) RETURNS BYTEA[] AS $$
+        DECLARE
+          stack BYTEA[];
+          len INT;
+          pos INT = 1;
+          slen INT;
+        BEGIN
+          IF witness IS NULL OR witness = '' THEN
+            RETURN NULL;
+          END IF;
+          len = GET_BYTE(witness, 0); -- this is a varint, but whatever
+          WHILE len > 0 LOOP
+            slen = GET_BYTE(witness, pos);
+            IF slen = 253 THEN
+              slen = GET_BYTE(witness, pos+1) + GET_BYTE(witness, pos+2)*256;
+              pos = pos+2;
+              -- NB: There is a possibility of a 4-byte compact, but no transaction uses it
+            END IF;
+            stack = stack || SUBSTR(witness, pos+2, slen);
+            pos = pos + slen + 1;
+            len = len - 1;
+          END LOOP;
+          RETURN stack;
+        END;
+        $$ LANGUAGE plpgsql IMMUTABLE;
+
+        CREATE OR REPLACE FUNCTION extract_address(scriptsig BYTEA, witness BYTEA) RETURNS BYTEA AS $$
+        DECLARE
+          pub BYTEA;
+          sha BYTEA;
+          wits BYTEA[];
+          len INT;
+          pos INT;
+          op INT;
+        BEGIN
+          IF LENGTH(scriptsig) = 0 OR scriptsig IS NULL THEN     -- Native SegWit: P2WSH or P2WPKH
+            wits = %[1]s.parse_witness(witness);
+            pub = wits[array_length(wits, 1)];
+            sha = public.digest(pub, 'sha256');
+            IF ARRAY_LENGTH(wits, 1) = 2 AND LENGTH(pub) = 33 THEN       -- Most likely a P2WPKH
+              RETURN public.digest(sha, 'ripemd160');
+            ELSE       -- Most likely a P2WSH
+              RETURN sha;
+            END IF;
+          ELSE
+             len = GET_BYTE(scriptsig, 0);
+             IF len = LENGTH(scriptsig) - 1 THEN        -- Most likely a P2SH (or P2SH-P2W*)
+               RETURN public.digest(public.digest(SUBSTR(scriptsig, 2), 'sha256'), 'ripemd160');
+             ELSE  -- P2PKH or longer P2SH, either way the last thing is what we need
+               pos = 0;
+               WHILE pos < LENGTH(scriptsig)-1 LOOP
+                 op = GET_BYTE(scriptsig, pos);
+                 IF op > 0 AND op < 76 THEN
+                   len = op;
+                   pos = pos + 1;
+                 ELSEIF op = 76 THEN
+                   len = GET_BYTE(scriptsig, pos+1);
+                   pos = pos + 2;
+                 ELSEIF op = 77 THEN
+                   len = GET_BYTE(scriptsig, pos+1) + GET_BYTE(scriptsig, pos+2)*256;
+                   pos = pos + 3;
+                 ELSE
+                   pos = pos + 1;
+                   CONTINUE;
+                 END IF;
+                 pub = SUBSTR(scriptsig, pos+1, len);
+                 pos = pos + len;
+               END LOOP;
+               RETURN public.digest(public.digest(pub, 'sha256'), 'ripemd160');
+             END IF;
+          END IF;
+          RETURN NULL;
+        END;
+        $$ LANGUAGE plpgsql IMMUTABLE;
+
+        -- Address prefix (txin)
+        CREATE OR REPLACE FUNCTION addr_prefix(scriptsig BYTEA, witness BYTEA) RETURNS BIGINT AS $$
+        BEGIN
+          RETURN %[1]s.bytes2int8(%[1]s.extract_address(scriptsig, witness));
+        END;
+        $$ LANGUAGE plpgsql IMMUTABLE;
+
+        -- Partial/conditional index because coinbase txin scriptsigs are garbage
+        CREATE INDEX IF NOT EXISTS txins_addr_prefix_tx_id_idx ON txins(addr_prefix(scriptsig, witness), tx_id)
+         WHERE prevout_tx_id IS NOT NULL;
+       `, schema)); err != nil {
+		return err
+	}
+	if verbose {
+		log.Printf("  ...done in %s. Starting %s indexes on ordered columns...", time.Now().Sub(start).Round(time.Millisecond), strategy)
+	}
+	return createOrderedIndexes(db, strategy, verbose)
+}
+
+func createConstraints(db *sql.DB, verbose bool) error {
+	var start time.Time
+	if verbose {
+		log.Printf("  Starting blocks prev_block_id foreign key...")
+	}
+	start = time.Now()
+	if _, err := db.Exec(`
+	   DO $$
+	   BEGIN
+	     -- NB: table_name is the target/foreign table
+	     IF NOT EXISTS (SELECT constraint_name FROM information_schema.constraint_column_usage
+	                     WHERE table_schema = current_schema() AND table_name = 'blocks' AND constraint_name = 'blocks_prev_block_id_fkey') THEN
+	       ALTER TABLE blocks ADD CONSTRAINT blocks_prev_block_id_fkey FOREIGN KEY (prev_block_id) REFERENCES blocks(id);
+	     END IF;
+	   END
+	   $$;`); err != nil {
+		return err
+	}
+	if verbose {
+		log.Printf("  ...done in %s. Starting block_txs block_id foreign key...", time.Now().Sub(start).Round(time.Millisecond))
+	}
+	start = time.Now()
+	if _, err := db.Exec(`
+	   DO $$
+	   BEGIN
+	     -- NB: table_name is the target/foreign table
+	     IF NOT EXISTS (SELECT constraint_name FROM information_schema.constraint_column_usage
+	                     WHERE table_schema = current_schema() AND table_name = 'blocks' AND constraint_name = 'block_txs_block_id_fkey') THEN
+	       ALTER TABLE block_txs ADD CONSTRAINT block_txs_block_id_fkey FOREIGN KEY (block_id) REFERENCES blocks(id);
+	     END IF;
+	   END
+	   $$;`); err != nil {
+		return err
+	}
+	if verbose {
+		log.Printf("  ...done in %s. Starting block_txs tx_id foreign key...", time.Now().Sub(start).Round(time.Millisecond))
+	}
+	start = time.Now()
+	if _, err := db.Exec(`
+	   DO $$
+	   BEGIN
+	     -- NB: table_name is the target/foreign table
+	     IF NOT EXISTS (SELECT constraint_name FROM information_schema.constraint_column_usage
+	                     WHERE table_schema = current_schema() AND table_name = 'txs' AND constraint_name = 'block_txs_tx_id_fkey') THEN
+	       ALTER TABLE block_txs ADD CONSTRAINT block_txs_tx_id_fkey FOREIGN KEY (tx_id) REFERENCES txs(id);
+	     END IF;
+	   END
+	   $$;`); err != nil {
+		return err
+	}
+	if verbose {
+		log.Printf("  ...done in %s. Starting txins tx_id foreign key...", time.Now().Sub(start).Round(time.Millisecond))
+	}
+	start = time.Now()
+	if _, err := db.Exec(`
+       DO $$
+       BEGIN
+         -- NB: table_name is the target/foreign table
+         IF NOT EXISTS (SELECT constraint_name FROM information_schema.constraint_column_usage
+                         WHERE table_schema = current_schema() AND table_name = 'txs' AND constraint_name = 'txins_tx_id_fkey') THEN
+           ALTER TABLE txins ADD CONSTRAINT txins_tx_id_fkey FOREIGN KEY (tx_id) REFERENCES txs(id);
+         END IF;
+       END
+       $$;`); err != nil {
+		return err
+	}
+	if verbose {
+		log.Printf("  ...done in %s. Starting txouts tx_id foreign key...", time.Now().Sub(start).Round(time.Millisecond))
+	}
+	start = time.Now()
+	if _, err := db.Exec(`
+       DO $$
+       BEGIN
+         -- NB: table_name is the target/foreign table
+         IF NOT EXISTS (SELECT constraint_name FROM information_schema.constraint_column_usage
+                         WHERE table_schema = current_schema() AND table_name = 'txs' AND constraint_name = 'txouts_tx_id_fkey') THEN
+           ALTER TABLE txouts ADD CONSTRAINT txouts_tx_id_fkey FOREIGN KEY (tx_id) REFERENCES txs(id);
+         END IF;
+       END
+       $$;`); err != nil {
+		return err
+	}
+	if verbose {
+		log.Printf("  ...done in %s.", time.Now().Sub(start).Round(time.Millisecond))
+	}
+	return nil
+}
+
+// SetOrphans sets the orphan status of the blocks within window
+// heights of the tip of the best chain. If window is 0, the whole
+// table is updated, which takes a while on a large database, so when
+// following the chain it is called after each new block with a small
+// window instead. A reorg deeper than the window is not caught by
+// that, but by the next import, which covers the blocks it imported.
+//
+// The tip is the block with the most chainwork, as in Core, and of
+// those the first one received (lowest id). Should no block have a
+// chainwork (see prevblock.go), it is the highest block.
+//
+// The WITH RECURSIVE part connects rows by joining prev_block_id to id,
+// thereby building a list which starts at the tip and going towards
+// the beginning until no parent can be found, or the window is left.
+//
+// Then we LEFT JOIN the above to the blocks table, and where there is
+// no match (x.id IS NULL) we mark it as orphan.
+//
+// The version bits of the retarget periods of the blocks whose status
+// changed are counted again (see versionbits.go), in the same
+// transaction.
+
+func (w *PGWriter) SetOrphans(window int) error {
+	if window < 0 {
+		return fmt.Errorf("Negative orphan window %d.", window)
+	}
+	txn, err := w.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer txn.Rollback() // no-op after Commit
+
+	if _, err := txn.Exec("CREATE TEMP TABLE _orphan_periods (period INT NOT NULL) ON COMMIT DROP"); err != nil {
+		return err
+	}
+	if _, err := txn.Exec(fmt.Sprintf(`
+DO $$
+DECLARE
+  tip_id INT;
+  min_height INT = -1;
+BEGIN
+SELECT id INTO tip_id FROM blocks ORDER BY chainwork DESC NULLS LAST, height DESC, id LIMIT 1;
+IF (%[1]d > 0) THEN
+  SELECT height - %[1]d INTO min_height FROM blocks WHERE id = tip_id;
+END IF;
+WITH changed AS (
+UPDATE blocks
+   SET orphan = a.orphan
+  FROM (
+    SELECT blocks.id, x.id IS NULL AS orphan
+      FROM blocks
+      LEFT JOIN (
+        WITH RECURSIVE recur(id, prev_block_id, height) AS (
+          -- non-recursive term, executed once
+          SELECT id, prev_block_id, height
+            FROM blocks
+           WHERE id = tip_id
+          UNION ALL
+          -- recursive term, recur refers to previous iteration result
+          -- iteration stops when previous row prev_block_id finds no match OR
+          -- the window is left
+            SELECT blocks.id, blocks.prev_block_id, blocks.height
+              FROM recur
+              JOIN blocks ON blocks.id = recur.prev_block_id
+             WHERE recur.height > min_height
+        )
+        SELECT recur.id
+          FROM recur
+      ) x ON blocks.id = x.id
+     WHERE blocks.height >= min_height
+   ) a
+  WHERE blocks.id = a.id AND blocks.orphan <> a.orphan
+RETURNING blocks.height
+)
+INSERT INTO _orphan_periods SELECT DISTINCT height / %[2]d FROM changed;
+END
+ $$`, window, core.RetargetInterval)); err != nil {
+		return err
+	}
+
+	var periods []int
+	rows, err := txn.Query("SELECT period FROM _orphan_periods ORDER BY period")
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var period int
+		if err := rows.Scan(&period); err != nil {
+			rows.Close()
+			return err
+		}
+		periods = append(periods, period)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, period := range periods {
+		if _, err := countPeriod(txn, period); err != nil {
+			return fmt.Errorf("Counting version bits of period %d: %v", period, err)
+		}
+	}
+	return txn.Commit()
+}
+
+// The txins trigger function. Updates which do not change the prevout
+// (e.g. pruning, see prune.go) do not touch txouts.
+const txinsTriggerFunc = `
+CREATE OR REPLACE FUNCTION txins_after_trigger_func() RETURNS TRIGGER AS $$
+  BEGIN
+    IF (TG_OP = 'UPDATE' AND NEW.prevout_tx_id IS NOT DISTINCT FROM OLD.prevout_tx_id
+        AND NEW.prevout_n = OLD.prevout_n) THEN
+      RETURN NEW;
+    ELSIF (TG_OP = 'DELETE') THEN
+      IF OLD.prevout_tx_id IS NOT NULL THEN
+        UPDATE txouts SET spent = FALSE
+         WHERE tx_id = OLD.prevout_tx_id AND n = OLD.prevout_n;
+      END IF;
+      RETURN OLD;
+    ELSIF (TG_OP = 'UPDATE' OR TG_OP = 'INSERT') THEN
+      IF NEW.prevout_tx_id IS NOT NULL THEN
+        UPDATE txouts SET spent = TRUE
+         WHERE tx_id = NEW.prevout_tx_id AND n = NEW.prevout_n;
+        IF NOT FOUND THEN
+          RAISE EXCEPTION 'Unknown tx_id:prevout_n combination: %:%', NEW.prevout_tx_id, NEW.prevout_n;
+        END IF;
+      END IF;
+      RETURN NEW;
+    END IF;
+    RETURN NULL;
+  END;
+$$ LANGUAGE plpgsql;
+`
+
+func createTxinsTriggers(db *sql.DB) error {
+	if _, err := db.Exec(txinsTriggerFunc + `
+CREATE CONSTRAINT TRIGGER txins_after_trigger
+AFTER INSERT OR UPDATE OR DELETE ON txins DEFERRABLE
+  FOR EACH ROW EXECUTE PROCEDURE txins_after_trigger_func();
+
+        `); err != nil {
+		return err
+	}
+	return nil
+}
+
+func warmupCache(db *sql.DB, cache *txIdCache, blocks int) error {
+	stmt := `
+SELECT t.id, t.txid, o.cnt
+  FROM txs t
+  JOIN LATERAL (
+    SELECT COUNT(1) AS cnt
+      FROM txouts o
+      WHERE o.tx_id = t.id
+ ) o ON true
+ WHERE id > (
+    SELECT MIN(tx_id) FROM block_txs bt
+      JOIN (
+        SELECT id FROM blocks ORDER BY id DESC LIMIT $1
+      ) b ON b.id = bt.block_id
+    )
+ORDER BY t.id;
+`
+	rows, err := db.Query(stmt, blocks)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	var (
+		txid int64
+		hash core.Uint256
+		cnt  int
+	)
+	for rows.Next() {
+		if err := rows.Scan(&txid, &hash, &cnt); err != nil {
+			return err
+		}
+		cache.add(hash, txid, cnt)
+	}
+	return nil
+}
+
+func takeSnapshot(db *sql.DB, dataset string, height int, tag string) error {
+	log.Printf("Taking a ZFS snapshot: %s@%d%s", dataset, height, tag)
+	log.Printf("  calling pg_start_backup('blkchain', true)")
+	if _, err := db.Exec(`SELECT pg_start_backup('blkchain', true)`); err != nil {
+		return err
+	}
+	log.Printf("  executing COPY (SELECT) TO PROGRAM 'zfs snapshot %s@%d%s'", dataset, height, tag)
+	if _, err := db.Exec(fmt.Sprintf(`COPY (SELECT) TO PROGRAM 'zfs snapshot %s@%d%s'`, dataset, height, tag)); err != nil {
+		return err
+	}
+	log.Printf("  calling pg_stop_backup()")
+	if _, err := db.Exec(`SELECT pg_stop_backup()`); err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package pg
+
+import (
+	"database/sql"
+	"fmt"
+	"math/big"
+
+	"github.com/blkchain/blkchain/core"
+)
+
+// The prev_block_id of a block is the id of its parent, the block with
+// the hash of its PrevHash, so that walking the chain is a join on ids
+// rather than on hashes. It is resolved as the blocks are imported:
+// they come mostly in chain order, so the parent is nearly always one
+// of the last few blocks, otherwise it is looked up. During the first
+// import there is no index to look it up with, but then the blocks are
+// in height order from genesis. It is NULL for the genesis block, and
+// for a block whose parent is not in the database.
+//
+// The chainwork of a block, the work of its parent plus its own (see
+// core.BlockWork), comes along with it. It is NULL when that of the
+// parent is not known, e.g. when the first import did not start at the
+// genesis block, and so are the chainworks of all the descendants.
+
+const recentBlockIds = 100
+
+type recentBlock struct {
+	id   int
+	work *big.Int // chainwork, nil if not known
+}
+
+type blockIdCache struct {
+	blocks map[core.Uint256]recentBlock
+	order  []core.Uint256 // oldest first
+}
+
+func newBlockIdCache() *blockIdCache {
+	return &blockIdCache{blocks: make(map[core.Uint256]recentBlock, recentBlockIds)}
+}
+
+func (c *blockIdCache) add(hash core.Uint256, id int, work *big.Int) {
+	if len(c.order) == recentBlockIds {
+		delete(c.blocks, c.order[0])
+		c.order = c.order[1:]
+	}
+	c.blocks[hash] = recentBlock{id, work}
+	c.order = append(c.order, hash)
+}
+
+// prev returns the id and the chainwork of the block with the hash
+// prevHash, looking it up in the database if lookup and not recent (or
+// recent but without the chainwork), nil if not found.
+func (c *blockIdCache) prev(db *sql.DB, prevHash core.Uint256, lookup bool) (*int, *big.Int, error) {
+	if prevHash == (core.Uint256{}) {
+		return nil, nil, nil
+	}
+	rb, ok := c.blocks[prevHash]
+	if ok && (rb.work != nil || !lookup || db == nil) {
+		return &rb.id, rb.work, nil
+	}
+	if !lookup || db == nil {
+		return nil, nil, nil
+	}
+	var (
+		id   int
+		work sql.NullString
+	)
+	err := db.QueryRow("SELECT id, chainwork FROM blocks WHERE hash = $1", prevHash[:]).Scan(&id, &work)
+	if err == sql.ErrNoRows {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if !work.Valid {
+		return &id, nil, nil
+	}
+	w, ok := new(big.Int).SetString(work.String, 10)
+	if !ok {
+		return nil, nil, fmt.Errorf("Bad chainwork %q of block %v.", work.String, prevHash)
+	}
+	return &id, w, nil
+}
+
+// chainwork is that of the parent plus the work of bh, nil if the
+// parent is not the genesis block and its chainwork is not known.
+func chainwork(bh *core.BlockHeader, prevWork *big.Int) *big.Int {
+	if bh.PrevHash == (core.Uint256{}) {
+		return bh.Work()
+	}
+	if prevWork == nil {
+		return nil
+	}
+	return new(big.Int).Add(prevWork, bh.Work())
+}
+
+// numeric is a big.Int as a NUMERIC parameter.
+func numeric(n *big.Int) interface{} {
+	if n == nil {
+		return nil
+	}
+	return n.String()
+}
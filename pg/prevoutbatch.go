@@ -0,0 +1,51 @@
+package pg
+
+import (
+	"database/sql"
+
+	"github.com/blkchain/blkchain/core"
+	"github.com/lib/pq"
+)
+
+// After the first import the txins are written a block at a time, and
+// the prevout_tx_id of those whose prevout txid is not in the txid
+// cache is looked up for the whole block with one query at the commit,
+// rather than writing them with a NULL and fixing it with an UPDATE
+// joining txins to txs, so that the rows are written once and the
+// column is complete as soon as the block is. Should the lookup fail,
+// it is back to the UPDATE (fixPrevoutTxId) in the same transaction.
+
+// resolvePrevoutTxIds returns the tx ids of the prevout txids of ins
+// which are in the database. Like Core, a txid which is there twice
+// (BIP30) is the later tx.
+func resolvePrevoutTxIds(db *sql.DB, ins []*txInRec) (map[core.Uint256]int64, error) {
+	if db == nil {
+		return nil, nil
+	}
+	seen := make(map[core.Uint256]bool, len(ins))
+	hashes := make([][]byte, 0, len(ins))
+	for _, tr := range ins {
+		h := tr.txIn.PrevOut.Hash
+		if !seen[h] {
+			seen[h] = true
+			hashes = append(hashes, h[:])
+		}
+	}
+	rows, err := db.Query("SELECT txid, MAX(id) FROM txs WHERE txid = ANY($1) GROUP BY txid", pq.ByteaArray(hashes))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	result := make(map[core.Uint256]int64, len(hashes))
+	for rows.Next() {
+		var (
+			txid []byte
+			id   int64
+		)
+		if err := rows.Scan(&txid, &id); err != nil {
+			return nil, err
+		}
+		result[core.Uint256FromBytes(txid)] = id
+	}
+	return result, rows.Err()
+}
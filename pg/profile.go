@@ -0,0 +1,228 @@
+package pg
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/blkchain/blkchain/core"
+)
+
+// Import profiling. With WriterConfig.Profile every block gets a
+// blockMetrics which travels with its records to the writers, each of
+// which adds the time it spent copying them (and committing after
+// the block, if it did) for its table. Once all the records of a block
+// are written, a row with the times is recorded in import_metrics, so
+// that it can be seen what the import waits for: reading the blocks
+// (disk), hashing (CPU) or the COPYs (Postgres).
+
+// The tables the times are kept of, block_txs is counted with txs.
+const (
+	metricsBlocks = iota
+	metricsTxs
+	metricsTxIns
+	metricsTxOuts
+	nMetricsTables
+)
+
+// Rows written to import_metrics per transaction.
+const metricsBatch = 64
+
+type blockMetrics struct {
+	blockId int
+	height  int
+	nTxs    int
+	size    int
+	parse   time.Duration // BlockRec.ParseTime
+	hash    time.Duration
+	start   time.Time
+	total   time.Duration
+	copy    [nMetricsTables]int64 // nanoseconds, atomic
+	wg      sync.WaitGroup        // records not written yet
+}
+
+// now is the start of an operation timed, the zero time if not
+// profiling, which saves the system call.
+func (m *blockMetrics) now() time.Time {
+	if m == nil {
+		return time.Time{}
+	}
+	return time.Now()
+}
+
+// add counts a record sent to a writer, which must call done.
+func (m *blockMetrics) add() *blockMetrics {
+	if m != nil {
+		m.wg.Add(1)
+	}
+	return m
+}
+
+// done adds the time since start to the table.
+func (m *blockMetrics) done(table int, start time.Time) {
+	if m == nil {
+		return
+	}
+	atomic.AddInt64(&m.copy[table], int64(time.Since(start)))
+	m.wg.Done()
+}
+
+func (m *blockMetrics) blockHash(b *core.Block) core.Uint256 {
+	if m == nil {
+		return b.Hash()
+	}
+	start := time.Now()
+	hash := b.Hash()
+	m.hash += time.Since(start)
+	return hash
+}
+
+func (m *blockMetrics) txHash(tx *core.Tx) core.Uint256 {
+	if m == nil {
+		return tx.Hash()
+	}
+	start := time.Now()
+	hash := tx.Hash()
+	m.hash += time.Since(start)
+	return hash
+}
+
+func (m *blockMetrics) copyTime(table int) time.Duration {
+	return time.Duration(atomic.LoadInt64(&m.copy[table]))
+}
+
+type importProfiler struct {
+	db   *sql.DB // nil with nulldb, only slow blocks are logged
+	slow time.Duration
+	ch   chan *blockMetrics
+	wg   sync.WaitGroup
+}
+
+func createImportMetricsTable(db *sql.DB) error {
+	_, err := db.Exec(`
+  CREATE TABLE IF NOT EXISTS import_metrics (
+   block_id       INT NOT NULL PRIMARY KEY
+  ,height         INT NOT NULL
+  ,n_txs          INT NOT NULL
+  ,size           INT NOT NULL
+  ,parse_ms       REAL NOT NULL -- reading and decoding the block
+  ,hash_ms        REAL NOT NULL -- block and tx hashes
+  ,blocks_ms      REAL NOT NULL -- COPY and commits, per table
+  ,txs_ms         REAL NOT NULL -- with block_txs
+  ,txins_ms       REAL NOT NULL
+  ,txouts_ms      REAL NOT NULL
+  ,total_ms       REAL NOT NULL -- from the start of hashing to the last row written
+  ,recorded       TIMESTAMPTZ NOT NULL DEFAULT now()
+  );
+`)
+	return err
+}
+
+func newImportProfiler(db *sql.DB, slow time.Duration) *importProfiler {
+	p := &importProfiler{
+		db:   db,
+		slow: slow,
+		ch:   make(chan *blockMetrics, 1024),
+	}
+	p.wg.Add(1)
+	go p.run()
+	return p
+}
+
+// begin returns the metrics of the block, nil if not profiling.
+func (p *importProfiler) begin(br *blockRecSync) *blockMetrics {
+	if p == nil {
+		return nil
+	}
+	return &blockMetrics{
+		nTxs:  len(br.Txs),
+		size:  br.Size(),
+		parse: br.ParseTime,
+		start: time.Now(),
+	}
+}
+
+// record hands the metrics of a block whose records have all been
+// sent on to be written once they are.
+func (p *importProfiler) record(m *blockMetrics, br *blockRecSync) {
+	if p == nil {
+		return
+	}
+	m.blockId, m.height = br.Id, br.Height
+	p.ch <- m
+}
+
+func (p *importProfiler) close() {
+	if p == nil {
+		return
+	}
+	close(p.ch)
+	p.wg.Wait()
+}
+
+func (p *importProfiler) run() {
+	defer p.wg.Done()
+
+	batch := make([]*blockMetrics, 0, metricsBatch)
+	flush := func() {
+		if p.db != nil && len(batch) > 0 {
+			if err := writeImportMetrics(p.db, batch); err != nil {
+				log.Printf("Error writing import metrics: %v", err)
+			}
+		}
+		batch = batch[:0]
+	}
+
+	for m := range p.ch {
+		m.wg.Wait()
+		m.total = time.Since(m.start)
+		if p.slow > 0 && m.total+m.parse > p.slow {
+			log.Printf("Slow block %d (%d txs, %d KB): parse %v hash %v copy blocks %v txs %v txins %v txouts %v total %v",
+				m.height, m.nTxs, m.size/1024,
+				m.parse.Round(time.Millisecond), m.hash.Round(time.Millisecond),
+				m.copyTime(metricsBlocks).Round(time.Millisecond), m.copyTime(metricsTxs).Round(time.Millisecond),
+				m.copyTime(metricsTxIns).Round(time.Millisecond), m.copyTime(metricsTxOuts).Round(time.Millisecond),
+				m.total.Round(time.Millisecond))
+		}
+		if batch = append(batch, m); len(batch) == metricsBatch || len(p.ch) == 0 {
+			flush()
+		}
+	}
+	flush()
+}
+
+func writeImportMetrics(db *sql.DB, batch []*blockMetrics) error {
+	txn, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer txn.Rollback()
+
+	stmt, err := txn.Prepare(`
+INSERT INTO import_metrics (block_id, height, n_txs, size, parse_ms, hash_ms, blocks_ms, txs_ms, txins_ms, txouts_ms, total_ms)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+    ON CONFLICT (block_id) DO UPDATE SET
+       height = EXCLUDED.height, n_txs = EXCLUDED.n_txs, size = EXCLUDED.size,
+       parse_ms = EXCLUDED.parse_ms, hash_ms = EXCLUDED.hash_ms,
+       blocks_ms = EXCLUDED.blocks_ms, txs_ms = EXCLUDED.txs_ms,
+       txins_ms = EXCLUDED.txins_ms, txouts_ms = EXCLUDED.txouts_ms,
+       total_ms = EXCLUDED.total_ms, recorded = now()`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	ms := func(d time.Duration) float64 { return float64(d) / float64(time.Millisecond) }
+	for _, m := range batch {
+		if _, err := stmt.Exec(m.blockId, m.height, m.nTxs, m.size, ms(m.parse), ms(m.hash),
+			ms(m.copyTime(metricsBlocks)), ms(m.copyTime(metricsTxs)),
+			ms(m.copyTime(metricsTxIns)), ms(m.copyTime(metricsTxOuts)),
+			ms(m.total)); err != nil {
+			return fmt.Errorf("Block %d: %v", m.height, err)
+		}
+	}
+	return txn.Commit()
+}
@@ -0,0 +1,199 @@
+package pg
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+
+	"github.com/blkchain/blkchain/core"
+)
+
+// Import progress. Each writer records, in the same transaction as the
+// rows it copied, the high-water mark of the import at the commit
+// signal: the last block id and tx id sent to the writers. Since the
+// tables are committed independently, an interrupted import can leave
+// some of them further along than others (e.g. a block committed, but
+// not its inputs). On resume the rows past the lowest mark are deleted,
+// so that the import continues from a point where every table is
+// complete, rather than skipping the missing rows.
+
+var progressTables = []string{"blocks", "block_txs", "txs", "txins", "txouts"}
+
+// A high-water mark, sent along with a commit signal.
+type highWater struct {
+	blockId int
+	txId    int64
+}
+
+func createImportProgressTable(db *sql.DB) error {
+	_, err := db.Exec(`
+  CREATE TABLE IF NOT EXISTS import_progress (
+   tbl            TEXT NOT NULL PRIMARY KEY
+  ,block_id       INT NOT NULL -- committed up to and including
+  ,tx_id          BIGINT NOT NULL -- committed up to and including
+  );
+`)
+	return err
+}
+
+// Must be called in the transaction of the COPY, after the COPY.
+func recordProgress(txn *sql.Tx, table string, hw *highWater) error {
+	_, err := txn.Exec(`
+INSERT INTO import_progress (tbl, block_id, tx_id) VALUES ($1, $2, $3)
+    ON CONFLICT (tbl) DO UPDATE SET block_id = EXCLUDED.block_id, tx_id = EXCLUDED.tx_id`,
+		table, hw.blockId, hw.txId)
+	return err
+}
+
+func getProgress(db *sql.DB, marks map[string][]highWater) error {
+	rows, err := db.Query("SELECT tbl, block_id, tx_id FROM import_progress")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			table string
+			hw    highWater
+		)
+		if err := rows.Scan(&table, &hw.blockId, &hw.txId); err != nil {
+			return err
+		}
+		marks[table] = append(marks[table], hw)
+	}
+	return rows.Err()
+}
+
+// trimToProgress deletes the rows past the lowest high-water mark (see
+// above). Nothing is done unless every table has a mark (on every
+// shard), which is not the case for databases imported before the
+// marks were recorded, until every table has been committed once.
+func trimToProgress(db *sql.DB, shards *shardSet) error {
+	marks := make(map[string][]highWater)
+	if err := getProgress(db, marks); err != nil {
+		return err
+	}
+	dbs := []*sql.DB{db}
+	if shards != nil {
+		// txins and txouts are only recorded by the shards
+		delete(marks, "txins")
+		delete(marks, "txouts")
+		for _, sdb := range shards.dbs {
+			if err := getProgress(sdb, marks); err != nil {
+				return err
+			}
+		}
+		dbs = append(dbs, shards.dbs...)
+	}
+
+	var low highWater
+	consistent := true
+	for i, table := range progressTables {
+		want := 1
+		if shards != nil && (table == "txins" || table == "txouts") {
+			want = len(shards.dbs)
+		}
+		if len(marks[table]) != want {
+			log.Printf("No complete import progress (%s), not checking for partially committed blocks.", table)
+			return nil
+		}
+		for j, hw := range marks[table] {
+			if i == 0 && j == 0 {
+				low = hw
+				continue
+			}
+			if hw != low {
+				consistent = false
+			}
+			if hw.blockId < low.blockId {
+				low.blockId = hw.blockId
+			}
+			if hw.txId < low.txId {
+				low.txId = hw.txId
+			}
+		}
+	}
+	if consistent {
+		return nil
+	}
+
+	log.Printf("Tables were committed to different points, deleting rows past block id %d, tx id %d...", low.blockId, low.txId)
+	for _, db := range dbs {
+		if err := trimTables(db, low); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// trimTables deletes the rows past low from the tables of the import
+// and the tables with a tx_id of the parsers and hooks (see
+// txtables.go). What is derived from the blocks goes back to where
+// the blocks were left: the address events from the first height
+// deleted and the version bits of its period, which are recounted as
+// the blocks come in again. The totals kept by the protocols parser
+// (runes.mints, brc20_tokens.minted and brc20_balances) are not,
+// they may count again what is imported again.
+func trimTables(db *sql.DB, low highWater) error {
+	tables, err := existingTxTables(db, true)
+	if err != nil {
+		return err
+	}
+	var haveEvents, haveVersionBits bool
+	if err := db.QueryRow(`
+SELECT to_regclass(current_schema() || '.address_events_height') IS NOT NULL
+      ,to_regclass(current_schema() || '.versionbits_periods') IS NOT NULL`).Scan(&haveEvents, &haveVersionBits); err != nil {
+		return err
+	}
+
+	txn, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer txn.Rollback() // no-op after Commit
+
+	var fromHeight sql.NullInt64 // the first height deleted
+	if err := txn.QueryRow("SELECT MIN(height) FROM blocks WHERE id > $1", low.blockId).Scan(&fromHeight); err != nil {
+		return err
+	}
+
+	// Children first, the txins trigger (if any) unspends the outputs
+	// the deleted inputs spent.
+	type del struct {
+		sql string
+		arg interface{}
+	}
+	var stmts []del
+	for _, t := range tables {
+		stmts = append(stmts, del{fmt.Sprintf("DELETE FROM %s WHERE tx_id > $1", t), low.txId})
+	}
+	stmts = append(stmts,
+		del{"DELETE FROM txins WHERE tx_id > $1", low.txId},
+		del{"DELETE FROM txouts WHERE tx_id > $1", low.txId},
+		del{"DELETE FROM block_txs WHERE block_id > $1", low.blockId},
+		del{"DELETE FROM txs WHERE id > $1", low.txId},
+		del{"DELETE FROM blocks WHERE id > $1", low.blockId},
+	)
+	if fromHeight.Valid {
+		if haveEvents {
+			stmts = append(stmts,
+				del{"DELETE FROM address_events WHERE height >= $1", fromHeight.Int64},
+				del{"UPDATE address_events_height SET height = $1 - 1 WHERE height >= $1", fromHeight.Int64})
+		}
+		if haveVersionBits {
+			period := fromHeight.Int64 / core.RetargetInterval
+			stmts = append(stmts,
+				del{"DELETE FROM versionbits WHERE period >= $1", period},
+				del{"DELETE FROM versionbits_periods WHERE period >= $1", period})
+		}
+	}
+	for _, st := range stmts {
+		if _, err := txn.Exec(st.sql, st.arg); err != nil {
+			return err
+		}
+	}
+	if _, err := txn.Exec("UPDATE import_progress SET block_id = $1, tx_id = $2", low.blockId, low.txId); err != nil {
+		return err
+	}
+	return txn.Commit()
+}
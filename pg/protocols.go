@@ -0,0 +1,718 @@
+package pg
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"math/big"
+
+	"github.com/blkchain/blkchain/core"
+	"github.com/lib/pq"
+)
+
+// With WriterConfig.Protocols (or the "protocols" parser, see
+// parsers.go) the import also indexes the Runes and
+// BRC-20 token protocols (see core/runestone.go and core/brc20.go) into the
+// runes*, rune_* and brc20_* tables.
+//
+// Runes balances are tracked per output: the unspent outputs holding
+// runes are kept in memory (loaded from the database at startup), the
+// runes going into a transaction are allocated to its outputs per the
+// runestone edicts, the rest goes to the pointer or the first
+// non-OP_RETURN output. A cenotaph burns everything going in.
+//
+// BRC-20 deploys and mints are applied as they are inscribed, the
+// minted amount is credited to the scriptpubkey of the first output
+// of the inscribing transaction (where the inscription normally
+// lands). Transfer inscriptions are recorded, but moving the balance
+// requires tracking the inscription from output to output, which we
+// do not do.
+//
+// NB: The state is updated as blocks are written and chain splits are
+// not undone, the effects of an orphaned block remain. Mainnet only.
+
+const (
+	runesStartHeight = 840000
+	brc20StartHeight = 779832
+)
+
+type outKey struct {
+	hash core.Uint256
+	n    uint32
+}
+
+type runeBalance struct {
+	id     core.RuneId
+	amount *big.Int
+}
+
+type runeEntry struct {
+	id           core.RuneId
+	name         string
+	spacedName   string
+	divisibility uint8
+	symbol       *rune
+	premine      *big.Int
+	terms        *core.RuneTerms
+	turbo        bool
+	mints        *big.Int
+	txId         int64
+	dirty        bool
+}
+
+type brc20Token struct {
+	tick     string
+	max      *big.Rat
+	lim      *big.Rat
+	decimals int
+	minted   *big.Rat
+	txId     int64
+	dirty    bool
+}
+
+type protocolIndex struct {
+	runes    map[core.RuneId]*runeEntry
+	names    map[string]bool
+	balances map[outKey][]*runeBalance // unspent outputs holding runes
+	tokens   map[string]*brc20Token
+
+	// pending writes
+	newBalances [][]interface{}
+	events      [][]interface{}
+	brc20Ops    [][]interface{}
+	credits     map[[2]string]*big.Rat // tick, scriptpubkey
+}
+
+func newProtocolIndex() *protocolIndex {
+	return &protocolIndex{
+		runes:    make(map[core.RuneId]*runeEntry),
+		names:    make(map[string]bool),
+		balances: make(map[outKey][]*runeBalance),
+		tokens:   make(map[string]*brc20Token),
+		credits:  make(map[[2]string]*big.Rat),
+	}
+}
+
+func createProtocolTables(db *sql.DB) error {
+	_, err := db.Exec(`
+  CREATE TABLE IF NOT EXISTS runes (
+   block          BIGINT NOT NULL
+  ,tx             INT NOT NULL
+  ,name           TEXT NOT NULL
+  ,spaced_name    TEXT NOT NULL
+  ,divisibility   SMALLINT NOT NULL
+  ,symbol         TEXT
+  ,premine        NUMERIC(39) NOT NULL
+  ,cap            NUMERIC(39)
+  ,amount         NUMERIC(39)
+  ,height_start   BIGINT
+  ,height_end     BIGINT
+  ,offset_start   BIGINT
+  ,offset_end     BIGINT
+  ,turbo          BOOLEAN NOT NULL
+  ,mints          NUMERIC(39) NOT NULL
+  ,tx_id          BIGINT NOT NULL -- etching
+  ,PRIMARY KEY(block, tx)
+  );
+
+  -- Rune amounts held by each output, spent or not (see txouts.spent)
+  CREATE TABLE IF NOT EXISTS rune_balances (
+   tx_id          BIGINT NOT NULL
+  ,n              SMALLINT NOT NULL
+  ,rune_block     BIGINT NOT NULL
+  ,rune_tx        INT NOT NULL
+  ,amount         NUMERIC(39) NOT NULL
+  );
+  CREATE INDEX IF NOT EXISTS rune_balances_tx_id_n_idx ON rune_balances(tx_id, n);
+  CREATE INDEX IF NOT EXISTS rune_balances_rune_idx ON rune_balances(rune_block, rune_tx);
+
+  -- etch, mint and burn
+  CREATE TABLE IF NOT EXISTS rune_events (
+   tx_id          BIGINT NOT NULL
+  ,kind           TEXT NOT NULL
+  ,rune_block     BIGINT NOT NULL
+  ,rune_tx        INT NOT NULL
+  ,amount         NUMERIC(39) NOT NULL
+  );
+  CREATE INDEX IF NOT EXISTS rune_events_tx_id_idx ON rune_events(tx_id);
+
+  CREATE TABLE IF NOT EXISTS brc20_tokens (
+   tick           TEXT NOT NULL PRIMARY KEY
+  ,max            NUMERIC NOT NULL
+  ,lim            NUMERIC NOT NULL
+  ,decimals       SMALLINT NOT NULL
+  ,minted         NUMERIC NOT NULL
+  ,tx_id          BIGINT NOT NULL -- deploy
+  );
+
+  CREATE TABLE IF NOT EXISTS brc20_ops (
+   tx_id          BIGINT NOT NULL
+  ,n              SMALLINT NOT NULL -- txin
+  ,idx            SMALLINT NOT NULL -- envelope within the txin
+  ,op             TEXT NOT NULL
+  ,tick           TEXT NOT NULL
+  ,amount         NUMERIC
+  ,valid          BOOLEAN NOT NULL
+  ,scriptpubkey   BYTEA
+  );
+  CREATE INDEX IF NOT EXISTS brc20_ops_tick_idx ON brc20_ops(tick);
+
+  CREATE TABLE IF NOT EXISTS brc20_balances (
+   tick           TEXT NOT NULL
+  ,scriptpubkey   BYTEA NOT NULL
+  ,balance        NUMERIC NOT NULL
+  ,PRIMARY KEY(tick, scriptpubkey)
+  );
+
+  CREATE OR REPLACE VIEW rune_holders_v AS
+    SELECT r.spaced_name, rb.rune_block, rb.rune_tx, o.scriptpubkey, SUM(rb.amount) AS amount
+      FROM rune_balances rb
+      JOIN txouts_v o ON o.tx_id = rb.tx_id AND o.n = rb.n
+      JOIN runes r ON r.block = rb.rune_block AND r.tx = rb.rune_tx
+     WHERE NOT o.spent
+     GROUP BY 1, 2, 3, 4;
+`)
+	return err
+}
+
+func bigString(n *big.Int) interface{} {
+	if n == nil {
+		return nil
+	}
+	return n.String()
+}
+
+func uint64Ptr(n *uint64) interface{} {
+	if n == nil {
+		return nil
+	}
+	return int64(*n)
+}
+
+func (p *protocolIndex) Start(db *sql.DB) error {
+	if db == nil {
+		return nil
+	}
+	if err := createProtocolTables(db); err != nil {
+		return err
+	}
+	return p.load(db)
+}
+
+// Load the runes, the unspent rune balances and the BRC-20 tokens.
+func (p *protocolIndex) load(db *sql.DB) error {
+	rows, err := db.Query(`
+SELECT block, tx, name, spaced_name, divisibility, symbol, premine::TEXT, cap::TEXT, amount::TEXT
+      ,height_start, height_end, offset_start, offset_end, turbo, mints::TEXT, tx_id
+  FROM runes`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			r                                        runeEntry
+			symbol, cap, amount                      sql.NullString
+			premine, mints                           string
+			heightStart, heightEnd, offStart, offEnd sql.NullInt64
+		)
+		if err := rows.Scan(&r.id.Block, &r.id.Tx, &r.name, &r.spacedName, &r.divisibility, &symbol, &premine, &cap, &amount,
+			&heightStart, &heightEnd, &offStart, &offEnd, &r.turbo, &mints, &r.txId); err != nil {
+			return err
+		}
+		if symbol.Valid && len(symbol.String) > 0 {
+			s := []rune(symbol.String)[0]
+			r.symbol = &s
+		}
+		r.premine, _ = new(big.Int).SetString(premine, 10)
+		r.mints, _ = new(big.Int).SetString(mints, 10)
+		if amount.Valid {
+			t := &core.RuneTerms{}
+			t.Amount, _ = new(big.Int).SetString(amount.String, 10)
+			if cap.Valid {
+				t.Cap, _ = new(big.Int).SetString(cap.String, 10)
+			}
+			for _, x := range []struct {
+				v   sql.NullInt64
+				dst **uint64
+			}{{heightStart, &t.HeightStart}, {heightEnd, &t.HeightEnd}, {offStart, &t.OffsetStart}, {offEnd, &t.OffsetEnd}} {
+				if x.v.Valid {
+					v := uint64(x.v.Int64)
+					*x.dst = &v
+				}
+			}
+			r.terms = t
+		}
+		p.runes[r.id] = &r
+		p.names[r.name] = true
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	brows, err := db.Query(`
+SELECT t.txid, rb.n, rb.rune_block, rb.rune_tx, rb.amount::TEXT
+  FROM rune_balances rb
+  JOIN txouts o ON o.tx_id = rb.tx_id AND o.n = rb.n
+  JOIN txs t ON t.id = rb.tx_id
+ WHERE NOT o.spent`)
+	if err != nil {
+		return err
+	}
+	defer brows.Close()
+	for brows.Next() {
+		var (
+			key    outKey
+			b      runeBalance
+			amount string
+		)
+		if err := brows.Scan(&key.hash, &key.n, &b.id.Block, &b.id.Tx, &amount); err != nil {
+			return err
+		}
+		b.amount, _ = new(big.Int).SetString(amount, 10)
+		p.balances[key] = append(p.balances[key], &b)
+	}
+	if err := brows.Err(); err != nil {
+		return err
+	}
+
+	trows, err := db.Query("SELECT tick, max::TEXT, lim::TEXT, decimals, minted::TEXT, tx_id FROM brc20_tokens")
+	if err != nil {
+		return err
+	}
+	defer trows.Close()
+	for trows.Next() {
+		var (
+			t                brc20Token
+			max, lim, minted string
+		)
+		if err := trows.Scan(&t.tick, &max, &lim, &t.decimals, &minted, &t.txId); err != nil {
+			return err
+		}
+		t.max, _ = new(big.Rat).SetString(max)
+		t.lim, _ = new(big.Rat).SetString(lim)
+		t.minted, _ = new(big.Rat).SetString(minted)
+		p.tokens[t.tick] = &t
+	}
+	if err := trows.Err(); err != nil {
+		return err
+	}
+
+	log.Printf("Protocols: loaded %d runes, %d outputs holding runes, %d BRC-20 tokens.",
+		len(p.runes), len(p.balances), len(p.tokens))
+	return nil
+}
+
+func (p *protocolIndex) ParseTx(height, n int, txId int64, tx *core.Tx) {
+	if height >= runesStartHeight {
+		p.addRunes(height, n, txId, tx)
+	}
+	if height >= brc20StartHeight && tx.SegWit {
+		for _, ins := range tx.Inscriptions() {
+			if op := ins.ParseBrc20(); op != nil {
+				p.addBrc20(txId, tx, ins, op)
+			}
+		}
+	}
+}
+
+func isOpReturn(script []byte) bool {
+	return len(script) > 0 && script[0] == core.OP_RETURN
+}
+
+func (p *protocolIndex) event(txId int64, kind string, id core.RuneId, amount *big.Int) {
+	p.events = append(p.events, []interface{}{txId, kind, int64(id.Block), int32(id.Tx), amount.String()})
+}
+
+func (p *protocolIndex) addRunes(height, n int, txId int64, tx *core.Tx) {
+	unallocated := make(map[core.RuneId]*big.Int)
+	var order []core.RuneId // deterministic iteration
+	add := func(id core.RuneId, amount *big.Int) {
+		if bal, ok := unallocated[id]; ok {
+			bal.Add(bal, amount)
+		} else {
+			unallocated[id] = new(big.Int).Set(amount)
+			order = append(order, id)
+		}
+	}
+
+	for _, txin := range tx.TxIns {
+		key := outKey{txin.PrevOut.Hash, txin.PrevOut.N}
+		if bals, ok := p.balances[key]; ok {
+			for _, b := range bals {
+				add(b.id, b.amount)
+			}
+			delete(p.balances, key)
+		}
+	}
+
+	rs := tx.DecodeRunestone()
+	if rs == nil && len(unallocated) == 0 {
+		return
+	}
+
+	if rs != nil && rs.Cenotaph {
+		for _, id := range order {
+			p.event(txId, "burn", id, unallocated[id])
+		}
+		return
+	}
+
+	var etched *core.RuneId
+	if rs != nil {
+		if rs.Mint != nil {
+			if amount := p.mint(*rs.Mint, height); amount != nil {
+				add(*rs.Mint, amount)
+				p.event(txId, "mint", *rs.Mint, amount)
+			}
+		}
+		if rs.Etching != nil {
+			id := core.RuneId{Block: uint64(height), Tx: uint32(n)}
+			if p.etch(id, txId, rs.Etching) {
+				etched = &id
+				premine := new(big.Int)
+				if rs.Etching.Premine != nil {
+					premine.Set(rs.Etching.Premine)
+				}
+				add(id, premine)
+				p.event(txId, "etch", id, premine)
+			}
+		}
+	}
+
+	nOuts := len(tx.TxOuts)
+	alloc := make([]map[core.RuneId]*big.Int, nOuts)
+	allocate := func(out int, id core.RuneId, amount *big.Int) {
+		if amount.Sign() == 0 {
+			return
+		}
+		if alloc[out] == nil {
+			alloc[out] = make(map[core.RuneId]*big.Int)
+		}
+		if a, ok := alloc[out][id]; ok {
+			a.Add(a, amount)
+		} else {
+			alloc[out][id] = new(big.Int).Set(amount)
+		}
+		bal := unallocated[id]
+		bal.Sub(bal, amount)
+	}
+
+	var spendable []int // non-OP_RETURN outputs
+	for i, out := range tx.TxOuts {
+		if !isOpReturn(out.ScriptPubKey) {
+			spendable = append(spendable, i)
+		}
+	}
+
+	if rs != nil {
+		for _, e := range rs.Edicts {
+			id := e.Id
+			if id == (core.RuneId{}) {
+				if etched == nil {
+					continue
+				}
+				id = *etched
+			}
+			bal, ok := unallocated[id]
+			if !ok || bal.Sign() == 0 {
+				continue
+			}
+			if int(e.Output) == nOuts { // split among all spendable outputs
+				if len(spendable) == 0 {
+					continue
+				}
+				if e.Amount.Sign() == 0 {
+					each, rem := new(big.Int).DivMod(bal, big.NewInt(int64(len(spendable))), new(big.Int))
+					r := int(rem.Int64())
+					for i, out := range spendable {
+						amt := new(big.Int).Set(each)
+						if i < r {
+							amt.Add(amt, big.NewInt(1))
+						}
+						allocate(out, id, amt)
+					}
+				} else {
+					for _, out := range spendable {
+						amt := e.Amount
+						if amt.Cmp(bal) > 0 {
+							amt = new(big.Int).Set(bal)
+						}
+						allocate(out, id, amt)
+						if bal.Sign() == 0 {
+							break
+						}
+					}
+				}
+			} else {
+				amt := e.Amount
+				if amt.Sign() == 0 || amt.Cmp(bal) > 0 {
+					amt = new(big.Int).Set(bal)
+				}
+				allocate(int(e.Output), id, amt)
+			}
+		}
+	}
+
+	// The rest goes to the pointer or the first spendable output
+	target := -1
+	if rs != nil && rs.Pointer != nil {
+		target = int(*rs.Pointer)
+	} else if len(spendable) > 0 {
+		target = spendable[0]
+	}
+	for _, id := range order {
+		if bal := unallocated[id]; bal.Sign() > 0 {
+			if target < 0 {
+				p.event(txId, "burn", id, bal)
+				bal.SetInt64(0)
+			} else {
+				allocate(target, id, new(big.Int).Set(bal))
+			}
+		}
+	}
+
+	hash := tx.Hash()
+	for out, a := range alloc {
+		if a == nil {
+			continue
+		}
+		for _, id := range order {
+			amount, ok := a[id]
+			if !ok {
+				continue
+			}
+			if isOpReturn(tx.TxOuts[out].ScriptPubKey) {
+				p.event(txId, "burn", id, amount)
+				continue
+			}
+			key := outKey{hash, uint32(out)}
+			p.balances[key] = append(p.balances[key], &runeBalance{id, amount})
+			p.newBalances = append(p.newBalances, []interface{}{txId, out, int64(id.Block), int32(id.Tx), amount.String()})
+		}
+	}
+}
+
+// Returns the amount minted, nil if the mint is not possible.
+func (p *protocolIndex) mint(id core.RuneId, height int) *big.Int {
+	r := p.runes[id]
+	if r == nil || r.terms == nil || r.terms.Amount == nil || r.terms.Cap == nil {
+		return nil
+	}
+	t, h := r.terms, uint64(height)
+	if r.mints.Cmp(t.Cap) >= 0 ||
+		(t.HeightStart != nil && h < *t.HeightStart) ||
+		(t.HeightEnd != nil && h >= *t.HeightEnd) ||
+		(t.OffsetStart != nil && h < r.id.Block+*t.OffsetStart) ||
+		(t.OffsetEnd != nil && h >= r.id.Block+*t.OffsetEnd) {
+		return nil
+	}
+	r.mints.Add(r.mints, big.NewInt(1))
+	r.dirty = true
+	return t.Amount
+}
+
+func (p *protocolIndex) etch(id core.RuneId, txId int64, e *core.RuneEtching) bool {
+	num := e.Rune
+	if num == nil {
+		num = core.ReservedRune(id)
+	} else if num.Cmp(core.RuneReserved) >= 0 {
+		return false // reserved names cannot be etched explicitly
+	}
+	name := core.RuneName(num)
+	if p.names[name] {
+		return false
+	}
+	premine := new(big.Int)
+	if e.Premine != nil {
+		premine.Set(e.Premine)
+	}
+	p.runes[id] = &runeEntry{
+		id:           id,
+		name:         name,
+		spacedName:   core.SpacedRuneName(name, e.Spacers),
+		divisibility: e.Divisibility,
+		symbol:       e.Symbol,
+		premine:      premine,
+		terms:        e.Terms,
+		turbo:        e.Turbo,
+		mints:        new(big.Int),
+		txId:         txId,
+		dirty:        true,
+	}
+	p.names[name] = true
+	return true
+}
+
+func (p *protocolIndex) addBrc20(txId int64, tx *core.Tx, ins *core.Inscription, op *core.Brc20Op) {
+	var owner []byte
+	if len(tx.TxOuts) > 0 {
+		owner = tx.TxOuts[0].ScriptPubKey
+	}
+
+	valid, amount := false, op.Amt
+	t := p.tokens[op.Tick]
+	switch op.Op {
+	case core.Brc20Deploy:
+		if t == nil {
+			p.tokens[op.Tick] = &brc20Token{
+				tick:     op.Tick,
+				max:      op.Max,
+				lim:      op.Lim,
+				decimals: op.Decimals,
+				minted:   new(big.Rat),
+				txId:     txId,
+				dirty:    true,
+			}
+			valid, amount = true, op.Max
+		}
+	case core.Brc20Mint:
+		if t != nil && brc20Decimals(amount, t.decimals) && amount.Cmp(t.lim) <= 0 && t.minted.Cmp(t.max) < 0 {
+			left := new(big.Rat).Sub(t.max, t.minted)
+			if amount.Cmp(left) > 0 {
+				amount = left
+			}
+			t.minted.Add(t.minted, amount)
+			t.dirty = true
+			if owner != nil {
+				key := [2]string{op.Tick, string(owner)}
+				if c, ok := p.credits[key]; ok {
+					c.Add(c, amount)
+				} else {
+					p.credits[key] = new(big.Rat).Set(amount)
+				}
+			}
+			valid = true
+		}
+	case core.Brc20Transfer:
+		valid = t != nil && brc20Decimals(amount, t.decimals)
+	}
+
+	var amt interface{}
+	if amount != nil {
+		amt = amount.FloatString(core.Brc20MaxDecimals)
+	}
+	p.brc20Ops = append(p.brc20Ops, []interface{}{txId, ins.Input, ins.Index, op.Op, op.Tick, amt, valid, owner})
+}
+
+// Whether the amount has at most the given number of decimals.
+func brc20Decimals(r *big.Rat, decimals int) bool {
+	scaled := new(big.Rat).Mul(r, new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(decimals)), nil)))
+	return scaled.IsInt()
+}
+
+func copyRows(txn *sql.Tx, table string, cols []string, rows [][]interface{}) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	stmt, err := txn.Prepare(pq.CopyIn(table, cols...))
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		if _, err := stmt.Exec(row...); err != nil {
+			stmt.Close()
+			return err
+		}
+	}
+	if _, err := stmt.Exec(); err != nil {
+		stmt.Close()
+		return err
+	}
+	return stmt.Close()
+}
+
+func (p *protocolIndex) Flush(db *sql.DB) error {
+	if db == nil {
+		p.newBalances, p.events, p.brc20Ops = nil, nil, nil
+		p.credits = make(map[[2]string]*big.Rat)
+		return nil
+	}
+
+	txn, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := p.flushTxn(txn); err != nil {
+		txn.Rollback()
+		return err
+	}
+	if err := txn.Commit(); err != nil {
+		return err
+	}
+
+	p.newBalances, p.events, p.brc20Ops = nil, nil, nil
+	p.credits = make(map[[2]string]*big.Rat)
+	for _, r := range p.runes {
+		r.dirty = false
+	}
+	for _, t := range p.tokens {
+		t.dirty = false
+	}
+	return nil
+}
+
+func (p *protocolIndex) flushTxn(txn *sql.Tx) error {
+	for _, r := range p.runes {
+		if !r.dirty {
+			continue
+		}
+		var symbol interface{}
+		if r.symbol != nil {
+			symbol = string(*r.symbol)
+		}
+		var (
+			cap, amount                interface{}
+			hStart, hEnd, oStart, oEnd interface{}
+		)
+		if t := r.terms; t != nil {
+			cap, amount = bigString(t.Cap), bigString(t.Amount)
+			hStart, hEnd = uint64Ptr(t.HeightStart), uint64Ptr(t.HeightEnd)
+			oStart, oEnd = uint64Ptr(t.OffsetStart), uint64Ptr(t.OffsetEnd)
+		}
+		if _, err := txn.Exec(`
+INSERT INTO runes (block, tx, name, spaced_name, divisibility, symbol, premine, cap, amount
+                  ,height_start, height_end, offset_start, offset_end, turbo, mints, tx_id)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)
+    ON CONFLICT (block, tx) DO UPDATE SET mints = EXCLUDED.mints`,
+			int64(r.id.Block), int32(r.id.Tx), r.name, r.spacedName, int(r.divisibility), symbol, r.premine.String(), cap, amount,
+			hStart, hEnd, oStart, oEnd, r.turbo, r.mints.String(), r.txId); err != nil {
+			return fmt.Errorf("runes: %v", err)
+		}
+	}
+
+	if err := copyRows(txn, "rune_balances", []string{"tx_id", "n", "rune_block", "rune_tx", "amount"}, p.newBalances); err != nil {
+		return fmt.Errorf("rune_balances: %v", err)
+	}
+	if err := copyRows(txn, "rune_events", []string{"tx_id", "kind", "rune_block", "rune_tx", "amount"}, p.events); err != nil {
+		return fmt.Errorf("rune_events: %v", err)
+	}
+	if err := copyRows(txn, "brc20_ops", []string{"tx_id", "n", "idx", "op", "tick", "amount", "valid", "scriptpubkey"}, p.brc20Ops); err != nil {
+		return fmt.Errorf("brc20_ops: %v", err)
+	}
+
+	for _, t := range p.tokens {
+		if !t.dirty {
+			continue
+		}
+		if _, err := txn.Exec(`
+INSERT INTO brc20_tokens (tick, max, lim, decimals, minted, tx_id)
+VALUES ($1, $2, $3, $4, $5, $6)
+    ON CONFLICT (tick) DO UPDATE SET minted = EXCLUDED.minted`,
+			t.tick, t.max.FloatString(t.decimals), t.lim.FloatString(t.decimals), t.decimals,
+			t.minted.FloatString(t.decimals), t.txId); err != nil {
+			return fmt.Errorf("brc20_tokens: %v", err)
+		}
+	}
+
+	for key, amount := range p.credits {
+		if _, err := txn.Exec(`
+INSERT INTO brc20_balances (tick, scriptpubkey, balance)
+VALUES ($1, $2, $3)
+    ON CONFLICT (tick, scriptpubkey) DO UPDATE SET balance = brc20_balances.balance + EXCLUDED.balance`,
+			key[0], []byte(key[1]), amount.FloatString(core.Brc20MaxDecimals)); err != nil {
+			return fmt.Errorf("brc20_balances: %v", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package pg
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Script pruning, for when only the UTXO set and the flows of value
+// matter. Once an output has been spent for more than the prune depth
+// (in blocks), its scriptpubkey, and the scriptsig and witness of the
+// input spending it, are set to NULL. Scripts are the bulk of the
+// database, so this saves most of the space (after a VACUUM, which
+// makes the space reusable, or VACUUM FULL to return it to the OS).
+//
+// Pruned inputs and outputs no longer show up in the address queries,
+// and the affected transactions can no longer be serialized.
+// Unspent outputs are never pruned. The last pruned height is kept in
+// pruned_height, so pruning continues where it left off.
+
+const (
+	MinPruneDepth = 100
+	pruneBatch    = 1000 // blocks per transaction
+)
+
+// PruneScripts prunes the scripts of outputs spent more than depth
+// blocks ago, see above. It can be run while the import is following
+// the chain.
+func PruneScripts(connstr string, depth int) error {
+	db, err := sql.Open("postgres", connstr)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return pruneScripts(db, depth, true)
+}
+
+// PruneScripts prunes scripts if PruneDepth is set in the WriterConfig.
+func (w *PGWriter) PruneScripts() error {
+	if w.db == nil || w.cfg.PruneDepth <= 0 {
+		return nil
+	}
+	return pruneScripts(w.db, w.cfg.PruneDepth, false)
+}
+
+func pruneScripts(db *sql.DB, depth int, verbose bool) error {
+	if depth < MinPruneDepth {
+		return fmt.Errorf("Prune depth must be at least %d.", MinPruneDepth)
+	}
+
+	// The trigger function must ignore the updates of the scriptsig
+	// (it did not in older versions).
+	if _, err := db.Exec(txinsTriggerFunc + `
+  ALTER TABLE txouts ALTER COLUMN scriptpubkey DROP NOT NULL;
+  ALTER TABLE txins ALTER COLUMN scriptsig DROP NOT NULL;
+  CREATE TABLE IF NOT EXISTS pruned_height (
+   height         INT NOT NULL -- last height whose inputs were pruned
+  );
+`); err != nil {
+		return err
+	}
+
+	dedup, err := haveScriptDict(db)
+	if err != nil {
+		return err
+	}
+	setScript := "scriptpubkey = NULL"
+	if dedup {
+		setScript = "scriptpubkey = NULL, script_id = NULL"
+	}
+
+	var from, tip int
+	if err := db.QueryRow("SELECT COALESCE(MAX(height), -1) + 1 FROM pruned_height").Scan(&from); err != nil {
+		return err
+	}
+	if err := db.QueryRow("SELECT COALESCE(MAX(height), -1) FROM blocks WHERE NOT orphan").Scan(&tip); err != nil {
+		return err
+	}
+
+	for to := tip - depth; from <= to; from += pruneBatch {
+		last := from + pruneBatch - 1
+		if last > to {
+			last = to
+		}
+		start := time.Now()
+		if err := pruneHeights(db, from, last, setScript); err != nil {
+			return err
+		}
+		if verbose {
+			log.Printf("  Pruned up to height %d in %s.", last, time.Now().Sub(start).Round(time.Millisecond))
+		}
+	}
+	return nil
+}
+
+func pruneHeights(db *sql.DB, from, to int, setScript string) error {
+	txn, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	// The outputs spent by the inputs in these blocks, then the inputs
+	if _, err := txn.Exec(fmt.Sprintf(`
+UPDATE txouts o
+   SET %s
+  FROM blocks b
+  JOIN block_txs bt ON bt.block_id = b.id
+  JOIN txins i ON i.tx_id = bt.tx_id
+ WHERE b.height BETWEEN $1 AND $2
+   AND NOT b.orphan
+   AND o.tx_id = i.prevout_tx_id
+   AND o.n = i.prevout_n`, setScript), from, to); err != nil {
+		txn.Rollback()
+		return err
+	}
+	if _, err := txn.Exec(`
+UPDATE txins i
+   SET scriptsig = NULL, witness = NULL
+  FROM blocks b
+  JOIN block_txs bt ON bt.block_id = b.id
+ WHERE b.height BETWEEN $1 AND $2
+   AND NOT b.orphan
+   AND i.tx_id = bt.tx_id
+   AND i.prevout_tx_id IS NOT NULL`, from, to); err != nil {
+		txn.Rollback()
+		return err
+	}
+	if _, err := txn.Exec("DELETE FROM pruned_height"); err != nil {
+		txn.Rollback()
+		return err
+	}
+	if _, err := txn.Exec("INSERT INTO pruned_height (height) VALUES ($1)", to); err != nil {
+		txn.Rollback()
+		return err
+	}
+	return txn.Commit()
+}
@@ -0,0 +1,172 @@
+package pg
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/blkchain/blkchain/core"
+	"github.com/lib/pq"
+)
+
+// Auditing a PSBT against the indexed chain: for every input the
+// output it spends is looked up, with its value, the height of the
+// block it is in and whether (and by which tx) it is already spent.
+// The values and scripts the PSBT claims for its inputs (witness or
+// non-witness UTXO) are compared to the database, and the fee is
+// computed from the database values where known, else from the PSBT.
+
+type PSBTReport struct {
+	TxId       core.Uint256        `json:"txid"`
+	Version    uint32              `json:"psbt_version"`
+	Height     int                 `json:"height"` // of the database
+	Inputs     []*PSBTInputReport  `json:"inputs"`
+	Outputs    []*PSBTOutputReport `json:"outputs"`
+	InputTotal int64               `json:"input_total"`
+	OutTotal   int64               `json:"output_total"`
+	Fee        *int64              `json:"fee"` // nil if an input value is unknown
+	FeeRate    *float64            `json:"fee_rate,omitempty"`
+	Problems   []string            `json:"problems,omitempty"`
+}
+
+type PSBTInputReport struct {
+	N             int           `json:"n"`
+	PrevOutHash   core.Uint256  `json:"prevout_hash"`
+	PrevOutN      uint32        `json:"prevout_n"`
+	Value         *int64        `json:"value"`                  // from the database, else the PSBT
+	ScriptPubKey  string        `json:"scriptpubkey,omitempty"` // hex
+	Found         bool          `json:"found"`                  // in the database
+	Height        *int          `json:"height,omitempty"`
+	Confirmations int           `json:"confirmations,omitempty"`
+	Spent         bool          `json:"spent"`
+	SpentBy       *core.Uint256 `json:"spent_by,omitempty"`
+	Signatures    int           `json:"signatures"`
+	Finalized     bool          `json:"finalized"`
+}
+
+type PSBTOutputReport struct {
+	N            int    `json:"n"`
+	Value        int64  `json:"value"`
+	ScriptPubKey string `json:"scriptpubkey"` // hex
+}
+
+func (r *PSBTReport) problem(format string, args ...interface{}) {
+	r.Problems = append(r.Problems, fmt.Sprintf(format, args...))
+}
+
+// CheckPSBT cross-references the inputs of the PSBT with the
+// database. The report has the problems found (unknown, spent or
+// mismatching prevouts), an error is returned only if the lookup
+// could not be made.
+func (e *Explorer) CheckPSBT(p *core.PSBT) (*PSBTReport, error) {
+	tx := p.Tx
+	r := &PSBTReport{TxId: tx.Hash(), Version: p.Version}
+
+	for n, out := range tx.TxOuts {
+		r.Outputs = append(r.Outputs, &PSBTOutputReport{N: n, Value: out.Value, ScriptPubKey: hex.EncodeToString(out.ScriptPubKey)})
+		r.OutTotal += out.Value
+	}
+
+	hashes := make([][]byte, len(tx.TxIns))
+	ns := make([]int64, len(tx.TxIns))
+	for n, in := range tx.TxIns {
+		hash := in.PrevOut.Hash
+		hashes[n], ns[n] = hash[:], int64(in.PrevOut.N)
+	}
+
+	if err := e.db.Get(&r.Height, "SELECT COALESCE(MAX(height), -1) FROM blocks WHERE NOT orphan"); err != nil {
+		return nil, err
+	}
+
+	// From the primary, a replica may not have seen the latest spends.
+	var prevOuts []struct {
+		N            int           `db:"n"`
+		Value        int64         `db:"value"`
+		ScriptPubKey []byte        `db:"scriptpubkey"`
+		Spent        bool          `db:"spent"`
+		Height       sql.NullInt64 `db:"height"`
+		SpentBy      []byte        `db:"spent_by"`
+	}
+	if err := e.db.Select(&prevOuts, `
+SELECT i.n - 1 AS n, o.value, o.scriptpubkey, o.spent,
+       (SELECT MIN(b.height) FROM block_txs bt JOIN blocks b ON b.id = bt.block_id
+         WHERE bt.tx_id = t.id AND NOT b.orphan) AS height,
+       (SELECT st.txid FROM txins si JOIN txs st ON st.id = si.tx_id
+         WHERE si.prevout_tx_id = t.id AND si.prevout_n = i.vout LIMIT 1) AS spent_by
+  FROM unnest($1::bytea[], $2::int[]) WITH ORDINALITY AS i(txid, vout, n)
+  JOIN txs t ON t.txid = i.txid
+  JOIN txouts_v o ON o.tx_id = t.id AND o.n = i.vout`, pq.Array(hashes), pq.Array(ns)); err != nil {
+		return nil, err
+	}
+
+	r.Inputs = make([]*PSBTInputReport, len(tx.TxIns))
+	scripts := make([][]byte, len(tx.TxIns))
+	for n, in := range tx.TxIns {
+		ir := &PSBTInputReport{N: n, PrevOutHash: in.PrevOut.Hash, PrevOutN: in.PrevOut.N}
+		if n < len(p.Inputs) {
+			ir.Signatures, ir.Finalized = p.Inputs[n].PartialSigs, p.Inputs[n].IsFinalized()
+		}
+		r.Inputs[n] = ir
+	}
+	for _, po := range prevOuts {
+		ir := r.Inputs[po.N]
+		value := po.Value
+		ir.Found, ir.Value, ir.Spent = true, &value, po.Spent
+		ir.ScriptPubKey = hex.EncodeToString(po.ScriptPubKey)
+		scripts[po.N] = po.ScriptPubKey
+		if po.Height.Valid {
+			h := int(po.Height.Int64)
+			ir.Height, ir.Confirmations = &h, r.Height-h+1
+		}
+		if po.SpentBy != nil {
+			txid := core.Uint256FromBytes(po.SpentBy)
+			ir.SpentBy = &txid
+		}
+	}
+
+	known := true
+	for n, ir := range r.Inputs {
+		var utxo *core.TxOut
+		if n < len(p.Inputs) {
+			utxo = p.Inputs[n].Utxo(tx.TxIns[n].PrevOut)
+		}
+		switch {
+		case !ir.Found && utxo == nil:
+			r.problem("Input %d: prevout %v:%d not found, value unknown", n, ir.PrevOutHash, ir.PrevOutN)
+		case !ir.Found:
+			r.problem("Input %d: prevout %v:%d not found", n, ir.PrevOutHash, ir.PrevOutN)
+			value := utxo.Value
+			ir.Value, ir.ScriptPubKey = &value, hex.EncodeToString(utxo.ScriptPubKey)
+		case utxo != nil && (utxo.Value != *ir.Value || !bytes.Equal(utxo.ScriptPubKey, scripts[n])):
+			r.problem("Input %d: the PSBT UTXO does not match the database", n)
+		}
+		if ir.Spent {
+			if ir.SpentBy != nil {
+				r.problem("Input %d: prevout already spent by %v", n, *ir.SpentBy)
+			} else {
+				r.problem("Input %d: prevout already spent", n)
+			}
+		}
+		if ir.Value == nil {
+			known = false
+		} else {
+			r.InputTotal += *ir.Value
+		}
+	}
+
+	if known {
+		fee := r.InputTotal - r.OutTotal
+		r.Fee = &fee
+		if fee < 0 {
+			r.problem("Outputs exceed inputs by %d sat", -fee)
+		}
+		// The unsigned tx is smaller than the signed one, the rate is an
+		// upper bound.
+		if vsize := tx.VirtualSize(); vsize > 0 {
+			rate := float64(fee) / float64(vsize)
+			r.FeeRate = &rate
+		}
+	}
+	return r, nil
+}
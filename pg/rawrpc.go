@@ -0,0 +1,171 @@
+package pg
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/blkchain/blkchain/core"
+	"github.com/jmoiron/sqlx"
+)
+
+// The queries behind the bitcoind compatible JSON-RPC methods of the
+// server (see serve/rpc.go): blocks and txs are rebuilt from the rows
+// (see readBlockWhere), so pruned scripts or unresolved prevouts make
+// them fail, and the confirmations are as of the main chain tip. There
+// is no mempool, only confirmed txs and outputs are found.
+
+type BlockInfo struct {
+	Block         *core.Block
+	Height        int
+	Confirmations int    // -1 for orphans, as in Core
+	MedianTime    int64  // of the block and the 10 before it
+	ChainWork     string // decimal, "" if not known
+	Next          *core.Uint256
+}
+
+type TxInfo struct {
+	Tx            *core.Tx
+	BlockHash     core.Uint256
+	BlockTime     int64
+	Confirmations int // 0 if only in orphans
+}
+
+type TxOutInfo struct {
+	BestBlock     core.Uint256
+	Confirmations int
+	Value         int64
+	ScriptPubKey  []byte
+	Coinbase      bool
+}
+
+// SelectBlockInfo rebuilds the block of the hash, sql.ErrNoRows if it
+// is not in the database.
+func (e *Explorer) SelectBlockInfo(hash core.Uint256) (*BlockInfo, error) {
+	var info *BlockInfo
+	err := e.read(func(db *sqlx.DB) error {
+		var (
+			orphan    bool
+			tip       int
+			chainWork sql.NullString
+			next      []byte
+		)
+		i := &BlockInfo{}
+		if err := db.QueryRow(`
+SELECT b.height, b.orphan, b.chainwork::TEXT
+      ,(SELECT MAX(height) FROM blocks WHERE NOT orphan)
+      ,(SELECT PERCENTILE_DISC(0.5) WITHIN GROUP (ORDER BY uint32(m.time))
+          FROM blocks m
+         WHERE m.height BETWEEN b.height - 10 AND b.height
+           AND (m.id = b.id OR NOT m.orphan))
+      ,(SELECT n.hash FROM blocks n WHERE n.prev_block_id = b.id AND NOT n.orphan LIMIT 1)
+  FROM blocks b
+ WHERE b.hash = $1`, hash[:]).Scan(&i.Height, &orphan, &chainWork, &tip, &i.MedianTime, &next); err != nil {
+			return err
+		}
+		b, err := readBlockWhere(db.DB, "hash = $1", hash[:])
+		if err != nil {
+			return err
+		}
+		i.Block, i.ChainWork = b, chainWork.String
+		i.Confirmations = tip - i.Height + 1
+		if orphan {
+			i.Confirmations = -1
+		}
+		if next != nil {
+			h := core.Uint256FromBytes(next)
+			i.Next = &h
+		}
+		info = i
+		return nil
+	})
+	return info, err
+}
+
+// SelectMainChainHash returns the hash of the main chain block at
+// height, that of the tip if height is negative.
+func (e *Explorer) SelectMainChainHash(height int) (core.Uint256, error) {
+	var hash core.Uint256
+	err := e.readGet(&hash, `
+SELECT hash FROM blocks
+ WHERE NOT orphan
+   AND height = CASE WHEN $1 < 0 THEN (SELECT MAX(height) FROM blocks WHERE NOT orphan) ELSE $1 END`, height)
+	return hash, err
+}
+
+// SelectTxInfo rebuilds the tx of the txid, with its block (preferably
+// in the main chain), sql.ErrNoRows if it is not in the database.
+func (e *Explorer) SelectTxInfo(txid core.Uint256) (*TxInfo, error) {
+	if known, err := e.txids.knows(e.db.DB, txid); err != nil {
+		return nil, err
+	} else if !known {
+		return nil, sql.ErrNoRows
+	}
+
+	var info *TxInfo
+	err := e.read(func(db *sqlx.DB) error {
+		var (
+			id                int64
+			version, lockTime int32
+			orphan            bool
+			height, tip       int
+		)
+		i := &TxInfo{}
+		if err := db.QueryRow(`
+SELECT t.id, t.version, t.locktime, b.hash, uint32(b.time), b.height, b.orphan
+      ,(SELECT MAX(height) FROM blocks WHERE NOT orphan)
+  FROM txs t
+  JOIN LATERAL (
+    SELECT b.hash, b.time, b.height, b.orphan
+      FROM block_txs bt
+      JOIN blocks b ON b.id = bt.block_id
+     WHERE bt.tx_id = t.id
+     ORDER BY b.orphan -- prefer the main chain
+     LIMIT 1
+  ) b ON true
+ WHERE t.txid = $1`, txid[:]).Scan(&id, &version, &lockTime, &i.BlockHash, &i.BlockTime, &height, &orphan, &tip); err != nil {
+			return err
+		}
+		i.Tx = &core.Tx{Version: uint32(version), LockTime: uint32(lockTime)}
+		if err := readTxs(db.DB, []int64{id}, map[int64]*core.Tx{id: i.Tx}); err != nil {
+			return err
+		}
+		if i.Tx.Hash() != txid {
+			return fmt.Errorf("Tx %v cannot be rebuilt (pruned scripts or unresolved prevouts?)", txid)
+		}
+		if !orphan {
+			i.Confirmations = tip - height + 1
+		}
+		info = i
+		return nil
+	})
+	return info, err
+}
+
+// SelectUnspentTxOut returns the output if it is in the UTXO set of
+// the main chain, sql.ErrNoRows if it is spent or not found.
+func (e *Explorer) SelectUnspentTxOut(txid core.Uint256, n int) (*TxOutInfo, error) {
+	var info *TxOutInfo
+	err := e.read(func(db *sqlx.DB) error {
+		var height, tip int
+		i := &TxOutInfo{}
+		if err := db.QueryRow(`
+WITH tip AS (
+  SELECT hash, height FROM blocks WHERE NOT orphan ORDER BY height DESC LIMIT 1
+)
+SELECT o.value, o.scriptpubkey, bt.n = 0, b.height, tip.hash, tip.height
+  FROM txs t
+  JOIN txouts_v o ON o.tx_id = t.id
+  JOIN block_txs bt ON bt.tx_id = t.id
+  JOIN blocks b ON b.id = bt.block_id AND NOT b.orphan
+ CROSS JOIN tip
+ WHERE t.txid = $1
+   AND o.n = $2
+   AND NOT o.spent`, txid[:], n).Scan(&i.Value, &i.ScriptPubKey, &i.Coinbase, &height, &i.BestBlock, &tip); err != nil {
+			return err
+		}
+		i.Confirmations = tip - height + 1
+		info = i
+		return nil
+	})
+	return info, err
+}
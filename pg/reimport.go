@@ -0,0 +1,477 @@
+package pg
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"log"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/blkchain/blkchain/core"
+	"github.com/lib/pq"
+)
+
+// Re-importing a range of heights, e.g. after finding corrupted rows,
+// without starting over. Each block of the range is rewritten in its own
+// transaction: the rows of its transactions are deleted and written
+// anew from the source, keeping their ids, so that the inputs of later
+// blocks spending them remain valid. Transactions which were linked to
+// the block but are not in it (and in no other block) are deleted, and
+// the spent flags of the rewritten outputs are set from the inputs in
+// the database. The chainwork of the block is computed again, and
+// should it change, that of its descendants with it.
+//
+// The rows of the transactions in the tables of the parsers (see
+// txtables.go) are deleted along with them and the parsers are run
+// again on the block, those whose tables exist, flushing right after
+// the block is committed. Only the parsers which need nothing but the
+// transaction can be, the protocols parser keeps state from block to
+// block and hooks are not run, re-importing is refused when any other
+// table with a tx_id exists. Likewise the column plugins given in the
+// WriterConfig are given the rows re-imported, and re-importing is
+// refused when txs or txouts have columns of other plugins, which
+// would be left NULL.
+//
+// The blocks must already be in the database (by hash), orphans are
+// left as they are. Deduplicated scripts and sharding are not
+// supported. Address events from the first height on are dropped, to
+// be recomputed by the next import.
+
+// The parsers ReimportRange runs again, by the table they write.
+var reimportParsers = map[string]string{
+	"op_returns":       "opreturn",
+	"multisig":         "multisig",
+	"tx_labels":        "coinjoin",
+	"revealed_scripts": "revealed",
+}
+
+type reimporter struct {
+	db               *sql.DB
+	lastTxId         int64
+	haveInscriptions bool
+	havePayloads     bool
+	parsers          []EmbeddedParser
+	parserTables     []string
+	columns          *extraColumns // nil unless column plugins
+}
+
+// ReimportRange rewrites the blocks from height from to to (inclusive)
+// read from bhs, which must be positioned before from. The database is
+// that of cfg, opened as the import does (see credentials.go), with its
+// chain (see chains.go) and column plugins, the rest of cfg is ignored.
+func ReimportRange(cfg WriterConfig, bhs core.BlockHeaderIndex, from, to int) (int, error) {
+	db, err := cfg.openDB(cfg.ConnectString)
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	dedup, err := haveScriptDict(db)
+	if err != nil {
+		return 0, err
+	}
+	var sharded, haveEvents bool
+	r := &reimporter{db: db}
+	if err := db.QueryRow(`
+SELECT to_regclass(current_schema() || '.shard_heights') IS NOT NULL,
+       to_regclass(current_schema() || '.inscriptions') IS NOT NULL,
+       to_regclass(current_schema() || '.address_events') IS NOT NULL`).Scan(&sharded, &r.haveInscriptions, &haveEvents); err != nil {
+		return 0, err
+	}
+	if dedup || sharded {
+		return 0, fmt.Errorf("Re-importing is not supported with deduplicated scripts or sharding.")
+	}
+	if err := r.startParsers(); err != nil {
+		return 0, err
+	}
+	if r.columns, err = newExtraColumns(cfg.Columns); err != nil {
+		return 0, err
+	}
+	if err := r.checkColumns(); err != nil {
+		return 0, err
+	}
+
+	if r.lastTxId, err = getLastTxId(db); err != nil {
+		return 0, err
+	}
+
+	// The txs re-imported keep their ids or get new ones, either way
+	// they are from here on.
+	haveValues, err := haveInputValues(db)
+	if err != nil {
+		return 0, err
+	}
+	if r.havePayloads, err = haveScriptPayloads(db); err != nil {
+		return 0, err
+	}
+	var fromTxId int64
+	if err := db.QueryRow(`
+SELECT COALESCE(MIN(bt.tx_id), 0)
+  FROM block_txs bt
+  JOIN blocks b ON b.id = bt.block_id
+ WHERE b.height >= $1`, from).Scan(&fromTxId); err != nil {
+		return 0, err
+	}
+
+	cnt, start := 0, time.Now()
+	for bhs.Next() {
+		height := bhs.CurrentHeight()
+		if height < from {
+			continue
+		}
+		if height > to {
+			break
+		}
+		b, err := bhs.ReadBlock()
+		if err != nil {
+			return cnt, err
+		}
+		if err := r.block(height, b); err != nil {
+			return cnt, fmt.Errorf("Height %d: %v", height, err)
+		}
+		for _, p := range r.parsers {
+			if err := p.Flush(db); err != nil {
+				return cnt, fmt.Errorf("Height %d: flushing parser %T: %v", height, p, err)
+			}
+		}
+		cnt++
+		if time.Now().Sub(start) > 5*time.Second {
+			log.Printf("Re-imported up to height %d.", height)
+			start = time.Now()
+		}
+	}
+
+	if haveValues && cnt > 0 {
+		if err := fillInputValues(db, fromTxId, false); err != nil {
+			return cnt, err
+		}
+	}
+
+	if haveEvents && cnt > 0 {
+		log.Printf("Dropping address events from height %d on, the next import recomputes them.", from)
+		if _, err := db.Exec(`
+DELETE FROM address_events WHERE height >= $1;
+UPDATE address_events_height SET height = $1 - 1 WHERE height >= $1`, from); err != nil {
+			return cnt, err
+		}
+	}
+	return cnt, nil
+}
+
+// startParsers starts the parsers of the tables with a tx_id which
+// exist, refusing the tables of any other (see above).
+func (r *reimporter) startParsers() error {
+	tables, err := existingTxTables(r.db, true)
+	if err != nil {
+		return err
+	}
+	var names []string
+	for _, t := range tables {
+		if t == "inscriptions" { // written along with the txs
+			continue
+		}
+		name, ok := reimportParsers[t]
+		if !ok {
+			return fmt.Errorf("Re-importing is not supported with the %s table, its parser or hook cannot be run again on a single block.", t)
+		}
+		names = append(names, name)
+		r.parserTables = append(r.parserTables, t)
+	}
+	if r.parsers, err = newParsers(names); err != nil {
+		return err
+	}
+	for _, p := range r.parsers {
+		if err := p.Start(r.db); err != nil {
+			return fmt.Errorf("Starting parser %T: %v", p, err)
+		}
+	}
+	return nil
+}
+
+// checkColumns adds the columns of the plugins if need be and makes
+// sure txs and txouts have no others than theirs and the built in ones.
+func (r *reimporter) checkColumns() error {
+	if err := r.columns.create(r.db); err != nil {
+		return err
+	}
+	for table, builtin := range builtinColumns {
+		known := make(map[string]bool)
+		for _, c := range append(append([]string(nil), builtin...), r.columns.names(table)...) {
+			known[c] = true
+		}
+		rows, err := r.db.Query(`
+SELECT column_name
+  FROM information_schema.columns
+ WHERE table_schema = current_schema() AND table_name = $1`, table)
+		if err != nil {
+			return err
+		}
+		var unknown []string
+		for rows.Next() {
+			var name string
+			if err := rows.Scan(&name); err != nil {
+				rows.Close()
+				return err
+			}
+			if !known[name] {
+				unknown = append(unknown, table+"."+name)
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		if len(unknown) > 0 {
+			return fmt.Errorf("Column(s) %s are not of the column plugins given, they would be left NULL.", strings.Join(unknown, ", "))
+		}
+	}
+	return nil
+}
+
+// chainworkOf returns the chainwork of the block with hash, nil if
+// unknown.
+func chainworkOf(txn *sql.Tx, hash core.Uint256) (*big.Int, error) {
+	var work sql.NullString
+	err := txn.QueryRow("SELECT chainwork FROM blocks WHERE hash = $1", hash[:]).Scan(&work)
+	if err == sql.ErrNoRows || err == nil && !work.Valid {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	w, ok := new(big.Int).SetString(work.String, 10)
+	if !ok {
+		return nil, fmt.Errorf("Bad chainwork %q of block %v.", work.String, hash)
+	}
+	return w, nil
+}
+
+func (r *reimporter) block(height int, b *core.Block) error {
+	hash := b.Hash()
+
+	txn, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer txn.Rollback() // no-op after Commit
+
+	var blockId int
+	if err := txn.QueryRow("SELECT id FROM blocks WHERE hash = $1", hash[:]).Scan(&blockId); err != nil {
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("Block %v is not in the database, use import.", hash)
+		}
+		return err
+	}
+
+	var oldIds []int64
+	if err := selectInt64s(txn, &oldIds, "SELECT tx_id FROM block_txs WHERE block_id = $1", blockId); err != nil {
+		return err
+	}
+
+	// Keep the id of every tx already known by txid.
+	ids := make([]int64, len(b.Txs))
+	byHash := make(map[core.Uint256]int64, len(b.Txs))
+	for n, tx := range b.Txs {
+		h := tx.Hash()
+		var id int64
+		err := txn.QueryRow("SELECT id FROM txs WHERE txid = $1", h[:]).Scan(&id)
+		if err == sql.ErrNoRows {
+			r.lastTxId++
+			id = r.lastTxId
+		} else if err != nil {
+			return err
+		}
+		ids[n] = id
+		byHash[h] = id
+	}
+	all := append(append([]int64{}, ids...), oldIds...)
+
+	// The bits may be what was corrupted, and the chainwork with them.
+	oldWork, err := chainworkOf(txn, hash)
+	if err != nil {
+		return err
+	}
+	prevWork, err := chainworkOf(txn, b.PrevHash)
+	if err != nil {
+		return err
+	}
+	work := chainwork(b.BlockHeader, prevWork)
+
+	if _, err := txn.Exec(`
+UPDATE blocks
+   SET version = $2, prevhash = $3, merkleroot = $4, time = $5, bits = $6, nonce = $7,
+       size = $8, base_size = $9, weight = $10, virt_size = $11,
+       prev_block_id = (SELECT id FROM blocks WHERE hash = $3), chainwork = $12
+ WHERE id = $1`, blockId, int32(b.Version), b.PrevHash[:], b.HashMerkleRoot[:], int32(b.Time),
+		int32(b.Bits), int32(b.Nonce), b.Size(), b.BaseSize(), b.Weight(), b.VirtualSize(), numeric(work)); err != nil {
+		return err
+	}
+	if oldWork != nil && work != nil && oldWork.Cmp(work) != 0 {
+		if _, err := txn.Exec(`
+WITH RECURSIVE d(id) AS (
+  SELECT id FROM blocks WHERE prev_block_id = $1
+  UNION ALL
+  SELECT b.id FROM d JOIN blocks b ON b.prev_block_id = d.id
+)
+UPDATE blocks SET chainwork = chainwork + $2::NUMERIC WHERE id IN (SELECT id FROM d)`,
+			blockId, new(big.Int).Sub(work, oldWork).String()); err != nil {
+			return err
+		}
+	}
+
+	// Children first, the txins trigger unspends the outputs the
+	// deleted inputs spent.
+	type del struct {
+		sql string
+		arg interface{}
+	}
+	deletes := []del{
+		{"DELETE FROM block_txs WHERE block_id = $1", blockId},
+		{"DELETE FROM txins WHERE tx_id = ANY($1)", pq.Array(all)},
+		{"DELETE FROM txouts WHERE tx_id = ANY($1)", pq.Array(all)},
+	}
+	if r.haveInscriptions {
+		deletes = append(deletes, del{"DELETE FROM inscriptions WHERE tx_id = ANY($1)", pq.Array(all)})
+	}
+	for _, t := range r.parserTables {
+		deletes = append(deletes, del{fmt.Sprintf("DELETE FROM %s WHERE tx_id = ANY($1)", t), pq.Array(all)})
+	}
+	// txs no longer in any block
+	deletes = append(deletes, del{
+		"DELETE FROM txs t WHERE t.id = ANY($1) AND NOT EXISTS (SELECT 1 FROM block_txs bt WHERE bt.tx_id = t.id)",
+		pq.Array(oldIds),
+	})
+	for _, d := range deletes {
+		if _, err := txn.Exec(d.sql, d.arg); err != nil {
+			return err
+		}
+	}
+
+	txCols := append([]string{"id", "txid", "version", "locktime", "size", "base_size", "weight", "virt_size", "n_inputs", "n_outputs"},
+		r.columns.names("txs")...)
+	names := make([]string, len(txCols))
+	params := make([]string, len(txCols))
+	sets := make([]string, 0, len(txCols)-1)
+	for i, c := range txCols {
+		names[i] = pq.QuoteIdentifier(c)
+		params[i] = fmt.Sprintf("$%d", i+1)
+		if i > 0 {
+			sets = append(sets, fmt.Sprintf("%[1]s = EXCLUDED.%[1]s", names[i]))
+		}
+	}
+	upsert, err := txn.Prepare(fmt.Sprintf(`
+INSERT INTO txs (%s)
+VALUES (%s)
+    ON CONFLICT (id) DO UPDATE
+   SET %s`, strings.Join(names, ", "), strings.Join(params, ", "), strings.Join(sets, ", ")))
+	if err != nil {
+		return err
+	}
+	for n, tx := range b.Txs {
+		h := tx.Hash()
+		vals := append([]interface{}{ids[n], h[:], int32(tx.Version), int32(tx.LockTime), tx.Size(), tx.BaseSize(),
+			tx.Weight(), tx.VirtualSize(), len(tx.TxIns), len(tx.TxOuts)}, r.columns.txValues(height, n, ids[n], tx)...)
+		if _, err := upsert.Exec(vals...); err != nil {
+			upsert.Close()
+			return err
+		}
+	}
+	upsert.Close()
+
+	var btxs, outs, ins, inscr [][]interface{}
+	for n, tx := range b.Txs {
+		id := ids[n]
+		btxs = append(btxs, []interface{}{blockId, n, id})
+		for i, out := range tx.TxOuts {
+			row := []interface{}{id, i, out.Value, out.ScriptPubKey, false}
+			if r.havePayloads {
+				row = append(row, scriptPayload(out.ScriptPubKey))
+			}
+			row = append(row, r.columns.txOutValues(height, id, tx, i)...)
+			outs = append(outs, row)
+		}
+		for i, in := range tx.TxIns {
+			var prevOutTxId interface{}
+			if in.PrevOut.N != 0xffffffff { // coinbase
+				if pid, ok := byHash[in.PrevOut.Hash]; ok {
+					prevOutTxId = pid
+				} else {
+					var pid sql.NullInt64
+					if err := txn.QueryRow("SELECT id FROM txs WHERE txid = $1", in.PrevOut.Hash[:]).Scan(&pid); err != nil && err != sql.ErrNoRows {
+						return err
+					}
+					if pid.Valid {
+						prevOutTxId = pid.Int64
+					} else {
+						log.Printf("Prevout %v of tx %v not found.", in.PrevOut.Hash, tx.Hash())
+					}
+				}
+			}
+			var wb interface{}
+			if in.Witness != nil {
+				var buf bytes.Buffer
+				core.BinWrite(&in.Witness, &buf)
+				wb = buf.Bytes()
+			}
+			ins = append(ins, []interface{}{id, i, prevOutTxId, int32(in.PrevOut.N), in.ScriptSig, int32(in.Sequence), wb})
+		}
+		if r.haveInscriptions {
+			for _, in := range tx.Inscriptions() {
+				inscr = append(inscr, []interface{}{id, in.Input, in.Index,
+					inscriptionText(in.ContentType), inscriptionText(in.ContentEncoding), in.Body})
+			}
+		}
+		for _, p := range r.parsers {
+			p.ParseTx(height, n, id, tx)
+		}
+	}
+
+	// Outputs before inputs, the txins trigger needs them.
+	if err := copyRows(txn, "block_txs", []string{"block_id", "n", "tx_id"}, btxs); err != nil {
+		return err
+	}
+	outCols := []string{"tx_id", "n", "value", "scriptpubkey", "spent"}
+	if r.havePayloads {
+		outCols = append(outCols, "script_payload")
+	}
+	outCols = append(outCols, r.columns.names("txouts")...)
+	if err := copyRows(txn, "txouts", outCols, outs); err != nil {
+		return err
+	}
+	if err := copyRows(txn, "txins", []string{"tx_id", "n", "prevout_tx_id", "prevout_n", "scriptsig", "sequence", "witness"}, ins); err != nil {
+		return err
+	}
+	if err := copyRows(txn, "inscriptions", []string{"tx_id", "n", "idx", "content_type", "content_encoding", "body"}, inscr); err != nil {
+		return err
+	}
+
+	// The outputs may be spent by inputs of later blocks.
+	if _, err := txn.Exec(`
+UPDATE txouts o
+   SET spent = EXISTS (SELECT 1 FROM txins i WHERE i.prevout_tx_id = o.tx_id AND i.prevout_n = o.n)
+      ,spent_same_block = EXISTS (SELECT 1 FROM txins i
+                                   WHERE i.prevout_tx_id = o.tx_id AND i.prevout_n = o.n AND i.tx_id = ANY($1))
+ WHERE o.tx_id = ANY($1)`, pq.Array(ids)); err != nil {
+		return err
+	}
+
+	return txn.Commit()
+}
+
+func selectInt64s(txn *sql.Tx, dest *[]int64, query string, args ...interface{}) error {
+	rows, err := txn.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var v int64
+		if err := rows.Scan(&v); err != nil {
+			return err
+		}
+		*dest = append(*dest, v)
+	}
+	return rows.Err()
+}
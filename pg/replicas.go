@@ -0,0 +1,170 @@
+package pg
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Read replicas. The Explorer can be given the connect strings of
+// streaming replicas of the database the import writes to, reads are
+// then spread across the replicas round robin, and only the rare
+// writes (e.g. AddAddressLabel) go to the primary.
+//
+// A background health check pings every replica (and checks its
+// replication lag if a maximum is given) and takes it out of rotation
+// while it fails. A query failing with a connection error marks the
+// replica unhealthy at once and is retried on the next one, falling
+// back to the primary if no replica is healthy.
+
+const DefaultHealthCheckInterval = 10 * time.Second
+
+type replica struct {
+	db      *sqlx.DB
+	name    string // for logging
+	healthy int32  // atomic
+}
+
+func (r *replica) isHealthy() bool {
+	return atomic.LoadInt32(&r.healthy) == 1
+}
+
+func (r *replica) setHealthy(healthy bool, reason error) {
+	var v int32
+	if healthy {
+		v = 1
+	}
+	if old := atomic.SwapInt32(&r.healthy, v); old != v {
+		if healthy {
+			log.Printf("Replica %s is back in rotation.", r.name)
+		} else {
+			log.Printf("Replica %s taken out of rotation: %v", r.name, reason)
+		}
+	}
+}
+
+type replicaSet struct {
+	replicas []*replica
+	next     uint32 // atomic
+	maxLag   time.Duration
+	stop     chan bool
+	wg       sync.WaitGroup
+}
+
+func newReplicaSet(connstrs []string, maxLag, interval time.Duration) (*replicaSet, error) {
+	rs := &replicaSet{maxLag: maxLag, stop: make(chan bool)}
+	for i, connstr := range connstrs {
+		// Open does not connect, so a replica which is down at start
+		// does not prevent the Explorer from starting.
+		conn, err := sqlx.Open("postgres", connstr)
+		if err != nil {
+			rs.close()
+			return nil, err
+		}
+		r := &replica{db: conn, name: fmt.Sprintf("#%d", i+1)}
+		rs.replicas = append(rs.replicas, r)
+		rs.check(r)
+	}
+	if interval <= 0 {
+		interval = DefaultHealthCheckInterval
+	}
+	rs.wg.Add(1)
+	go rs.healthCheck(interval)
+	return rs, nil
+}
+
+func (rs *replicaSet) healthCheck(interval time.Duration) {
+	defer rs.wg.Done()
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-rs.stop:
+			return
+		case <-t.C:
+			for _, r := range rs.replicas {
+				rs.check(r)
+			}
+		}
+	}
+}
+
+func (rs *replicaSet) check(r *replica) {
+	if err := r.db.Ping(); err != nil {
+		r.setHealthy(false, err)
+		return
+	}
+	if rs.maxLag > 0 {
+		// NULL when not a replica (or nothing replayed yet)
+		var lag *float64
+		if err := r.db.Get(&lag, "SELECT EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp())"); err != nil {
+			r.setHealthy(false, err)
+			return
+		}
+		if lag != nil && time.Duration(*lag*float64(time.Second)) > rs.maxLag {
+			r.setHealthy(false, errors.New("replication lag too high"))
+			return
+		}
+	}
+	r.setHealthy(true, nil)
+}
+
+// pick returns the next healthy replica, or nil.
+func (rs *replicaSet) pick() *replica {
+	n := len(rs.replicas)
+	start := int(atomic.AddUint32(&rs.next, 1))
+	for i := 0; i < n; i++ {
+		if r := rs.replicas[(start+i)%n]; r.isHealthy() {
+			return r
+		}
+	}
+	return nil
+}
+
+func (rs *replicaSet) close() {
+	close(rs.stop)
+	rs.wg.Wait()
+	for _, r := range rs.replicas {
+		r.db.Close()
+	}
+}
+
+// isConnError tells whether the query failed because of the connection
+// rather than the query itself, in which case another server may do.
+func isConnError(err error) bool {
+	if errors.Is(err, driver.ErrBadConn) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// read runs f against a healthy replica, failing over to the next one
+// on connection errors, and to the primary when there are none left.
+func (e *Explorer) read(f func(db *sqlx.DB) error) error {
+	if e.replicas != nil {
+		for r := e.replicas.pick(); r != nil; r = e.replicas.pick() {
+			err := f(r.db)
+			if err == nil || !isConnError(err) {
+				return err
+			}
+			r.setHealthy(false, err)
+		}
+	}
+	return f(e.db)
+}
+
+func (e *Explorer) readGet(dest interface{}, query string, args ...interface{}) error {
+	return e.read(func(db *sqlx.DB) error { return db.Get(dest, query, args...) })
+}
+
+func (e *Explorer) readSelect(dest interface{}, query string, args ...interface{}) error {
+	return e.read(func(db *sqlx.DB) error { return db.Select(dest, query, args...) })
+}
@@ -0,0 +1,261 @@
+package pg
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Retention, for when only a sliding window of recent history is
+// needed in Postgres. The transactions of the blocks more than the
+// retention window (in blocks) below the tip are deleted, after the
+// blocks have been archived, if an archive is given, as the CSV files
+// of the DuckDB export (see duckdb.go) in <archive>/<from>-<to>, which
+// can be a local directory or an s3:// or gs:// URL (see objstore.go).
+//
+// What is deleted keeps the rest consistent: the inputs of the old
+// transactions and the outputs they spent, then the old transactions
+// which have no outputs left, with their block_txs and their rows in
+// the tables of the parsers and hooks (see txtables.go). The outputs
+// which are unspent, or spent by a transaction still in the window,
+// are kept along with their transaction (which then has no inputs),
+// so the UTXO set, the balances and the inputs of the window are all
+// there. The blocks themselves are kept, as are transactions only in
+// orphan blocks, the tables of state of the parsers (runes and
+// brc20_tokens) and the tables derived from the chain (statistics,
+// address events, snapshots, brc20_balances). The last height deleted
+// is kept in retention_height, so retention continues where it left
+// off.
+//
+// StorageForecast estimates how much the tables grow in a year, and
+// so how big they would be with a given window.
+
+const (
+	MinRetainBlocks = 1000
+	retentionBatch  = 1000 // blocks per transaction
+
+	BlocksPerYear = 144 * 365
+)
+
+// Retain deletes the transactions of the blocks more than keep blocks
+// below the tip, archiving them first to archive unless empty, see
+// above. It can be run while the import is following the chain.
+func Retain(connstr string, keep int, archive string) error {
+	db, err := sql.Open("postgres", connstr)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return retain(db, keep, archive, true)
+}
+
+// Retain applies retention if RetainBlocks is set in the WriterConfig.
+func (w *PGWriter) Retain() error {
+	if w.db == nil || w.cfg.RetainBlocks <= 0 {
+		return nil
+	}
+	return retain(w.db, w.cfg.RetainBlocks, w.cfg.RetainArchive, false)
+}
+
+func retain(db *sql.DB, keep int, archive string, verbose bool) error {
+	if keep < MinRetainBlocks {
+		return fmt.Errorf("The retention window must be at least %d blocks.", MinRetainBlocks)
+	}
+	var sharded bool
+	if err := db.QueryRow("SELECT to_regclass(current_schema() || '.shard_heights') IS NOT NULL").Scan(&sharded); err != nil {
+		return err
+	}
+	if sharded {
+		return fmt.Errorf("Retention is not supported with shards.")
+	}
+	if _, err := db.Exec(`
+  CREATE TABLE IF NOT EXISTS retention_height (
+   height         INT NOT NULL -- last height whose txs were deleted
+  );
+`); err != nil {
+		return err
+	}
+
+	var from, tip int
+	if err := db.QueryRow("SELECT COALESCE(MAX(height), -1) + 1 FROM retention_height").Scan(&from); err != nil {
+		return err
+	}
+	if err := db.QueryRow("SELECT COALESCE(MAX(height), -1) FROM blocks WHERE NOT orphan").Scan(&tip); err != nil {
+		return err
+	}
+
+	tables, err := existingTxTables(db, false)
+	if err != nil {
+		return err
+	}
+
+	for to := tip - keep; from <= to; from += retentionBatch {
+		last := from + retentionBatch - 1
+		if last > to {
+			last = to
+		}
+		start := time.Now()
+		if archive != "" {
+			if err := archiveHeights(db, archive, from, last); err != nil {
+				return fmt.Errorf("Archiving heights %d to %d: %v", from, last, err)
+			}
+		}
+		if err := retireHeights(db, from, last, tables); err != nil {
+			return fmt.Errorf("Deleting heights %d to %d: %v", from, last, err)
+		}
+		if verbose {
+			log.Printf("  Deleted up to height %d in %s.", last, time.Now().Sub(start).Round(time.Millisecond))
+		}
+	}
+	return nil
+}
+
+// archiveHeights exports the blocks from to to to <archive>/<from>-<to>.
+func archiveHeights(db *sql.DB, archive string, from, to int) error {
+	name := fmt.Sprintf("%d-%d", from, to)
+	if !IsObjectURL(archive) {
+		_, err := exportDuckDB(db, filepath.Join(archive, name), from, to)
+		return err
+	}
+	tmp, err := os.MkdirTemp("", "retention")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	if _, err := exportDuckDB(db, tmp, from, to); err != nil {
+		return err
+	}
+	_, err = UploadDir(tmp, JoinOutput(archive, name))
+	return err
+}
+
+func retireHeights(db *sql.DB, from, to int, tables []string) error {
+	txn, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer txn.Rollback() // no-op after Commit
+
+	type stmt struct {
+		sql  string
+		args []interface{}
+	}
+	heights := []interface{}{from, to}
+	stmts := []stmt{
+		{"CREATE TEMP TABLE _retire (tx_id BIGINT NOT NULL) ON COMMIT DROP", nil},
+		// The txs of the main chain blocks, except those which are
+		// also in a later (orphan) block.
+		{`
+INSERT INTO _retire
+SELECT DISTINCT bt.tx_id
+  FROM blocks b
+  JOIN block_txs bt ON bt.block_id = b.id
+ WHERE b.height BETWEEN $1 AND $2
+   AND NOT b.orphan
+   AND NOT EXISTS (SELECT 1
+                     FROM block_txs bt2
+                     JOIN blocks b2 ON b2.id = bt2.block_id
+                    WHERE bt2.tx_id = bt.tx_id
+                      AND b2.height > $2)`, heights},
+		// The outputs spent by their inputs, then the inputs.
+		{`
+DELETE FROM txouts o
+ USING _retire r, txins i
+ WHERE i.tx_id = r.tx_id
+   AND o.tx_id = i.prevout_tx_id
+   AND o.n = i.prevout_n`, nil},
+		{"DELETE FROM txins i USING _retire r WHERE i.tx_id = r.tx_id", nil},
+		// The txs with no outputs left.
+		{`
+CREATE TEMP TABLE _retired ON COMMIT DROP AS
+SELECT r.tx_id
+  FROM _retire r
+ WHERE NOT EXISTS (SELECT 1 FROM txouts o WHERE o.tx_id = r.tx_id)`, nil},
+	}
+	for _, t := range tables {
+		stmts = append(stmts, stmt{fmt.Sprintf("DELETE FROM %s x USING _retired r WHERE x.tx_id = r.tx_id", t), nil})
+	}
+	stmts = append(stmts,
+		stmt{"DELETE FROM block_txs bt USING _retired r WHERE bt.tx_id = r.tx_id", nil},
+		stmt{"DELETE FROM txs t USING _retired r WHERE t.id = r.tx_id", nil},
+		stmt{"DELETE FROM retention_height", nil},
+		stmt{"INSERT INTO retention_height (height) VALUES ($1)", []interface{}{to}},
+	)
+	for _, st := range stmts {
+		if _, err := txn.Exec(st.sql, st.args...); err != nil {
+			return err
+		}
+	}
+	return txn.Commit()
+}
+
+type TableForecast struct {
+	Table   string
+	Bytes   int64   // now, with indexes and TOAST
+	Rows    int64   // estimated
+	PerYear int64   // rows added in the last BlocksPerYear blocks
+	Growth  float64 // bytes per year
+}
+
+// StorageForecast returns the size of the chain tables and how much
+// they grew in the last year of blocks (fewer if there are fewer),
+// scaled to a year, taking the current bytes per row.
+func StorageForecast(connstr string) ([]*TableForecast, error) {
+	db, err := sql.Open("postgres", connstr)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	var tip, blocks int
+	if err := db.QueryRow("SELECT COALESCE(MAX(height), -1) FROM blocks WHERE NOT orphan").Scan(&tip); err != nil {
+		return nil, err
+	}
+	blocks = BlocksPerYear
+	if tip+1 < blocks {
+		blocks = tip + 1
+	}
+	if blocks <= 0 {
+		return nil, fmt.Errorf("There are no blocks.")
+	}
+
+	var nBlocks, nTxs, nIns, nOuts int64
+	if err := db.QueryRow(`
+SELECT COUNT(DISTINCT b.id), COUNT(t.id), COALESCE(SUM(t.n_inputs), 0), COALESCE(SUM(t.n_outputs), 0)
+  FROM blocks b
+  LEFT JOIN block_txs bt ON bt.block_id = b.id
+  LEFT JOIN txs t ON t.id = bt.tx_id
+ WHERE b.height > $1
+   AND NOT b.orphan`, tip-blocks).Scan(&nBlocks, &nTxs, &nIns, &nOuts); err != nil {
+		return nil, err
+	}
+	perYear := map[string]int64{
+		"blocks":    nBlocks,
+		"block_txs": nTxs,
+		"txs":       nTxs,
+		"txins":     nIns,
+		"txouts":    nOuts,
+	}
+
+	scale := float64(BlocksPerYear) / float64(blocks)
+	var result []*TableForecast
+	for _, t := range []string{"blocks", "block_txs", "txs", "txins", "txouts"} {
+		f := &TableForecast{Table: t, PerYear: int64(float64(perYear[t]) * scale)}
+		var reltuples float64
+		if err := db.QueryRow(`
+SELECT pg_total_relation_size(c.oid), c.reltuples
+  FROM pg_class c
+ WHERE c.oid = to_regclass(current_schema() || '.' || $1)`, t).Scan(&f.Bytes, &reltuples); err != nil {
+			return nil, err
+		}
+		f.Rows = int64(reltuples)
+		if f.Rows > 0 {
+			f.Growth = float64(f.Bytes) / float64(f.Rows) * float64(f.PerYear)
+		}
+		result = append(result, f)
+	}
+	return result, nil
+}
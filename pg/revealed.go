@@ -0,0 +1,110 @@
+package pg
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"fmt"
+
+	"github.com/blkchain/blkchain/core"
+)
+
+// The "revealed" parser captures the scripts behind P2SH and P2WSH
+// outputs when they are spent: the redeem script or witness script of
+// the input (see core.TxIn.RevealedScript), in the
+// revealed_scripts table by the hash the output commits to, the
+// HASH160 of the redeem script for P2SH (for P2SH-P2WSH that of the
+// 0020<SHA256> program, the witness script is what is kept) or the
+// SHA256 of the witness script for P2WSH. That is the script_payload
+// of the output (see scriptpayload.go), so that all the outputs to a
+// script, spent or not, earlier or later, can be classified once any
+// of them has been spent:
+//
+//   SELECT o.*, r.script FROM txouts o JOIN revealed_scripts r ON r.hash = o.script_payload;
+//
+// Only the first input revealing a script is recorded.
+
+type revealedParser struct {
+	rows [][]interface{}
+	seen map[string]bool // the hashes in rows
+}
+
+func (p *revealedParser) Start(db *sql.DB) error {
+	if db == nil {
+		return nil
+	}
+	_, err := db.Exec(`
+  CREATE TABLE IF NOT EXISTS revealed_scripts (
+   hash           BYTEA NOT NULL PRIMARY KEY -- committed to by the output
+  ,type           TEXT NOT NULL -- p2sh, p2wsh or p2sh-p2wsh
+  ,script         BYTEA NOT NULL
+  ,tx_id          BIGINT NOT NULL -- of the first input revealing it
+  ,n              SMALLINT NOT NULL
+  );
+`)
+	return err
+}
+
+// revealedScriptHash returns the hash the output spent commits to.
+func revealedScriptHash(script []byte, kind string) []byte {
+	switch kind {
+	case "p2sh":
+		return core.Hash160(script)
+	case "p2sh-p2wsh":
+		h := sha256.Sum256(script)
+		return core.Hash160(append([]byte{core.OP_0, 32}, h[:]...))
+	}
+	h := sha256.Sum256(script)
+	return h[:]
+}
+
+func (p *revealedParser) ParseTx(height, n int, txId int64, tx *core.Tx) {
+	if n == 0 { // coinbase
+		return
+	}
+	for i, in := range tx.TxIns {
+		script, kind := in.RevealedScript()
+		if script == nil {
+			continue
+		}
+		hash := revealedScriptHash(script, kind)
+		if p.seen[string(hash)] {
+			continue
+		}
+		if p.seen == nil {
+			p.seen = make(map[string]bool)
+		}
+		p.seen[string(hash)] = true
+		p.rows = append(p.rows, []interface{}{hash, kind, script, txId, i})
+	}
+}
+
+func (p *revealedParser) Flush(db *sql.DB) error {
+	if db == nil || len(p.rows) == 0 {
+		p.rows, p.seen = nil, nil
+		return nil
+	}
+	txn, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer txn.Rollback() // no-op after Commit
+
+	// Most scripts were revealed before, by an earlier batch.
+	if _, err := txn.Exec("CREATE TEMP TABLE _revealed_scripts (LIKE revealed_scripts) ON COMMIT DROP"); err != nil {
+		return err
+	}
+	if err := copyRows(txn, "_revealed_scripts", []string{"hash", "type", "script", "tx_id", "n"}, p.rows); err != nil {
+		return fmt.Errorf("revealed_scripts: %v", err)
+	}
+	if _, err := txn.Exec(`
+INSERT INTO revealed_scripts
+SELECT * FROM _revealed_scripts
+    ON CONFLICT (hash) DO NOTHING`); err != nil {
+		return fmt.Errorf("revealed_scripts: %v", err)
+	}
+	if err := txn.Commit(); err != nil {
+		return err
+	}
+	p.rows, p.seen = nil, nil
+	return nil
+}
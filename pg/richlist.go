@@ -0,0 +1,124 @@
+package pg
+
+import (
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+)
+
+// Rich lists: the top balances by address, or by entity (the clusters
+// of address_labels, see labels.go). At the tip the balances come from
+// the UTXO set (unspent txouts), at any other height from
+// address_events (see balances.go), which needs to have been
+// populated with -address-events.
+
+type RichListEntry struct {
+	Rank      int    `json:"rank"`
+	Address   string `json:"address,omitempty"`
+	Entity    string `json:"entity,omitempty"`
+	Addresses int    `json:"addresses,omitempty"` // of the entity with a balance
+	Balance   int64  `json:"balance"`
+}
+
+const (
+	RichListByAddress = "address"
+	RichListByEntity  = "entity"
+)
+
+// RichList returns the top n balances at height (negative for the
+// tip), by address or entity.
+func RichList(connstr string, height, n int, by string) ([]*RichListEntry, error) {
+	db, err := sql.Open("postgres", connstr)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	// The balance of every address, as (addr, balance)
+	balances := `
+    SELECT extract_address(scriptpubkey) AS addr, SUM(value) AS balance
+      FROM txouts_v
+     WHERE NOT spent
+     GROUP BY 1`
+	args := []interface{}{n}
+	if height >= 0 {
+		var last int
+		if err := db.QueryRow("SELECT COALESCE(MAX(height), -1) FROM address_events_height").Scan(&last); err != nil {
+			return nil, fmt.Errorf("Cannot read address_events (was it populated with -address-events?): %v", err)
+		}
+		if height > last {
+			return nil, fmt.Errorf("Height %d is beyond the last height in address_events (%d).", height, last)
+		}
+		balances = `
+    SELECT DISTINCT ON (addr) addr, balance
+      FROM address_events
+     WHERE height <= $2
+     ORDER BY addr, height DESC, tx_id DESC, spending, n DESC`
+		args = append(args, height)
+	}
+
+	var stmt string
+	switch by {
+	case RichListByAddress:
+		// Look up a script for each address to show it the usual way
+		stmt = fmt.Sprintf(`
+WITH b AS (%s)
+SELECT b.addr, s.scriptpubkey, NULL, b.balance, 0 FROM (
+  SELECT addr, balance FROM b
+   WHERE addr IS NOT NULL AND balance > 0
+   ORDER BY balance DESC
+   LIMIT $1
+) b
+LEFT JOIN LATERAL (
+  SELECT scriptpubkey
+    FROM txouts_v o
+   WHERE addr_prefix(o.scriptpubkey) = bytes2int8(b.addr)
+     AND extract_address(o.scriptpubkey) = b.addr
+   LIMIT 1
+) s ON true
+ORDER BY b.balance DESC`, balances)
+	case RichListByEntity:
+		stmt = fmt.Sprintf(`
+WITH b AS (%s),
+l AS (
+  SELECT DISTINCT ON (addr) addr, COALESCE(entity, label) AS entity
+    FROM address_labels
+   ORDER BY addr, entity NULLS LAST
+)
+SELECT NULL, NULL, l.entity, SUM(b.balance)::BIGINT, COUNT(1)
+  FROM b
+  JOIN l ON l.addr = b.addr
+ WHERE b.balance > 0
+ GROUP BY l.entity
+ ORDER BY 4 DESC
+ LIMIT $1`, balances)
+	default:
+		return nil, fmt.Errorf("Unknown rich list kind: %q (must be %q or %q)", by, RichListByAddress, RichListByEntity)
+	}
+
+	rows, err := db.Query(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []*RichListEntry
+	for rows.Next() {
+		var (
+			addr, script []byte
+			entity       sql.NullString
+			e            RichListEntry
+		)
+		if err := rows.Scan(&addr, &script, &entity, &e.Balance, &e.Addresses); err != nil {
+			return nil, err
+		}
+		e.Rank = len(result) + 1
+		if by == RichListByEntity {
+			e.Entity = entity.String
+		} else if e.Address = scriptAddress(script); e.Address == "" {
+			e.Address = hex.EncodeToString(addr)
+		}
+		result = append(result, &e)
+	}
+	return result, rows.Err()
+}
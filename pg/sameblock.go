@@ -0,0 +1,45 @@
+package pg
+
+import (
+	"database/sql"
+
+	"github.com/blkchain/blkchain/core"
+)
+
+// Outputs spent in the same block: txouts.spent_same_block is set on
+// the outputs which a later tx of the block that created them spends,
+// i.e. which were never part of the UTXO set between blocks, e.g.
+// chains of unconfirmed txs mined together or CPFP. The import knows
+// this from the inputs of the block before writing its outputs, so
+// these are written already spent and need no UTXO set lookup. The
+// txs spending an output of their own block:
+//
+//   SELECT DISTINCT i.tx_id
+//     FROM txins i
+//     JOIN txouts o ON o.tx_id = i.prevout_tx_id AND o.n = i.prevout_n
+//    WHERE o.spent_same_block;
+//
+// The upgrade computes it for existing databases, but not in the
+// shards, where the column is added with false for existing rows.
+
+// spentInBlock returns the outpoints spent by the inputs of the block,
+// the outputs of the block among them are those spent in it.
+func spentInBlock(b *core.Block) map[core.OutPoint]bool {
+	spent := make(map[core.OutPoint]bool)
+	for n, tx := range b.Txs {
+		if n == 0 { // coinbase
+			continue
+		}
+		for _, txin := range tx.TxIns {
+			spent[txin.PrevOut] = true
+		}
+	}
+	return spent
+}
+
+// Adds the column to the txouts of a shard created before it, see
+// openShards.
+func addSpentSameBlockColumn(db *sql.DB) error {
+	_, err := db.Exec("ALTER TABLE txouts ADD COLUMN IF NOT EXISTS spent_same_block BOOLEAN NOT NULL DEFAULT false")
+	return err
+}
@@ -0,0 +1,182 @@
+package pg
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/blkchain/blkchain/core"
+)
+
+// Script validation of a random sample of inputs, as a spot check of
+// what the import wrote: the transaction is rebuilt from its rows
+// (which must hash to its txid) and one of its inputs is run through
+// the interpreter against the output it spends, signatures included,
+// with the soft forks of the height of the block. A failure means the
+// rows are wrong (or the interpreter is).
+//
+// Transactions which cannot be rebuilt, e.g. because the outputs are
+// pruned or a prevout is not resolved, are skipped, and those of the
+// blocks up to the assume-valid block (see assumevalid.go) are only
+// rebuilt. Not supported with sharding.
+
+const scriptCheckAttempts = 10 // per sampled input, before giving up
+
+type ScriptCheckResult struct {
+	Checked  int // inputs which validated
+	Failed   []string
+	Skipped  int
+	Assumed  int // rebuilt, but not validated (assume-valid)
+	Duration time.Duration
+}
+
+// VerifyScripts checks sample inputs of transactions at or above the
+// given height (0 = everything), the scripts of those up to the
+// assume-valid block hash ("" = none) are not run.
+func VerifyScripts(connstr string, sinceHeight, sample int, assumeValid string) (*ScriptCheckResult, error) {
+	db, err := sql.Open("postgres", connstr)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	minTxId, _, err := verifyStart(db, sinceHeight)
+	if err != nil {
+		return nil, err
+	}
+	avHeight, err := assumeValidHeight(db, assumeValid)
+	if err != nil {
+		return nil, err
+	}
+	var maxTxId int64
+	if err := db.QueryRow("SELECT COALESCE(MAX(id), 0) FROM txs").Scan(&maxTxId); err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	r := &ScriptCheckResult{}
+	for i := 0; i < sample*scriptCheckAttempts && r.Checked+len(r.Failed)+r.Assumed < sample; i++ {
+		txId := minTxId + rand.Int63n(maxTxId-minTxId+1)
+		tx, prevOuts, height, err := loadTxForCheck(db, &txId)
+		if err != nil {
+			return r, err
+		}
+		if tx == nil {
+			r.Skipped++
+			continue
+		}
+		if height <= avHeight {
+			r.Assumed++
+			continue
+		}
+		n := rand.Intn(len(tx.TxIns))
+		checker := core.NewTxSigChecker(tx, n, prevOuts)
+		err = core.VerifyScript(tx, n, prevOuts[n], core.ScriptFlagsAt(height), checker)
+		if err != nil {
+			r.Failed = append(r.Failed, fmt.Sprintf("%v:%d (height %d): %v", tx.Hash(), n, height, err))
+		} else {
+			r.Checked++
+		}
+	}
+	r.Duration = time.Now().Sub(start)
+	return r, nil
+}
+
+// loadTxForCheck rebuilds the first non-coinbase tx with an id at or
+// above txId (which is updated), with the outputs spent by its inputs.
+// The tx is nil if it cannot be rebuilt.
+func loadTxForCheck(db *sql.DB, txId *int64) (*core.Tx, []*core.TxOut, int, error) {
+	var (
+		txid     []byte
+		version  int32
+		lockTime int32
+		height   int
+	)
+	err := db.QueryRow(`
+SELECT t.id, t.txid, t.version, t.locktime, b.height
+  FROM txs t
+  JOIN block_txs bt ON bt.tx_id = t.id
+  JOIN blocks b ON b.id = bt.block_id
+ WHERE t.id >= $1
+   AND bt.n > 0
+   AND NOT b.orphan
+ ORDER BY t.id
+ LIMIT 1`, *txId).Scan(txId, &txid, &version, &lockTime, &height)
+	if err == sql.ErrNoRows {
+		return nil, nil, 0, nil
+	}
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	tx := &core.Tx{Version: uint32(version), LockTime: uint32(lockTime)}
+
+	rows, err := db.Query(`
+SELECT p.txid, i.prevout_n, i.scriptsig, i.sequence, i.witness, o.value, o.scriptpubkey
+  FROM txins i
+  LEFT JOIN txs p ON p.id = i.prevout_tx_id
+  LEFT JOIN txouts_v o ON o.tx_id = i.prevout_tx_id AND o.n = i.prevout_n
+ WHERE i.tx_id = $1
+ ORDER BY i.n`, *txId)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	var prevOuts []*core.TxOut
+	for rows.Next() {
+		var (
+			prevHash, scriptSig, witness, scriptPubKey []byte
+			prevN, sequence                            int32
+			value                                      sql.NullInt64
+		)
+		if err := rows.Scan(&prevHash, &prevN, &scriptSig, &sequence, &witness, &value, &scriptPubKey); err != nil {
+			rows.Close()
+			return nil, nil, 0, err
+		}
+		in := &core.TxIn{
+			PrevOut:   core.OutPoint{Hash: core.Uint256FromBytes(prevHash), N: uint32(prevN)},
+			ScriptSig: scriptSig,
+			Sequence:  uint32(sequence),
+		}
+		if witness != nil {
+			if err := core.BinRead(&in.Witness, bytes.NewReader(witness)); err != nil {
+				rows.Close()
+				return nil, nil, 0, err
+			}
+			tx.SegWit = tx.SegWit || len(in.Witness) > 0
+		}
+		tx.TxIns = append(tx.TxIns, in)
+
+		if !value.Valid || scriptPubKey == nil {
+			rows.Close()
+			return nil, nil, 0, nil // pruned or unresolved
+		}
+		prevOuts = append(prevOuts, &core.TxOut{Value: value.Int64, ScriptPubKey: scriptPubKey})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, nil, 0, err
+	}
+
+	rows, err = db.Query("SELECT value, scriptpubkey FROM txouts_v WHERE tx_id = $1 ORDER BY n", *txId)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	for rows.Next() {
+		out := &core.TxOut{}
+		if err := rows.Scan(&out.Value, &out.ScriptPubKey); err != nil {
+			rows.Close()
+			return nil, nil, 0, err
+		}
+		tx.TxOuts = append(tx.TxOuts, out)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, nil, 0, err
+	}
+
+	if len(tx.TxIns) == 0 || tx.Hash() != core.Uint256FromBytes(txid) {
+		return nil, nil, 0, nil // pruned or incomplete
+	}
+	return tx, prevOuts, height, nil
+}
@@ -0,0 +1,133 @@
+package pg
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/blkchain/blkchain/core"
+)
+
+// Script payloads: with -script-payloads the txouts have a
+// script_payload column, what identifies the owner of the output
+// without parsing the script again in every query: the 20 byte hash of
+// P2PKH, P2SH and P2WPKH, the 32 byte program of P2WSH and P2TR (the
+// witness program of the other versions) and the public key of P2PK
+// (see core.ScriptPayload), NULL for the other types. The payload
+// does not say the type, script_type() does, and a P2PKH and a P2WPKH
+// output of the same key have the same one, which is usually what
+// aggregating by address wants.
+//
+// The payloads are written along with the txouts. Once the column
+// exists, the import keeps writing it whether or not the option is
+// given again; cmd/upgrade -script-payloads adds it to an existing
+// database and fills it in with the script_payload() function, which
+// is the same thing in SQL. Not supported with shards.
+
+func createScriptPayloadColumn(db *sql.DB) error {
+	_, err := db.Exec(`
+  -- The payload of a scriptPubKey, see core.ScriptPayload
+  CREATE OR REPLACE FUNCTION script_payload(_spk BYTEA) RETURNS BYTEA AS $$
+    SELECT CASE
+      WHEN LENGTH(_spk) = 25 AND SUBSTR(_spk, 1, 3) = E'\\x76a914' AND SUBSTR(_spk, 24, 2) = E'\\x88ac' THEN SUBSTR(_spk, 4, 20)
+      WHEN LENGTH(_spk) = 23 AND SUBSTR(_spk, 1, 2) = E'\\xa914' AND GET_BYTE(_spk, 22) = 135 THEN SUBSTR(_spk, 3, 20)
+      WHEN LENGTH(_spk) = 22 AND SUBSTR(_spk, 1, 2) = E'\\x0014' THEN SUBSTR(_spk, 3)
+      WHEN LENGTH(_spk) = 34 AND SUBSTR(_spk, 1, 2) = E'\\x0020' THEN SUBSTR(_spk, 3)
+      WHEN _spk = E'\\x51024e73' THEN NULL -- P2A
+      WHEN LENGTH(_spk) BETWEEN 4 AND 42 AND GET_BYTE(_spk, 0) BETWEEN 81 AND 96
+       AND GET_BYTE(_spk, 1) = LENGTH(_spk) - 2 THEN SUBSTR(_spk, 3)
+      WHEN (LENGTH(_spk) = 35 AND GET_BYTE(_spk, 0) = 33 OR LENGTH(_spk) = 67 AND GET_BYTE(_spk, 0) = 65)
+       AND GET_BYTE(_spk, LENGTH(_spk) - 1) = 172 THEN SUBSTR(_spk, 2, LENGTH(_spk) - 2)
+      ELSE NULL END
+  $$ LANGUAGE sql IMMUTABLE;
+
+  ALTER TABLE txouts ADD COLUMN IF NOT EXISTS script_payload BYTEA;
+`)
+	return err
+}
+
+func createScriptPayloadIndex(db *sql.DB, verbose bool) error {
+	start := time.Now()
+	if _, err := db.Exec("CREATE INDEX IF NOT EXISTS txouts_script_payload_idx ON txouts(script_payload)"); err != nil {
+		return err
+	}
+	if verbose {
+		log.Printf("  Created the script payload index in %s.", time.Now().Sub(start).Round(time.Millisecond))
+	}
+	return nil
+}
+
+func haveScriptPayloads(db *sql.DB) (bool, error) {
+	return columnExists(db, "txouts", "script_payload")
+}
+
+// scriptPayload is the value of the column, nil (NULL rather than an
+// empty BYTEA) if there is no payload.
+func scriptPayload(script []byte) interface{} {
+	if p := core.ScriptPayload(script); p != nil {
+		return p
+	}
+	return nil
+}
+
+// fillScriptPayloads sets the script_payload of the txouts from
+// fromTxId on.
+func fillScriptPayloads(db *sql.DB, fromTxId int64, verbose bool) error {
+	dedup, err := haveScriptDict(db)
+	if err != nil {
+		return err
+	}
+	query := `
+UPDATE txouts
+   SET script_payload = script_payload(scriptpubkey)
+ WHERE tx_id >= $1`
+	if dedup {
+		query = `
+UPDATE txouts o
+   SET script_payload = script_payload(s.script)
+  FROM scripts s
+ WHERE o.tx_id >= $1
+   AND s.id = o.script_id`
+	}
+	start := time.Now()
+	res, err := db.Exec(query, fromTxId)
+	if err != nil {
+		return fmt.Errorf("Filling in script_payload: %v", err)
+	}
+	if verbose {
+		n, _ := res.RowsAffected()
+		log.Printf("  Filled in the script_payload of %d txouts in %s.", n, time.Now().Sub(start).Round(time.Millisecond))
+	}
+	return nil
+}
+
+// AddScriptPayloads adds the script_payload column to an existing
+// database, fills it in and indexes it, which is a rewrite of the
+// whole txouts table.
+func AddScriptPayloads(connstr string) error {
+	db, err := sql.Open("postgres", connstr)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var sharded bool
+	if err := db.QueryRow("SELECT to_regclass(current_schema() || '.shard_heights') IS NOT NULL").Scan(&sharded); err != nil {
+		return err
+	}
+	if sharded {
+		return fmt.Errorf("Script payloads are not supported with shards.")
+	}
+	if err := createScriptPayloadColumn(db); err != nil {
+		return err
+	}
+	log.Printf("Filling in script_payload, this may take a long time...")
+	if err := fillScriptPayloads(db, 0, true); err != nil {
+		return err
+	}
+	if err := createScriptPayloadIndex(db, true); err != nil {
+		return err
+	}
+	return analyzeTables(db, "after filling in script_payload", []string{"txouts"}, false)
+}
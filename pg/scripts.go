@@ -0,0 +1,248 @@
+package pg
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"log"
+	"time"
+)
+
+// Many scriptpubkeys repeat (address reuse, OP_RETURN prefixes), with
+// WriterConfig.DedupScripts each unique script is stored once in the
+// scripts table and txouts refer to it by script_id, leaving
+// txouts.scriptpubkey NULL. The txouts_v view joins them back
+// together, the Explorer uses it, so should most queries.
+//
+// The mode is chosen at the first import, when the scripts table is
+// created, catching up continues in whatever mode the database is in.
+//
+// Scripts are matched by SHA256 using a bounded in-memory dictionary,
+// a script evicted from it gets a new id when seen again. During the
+// first import this is corrected at the end (see finishScripts), when
+// catching up the database is consulted on a dictionary miss.
+//
+// NB: The Explorer address queries use the txouts address prefix
+// index, which does not exist in this mode.
+
+const scriptDictSize = 4 * 1024 * 1024
+
+type scriptDict struct {
+	cur, prev map[[sha256.Size]byte]int64
+	sz        int
+	lastId    int64
+	db        *sql.DB // for lookups on miss, nil during the first import
+	hits      int
+	miss      int
+}
+
+func newScriptDict(sz int, lastId int64, db *sql.DB) *scriptDict {
+	return &scriptDict{
+		cur:    make(map[[sha256.Size]byte]int64, sz/2),
+		prev:   make(map[[sha256.Size]byte]int64),
+		sz:     sz,
+		lastId: lastId,
+		db:     db,
+	}
+}
+
+// Two generations, when the current one is full, the previous one is
+// discarded, an entry found in the previous one is moved forward.
+func (d *scriptDict) put(key [sha256.Size]byte, id int64) {
+	if len(d.cur) >= d.sz/2 {
+		d.prev = d.cur
+		d.cur = make(map[[sha256.Size]byte]int64, d.sz/2)
+	}
+	d.cur[key] = id
+}
+
+// Returns the id of the script and whether it is new and needs to be
+// written.
+func (d *scriptDict) id(script []byte) (int64, bool) {
+	key := sha256.Sum256(script)
+	if id, ok := d.cur[key]; ok {
+		d.hits++
+		return id, false
+	}
+	if id, ok := d.prev[key]; ok {
+		d.hits++
+		d.put(key, id)
+		return id, false
+	}
+	d.miss++
+	if d.db != nil {
+		var id int64
+		err := d.db.QueryRow("SELECT id FROM scripts WHERE public.digest(script, 'sha256') = $1 LIMIT 1", key[:]).Scan(&id)
+		if err == nil {
+			d.put(key, id)
+			return id, false
+		}
+		if err != sql.ErrNoRows {
+			log.Printf("scriptDict: lookup error: %v", err)
+		}
+	}
+	d.lastId++
+	d.put(key, d.lastId)
+	return d.lastId, true
+}
+
+func (d *scriptDict) reportStats() {
+	log.Printf("Script dictionary: hits: %d misses: %d size: %d", d.hits, d.miss, len(d.cur)+len(d.prev))
+}
+
+func pgScriptWriter(c chan *scriptRec, db *sql.DB, upsert bool, fail *writerFailure) {
+	defer writerWg.Done()
+	defer fail.catch("scripts writer", func() {
+		for sr := range c {
+			if sr != nil && sr.sync != nil {
+				sr.sync <- false
+			}
+		}
+	})
+
+	stage := pipeline.stage("scripts", c, stateRunning)
+	defer pipeline.remove(stage)
+
+	cols := []string{"id", "script"}
+
+	txn, stmt, err := begin(fail.ctx, db, upsert, "scripts", cols)
+	if err != nil {
+		log.Printf("ERROR (14): %v", err)
+	}
+
+	for sr := range c {
+
+		if sr == nil || sr.id == 0 { // commit signal
+			stage.committing()
+			if err = commit(stmt, txn, upsert, nil); err != nil {
+				log.Printf("Script commit error: %v", err)
+			}
+			txn, stmt, err = begin(fail.ctx, db, upsert, "scripts", cols)
+			if err != nil {
+				log.Printf("ERROR (15): %v", err)
+			}
+			stage.committed()
+			if sr != nil && sr.sync != nil {
+				sr.sync <- true
+			}
+			continue
+		}
+
+		stage.row()
+		if stmt != nil {
+			script := sr.script
+			if script == nil {
+				script = []byte{} // NOT NULL
+			}
+			_, err = stmt.Exec(sr.id, script)
+		}
+		if err != nil {
+			log.Printf("ERROR (15.5): %v\n", err)
+		}
+	}
+
+	log.Printf("Script writer channel closed, committing transaction.")
+	stage.committing()
+	if err = commit(stmt, txn, upsert, nil); err != nil {
+		log.Printf("Script commit error: %v", err)
+	}
+	log.Printf("Script writer done.")
+}
+
+func haveScriptDict(db *sql.DB) (bool, error) {
+	var exists bool
+	err := db.QueryRow("SELECT to_regclass(current_schema() || '.scripts') IS NOT NULL").Scan(&exists)
+	return exists, err
+}
+
+// Called on the first import only, right after createTables.
+func createScriptsTable(db *sql.DB) error {
+	_, err := db.Exec(`
+  CREATE TABLE scripts (
+   id           BIGINT NOT NULL
+  ,script       BYTEA NOT NULL
+  );
+  ALTER TABLE scripts SET (autovacuum_enabled=false);
+
+  ALTER TABLE txouts
+    ADD COLUMN script_id BIGINT,
+    ALTER COLUMN scriptpubkey DROP NOT NULL;
+`)
+	return err
+}
+
+func getLastScriptId(db *sql.DB) (int64, error) {
+	var id int64
+	err := db.QueryRow("SELECT COALESCE(MAX(id), 0) FROM scripts").Scan(&id)
+	return id, err
+}
+
+func createTxoutsView(db *sql.DB, dedup bool) error {
+	if !dedup {
+		_, err := db.Exec(`
+  CREATE OR REPLACE VIEW txouts_v AS
+    SELECT tx_id, n, value, scriptpubkey, spent, spent_same_block
+      FROM txouts;
+`)
+		return err
+	}
+	_, err := db.Exec(`
+  CREATE OR REPLACE VIEW txouts_v AS
+    SELECT o.tx_id, o.n, o.value, COALESCE(o.scriptpubkey, s.script) AS scriptpubkey, o.spent, o.spent_same_block
+      FROM txouts o
+      LEFT JOIN scripts s ON s.id = o.script_id;
+`)
+	return err
+}
+
+// At the end of the first import, merge the scripts which were
+// written more than once because they fell out of the dictionary,
+// then create the indexes.
+func finishScripts(db *sql.DB, verbose bool) error {
+	if verbose {
+		log.Printf("  Merging duplicate scripts...")
+	}
+	start := time.Now()
+	if _, err := db.Exec(`
+  CREATE TABLE _script_dupes AS
+    SELECT id, keep
+      FROM (SELECT id, MIN(id) OVER (PARTITION BY public.digest(script, 'sha256')) AS keep FROM scripts) s
+     WHERE id <> keep;
+
+  UPDATE txouts o
+     SET script_id = d.keep
+    FROM _script_dupes d
+   WHERE o.script_id = d.id;
+
+  DELETE FROM scripts s
+   USING _script_dupes d
+   WHERE s.id = d.id;
+
+  DROP TABLE _script_dupes;
+`); err != nil {
+		return err
+	}
+	if verbose {
+		log.Printf("  ...done in %s. Starting scripts indexes...", time.Now().Sub(start).Round(time.Millisecond))
+	}
+	start = time.Now()
+	if _, err := db.Exec(`
+       DO $$
+       BEGIN
+         IF NOT EXISTS (SELECT constraint_name FROM information_schema.constraint_column_usage
+                         WHERE table_schema = current_schema() AND table_name = 'scripts' AND constraint_name = 'scripts_pkey') THEN
+            ALTER TABLE scripts ADD CONSTRAINT scripts_pkey PRIMARY KEY(id);
+         END IF;
+       END
+       $$;
+       CREATE INDEX IF NOT EXISTS scripts_digest_idx ON scripts(public.digest(script, 'sha256'));
+       CREATE INDEX IF NOT EXISTS scripts_addr_prefix_idx ON scripts(addr_prefix(script));
+       CREATE INDEX IF NOT EXISTS txouts_script_id_idx ON txouts(script_id);
+       ALTER TABLE scripts RESET (autovacuum_enabled);
+`); err != nil {
+		return err
+	}
+	if verbose {
+		log.Printf("  ...done in %s.", time.Now().Sub(start).Round(time.Millisecond))
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package pg
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Script type statistics: the number and value of the outputs created
+// per script type (P2PKH, P2WPKH, P2TR etc., see script_type()) per
+// day, week, month or year of block time, in the script_type_stats
+// table, i.e. the adoption curves of the output types. The outputs of
+// the main chain only. These are computed by cmd/scripttypes from the
+// txouts, again from the period of a given height on when updating.
+// Pruned scripts (see prune.go) are counted as "pruned".
+
+// The script types, in the order of the CSV columns.
+var ScriptTypes = []string{"p2pk", "p2pkh", "p2sh", "multisig", "p2wpkh", "p2wsh", "p2tr", "p2a",
+	"witness_unknown", "op_return", "nonstandard", "pruned"}
+
+var scriptTypePeriods = map[string]bool{"day": true, "week": true, "month": true, "year": true}
+
+type ScriptTypeStat struct {
+	Start      time.Time
+	ScriptType string
+	Outputs    int64
+	Value      int64
+	Share      float64 // of the outputs of the period
+}
+
+func createScriptTypeStats(db *sql.DB) error {
+	_, err := db.Exec(`
+  -- The type of a scriptPubKey, as the "type" of Core's decodescript
+  CREATE OR REPLACE FUNCTION script_type(_spk BYTEA) RETURNS TEXT AS $$
+    SELECT CASE
+      WHEN _spk IS NULL THEN 'pruned'
+      WHEN LENGTH(_spk) = 25 AND SUBSTR(_spk, 1, 3) = E'\\x76a914' AND SUBSTR(_spk, 24, 2) = E'\\x88ac' THEN 'p2pkh'
+      WHEN LENGTH(_spk) = 23 AND SUBSTR(_spk, 1, 2) = E'\\xa914' AND GET_BYTE(_spk, 22) = 135 THEN 'p2sh'
+      WHEN LENGTH(_spk) = 22 AND SUBSTR(_spk, 1, 2) = E'\\x0014' THEN 'p2wpkh'
+      WHEN LENGTH(_spk) = 34 AND SUBSTR(_spk, 1, 2) = E'\\x0020' THEN 'p2wsh'
+      WHEN LENGTH(_spk) = 34 AND SUBSTR(_spk, 1, 2) = E'\\x5120' THEN 'p2tr'
+      WHEN _spk = E'\\x51024e73' THEN 'p2a'
+      WHEN LENGTH(_spk) BETWEEN 4 AND 42 AND GET_BYTE(_spk, 0) BETWEEN 81 AND 96
+       AND GET_BYTE(_spk, 1) = LENGTH(_spk) - 2 THEN 'witness_unknown'
+      WHEN (LENGTH(_spk) = 35 AND GET_BYTE(_spk, 0) = 33 OR LENGTH(_spk) = 67 AND GET_BYTE(_spk, 0) = 65)
+       AND GET_BYTE(_spk, LENGTH(_spk) - 1) = 172 THEN 'p2pk'
+      WHEN LENGTH(_spk) > 0 AND GET_BYTE(_spk, 0) = 106 THEN 'op_return'
+      WHEN LENGTH(_spk) > 3 AND GET_BYTE(_spk, 0) BETWEEN 81 AND 96
+       AND GET_BYTE(_spk, LENGTH(_spk) - 1) = 174 THEN 'multisig'
+      ELSE 'nonstandard' END
+  $$ LANGUAGE sql IMMUTABLE;
+
+  CREATE TABLE IF NOT EXISTS script_type_stats (
+    period       TEXT NOT NULL -- day, week, month or year
+   ,start        DATE NOT NULL -- UTC
+   ,script_type  TEXT NOT NULL
+   ,outputs      BIGINT NOT NULL
+   ,value        BIGINT NOT NULL
+   ,PRIMARY KEY (period, start, script_type)
+  );
+`)
+	return err
+}
+
+// UpdateScriptTypeStats (re)computes the statistics per period from
+// the period of the block at sinceHeight on (0 = everything).
+func UpdateScriptTypeStats(connstr, period string, sinceHeight int) error {
+	if !scriptTypePeriods[period] {
+		return fmt.Errorf("Invalid period %q, expected day, week, month or year.", period)
+	}
+	db, err := sql.Open("postgres", connstr)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if err := createScriptTypeStats(db); err != nil {
+		return err
+	}
+
+	var since time.Time
+	if sinceHeight > 0 {
+		var t sql.NullTime
+		if err := db.QueryRow(`
+SELECT DATE_TRUNC($1, TO_TIMESTAMP(uint32(MIN(time))) AT TIME ZONE 'UTC')::DATE
+  FROM blocks
+ WHERE height >= $2 AND NOT orphan`, period, sinceHeight).Scan(&t); err != nil {
+			return err
+		}
+		if !t.Valid {
+			return fmt.Errorf("No blocks at or above height %d.", sinceHeight)
+		}
+		since = t.Time
+	}
+
+	log.Printf("Counting the outputs per script type and %s since %s...", period, since.Format("2006-01-02"))
+	start := time.Now()
+	txn, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer txn.Rollback()
+
+	if _, err := txn.Exec("DELETE FROM script_type_stats WHERE period = $1 AND start >= $2", period, since); err != nil {
+		return err
+	}
+	res, err := txn.Exec(`
+INSERT INTO script_type_stats (period, start, script_type, outputs, value)
+SELECT $1, b.start, script_type(o.scriptpubkey), COUNT(1), SUM(o.value)
+  FROM (SELECT id, DATE_TRUNC($1, TO_TIMESTAMP(uint32(time)) AT TIME ZONE 'UTC')::DATE AS start
+          FROM blocks
+         WHERE NOT orphan) b
+  JOIN block_txs bt ON bt.block_id = b.id
+  JOIN txouts_v o ON o.tx_id = bt.tx_id
+ WHERE b.start >= $2
+ GROUP BY 1, 2, 3`, period, since)
+	if err != nil {
+		return err
+	}
+	if err := txn.Commit(); err != nil {
+		return err
+	}
+	n, _ := res.RowsAffected()
+	log.Printf("  ...%d rows done in %s.", n, time.Now().Sub(start).Round(time.Millisecond))
+	return nil
+}
+
+// ScriptTypeStats returns the statistics of the period, ordered by
+// start and type.
+func ScriptTypeStats(connstr, period string) ([]*ScriptTypeStat, error) {
+	db, err := sql.Open("postgres", connstr)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	rows, err := db.Query(`
+SELECT start, script_type, outputs, value
+      ,outputs::FLOAT8 / SUM(outputs) OVER (PARTITION BY start)
+  FROM script_type_stats
+ WHERE period = $1
+ ORDER BY start, script_type`, period)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []*ScriptTypeStat
+	for rows.Next() {
+		s := &ScriptTypeStat{}
+		if err := rows.Scan(&s.Start, &s.ScriptType, &s.Outputs, &s.Value, &s.Share); err != nil {
+			return nil, err
+		}
+		result = append(result, s)
+	}
+	return result, rows.Err()
+}
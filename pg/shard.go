@@ -0,0 +1,495 @@
+package pg
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Sharding, for deployments too large for a single server. With
+// ShardConnectStrings in the WriterConfig, the txins and txouts rows
+// are written to the shard databases by tx_id % number of shards,
+// while blocks, block_txs and txs (and everything else) stay on the
+// database of ConnectString, the coordinator. The txs table has to stay
+// central, since resolving the prevout txid of every input to a tx_id
+// needs all of it.
+//
+// The inputs and the outputs of a transaction are on the same shard,
+// an input and the output it spends generally are not. The shards have
+// no txins trigger, the spent flags are set by the writer instead. The
+// foreign keys are not created on the shards, and shard_heights on the
+// coordinator records the height up to which each shard has been
+// written. The number of shards cannot be changed after the first
+// import.
+//
+// DedupScripts, AddressHistory, AddressEvents, UtxoSnapshotInterval
+// and PruneDepth are not supported with sharding, the queries they rely
+// on need txins and txouts in the same database. Use QueryShards to
+// run a query on every shard.
+
+const shardSpentBatch = 10000 // (tx_id, n) pairs per UPDATE
+
+type shardSet struct {
+	dbs []*sql.DB
+}
+
+// ShardOf returns the shard of the rows of a transaction.
+func ShardOf(txId int64, shards int) int {
+	return int(txId % int64(shards))
+}
+
+func (s *shardSet) of(txId int64) int {
+	return ShardOf(txId, len(s.dbs))
+}
+
+func (s *shardSet) close() {
+	for _, db := range s.dbs {
+		db.Close()
+	}
+}
+
+func checkShardConfig(cfg *WriterConfig) error {
+	if len(cfg.ShardConnectStrings) == 0 {
+		return nil
+	}
+	if cfg.ConnectString == "nulldb" {
+		return fmt.Errorf("Sharding requires a database.")
+	}
+	if cfg.DedupScripts || cfg.AddressHistory || cfg.AddressEvents || cfg.UtxoSnapshotInterval > 0 || cfg.PruneDepth > 0 {
+		return fmt.Errorf("Script deduplication, address history, address events, UTXO snapshots and pruning are not supported with sharding.")
+	}
+	return nil
+}
+
+// openShards opens the shard databases and creates their tables. Each
+// shard must be in the same state as the coordinator, i.e. empty on
+// the first import.
+func openShards(cfg *WriterConfig, firstImport bool) (*shardSet, error) {
+	s := &shardSet{}
+	for i, connstr := range cfg.ShardConnectStrings {
+		db, err := cfg.openDB(connstr)
+		if err != nil {
+			s.close()
+			return nil, err
+		}
+		s.dbs = append(s.dbs, db)
+
+		if err := createChainSchema(db, cfg.Chain); err != nil {
+			s.close()
+			return nil, fmt.Errorf("Shard %d: %v", i, err)
+		}
+		created := true
+		if err := createTables(db); err != nil {
+			if !strings.Contains(err.Error(), "already exists") {
+				s.close()
+				return nil, fmt.Errorf("Shard %d: %v", i, err)
+			}
+			created = false
+		}
+		if created != firstImport {
+			s.close()
+			return nil, fmt.Errorf("Shard %d is not in the same state as the coordinator (tables exist: %v).", i, !created)
+		}
+		if firstImport {
+			if err := setTableStorageParams(db); err != nil {
+				s.close()
+				return nil, fmt.Errorf("Shard %d: %v", i, err)
+			}
+		}
+		if err := createImportProgressTable(db); err != nil {
+			s.close()
+			return nil, fmt.Errorf("Shard %d: %v", i, err)
+		}
+		if err := addSpentSameBlockColumn(db); err != nil {
+			s.close()
+			return nil, fmt.Errorf("Shard %d: %v", i, err)
+		}
+		if err := createTxoutsView(db, false); err != nil {
+			s.close()
+			return nil, fmt.Errorf("Shard %d: %v", i, err)
+		}
+		if err := setColumnStorage(db, cfg.ColumnStorage, cfg.ColumnCompression); err != nil {
+			s.close()
+			return nil, fmt.Errorf("Shard %d: %v", i, err)
+		}
+	}
+	return s, nil
+}
+
+func createShardHeightsTable(db *sql.DB, shards int) error {
+	if _, err := db.Exec(`
+  CREATE TABLE IF NOT EXISTS shard_heights (
+   shard          INT NOT NULL PRIMARY KEY
+  ,height         INT NOT NULL -- written up to and including
+  );
+`); err != nil {
+		return err
+	}
+	var n int
+	if err := db.QueryRow("SELECT COUNT(1) FROM shard_heights").Scan(&n); err != nil {
+		return err
+	}
+	if n != 0 && n != shards {
+		return fmt.Errorf("The database has %d shards, cannot continue with %d.", n, shards)
+	}
+	return nil
+}
+
+// updateHeights records the last height of the coordinator as the
+// height of every shard. It must be called after the rows up to it have
+// been committed on all shards.
+func (s *shardSet) updateHeights(db *sql.DB) error {
+	for i := range s.dbs {
+		if _, err := db.Exec(`
+INSERT INTO shard_heights (shard, height)
+SELECT $1, COALESCE(MAX(height), -1) FROM blocks
+    ON CONFLICT (shard) DO UPDATE SET height = EXCLUDED.height`, i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ShardHeights returns the height each shard has been written up to.
+func ShardHeights(connstr string) ([]int, error) {
+	db, err := sql.Open("postgres", connstr)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	rows, err := db.Query("SELECT height FROM shard_heights ORDER BY shard")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var heights []int
+	for rows.Next() {
+		var h int
+		if err := rows.Scan(&h); err != nil {
+			return nil, err
+		}
+		heights = append(heights, h)
+	}
+	return heights, rows.Err()
+}
+
+// routeTxIns resolves the prevout tx_id of each input, then passes it
+// on to the writer of its shard. The commit signals are passed on to
+// every shard, and once all shards have committed (after the first
+// import), the spent flags of the outputs spent are set.
+func (s *shardSet) routeTxIns(c chan *txInRec, coord *sql.DB, firstImport bool, upsert bool, fail *writerFailure) {
+	defer writerWg.Done()
+
+	outs := make([]chan *txInRec, len(s.dbs))
+	for i, db := range s.dbs {
+		outs[i] = make(chan *txInRec, 64)
+		go pgTxInWriter(outs[i], db, firstImport, false, upsert, fail)
+	}
+	defer fail.catch("txins router", func() {
+		for _, out := range outs {
+			close(out)
+		}
+		for tr := range c {
+			if tr != nil && tr.sync != nil {
+				tr.sync <- false
+			}
+		}
+	})
+
+	spent := make([]spentOuts, len(s.dbs))
+
+	for tr := range c {
+		if tr == nil || tr.txIn == nil { // commit signal
+			var hw *highWater
+			if tr != nil {
+				hw = tr.hw
+			}
+			if firstImport {
+				for _, out := range outs {
+					out <- &txInRec{hw: hw}
+				}
+			} else {
+				s.waitCommit(outs, hw)
+				for i, db := range s.dbs {
+					if err := spent[i].mark(db); err != nil {
+						log.Printf("Shard %d: error setting spent flags: %v", i, err)
+					}
+				}
+				if err := s.updateHeights(coord); err != nil {
+					log.Printf("Error updating shard heights: %v", err)
+				}
+			}
+			if tr != nil && tr.sync != nil {
+				tr.sync <- true
+			}
+			continue
+		}
+
+		t := tr.txIn
+		var prevOutTxId *int64
+		if t.PrevOut.N != 0xffffffff { // coinbase
+			if prevOutTxId = tr.idCache.check(t.PrevOut.Hash); prevOutTxId == nil {
+				if firstImport {
+					// fixed in fixPrevoutTxIds() at the end
+					if err := recordPrevoutMiss(coord, tr.txId, tr.n, t.PrevOut.Hash); err != nil {
+						log.Printf("ERROR (S1): %v", err)
+					}
+				} else if tr.txids.mayContain(t.PrevOut.Hash) {
+					var id sql.NullInt64
+					if err := coord.QueryRow("SELECT MAX(id) FROM txs WHERE txid = $1", t.PrevOut.Hash[:]).Scan(&id); err != nil {
+						log.Printf("ERROR (S2): %v", err)
+					}
+					if id.Valid {
+						prevOutTxId = &id.Int64
+					}
+				}
+			}
+			if prevOutTxId != nil && !firstImport {
+				sh := s.of(*prevOutTxId)
+				spent[sh].add(*prevOutTxId, int32(t.PrevOut.N))
+			}
+		}
+
+		outs[s.of(tr.txId)] <- &txInRec{
+			txId:        tr.txId,
+			n:           tr.n,
+			txIn:        t,
+			prevOutTxId: prevOutTxId,
+		}
+	}
+
+	for _, out := range outs {
+		close(out)
+	}
+}
+
+// routeTxOuts passes each output on to the writer of its shard.
+func (s *shardSet) routeTxOuts(c chan *txOutRec, utxo isUTXOer, upsert bool, fail *writerFailure) {
+	defer writerWg.Done()
+
+	outs := make([]chan *txOutRec, len(s.dbs))
+	for i, db := range s.dbs {
+		outs[i] = make(chan *txOutRec, 64)
+		go pgTxOutWriter(outs[i], db, utxo, false, false, nil, upsert, fail)
+	}
+	defer fail.catch("txouts router", func() {
+		for _, out := range outs {
+			close(out)
+		}
+		for tr := range c {
+			if tr != nil && tr.sync != nil {
+				tr.sync <- false
+			}
+		}
+	})
+
+	for tr := range c {
+		if tr == nil || tr.txOut == nil { // commit signal
+			if tr == nil || tr.sync == nil {
+				var hw *highWater
+				if tr != nil {
+					hw = tr.hw
+				}
+				for _, out := range outs {
+					out <- &txOutRec{hw: hw}
+				}
+				continue
+			}
+			var wg sync.WaitGroup
+			for _, out := range outs {
+				wg.Add(1)
+				done := make(chan bool)
+				out <- &txOutRec{sync: done, hw: tr.hw}
+				go func() { <-done; wg.Done() }()
+			}
+			wg.Wait()
+			tr.sync <- true
+			continue
+		}
+		outs[s.of(tr.txId)] <- tr
+	}
+
+	for _, out := range outs {
+		close(out)
+	}
+}
+
+// waitCommit sends a commit signal to every shard writer and waits for
+// all of them.
+func (s *shardSet) waitCommit(outs []chan *txInRec, hw *highWater) {
+	var wg sync.WaitGroup
+	for _, out := range outs {
+		wg.Add(1)
+		done := make(chan bool)
+		out <- &txInRec{sync: done, hw: hw}
+		go func() { <-done; wg.Done() }()
+	}
+	wg.Wait()
+}
+
+// The outputs spent by the inputs written since the last commit, on
+// one shard.
+type spentOuts struct {
+	txIds []int64
+	ns    []int32
+}
+
+func (so *spentOuts) add(txId int64, n int32) {
+	so.txIds = append(so.txIds, txId)
+	so.ns = append(so.ns, n)
+}
+
+func (so *spentOuts) mark(db *sql.DB) error {
+	defer func() {
+		so.txIds, so.ns = so.txIds[:0], so.ns[:0]
+	}()
+	for i := 0; i < len(so.txIds); i += shardSpentBatch {
+		j := i + shardSpentBatch
+		if j > len(so.txIds) {
+			j = len(so.txIds)
+		}
+		if _, err := db.Exec(`
+UPDATE txouts o
+   SET spent = TRUE
+  FROM unnest($1::BIGINT[], $2::INT[]) AS u(tx_id, n)
+ WHERE o.tx_id = u.tx_id
+   AND o.n = u.n
+   AND NOT o.spent`, pq.Array(so.txIds[i:j]), pq.Array(so.ns[i:j])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fixPrevoutTxIds is fixPrevoutTxId for the shards: the misses
+// recorded on the coordinator are resolved there and the results
+// written to the shard of each input.
+func (s *shardSet) fixPrevoutTxIds(coord *sql.DB) error {
+	rows, err := coord.Query(`
+SELECT m.tx_id, m.n, MAX(t.id)
+  FROM _prevout_miss m
+  JOIN txs t ON t.txid = m.prevout_hash
+ GROUP BY m.tx_id, m.n`)
+	if err != nil {
+		return err
+	}
+	type fix struct {
+		txIds, ids []int64
+		ns         []int32
+	}
+	fixes := make([]fix, len(s.dbs))
+	for rows.Next() {
+		var (
+			txId, id int64
+			n        int32
+		)
+		if err := rows.Scan(&txId, &n, &id); err != nil {
+			rows.Close()
+			return err
+		}
+		f := &fixes[s.of(txId)]
+		f.txIds, f.ns, f.ids = append(f.txIds, txId), append(f.ns, n), append(f.ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for i, db := range s.dbs {
+		f := fixes[i]
+		for j := 0; j < len(f.txIds); j += shardSpentBatch {
+			k := j + shardSpentBatch
+			if k > len(f.txIds) {
+				k = len(f.txIds)
+			}
+			if _, err := db.Exec(`
+UPDATE txins i
+   SET prevout_tx_id = u.id
+  FROM unnest($1::BIGINT[], $2::INT[], $3::BIGINT[]) AS u(tx_id, n, id)
+ WHERE i.tx_id = u.tx_id
+   AND i.n = u.n`, pq.Array(f.txIds[j:k]), pq.Array(f.ns[j:k]), pq.Array(f.ids[j:k])); err != nil {
+				return fmt.Errorf("Shard %d: %v", i, err)
+			}
+		}
+	}
+	return clearPrevoutMissTable(coord)
+}
+
+// finish creates the indexes on the shards at the end of the
+// first import.
+func (s *shardSet) finish(coord *sql.DB, strategy string, verbose bool) {
+	var wg sync.WaitGroup
+	for i, db := range s.dbs {
+		wg.Add(1)
+		go func(i int, db *sql.DB) {
+			defer wg.Done()
+			start := time.Now()
+			if err := createIndexes(db, strategy, false); err != nil {
+				log.Printf("Shard %d: error creating indexes: %v", i, err)
+			}
+			if err := resetTableStorageParams(db); err != nil {
+				log.Printf("Shard %d: error resetting storage parameters: %v", i, err)
+			}
+			if verbose {
+				log.Printf("  Shard %d indexes done in %s.", i, time.Now().Sub(start).Round(time.Millisecond))
+			}
+		}(i, db)
+	}
+	wg.Wait()
+	if err := s.updateHeights(coord); err != nil {
+		log.Printf("Error updating shard heights: %v", err)
+	}
+}
+
+// QueryShards runs the query on every shard in parallel, and calls fn
+// with the rows of each (one at a time, in no particular order). The
+// first error is returned.
+func QueryShards(connstrs []string, query string, args []interface{}, fn func(shard int, rows *sql.Rows) error) error {
+	var (
+		wg    sync.WaitGroup
+		mu    sync.Mutex
+		first error
+	)
+	for i, connstr := range connstrs {
+		wg.Add(1)
+		go func(i int, connstr string) {
+			defer wg.Done()
+			err := queryShard(i, connstr, query, args, func(rows *sql.Rows) error {
+				mu.Lock()
+				defer mu.Unlock()
+				return fn(i, rows)
+			})
+			if err != nil {
+				mu.Lock()
+				if first == nil {
+					first = fmt.Errorf("Shard %d: %v", i, err)
+				}
+				mu.Unlock()
+			}
+		}(i, connstr)
+	}
+	wg.Wait()
+	return first
+}
+
+func queryShard(i int, connstr, query string, args []interface{}, fn func(rows *sql.Rows) error) error {
+	db, err := sql.Open("postgres", connstr)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	if err := fn(rows); err != nil {
+		return err
+	}
+	return rows.Err()
+}
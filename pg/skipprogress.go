@@ -0,0 +1,74 @@
+package pg
+
+import (
+	"database/sql"
+
+	"github.com/blkchain/blkchain/core"
+)
+
+// Progress of the skip phase. When the blocks given to the first
+// import do not start right at the last block in the database, the
+// block worker ignores them until it gets to it, which, from far back,
+// means reading a lot of blocks for nothing. Every few seconds the
+// last block ignored is recorded, by the hash of the block being
+// looked for (the target), so that an interrupted import can start
+// there the next time (see SkipResumeHeight) rather than from the
+// beginning again. The row is deleted once the target is found.
+
+func createSkipProgressTable(db *sql.DB) error {
+	_, err := db.Exec(`
+  CREATE TABLE IF NOT EXISTS import_skip_progress (
+   target         BYTEA NOT NULL PRIMARY KEY -- hash of the last block in the db
+  ,height         INT NOT NULL -- of the last block ignored, -1 if not known
+  ,hash           BYTEA NOT NULL
+  ,skipped        INT NOT NULL
+  ,updated        TIMESTAMPTZ NOT NULL DEFAULT now()
+  );
+`)
+	return err
+}
+
+func saveSkipProgress(db *sql.DB, st *SkipStatus, target, hash core.Uint256) error {
+	if db == nil {
+		return nil
+	}
+	_, err := db.Exec(`
+INSERT INTO import_skip_progress (target, height, hash, skipped) VALUES ($1, $2, $3, $4)
+    ON CONFLICT (target) DO UPDATE SET height = EXCLUDED.height, hash = EXCLUDED.hash,
+                                       skipped = EXCLUDED.skipped, updated = now()`,
+		target[:], st.Height, hash[:], st.Skipped)
+	return err
+}
+
+func clearSkipProgress(db *sql.DB) error {
+	if db == nil {
+		return nil
+	}
+	_, err := db.Exec("DELETE FROM import_skip_progress")
+	return err
+}
+
+// SkipResumeHeight returns the height of the last block ignored by an
+// interrupted skip phase which was looking for the block which is
+// still the last one in the database, -1 if there is none.
+func (w *PGWriter) SkipResumeHeight() (int, error) {
+	if w.db == nil {
+		return -1, nil
+	}
+	hashes, err := getHeightAndHashes(w.db, 1)
+	if err != nil {
+		return -1, err
+	}
+	height := -1
+	for _, hh := range hashes {
+		target := hh[len(hh)-1]
+		err := w.db.QueryRow("SELECT height FROM import_skip_progress WHERE target = $1", target[:]).Scan(&height)
+		if err == sql.ErrNoRows {
+			return -1, nil
+		}
+		if err != nil {
+			return -1, err
+		}
+	}
+	return height, nil
+}
@@ -0,0 +1,196 @@
+package pg
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/blkchain/blkchain/core"
+)
+
+// A Spool is a write-ahead queue of blocks on local disk, for when the
+// database is unavailable while following the node. Blocks are appended
+// to a single file as records of a 4-byte little-endian length followed
+// by the block as in the blk*.dat files, and fsync'ed, so that nothing
+// is lost if the import is restarted during the outage. Replay writes
+// them out in order once the database is back.
+//
+// A record cut short (e.g. by a crash during Append) is discarded.
+
+const (
+	spoolFile      = "blocks.spool"
+	maxSpoolRecord = 32 << 20 // anything larger is garbage
+)
+
+type Spool struct {
+	path string
+	f    *os.File
+	n    int // records
+}
+
+// OpenSpool opens (creating if needed) the spool in dir. Blocks left
+// over from a previous run are kept.
+func OpenSpool(dir string) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	s := &Spool{path: filepath.Join(dir, spoolFile)}
+	f, err := os.OpenFile(s.path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	s.f = f
+
+	// Count the records, truncating a partial last one.
+	end, err := s.scan(nil)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := f.Truncate(end); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err := f.Seek(end, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if s.n > 0 {
+		log.Printf("Spool %s has %d blocks waiting to be written.", s.path, s.n)
+	}
+	return s, nil
+}
+
+// Len returns the number of blocks in the spool.
+func (s *Spool) Len() int {
+	return s.n
+}
+
+// Append adds a block to the end of the spool.
+func (s *Spool) Append(b *core.Block) error {
+	var buf bytes.Buffer
+	buf.Write([]byte{0, 0, 0, 0}) // length placeholder
+	if err := core.BinWrite(b, &buf); err != nil {
+		return err
+	}
+	rec := buf.Bytes()
+	binary.LittleEndian.PutUint32(rec, uint32(len(rec)-4))
+	if _, err := s.f.Write(rec); err != nil {
+		return err
+	}
+	if err := s.f.Sync(); err != nil {
+		return err
+	}
+	s.n++
+	return nil
+}
+
+// Replay calls fn with every block in the spool, in order. The blocks
+// for which fn succeeded are removed from the spool, replay stops at
+// the first error, which is returned. Returns the number of blocks
+// replayed.
+func (s *Spool) Replay(fn func(*core.Block) error) (int, error) {
+	if s.n == 0 {
+		return 0, nil
+	}
+	done, cnt := int64(0), 0
+	_, err := s.scan(func(b *core.Block, end int64) error {
+		if err := fn(b); err != nil {
+			return err
+		}
+		done = end
+		cnt++
+		return nil
+	})
+	if done > 0 {
+		if terr := s.trim(done); terr != nil {
+			return cnt, terr
+		}
+		s.n -= cnt
+	}
+	return cnt, err
+}
+
+func (s *Spool) Close() error {
+	return s.f.Close()
+}
+
+// scan reads the records from the start of the file, calling fn (if
+// not nil) with each block and the offset of the end of its record,
+// and counts them. Returns the offset of the end of the last complete
+// record.
+func (s *Spool) scan(fn func(*core.Block, int64) error) (int64, error) {
+	if _, err := s.f.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	defer s.f.Seek(0, io.SeekEnd)
+
+	r := bufio.NewReader(s.f)
+	var end int64
+	n := 0
+	for {
+		var size uint32
+		if err := binary.Read(r, binary.LittleEndian, &size); err != nil {
+			break // io.EOF or a partial length
+		}
+		if size > maxSpoolRecord {
+			log.Printf("Spool: discarding a bad record at offset %d.", end)
+			break
+		}
+		rec := make([]byte, size)
+		if _, err := io.ReadFull(r, rec); err != nil {
+			log.Printf("Spool: discarding a partial record at offset %d.", end)
+			break
+		}
+		if fn != nil {
+			var b core.Block
+			if err := core.BinRead(&b, bytes.NewReader(rec)); err != nil {
+				return end, fmt.Errorf("Spool: bad record at offset %d: %v", end, err)
+			}
+			if err := fn(&b, end+4+int64(size)); err != nil {
+				return end, err
+			}
+		}
+		end += 4 + int64(size)
+		n++
+	}
+	if fn == nil {
+		s.n = n
+	}
+	return end, nil
+}
+
+// trim removes the records before offset by copying the rest to a new
+// file which replaces the spool.
+func (s *Spool) trim(offset int64) error {
+	tmp := s.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := s.f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := io.Copy(f, s.f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		f.Close()
+		return err
+	}
+	s.f.Close()
+	s.f = f
+	_, err = f.Seek(0, io.SeekEnd)
+	return err
+}
@@ -0,0 +1,127 @@
+package pg
+
+import (
+	"database/sql"
+)
+
+// Disk usage of the database by table and index, for planning
+// storage.
+
+type IndexStats struct {
+	Name  string
+	Bytes int64
+}
+
+type TableStats struct {
+	Name       string
+	TableBytes int64 // including TOAST
+	IndexBytes int64
+	Rows       int64 // estimate
+	DeadRows   int64
+	Indexes    []*IndexStats
+}
+
+// Bloat is estimated as the ratio of dead to all rows.
+func (t *TableStats) Bloat() float64 {
+	if t.Rows+t.DeadRows == 0 {
+		return 0
+	}
+	return float64(t.DeadRows) / float64(t.Rows+t.DeadRows)
+}
+
+type Stats struct {
+	Tables     []*TableStats
+	TotalBytes int64 // whole database
+	ChainBytes int64 // sum of main chain block sizes
+	Height     int
+}
+
+// If nothing has been imported yet, assume the database will be about
+// this many times the size of the block files. This is a rough figure
+// with all the indexes.
+const DefaultDbToChainRatio = 1.6
+
+// ProjectedBytes estimates the database size for the given size of
+// the whole chain (e.g. the total of blk*.dat files).
+func (s *Stats) ProjectedBytes(chainBytes int64) int64 {
+	ratio := DefaultDbToChainRatio
+	if s.ChainBytes > 1024*1024*1024 { // too little data is not representative
+		ratio = float64(s.TotalBytes) / float64(s.ChainBytes)
+	}
+	return int64(float64(chainBytes) * ratio)
+}
+
+func DatabaseStats(connstr string) (*Stats, error) {
+	db, err := sql.Open("postgres", connstr)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	var stats Stats
+	if err := db.QueryRow("SELECT pg_database_size(current_database())").Scan(&stats.TotalBytes); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`
+SELECT c.relname, pg_table_size(c.oid), pg_indexes_size(c.oid)
+      ,GREATEST(c.reltuples, 0)::BIGINT, COALESCE(s.n_dead_tup, 0)
+  FROM pg_class c
+  JOIN pg_namespace n ON n.oid = c.relnamespace
+  LEFT JOIN pg_stat_user_tables s ON s.relid = c.oid
+ WHERE c.relkind IN ('r', 'p', 'm')
+   AND n.nspname = current_schema()
+ ORDER BY pg_total_relation_size(c.oid) DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	byName := make(map[string]*TableStats)
+	for rows.Next() {
+		var t TableStats
+		if err := rows.Scan(&t.Name, &t.TableBytes, &t.IndexBytes, &t.Rows, &t.DeadRows); err != nil {
+			return nil, err
+		}
+		stats.Tables = append(stats.Tables, &t)
+		byName[t.Name] = &t
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	irows, err := db.Query(`
+SELECT i.relname, i.indexrelname, pg_relation_size(i.indexrelid)
+  FROM pg_stat_user_indexes i
+ WHERE i.schemaname = current_schema()
+ ORDER BY 1, 3 DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer irows.Close()
+
+	for irows.Next() {
+		var (
+			table string
+			idx   IndexStats
+		)
+		if err := irows.Scan(&table, &idx.Name, &idx.Bytes); err != nil {
+			return nil, err
+		}
+		if t, ok := byName[table]; ok {
+			t.Indexes = append(t.Indexes, &idx)
+		}
+	}
+	if err := irows.Err(); err != nil {
+		return nil, err
+	}
+
+	if _, ok := byName["blocks"]; ok {
+		if err := db.QueryRow("SELECT COALESCE(SUM(size), 0), COALESCE(MAX(height), 0) FROM blocks WHERE NOT orphan").
+			Scan(&stats.ChainBytes, &stats.Height); err != nil {
+			return nil, err
+		}
+	}
+
+	return &stats, nil
+}
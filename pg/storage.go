@@ -0,0 +1,79 @@
+package pg
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// The script and witness BYTEA columns are mostly short and poorly
+// compressible, the default EXTENDED storage spends time trying to
+// compress them. EXTERNAL stores large values out of line without
+// compression, MAIN compresses but keeps them in line as long as
+// possible. On PG14+ the compression method can also be changed from
+// pglz to the much faster lz4 (the server must be built with
+// lz4). These settings only apply to rows written afterwards.
+
+var byteaColumns = []struct{ table, column string }{
+	{"txins", "scriptsig"},
+	{"txins", "witness"},
+	{"txouts", "scriptpubkey"},
+	{"scripts", "script"}, // with DedupScripts
+}
+
+func serverVersionNum(db *sql.DB) (int, error) {
+	var v int
+	err := db.QueryRow("SELECT current_setting('server_version_num')::INT").Scan(&v)
+	return v, err
+}
+
+// storage is "", "plain", "external", "extended" or "main",
+// compression is "", "pglz" or "lz4". Empty means leave as is.
+func setColumnStorage(db *sql.DB, storage, compression string) error {
+	storage, compression = strings.ToUpper(storage), strings.ToLower(compression)
+	if storage == "" && compression == "" {
+		return nil
+	}
+	switch storage {
+	case "", "PLAIN", "EXTERNAL", "EXTENDED", "MAIN":
+	default:
+		return fmt.Errorf("Invalid column storage: %q", storage)
+	}
+	switch compression {
+	case "", "pglz", "lz4":
+	default:
+		return fmt.Errorf("Invalid column compression: %q", compression)
+	}
+	if compression != "" {
+		v, err := serverVersionNum(db)
+		if err != nil {
+			return err
+		}
+		if v < 140000 {
+			return fmt.Errorf("Column compression requires PostgreSQL 14 or later (server_version_num is %d).", v)
+		}
+	}
+
+	for _, c := range byteaColumns {
+		exists, err := columnExists(db, c.table, c.column)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			continue
+		}
+		if storage != "" {
+			if _, err := db.Exec(fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s SET STORAGE %s", c.table, c.column, storage)); err != nil {
+				return err
+			}
+		}
+		if compression != "" {
+			if _, err := db.Exec(fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s SET COMPRESSION %s", c.table, c.column, compression)); err != nil {
+				return err
+			}
+		}
+	}
+	log.Printf("Set BYTEA column storage: %q compression: %q.", storage, compression)
+	return nil
+}
@@ -0,0 +1,72 @@
+package pg
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Throttling, for importing into a database which also serves other
+// workloads: the block worker paces itself so that no more than
+// WriterConfig.ThrottleRows rows (blocks, txs, inputs and outputs) or
+// ThrottleBytes bytes of blocks per second go to the writers. The
+// writers themselves copy as fast as they are fed. cmd/import
+// -throttle also lowers the CPU and I/O priority of the import.
+
+// ParseThrottle parses a limit such as "50000rows/s" or "20MB/s" (the
+// "/s" is optional) into rows or bytes per second, one of which is 0.
+func ParseThrottle(s string) (rows int, bytes int64, err error) {
+	v := strings.TrimSuffix(strings.TrimSpace(s), "/s")
+	unit := strings.TrimLeft(v, "0123456789.")
+	n, err := strconv.ParseFloat(strings.TrimSuffix(v, unit), 64)
+	if err != nil || n <= 0 {
+		return 0, 0, fmt.Errorf("Invalid throttle %q, expected e.g. 50000rows/s or 20MB/s.", s)
+	}
+	switch strings.ToLower(unit) {
+	case "rows", "row":
+		return int(n), 0, nil
+	case "kb":
+		return 0, int64(n * 1024), nil
+	case "mb":
+		return 0, int64(n * 1024 * 1024), nil
+	}
+	return 0, 0, fmt.Errorf("Invalid throttle unit %q, expected rows, KB or MB.", unit)
+}
+
+type throttle struct {
+	rows  int
+	bytes int64
+	next  time.Time // when the next block may be sent
+}
+
+// newThrottle returns nil if there is no limit, which is a no-op.
+func newThrottle(rows int, bytes int64) *throttle {
+	if rows <= 0 && bytes <= 0 {
+		return nil
+	}
+	return &throttle{rows: rows, bytes: bytes}
+}
+
+// wait sleeps for as long as sending the rows and bytes of the last
+// block takes at the limit, less the time since the block before.
+func (t *throttle) wait(rows int, bytes int64) {
+	if t == nil {
+		return
+	}
+	var d time.Duration
+	if t.rows > 0 {
+		d = time.Duration(rows) * time.Second / time.Duration(t.rows)
+	}
+	if t.bytes > 0 {
+		if db := time.Duration(bytes) * time.Second / time.Duration(t.bytes); db > d {
+			d = db
+		}
+	}
+	now := time.Now()
+	if t.next.Before(now) {
+		t.next = now
+	}
+	t.next = t.next.Add(d)
+	time.Sleep(t.next.Sub(now))
+}
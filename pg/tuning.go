@@ -0,0 +1,182 @@
+package pg
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// At startup we look at the relevant server settings and log
+// recommendations for the bulk import. Some settings can be changed
+// per session, these can be applied automatically (WriterConfig.Tune),
+// the rest are up to the DBA (see also PostgreSQL Tuning in the
+// README).
+
+const (
+	kB = 1024
+	mB = 1024 * kB
+	gB = 1024 * mB
+)
+
+type pgSetting struct {
+	name  string
+	value string // as shown, e.g. "off" or "128MB"
+	bytes int64  // for memory/size settings, otherwise 0
+}
+
+type recommendation struct {
+	name    string
+	value   string // recommended value
+	session bool   // can be set per session (in the connect string)
+	check   func(s *pgSetting) bool
+	why     string
+}
+
+var recommendations = []*recommendation{
+	{"synchronous_commit", "off", true,
+		func(s *pgSetting) bool { return s.value == "off" },
+		"not waiting for the WAL flush on every commit speeds up the import"},
+	{"maintenance_work_mem", "2GB", true,
+		func(s *pgSetting) bool { return s.bytes >= 1*gB },
+		"index creation at the end of the import is much faster with more memory"},
+	{"work_mem", "64MB", true,
+		func(s *pgSetting) bool { return s.bytes >= 64*mB },
+		"fixing prevout_tx_id and marking orphans involve large joins"},
+	{"max_wal_size", "16GB", false,
+		func(s *pgSetting) bool { return s.bytes >= 8*gB },
+		"a small max_wal_size causes very frequent checkpoints during COPY"},
+	{"shared_buffers", "1GB-8GB", false,
+		func(s *pgSetting) bool { return s.bytes <= 8*gB },
+		"the import working set does not fit in memory anyway, the OS cache does better"},
+}
+
+func getSettings(db *sql.DB, names []string) (map[string]*pgSetting, error) {
+	rows, err := db.Query(`
+SELECT name, setting, COALESCE(unit, '') FROM pg_settings WHERE name = ANY(string_to_array($1, ','))`,
+		strings.Join(names, ","))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make(map[string]*pgSetting, len(names))
+	for rows.Next() {
+		var name, setting, unit string
+		if err := rows.Scan(&name, &setting, &unit); err != nil {
+			return nil, err
+		}
+		result[name] = &pgSetting{
+			name:  name,
+			value: setting,
+			bytes: settingBytes(setting, unit),
+		}
+	}
+	return result, rows.Err()
+}
+
+// pg_settings units for memory are things like "8kB" or "MB", the
+// value is a multiple of the unit.
+func settingBytes(setting, unit string) int64 {
+	n, err := strconv.ParseInt(setting, 10, 64)
+	if err != nil || unit == "" {
+		return 0
+	}
+	mult := int64(1)
+	num := strings.TrimRightFunc(unit, func(r rune) bool { return r < '0' || r > '9' })
+	if num != "" {
+		mult, _ = strconv.ParseInt(num, 10, 64)
+	}
+	switch strings.TrimPrefix(unit, num) {
+	case "B":
+	case "kB":
+		mult *= kB
+	case "MB":
+		mult *= mB
+	case "GB":
+		mult *= gB
+	default:
+		return 0 // not a memory setting
+	}
+	return n * mult
+}
+
+// adviseSettings logs recommendations and returns the session-level
+// settings which are not optimal.
+func adviseSettings(db *sql.DB) (map[string]string, error) {
+	names := make([]string, 0, len(recommendations))
+	for _, r := range recommendations {
+		names = append(names, r.name)
+	}
+	settings, err := getSettings(db, names)
+	if err != nil {
+		return nil, err
+	}
+
+	session := make(map[string]string)
+	for _, r := range recommendations {
+		s, ok := settings[r.name]
+		if !ok || r.check(s) {
+			continue
+		}
+		shown := s.value
+		if s.bytes > 0 {
+			shown = fmt.Sprintf("%dMB", s.bytes/mB)
+		}
+		where := "postgresql.conf"
+		if r.session {
+			where = "session (-tune or connect string)"
+			session[r.name] = r.value
+		}
+		log.Printf("Tuning: %s is %s, recommended %s in %s: %s.", r.name, shown, r.value, where, r.why)
+	}
+	return session, nil
+}
+
+// Add run-time parameters to the connect string, which can be a URL
+// or key=value pairs. lib/pq passes unknown keys on to the server as
+// session settings.
+func withSessionSettings(connstr string, settings map[string]string) string {
+	if strings.HasPrefix(connstr, "postgres://") || strings.HasPrefix(connstr, "postgresql://") {
+		if u, err := url.Parse(connstr); err == nil {
+			q := u.Query()
+			for k, v := range settings {
+				q.Set(k, v)
+			}
+			u.RawQuery = q.Encode()
+			return u.String()
+		}
+	}
+	for k, v := range settings {
+		connstr += fmt.Sprintf(" %s=%s", k, v)
+	}
+	return connstr
+}
+
+// Returns the db to use, which is reopened (with open) with the session
+// settings applied if apply is true.
+func tuneSettings(db *sql.DB, connstr string, apply bool, open func(string) (*sql.DB, error)) (*sql.DB, error) {
+	session, err := adviseSettings(db)
+	if err != nil {
+		return nil, err
+	}
+	if !apply || len(session) == 0 {
+		return db, nil
+	}
+
+	newDb, err := open(withSessionSettings(connstr, session))
+	if err != nil {
+		return nil, err
+	}
+	if err := newDb.Ping(); err != nil {
+		newDb.Close()
+		return nil, err
+	}
+	db.Close()
+	for k, v := range session {
+		log.Printf("Tuning: applied %s=%s to the import session.", k, v)
+	}
+	return newDb, nil
+}
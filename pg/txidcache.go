@@ -1,11 +1,11 @@
-package db
+package pg
 
 import (
 	"log"
 	"runtime"
 	"sync"
 
-	"github.com/blkchain/blkchain"
+	"github.com/blkchain/blkchain/core"
 )
 
 // We are avoiding defer Unlock() because rumors are it is slower than
@@ -42,6 +42,8 @@ type txIdCache struct {
 	hits int
 	miss int
 	evic int
+	drop int // random entries removed because the cache is full
+	peak int // the largest size
 	// The following "recent cache" is to not purge the N most recent
 	// transactions. This is necessary when we detect known
 	// transactions during chain splits. (In other words these
@@ -116,10 +118,11 @@ func (c *txIdCache) checkSize() {
 			delete(c.m, k)
 			break
 		}
+		c.drop++
 	}
 }
 
-func (c *txIdCache) add(hash blkchain.Uint256, id int64, cnt int) int64 {
+func (c *txIdCache) add(hash core.Uint256, id int64, cnt int) int64 {
 	var (
 		key    [HASH_PREFIX_SIZE]byte
 		result int64
@@ -147,6 +150,9 @@ func (c *txIdCache) add(hash blkchain.Uint256, id int64, cnt int) int64 {
 			val := uint64(uint64(id<<16) | uint64(uint16(cnt)))
 			c.m[key] = &val
 			result = id
+			if len(c.m) > c.peak {
+				c.peak = len(c.m)
+			}
 		}
 		c.Unlock()
 	}
@@ -154,7 +160,7 @@ func (c *txIdCache) add(hash blkchain.Uint256, id int64, cnt int) int64 {
 }
 
 // Note that check is destructive, see comment at the top.
-func (c *txIdCache) check(hash blkchain.Uint256) *int64 {
+func (c *txIdCache) check(hash core.Uint256) *int64 {
 	var key [HASH_PREFIX_SIZE]byte
 	copy(key[:], hash[:HASH_PREFIX_SIZE])
 
@@ -178,7 +184,7 @@ func (c *txIdCache) check(hash blkchain.Uint256) *int64 {
 func (c *txIdCache) reportStats() {
 	var m runtime.MemStats
 	runtime.ReadMemStats(&m)
-	log.Printf("Txid cache hits: %d (%.02f%%) misses: %d collisions: %d dupes: %d evictions: %d size: %d procmem: %d MiB",
+	log.Printf("Txid cache hits: %d (%.02f%%) misses: %d collisions: %d dupes: %d evictions: %d dropped: %d size: %d procmem: %d MiB",
 		c.hits, float64(c.hits)/(float64(c.hits+c.miss)+0.0001)*100,
-		c.miss, c.cols, c.dups, c.evic, len(c.m), m.Sys/1024/1024)
+		c.miss, c.cols, c.dups, c.evic, c.drop, len(c.m), m.Sys/1024/1024)
 }
@@ -0,0 +1,193 @@
+package pg
+
+import (
+	"database/sql"
+	"encoding/binary"
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/blkchain/blkchain/core"
+)
+
+// A bloom filter of all the txids in the txs table, so that a txid
+// which is not there can be told without asking Postgres. It has no
+// false negatives, and with b bits per txid about 0.6185^b false
+// positives (2% with 8 bits, about 1 GiB for a billion txids), which
+// are simply looked up as before. The txids are hashes already, so the
+// k bit positions are taken from the txid itself (double hashing).
+//
+// It is used by the writer after the first import (see
+// WriterConfig.TxidFilterBits), where a prevout txid missing from the
+// txid cache is only looked up (or recorded as a miss to be resolved)
+// if it may be in the database, and by the Explorer (see
+// Config.TxidFilterBits), which answers lookups of unknown txids with
+// not found. It is built from the txs table at the start, which takes
+// a while on a large database, and is then kept up to date: the writer
+// adds the txs as it writes them, the Explorer reads the txs with ids
+// above the last one it has before rejecting a txid.
+
+const txidFilterBatch = 1_000_000
+
+type txidFilter struct {
+	sync.RWMutex
+	refresh sync.Mutex // one addFrom at a time
+	bits    []uint64
+	m       uint64 // number of bits
+	k       int
+	lastId  int64 // highest tx id added from the db
+	n       int64 // txids added
+	reject  int64 // lookups answered with a no
+}
+
+// newTxidFilter makes a filter for about n txids, with some room for
+// growth, at bitsPerTx bits per txid.
+func newTxidFilter(n int64, bitsPerTx int) *txidFilter {
+	if n < txidFilterBatch {
+		n = txidFilterBatch
+	}
+	words := (n*int64(bitsPerTx)*5/4 + 63) / 64
+	k := int(math.Round(float64(bitsPerTx) * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
+	return &txidFilter{bits: make([]uint64, words), m: uint64(words) * 64, k: k}
+}
+
+func (f *txidFilter) positions(hash core.Uint256, fn func(word int, mask uint64) bool) bool {
+	h1 := binary.LittleEndian.Uint64(hash[0:8])
+	h2 := binary.LittleEndian.Uint64(hash[8:16]) | 1
+	for i := 0; i < f.k; i++ {
+		pos := (h1 + uint64(i)*h2) % f.m
+		if !fn(int(pos/64), 1<<(pos%64)) {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *txidFilter) add(hash core.Uint256) {
+	f.Lock()
+	f.positions(hash, func(word int, mask uint64) bool {
+		f.bits[word] |= mask
+		return true
+	})
+	f.n++
+	f.Unlock()
+}
+
+func (f *txidFilter) contains(hash core.Uint256) bool {
+	f.RLock()
+	result := f.positions(hash, func(word int, mask uint64) bool {
+		return f.bits[word]&mask != 0
+	})
+	f.RUnlock()
+	return result
+}
+
+// mayContain is false if the txid is certainly not in the filter. A
+// nil filter may contain anything.
+func (f *txidFilter) mayContain(hash core.Uint256) bool {
+	if f == nil {
+		return true
+	}
+	result := f.contains(hash)
+	if !result {
+		f.Lock()
+		f.reject++
+		f.Unlock()
+	}
+	return result
+}
+
+// addFrom adds the txids of the txs with ids above the last one added
+// from the db, returning how many.
+func (f *txidFilter) addFrom(db *sql.DB) (int, error) {
+	f.refresh.Lock()
+	defer f.refresh.Unlock()
+	total := 0
+	for {
+		f.RLock()
+		lastId := f.lastId
+		f.RUnlock()
+		rows, err := db.Query("SELECT id, txid FROM txs WHERE id > $1 ORDER BY id LIMIT $2", lastId, txidFilterBatch)
+		if err != nil {
+			return total, err
+		}
+		n := 0
+		for rows.Next() {
+			var (
+				id   int64
+				txid []byte
+			)
+			if err := rows.Scan(&id, &txid); err != nil {
+				rows.Close()
+				return total, err
+			}
+			f.add(core.Uint256FromBytes(txid))
+			f.Lock()
+			f.lastId = id
+			f.Unlock()
+			n++
+		}
+		if err := rows.Err(); err != nil {
+			rows.Close()
+			return total, err
+		}
+		rows.Close()
+		total += n
+		if n < txidFilterBatch {
+			return total, nil
+		}
+		if total%(100*txidFilterBatch) == 0 {
+			log.Printf(" - txid filter: %d txids...", total)
+		}
+	}
+}
+
+// loadTxidFilter builds the filter from the txs table.
+func loadTxidFilter(db *sql.DB, bitsPerTx int) (*txidFilter, error) {
+	start := time.Now()
+	var maxId sql.NullInt64
+	if err := db.QueryRow("SELECT MAX(id) FROM txs").Scan(&maxId); err != nil {
+		return nil, err
+	}
+	f := newTxidFilter(maxId.Int64, bitsPerTx)
+	log.Printf("Building the txid filter (%d MiB)...", f.m/8/1024/1024)
+	n, err := f.addFrom(db)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Txid filter of %d txids built in %s.", n, time.Now().Sub(start).Round(time.Millisecond))
+	return f, nil
+}
+
+// knows is mayContain for a filter of a db written to by others: a
+// txid not in the filter may have been added since, so the new txs
+// are added before saying no.
+func (f *txidFilter) knows(db *sql.DB, hash core.Uint256) (bool, error) {
+	if f == nil || f.contains(hash) {
+		return true, nil
+	}
+	if _, err := f.addFrom(db); err != nil {
+		return false, err
+	}
+	return f.mayContain(hash), nil
+}
+
+func (f *txidFilter) reportStats() {
+	if f == nil {
+		return
+	}
+	f.RLock()
+	log.Printf("Txid filter txids: %d rejected lookups: %d fill: %.02f%%",
+		f.n, f.reject, f.fill()*100)
+	f.RUnlock()
+}
+
+// fill is the expected fraction of bits set. NB: locking is up to
+// caller!
+func (f *txidFilter) fill() float64 {
+	return 1 - math.Exp(-float64(f.k)*float64(f.n)/float64(f.m))
+}
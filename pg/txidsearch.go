@@ -0,0 +1,113 @@
+package pg
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Search by the beginning of a txid, as typed into an explorer. The
+// txids are stored in the internal byte order, so the first bytes as
+// displayed are the last ones stored, which no index on txid helps
+// with. txid_prefix() is the first 8 bytes as displayed as a BIGINT
+// (like addr_prefix() for addresses), and with the optional
+// txs_txid_prefix_idx expression index on it (WriterConfig
+// TxidPrefixIndex, or CreateTxidPrefixIndex on an existing database) a
+// prefix is a range of it. Without the index a search is a full scan
+// of txs.
+
+const MinTxidPrefix = 4 // hex digits
+
+func createTxidPrefixFunction(db *sql.DB) error {
+	_, err := db.Exec(`
+  -- The first 8 bytes of a txid in display order as a BIGINT
+  CREATE OR REPLACE FUNCTION txid_prefix(_txid BYTEA) RETURNS BIGINT AS $$
+    SELECT (GET_BYTE(_txid, 31)::BIGINT << 56) | (GET_BYTE(_txid, 30)::BIGINT << 48)
+         | (GET_BYTE(_txid, 29)::BIGINT << 40) | (GET_BYTE(_txid, 28)::BIGINT << 32)
+         | (GET_BYTE(_txid, 27)::BIGINT << 24) | (GET_BYTE(_txid, 26)::BIGINT << 16)
+         | (GET_BYTE(_txid, 25)::BIGINT << 8)  |  GET_BYTE(_txid, 24)::BIGINT
+  $$ LANGUAGE sql IMMUTABLE STRICT;
+`)
+	return err
+}
+
+func createTxidPrefixIndex(db *sql.DB, verbose bool) error {
+	if err := createTxidPrefixFunction(db); err != nil {
+		return err
+	}
+	stmt := "CREATE INDEX IF NOT EXISTS txs_txid_prefix_idx ON txs(txid_prefix(txid));"
+	if verbose {
+		log.Printf("  Starting %s", stmt)
+	}
+	start := time.Now()
+	if _, err := db.Exec(stmt); err != nil {
+		return err
+	}
+	if verbose {
+		log.Printf("  ...done in %s.", time.Now().Sub(start).Round(time.Millisecond))
+	}
+	return nil
+}
+
+// CreateTxidPrefixIndex adds the txid prefix index to an existing
+// database. This takes a while on a full chain.
+func CreateTxidPrefixIndex(connstr string) error {
+	db, err := sql.Open("postgres", connstr)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return createTxidPrefixIndex(db, true)
+}
+
+// txidPrefixRange returns the range of txid_prefix() of the txids
+// beginning with prefix (hex, as displayed).
+func txidPrefixRange(prefix string) (int64, int64, error) {
+	if len(prefix) < MinTxidPrefix || len(prefix) > 64 {
+		return 0, 0, fmt.Errorf("Invalid txid prefix: %q (must be %d to 64 hex digits)", prefix, MinTxidPrefix)
+	}
+	p := prefix
+	if len(p) > 16 {
+		p = p[:16]
+	}
+	lo, err := strconv.ParseUint(p+strings.Repeat("0", 16-len(p)), 16, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("Invalid txid prefix: %q", prefix)
+	}
+	hi, _ := strconv.ParseUint(p+strings.Repeat("f", 16-len(p)), 16, 64)
+	// Same sign, the first digit is in the prefix
+	return int64(lo), int64(hi), nil
+}
+
+// SearchTxid returns up to limit txs whose txid (hex, as displayed)
+// begins with prefix, with the height of the block of the main chain
+// they are in.
+func (e *Explorer) SearchTxid(prefix string, limit int) ([]string, error) {
+	prefix = strings.ToLower(prefix)
+	lo, hi, err := txidPrefixRange(prefix)
+	if err != nil {
+		return nil, err
+	}
+	stmt := `
+SELECT to_json(s.*) FROM (
+SELECT hash_hex(t.txid) AS txid
+      ,(SELECT MIN(b.height)
+          FROM block_txs bt
+          JOIN blocks b ON b.id = bt.block_id
+         WHERE bt.tx_id = t.id AND NOT b.orphan) AS height
+  FROM txs t
+ WHERE txid_prefix(t.txid) BETWEEN $1 AND $2
+   AND hash_hex(t.txid) LIKE $3
+ ORDER BY txid_prefix(t.txid)
+ LIMIT $4
+) s`
+
+	var txs []string
+	if err := e.readSelect(&txs, stmt, lo, hi, prefix+"%", limit); err != nil {
+		return nil, err
+	}
+	return txs, nil
+}
@@ -0,0 +1,72 @@
+package pg
+
+import (
+	"database/sql"
+	"sort"
+	"sync"
+)
+
+// The tables of the parsers and hooks with a tx_id column, i.e. rows
+// which belong to a transaction. When an interrupted import is resumed
+// their rows past the high-water mark are deleted with those of txs
+// (see progress.go), and retention deletes their rows along with the
+// transactions it retires (see retention.go), except for the tables of
+// state, rows which are not about the transaction itself but which it
+// happened to create, e.g. the runes it etched, which a parser loads
+// in Start() and needs for as long as the import goes on.
+//
+// The tables of the built in parsers are here, a third party parser
+// or hook with such tables calls RegisterTxTable from init(). Column
+// plugins (see columns.go) have no tables of their own, their columns
+// go with the txs and txouts rows.
+
+var (
+	txTablesMu sync.Mutex
+	txTables   = map[string]bool{ // name: state
+		"inscriptions":     false,
+		"op_returns":       false,
+		"multisig":         false,
+		"tx_labels":        false,
+		"revealed_scripts": false,
+		"runes":            true,
+		"rune_balances":    false,
+		"rune_events":      false,
+		"brc20_tokens":     true,
+		"brc20_ops":        false,
+	}
+)
+
+// RegisterTxTable adds a table with a tx_id column, state if its rows
+// must outlive their transaction (see above).
+func RegisterTxTable(name string, state bool) {
+	txTablesMu.Lock()
+	defer txTablesMu.Unlock()
+	txTables[name] = state
+}
+
+// existingTxTables returns the sorted names of the registered tables
+// present in the database, without the tables of state unless
+// withState.
+func existingTxTables(db *sql.DB, withState bool) ([]string, error) {
+	txTablesMu.Lock()
+	var names []string
+	for name, state := range txTables {
+		if withState || !state {
+			names = append(names, name)
+		}
+	}
+	txTablesMu.Unlock()
+	sort.Strings(names)
+
+	var result []string
+	for _, name := range names {
+		var exists bool
+		if err := db.QueryRow("SELECT to_regclass(current_schema() || '.' || $1) IS NOT NULL", name).Scan(&exists); err != nil {
+			return nil, err
+		}
+		if exists {
+			result = append(result, name)
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,100 @@
+package pg
+
+import (
+	"math/big"
+	"time"
+
+	"github.com/blkchain/blkchain/core"
+)
+
+// These types reflect the database structure.
+
+type BlockRec struct {
+	*core.Block
+
+	Id     int
+	Height int
+	Hash   core.Uint256
+	Orphan bool
+
+	// Time it took to read and decode the block, for the import
+	// metrics (see profile.go), optional.
+	ParseTime time.Duration
+
+	headerOnly bool     // only the header is written, sizes are 0
+	backfill   bool     // header-only block row exists, write the txs
+	prevId     *int     // prev_block_id, see prevblock.go
+	chainwork  *big.Int // see prevblock.go
+
+	size     int
+	baseSize int
+	weight   int
+	virtSize int
+}
+
+type txRec struct {
+	id      int64
+	blockId int
+	n       int // position within block
+	tx      *core.Tx
+	hash    core.Uint256
+
+	size     int
+	baseSize int
+	weight   int
+	virtSize int
+
+	sync chan bool
+	hw   *highWater // with a commit signal
+	dupe bool       // already seen
+
+	extra []interface{} // the values of the column plugins
+
+	metrics *blockMetrics // when profiling
+}
+
+type txInRec struct {
+	txId    int64
+	n       int
+	txIn    *core.TxIn
+	idCache *txIdCache
+	txids   *txidFilter // nil = none
+	sync    chan bool
+	hw      *highWater // with a commit signal
+
+	prevOutTxId *int64        // already resolved if idCache is nil (sharding)
+	value       *int64        // of the output spent, if known (see inputvalues.go)
+	metrics     *blockMetrics // when profiling
+}
+
+type txOutRec struct {
+	txId  int64
+	n     int
+	txOut *core.TxOut
+	hash  core.Uint256
+	sync  chan bool
+	hw    *highWater // with a commit signal
+
+	scriptId  int64         // with DedupScripts
+	sameBlock bool          // spent in the same block (see sameblock.go)
+	extra     []interface{} // the values of the column plugins
+	metrics   *blockMetrics // when profiling
+}
+
+type scriptRec struct {
+	id     int64
+	script []byte
+	sync   chan bool
+}
+
+type inscriptionRec struct {
+	txId int64
+	ins  *core.Inscription
+	sync chan bool
+}
+
+// type BlockInfo struct {
+// 	*core.Block
+// 	Height int
+// 	Sync   chan bool // `json:"-"` // ZZZ
+// }
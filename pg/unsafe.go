@@ -0,0 +1,122 @@
+package pg
+
+import (
+	"database/sql"
+	"log"
+)
+
+// Fast-unsafe mode trades durability for speed during a bulk load:
+//
+//  - synchronous_commit=off is set for the import sessions, and
+//    wal_compression=on if the role may set it (before PG15 only a
+//    superuser can), otherwise it is left as is.
+//  - On catch up, the txins trigger which marks outputs spent is
+//    disabled, instead the spent flags are set in one UPDATE at the
+//    end.
+//
+// The last tx id before the trigger was disabled is recorded in the
+// _fast_unsafe table, so that if the import crashes, the next run
+// (in any mode) can still fix the spent flags and re-enable the
+// trigger. To do it manually:
+//
+//   UPDATE txouts o SET spent = true FROM txins i, _fast_unsafe f
+//    WHERE i.tx_id > f.tx_id AND i.prevout_tx_id = o.tx_id AND i.prevout_n = o.n;
+//   ALTER TABLE txins ENABLE TRIGGER txins_after_trigger;
+//   DROP TABLE _fast_unsafe;
+
+var fastUnsafeSettings = map[string]string{
+	"synchronous_commit": "off",
+}
+
+// walCompression returns the db reopened (with open) with
+// wal_compression=on and its connect string, or the same db if the
+// setting is not permitted, which would fail every connection if it
+// were a startup parameter.
+func walCompression(db *sql.DB, connstr string, open func(string) (*sql.DB, error)) (*sql.DB, string, error) {
+	txn, err := db.Begin()
+	if err != nil {
+		return nil, "", err
+	}
+	_, err = txn.Exec("SET LOCAL wal_compression = on")
+	txn.Rollback()
+	if err != nil {
+		log.Printf("Fast-unsafe mode: not setting wal_compression: %v", err)
+		return db, connstr, nil
+	}
+
+	connstr = withSessionSettings(connstr, map[string]string{"wal_compression": "on"})
+	newDb, err := open(connstr)
+	if err != nil {
+		return nil, "", err
+	}
+	db.Close()
+	log.Printf("Fast-unsafe mode: wal_compression=on.")
+	return newDb, connstr, nil
+}
+
+func disableTriggers(db *sql.DB) error {
+	txn, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	// If the table already exists, a previous run did not finish and
+	// the older tx id must be kept.
+	if _, err := txn.Exec(`
+        CREATE TABLE IF NOT EXISTS _fast_unsafe AS
+          SELECT COALESCE(MAX(id), 0) AS tx_id FROM txs;
+        DO $$
+        BEGIN
+          IF EXISTS (SELECT 1 FROM pg_trigger WHERE tgname = 'txins_after_trigger' AND tgrelid = to_regclass(current_schema() || '.txins')) THEN
+            ALTER TABLE txins DISABLE TRIGGER txins_after_trigger;
+          END IF;
+        END
+        $$;
+`); err != nil {
+		txn.Rollback()
+		return err
+	}
+	return txn.Commit()
+}
+
+func haveDisabledTriggers(db *sql.DB) (bool, error) {
+	var exists bool
+	err := db.QueryRow("SELECT to_regclass(current_schema() || '._fast_unsafe') IS NOT NULL").Scan(&exists)
+	return exists, err
+}
+
+func restoreTriggers(db *sql.DB) error {
+	if exists, err := haveDisabledTriggers(db); err != nil || !exists {
+		return err
+	}
+
+	log.Printf("Fast-unsafe: marking outputs spent by the new inputs and re-enabling the txins trigger...")
+	txn, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if _, err := txn.Exec(`
+        UPDATE txouts o
+           SET spent = true
+          FROM txins i, _fast_unsafe f
+         WHERE i.tx_id > f.tx_id
+           AND i.prevout_tx_id = o.tx_id
+           AND i.prevout_n = o.n
+           AND NOT o.spent;
+        DO $$
+        BEGIN
+          IF EXISTS (SELECT 1 FROM pg_trigger WHERE tgname = 'txins_after_trigger' AND tgrelid = to_regclass(current_schema() || '.txins')) THEN
+            ALTER TABLE txins ENABLE TRIGGER txins_after_trigger;
+          END IF;
+        END
+        $$;
+        DROP TABLE _fast_unsafe;
+`); err != nil {
+		txn.Rollback()
+		return err
+	}
+	if err := txn.Commit(); err != nil {
+		return err
+	}
+	log.Printf("Fast-unsafe: txins trigger restored.")
+	return nil
+}
@@ -0,0 +1,300 @@
+package pg
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// Databases created by older versions of the import lack some
+// columns. Each upgrade is detected by a missing column, and applied
+// by altering the table in place and backfilling the values that can
+// be derived from what is already in the database. Upgrades are
+// applied in order, later ones may depend on earlier ones.
+//
+// NB: There is no utxos table in this schema (the spent flag is on
+// txouts), so there is nothing to upgrade there.
+
+type upgrade struct {
+	table  string
+	column string // the missing column which signals this upgrade is needed
+	desc   string
+	sql    string
+}
+
+var upgrades = []*upgrade{
+	{"txins", "witness", "add txins witness column",
+		// Witness data cannot be recreated from the database, only
+		// by re-importing the blocks.
+		`ALTER TABLE txins ADD COLUMN witness BYTEA`,
+	},
+	{"blocks", "orphan", "add blocks orphan column",
+		`ALTER TABLE blocks ADD COLUMN orphan BOOLEAN NOT NULL DEFAULT false`,
+	},
+	{"txs", "size", "add and compute txs size, base_size, weight and virt_size",
+		`
+  ALTER TABLE txs
+    ADD COLUMN size INT,
+    ADD COLUMN base_size INT,
+    ADD COLUMN weight INT,
+    ADD COLUMN virt_size INT;
+
+  -- See Tx.Size() and friends, the sigop weight is an approximation of
+  -- one sigop per input, same as Tx.VirtualSize()
+  UPDATE txs t
+     SET size = s.size, base_size = s.base_size
+        ,weight = s.base_size*3 + s.size
+        ,virt_size = (GREATEST(s.base_size*3 + s.size, s.n_ins*80) + 3) / 4
+    FROM (
+      SELECT t.id, i.n_ins
+            ,4 + i.sz + o.sz + 4 AS base_size
+            ,4 + i.sz + o.sz + 4 + CASE WHEN i.segwit THEN 2 + i.wsz ELSE 0 END AS size
+        FROM txs t
+        JOIN LATERAL (
+          SELECT COUNT(1) AS n_ins
+                ,compact_size_size(COUNT(1))
+                   + COALESCE(SUM(36 + compact_size_size(LENGTH(scriptsig)) + LENGTH(scriptsig) + 4), 0) AS sz
+                ,BOOL_OR(witness IS NOT NULL) AS segwit
+                ,COALESCE(SUM(COALESCE(LENGTH(witness), 1)), 0) AS wsz
+            FROM txins WHERE tx_id = t.id
+        ) i ON true
+        JOIN LATERAL (
+          SELECT compact_size_size(COUNT(1))
+                   + COALESCE(SUM(8 + compact_size_size(LENGTH(scriptpubkey)) + LENGTH(scriptpubkey)), 0) AS sz
+            FROM txouts WHERE tx_id = t.id
+        ) o ON true
+    ) s
+   WHERE t.id = s.id;
+
+  ALTER TABLE txs
+    ALTER COLUMN size SET NOT NULL,
+    ALTER COLUMN base_size SET NOT NULL,
+    ALTER COLUMN weight SET NOT NULL,
+    ALTER COLUMN virt_size SET NOT NULL;
+`,
+	},
+	{"blocks", "size", "add and compute blocks size, base_size, weight and virt_size",
+		`
+  ALTER TABLE blocks
+    ADD COLUMN size INT,
+    ADD COLUMN base_size INT,
+    ADD COLUMN weight INT,
+    ADD COLUMN virt_size INT;
+
+  UPDATE blocks b
+     SET size = 80 + compact_size_size(s.cnt) + s.size
+        ,base_size = 80 + compact_size_size(s.cnt) + s.base_size
+        ,weight = (80 + compact_size_size(s.cnt) + s.base_size)*3 + 80 + compact_size_size(s.cnt) + s.size
+        ,virt_size = 80 + compact_size_size(s.cnt) + s.virt_size
+    FROM (
+      SELECT bt.block_id, COUNT(1) AS cnt
+            ,SUM(t.size) AS size, SUM(t.base_size) AS base_size, SUM(t.virt_size) AS virt_size
+        FROM block_txs bt
+        JOIN txs t ON t.id = bt.tx_id
+       GROUP BY bt.block_id
+    ) s
+   WHERE b.id = s.block_id;
+
+  -- Header-only blocks (see HeadersOnly) have no txs
+  UPDATE blocks SET size = 0, base_size = 0, weight = 0, virt_size = 0 WHERE size IS NULL;
+
+  ALTER TABLE blocks
+    ALTER COLUMN size SET NOT NULL,
+    ALTER COLUMN base_size SET NOT NULL,
+    ALTER COLUMN weight SET NOT NULL,
+    ALTER COLUMN virt_size SET NOT NULL;
+`,
+	},
+	{"txs", "n_inputs", "add and compute txs n_inputs and n_outputs",
+		`
+  ALTER TABLE txs
+    ADD COLUMN n_inputs INT,
+    ADD COLUMN n_outputs INT;
+
+  UPDATE txs t
+     SET n_inputs = (SELECT COUNT(1) FROM txins WHERE tx_id = t.id)
+        ,n_outputs = (SELECT COUNT(1) FROM txouts WHERE tx_id = t.id);
+
+  ALTER TABLE txs
+    ALTER COLUMN n_inputs SET NOT NULL,
+    ALTER COLUMN n_outputs SET NOT NULL;
+`,
+	},
+	{"blocks", "prev_block_id", "add and resolve blocks prev_block_id",
+		`
+  ALTER TABLE blocks ADD COLUMN prev_block_id INT;
+
+  UPDATE blocks b
+     SET prev_block_id = p.id
+    FROM blocks p
+   WHERE p.hash = b.prevhash;
+
+  CREATE INDEX IF NOT EXISTS blocks_prev_block_id_idx ON blocks(prev_block_id);
+
+  -- Unless the database was imported without constraints
+  DO $$
+  BEGIN
+    IF EXISTS (SELECT constraint_name FROM information_schema.constraint_column_usage
+                WHERE table_schema = current_schema() AND table_name = 'blocks' AND constraint_name = 'block_txs_block_id_fkey') THEN
+      ALTER TABLE blocks ADD CONSTRAINT blocks_prev_block_id_fkey FOREIGN KEY (prev_block_id) REFERENCES blocks(id);
+    END IF;
+  END
+  $$;
+`,
+	},
+	{"blocks", "chainwork", "add and compute blocks chainwork",
+		// From the genesis block down the prev_block_ids, so this
+		// needs the above.
+		`
+  ALTER TABLE blocks ADD COLUMN chainwork NUMERIC;
+
+  WITH RECURSIVE w(id, chainwork) AS (
+    SELECT id, block_work(bits)
+      FROM blocks
+     WHERE prevhash = DECODE(REPEAT('00', 32), 'hex')
+    UNION ALL
+    SELECT b.id, w.chainwork + block_work(b.bits)
+      FROM w
+      JOIN blocks b ON b.prev_block_id = w.id
+  )
+  UPDATE blocks b
+     SET chainwork = w.chainwork
+    FROM w
+   WHERE b.id = w.id;
+
+  CREATE INDEX IF NOT EXISTS blocks_chainwork_idx ON blocks(chainwork DESC NULLS LAST);
+`,
+	},
+	{"txouts", "spent_same_block", "add and compute txouts spent_same_block",
+		// See sameblock.go, the outputs spent by a tx of a block
+		// which also has the tx of the output.
+		`
+  ALTER TABLE txouts ADD COLUMN spent_same_block BOOLEAN NOT NULL DEFAULT false;
+
+  UPDATE txouts o
+     SET spent_same_block = true
+    FROM txins i, block_txs bo, block_txs bi
+   WHERE o.spent
+     AND i.prevout_tx_id = o.tx_id
+     AND i.prevout_n = o.n
+     AND bo.tx_id = o.tx_id
+     AND bi.tx_id = i.tx_id
+     AND bi.block_id = bo.block_id;
+`,
+	},
+	{"blocks_v", "stripped_size", "add blocks_v stripped_size and fullness",
+		// See views.go, the sizes themselves are already there.
+		viewFunctionsSQL + blocksViewSQL,
+	},
+}
+
+func columnExists(db *sql.DB, table, column string) (bool, error) {
+	var exists bool
+	err := db.QueryRow(`
+SELECT EXISTS (SELECT 1 FROM information_schema.columns
+                WHERE table_schema = current_schema() AND table_name = $1 AND column_name = $2)`,
+		table, column).Scan(&exists)
+	return exists, err
+}
+
+func pendingUpgrades(db *sql.DB) ([]*upgrade, error) {
+	var result []*upgrade
+	for _, u := range upgrades {
+		exists, err := columnExists(db, u.table, u.column)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			result = append(result, u)
+		}
+	}
+	return result, nil
+}
+
+// Returns an error listing the needed upgrades, if any.
+func checkUpgrades(db *sql.DB) error {
+	pending, err := pendingUpgrades(db)
+	if err != nil {
+		return err
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+	descs := make([]string, 0, len(pending))
+	for _, u := range pending {
+		descs = append(descs, u.desc)
+	}
+	return fmt.Errorf("Database was created by an older version and needs an upgrade (%s), run cmd/upgrade.",
+		strings.Join(descs, "; "))
+}
+
+func createUpgradeFunctions(db *sql.DB) error {
+	_, err := db.Exec(`
+  -- Same as compactSizeSize() in core/binary.go
+  CREATE OR REPLACE FUNCTION compact_size_size(n BIGINT) RETURNS INT AS $$
+    SELECT CASE WHEN n < 253 THEN 1 WHEN n < 65535 THEN 3 WHEN n < 4294967295 THEN 5 ELSE 9 END
+  $$ LANGUAGE sql IMMUTABLE STRICT;
+
+  -- Same as core.BlockWork(), 2^256 / (target+1), the target
+  -- decoded from the compact bits as in CompactToBig()
+  CREATE OR REPLACE FUNCTION block_work(_bits INT) RETURNS NUMERIC AS $$
+    SELECT CASE WHEN negative OR target <= 0 THEN 0
+                ELSE DIV(2::NUMERIC ^ 256, target + 1) END
+      FROM (SELECT (_bits & 8388608) <> 0 AS negative
+                  ,CASE WHEN e <= 3 THEN DIV(m, 256::NUMERIC ^ (3 - e))
+                        ELSE m * 256::NUMERIC ^ (e - 3) END AS target
+              FROM (SELECT (_bits >> 24) & 255 AS e, (_bits & 8388607)::NUMERIC AS m) x) t
+  $$ LANGUAGE sql IMMUTABLE STRICT;
+`)
+	return err
+}
+
+// UpgradeDatabase applies all the pending upgrades, each in its own
+// transaction. With dryRun it only logs what would be done. This can
+// take a very long time on a large database.
+func UpgradeDatabase(connstr string, dryRun bool) error {
+	db, err := sql.Open("postgres", connstr)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	pending, err := pendingUpgrades(db)
+	if err != nil {
+		return err
+	}
+	if len(pending) == 0 {
+		log.Printf("Database is up to date.")
+		return nil
+	}
+
+	if !dryRun {
+		if err := createUpgradeFunctions(db); err != nil {
+			return err
+		}
+	}
+
+	for _, u := range pending {
+		if dryRun {
+			log.Printf("Needed: %s.", u.desc)
+			continue
+		}
+		log.Printf("Upgrade: %s...", u.desc)
+		start := time.Now()
+		txn, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := txn.Exec(u.sql); err != nil {
+			txn.Rollback()
+			return fmt.Errorf("%s: %v", u.desc, err)
+		}
+		if err := txn.Commit(); err != nil {
+			return err
+		}
+		log.Printf("...done in %s.", time.Now().Sub(start).Round(time.Millisecond))
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package pg
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// Upsert mode. After the first import the blocks are written one at a
+// time, each in its own small transactions, where COPY has little
+// advantage over INSERT, and fails as a whole on the first row which
+// is there already, e.g. when blocks around the tip are replayed
+// after a crash or reprocessed in a reorg. With WriterConfig.Upsert
+// the writers INSERT ... ON CONFLICT DO NOTHING instead, so the rows
+// which exist are left as they are and the rest are written. The first
+// import always uses COPY, there are no unique indexes to conflict on
+// then anyway.
+//
+// Without a unique index there is no conflict and the rows would be
+// written again, which is the case of txins and block_txs with
+// -index-strategy brin (see brin.go) and of every table with
+// -no-constraints, upsert mode is refused then.
+
+// upsertStmt is the INSERT used instead of pq.CopyIn(table, cols...).
+func upsertStmt(table string, cols []string) string {
+	names := make([]string, len(cols))
+	params := make([]string, len(cols))
+	for i, c := range cols {
+		names[i] = pq.QuoteIdentifier(c)
+		params[i] = fmt.Sprintf("$%d", i+1)
+	}
+	return fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s) ON CONFLICT DO NOTHING",
+		pq.QuoteIdentifier(table), strings.Join(names, ", "), strings.Join(params, ", "))
+}
+
+// checkUpsert returns an error if a table the writers insert into has
+// no unique index to conflict on.
+func checkUpsert(db *sql.DB) error {
+	rows, err := db.Query(`
+    SELECT t
+      FROM unnest($1::TEXT[]) t
+     WHERE to_regclass(current_schema() || '.' || t) IS NOT NULL
+       AND NOT EXISTS (SELECT 1 FROM pg_index WHERE indrelid = to_regclass(current_schema() || '.' || t) AND indisunique)`,
+		pq.Array([]string{"blocks", "txs", "block_txs", "txins", "txouts", "scripts", "inscriptions"}))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	var tables []string
+	for rows.Next() {
+		var t string
+		if err := rows.Scan(&t); err != nil {
+			return err
+		}
+		tables = append(tables, t)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(tables) > 0 {
+		return fmt.Errorf("Upsert mode needs unique indexes to conflict on, there are none on %s (-index-strategy brin or -no-constraints?).", strings.Join(tables, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package pg
+
+import (
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/blkchain/blkchain/core"
+	"github.com/lib/pq"
+)
+
+// UTXO snapshots record the distribution of the UTXO set by age (the
+// data behind "HODL waves") and by value every so many blocks while
+// following the tip. The spent flags only describe the present, so
+// these cannot be reconstructed for past heights without replaying
+// the chain, they have to be taken as the chain goes.
+//
+// Each snapshot is a set of rows, one per band: kind is 'age' (band_min
+// in blocks) or 'value' (band_min in satoshis), the band extends to the
+// band_min of the next row. An 'immature' row (band_min 0) has the
+// coinbase outputs which cannot be spent yet (see maturity.go), they
+// are in the bands too.
+
+var (
+	// 1 day, 1 week, 1, 3, 6 months, 1, 2, 3, 5, 7, 10 years
+	utxoAgeBands = []int64{0, 144, 1008, 4320, 12960, 25920, 52560, 105120, 157680, 262800, 367920, 525600}
+	// 0.001, 0.01, 0.1, 1, 10, 100, 1000 BTC
+	utxoValueBands = []int64{0, 1e5, 1e6, 1e7, 1e8, 1e9, 1e10, 1e11}
+)
+
+func createUtxoSnapshotsTable(db *sql.DB) error {
+	_, err := db.Exec(`
+  CREATE TABLE IF NOT EXISTS utxo_snapshots (
+   height         INT NOT NULL
+  ,time           INT NOT NULL -- of the block at height
+  ,kind           TEXT NOT NULL -- 'age' or 'value'
+  ,band_min       BIGINT NOT NULL -- blocks or satoshis
+  ,count          BIGINT NOT NULL
+  ,value          BIGINT NOT NULL
+  ,PRIMARY KEY (height, kind, band_min)
+  );
+`)
+	return err
+}
+
+// UpdateUtxoSnapshots takes a snapshot if there have been at least
+// UtxoSnapshotInterval blocks since the last one. It should be called
+// after SetOrphans().
+func (w *PGWriter) UpdateUtxoSnapshots() error {
+	if w.db == nil || w.cfg.UtxoSnapshotInterval <= 0 {
+		return nil
+	}
+	return updateUtxoSnapshots(w.db, w.cfg.UtxoSnapshotInterval)
+}
+
+func updateUtxoSnapshots(db *sql.DB, interval int) error {
+	if err := createUtxoSnapshotsTable(db); err != nil {
+		return err
+	}
+
+	var last, tip int
+	if err := db.QueryRow("SELECT COALESCE(MAX(height), -1) FROM utxo_snapshots").Scan(&last); err != nil {
+		return err
+	}
+	if err := db.QueryRow("SELECT COALESCE(MAX(height), -1) FROM blocks WHERE NOT orphan").Scan(&tip); err != nil {
+		return err
+	}
+	if tip < 0 || (last >= 0 && tip < last+interval) {
+		return nil
+	}
+
+	log.Printf("Taking UTXO snapshot at height %d...", tip)
+	start := time.Now()
+	txn, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if _, err := txn.Exec(`
+INSERT INTO utxo_snapshots (height, time, kind, band_min, count, value)
+SELECT t.height, t.time,
+       CASE WHEN age_band IS NOT NULL THEN 'age' ELSE 'value' END,
+       COALESCE(($2::BIGINT[])[age_band], ($3::BIGINT[])[value_band]),
+       COUNT(1), SUM(u.value)
+  FROM (SELECT height, time FROM blocks WHERE height = $1 AND NOT orphan LIMIT 1) t
+  JOIN LATERAL (
+    SELECT o.value
+          ,width_bucket(($1 - b.height)::BIGINT, $2::BIGINT[]) AS age_band
+          ,width_bucket(o.value, $3::BIGINT[]) AS value_band
+      FROM txouts o
+      JOIN block_txs bt ON bt.tx_id = o.tx_id
+      JOIN blocks b ON b.id = bt.block_id
+     WHERE NOT o.spent
+       AND NOT b.orphan
+       AND b.height <= $1
+  ) u ON true
+ GROUP BY t.height, t.time, GROUPING SETS ((age_band), (value_band))`,
+		tip, pq.Array(utxoAgeBands), pq.Array(utxoValueBands)); err != nil {
+		txn.Rollback()
+		return err
+	}
+	if _, err := txn.Exec(`
+INSERT INTO utxo_snapshots (height, time, kind, band_min, count, value)
+SELECT t.height, t.time, 'immature', 0, COUNT(o.tx_id), COALESCE(SUM(o.value), 0)
+  FROM (SELECT height, time FROM blocks WHERE height = $1 AND NOT orphan LIMIT 1) t
+  LEFT JOIN blocks b ON b.height <= $1 AND $1 - b.height + 1 < $2 AND NOT b.orphan
+  LEFT JOIN block_txs bt ON bt.block_id = b.id AND bt.n = 0
+  LEFT JOIN txouts o ON o.tx_id = bt.tx_id AND NOT o.spent
+ GROUP BY t.height, t.time`, tip, core.CoinbaseMaturity); err != nil {
+		txn.Rollback()
+		return err
+	}
+	if err := txn.Commit(); err != nil {
+		return err
+	}
+	log.Printf("UTXO snapshot done in %s.", time.Now().Sub(start).Round(time.Millisecond))
+	return nil
+}
@@ -0,0 +1,140 @@
+package pg
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Logical validation of the database, i.e. what the foreign keys and
+// primary keys would guarantee, plus a few things they could not. It
+// is the alternative to the constraints for databases imported with
+// WriterConfig.NoConstraints, but can be run on any database.
+//
+// Every check counts the offending rows with tx_id (or block_id) at
+// or above the given starting point, so that periodic runs can limit
+// themselves to the recently imported data.
+
+type check struct {
+	name    string
+	desc    string
+	byBlock bool   // $1 is the minimum block_id rather than tx_id
+	sql     string // returns the count of offending rows
+}
+
+var checks = []*check{
+	{"block_txs_block_id", "block_txs rows referencing a missing block", false, `
+SELECT COUNT(1) FROM block_txs bt
+ WHERE bt.tx_id >= $1
+   AND NOT EXISTS (SELECT 1 FROM blocks b WHERE b.id = bt.block_id)`},
+	{"block_txs_tx_id", "block_txs rows referencing a missing tx", false, `
+SELECT COUNT(1) FROM block_txs bt
+ WHERE bt.tx_id >= $1
+   AND NOT EXISTS (SELECT 1 FROM txs t WHERE t.id = bt.tx_id)`},
+	{"block_txs_dupes", "duplicate block_txs (block_id, n)", false, `
+SELECT COUNT(1) FROM (
+  SELECT block_id, n FROM block_txs WHERE tx_id >= $1
+   GROUP BY block_id, n HAVING COUNT(1) > 1) d`},
+	{"txs_block", "txs not in any block", false, `
+SELECT COUNT(1) FROM txs t
+ WHERE t.id >= $1
+   AND NOT EXISTS (SELECT 1 FROM block_txs bt WHERE bt.tx_id = t.id)`},
+	{"txins_tx_id", "txins referencing a missing tx", false, `
+SELECT COUNT(1) FROM txins i
+ WHERE i.tx_id >= $1
+   AND NOT EXISTS (SELECT 1 FROM txs t WHERE t.id = i.tx_id)`},
+	{"txins_dupes", "duplicate txins (tx_id, n)", false, `
+SELECT COUNT(1) FROM (
+  SELECT tx_id, n FROM txins WHERE tx_id >= $1
+   GROUP BY tx_id, n HAVING COUNT(1) > 1) d`},
+	{"txins_prevout", "txins spending a missing txout", false, `
+SELECT COUNT(1) FROM txins i
+ WHERE i.tx_id >= $1
+   AND i.prevout_tx_id IS NOT NULL
+   AND NOT EXISTS (SELECT 1 FROM txouts o WHERE o.tx_id = i.prevout_tx_id AND o.n = i.prevout_n)`},
+	{"txouts_tx_id", "txouts referencing a missing tx", false, `
+SELECT COUNT(1) FROM txouts o
+ WHERE o.tx_id >= $1
+   AND NOT EXISTS (SELECT 1 FROM txs t WHERE t.id = o.tx_id)`},
+	{"txouts_dupes", "duplicate txouts (tx_id, n)", false, `
+SELECT COUNT(1) FROM (
+  SELECT tx_id, n FROM txouts WHERE tx_id >= $1
+   GROUP BY tx_id, n HAVING COUNT(1) > 1) d`},
+	{"txouts_spent", "txouts spent by a txin but not marked spent", false, `
+SELECT COUNT(1) FROM txins i
+  JOIN txouts o ON o.tx_id = i.prevout_tx_id AND o.n = i.prevout_n
+ WHERE i.tx_id >= $1
+   AND NOT o.spent`},
+	{"blocks_prevhash", "blocks whose previous block is missing", true, `
+SELECT COUNT(1) FROM blocks b
+ WHERE b.id >= $1 AND b.height > 0
+   AND NOT EXISTS (SELECT 1 FROM blocks p WHERE p.hash = b.prevhash)`},
+	{"blocks_prev_block_id", "blocks whose prev_block_id is not that of the prevhash block", true, `
+SELECT COUNT(1) FROM blocks b
+  LEFT JOIN blocks p ON p.id = b.prev_block_id
+ WHERE b.id >= $1
+   AND p.hash IS DISTINCT FROM (SELECT hash FROM blocks WHERE hash = b.prevhash)`},
+}
+
+type CheckResult struct {
+	Name     string
+	Desc     string
+	Count    int64
+	Duration time.Duration
+}
+
+// Returns the smallest tx_id and block_id at or above the given
+// height, 0 means the whole database.
+func verifyStart(db *sql.DB, height int) (int64, int, error) {
+	if height <= 0 {
+		return 0, 0, nil
+	}
+	var (
+		txId    sql.NullInt64
+		blockId sql.NullInt64
+	)
+	if err := db.QueryRow(`
+SELECT MIN(bt.tx_id), MIN(b.id)
+  FROM blocks b
+  LEFT JOIN block_txs bt ON bt.block_id = b.id
+ WHERE b.height >= $1`, height).Scan(&txId, &blockId); err != nil {
+		return 0, 0, err
+	}
+	if !blockId.Valid {
+		return 0, 0, fmt.Errorf("No blocks at or above height %d.", height)
+	}
+	return txId.Int64, int(blockId.Int64), nil
+}
+
+// VerifyDatabase runs all the checks on data at or above the given
+// height (0 = everything). It does not stop at the first problem.
+func VerifyDatabase(connstr string, sinceHeight int) ([]*CheckResult, error) {
+	db, err := sql.Open("postgres", connstr)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	minTxId, minBlockId, err := verifyStart(db, sinceHeight)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*CheckResult, 0, len(checks))
+	for _, c := range checks {
+		log.Printf("Checking %s...", c.desc)
+		start := time.Now()
+		r := &CheckResult{Name: c.name, Desc: c.desc}
+		var min interface{} = minTxId
+		if c.byBlock {
+			min = minBlockId
+		}
+		if err := db.QueryRow(c.sql, min).Scan(&r.Count); err != nil {
+			return result, err
+		}
+		r.Duration = time.Now().Sub(start)
+		result = append(result, r)
+	}
+	return result, nil
+}
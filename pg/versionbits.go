@@ -0,0 +1,169 @@
+package pg
+
+import (
+	"database/sql"
+	"sort"
+
+	"github.com/blkchain/blkchain/core"
+)
+
+// Version bits signaling is counted per retarget period from the
+// blocks table. pgBlockWorker notes the periods of the blocks passing
+// through and at every flush the counts of those periods are computed
+// again from scratch, leaving out orphans, so that blocks fed twice
+// (e.g. after resuming) are not counted twice. The blocks may not all
+// be committed at a flush, a period therefore stays pending until all
+// its blocks are there, the last flush is after the writers are done
+// and the orphans marked. Nor are the orphans marked until after the
+// blocks, a period with a stale block has one too many until then,
+// which is why SetOrphans counts again the periods of the blocks whose
+// status it changes.
+// The versionbits_periods table has the total number of blocks seen
+// per retarget period, the versionbits table has the number of blocks
+// signaling per bit.
+
+type versionBitsCounter struct {
+	periods map[int]bool
+}
+
+func newVersionBitsCounter() *versionBitsCounter {
+	return &versionBitsCounter{periods: make(map[int]bool)}
+}
+
+func (c *versionBitsCounter) add(height int) {
+	c.periods[height/core.RetargetInterval] = true
+}
+
+func (c *versionBitsCounter) flush(db *sql.DB) error {
+	if db == nil || len(c.periods) == 0 {
+		return nil
+	}
+
+	txn, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	periods := make([]int, 0, len(c.periods))
+	for p, _ := range c.periods {
+		periods = append(periods, p)
+	}
+	sort.Ints(periods)
+
+	var complete []int
+	for _, period := range periods {
+		blocks, err := countPeriod(txn, period)
+		if err != nil {
+			txn.Rollback()
+			return err
+		}
+		if blocks == core.RetargetInterval {
+			complete = append(complete, period)
+		}
+	}
+
+	if err := txn.Commit(); err != nil {
+		return err
+	}
+	for _, period := range complete {
+		delete(c.periods, period)
+	}
+	return nil
+}
+
+// countPeriod replaces the counts of a period with those of its
+// blocks, returning the number of blocks.
+func countPeriod(txn *sql.Tx, period int) (int, error) {
+	from, to := period*core.RetargetInterval, (period+1)*core.RetargetInterval-1
+	if _, err := txn.Exec("DELETE FROM versionbits_periods WHERE period = $1", period); err != nil {
+		return 0, err
+	}
+	if _, err := txn.Exec("DELETE FROM versionbits WHERE period = $1", period); err != nil {
+		return 0, err
+	}
+	var blocks int
+	if err := txn.QueryRow(`
+        INSERT INTO versionbits_periods (period, blocks, start_time, end_time)
+        SELECT $1, COUNT(1), MIN(time), MAX(time)
+          FROM blocks
+         WHERE height BETWEEN $2 AND $3 AND NOT orphan
+        HAVING COUNT(1) > 0
+        RETURNING blocks`, period, from, to).Scan(&blocks); err != nil {
+		if err == sql.ErrNoRows {
+			return 0, nil
+		}
+		return 0, err
+	}
+	// The top bits of the version are 001 (0xe0000000 is negative as INT).
+	if _, err := txn.Exec(`
+        INSERT INTO versionbits (period, bit, signaling)
+        SELECT $1, bit, COUNT(1)
+          FROM blocks
+          JOIN generate_series(0, $4) AS bit ON version & (1 << bit) <> 0
+         WHERE height BETWEEN $2 AND $3 AND NOT orphan
+           AND version & $5 = $6
+         GROUP BY bit`,
+		period, from, to, core.VersionBitsNumBits-1,
+		int32(-(1<<32)+core.VersionBitsTopMask), core.VersionBitsTopBits); err != nil {
+		return 0, err
+	}
+	return blocks, nil
+}
+
+// The deployments table is (re)populated from
+// core.MainNetDeployments every time, the versionbits_signaling
+// view is what one would normally query to chart signaling.
+func createVersionBitsTables(db *sql.DB) error {
+	if _, err := db.Exec(`
+  CREATE TABLE IF NOT EXISTS versionbits_periods (
+   period       INT NOT NULL PRIMARY KEY -- height / 2016
+  ,blocks       INT NOT NULL
+  ,start_time   INT NOT NULL
+  ,end_time     INT NOT NULL
+  );
+
+  CREATE TABLE IF NOT EXISTS versionbits (
+   period       INT NOT NULL
+  ,bit          SMALLINT NOT NULL
+  ,signaling    INT NOT NULL
+  ,PRIMARY KEY (period, bit)
+  );
+
+  CREATE TABLE IF NOT EXISTS deployments (
+   name         TEXT NOT NULL PRIMARY KEY
+  ,bit          SMALLINT NOT NULL
+  ,start_time   INT NOT NULL
+  ,timeout      INT NOT NULL
+  ,threshold    INT NOT NULL
+  );
+
+  CREATE OR REPLACE VIEW versionbits_signaling AS
+    SELECT p.period
+          ,p.period * 2016 AS start_height
+          ,to_timestamp(p.start_time) AS start_time
+          ,d.name AS deployment
+          ,d.bit
+          ,COALESCE(v.signaling, 0) AS signaling
+          ,p.blocks
+          ,COALESCE(v.signaling, 0)::float / p.blocks AS ratio
+          ,COALESCE(v.signaling, 0) >= d.threshold AS threshold_reached
+      FROM versionbits_periods p
+      JOIN deployments d ON p.end_time >= d.start_time AND p.start_time < d.timeout
+      LEFT JOIN versionbits v ON v.period = p.period AND v.bit = d.bit;
+`); err != nil {
+		return err
+	}
+
+	for _, d := range core.MainNetDeployments {
+		if _, err := db.Exec(`
+            INSERT INTO deployments (name, bit, start_time, timeout, threshold)
+            VALUES ($1, $2, $3, $4, $5)
+            ON CONFLICT (name) DO UPDATE
+               SET bit = EXCLUDED.bit, start_time = EXCLUDED.start_time,
+                   timeout = EXCLUDED.timeout, threshold = EXCLUDED.threshold`,
+			d.Name, d.Bit, d.StartTime, d.Timeout, d.Threshold); err != nil {
+			return err
+		}
+	}
+	return nil
+}
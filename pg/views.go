@@ -0,0 +1,94 @@
+package pg
+
+import "database/sql"
+
+// Hashes are stored as BYTEA in the internal (little-endian) byte
+// order, which is the reverse of how txids and block hashes are
+// normally displayed. These views present the data the way block
+// explorers and Core do, which is handy for BI tools. Note that
+// filtering on a view column such as txid will not use the indexes,
+// use hex_hash() to convert the display hex to BYTEA and query the
+// tables instead.
+
+// The sizes of the blocks are computed as they are parsed (see
+// Block.Size() and friends), stripped_size is another name of
+// base_size, the size without the witnesses, and fullness is the
+// weight as a fraction of the 4M limit. Also an upgrade (with the
+// functions), for the databases created before stripped_size.
+const blocksViewSQL = `
+  CREATE OR REPLACE VIEW blocks_v AS
+    SELECT id
+          ,height
+          ,hash_hex(hash) AS hash
+          ,version
+          ,LPAD(TO_HEX(version), 8, '0') AS version_hex
+          ,hash_hex(prevhash) AS prevhash
+          ,hash_hex(merkleroot) AS merkleroot
+          ,TO_TIMESTAMP(uint32(time)) AS time
+          ,LPAD(TO_HEX(bits), 8, '0') AS bits
+          ,uint32(nonce) AS nonce
+          ,orphan
+          ,size
+          ,base_size
+          ,weight
+          ,virt_size
+          ,base_size AS stripped_size -- CREATE OR REPLACE VIEW can only add columns at the end
+          ,weight / 4000000.0 AS fullness
+      FROM blocks;
+`
+
+const viewFunctionsSQL = `
+  -- BYTEA (internal byte order) to display hex
+  CREATE OR REPLACE FUNCTION hash_hex(_hash BYTEA) RETURNS TEXT AS $$
+    SELECT COALESCE(STRING_AGG(LPAD(TO_HEX(GET_BYTE(_hash, i)), 2, '0'), '' ORDER BY i DESC), '')
+      FROM GENERATE_SERIES(0, LENGTH(_hash) - 1) i
+  $$ LANGUAGE sql IMMUTABLE STRICT;
+
+  -- Display hex to BYTEA (internal byte order)
+  CREATE OR REPLACE FUNCTION hex_hash(_hex TEXT) RETURNS BYTEA AS $$
+    SELECT DECODE(COALESCE(STRING_AGG(SUBSTR(_hex, i, 2), '' ORDER BY i DESC), ''), 'hex')
+      FROM GENERATE_SERIES(1, LENGTH(_hex), 2) i
+  $$ LANGUAGE sql IMMUTABLE STRICT;
+
+  -- Our INTs are uint32 in Bitcoin
+  CREATE OR REPLACE FUNCTION uint32(_i INT) RETURNS BIGINT AS $$
+    SELECT _i::BIGINT & 4294967295
+  $$ LANGUAGE sql IMMUTABLE STRICT;
+
+  -- The subsidy of the block at a height, as core.SubsidyAt()
+  CREATE OR REPLACE FUNCTION block_subsidy(_height INT, _interval INT DEFAULT 210000) RETURNS BIGINT AS $$
+    SELECT CASE WHEN _height < 0 OR _height / _interval >= 64 THEN 0
+                ELSE 5000000000::BIGINT >> (_height / _interval) END
+  $$ LANGUAGE sql IMMUTABLE STRICT;
+`
+
+func createViews(db *sql.DB) error {
+	_, err := db.Exec(viewFunctionsSQL + blocksViewSQL + `
+  CREATE OR REPLACE VIEW txs_v AS
+    SELECT t.id
+          ,hash_hex(t.txid) AS txid
+          ,t.version
+          ,uint32(t.locktime) AS locktime
+          ,t.size
+          ,t.base_size
+          ,t.weight
+          ,t.virt_size
+          ,b.height
+          ,hash_hex(b.hash) AS block_hash
+          ,t.n_inputs  -- CREATE OR REPLACE VIEW can only add columns at the end
+          ,t.n_outputs
+      FROM txs t
+      LEFT JOIN LATERAL (
+        SELECT b.height, b.hash
+          FROM block_txs bt
+          JOIN blocks b ON b.id = bt.block_id
+         WHERE bt.tx_id = t.id
+         ORDER BY b.orphan -- prefer the main chain
+         LIMIT 1
+      ) b ON true;
+`)
+	if err != nil {
+		return err
+	}
+	return createTxidPrefixFunction(db) // see txidsearch.go
+}
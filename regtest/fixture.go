@@ -0,0 +1,335 @@
+package regtest
+
+import (
+	"bytes"
+	"crypto/sha256"
+	_ "embed"
+	"fmt"
+	"io"
+	"math/big"
+
+	"github.com/blkchain/blkchain/core"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/ecdsa"
+	"github.com/btcsuite/btcd/chaincfg"
+)
+
+// The fixture is a small regtest chain, as blocks in the format of
+// the blk*.dat files in chain.dat, made by BuildChain (cmd/itest
+// -write-fixture). From the regtest genesis block:
+//
+//   1-101  coinbases only, paying to key A (P2PKH)
+//   102    tx1 spends the coinbase of 1 to a P2WPKH output of key B
+//          and change to A
+//   103    tx2 (segwit) spends the P2WPKH output to C, with an
+//          OP_RETURN output; tx3 spends the output of tx2 to a P2WPKH
+//          output of A in the same block
+//   104a   a branch which is orphaned
+//   105a   tx4 spends the change of tx1, with a locktime
+//   104b   the longer branch
+//   105b   with tx4 again
+//   106b
+//
+// The blocks up to 103 make the first import (FirstImportHeight), the
+// others are imported afterwards, in this order, as if they came from
+// a node.
+
+// The height of the last block of the first import.
+const FirstImportHeight = 103
+
+const (
+	subsidy = 50 * 100_000_000
+	fee     = 10_000
+	bits    = 0x207fffff
+)
+
+//go:embed chain.dat
+var chainDat []byte
+
+type Chain struct {
+	Blocks  []*core.Block // in import order
+	Heights map[core.Uint256]int
+	Orphans map[core.Uint256]bool
+	Work    map[core.Uint256]*big.Int // chainwork
+}
+
+// Fixture returns the bundled chain.
+func Fixture() (*Chain, error) {
+	return ReadChain(bytes.NewReader(chainDat))
+}
+
+// ReadChain reads a chain written by WriteChain, the parent of each
+// block must come before it.
+func ReadChain(r io.Reader) (*Chain, error) {
+	c := &Chain{
+		Heights: make(map[core.Uint256]int),
+		Orphans: make(map[core.Uint256]bool),
+		Work:    make(map[core.Uint256]*big.Int),
+	}
+	for {
+		b := &core.Block{Magic: core.RegTestMagic}
+		if err := core.BinRead(b, r); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("Block %d: %v", len(c.Blocks), err)
+		}
+		height, work := 0, b.Work()
+		if len(c.Blocks) > 0 {
+			h, ok := c.Heights[b.PrevHash]
+			if !ok {
+				return nil, fmt.Errorf("Block %d: unknown parent %v", len(c.Blocks), b.PrevHash)
+			}
+			height = h + 1
+			work.Add(work, c.Work[b.PrevHash])
+		}
+		c.Heights[b.Hash()] = height
+		c.Work[b.Hash()] = work
+		c.Blocks = append(c.Blocks, b)
+	}
+	if len(c.Blocks) == 0 {
+		return nil, fmt.Errorf("Empty chain.")
+	}
+
+	// Everything not on the way back from the block with the most work
+	// (the first one of those) is an orphan.
+	tip := c.Blocks[0]
+	for _, b := range c.Blocks {
+		if c.Work[b.Hash()].Cmp(c.Work[tip.Hash()]) > 0 {
+			tip = b
+		}
+	}
+	best := make(map[core.Uint256]bool)
+	byHash := make(map[core.Uint256]*core.Block)
+	for _, b := range c.Blocks {
+		byHash[b.Hash()] = b
+	}
+	for b := tip; b != nil; b = byHash[b.PrevHash] {
+		best[b.Hash()] = true
+	}
+	for _, b := range c.Blocks {
+		if !best[b.Hash()] {
+			c.Orphans[b.Hash()] = true
+		}
+	}
+	return c, nil
+}
+
+func WriteChain(c *Chain, w io.Writer) error {
+	for _, b := range c.Blocks {
+		if err := core.BinWrite(b, w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// BuildChain makes the fixture chain (see above). It is deterministic,
+// the keys are derived from their names and the signatures are RFC6979.
+func BuildChain() (*Chain, error) {
+	var gen bytes.Buffer
+	if err := chaincfg.RegressionNetParams.GenesisBlock.Serialize(&gen); err != nil {
+		return nil, err
+	}
+	genesis := &core.Block{Magic: core.RegTestMagic, BlockHeader: &core.BlockHeader{}}
+	r := bytes.NewReader(gen.Bytes())
+	if err := core.BinRead(genesis.BlockHeader, r); err != nil {
+		return nil, err
+	}
+	if err := core.BinRead(&genesis.Txs, r); err != nil {
+		return nil, err
+	}
+	if hash := genesis.Hash(); hash != core.Uint256(*chaincfg.RegressionNetParams.GenesisHash) {
+		return nil, fmt.Errorf("Genesis block hash mismatch: %v", hash)
+	}
+
+	a, b, c := newKey("A"), newKey("B"), newKey("C")
+
+	cb := &builder{blocks: []*core.Block{genesis}}
+	tip := genesis
+	for height := 1; height <= 101; height++ {
+		tip = cb.mine(tip, height, a.p2pkh())
+	}
+	cb1 := cb.blocks[1].Txs[0]
+
+	tx1 := &core.Tx{
+		Version: 2,
+		TxIns:   core.TxInList{{PrevOut: core.OutPoint{Hash: cb1.Hash()}, Sequence: 0xffffffff}},
+		TxOuts: core.TxOutList{
+			{Value: 30 * 100_000_000, ScriptPubKey: b.p2wpkh()},
+			{Value: subsidy - 30*100_000_000 - fee, ScriptPubKey: a.p2pkh()},
+		},
+	}
+	if err := a.signP2PKH(tx1, 0); err != nil {
+		return nil, err
+	}
+	tip = cb.mine(tip, 102, a.p2pkh(), tx1)
+
+	tx2 := &core.Tx{
+		Version: 2,
+		TxIns:   core.TxInList{{PrevOut: core.OutPoint{Hash: tx1.Hash(), N: 0}, Sequence: 0xffffffff}},
+		TxOuts: core.TxOutList{
+			{Value: tx1.TxOuts[0].Value - fee, ScriptPubKey: c.p2pkh()},
+			{Value: 0, ScriptPubKey: append([]byte{core.OP_RETURN, 8}, "blkchain"...)},
+		},
+	}
+	if err := b.signP2WPKH(tx2, 0, tx1.TxOuts[0].Value); err != nil {
+		return nil, err
+	}
+	tx3 := &core.Tx{
+		Version: 2,
+		TxIns:   core.TxInList{{PrevOut: core.OutPoint{Hash: tx2.Hash(), N: 0}, Sequence: 0xffffffff}},
+		TxOuts:  core.TxOutList{{Value: tx2.TxOuts[0].Value - fee, ScriptPubKey: a.p2wpkh()}},
+	}
+	if err := c.signP2PKH(tx3, 0); err != nil {
+		return nil, err
+	}
+	fork := cb.mine(tip, 103, a.p2pkh(), tx2, tx3)
+
+	tx4 := &core.Tx{
+		Version:  2,
+		TxIns:    core.TxInList{{PrevOut: core.OutPoint{Hash: tx1.Hash(), N: 1}, Sequence: 0xfffffffd}},
+		TxOuts:   core.TxOutList{{Value: tx1.TxOuts[1].Value - fee, ScriptPubKey: b.p2pkh()}},
+		LockTime: 104,
+	}
+	if err := a.signP2PKH(tx4, 0); err != nil {
+		return nil, err
+	}
+
+	tip = cb.mine(fork, 104, a.p2pkh())
+	cb.mine(tip, 105, a.p2pkh(), tx4)
+	tip = cb.mine(fork, 104, c.p2pkh())
+	tip = cb.mine(tip, 105, c.p2pkh(), tx4)
+	cb.mine(tip, 106, c.p2pkh())
+
+	var buf bytes.Buffer
+	if err := WriteChain(&Chain{Blocks: cb.blocks}, &buf); err != nil {
+		return nil, err
+	}
+	return ReadChain(&buf)
+}
+
+type builder struct {
+	blocks []*core.Block
+}
+
+// mine makes a block on prev with a coinbase paying the subsidy and
+// the fees to script, and the txs.
+func (cb *builder) mine(prev *core.Block, height int, script []byte, txs ...*core.Tx) *core.Block {
+	coinbase := &core.Tx{
+		Version: 2,
+		TxIns: core.TxInList{{
+			PrevOut:   core.OutPoint{N: 0xffffffff},
+			ScriptSig: append(heightPush(height), core.OP_0), // BIP34
+			Sequence:  0xffffffff,
+		}},
+		TxOuts: core.TxOutList{{Value: subsidy + int64(fee*len(txs)), ScriptPubKey: script}},
+	}
+
+	segwit := false
+	for _, tx := range txs {
+		segwit = segwit || tx.SegWit
+	}
+	if segwit {
+		// BIP141 commitment, with a witness reserved value of zeros
+		wtxids := []core.Uint256{{}}
+		for _, tx := range txs {
+			wtxids = append(wtxids, tx.WitnessHash())
+		}
+		root := core.MerkleRoot(wtxids)
+		commitment := core.ShaSha256(append(root[:], make([]byte, 32)...))
+		coinbase.TxIns[0].Witness = core.Witness{make([]byte, 32)}
+		coinbase.SegWit = true
+		coinbase.TxOuts = append(coinbase.TxOuts, &core.TxOut{
+			ScriptPubKey: append([]byte{core.OP_RETURN, 36, 0xaa, 0x21, 0xa9, 0xed}, commitment[:]...),
+		})
+	}
+
+	b := &core.Block{
+		Magic: core.RegTestMagic,
+		BlockHeader: &core.BlockHeader{
+			Version:  0x20000000,
+			PrevHash: prev.Hash(),
+			Time:     prev.Time + 600,
+			Bits:     bits,
+		},
+		Txs: append(core.TxList{coinbase}, txs...),
+	}
+	b.HashMerkleRoot = b.Txs.MerkleRoot()
+
+	target := new(big.Int).Lsh(big.NewInt(bits&0xffffff), 8*(bits>>24-3))
+	for {
+		hash := b.Hash()
+		if hashToBig(hash).Cmp(target) <= 0 {
+			break
+		}
+		b.Nonce++
+	}
+	cb.blocks = append(cb.blocks, b)
+	return b
+}
+
+func heightPush(height int) []byte {
+	if height <= 16 {
+		return []byte{byte(core.OP_1 + height - 1)}
+	}
+	var n []byte
+	for v := height; v > 0; v >>= 8 {
+		n = append(n, byte(v))
+	}
+	if n[len(n)-1]&0x80 != 0 {
+		n = append(n, 0)
+	}
+	return append([]byte{byte(len(n))}, n...)
+}
+
+func hashToBig(hash core.Uint256) *big.Int {
+	b := make([]byte, 32)
+	for i := range b {
+		b[i] = hash[31-i]
+	}
+	return new(big.Int).SetBytes(b)
+}
+
+type key struct {
+	priv *btcec.PrivateKey
+	pub  []byte // compressed
+}
+
+func newKey(name string) *key {
+	secret := sha256.Sum256([]byte("blkchain regtest " + name))
+	priv, pub := btcec.PrivKeyFromBytes(secret[:])
+	return &key{priv: priv, pub: pub.SerializeCompressed()}
+}
+
+func (k *key) p2pkh() []byte {
+	s := []byte{core.OP_DUP, core.OP_HASH160, 20}
+	s = append(s, core.Hash160(k.pub)...)
+	return append(s, core.OP_EQUALVERIFY, core.OP_CHECKSIG)
+}
+
+func (k *key) p2wpkh() []byte {
+	return append([]byte{core.OP_0, 20}, core.Hash160(k.pub)...)
+}
+
+func (k *key) sign(hash core.Uint256) []byte {
+	sig := ecdsa.Sign(k.priv, hash[:]).Serialize()
+	return append(sig, core.SigHashAll)
+}
+
+func (k *key) signP2PKH(tx *core.Tx, n int) error {
+	hash := core.LegacySigHash(tx, n, k.p2pkh(), core.SigHashAll)
+	sig := k.sign(hash)
+	in := tx.TxIns[n]
+	in.ScriptSig = append(append([]byte{byte(len(sig))}, sig...), byte(len(k.pub)))
+	in.ScriptSig = append(in.ScriptSig, k.pub...)
+	return core.VerifyScript(tx, n, &core.TxOut{ScriptPubKey: k.p2pkh()}, core.ScriptVerifyAll, core.NewTxSigChecker(tx, n, nil))
+}
+
+func (k *key) signP2WPKH(tx *core.Tx, n int, amount int64) error {
+	hash := core.WitnessV0SigHash(tx, n, k.p2pkh(), amount, core.SigHashAll)
+	tx.TxIns[n].Witness = core.Witness{k.sign(hash), k.pub}
+	tx.SegWit = true
+	prevOuts := make([]*core.TxOut, len(tx.TxIns))
+	prevOuts[n] = &core.TxOut{Value: amount, ScriptPubKey: k.p2wpkh()}
+	return core.VerifyTxInput(tx, n, prevOuts)
+}
@@ -0,0 +1,296 @@
+package regtest
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/blkchain/blkchain/core"
+	"github.com/blkchain/blkchain/pg"
+)
+
+// Importing the fixture and checking the rows written, all of them,
+// against what they should be according to the blocks. Surrogate ids
+// are compared by what they refer to (e.g. the txid of prevout_tx_id),
+// since a dupe tx takes up an id too.
+
+// Mismatches reported per table at most.
+const maxMismatches = 10
+
+// utxoSet stands in for the chainstate of Core at the tip of the best
+// chain, which the first import takes the spent flags from. Like the
+// chainstate, it has neither the genesis coinbase nor the unspendable
+// (OP_RETURN) outputs.
+type utxoSet map[core.OutPoint]bool
+
+func (u utxoSet) IsUTXO(hash core.Uint256, n uint32) (bool, error) {
+	return u[core.OutPoint{Hash: hash, N: n}], nil
+}
+
+func newUTXOSet(c *Chain) utxoSet {
+	u := make(utxoSet)
+	for i, b := range c.Blocks {
+		if c.Orphans[b.Hash()] {
+			continue
+		}
+		for _, tx := range b.Txs {
+			hash := tx.Hash()
+			for n, out := range tx.TxOuts {
+				if i > 0 && (len(out.ScriptPubKey) == 0 || out.ScriptPubKey[0] != core.OP_RETURN) {
+					u[core.OutPoint{Hash: hash, N: uint32(n)}] = true
+				}
+			}
+			for _, in := range tx.TxIns {
+				delete(u, in.PrevOut)
+			}
+		}
+	}
+	return u
+}
+
+// firstImport is the number of blocks of the first import.
+func (c *Chain) firstImport() int {
+	n := 0
+	for n < len(c.Blocks) && c.Heights[c.Blocks[n].Hash()] <= FirstImportHeight {
+		n++
+	}
+	return n
+}
+
+// Import writes the chain to the database of cfg, which must be empty,
+// as cmd/import does: the first import from "LevelDb", with the
+// heights known, then the blocks after FirstImportHeight one at a time
+// as if from a node, which takes care of the reorg.
+func Import(cfg pg.WriterConfig, c *Chain) error {
+	n := c.firstImport()
+
+	w, err := pg.NewPGWriterConfig(cfg, newUTXOSet(c))
+	if err != nil {
+		return err
+	}
+	for _, b := range c.Blocks[:n] {
+		if err := w.WriteBlock(&pg.BlockRec{Block: b, Height: c.Heights[b.Hash()]}, false); err != nil {
+			w.Close()
+			return err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	if w, err = pg.NewPGWriterConfig(cfg, nil); err != nil {
+		return err
+	}
+	for _, b := range c.Blocks[n:] {
+		if err := w.WriteBlock(&pg.BlockRec{Block: b, Height: -1}, true); err != nil {
+			w.Close()
+			return err
+		}
+	}
+	return w.Close()
+}
+
+// Check compares the rows of blocks, txs, block_txs, txins and txouts
+// (txouts_v) with what the chain should have been imported as. The
+// mismatches are returned, an error only if the queries failed.
+func Check(conn *sql.DB, c *Chain) ([]string, error) {
+	exp := expectedRows(c)
+	var problems []string
+	for _, t := range checkQueries {
+		rows, err := queryRows(conn, t.query)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", t.table, err)
+		}
+		problems = append(problems, compareRows(t.table, exp[t.table], rows)...)
+	}
+	return problems, nil
+}
+
+// The rows as strings, in the order of the ids.
+var checkQueries = []struct {
+	table, query string
+}{
+	{"blocks", `
+SELECT b.height, b.hash, b.version, b.prevhash, p.hash AS prev_block, b.merkleroot, b.time, b.bits, b.nonce,
+       b.chainwork, b.orphan, b.size, b.base_size, b.weight, b.virt_size
+  FROM blocks b
+  LEFT JOIN blocks p ON p.id = b.prev_block_id
+ ORDER BY b.id`},
+	{"txs", `
+SELECT txid, version, locktime, size, base_size, weight, virt_size, n_inputs, n_outputs
+  FROM txs ORDER BY id`},
+	{"block_txs", `
+SELECT b.hash, bt.n, t.txid
+  FROM block_txs bt
+  JOIN blocks b ON b.id = bt.block_id
+  JOIN txs t ON t.id = bt.tx_id
+ ORDER BY b.id, bt.n`},
+	{"txins", `
+SELECT t.txid, i.n, p.txid, i.prevout_n, i.scriptsig, i.sequence, i.witness
+  FROM txins i
+  JOIN txs t ON t.id = i.tx_id
+  LEFT JOIN txs p ON p.id = i.prevout_tx_id
+ ORDER BY i.tx_id, i.n`},
+	{"txouts", `
+SELECT t.txid, o.n, o.value, o.scriptpubkey, o.spent
+  FROM txouts_v o
+  JOIN txs t ON t.id = o.tx_id
+ ORDER BY o.tx_id, o.n`},
+}
+
+func expectedRows(c *Chain) map[string][]string {
+	exp := make(map[string][]string)
+	n := c.firstImport()
+	utxo := newUTXOSet(c)
+
+	// Only the first occurrence of a tx is written, and the outputs
+	// spent after the first import are flagged by the txins trigger.
+	seen := make(map[core.Uint256]bool)
+	var txs []*core.Tx
+	spentLater := make(map[core.OutPoint]bool)
+	for i, b := range c.Blocks {
+		hash := b.Hash()
+		prevBlock := "NULL"
+		if i > 0 {
+			prevBlock = b.PrevHash.String()
+		}
+		exp["blocks"] = append(exp["blocks"], fmt.Sprintf("%d %v %d %v %s %v %d %d %d %v %v %d %d %d %d",
+			c.Heights[hash], hash, int32(b.Version), b.PrevHash, prevBlock, b.HashMerkleRoot,
+			int32(b.Time), int32(b.Bits), int32(b.Nonce), c.Work[hash], c.Orphans[hash],
+			b.Size(), b.BaseSize(), b.Weight(), b.VirtualSize()))
+		for j, tx := range b.Txs {
+			txid := tx.Hash()
+			exp["block_txs"] = append(exp["block_txs"], fmt.Sprintf("%v %d %v", hash, j, txid))
+			if seen[txid] {
+				continue
+			}
+			seen[txid] = true
+			txs = append(txs, tx)
+			if i >= n {
+				for _, in := range tx.TxIns {
+					spentLater[in.PrevOut] = true
+				}
+			}
+		}
+	}
+
+	firstImportTxs := make(map[core.Uint256]bool)
+	for _, b := range c.Blocks[:n] {
+		for _, tx := range b.Txs {
+			firstImportTxs[tx.Hash()] = true
+		}
+	}
+
+	for _, tx := range txs {
+		txid := tx.Hash()
+		exp["txs"] = append(exp["txs"], fmt.Sprintf("%v %d %d %d %d %d %d %d %d",
+			txid, int32(tx.Version), int32(tx.LockTime), tx.Size(), tx.BaseSize(), tx.Weight(), tx.VirtualSize(),
+			len(tx.TxIns), len(tx.TxOuts)))
+		for i, in := range tx.TxIns {
+			prev := "NULL"
+			if in.PrevOut.N != 0xffffffff {
+				prev = in.PrevOut.Hash.String()
+			}
+			witness := "NULL"
+			if in.Witness != nil {
+				var buf bytes.Buffer
+				core.BinWrite(&in.Witness, &buf)
+				witness = hex.EncodeToString(buf.Bytes())
+			}
+			exp["txins"] = append(exp["txins"], fmt.Sprintf("%v %d %s %d %x %d %s",
+				txid, i, prev, int32(in.PrevOut.N), in.ScriptSig, int32(in.Sequence), witness))
+		}
+		for i, out := range tx.TxOuts {
+			op := core.OutPoint{Hash: txid, N: uint32(i)}
+			spent := spentLater[op]
+			if firstImportTxs[txid] { // from the UTXO set
+				spent = spent || !utxo[op]
+			}
+			exp["txouts"] = append(exp["txouts"], fmt.Sprintf("%v %d %d %x %v",
+				txid, i, out.Value, out.ScriptPubKey, spent))
+		}
+	}
+	return exp
+}
+
+// queryRows returns the rows of query as strings formatted like the
+// expected ones: hashes as Uint256, other bytes in hex.
+func queryRows(conn *sql.DB, query string) ([]string, error) {
+	rows, err := conn.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	var result []string
+	for rows.Next() {
+		vals := make([]interface{}, len(cols))
+		ptrs := make([]interface{}, len(cols))
+		for i := range vals {
+			ptrs[i] = &vals[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, err
+		}
+		var line bytes.Buffer
+		for i, v := range vals {
+			if i > 0 {
+				line.WriteByte(' ')
+			}
+			switch v := v.(type) {
+			case nil:
+				line.WriteString("NULL")
+			case []byte:
+				if isHashColumn(cols[i]) && len(v) == 32 {
+					fmt.Fprint(&line, core.Uint256FromBytes(v))
+				} else if cols[i] == "chainwork" { // NUMERIC, as text
+					line.Write(v)
+				} else {
+					line.WriteString(hex.EncodeToString(v))
+				}
+			default:
+				fmt.Fprint(&line, v)
+			}
+		}
+		result = append(result, line.String())
+	}
+	return result, rows.Err()
+}
+
+func isHashColumn(col string) bool {
+	switch col {
+	case "hash", "prevhash", "prev_block", "merkleroot", "txid":
+		return true
+	}
+	return false
+}
+
+func compareRows(table string, exp, got []string) []string {
+	var problems []string
+	if len(exp) != len(got) {
+		problems = append(problems, fmt.Sprintf("%s: expected %d rows, got %d", table, len(exp), len(got)))
+	}
+	mismatches := 0
+	for i := 0; i < len(exp) || i < len(got); i++ {
+		var e, g string
+		if i < len(exp) {
+			e = exp[i]
+		}
+		if i < len(got) {
+			g = got[i]
+		}
+		if e == g {
+			continue
+		}
+		if mismatches++; mismatches > maxMismatches {
+			problems = append(problems, fmt.Sprintf("%s: more mismatches...", table))
+			break
+		}
+		problems = append(problems, fmt.Sprintf("%s row %d: expected %q, got %q", table, i, e, g))
+	}
+	return problems
+}
@@ -0,0 +1,135 @@
+package regtest
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	_ "github.com/lib/pq"
+)
+
+// A Postgres to import the fixture into, thrown away afterwards:
+// either a database created on an existing server, or a whole cluster
+// started in a temporary directory (needs initdb and pg_ctl, and the
+// contrib extensions for pgcrypto).
+
+type TempPostgres struct {
+	ConnectString string
+	close         func() error
+}
+
+func (t *TempPostgres) Close() error {
+	return t.close()
+}
+
+// TempDatabase creates a new database on the server of connstr (with
+// the rights to create databases), the database in connstr is only
+// used to connect.
+func TempDatabase(connstr string) (*TempPostgres, error) {
+	conn, err := sql.Open("postgres", connstr)
+	if err != nil {
+		return nil, err
+	}
+	name := fmt.Sprintf("blkchain_regtest_%d", os.Getpid())
+	if _, err := conn.Exec("CREATE DATABASE " + name); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("Creating database %s: %v", name, err)
+	}
+	return &TempPostgres{
+		ConnectString: connstr + " dbname=" + name, // the last one counts
+		close: func() error {
+			defer conn.Close()
+			_, err := conn.Exec("DROP DATABASE " + name)
+			return err
+		},
+	}, nil
+}
+
+// TempCluster initializes and starts a cluster in a temporary
+// directory, listening only on a unix socket in it. The binaries are
+// looked for in binDir if given, else the PATH and the usual Debian
+// location.
+func TempCluster(binDir string) (*TempPostgres, error) {
+	initdb, err := findPGBin(binDir, "initdb")
+	if err != nil {
+		return nil, err
+	}
+	pgCtl := filepath.Join(filepath.Dir(initdb), "pg_ctl")
+
+	dir, err := os.MkdirTemp("", "blkchain-regtest-")
+	if err != nil {
+		return nil, err
+	}
+	data := filepath.Join(dir, "data")
+	port, err := freePort()
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+
+	if err := run(initdb, "-D", data, "-U", "postgres", "--auth=trust", "-E", "UTF8"); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	opts := fmt.Sprintf("-k %s -c listen_addresses='' -p %d -c fsync=off", dir, port)
+	if err := run(pgCtl, "-D", data, "-l", filepath.Join(dir, "log"), "-o", opts, "-w", "start"); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	return &TempPostgres{
+		ConnectString: fmt.Sprintf("host=%s port=%d user=postgres dbname=postgres sslmode=disable", dir, port),
+		close: func() error {
+			defer os.RemoveAll(dir)
+			return run(pgCtl, "-D", data, "-m", "immediate", "stop")
+		},
+	}, nil
+}
+
+func findPGBin(binDir, name string) (string, error) {
+	if binDir != "" {
+		return filepath.Join(binDir, name), nil
+	}
+	if path, err := exec.LookPath(name); err == nil {
+		return path, nil
+	}
+	// Debian and Ubuntu don't put them on the PATH, take the newest.
+	dirs, _ := filepath.Glob("/usr/lib/postgresql/*/bin")
+	sort.Slice(dirs, func(i, j int) bool { return pgVersion(dirs[i]) > pgVersion(dirs[j]) })
+	for _, dir := range dirs {
+		path := filepath.Join(dir, name)
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("%s not found, use the PostgreSQL bin directory", name)
+}
+
+func pgVersion(binDir string) int {
+	var v int
+	fmt.Sscan(filepath.Base(filepath.Dir(binDir)), &v)
+	return v
+}
+
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
+
+func run(name string, args ...string) error {
+	out, err := exec.Command(name, args...).CombinedOutput()
+	if err != nil {
+		log.Printf("%s %s:\n%s", name, strings.Join(args, " "), out)
+		return fmt.Errorf("%s: %v", filepath.Base(name), err)
+	}
+	return nil
+}
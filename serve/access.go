@@ -0,0 +1,212 @@
+package serve
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Access control, for exposing the API publicly: with API keys (see
+// LoadAPIKeys) every request needs one, in the X-API-Key header or the
+// api_key query parameter, else it is a 401. With a rate limit, the
+// requests of each key (or of each client IP when there are no keys)
+// are limited by a token bucket, a request over the limit being a 429
+// with Retry-After. With request logging, every request is logged with
+// its status, duration, client and key name (never the key itself).
+
+// Bursts allowed by default, see LimitRate.
+const DefaultBurst = 10
+
+// The bucket of a client idle for this long is full again and is
+// dropped.
+const bucketIdle = 10 * time.Minute
+
+type APIKey struct {
+	Name string
+	Rate float64 // requests per second, 0 = the default rate
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+type limiter struct {
+	sync.Mutex
+	rate    float64 // tokens per second
+	burst   float64
+	buckets map[string]*bucket
+	swept   time.Time
+}
+
+// LoadAPIKeys reads API keys from a file, one per line as "<key>
+// <name> [<requests per second>]", # starting a comment.
+func LoadAPIKeys(path string) (map[string]*APIKey, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	keys := make(map[string]*APIKey)
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := sc.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 2 || len(fields) > 3 {
+			return nil, fmt.Errorf("%s:%d: expected <key> <name> [<requests per second>]", path, n)
+		}
+		k := &APIKey{Name: fields[1]}
+		if len(fields) == 3 {
+			if k.Rate, err = strconv.ParseFloat(fields[2], 64); err != nil || k.Rate <= 0 {
+				return nil, fmt.Errorf("%s:%d: invalid rate %q", path, n, fields[2])
+			}
+		}
+		if _, ok := keys[fields[0]]; ok {
+			return nil, fmt.Errorf("%s:%d: duplicate key", path, n)
+		}
+		keys[fields[0]] = k
+	}
+	return keys, sc.Err()
+}
+
+// RequireAPIKeys makes every request need one of the keys. The keys
+// with a rate are limited to it, the others only by LimitRate.
+func (s *Server) RequireAPIKeys(keys map[string]*APIKey) {
+	s.apiKeys = keys
+	if s.limiter == nil {
+		s.LimitRate(0, DefaultBurst)
+	}
+}
+
+// LimitRate limits every key, or client IP without keys, to rate
+// requests per second on average (0 = unlimited, keys may have their
+// own rate), with bursts of up to burst requests.
+func (s *Server) LimitRate(rate float64, burst int) {
+	if burst < 1 {
+		burst = 1
+	}
+	s.limiter = &limiter{rate: rate, burst: float64(burst), buckets: make(map[string]*bucket)}
+}
+
+// LogRequests logs every request.
+func (s *Server) LogRequests() {
+	s.logRequests = true
+}
+
+// admit checks the key and the rate of the request, it has been
+// answered if not. Returns the name of the key, or the client IP.
+func (s *Server) admit(w http.ResponseWriter, r *http.Request) (string, bool) {
+	client := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(client); err == nil {
+		client = host
+	}
+	rate := 0.0
+	if s.apiKeys != nil {
+		key := r.Header.Get("X-API-Key")
+		if key == "" {
+			key = r.URL.Query().Get("api_key")
+		}
+		k := s.apiKeys[key]
+		if k == nil {
+			http.Error(w, "Missing or invalid API key", http.StatusUnauthorized)
+			return client, false
+		}
+		client, rate = k.Name, k.Rate
+	}
+	if wait := s.limiter.take(client, rate); wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		http.Error(w, "Too many requests", http.StatusTooManyRequests)
+		return client, false
+	}
+	return client, true
+}
+
+// take takes a token from the bucket of the client, refilled at rate
+// (0 = the default). Returns how long until there is one if there is
+// none.
+func (l *limiter) take(client string, rate float64) time.Duration {
+	if l == nil {
+		return 0
+	}
+	if rate <= 0 {
+		rate = l.rate
+	}
+	if rate <= 0 {
+		return 0
+	}
+	l.Lock()
+	defer l.Unlock()
+
+	now := time.Now()
+	if now.Sub(l.swept) > bucketIdle {
+		for c, b := range l.buckets {
+			if now.Sub(b.last) > bucketIdle {
+				delete(l.buckets, c)
+			}
+		}
+		l.swept = now
+	}
+	b := l.buckets[client]
+	if b == nil {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[client] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+	if b.tokens < 1 {
+		return time.Duration((1 - b.tokens) / rate * float64(time.Second))
+	}
+	b.tokens--
+	return 0
+}
+
+// statusWriter records the status of the response, for the log.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += n
+	return n, err
+}
+
+// Hijack lets the WebSocket endpoint take over the connection.
+func (w *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("Connection cannot be hijacked")
+	}
+	w.status = http.StatusSwitchingProtocols
+	return hj.Hijack()
+}
+
+func logRequest(r *http.Request, client string, w *statusWriter, start time.Time) {
+	log.Printf("%s %s %s %d %d %s", client, r.Method, r.URL.Path, w.status, w.bytes,
+		time.Now().Sub(start).Round(time.Microsecond))
+}
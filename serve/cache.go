@@ -0,0 +1,159 @@
+package serve
+
+import (
+	"bytes"
+	"container/list"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/blkchain/blkchain/pg"
+)
+
+// A cache of the GET /api responses (see EnableCache), since explorers
+// ask for the same few things (the tip, the latest blocks, the txs
+// everyone is looking at) over and over. A response is cached under
+// its URL and the hash of the main chain tip, so a new block, as
+// notified by the import while following the chain (see pg/notify.go),
+// invalidates everything at once, and the TTL takes care of the rest
+// (e.g. the import not following). The cache is in memory (MemoryCache)
+// or shared by several servers in Redis (RedisCache).
+
+type Cache interface {
+	Get(key string) ([]byte, bool)
+	Set(key string, value []byte, ttl time.Duration)
+}
+
+// Default time to live of the cached responses.
+const DefaultCacheTTL = 10 * time.Minute
+
+// The largest response cached.
+const maxCachedResponse = 1 << 20
+
+// EnableCache caches the responses in c for up to ttl.
+func (s *Server) EnableCache(c Cache, ttl time.Duration) {
+	s.cache, s.cacheTTL = c, ttl
+	if hash, err := s.e.SelectMainChainHash(-1); err != nil {
+		log.Printf("Error reading the tip for the cache: %v", err)
+	} else {
+		s.setCacheTip(hash.String())
+	}
+	s.onNewBlock(func(b *pg.NewBlock) { s.setCacheTip(b.Hash.String()) })
+}
+
+func (s *Server) setCacheTip(hash string) {
+	s.cacheMu.Lock()
+	defer s.cacheMu.Unlock()
+	s.cacheTip = hash
+}
+
+// cacheable tells whether the response to the request may be cached.
+func cacheable(r *http.Request) bool {
+	return r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/api/") &&
+		r.URL.Path != "/api/ws" && r.URL.Query().Get("api_key") == ""
+}
+
+// cacheWriter keeps a copy of the response for the cache.
+type cacheWriter struct {
+	http.ResponseWriter
+	status int
+	buf    bytes.Buffer
+}
+
+func (w *cacheWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *cacheWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if w.buf.Len() <= maxCachedResponse {
+		w.buf.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// serveCached serves the request from the cache, or serves it and
+// caches the response if it is a 200.
+func (s *Server) serveCached(w http.ResponseWriter, r *http.Request) {
+	s.cacheMu.Lock()
+	key := s.cacheTip + " " + r.URL.RequestURI()
+	s.cacheMu.Unlock()
+
+	if body, ok := s.cache.Get(key); ok {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Cache", "HIT")
+		w.Write(body)
+		return
+	}
+	w.Header().Set("X-Cache", "MISS")
+	cw := &cacheWriter{ResponseWriter: w}
+	s.mux.ServeHTTP(cw, r)
+	if cw.status == http.StatusOK && cw.buf.Len() <= maxCachedResponse &&
+		strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+		s.cache.Set(key, cw.buf.Bytes(), s.cacheTTL)
+	}
+}
+
+// MemoryCache is an LRU cache of up to a number of bytes.
+type MemoryCache struct {
+	sync.Mutex
+	maxBytes int
+	bytes    int
+	lru      *list.List // of *memoryEntry, the most recent first
+	entries  map[string]*list.Element
+}
+
+type memoryEntry struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+func NewMemoryCache(maxBytes int) *MemoryCache {
+	return &MemoryCache{maxBytes: maxBytes, lru: list.New(), entries: make(map[string]*list.Element)}
+}
+
+func (c *MemoryCache) Get(key string) ([]byte, bool) {
+	c.Lock()
+	defer c.Unlock()
+	el := c.entries[key]
+	if el == nil {
+		return nil, false
+	}
+	e := el.Value.(*memoryEntry)
+	if time.Now().After(e.expires) {
+		c.remove(el)
+		return nil, false
+	}
+	c.lru.MoveToFront(el)
+	return e.value, true
+}
+
+func (c *MemoryCache) Set(key string, value []byte, ttl time.Duration) {
+	size := len(key) + len(value)
+	if size > c.maxBytes {
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+	if el := c.entries[key]; el != nil {
+		c.remove(el)
+	}
+	e := &memoryEntry{key: key, value: append([]byte(nil), value...), expires: time.Now().Add(ttl)}
+	c.entries[key] = c.lru.PushFront(e)
+	c.bytes += size
+	for c.bytes > c.maxBytes {
+		c.remove(c.lru.Back())
+	}
+}
+
+func (c *MemoryCache) remove(el *list.Element) {
+	e := c.lru.Remove(el).(*memoryEntry)
+	delete(c.entries, e.key)
+	c.bytes -= len(e.key) + len(e.value)
+}
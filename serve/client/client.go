@@ -0,0 +1,100 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// A Go client of the HTTP API (see serve/routes.go), without the
+// dependencies of the server. The methods, one per route, are
+// generated into routes_gen.go from serve.Routes by cmd/apigen: the
+// path params are arguments, the query params fields of a
+// <Method>Params struct (nil fields, or a nil struct, are left to
+// the server's defaults), and the results JSON as the server
+// writes it.
+
+//go:generate go run ../../cmd/apigen -o routes_gen.go
+
+type Client struct {
+	BaseURL string // e.g. http://localhost:8080
+	APIKey  string // "" = none
+	HTTP    *http.Client
+}
+
+func New(baseURL string) *Client {
+	return &Client{BaseURL: strings.TrimSuffix(baseURL, "/"), HTTP: http.DefaultClient}
+}
+
+// An Error is a response with a status other than 200.
+type Error struct {
+	Status  int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.Status, http.StatusText(e.Status), e.Message)
+}
+
+// Int, Bool and String return a pointer to the value, for the fields
+// of the Params structs.
+func Int(i int) *int          { return &i }
+func Bool(b bool) *bool       { return &b }
+func String(s string) *string { return &s }
+
+func (c *Client) do(ctx context.Context, method, path string, q url.Values, body string) (json.RawMessage, error) {
+	u := c.BaseURL + path
+	if len(q) > 0 {
+		u += "?" + q.Encode()
+	}
+	var rd io.Reader
+	if body != "" {
+		rd = strings.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, rd)
+	if err != nil {
+		return nil, err
+	}
+	if body != "" {
+		req.Header.Set("Content-Type", "text/plain")
+	}
+	if c.APIKey != "" {
+		req.Header.Set("X-API-Key", c.APIKey)
+	}
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return json.RawMessage(b), &Error{Status: resp.StatusCode, Message: strings.TrimSpace(string(b))}
+	}
+	return json.RawMessage(b), nil
+}
+
+func setInt(q url.Values, name string, v *int) {
+	if v != nil {
+		q.Set(name, strconv.Itoa(*v))
+	}
+}
+
+func setBool(q url.Values, name string, v *bool) {
+	if v != nil {
+		q.Set(name, strconv.FormatBool(*v))
+	}
+}
+
+func setString(q url.Values, name string, v *string) {
+	if v != nil {
+		q.Set(name, *v)
+	}
+}
@@ -0,0 +1,236 @@
+// Code generated by cmd/apigen. DO NOT EDIT.
+
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+)
+
+// Height calls GET /api/height: height of the highest block.
+func (c *Client) Height(ctx context.Context) (int, error) {
+	q := url.Values{}
+	r, err := c.do(ctx, "GET", "/api/height", q, "")
+	if err != nil {
+		return 0, err
+	}
+	var v int
+	return v, json.Unmarshal(r, &v)
+}
+
+// BlocksParams are the query params of Blocks, nil = the default.
+type BlocksParams struct {
+	Height *int // Highest block (default the tip)
+	Limit  *int // Number of items, 1 to 1000 (default 25)
+}
+
+// Blocks calls GET /api/blocks: blocks at and below a height.
+func (c *Client) Blocks(ctx context.Context, p *BlocksParams) (json.RawMessage, error) {
+	q := url.Values{}
+	if p != nil {
+		setInt(q, "height", p.Height)
+		setInt(q, "limit", p.Limit)
+	}
+	return c.do(ctx, "GET", "/api/blocks", q, "")
+}
+
+// ChainParams are the query params of Chain, nil = the default.
+type ChainParams struct {
+	Before *string // Height or block hash, the blocks below
+	After  *string // Height or block hash, the blocks above
+	Limit  *int    // Number of items, 1 to 1000 (default 25)
+}
+
+// Chain calls GET /api/chain: page of the main chain with confirmations.
+func (c *Client) Chain(ctx context.Context, p *ChainParams) (json.RawMessage, error) {
+	q := url.Values{}
+	if p != nil {
+		setString(q, "before", p.Before)
+		setString(q, "after", p.After)
+		setInt(q, "limit", p.Limit)
+	}
+	return c.do(ctx, "GET", "/api/chain", q, "")
+}
+
+// Block calls GET /api/block/{hash}: block header.
+func (c *Client) Block(ctx context.Context, hash string) (json.RawMessage, error) {
+	q := url.Values{}
+	return c.do(ctx, "GET", "/api/block/"+url.PathEscape(hash), q, "")
+}
+
+// BlockTxsParams are the query params of BlockTxs, nil = the default.
+type BlockTxsParams struct {
+	Start *int // Position of the first tx in the block
+	Limit *int // Number of items, 1 to 1000 (default 25)
+}
+
+// BlockTxs calls GET /api/block/{hash}/txs: transactions of a block.
+func (c *Client) BlockTxs(ctx context.Context, hash string, p *BlockTxsParams) (json.RawMessage, error) {
+	q := url.Values{}
+	if p != nil {
+		setInt(q, "start", p.Start)
+		setInt(q, "limit", p.Limit)
+	}
+	return c.do(ctx, "GET", "/api/block/"+url.PathEscape(hash)+"/txs", q, "")
+}
+
+// Tx calls GET /api/tx/{txid}: transaction with its inputs and outputs.
+func (c *Client) Tx(ctx context.Context, txid string) (json.RawMessage, error) {
+	q := url.Values{}
+	return c.do(ctx, "GET", "/api/tx/"+url.PathEscape(txid), q, "")
+}
+
+// SearchTxParams are the query params of SearchTx, nil = the default.
+type SearchTxParams struct {
+	Limit *int // Number of items, 1 to 1000 (default 25)
+}
+
+// SearchTx calls GET /api/search/tx/{prefix}: transactions by txid prefix.
+func (c *Client) SearchTx(ctx context.Context, prefix string, p *SearchTxParams) (json.RawMessage, error) {
+	q := url.Values{}
+	if p != nil {
+		setInt(q, "limit", p.Limit)
+	}
+	return c.do(ctx, "GET", "/api/search/tx/"+url.PathEscape(prefix), q, "")
+}
+
+// SearchAddressParams are the query params of SearchAddress, nil = the default.
+type SearchAddressParams struct {
+	Limit *int // Number of items, 1 to 1000 (default 25)
+}
+
+// SearchAddress calls GET /api/search/address/{address}: address type, balance and first page of history.
+func (c *Client) SearchAddress(ctx context.Context, address string, p *SearchAddressParams) (json.RawMessage, error) {
+	q := url.Values{}
+	if p != nil {
+		setInt(q, "limit", p.Limit)
+	}
+	return c.do(ctx, "GET", "/api/search/address/"+url.PathEscape(address), q, "")
+}
+
+// AddressTxsParams are the query params of AddressTxs, nil = the default.
+type AddressTxsParams struct {
+	Start *int // Tx id to start below
+	Limit *int // Number of items, 1 to 1000 (default 25)
+}
+
+// AddressTxs calls GET /api/address/{address}/txs: transactions of an address.
+func (c *Client) AddressTxs(ctx context.Context, address string, p *AddressTxsParams) (json.RawMessage, error) {
+	q := url.Values{}
+	if p != nil {
+		setInt(q, "start", p.Start)
+		setInt(q, "limit", p.Limit)
+	}
+	return c.do(ctx, "GET", "/api/address/"+url.PathEscape(address)+"/txs", q, "")
+}
+
+// AddressHistoryParams are the query params of AddressHistory, nil = the default.
+type AddressHistoryParams struct {
+	After *string // The next key of the previous page
+	Limit *int    // Number of items, 1 to 1000 (default 25)
+}
+
+// AddressHistory calls GET /api/address/{address}/history: funding and spending events of an address, newest first.
+func (c *Client) AddressHistory(ctx context.Context, address string, p *AddressHistoryParams) (json.RawMessage, error) {
+	q := url.Values{}
+	if p != nil {
+		setString(q, "after", p.After)
+		setInt(q, "limit", p.Limit)
+	}
+	return c.do(ctx, "GET", "/api/address/"+url.PathEscape(address)+"/history", q, "")
+}
+
+// AddressLabels calls GET /api/address/{address}/labels: labels of an address.
+func (c *Client) AddressLabels(ctx context.Context, address string) (json.RawMessage, error) {
+	q := url.Values{}
+	return c.do(ctx, "GET", "/api/address/"+url.PathEscape(address)+"/labels", q, "")
+}
+
+// AddressBalanceParams are the query params of AddressBalance, nil = the default.
+type AddressBalanceParams struct {
+	Height *int  // As of this height (default the tip)
+	Mature *bool // Only mature coinbase outputs
+}
+
+// AddressBalance calls GET /api/address/{address}/balance: balance of an address.
+func (c *Client) AddressBalance(ctx context.Context, address string, p *AddressBalanceParams) (json.RawMessage, error) {
+	q := url.Values{}
+	if p != nil {
+		setInt(q, "height", p.Height)
+		setBool(q, "mature", p.Mature)
+	}
+	return c.do(ctx, "GET", "/api/address/"+url.PathEscape(address)+"/balance", q, "")
+}
+
+// AddressUtxosParams are the query params of AddressUtxos, nil = the default.
+type AddressUtxosParams struct {
+	Height *int  // As of this height (default the tip)
+	Mature *bool // Only mature coinbase outputs
+}
+
+// AddressUtxos calls GET /api/address/{address}/utxos: unspent outputs of an address.
+func (c *Client) AddressUtxos(ctx context.Context, address string, p *AddressUtxosParams) (json.RawMessage, error) {
+	q := url.Values{}
+	if p != nil {
+		setInt(q, "height", p.Height)
+		setBool(q, "mature", p.Mature)
+	}
+	return c.do(ctx, "GET", "/api/address/"+url.PathEscape(address)+"/utxos", q, "")
+}
+
+// GraphQLParams are the query params of GraphQL, nil = the default.
+type GraphQLParams struct {
+	Query         *string // The query
+	Variables     *string // The variables, a JSON object
+	OperationName *string // The operation to run, if the query has several
+}
+
+// GraphQL calls GET /api/graphql: run a GraphQL query over blocks, txs and addresses.
+func (c *Client) GraphQL(ctx context.Context, p *GraphQLParams) (json.RawMessage, error) {
+	q := url.Values{}
+	if p != nil {
+		setString(q, "query", p.Query)
+		setString(q, "variables", p.Variables)
+		setString(q, "operationName", p.OperationName)
+	}
+	return c.do(ctx, "GET", "/api/graphql", q, "")
+}
+
+// AddressesParams are the query params of Addresses, nil = the default.
+type AddressesParams struct {
+	Height *int  // As of this height (default the tip)
+	Mature *bool // Only mature coinbase outputs
+	Utxos  *bool // With the unspent outputs
+}
+
+// Addresses calls POST /api/addresses: balances, and UTXOs, of many addresses.
+func (c *Client) Addresses(ctx context.Context, p *AddressesParams, body string) (json.RawMessage, error) {
+	q := url.Values{}
+	if p != nil {
+		setInt(q, "height", p.Height)
+		setBool(q, "mature", p.Mature)
+		setBool(q, "utxos", p.Utxos)
+	}
+	return c.do(ctx, "POST", "/api/addresses", q, body)
+}
+
+// PSBT calls POST /api/psbt: check a PSBT against the database.
+func (c *Client) PSBT(ctx context.Context, body string) (json.RawMessage, error) {
+	q := url.Values{}
+	return c.do(ctx, "POST", "/api/psbt", q, body)
+}
+
+// BroadcastParams are the query params of Broadcast, nil = the default.
+type BroadcastParams struct {
+	CheckOnly *bool // Only check
+}
+
+// Broadcast calls POST /api/broadcast: check a tx and relay it to the node (if enabled).
+func (c *Client) Broadcast(ctx context.Context, p *BroadcastParams, body string) (json.RawMessage, error) {
+	q := url.Values{}
+	if p != nil {
+		setBool(q, "check_only", p.CheckOnly)
+	}
+	return c.do(ctx, "POST", "/api/broadcast", q, body)
+}
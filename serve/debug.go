@@ -0,0 +1,71 @@
+package serve
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+
+	"github.com/blkchain/blkchain/pg"
+)
+
+// Debug endpoints of a long running process (e.g. an import), not to
+// be exposed beyond localhost:
+//
+//   /debug/pprof/...   the net/http/pprof profiles
+//   /debug/pipeline    the import pipeline, see pg.ImportPipelineStatus
+//
+// e.g. go tool pprof http://localhost:6060/debug/pprof/profile?seconds=30
+// or curl localhost:6060/debug/pprof/goroutine?debug=2 for the stacks
+// of a stalled writer.
+
+func NewDebugHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.HandleFunc("/debug/pipeline", pipeline)
+	return mux
+}
+
+func pipeline(w http.ResponseWriter, r *http.Request) {
+	b, err := json.MarshalIndent(pg.ImportPipelineStatus(), "", "  ")
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	writeJson(w, string(b))
+}
+
+// CheckLoopback returns an error unless addr (host:port) is on a
+// loopback interface.
+func CheckLoopback(addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	if host == "localhost" {
+		return nil
+	}
+	if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+		return fmt.Errorf("%s is not a loopback address", addr)
+	}
+	return nil
+}
+
+// ListenDebug serves NewDebugHandler on addr, which must be a loopback
+// address, in the background.
+func ListenDebug(addr string) error {
+	if err := CheckLoopback(addr); err != nil {
+		return err
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	go http.Serve(l, NewDebugHandler())
+	return nil
+}
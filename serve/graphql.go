@@ -0,0 +1,1478 @@
+package serve
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/blkchain/blkchain/core"
+	"github.com/blkchain/blkchain/pg"
+)
+
+// A GraphQL endpoint at /api/graphql over the blocks, txs and
+// addresses, with their relations resolved to any depth: block → txs →
+// inputs → prevout → spentBy → tx and so on. The schema (query
+// { __typename } or see gqlSchema) is
+//
+//   type Query {
+//     block(hash: String, height: Int): Block
+//     blocks(height: Int = -1, limit: Int = 25): [Block]
+//     tx(txid: String!): Tx
+//     address(address: String!): Address
+//   }
+//   type Block {
+//     hash height version prevHash merkleRoot time bits nonce orphan
+//     size strippedSize weight txCount prev: Block
+//     txs(start: Int = 0, limit: Int = 25): [Tx]
+//   }
+//   type Tx {
+//     txid version lockTime size vsize weight block: Block
+//     inputs: [TxIn] outputs: [TxOut]
+//   }
+//   type TxIn {
+//     n coinbase prevTxid prevN scriptSig sequence witness
+//     prevout: TxOut tx: Tx
+//   }
+//   type TxOut { n value type scriptPubKey address spent spentBy: TxIn tx: Tx }
+//   type Address { address type balance txs(limit: Int = 25): [Tx] }
+//
+// Hashes and scripts are hex, values satoshis. It is the query part of
+// GraphQL only, without introspection: operations, variables,
+// fragments, aliases and @skip/@include. A query is checked before
+// running it: deeper than MaxQueryDepth or more expensive than
+// MaxQueryComplexity, the number of objects it could return (lists
+// count as their limit, inputs and outputs as gqlListEstimate), it is
+// refused.
+//
+// The resolvers are batched, as with a dataloader: a field is resolved
+// for all the objects at its level of the result with one query (see
+// pg/graphql.go), so the number of queries depends on the shape of the
+// query, not on the size of the result.
+
+const (
+	MaxQueryDepth      = 10
+	MaxQueryComplexity = 20000
+)
+
+// The size assumed for the lists without a limit.
+const gqlListEstimate = 10
+
+// The largest GraphQL request body.
+const maxGraphQLBody = 64 << 10
+
+func (s *Server) graphQL(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Query         string                 `json:"query"`
+		Variables     map[string]interface{} `json:"variables"`
+		OperationName string                 `json:"operationName"`
+	}
+	if r.Method == http.MethodPost {
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxGraphQLBody+1))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(body) > maxGraphQLBody {
+			http.Error(w, "Request too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err := json.Unmarshal(body, &req); err != nil {
+			writeGraphQL(w, http.StatusBadRequest, nil, fmt.Errorf("Invalid request: %v", err))
+			return
+		}
+	} else {
+		q := r.URL.Query()
+		req.Query, req.OperationName = q.Get("query"), q.Get("operationName")
+		if v := q.Get("variables"); v != "" {
+			if err := json.Unmarshal([]byte(v), &req.Variables); err != nil {
+				writeGraphQL(w, http.StatusBadRequest, nil, fmt.Errorf("Invalid variables: %v", err))
+				return
+			}
+		}
+	}
+
+	data, err := s.runGraphQL(req.Query, req.OperationName, req.Variables)
+	switch err.(type) {
+	case nil:
+		writeGraphQL(w, http.StatusOK, data, nil)
+	case *gqlError:
+		writeGraphQL(w, http.StatusBadRequest, nil, err)
+	default:
+		log.Printf("Error serving %s: %v", r.URL, err)
+		writeGraphQL(w, http.StatusInternalServerError, nil, fmt.Errorf("Internal server error"))
+	}
+}
+
+func writeGraphQL(w http.ResponseWriter, status int, data interface{}, err error) {
+	resp := map[string]interface{}{"data": data}
+	if err != nil {
+		resp["errors"] = []map[string]string{{"message": err.Error()}}
+	}
+	js, _ := json.Marshal(resp)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	fmt.Fprintln(w, string(js))
+}
+
+// A gqlError is an error in the request, as opposed to one of the
+// database.
+type gqlError struct{ msg string }
+
+func (e *gqlError) Error() string { return e.msg }
+
+func gqlErrorf(format string, args ...interface{}) error {
+	return &gqlError{fmt.Sprintf(format, args...)}
+}
+
+// runGraphQL parses, checks and executes the query, returning the data.
+func (s *Server) runGraphQL(query, operationName string, vars map[string]interface{}) (*gqlObject, error) {
+	doc, err := parseGraphQL(query)
+	if err != nil {
+		return nil, err
+	}
+	var op *gqlOperation
+	for _, o := range doc.ops {
+		if o.name == operationName || operationName == "" && len(doc.ops) == 1 {
+			op = o
+		}
+	}
+	if op == nil {
+		if operationName == "" {
+			return nil, gqlErrorf("Must provide operationName with several operations")
+		}
+		return nil, gqlErrorf("Unknown operation %q", operationName)
+	}
+
+	x := &gqlExec{s: s, frags: doc.frags, vars: make(map[string]interface{})}
+	for _, v := range op.vars {
+		val, ok := vars[v.name]
+		if !ok || val == nil {
+			val = v.dflt
+		}
+		if val == nil && v.nonNull {
+			return nil, gqlErrorf("Variable $%s is required", v.name)
+		}
+		x.vars[v.name] = val
+	}
+
+	plans, _, err := x.plan("Query", op.sel, 1, map[string]bool{})
+	if err != nil {
+		return nil, err
+	}
+	outs, err := x.execute("Query", []interface{}{gqlRoot{}}, plans)
+	if err != nil {
+		return nil, err
+	}
+	return outs[0], nil
+}
+
+// Parsing
+
+type gqlDocument struct {
+	ops   []*gqlOperation
+	frags map[string]*gqlFragment
+}
+
+type gqlOperation struct {
+	name string
+	vars []*gqlVarDef
+	sel  []*gqlSelection
+}
+
+type gqlVarDef struct {
+	name    string
+	nonNull bool
+	dflt    interface{}
+}
+
+type gqlFragment struct {
+	on  string
+	sel []*gqlSelection
+}
+
+type gqlDirective struct {
+	name string
+	args map[string]interface{}
+}
+
+// A gqlSelection is a field, a fragment spread (fragment != "") or an
+// inline fragment (inline).
+type gqlSelection struct {
+	alias, name string
+	args        map[string]interface{}
+	fragment    string
+	inline      bool
+	on          string // type condition of an inline fragment, "" = any
+	directives  []*gqlDirective
+	sel         []*gqlSelection
+}
+
+func (f *gqlSelection) key() string {
+	if f.alias != "" {
+		return f.alias
+	}
+	return f.name
+}
+
+// Values are nil, int64, float64, string, bool, gqlEnum, gqlVar,
+// []interface{} and map[string]interface{}.
+type gqlVar string
+type gqlEnum string
+
+// Token kinds.
+const (
+	gqlEOF = iota
+	gqlPunct
+	gqlName
+	gqlInt
+	gqlFloat
+	gqlString
+)
+
+type gqlParser struct {
+	src  string
+	pos  int
+	kind int
+	tok  string // the token, unquoted for strings
+	at   int    // position of the token
+}
+
+func parseGraphQL(src string) (*gqlDocument, error) {
+	p := &gqlParser{src: src}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	doc := &gqlDocument{frags: make(map[string]*gqlFragment)}
+	for p.kind != gqlEOF {
+		switch {
+		case p.is(gqlPunct, "{"):
+			sel, err := p.selectionSet()
+			if err != nil {
+				return nil, err
+			}
+			doc.ops = append(doc.ops, &gqlOperation{sel: sel})
+		case p.is(gqlName, "query"):
+			op, err := p.operation()
+			if err != nil {
+				return nil, err
+			}
+			doc.ops = append(doc.ops, op)
+		case p.is(gqlName, "fragment"):
+			name, f, err := p.fragment()
+			if err != nil {
+				return nil, err
+			}
+			if doc.frags[name] != nil {
+				return nil, gqlErrorf("Duplicate fragment %s", name)
+			}
+			doc.frags[name] = f
+		case p.is(gqlName, "mutation"), p.is(gqlName, "subscription"):
+			return nil, gqlErrorf("Only queries are supported")
+		default:
+			return nil, p.unexpected()
+		}
+	}
+	if len(doc.ops) == 0 {
+		return nil, gqlErrorf("No operation in the query")
+	}
+	return doc, nil
+}
+
+func (p *gqlParser) is(kind int, tok string) bool {
+	return p.kind == kind && p.tok == tok
+}
+
+func (p *gqlParser) unexpected() error {
+	if p.kind == gqlEOF {
+		return gqlErrorf("Syntax error: unexpected end of query")
+	}
+	return gqlErrorf("Syntax error at %d: unexpected %q", p.at, p.tok)
+}
+
+// expect skips the punctuator or name tok.
+func (p *gqlParser) expect(kind int, tok string) error {
+	if !p.is(kind, tok) {
+		return p.unexpected()
+	}
+	return p.next()
+}
+
+func (p *gqlParser) name() (string, error) {
+	if p.kind != gqlName {
+		return "", p.unexpected()
+	}
+	name := p.tok
+	return name, p.next()
+}
+
+// next reads the next token, skipping whitespace, commas and comments.
+func (p *gqlParser) next() error {
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		if c == '#' {
+			for p.pos < len(p.src) && p.src[p.pos] != '\n' {
+				p.pos++
+			}
+		} else if c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',' {
+			p.pos++
+		} else if strings.HasPrefix(p.src[p.pos:], "\uFEFF") {
+			p.pos += 3
+		} else {
+			break
+		}
+	}
+	p.at = p.pos
+	if p.pos == len(p.src) {
+		p.kind, p.tok = gqlEOF, ""
+		return nil
+	}
+
+	c, start := p.src[p.pos], p.pos
+	switch {
+	case strings.HasPrefix(p.src[p.pos:], "..."):
+		p.pos += 3
+		p.kind, p.tok = gqlPunct, "..."
+	case strings.IndexByte("!$()=:@[]{}|&", c) >= 0:
+		p.pos++
+		p.kind, p.tok = gqlPunct, string(c)
+	case c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+		for p.pos < len(p.src) && isNameByte(p.src[p.pos]) {
+			p.pos++
+		}
+		p.kind, p.tok = gqlName, p.src[start:p.pos]
+	case c == '-' || c >= '0' && c <= '9':
+		p.pos++
+		p.kind = gqlInt
+		for p.pos < len(p.src) {
+			c := p.src[p.pos]
+			if c == '.' || c == 'e' || c == 'E' {
+				p.kind = gqlFloat
+			} else if !(c >= '0' && c <= '9' || p.kind == gqlFloat && (c == '+' || c == '-')) {
+				break
+			}
+			p.pos++
+		}
+		p.tok = p.src[start:p.pos]
+	case c == '"':
+		s, err := p.str()
+		if err != nil {
+			return err
+		}
+		p.kind, p.tok = gqlString, s
+	default:
+		r, _ := utf8.DecodeRuneInString(p.src[p.pos:])
+		return gqlErrorf("Syntax error at %d: unexpected character %q", p.pos, r)
+	}
+	return nil
+}
+
+func isNameByte(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+// str reads a string, the escapes of GraphQL being those of JSON.
+// Block strings are not supported.
+func (p *gqlParser) str() (string, error) {
+	start := p.pos
+	for p.pos++; p.pos < len(p.src); p.pos++ {
+		switch p.src[p.pos] {
+		case '\\':
+			p.pos++
+		case '\n':
+			return "", gqlErrorf("Syntax error at %d: unterminated string", start)
+		case '"':
+			p.pos++
+			var s string
+			if err := json.Unmarshal([]byte(p.src[start:p.pos]), &s); err != nil {
+				return "", gqlErrorf("Syntax error at %d: invalid string", start)
+			}
+			return s, nil
+		}
+	}
+	return "", gqlErrorf("Syntax error at %d: unterminated string", start)
+}
+
+func (p *gqlParser) operation() (*gqlOperation, error) {
+	op := &gqlOperation{}
+	if err := p.next(); err != nil { // query
+		return nil, err
+	}
+	if p.kind == gqlName {
+		op.name = p.tok
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+	}
+	if p.is(gqlPunct, "(") {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		for !p.is(gqlPunct, ")") {
+			v, err := p.varDef()
+			if err != nil {
+				return nil, err
+			}
+			op.vars = append(op.vars, v)
+		}
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := p.directives(); err != nil {
+		return nil, err
+	}
+	sel, err := p.selectionSet()
+	if err != nil {
+		return nil, err
+	}
+	op.sel = sel
+	return op, nil
+}
+
+func (p *gqlParser) varDef() (*gqlVarDef, error) {
+	if err := p.expect(gqlPunct, "$"); err != nil {
+		return nil, err
+	}
+	name, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(gqlPunct, ":"); err != nil {
+		return nil, err
+	}
+	v := &gqlVarDef{name: name}
+	if v.nonNull, err = p.typeRef(); err != nil {
+		return nil, err
+	}
+	if p.is(gqlPunct, "=") {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		if v.dflt, err = p.value(true); err != nil {
+			return nil, err
+		}
+	}
+	return v, nil
+}
+
+// typeRef skips a type, returning whether it is non-null.
+func (p *gqlParser) typeRef() (bool, error) {
+	if p.is(gqlPunct, "[") {
+		if err := p.next(); err != nil {
+			return false, err
+		}
+		if _, err := p.typeRef(); err != nil {
+			return false, err
+		}
+		if err := p.expect(gqlPunct, "]"); err != nil {
+			return false, err
+		}
+	} else if _, err := p.name(); err != nil {
+		return false, err
+	}
+	if p.is(gqlPunct, "!") {
+		return true, p.next()
+	}
+	return false, nil
+}
+
+func (p *gqlParser) fragment() (string, *gqlFragment, error) {
+	if err := p.next(); err != nil { // fragment
+		return "", nil, err
+	}
+	name, err := p.name()
+	if err != nil {
+		return "", nil, err
+	}
+	if name == "on" {
+		return "", nil, gqlErrorf("Syntax error at %d: a fragment cannot be named on", p.at)
+	}
+	if err := p.expect(gqlName, "on"); err != nil {
+		return "", nil, err
+	}
+	f := &gqlFragment{}
+	if f.on, err = p.name(); err != nil {
+		return "", nil, err
+	}
+	if _, err := p.directives(); err != nil {
+		return "", nil, err
+	}
+	if f.sel, err = p.selectionSet(); err != nil {
+		return "", nil, err
+	}
+	return name, f, nil
+}
+
+func (p *gqlParser) selectionSet() ([]*gqlSelection, error) {
+	if err := p.expect(gqlPunct, "{"); err != nil {
+		return nil, err
+	}
+	var sels []*gqlSelection
+	for !p.is(gqlPunct, "}") {
+		s, err := p.selection()
+		if err != nil {
+			return nil, err
+		}
+		sels = append(sels, s)
+	}
+	if len(sels) == 0 {
+		return nil, gqlErrorf("Syntax error at %d: empty selection", p.at)
+	}
+	return sels, p.next()
+}
+
+func (p *gqlParser) selection() (*gqlSelection, error) {
+	s := &gqlSelection{}
+	var err error
+	if p.is(gqlPunct, "...") {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		if p.kind == gqlName && p.tok != "on" {
+			s.fragment = p.tok
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+			s.directives, err = p.directives()
+			return s, err
+		}
+		s.inline = true
+		if p.is(gqlName, "on") {
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+			if s.on, err = p.name(); err != nil {
+				return nil, err
+			}
+		}
+		if s.directives, err = p.directives(); err != nil {
+			return nil, err
+		}
+		s.sel, err = p.selectionSet()
+		return s, err
+	}
+
+	if s.name, err = p.name(); err != nil {
+		return nil, err
+	}
+	if p.is(gqlPunct, ":") {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		s.alias = s.name
+		if s.name, err = p.name(); err != nil {
+			return nil, err
+		}
+	}
+	if s.args, err = p.arguments(); err != nil {
+		return nil, err
+	}
+	if s.directives, err = p.directives(); err != nil {
+		return nil, err
+	}
+	if p.is(gqlPunct, "{") {
+		if s.sel, err = p.selectionSet(); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func (p *gqlParser) arguments() (map[string]interface{}, error) {
+	args := make(map[string]interface{})
+	if !p.is(gqlPunct, "(") {
+		return args, nil
+	}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	for !p.is(gqlPunct, ")") {
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(gqlPunct, ":"); err != nil {
+			return nil, err
+		}
+		if _, ok := args[name]; ok {
+			return nil, gqlErrorf("Duplicate argument %s", name)
+		}
+		if args[name], err = p.value(false); err != nil {
+			return nil, err
+		}
+	}
+	return args, p.next()
+}
+
+func (p *gqlParser) directives() ([]*gqlDirective, error) {
+	var ds []*gqlDirective
+	for p.is(gqlPunct, "@") {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		args, err := p.arguments()
+		if err != nil {
+			return nil, err
+		}
+		ds = append(ds, &gqlDirective{name: name, args: args})
+	}
+	return ds, nil
+}
+
+// value reads a value, without variables if constant.
+func (p *gqlParser) value(constant bool) (interface{}, error) {
+	var v interface{}
+	switch p.kind {
+	case gqlInt:
+		i, err := strconv.ParseInt(p.tok, 10, 64)
+		if err != nil {
+			return nil, gqlErrorf("Syntax error at %d: invalid integer %s", p.at, p.tok)
+		}
+		v = i
+	case gqlFloat:
+		f, err := strconv.ParseFloat(p.tok, 64)
+		if err != nil {
+			return nil, gqlErrorf("Syntax error at %d: invalid number %s", p.at, p.tok)
+		}
+		v = f
+	case gqlString:
+		v = p.tok
+	case gqlName:
+		switch p.tok {
+		case "true", "false":
+			v = p.tok == "true"
+		case "null":
+			v = nil
+		default:
+			v = gqlEnum(p.tok)
+		}
+	case gqlPunct:
+		switch p.tok {
+		case "$":
+			if constant {
+				return nil, p.unexpected()
+			}
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+			name, err := p.name()
+			return gqlVar(name), err
+		case "[":
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+			list := []interface{}{}
+			for !p.is(gqlPunct, "]") {
+				item, err := p.value(constant)
+				if err != nil {
+					return nil, err
+				}
+				list = append(list, item)
+			}
+			return list, p.next()
+		case "{":
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+			obj := make(map[string]interface{})
+			for !p.is(gqlPunct, "}") {
+				name, err := p.name()
+				if err != nil {
+					return nil, err
+				}
+				if err := p.expect(gqlPunct, ":"); err != nil {
+					return nil, err
+				}
+				if obj[name], err = p.value(constant); err != nil {
+					return nil, err
+				}
+			}
+			return obj, p.next()
+		default:
+			return nil, p.unexpected()
+		}
+	default:
+		return nil, p.unexpected()
+	}
+	return v, p.next()
+}
+
+// Execution
+
+// gqlObject is an object of the result, its fields in query order.
+type gqlObject struct {
+	keys []string
+	vals []interface{}
+}
+
+func (o *gqlObject) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, k := range o.keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		kj, _ := json.Marshal(k)
+		b.Write(kj)
+		b.WriteByte(':')
+		vj, err := json.Marshal(o.vals[i])
+		if err != nil {
+			return nil, err
+		}
+		b.Write(vj)
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
+
+// A gqlPlan is a field to resolve, checked against the schema, with its
+// arguments and subfields.
+type gqlPlan struct {
+	key, name string
+	def       *gqlFieldDef // nil for __typename
+	args      map[string]interface{}
+	sub       []*gqlPlan
+}
+
+type gqlExec struct {
+	s     *Server
+	frags map[string]*gqlFragment
+	vars  map[string]interface{}
+}
+
+// plan checks the selection on the type and returns the fields to
+// resolve and the complexity of the selection.
+func (x *gqlExec) plan(typ string, sels []*gqlSelection, depth int, spreading map[string]bool) ([]*gqlPlan, int, error) {
+	if depth > MaxQueryDepth {
+		return nil, 0, gqlErrorf("Query too deep (the maximum depth is %d)", MaxQueryDepth)
+	}
+	var (
+		fields [][]*gqlSelection // by key
+		index  = make(map[string]int)
+	)
+	if err := x.collect(typ, sels, &fields, index, spreading); err != nil {
+		return nil, 0, err
+	}
+
+	var (
+		plans []*gqlPlan
+		cost  int
+	)
+	for _, fs := range fields {
+		f := fs[0]
+		p := &gqlPlan{key: f.key(), name: f.name}
+		plans = append(plans, p)
+		if f.name == "__typename" {
+			continue
+		}
+		p.def = gqlSchema[typ][f.name]
+		if p.def == nil {
+			return nil, 0, gqlErrorf("Cannot query field %q on type %s", f.name, typ)
+		}
+		for _, other := range fs[1:] {
+			if other.name != f.name {
+				return nil, 0, gqlErrorf("Fields %q conflict: %s and %s", f.key(), f.name, other.name)
+			}
+		}
+		var err error
+		if p.args, err = x.arguments(typ, f, p.def); err != nil {
+			return nil, 0, err
+		}
+		if p.def.typ == "" {
+			if f.sel != nil {
+				return nil, 0, gqlErrorf("Field %s of type %s cannot have a selection", f.name, typ)
+			}
+			cost++
+			continue
+		}
+		var sub []*gqlSelection
+		for _, other := range fs {
+			sub = append(sub, other.sel...)
+		}
+		if len(sub) == 0 {
+			return nil, 0, gqlErrorf("Field %s of type %s must have a selection", f.name, typ)
+		}
+		var subCost int
+		if p.sub, subCost, err = x.plan(p.def.typ, sub, depth+1, spreading); err != nil {
+			return nil, 0, err
+		}
+		n := 1
+		if p.def.list {
+			n = gqlListEstimate
+			if limit, ok := p.args["limit"].(int); ok {
+				n = limit
+			}
+		}
+		cost += 1 + n*subCost
+		if cost > MaxQueryComplexity {
+			return nil, 0, gqlErrorf("Query too complex (the maximum complexity is %d)", MaxQueryComplexity)
+		}
+	}
+	return plans, cost, nil
+}
+
+// collect gathers the fields of the selection by response key,
+// expanding the fragments and applying @skip and @include.
+func (x *gqlExec) collect(typ string, sels []*gqlSelection, fields *[][]*gqlSelection, index map[string]int, spreading map[string]bool) error {
+	for _, s := range sels {
+		if ok, err := x.included(s.directives); err != nil {
+			return err
+		} else if !ok {
+			continue
+		}
+		switch {
+		case s.fragment != "":
+			f := x.frags[s.fragment]
+			if f == nil {
+				return gqlErrorf("Unknown fragment %s", s.fragment)
+			}
+			if gqlSchema[f.on] == nil {
+				return gqlErrorf("Unknown type %s", f.on)
+			}
+			if f.on != typ {
+				continue
+			}
+			if spreading[s.fragment] {
+				return gqlErrorf("Fragment %s spreads itself", s.fragment)
+			}
+			spreading[s.fragment] = true
+			err := x.collect(typ, f.sel, fields, index, spreading)
+			delete(spreading, s.fragment)
+			if err != nil {
+				return err
+			}
+		case s.inline:
+			if s.on != "" && gqlSchema[s.on] == nil {
+				return gqlErrorf("Unknown type %s", s.on)
+			}
+			if s.on != "" && s.on != typ {
+				continue
+			}
+			if err := x.collect(typ, s.sel, fields, index, spreading); err != nil {
+				return err
+			}
+		default:
+			if i, ok := index[s.key()]; ok {
+				(*fields)[i] = append((*fields)[i], s)
+			} else {
+				index[s.key()] = len(*fields)
+				*fields = append(*fields, []*gqlSelection{s})
+			}
+		}
+	}
+	return nil
+}
+
+func (x *gqlExec) included(ds []*gqlDirective) (bool, error) {
+	for _, d := range ds {
+		if d.name != "skip" && d.name != "include" {
+			return false, gqlErrorf("Unknown directive @%s", d.name)
+		}
+		v, err := x.resolve(d.args["if"])
+		if err != nil {
+			return false, err
+		}
+		b, ok := v.(bool)
+		if !ok {
+			return false, gqlErrorf("@%s needs a Boolean if argument", d.name)
+		}
+		if b == (d.name == "skip") {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// resolve replaces the variables of the value with their values.
+func (x *gqlExec) resolve(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case gqlVar:
+		val, ok := x.vars[string(v)]
+		if !ok {
+			return nil, gqlErrorf("Variable $%s is not defined", v)
+		}
+		return val, nil
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i, item := range v {
+			var err error
+			if list[i], err = x.resolve(item); err != nil {
+				return nil, err
+			}
+		}
+		return list, nil
+	case map[string]interface{}:
+		obj := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			var err error
+			if obj[k], err = x.resolve(item); err != nil {
+				return nil, err
+			}
+		}
+		return obj, nil
+	}
+	return v, nil
+}
+
+// arguments returns the arguments of the field coerced to their types
+// (int or string), with the defaults, nil if not given and no default.
+func (x *gqlExec) arguments(typ string, f *gqlSelection, def *gqlFieldDef) (map[string]interface{}, error) {
+	for name := range f.args {
+		if _, ok := def.args[name]; !ok {
+			return nil, gqlErrorf("Unknown argument %s of %s.%s", name, typ, f.name)
+		}
+	}
+	args := make(map[string]interface{}, len(def.args))
+	for name, a := range def.args {
+		v, err := x.resolve(f.args[name])
+		if err != nil {
+			return nil, err
+		}
+		if v == nil {
+			if a.required {
+				return nil, gqlErrorf("Argument %s of %s.%s is required", name, typ, f.name)
+			}
+			args[name] = a.dflt
+			continue
+		}
+		switch a.typ {
+		case "Int":
+			switch n := v.(type) {
+			case int64:
+				args[name] = int(n)
+			case float64: // from the JSON variables
+				if n != float64(int32(n)) {
+					return nil, gqlErrorf("Argument %s of %s.%s is not an Int", name, typ, f.name)
+				}
+				args[name] = int(n)
+			default:
+				return nil, gqlErrorf("Argument %s of %s.%s is not an Int", name, typ, f.name)
+			}
+			if name == "limit" && (args[name].(int) < 1 || args[name].(int) > MaxLimit) {
+				return nil, gqlErrorf("Invalid limit: %d (must be 1 to %d)", args[name], MaxLimit)
+			}
+		case "String":
+			s, ok := v.(string)
+			if !ok {
+				return nil, gqlErrorf("Argument %s of %s.%s is not a String", name, typ, f.name)
+			}
+			args[name] = s
+		}
+	}
+	return args, nil
+}
+
+// execute resolves the fields of the objects of the type, returning an
+// object of the result for each (nil for a nil one). Each field is
+// resolved for all the objects at once, and the objects of an object
+// field are executed together.
+func (x *gqlExec) execute(typ string, objs []interface{}, plans []*gqlPlan) ([]*gqlObject, error) {
+	outs := make([]*gqlObject, len(objs))
+	var live []interface{}
+	var liveOuts []*gqlObject
+	for i, o := range objs {
+		if o == nil {
+			continue
+		}
+		outs[i] = &gqlObject{vals: make([]interface{}, len(plans))}
+		for _, p := range plans {
+			outs[i].keys = append(outs[i].keys, p.key)
+		}
+		live, liveOuts = append(live, o), append(liveOuts, outs[i])
+	}
+	if len(live) == 0 {
+		return outs, nil
+	}
+
+	for j, p := range plans {
+		if p.def == nil {
+			for _, out := range liveOuts {
+				out.vals[j] = typ
+			}
+			continue
+		}
+		vals, err := p.def.resolve(x, live, p.args)
+		if err != nil {
+			return nil, err
+		}
+		if p.def.typ == "" {
+			for i, out := range liveOuts {
+				out.vals[j] = vals[i]
+			}
+			continue
+		}
+		if !p.def.list {
+			subs, err := x.execute(p.def.typ, vals, p.sub)
+			if err != nil {
+				return nil, err
+			}
+			for i, out := range liveOuts {
+				if subs[i] != nil {
+					out.vals[j] = subs[i]
+				}
+			}
+			continue
+		}
+		var all []interface{}
+		for _, v := range vals {
+			list, _ := v.([]interface{})
+			all = append(all, list...)
+		}
+		subs, err := x.execute(p.def.typ, all, p.sub)
+		if err != nil {
+			return nil, err
+		}
+		for i, out := range liveOuts {
+			list, _ := vals[i].([]interface{})
+			items := make([]*gqlObject, len(list))
+			copy(items, subs[:len(list)])
+			subs = subs[len(list):]
+			out.vals[j] = items
+		}
+	}
+	return outs, nil
+}
+
+// Schema
+
+type gqlArg struct {
+	typ      string // Int or String
+	required bool
+	dflt     interface{}
+}
+
+// A gqlFieldDef resolves a field for a batch of objects, one value per
+// object: a scalar, an object of typ (nil = null), or a list of them.
+type gqlFieldDef struct {
+	typ     string // "" for scalars
+	list    bool
+	args    map[string]gqlArg
+	resolve func(x *gqlExec, objs []interface{}, args map[string]interface{}) ([]interface{}, error)
+}
+
+type gqlRoot struct{}
+
+type gqlAddress struct {
+	info *pg.AddressInfo
+}
+
+// scalar makes the resolver of a scalar field computed from the
+// object alone.
+func scalar(f func(obj interface{}) interface{}) *gqlFieldDef {
+	return &gqlFieldDef{resolve: func(x *gqlExec, objs []interface{}, args map[string]interface{}) ([]interface{}, error) {
+		vals := make([]interface{}, len(objs))
+		for i, o := range objs {
+			vals[i] = f(o)
+		}
+		return vals, nil
+	}}
+}
+
+func hexOrNil(b []byte) interface{} {
+	if b == nil {
+		return nil
+	}
+	return hex.EncodeToString(b)
+}
+
+var (
+	limitArg  = gqlArg{typ: "Int", dflt: DefaultLimit}
+	heightArg = gqlArg{typ: "Int", dflt: -1}
+)
+
+var gqlSchema = map[string]map[string]*gqlFieldDef{
+	"Query": {
+		"block": {typ: "Block", args: map[string]gqlArg{"hash": {typ: "String"}, "height": {typ: "Int"}}, resolve: gqlQueryBlock},
+		"blocks": {typ: "Block", list: true, args: map[string]gqlArg{"height": heightArg, "limit": limitArg},
+			resolve: gqlQueryBlocks},
+		"tx":      {typ: "Tx", args: map[string]gqlArg{"txid": {typ: "String", required: true}}, resolve: gqlQueryTx},
+		"address": {typ: "Address", args: map[string]gqlArg{"address": {typ: "String", required: true}}, resolve: gqlQueryAddress},
+	},
+	"Block": {
+		"hash":         scalar(func(o interface{}) interface{} { return o.(*pg.QLBlock).Hash.String() }),
+		"height":       scalar(func(o interface{}) interface{} { return o.(*pg.QLBlock).Height }),
+		"version":      scalar(func(o interface{}) interface{} { return o.(*pg.QLBlock).Version }),
+		"prevHash":     scalar(func(o interface{}) interface{} { return o.(*pg.QLBlock).PrevHash.String() }),
+		"merkleRoot":   scalar(func(o interface{}) interface{} { return o.(*pg.QLBlock).MerkleRoot.String() }),
+		"time":         scalar(func(o interface{}) interface{} { return o.(*pg.QLBlock).Time }),
+		"bits":         scalar(func(o interface{}) interface{} { return fmt.Sprintf("%08x", uint32(o.(*pg.QLBlock).Bits)) }),
+		"nonce":        scalar(func(o interface{}) interface{} { return o.(*pg.QLBlock).Nonce }),
+		"orphan":       scalar(func(o interface{}) interface{} { return o.(*pg.QLBlock).Orphan }),
+		"size":         scalar(func(o interface{}) interface{} { return o.(*pg.QLBlock).Size }),
+		"strippedSize": scalar(func(o interface{}) interface{} { return o.(*pg.QLBlock).BaseSize }),
+		"weight":       scalar(func(o interface{}) interface{} { return o.(*pg.QLBlock).Weight }),
+		"txCount":      scalar(func(o interface{}) interface{} { return o.(*pg.QLBlock).NTx }),
+		"prev": {typ: "Block", resolve: func(x *gqlExec, objs []interface{}, args map[string]interface{}) ([]interface{}, error) {
+			return x.blocksById(objs, func(o interface{}) *int64 { return o.(*pg.QLBlock).PrevBlockId })
+		}},
+		"txs": {typ: "Tx", list: true, args: map[string]gqlArg{"start": {typ: "Int", dflt: 0}, "limit": limitArg},
+			resolve: gqlBlockTxs},
+	},
+	"Tx": {
+		"txid":     scalar(func(o interface{}) interface{} { return o.(*pg.QLTx).Txid.String() }),
+		"version":  scalar(func(o interface{}) interface{} { return o.(*pg.QLTx).Version }),
+		"lockTime": scalar(func(o interface{}) interface{} { return o.(*pg.QLTx).LockTime }),
+		"size":     scalar(func(o interface{}) interface{} { return o.(*pg.QLTx).Size }),
+		"vsize":    scalar(func(o interface{}) interface{} { return o.(*pg.QLTx).VSize }),
+		"weight":   scalar(func(o interface{}) interface{} { return o.(*pg.QLTx).Weight }),
+		"block": {typ: "Block", resolve: func(x *gqlExec, objs []interface{}, args map[string]interface{}) ([]interface{}, error) {
+			return x.blocksById(objs, func(o interface{}) *int64 { return o.(*pg.QLTx).BlockId })
+		}},
+		"inputs":  {typ: "TxIn", list: true, resolve: gqlTxInputs},
+		"outputs": {typ: "TxOut", list: true, resolve: gqlTxOutputs},
+	},
+	"TxIn": {
+		"n":        scalar(func(o interface{}) interface{} { return o.(*pg.QLTxIn).N }),
+		"coinbase": scalar(func(o interface{}) interface{} { return o.(*pg.QLTxIn).PrevoutTxId == nil }),
+		"prevTxid": scalar(func(o interface{}) interface{} {
+			if i := o.(*pg.QLTxIn); i.PrevoutTxid != nil {
+				return core.Uint256FromBytes(i.PrevoutTxid).String()
+			}
+			return nil
+		}),
+		"prevN": scalar(func(o interface{}) interface{} {
+			if i := o.(*pg.QLTxIn); i.PrevoutTxId != nil {
+				return i.PrevoutN
+			}
+			return nil
+		}),
+		"scriptSig": scalar(func(o interface{}) interface{} { return hex.EncodeToString(o.(*pg.QLTxIn).ScriptSig) }),
+		"sequence":  scalar(func(o interface{}) interface{} { return o.(*pg.QLTxIn).Sequence }),
+		"witness":   {resolve: gqlTxInWitness},
+		"prevout":   {typ: "TxOut", resolve: gqlTxInPrevout},
+		"tx": {typ: "Tx", resolve: func(x *gqlExec, objs []interface{}, args map[string]interface{}) ([]interface{}, error) {
+			return x.txsById(objs, func(o interface{}) int64 { return o.(*pg.QLTxIn).TxId })
+		}},
+	},
+	"TxOut": {
+		"n":            scalar(func(o interface{}) interface{} { return o.(*pg.QLTxOut).N }),
+		"value":        scalar(func(o interface{}) interface{} { return o.(*pg.QLTxOut).Value }),
+		"type":         scalar(func(o interface{}) interface{} { return rpcScriptType(o.(*pg.QLTxOut).ScriptPubKey) }),
+		"scriptPubKey": scalar(func(o interface{}) interface{} { return hexOrNil(o.(*pg.QLTxOut).ScriptPubKey) }),
+		"address": scalar(func(o interface{}) interface{} {
+			if a := core.ScriptAddress(o.(*pg.QLTxOut).ScriptPubKey, core.MainNetAddressParams); a != "" {
+				return a
+			}
+			return nil
+		}),
+		"spent":   scalar(func(o interface{}) interface{} { return o.(*pg.QLTxOut).Spent }),
+		"spentBy": {typ: "TxIn", resolve: gqlTxOutSpentBy},
+		"tx": {typ: "Tx", resolve: func(x *gqlExec, objs []interface{}, args map[string]interface{}) ([]interface{}, error) {
+			return x.txsById(objs, func(o interface{}) int64 { return o.(*pg.QLTxOut).TxId })
+		}},
+	},
+	"Address": {
+		"address": scalar(func(o interface{}) interface{} { return o.(*gqlAddress).info.Address }),
+		"type":    scalar(func(o interface{}) interface{} { return o.(*gqlAddress).info.Type }),
+		"balance": {resolve: gqlAddressBalance},
+		"txs":     {typ: "Tx", list: true, args: map[string]gqlArg{"limit": limitArg}, resolve: gqlAddressTxs},
+	},
+}
+
+// The resolvers, the values of the ids being loaded with one query.
+
+func blocksAsList(blocks map[int64]*pg.QLBlock, ids []int64) []interface{} {
+	list := make([]interface{}, 0, len(ids))
+	for _, id := range ids {
+		if b := blocks[id]; b != nil {
+			list = append(list, b)
+		}
+	}
+	return list
+}
+
+func txsAsList(txs map[int64]*pg.QLTx, ids []int64) []interface{} {
+	list := make([]interface{}, 0, len(ids))
+	for _, id := range ids {
+		if t := txs[id]; t != nil {
+			list = append(list, t)
+		}
+	}
+	return list
+}
+
+// blocksById resolves a Block field from the block ids of the objects.
+func (x *gqlExec) blocksById(objs []interface{}, id func(interface{}) *int64) ([]interface{}, error) {
+	var ids []int64
+	for _, o := range objs {
+		if i := id(o); i != nil {
+			ids = append(ids, *i)
+		}
+	}
+	blocks, err := x.s.e.QLBlocks(ids)
+	if err != nil {
+		return nil, err
+	}
+	vals := make([]interface{}, len(objs))
+	for i, o := range objs {
+		if id := id(o); id != nil && blocks[*id] != nil {
+			vals[i] = blocks[*id]
+		}
+	}
+	return vals, nil
+}
+
+// txsById resolves a Tx field from the tx ids of the objects.
+func (x *gqlExec) txsById(objs []interface{}, id func(interface{}) int64) ([]interface{}, error) {
+	ids := make([]int64, len(objs))
+	for i, o := range objs {
+		ids[i] = id(o)
+	}
+	txs, err := x.s.e.QLTxs(ids)
+	if err != nil {
+		return nil, err
+	}
+	vals := make([]interface{}, len(objs))
+	for i, id := range ids {
+		if txs[id] != nil {
+			vals[i] = txs[id]
+		}
+	}
+	return vals, nil
+}
+
+func gqlQueryBlock(x *gqlExec, objs []interface{}, args map[string]interface{}) ([]interface{}, error) {
+	var id int64
+	if hash, ok := args["hash"].(string); ok {
+		h, err := core.Uint256FromString(hash)
+		if err != nil {
+			return nil, gqlErrorf("Invalid block hash: %v", err)
+		}
+		if id, err = x.s.e.QLBlockId(h); err == sql.ErrNoRows {
+			return []interface{}{nil}, nil
+		} else if err != nil {
+			return nil, err
+		}
+	} else if height, ok := args["height"].(int); ok && height >= 0 {
+		ids, err := x.s.e.QLMainChainBlockIds(height, 1)
+		if err != nil {
+			return nil, err
+		}
+		if len(ids) == 0 {
+			return []interface{}{nil}, nil
+		}
+		id = ids[0]
+	} else {
+		return nil, gqlErrorf("block needs a hash or a height")
+	}
+	blocks, err := x.s.e.QLBlocks([]int64{id})
+	if err != nil {
+		return nil, err
+	}
+	if b := blocks[id]; b != nil && (args["height"] == nil || b.Height == args["height"].(int)) {
+		return []interface{}{b}, nil
+	}
+	return []interface{}{nil}, nil
+}
+
+func gqlQueryBlocks(x *gqlExec, objs []interface{}, args map[string]interface{}) ([]interface{}, error) {
+	ids, err := x.s.e.QLMainChainBlockIds(args["height"].(int), args["limit"].(int))
+	if err != nil {
+		return nil, err
+	}
+	blocks, err := x.s.e.QLBlocks(ids)
+	if err != nil {
+		return nil, err
+	}
+	return []interface{}{blocksAsList(blocks, ids)}, nil
+}
+
+func gqlQueryTx(x *gqlExec, objs []interface{}, args map[string]interface{}) ([]interface{}, error) {
+	txid, err := core.Uint256FromString(args["txid"].(string))
+	if err != nil {
+		return nil, gqlErrorf("Invalid txid: %v", err)
+	}
+	id, err := x.s.e.QLTxId(txid)
+	if err == sql.ErrNoRows {
+		return []interface{}{nil}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return x.txsById([]interface{}{id}, func(o interface{}) int64 { return o.(int64) })
+}
+
+func gqlQueryAddress(x *gqlExec, objs []interface{}, args map[string]interface{}) ([]interface{}, error) {
+	info, err := pg.ParseAddress(args["address"].(string))
+	if err != nil {
+		return nil, gqlErrorf("Invalid address: %v", err)
+	}
+	return []interface{}{&gqlAddress{info: info}}, nil
+}
+
+func gqlBlockTxs(x *gqlExec, objs []interface{}, args map[string]interface{}) ([]interface{}, error) {
+	blockIds := make([]int64, len(objs))
+	for i, o := range objs {
+		blockIds[i] = o.(*pg.QLBlock).Id
+	}
+	txIds, err := x.s.e.QLBlockTxIds(blockIds, args["start"].(int), args["limit"].(int))
+	if err != nil {
+		return nil, err
+	}
+	var all []int64
+	for _, ids := range txIds {
+		all = append(all, ids...)
+	}
+	txs, err := x.s.e.QLTxs(all)
+	if err != nil {
+		return nil, err
+	}
+	vals := make([]interface{}, len(objs))
+	for i, id := range blockIds {
+		vals[i] = txsAsList(txs, txIds[id])
+	}
+	return vals, nil
+}
+
+func txIdsOf(objs []interface{}) []int64 {
+	ids := make([]int64, len(objs))
+	for i, o := range objs {
+		ids[i] = o.(*pg.QLTx).Id
+	}
+	return ids
+}
+
+func gqlTxInputs(x *gqlExec, objs []interface{}, args map[string]interface{}) ([]interface{}, error) {
+	ins, err := x.s.e.QLTxIns(txIdsOf(objs))
+	if err != nil {
+		return nil, err
+	}
+	vals := make([]interface{}, len(objs))
+	for i, o := range objs {
+		list := []interface{}{}
+		for _, in := range ins[o.(*pg.QLTx).Id] {
+			list = append(list, in)
+		}
+		vals[i] = list
+	}
+	return vals, nil
+}
+
+func gqlTxOutputs(x *gqlExec, objs []interface{}, args map[string]interface{}) ([]interface{}, error) {
+	outs, err := x.s.e.QLTxOuts(txIdsOf(objs))
+	if err != nil {
+		return nil, err
+	}
+	vals := make([]interface{}, len(objs))
+	for i, o := range objs {
+		list := []interface{}{}
+		for _, out := range outs[o.(*pg.QLTx).Id] {
+			list = append(list, out)
+		}
+		vals[i] = list
+	}
+	return vals, nil
+}
+
+func gqlTxInWitness(x *gqlExec, objs []interface{}, args map[string]interface{}) ([]interface{}, error) {
+	vals := make([]interface{}, len(objs))
+	for i, o := range objs {
+		in := o.(*pg.QLTxIn)
+		items := []string{}
+		if in.Witness != nil {
+			var wit core.Witness
+			if err := core.BinRead(&wit, bytes.NewReader(in.Witness)); err != nil {
+				return nil, fmt.Errorf("Witness of input %d of tx %d: %v", in.N, in.TxId, err)
+			}
+			for _, item := range wit {
+				items = append(items, hex.EncodeToString(item))
+			}
+		}
+		vals[i] = items
+	}
+	return vals, nil
+}
+
+func gqlTxInPrevout(x *gqlExec, objs []interface{}, args map[string]interface{}) ([]interface{}, error) {
+	var points []pg.QLOutPoint
+	for _, o := range objs {
+		if in := o.(*pg.QLTxIn); in.PrevoutTxId != nil {
+			points = append(points, pg.QLOutPoint{TxId: *in.PrevoutTxId, N: in.PrevoutN})
+		}
+	}
+	outs, err := x.s.e.QLTxOutsAt(points)
+	if err != nil {
+		return nil, err
+	}
+	vals := make([]interface{}, len(objs))
+	for i, o := range objs {
+		if in := o.(*pg.QLTxIn); in.PrevoutTxId != nil {
+			if out := outs[pg.QLOutPoint{TxId: *in.PrevoutTxId, N: in.PrevoutN}]; out != nil {
+				vals[i] = out
+			}
+		}
+	}
+	return vals, nil
+}
+
+func gqlTxOutSpentBy(x *gqlExec, objs []interface{}, args map[string]interface{}) ([]interface{}, error) {
+	var points []pg.QLOutPoint
+	for _, o := range objs {
+		if out := o.(*pg.QLTxOut); out.Spent {
+			points = append(points, pg.QLOutPoint{TxId: out.TxId, N: out.N})
+		}
+	}
+	ins, err := x.s.e.QLSpendingTxIns(points)
+	if err != nil {
+		return nil, err
+	}
+	vals := make([]interface{}, len(objs))
+	for i, o := range objs {
+		out := o.(*pg.QLTxOut)
+		if in := ins[pg.QLOutPoint{TxId: out.TxId, N: out.N}]; in != nil {
+			vals[i] = in
+		}
+	}
+	return vals, nil
+}
+
+// The balance is a query per address, there are few addresses in a
+// query.
+func gqlAddressBalance(x *gqlExec, objs []interface{}, args map[string]interface{}) ([]interface{}, error) {
+	vals := make([]interface{}, len(objs))
+	for i, o := range objs {
+		if key := o.(*gqlAddress).info.Key(); key != nil {
+			balance, _, err := x.s.e.SelectAddrBalanceAt(key, pg.AsOfTip, false)
+			if err != nil {
+				return nil, err
+			}
+			vals[i] = balance
+		}
+	}
+	return vals, nil
+}
+
+func gqlAddressTxs(x *gqlExec, objs []interface{}, args map[string]interface{}) ([]interface{}, error) {
+	txIds := make([][]int64, len(objs))
+	var all []int64
+	for i, o := range objs {
+		if key := o.(*gqlAddress).info.Key(); key != nil {
+			ids, err := x.s.e.QLAddrTxIds(key, args["limit"].(int))
+			if err != nil {
+				return nil, err
+			}
+			txIds[i] = ids
+			all = append(all, ids...)
+		}
+	}
+	txs, err := x.s.e.QLTxs(all)
+	if err != nil {
+		return nil, err
+	}
+	vals := make([]interface{}, len(objs))
+	for i := range objs {
+		vals[i] = txsAsList(txs, txIds[i])
+	}
+	return vals, nil
+}
@@ -0,0 +1,235 @@
+package serve
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/blkchain/blkchain/pg"
+)
+
+// Live updates over WebSocket at /api/ws (if enabled, see EnablePush):
+// the new blocks and the changes to watched addresses, as the import
+// follows the chain (see pg/notify.go), so that a frontend does not
+// have to poll. A client subscribes with messages such as
+//
+//   {"subscribe": "blocks"}
+//   {"subscribe": "address", "address": "bc1q..."}
+//   {"unsubscribe": "address", "address": "bc1q..."}
+//
+// each answered with {"subscribed": ...} (or "unsubscribed") or
+// {"error": ...}, and is
+// pushed
+//
+//   {"type": "block", "hash": ..., "height": ..., "time": ..., "txs": ...}
+//   {"type": "address", "address": ..., "hash": ..., "height": ...,
+//    "txid": ..., "n": ..., "spending": ..., "value": ...}
+//
+// for every new block and every output to or spend from a watched
+// address in it (n is the input if spending, value negative). A block
+// orphaned later is not retracted, the next block notification tells
+// of the new tip. A client too slow to take its messages is dropped.
+
+// The addresses a connection can watch.
+const MaxWatchedAddresses = 1000
+
+// Messages queued for a client before it is dropped.
+const pushQueue = 256
+
+const pushPingInterval = 30 * time.Second
+
+type pushHub struct {
+	sync.Mutex
+	e       *pg.Explorer
+	clients map[*pushClient]bool
+}
+
+type pushClient struct {
+	ws     *wsConn
+	send   chan []byte
+	blocks bool
+	addrs  map[string]string // address by key (see pg.AddressKey)
+}
+
+// EnablePush adds the WebSocket endpoint and listens for new blocks.
+func (s *Server) EnablePush() {
+	s.push = &pushHub{e: s.e, clients: make(map[*pushClient]bool)}
+	s.mux.HandleFunc("/api/ws", s.ws)
+	s.onNewBlock(s.push.newBlock)
+}
+
+func (s *Server) ws(w http.ResponseWriter, r *http.Request) {
+	ws, err := wsUpgrade(w, r)
+	if err != nil {
+		return
+	}
+	c := &pushClient{ws: ws, send: make(chan []byte, pushQueue), addrs: make(map[string]string)}
+	s.push.add(c)
+	defer s.push.remove(c)
+
+	go c.writeLoop()
+	for {
+		msg, err := ws.readMessage()
+		if err != nil {
+			return
+		}
+		s.push.request(c, msg)
+	}
+}
+
+func (c *pushClient) writeLoop() {
+	ping := time.NewTicker(pushPingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case msg, ok := <-c.send:
+			if !ok {
+				c.ws.write(wsClose, nil)
+				c.ws.close()
+				return
+			}
+			if err := c.ws.write(wsText, msg); err != nil {
+				c.ws.close()
+				return
+			}
+		case <-ping.C:
+			if err := c.ws.write(wsPing, nil); err != nil {
+				c.ws.close()
+				return
+			}
+		}
+	}
+}
+
+func (h *pushHub) add(c *pushClient) {
+	h.Lock()
+	defer h.Unlock()
+	h.clients[c] = true
+}
+
+func (h *pushHub) remove(c *pushClient) {
+	h.Lock()
+	defer h.Unlock()
+	if h.clients[c] {
+		delete(h.clients, c)
+		close(c.send)
+	}
+}
+
+// queue queues the message for the client, dropping the client if its
+// queue is full. The hub must be locked.
+func (h *pushHub) queue(c *pushClient, v interface{}) {
+	if !h.clients[c] {
+		return // dropped
+	}
+	msg, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("Error encoding push message: %v", err)
+		return
+	}
+	select {
+	case c.send <- msg:
+	default:
+		delete(h.clients, c)
+		close(c.send)
+	}
+}
+
+// request handles a subscription message of the client.
+func (h *pushHub) request(c *pushClient, msg []byte) {
+	var req struct {
+		Subscribe   string `json:"subscribe"`
+		Unsubscribe string `json:"unsubscribe"`
+		Address     string `json:"address"`
+	}
+	h.Lock()
+	defer h.Unlock()
+	reply := func(err error) {
+		if err != nil {
+			h.queue(c, map[string]string{"error": err.Error()})
+			return
+		}
+		r := map[string]string{"subscribed": req.Subscribe}
+		if req.Unsubscribe != "" {
+			r = map[string]string{"unsubscribed": req.Unsubscribe}
+		}
+		if req.Address != "" {
+			r["address"] = req.Address
+		}
+		h.queue(c, r)
+	}
+	if err := json.Unmarshal(msg, &req); err != nil {
+		reply(fmt.Errorf("Invalid message: %v", err))
+		return
+	}
+
+	subscribe, what := true, req.Subscribe
+	if req.Unsubscribe != "" {
+		subscribe, what = false, req.Unsubscribe
+	}
+	switch what {
+	case "blocks":
+		c.blocks = subscribe
+		reply(nil)
+	case "address":
+		key, err := pg.AddressKey(req.Address)
+		if err != nil {
+			reply(fmt.Errorf("Invalid address: %v", err))
+			return
+		}
+		if !subscribe {
+			delete(c.addrs, string(key))
+		} else if len(c.addrs) >= MaxWatchedAddresses {
+			reply(fmt.Errorf("Too many addresses (the maximum is %d)", MaxWatchedAddresses))
+			return
+		} else {
+			c.addrs[string(key)] = req.Address
+		}
+		reply(nil)
+	default:
+		reply(fmt.Errorf("Expected subscribe or unsubscribe blocks or address"))
+	}
+}
+
+// newBlock pushes the block and the events of the watched addresses in
+// it to the clients.
+func (h *pushHub) newBlock(b *pg.NewBlock) {
+	h.Lock()
+	watched := make(map[string]bool)
+	for c := range h.clients {
+		for key := range c.addrs {
+			watched[key] = true
+		}
+	}
+	h.Unlock()
+
+	var events []*pg.BlockAddrEvent
+	if len(watched) > 0 {
+		keys := make([][]byte, 0, len(watched))
+		for key := range watched {
+			keys = append(keys, []byte(key))
+		}
+		var err error
+		if events, err = h.e.SelectBlockAddrEvents(b.Hash, keys); err != nil {
+			log.Printf("Error reading the address events of block %v: %v", b.Hash, err)
+		}
+	}
+
+	hash := b.Hash.String()
+	h.Lock()
+	defer h.Unlock()
+	for c := range h.clients {
+		if c.blocks {
+			h.queue(c, map[string]interface{}{"type": "block", "hash": hash, "height": b.Height, "time": b.Time, "txs": b.NTx})
+		}
+		for _, ev := range events {
+			if addr, ok := c.addrs[string(ev.Addr)]; ok {
+				h.queue(c, map[string]interface{}{"type": "address", "address": addr, "hash": hash, "height": b.Height,
+					"txid": ev.Txid.String(), "n": ev.N, "spending": ev.Spending, "value": ev.Value})
+			}
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package serve
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RedisCache is a Cache in Redis, speaking just enough of its protocol
+// (RESP) for GET and SET. Redis being unavailable makes every Get a
+// miss, it is not an error of the request.
+
+const (
+	redisTimeout = time.Second
+	redisConns   = 8 // idle connections kept
+)
+
+type RedisCache struct {
+	addr     string
+	password string
+	db       int
+	prefix   string
+	idle     chan *redisConn
+}
+
+type redisConn struct {
+	conn net.Conn
+	br   *bufio.Reader
+}
+
+// NewRedisCache returns a cache in the Redis at the URL,
+// redis://[:password@]host[:port][/db], the keys being prefixed with
+// prefix.
+func NewRedisCache(redisURL, prefix string) (*RedisCache, error) {
+	u, err := url.Parse(redisURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "redis" {
+		return nil, fmt.Errorf("Not a redis:// URL: %s", redisURL)
+	}
+	c := &RedisCache{addr: u.Host, prefix: prefix, idle: make(chan *redisConn, redisConns)}
+	if u.Port() == "" {
+		c.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		c.password, _ = u.User.Password()
+	}
+	if p := strings.TrimPrefix(u.Path, "/"); p != "" {
+		if c.db, err = strconv.Atoi(p); err != nil {
+			return nil, fmt.Errorf("Invalid Redis db: %q", p)
+		}
+	}
+	// Connect now, for the errors in the URL to show
+	rc, err := c.conn()
+	if err != nil {
+		return nil, err
+	}
+	c.release(rc)
+	return c, nil
+}
+
+func (c *RedisCache) Get(key string) ([]byte, bool) {
+	v, err := c.do("GET", c.prefix+key)
+	if err != nil {
+		log.Printf("Redis: %v", err)
+		return nil, false
+	}
+	b, ok := v.([]byte)
+	return b, ok
+}
+
+func (c *RedisCache) Set(key string, value []byte, ttl time.Duration) {
+	if _, err := c.do("SET", c.prefix+key, string(value), "PX", strconv.FormatInt(ttl.Milliseconds(), 10)); err != nil {
+		log.Printf("Redis: %v", err)
+	}
+}
+
+func (c *RedisCache) conn() (*redisConn, error) {
+	select {
+	case rc := <-c.idle:
+		return rc, nil
+	default:
+	}
+	conn, err := net.DialTimeout("tcp", c.addr, redisTimeout)
+	if err != nil {
+		return nil, err
+	}
+	rc := &redisConn{conn: conn, br: bufio.NewReader(conn)}
+	if c.password != "" {
+		if _, err := rc.do("AUTH", c.password); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if c.db != 0 {
+		if _, err := rc.do("SELECT", strconv.Itoa(c.db)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return rc, nil
+}
+
+func (c *RedisCache) release(rc *redisConn) {
+	select {
+	case c.idle <- rc:
+	default:
+		rc.conn.Close()
+	}
+}
+
+func (c *RedisCache) do(args ...string) (interface{}, error) {
+	rc, err := c.conn()
+	if err != nil {
+		return nil, err
+	}
+	v, err := rc.do(args...)
+	if _, ok := err.(redisError); err != nil && !ok {
+		rc.conn.Close() // the connection is in an unknown state
+		return nil, err
+	}
+	c.release(rc)
+	return v, err
+}
+
+// An error reply of Redis.
+type redisError string
+
+func (e redisError) Error() string { return string(e) }
+
+// do sends the command and returns the reply: a string, an int64, a
+// []byte or nil for a bulk string, or a []interface{}.
+func (rc *redisConn) do(args ...string) (interface{}, error) {
+	rc.conn.SetDeadline(time.Now().Add(redisTimeout))
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(a), a)
+	}
+	if _, err := io.WriteString(rc.conn, b.String()); err != nil {
+		return nil, err
+	}
+	return rc.reply()
+}
+
+func (rc *redisConn) reply() (interface{}, error) {
+	line, err := rc.br.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || !strings.HasSuffix(line, "\r\n") {
+		return nil, fmt.Errorf("Invalid Redis reply %q", line)
+	}
+	kind, line := line[0], line[1:len(line)-2]
+	switch kind {
+	case '+':
+		return line, nil
+	case '-':
+		return nil, redisError(line)
+	case ':':
+		return strconv.ParseInt(line, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line)
+		if err != nil || n < 0 {
+			return nil, err // nil, nil for a null
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(rc.br, buf); err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	case '*':
+		n, err := strconv.Atoi(line)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			if items[i], err = rc.reply(); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("Invalid Redis reply %q", line)
+}
@@ -0,0 +1,146 @@
+package serve
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// The routes of the API, which document it: the OpenAPI document
+// served at /api/openapi.json is built from them, and so is the Go
+// client in serve/client (go generate ./serve/client, see cmd/apigen).
+// A route added to NewServer should be added here too.
+
+type Param struct {
+	Name     string
+	In       string // path or query
+	Type     string // string, integer or boolean
+	Required bool
+	Desc     string
+}
+
+type Route struct {
+	Name    string // of the client method
+	Method  string
+	Path    string // with {param} for the path params
+	Summary string
+	Params  []*Param
+	Body    string // description of the text/plain request body, "" = none
+	Result  string // integer, object or array
+}
+
+func pathParam(name, desc string) *Param {
+	return &Param{Name: name, In: "path", Type: "string", Required: true, Desc: desc}
+}
+
+func queryParam(name, typ, desc string) *Param {
+	return &Param{Name: name, In: "query", Type: typ, Desc: desc}
+}
+
+var (
+	limitQuery  = queryParam("limit", "integer", "Number of items, 1 to 1000 (default 25)")
+	heightQuery = queryParam("height", "integer", "As of this height (default the tip)")
+	matureQuery = queryParam("mature", "boolean", "Only mature coinbase outputs")
+	addressPath = pathParam("address", "Mainnet address")
+)
+
+var Routes = []*Route{
+	{"Height", "GET", "/api/height", "Height of the highest block", nil, "", "integer"},
+	{"Blocks", "GET", "/api/blocks", "Blocks at and below a height",
+		[]*Param{queryParam("height", "integer", "Highest block (default the tip)"), limitQuery}, "", "array"},
+	{"Chain", "GET", "/api/chain", "Page of the main chain with confirmations",
+		[]*Param{queryParam("before", "string", "Height or block hash, the blocks below"),
+			queryParam("after", "string", "Height or block hash, the blocks above"), limitQuery}, "", "object"},
+	{"Block", "GET", "/api/block/{hash}", "Block header",
+		[]*Param{pathParam("hash", "Block hash")}, "", "object"},
+	{"BlockTxs", "GET", "/api/block/{hash}/txs", "Transactions of a block",
+		[]*Param{pathParam("hash", "Block hash"), queryParam("start", "integer", "Position of the first tx in the block"), limitQuery}, "", "array"},
+	{"Tx", "GET", "/api/tx/{txid}", "Transaction with its inputs and outputs",
+		[]*Param{pathParam("txid", "Txid")}, "", "object"},
+	{"SearchTx", "GET", "/api/search/tx/{prefix}", "Transactions by txid prefix",
+		[]*Param{pathParam("prefix", "4 to 64 hex digits of the txid"), limitQuery}, "", "array"},
+	{"SearchAddress", "GET", "/api/search/address/{address}", "Address type, balance and first page of history",
+		[]*Param{addressPath, limitQuery}, "", "object"},
+	{"AddressTxs", "GET", "/api/address/{address}/txs", "Transactions of an address",
+		[]*Param{addressPath, queryParam("start", "integer", "Tx id to start below"), limitQuery}, "", "array"},
+	{"AddressHistory", "GET", "/api/address/{address}/history", "Funding and spending events of an address, newest first",
+		[]*Param{addressPath, queryParam("after", "string", "The next key of the previous page"), limitQuery}, "", "object"},
+	{"AddressLabels", "GET", "/api/address/{address}/labels", "Labels of an address",
+		[]*Param{addressPath}, "", "array"},
+	{"AddressBalance", "GET", "/api/address/{address}/balance", "Balance of an address",
+		[]*Param{addressPath, heightQuery, matureQuery}, "", "object"},
+	{"AddressUtxos", "GET", "/api/address/{address}/utxos", "Unspent outputs of an address",
+		[]*Param{addressPath, heightQuery, matureQuery}, "", "array"},
+	{"GraphQL", "GET", "/api/graphql", "Run a GraphQL query over blocks, txs and addresses",
+		[]*Param{{Name: "query", In: "query", Type: "string", Required: true, Desc: "The query"},
+			queryParam("variables", "string", "The variables, a JSON object"),
+			queryParam("operationName", "string", "The operation to run, if the query has several")}, "", "object"},
+	{"Addresses", "POST", "/api/addresses", "Balances, and UTXOs, of many addresses",
+		[]*Param{heightQuery, matureQuery, queryParam("utxos", "boolean", "With the unspent outputs")},
+		"Addresses or their scriptPubKeys in hex, separated by whitespace or commas", "object"},
+	{"PSBT", "POST", "/api/psbt", "Check a PSBT against the database", nil, "PSBT, base64 or hex", "object"},
+	{"Broadcast", "POST", "/api/broadcast", "Check a tx and relay it to the node (if enabled)",
+		[]*Param{queryParam("check_only", "boolean", "Only check")}, "Raw tx, hex", "object"},
+}
+
+// OpenAPI returns the OpenAPI 3 document of the routes.
+func OpenAPI() ([]byte, error) {
+	paths := make(map[string]map[string]interface{})
+	for _, rt := range Routes {
+		params := make([]interface{}, 0, len(rt.Params))
+		for _, p := range rt.Params {
+			params = append(params, map[string]interface{}{
+				"name":        p.Name,
+				"in":          p.In,
+				"required":    p.Required,
+				"description": p.Desc,
+				"schema":      map[string]string{"type": p.Type},
+			})
+		}
+		op := map[string]interface{}{
+			"operationId": rt.Name,
+			"summary":     rt.Summary,
+			"parameters":  params,
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "OK",
+					"content": map[string]interface{}{
+						"application/json": map[string]interface{}{"schema": map[string]string{"type": rt.Result}},
+					},
+				},
+				"400": map[string]string{"description": "Invalid parameter"},
+				"404": map[string]string{"description": "Not found"},
+			},
+		}
+		if rt.Body != "" {
+			op["requestBody"] = map[string]interface{}{
+				"required":    true,
+				"description": rt.Body,
+				"content":     map[string]interface{}{"text/plain": map[string]interface{}{"schema": map[string]string{"type": "string"}}},
+			}
+		}
+		if paths[rt.Path] == nil {
+			paths[rt.Path] = make(map[string]interface{})
+		}
+		paths[rt.Path][strings.ToLower(rt.Method)] = op
+	}
+	return json.MarshalIndent(map[string]interface{}{
+		"openapi": "3.0.3",
+		"info":    map[string]string{"title": "blkchain", "version": "1"},
+		"components": map[string]interface{}{
+			"securitySchemes": map[string]interface{}{
+				"apiKey": map[string]string{"type": "apiKey", "in": "header", "name": "X-API-Key"},
+			},
+		},
+		"paths": paths,
+	}, "", "  ")
+}
+
+func (s *Server) openAPI(w http.ResponseWriter, r *http.Request) {
+	doc, err := OpenAPI()
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	writeJson(w, string(doc))
+}
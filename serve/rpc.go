@@ -0,0 +1,508 @@
+package serve
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"strings"
+
+	"github.com/blkchain/blkchain/core"
+)
+
+// A subset of the bitcoind JSON-RPC, for tooling pointed at a node for
+// historical queries to be pointed at the database instead: POST / with
+// a JSON-RPC request (or a batch of them) as bitcoind takes it, with
+// positional or named params, credentials being ignored:
+//
+//   getblockcount
+//   getbestblockhash
+//   getblockhash height
+//   getblock blockhash (verbosity 0, 1 or 2)
+//   getrawtransaction txid (verbose) (blockhash)
+//   gettxout txid n (include_mempool)
+//
+// The results are as bitcoind's, less what the database does not have:
+// scripts have no "asm", txs no fees, and there is no mempool, so
+// getrawtransaction only finds confirmed txs and gettxout only the
+// outputs of the main chain UTXO set. Addresses are mainnet.
+
+// Error codes, as in Core's rpc/protocol.h
+const (
+	rpcMiscError         = -1
+	rpcInvalidAddrOrKey  = -5
+	rpcInvalidParameter  = -8
+	rpcInvalidRequest    = -32600
+	rpcMethodNotFound    = -32601
+	rpcInvalidParams     = -32602
+	rpcParseError        = -32700
+	rpcTypeError         = -3
+	rpcBlockNotAvailable = -32603 // RPC_INTERNAL_ERROR, as Core for pruned blocks
+)
+
+type rpcRequest struct {
+	Id     json.RawMessage `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *rpcError) Error() string {
+	return e.Message
+}
+
+type rpcResponse struct {
+	Result interface{}     `json:"result"`
+	Error  *rpcError       `json:"error"`
+	Id     json.RawMessage `json:"id"`
+}
+
+type rpcMethod struct {
+	params []string // names, in order
+	call   func(s *Server, params []json.RawMessage) (interface{}, error)
+}
+
+var rpcMethods = map[string]*rpcMethod{
+	"getblockcount":     {nil, (*Server).rpcGetBlockCount},
+	"getbestblockhash":  {nil, (*Server).rpcGetBestBlockHash},
+	"getblockhash":      {[]string{"height"}, (*Server).rpcGetBlockHash},
+	"getblock":          {[]string{"blockhash", "verbosity"}, (*Server).rpcGetBlock},
+	"getrawtransaction": {[]string{"txid", "verbose", "blockhash"}, (*Server).rpcGetRawTransaction},
+	"gettxout":          {[]string{"txid", "n", "include_mempool"}, (*Server).rpcGetTxOut},
+}
+
+func (s *Server) rpc(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "JSONRPC server handles only POST requests", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBroadcastBody))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid request: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	body = bytes.TrimSpace(body)
+	if len(body) > 0 && body[0] == '[' {
+		var reqs []*rpcRequest
+		if err := json.Unmarshal(body, &reqs); err != nil {
+			writeRpc(w, http.StatusInternalServerError, &rpcResponse{Error: &rpcError{rpcParseError, "Parse error"}})
+			return
+		}
+		resps := make([]*rpcResponse, len(reqs))
+		for i, req := range reqs {
+			resps[i] = s.rpcCall(req)
+		}
+		writeRpc(w, http.StatusOK, resps)
+		return
+	}
+
+	var req rpcRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeRpc(w, http.StatusInternalServerError, &rpcResponse{Error: &rpcError{rpcParseError, "Parse error"}})
+		return
+	}
+	resp := s.rpcCall(&req)
+	status := http.StatusOK
+	if resp.Error != nil {
+		status = http.StatusInternalServerError
+		if resp.Error.Code == rpcMethodNotFound {
+			status = http.StatusNotFound
+		}
+	}
+	writeRpc(w, status, resp)
+}
+
+func (s *Server) rpcCall(req *rpcRequest) *rpcResponse {
+	resp := &rpcResponse{Id: req.Id}
+	if resp.Id == nil {
+		resp.Id = json.RawMessage("null")
+	}
+	m := rpcMethods[req.Method]
+	if m == nil {
+		resp.Error = &rpcError{rpcMethodNotFound, "Method not found"}
+		return resp
+	}
+	params, err := rpcParams(req.Params, m.params)
+	if err == nil {
+		resp.Result, err = m.call(s, params)
+	}
+	switch err := err.(type) {
+	case nil:
+	case *rpcError:
+		resp.Result, resp.Error = nil, err
+	default:
+		log.Printf("Error serving %s: %v", req.Method, err)
+		resp.Result, resp.Error = nil, &rpcError{rpcMiscError, err.Error()}
+	}
+	return resp
+}
+
+// rpcParams returns the params by position, the named ones put in the
+// order of names, missing ones are nil.
+func rpcParams(raw json.RawMessage, names []string) ([]json.RawMessage, error) {
+	params := make([]json.RawMessage, len(names))
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 || bytes.Equal(raw, []byte("null")) {
+		return params, nil
+	}
+	if raw[0] == '{' {
+		var named map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &named); err != nil {
+			return nil, &rpcError{rpcInvalidRequest, "Params must be an array or object"}
+		}
+		for k, v := range named {
+			i := 0
+			for i < len(names) && names[i] != k {
+				i++
+			}
+			if i == len(names) {
+				return nil, &rpcError{rpcInvalidParameter, fmt.Sprintf("Unknown named parameter %s", k)}
+			}
+			params[i] = v
+		}
+		return params, nil
+	}
+	var pos []json.RawMessage
+	if err := json.Unmarshal(raw, &pos); err != nil {
+		return nil, &rpcError{rpcInvalidRequest, "Params must be an array or object"}
+	}
+	if len(pos) > len(names) {
+		return nil, &rpcError{rpcInvalidParams, fmt.Sprintf("Too many parameters (%d, at most %d)", len(pos), len(names))}
+	}
+	copy(params, pos)
+	return params, nil
+}
+
+func isNull(p json.RawMessage) bool {
+	return p == nil || bytes.Equal(p, []byte("null"))
+}
+
+func hashParam(p json.RawMessage, name string) (core.Uint256, error) {
+	var v string
+	if isNull(p) || json.Unmarshal(p, &v) != nil {
+		return core.Uint256{}, &rpcError{rpcTypeError, fmt.Sprintf("%s must be a hex string", name)}
+	}
+	hash, err := core.Uint256FromString(v)
+	if err != nil {
+		return core.Uint256{}, &rpcError{rpcInvalidParameter, fmt.Sprintf("%s must be of length 64 (not %d, for '%s')", name, len(v), v)}
+	}
+	return hash, nil
+}
+
+// intRpcParam takes booleans as 0 and 1, as the verbosity params of Core.
+func intRpcParam(p json.RawMessage, name string, dflt int) (int, error) {
+	if isNull(p) {
+		return dflt, nil
+	}
+	var b bool
+	if json.Unmarshal(p, &b) == nil {
+		if b {
+			return 1, nil
+		}
+		return 0, nil
+	}
+	var i int
+	if err := json.Unmarshal(p, &i); err != nil {
+		return 0, &rpcError{rpcTypeError, fmt.Sprintf("%s must be a number", name)}
+	}
+	return i, nil
+}
+
+func (s *Server) rpcGetBlockCount(params []json.RawMessage) (interface{}, error) {
+	hash, err := s.e.SelectMainChainHash(-1)
+	if err != nil {
+		return nil, err
+	}
+	return s.e.SelectBlockHeight(hash)
+}
+
+func (s *Server) rpcGetBestBlockHash(params []json.RawMessage) (interface{}, error) {
+	hash, err := s.e.SelectMainChainHash(-1)
+	if err != nil {
+		return nil, err
+	}
+	return hash.String(), nil
+}
+
+func (s *Server) rpcGetBlockHash(params []json.RawMessage) (interface{}, error) {
+	if isNull(params[0]) {
+		return nil, &rpcError{rpcInvalidParams, "height is required"}
+	}
+	height, err := intRpcParam(params[0], "height", 0)
+	if err != nil {
+		return nil, err
+	}
+	if height < 0 {
+		return nil, &rpcError{rpcInvalidParameter, "Block height out of range"}
+	}
+	hash, err := s.e.SelectMainChainHash(height)
+	if err == sql.ErrNoRows {
+		return nil, &rpcError{rpcInvalidParameter, "Block height out of range"}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return hash.String(), nil
+}
+
+func (s *Server) rpcGetBlock(params []json.RawMessage) (interface{}, error) {
+	hash, err := hashParam(params[0], "blockhash")
+	if err != nil {
+		return nil, err
+	}
+	verbosity, err := intRpcParam(params[1], "verbosity", 1)
+	if err != nil {
+		return nil, err
+	}
+	info, err := s.e.SelectBlockInfo(hash)
+	if err == sql.ErrNoRows {
+		return nil, &rpcError{rpcInvalidAddrOrKey, "Block not found"}
+	}
+	if err != nil {
+		return nil, &rpcError{rpcBlockNotAvailable, fmt.Sprintf("Block not available (%v)", err)}
+	}
+	b := info.Block
+	if verbosity <= 0 {
+		var buf bytes.Buffer
+		if err := core.BinWrite(b, &buf); err != nil {
+			return nil, err
+		}
+		return hex.EncodeToString(buf.Bytes()), nil
+	}
+
+	result := map[string]interface{}{
+		"hash":          hash.String(),
+		"confirmations": info.Confirmations,
+		"size":          b.Size(),
+		"strippedsize":  b.BaseSize(),
+		"weight":        b.Weight(),
+		"height":        info.Height,
+		"version":       int32(b.Version),
+		"versionHex":    fmt.Sprintf("%08x", uint32(b.Version)),
+		"merkleroot":    b.HashMerkleRoot.String(),
+		"time":          uint32(b.Time),
+		"mediantime":    info.MedianTime,
+		"nonce":         uint32(b.Nonce),
+		"bits":          fmt.Sprintf("%08x", uint32(b.Bits)),
+		"difficulty":    b.Difficulty(),
+		"nTx":           len(b.Txs),
+	}
+	if work, ok := new(big.Int).SetString(info.ChainWork, 10); ok {
+		result["chainwork"] = fmt.Sprintf("%064x", work)
+	}
+	if info.Height > 0 {
+		result["previousblockhash"] = b.PrevHash.String()
+	}
+	if info.Next != nil {
+		result["nextblockhash"] = info.Next.String()
+	}
+	txs := make([]interface{}, len(b.Txs))
+	for i, tx := range b.Txs {
+		if verbosity == 1 {
+			txs[i] = tx.Hash().String()
+		} else if txs[i], err = rpcTx(tx, i == 0); err != nil {
+			return nil, err
+		}
+	}
+	result["tx"] = txs
+	return result, nil
+}
+
+func (s *Server) rpcGetRawTransaction(params []json.RawMessage) (interface{}, error) {
+	txid, err := hashParam(params[0], "txid")
+	if err != nil {
+		return nil, err
+	}
+	verbose, err := intRpcParam(params[1], "verbose", 0)
+	if err != nil {
+		return nil, err
+	}
+	info, err := s.e.SelectTxInfo(txid)
+	if err == sql.ErrNoRows {
+		return nil, &rpcError{rpcInvalidAddrOrKey, "No such mempool or blockchain transaction."}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !isNull(params[2]) {
+		blockHash, err := hashParam(params[2], "blockhash")
+		if err != nil {
+			return nil, err
+		}
+		if blockHash != info.BlockHash {
+			return nil, &rpcError{rpcInvalidAddrOrKey, "No such transaction found in the provided block."}
+		}
+	}
+	if verbose <= 0 {
+		var buf bytes.Buffer
+		if err := core.BinWrite(info.Tx, &buf); err != nil {
+			return nil, err
+		}
+		return hex.EncodeToString(buf.Bytes()), nil
+	}
+
+	coinbase := len(info.Tx.TxIns) == 1 && info.Tx.TxIns[0].PrevOut.Hash == (core.Uint256{}) &&
+		info.Tx.TxIns[0].PrevOut.N == 0xffffffff
+	result, err := rpcTx(info.Tx, coinbase)
+	if err != nil {
+		return nil, err
+	}
+	result["blockhash"] = info.BlockHash.String()
+	result["confirmations"] = info.Confirmations
+	result["time"] = info.BlockTime
+	result["blocktime"] = info.BlockTime
+	return result, nil
+}
+
+func (s *Server) rpcGetTxOut(params []json.RawMessage) (interface{}, error) {
+	txid, err := hashParam(params[0], "txid")
+	if err != nil {
+		return nil, err
+	}
+	if isNull(params[1]) {
+		return nil, &rpcError{rpcInvalidParams, "n is required"}
+	}
+	n, err := intRpcParam(params[1], "n", 0)
+	if err != nil {
+		return nil, err
+	}
+	info, err := s.e.SelectUnspentTxOut(txid, n)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"bestblock":     info.BestBlock.String(),
+		"confirmations": info.Confirmations,
+		"value":         rpcAmount(info.Value),
+		"scriptPubKey":  rpcScriptPubKey(info.ScriptPubKey),
+		"coinbase":      info.Coinbase,
+	}, nil
+}
+
+// rpcTx is the tx as decoderawtransaction shows it.
+func rpcTx(tx *core.Tx, coinbase bool) (map[string]interface{}, error) {
+	var buf bytes.Buffer
+	if err := core.BinWrite(tx, &buf); err != nil {
+		return nil, err
+	}
+	vin := make([]interface{}, len(tx.TxIns))
+	for i, in := range tx.TxIns {
+		v := make(map[string]interface{})
+		if coinbase {
+			v["coinbase"] = hex.EncodeToString(in.ScriptSig)
+		} else {
+			v["txid"] = in.PrevOut.Hash.String()
+			v["vout"] = in.PrevOut.N
+			v["scriptSig"] = map[string]interface{}{"hex": hex.EncodeToString(in.ScriptSig)}
+		}
+		if len(in.Witness) > 0 {
+			wit := make([]string, len(in.Witness))
+			for j, item := range in.Witness {
+				wit[j] = hex.EncodeToString(item)
+			}
+			v["txinwitness"] = wit
+		}
+		v["sequence"] = in.Sequence
+		vin[i] = v
+	}
+	vout := make([]interface{}, len(tx.TxOuts))
+	for i, out := range tx.TxOuts {
+		vout[i] = map[string]interface{}{
+			"value":        rpcAmount(out.Value),
+			"n":            i,
+			"scriptPubKey": rpcScriptPubKey(out.ScriptPubKey),
+		}
+	}
+	return map[string]interface{}{
+		"txid":     tx.Hash().String(),
+		"hash":     tx.WitnessHash().String(),
+		"version":  int32(tx.Version),
+		"size":     tx.Size(),
+		"vsize":    tx.VirtualSize(),
+		"weight":   tx.Weight(),
+		"locktime": tx.LockTime,
+		"vin":      vin,
+		"vout":     vout,
+		"hex":      hex.EncodeToString(buf.Bytes()),
+	}, nil
+}
+
+func rpcScriptPubKey(script []byte) map[string]interface{} {
+	typ := rpcScriptType(script)
+	result := map[string]interface{}{"hex": hex.EncodeToString(script), "type": typ}
+	if typ != "pubkey" { // Core does not give P2PK an address
+		if addr := core.ScriptAddress(script, core.MainNetAddressParams); addr != "" {
+			result["address"] = addr
+		}
+	}
+	return result
+}
+
+// rpcScriptType is the type of the script as Core names it, see also
+// script_type() in pg/scripttypes.go.
+func rpcScriptType(s []byte) string {
+	n := len(s)
+	switch {
+	case n == 25 && s[0] == 0x76 && s[1] == 0xa9 && s[2] == 20 && s[23] == 0x88 && s[24] == 0xac:
+		return "pubkeyhash"
+	case n == 23 && s[0] == 0xa9 && s[1] == 20 && s[22] == 0x87:
+		return "scripthash"
+	case n == 22 && s[0] == core.OP_0 && s[1] == 20:
+		return "witness_v0_keyhash"
+	case n == 34 && s[0] == core.OP_0 && s[1] == 32:
+		return "witness_v0_scripthash"
+	case n == 34 && s[0] == core.OP_1 && s[1] == 32:
+		return "witness_v1_taproot"
+	case bytes.Equal(s, []byte{core.OP_1, 2, 0x4e, 0x73}):
+		return "anchor"
+	case n >= 4 && n <= 42 && s[0] >= core.OP_1 && s[0] <= core.OP_16 && int(s[1]) == n-2:
+		return "witness_unknown"
+	case (n == 35 || n == 67) && int(s[0]) == n-2 && s[n-1] == 0xac:
+		return "pubkey"
+	case n > 0 && s[0] == core.OP_RETURN:
+		return "nulldata"
+	case n > 3 && s[0] >= core.OP_1 && s[0] <= core.OP_16 && s[n-1] == 0xae:
+		return "multisig"
+	}
+	return "nonstandard"
+}
+
+// rpcAmount is satoshis as BTC with 8 decimals, the way Core writes
+// amounts.
+type rpcAmount int64
+
+func (a rpcAmount) MarshalJSON() ([]byte, error) {
+	sign := ""
+	if a < 0 {
+		sign, a = "-", -a
+	}
+	return []byte(fmt.Sprintf("%s%d.%08d", sign, a/1e8, a%1e8)), nil
+}
+
+func writeRpc(w http.ResponseWriter, status int, v interface{}) {
+	js, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("Error encoding the RPC response: %v", err)
+		js = []byte(`{"result":null,"error":{"code":-1,"message":"Internal error"},"id":null}`)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	fmt.Fprintln(w, strings.TrimSpace(string(js)))
+}
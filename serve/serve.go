@@ -0,0 +1,677 @@
+package serve
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/blkchain/blkchain/core"
+	"github.com/blkchain/blkchain/pg"
+)
+
+// A JSON HTTP API over the Explorer queries, read-only unless
+// broadcasting is enabled (the PSBT check is a POST for the size of
+// the PSBT, it does not write anything). All responses
+// are JSON, lists are arrays (or an object with the next page key where
+// there is keyset pagination):
+//
+//   GET /api/height
+//   GET /api/blocks?height=H&limit=N
+//   GET /api/chain?before=H|HASH&limit=N (or after=H|HASH)
+//   GET /api/block/<hash>
+//   GET /api/block/<hash>/txs?start=N&limit=N
+//   GET /api/tx/<txid>
+//   GET /api/search/tx/<txid prefix>?limit=N
+//   GET /api/search/address/<address>?limit=N
+//   GET /api/address/<address>/txs?start=TXID&limit=N
+//   GET /api/address/<address>/history?after=KEY&limit=N
+//   GET /api/address/<address>/labels
+//   GET /api/address/<address>/balance?height=H&mature=true
+//   GET /api/address/<address>/utxos?height=H&mature=true
+//   POST /api/addresses?height=H&mature=true&utxos=true (addresses in the body)
+//   POST /api/psbt (base64 or hex PSBT, see pg.CheckPSBT)
+//   POST /api/broadcast[?check_only=true] (if enabled, see below)
+//   GET /api/ws (WebSocket, if enabled, see push.go)
+//   GET or POST /api/graphql (GraphQL, see graphql.go)
+//   POST / (bitcoind JSON-RPC, see rpc.go)
+//   GET /api/openapi.json (the OpenAPI document, see routes.go)
+//
+// Hashes are hex in display order, addresses are mainnet base58 or
+// bech32. The GET responses may be cached, see cache.go.
+
+const (
+	DefaultLimit = 25
+	MaxLimit     = 1000
+)
+
+// The largest broadcast or PSBT request body (hex), a standard tx is at
+// most 400k weight units.
+const maxBroadcastBody = 1 << 20
+
+type Server struct {
+	e   *pg.Explorer
+	mux *http.ServeMux
+
+	relayer    Relayer // nil unless broadcasting is enabled
+	maxFeeRate float64
+
+	apiKeys     map[string]*APIKey // nil = none needed, see access.go
+	limiter     *limiter           // nil = no rate limit
+	logRequests bool
+
+	push *pushHub // nil unless pushing is enabled, see push.go
+
+	cache    Cache // nil = no cache, see cache.go
+	cacheTTL time.Duration
+	cacheMu  sync.Mutex
+	cacheTip string
+
+	newBlockMu  sync.Mutex
+	newBlockFns []func(*pg.NewBlock) // see onNewBlock
+}
+
+// A Relayer sends a serialized tx to the network, e.g.
+// btcnode.RPCClient.
+type Relayer interface {
+	SendRawTransaction(raw []byte) (core.Uint256, error)
+}
+
+func NewServer(e *pg.Explorer) *Server {
+	s := &Server{e: e, mux: http.NewServeMux()}
+	s.mux.HandleFunc("/api/height", s.height)
+	s.mux.HandleFunc("/api/blocks", s.blocks)
+	s.mux.HandleFunc("/api/chain", s.chain)
+	s.mux.HandleFunc("/api/block/", s.block)
+	s.mux.HandleFunc("/api/tx/", s.tx)
+	s.mux.HandleFunc("/api/search/tx/", s.searchTx)
+	s.mux.HandleFunc("/api/search/address/", s.searchAddress)
+	s.mux.HandleFunc("/api/address/", s.address)
+	s.mux.HandleFunc("/api/addresses", s.addresses)
+	s.mux.HandleFunc("/api/psbt", s.psbt)
+	s.mux.HandleFunc("/api/graphql", s.graphQL)
+	s.mux.HandleFunc("/api/openapi.json", s.openAPI)
+	s.mux.HandleFunc("/", s.rpc)
+	return s
+}
+
+// EnableBroadcast adds the broadcast endpoint, which takes a raw tx as
+// hex in the request body, checks it (see pg.CheckTx) with the maximum
+// fee rate maxFeeRate (sat/vB) and relays it with r. The response is
+// the check, with status 422 if there are problems, in which case the
+// tx is not relayed, nor is it with check_only.
+func (s *Server) EnableBroadcast(r Relayer, maxFeeRate float64) {
+	s.relayer, s.maxFeeRate = r, maxFeeRate
+	s.mux.HandleFunc("/api/broadcast", s.broadcast)
+}
+
+// onNewBlock calls fn with every new block notified (see
+// pg.ListenNewBlocks), listening from the first call on.
+func (s *Server) onNewBlock(fn func(*pg.NewBlock)) {
+	s.newBlockMu.Lock()
+	defer s.newBlockMu.Unlock()
+	s.newBlockFns = append(s.newBlockFns, fn)
+	if len(s.newBlockFns) > 1 {
+		return
+	}
+	go func() {
+		err := s.e.ListenNewBlocks(func(b *pg.NewBlock) {
+			s.newBlockMu.Lock()
+			fns := s.newBlockFns
+			s.newBlockMu.Unlock()
+			for _, fn := range fns {
+				fn(b)
+			}
+		})
+		if err != nil {
+			log.Printf("Error listening for new blocks: %v", err)
+		}
+	}()
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.logRequests {
+		sw, start := &statusWriter{ResponseWriter: w}, time.Now()
+		client := r.RemoteAddr
+		defer func() { logRequest(r, client, sw, start) }()
+		w = sw
+		var ok bool
+		if client, ok = s.admit(w, r); !ok {
+			return
+		}
+	} else if _, ok := s.admit(w, r); !ok {
+		return
+	}
+	post := r.Method == http.MethodPost &&
+		(r.URL.Path == "/api/broadcast" && s.relayer != nil || r.URL.Path == "/api/psbt" || r.URL.Path == "/api/addresses" || r.URL.Path == "/api/graphql" || r.URL.Path == "/")
+	if r.Method != http.MethodGet && r.Method != http.MethodHead && !post {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.cache != nil && cacheable(r) {
+		s.serveCached(w, r)
+		return
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) height(w http.ResponseWriter, r *http.Request) {
+	height, err := s.e.SelectMaxHeight()
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	writeJson(w, strconv.Itoa(height))
+}
+
+func (s *Server) blocks(w http.ResponseWriter, r *http.Request) {
+	limit, err := limitParam(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	height, err := intParam(r, "height", -1)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if height < 0 {
+		if height, err = s.e.SelectMaxHeight(); err != nil {
+			serverError(w, r, err)
+			return
+		}
+	}
+	blocks, err := s.e.SelectBlocksJson(height, limit)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	writeJsonArray(w, blocks)
+}
+
+// /api/chain pages through the main chain, from the tip by default,
+// the blocks with their confirmations. The cursor (before or after) is
+// a height or a block hash, the response has the next and prev cursors.
+func (s *Server) chain(w http.ResponseWriter, r *http.Request) {
+	limit, err := limitParam(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	q := r.URL.Query()
+	if q.Get("before") != "" && q.Get("after") != "" {
+		http.Error(w, "Only one of before and after", http.StatusBadRequest)
+		return
+	}
+	after, cursor := false, q.Get("before")
+	if v := q.Get("after"); v != "" {
+		after, cursor = true, v
+	}
+
+	height := math.MaxInt32
+	if len(cursor) == 64 {
+		hash, err := core.Uint256FromString(cursor)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid block hash: %v", err), http.StatusBadRequest)
+			return
+		}
+		if height, err = s.e.SelectBlockHeight(hash); err != nil {
+			serverError(w, r, err)
+			return
+		}
+	} else if cursor != "" {
+		if height, err = strconv.Atoi(cursor); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid cursor: %q", cursor), http.StatusBadRequest)
+			return
+		}
+	}
+
+	page, err := s.e.SelectChainPage(height, after, limit)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	result, err := json.Marshal(page)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	writeJson(w, string(result))
+}
+
+// /api/block/<hash> and /api/block/<hash>/txs
+func (s *Server) block(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/block/"), "/")
+	hash, err := core.Uint256FromString(parts[0])
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid block hash: %v", err), http.StatusBadRequest)
+		return
+	}
+	switch {
+	case len(parts) == 1:
+		block, err := s.e.SelectBlockByHashJson(hash)
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		writeJson(w, *block)
+	case len(parts) == 2 && parts[1] == "txs":
+		limit, err := limitParam(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		start, err := intParam(r, "start", 0)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		txs, err := s.e.SelectTxsJson(hash, start, limit)
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		writeJsonArray(w, txs)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *Server) tx(w http.ResponseWriter, r *http.Request) {
+	hash, err := core.Uint256FromString(strings.TrimPrefix(r.URL.Path, "/api/tx/"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid txid: %v", err), http.StatusBadRequest)
+		return
+	}
+	tx, err := s.e.SelectTxByHashJson(hash)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	writeJson(w, *tx)
+}
+
+// /api/search/tx/<prefix>, the txs whose txid begins with prefix
+func (s *Server) searchTx(w http.ResponseWriter, r *http.Request) {
+	limit, err := limitParam(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	prefix := strings.TrimPrefix(r.URL.Path, "/api/search/tx/")
+	if len(prefix) < pg.MinTxidPrefix || len(prefix) > 64 || strings.Trim(prefix, "0123456789abcdefABCDEF") != "" {
+		http.Error(w, fmt.Sprintf("Invalid txid prefix: %q (must be %d to 64 hex digits)", prefix, pg.MinTxidPrefix), http.StatusBadRequest)
+		return
+	}
+	txs, err := s.e.SearchTxid(prefix, limit)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	writeJsonArray(w, txs)
+}
+
+// /api/search/address/<address>, what the address is, its balance and
+// the first page of its history. An address which cannot be used is a
+// 400 with what is wrong with it (see pg.ParseAddress), one which is
+// valid but not indexed (taproot) has a null balance and history.
+func (s *Server) searchAddress(w http.ResponseWriter, r *http.Request) {
+	limit, err := limitParam(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	info, err := pg.ParseAddress(strings.TrimPrefix(r.URL.Path, "/api/search/address/"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid address: %v", err), http.StatusBadRequest)
+		return
+	}
+	result := struct {
+		*pg.AddressInfo
+		Height  *int            `json:"height"`
+		Balance *int64          `json:"balance"`
+		History json.RawMessage `json:"history"`
+	}{AddressInfo: info, History: json.RawMessage("null")}
+
+	if addr := info.Key(); addr != nil {
+		balance, last, err := s.e.SelectAddrBalanceAt(addr, pg.AsOfTip, false)
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		result.Height, result.Balance = &last, &balance
+		events, next, err := s.e.SelectAddrHistoryJson(addr, pg.AddrHistoryStart, limit)
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		nextJson := "null"
+		if next != nil {
+			nextJson = strconv.Quote(next.String())
+		}
+		result.History = json.RawMessage(fmt.Sprintf(`{"events":[%s],"next":%s}`, strings.Join(events, ","), nextJson))
+	}
+
+	js, err := json.Marshal(result)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	writeJson(w, string(js))
+}
+
+// /api/address/<address>/{txs,history,labels,balance,utxos}, height
+// is as of that height (see pg/asof.go), the tip if not given.
+func (s *Server) address(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/address/"), "/")
+	if len(parts) != 2 {
+		http.NotFound(w, r)
+		return
+	}
+	addr, err := pg.AddressKey(parts[0])
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid address: %v", err), http.StatusBadRequest)
+		return
+	}
+	limit, err := limitParam(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	switch parts[1] {
+	case "txs":
+		start, err := intParam(r, "start", math.MaxInt)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		txs, err := s.e.SelectTxsByAddrJson(addr, start, limit)
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		writeJsonArray(w, txs)
+	case "history":
+		after := pg.AddrHistoryStart
+		if v := r.URL.Query().Get("after"); v != "" {
+			if after, err = pg.ParseAddrHistoryKey(v); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		events, next, err := s.e.SelectAddrHistoryJson(addr, after, limit)
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		nextJson := "null"
+		if next != nil {
+			nextJson = strconv.Quote(next.String())
+		}
+		writeJson(w, fmt.Sprintf(`{"events":[%s],"next":%s}`, strings.Join(events, ","), nextJson))
+	case "labels":
+		labels, err := s.e.SelectAddrLabelsJson(addr)
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		writeJsonArray(w, labels)
+	case "balance":
+		height, err := intParam(r, "height", pg.AsOfTip)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		mature, err := boolParam(r, "mature")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		balance, last, err := s.e.SelectAddrBalanceAt(addr, height, mature)
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		if height > last {
+			height = last
+		}
+		writeJson(w, fmt.Sprintf(`{"height":%d,"balance":%d}`, height, balance))
+	case "utxos":
+		height, err := intParam(r, "height", pg.AsOfTip)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		mature, err := boolParam(r, "mature")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		utxos, err := s.e.SelectAddrUtxosAsOfJson(addr, height, mature)
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		writeJsonArray(w, utxos)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *Server) broadcast(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBroadcastBody))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid request: %v", err), http.StatusBadRequest)
+		return
+	}
+	raw, err := hex.DecodeString(string(bytes.TrimSpace(body)))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid tx hex: %v", err), http.StatusBadRequest)
+		return
+	}
+	var tx core.Tx
+	if err := core.BinRead(&tx, bytes.NewReader(raw)); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid tx: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	check, err := s.e.CheckTx(&tx, s.maxFeeRate)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	result, err := json.Marshal(check)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	if len(check.Problems) > 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		fmt.Fprintln(w, string(result))
+		return
+	}
+	if checkOnly, _ := strconv.ParseBool(r.URL.Query().Get("check_only")); checkOnly {
+		writeJson(w, string(result))
+		return
+	}
+
+	if _, err := s.relayer.SendRawTransaction(raw); err != nil {
+		log.Printf("Error relaying tx %v: %v", check.TxId, err)
+		http.Error(w, fmt.Sprintf("Relaying failed: %v", err), http.StatusBadGateway)
+		return
+	}
+	log.Printf("Relayed tx %v.", check.TxId)
+	writeJson(w, string(result))
+}
+
+func (s *Server) psbt(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBroadcastBody))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid request: %v", err), http.StatusBadRequest)
+		return
+	}
+	p, err := core.DecodePSBTString(string(body))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid PSBT: %v", err), http.StatusBadRequest)
+		return
+	}
+	report, err := s.e.CheckPSBT(p)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	result, err := json.Marshal(report)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	writeJson(w, string(result))
+}
+
+// /api/addresses takes up to pg.MaxBulkAddresses addresses, or hex
+// scriptPubKeys of addresses, separated by whitespace or commas, and
+// returns the balance of each (as /api/address/<address>/balance) and
+// with utxos=true the unspent outputs, in the order given, with one
+// query for them all.
+func (s *Server) addresses(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBroadcastBody))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid request: %v", err), http.StatusBadRequest)
+		return
+	}
+	items := strings.FieldsFunc(string(body), func(c rune) bool { return c == ',' || unicode.IsSpace(c) })
+	if len(items) == 0 || len(items) > pg.MaxBulkAddresses {
+		http.Error(w, fmt.Sprintf("Expected 1 to %d addresses, got %d", pg.MaxBulkAddresses, len(items)), http.StatusBadRequest)
+		return
+	}
+	height, err := intParam(r, "height", pg.AsOfTip)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	mature, err := boolParam(r, "mature")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	utxos, err := boolParam(r, "utxos")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	keys := make([][]byte, len(items))
+	for i, item := range items {
+		addr := item
+		if script, err := hex.DecodeString(item); err == nil && len(script) >= 22 { // longer than any address
+			if addr = core.ScriptAddress(script, core.MainNetAddressParams); addr == "" {
+				http.Error(w, fmt.Sprintf("Not the scriptPubKey of an address: %s", item), http.StatusBadRequest)
+				return
+			}
+		}
+		if keys[i], err = pg.AddressKey(addr); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid address: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	balances, asOf, err := s.e.SelectAddrsBalances(keys, height, mature, utxos)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	type addrBalance struct {
+		Address string `json:"address"`
+		*pg.AddrBalance
+	}
+	result := struct {
+		Height    int            `json:"height"`
+		Addresses []*addrBalance `json:"addresses"`
+	}{Height: asOf, Addresses: make([]*addrBalance, len(items))}
+	for i, item := range items {
+		result.Addresses[i] = &addrBalance{Address: item, AddrBalance: balances[i]}
+	}
+	js, err := json.Marshal(result)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	writeJson(w, string(js))
+}
+
+func intParam(r *http.Request, name string, dflt int) (int, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return dflt, nil
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid %s: %q", name, v)
+	}
+	return i, nil
+}
+
+func boolParam(r *http.Request, name string) (bool, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("Invalid %s: %q", name, v)
+	}
+	return b, nil
+}
+
+func limitParam(r *http.Request) (int, error) {
+	limit, err := intParam(r, "limit", DefaultLimit)
+	if err != nil {
+		return 0, err
+	}
+	if limit < 1 || limit > MaxLimit {
+		return 0, fmt.Errorf("Invalid limit: %d (must be 1 to %d)", limit, MaxLimit)
+	}
+	return limit, nil
+}
+
+func writeJson(w http.ResponseWriter, json string) {
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintln(w, json)
+}
+
+func writeJsonArray(w http.ResponseWriter, items []string) {
+	writeJson(w, "["+strings.Join(items, ",")+"]")
+}
+
+func serverError(w http.ResponseWriter, r *http.Request, err error) {
+	if err == sql.ErrNoRows {
+		http.NotFound(w, r)
+		return
+	}
+	log.Printf("Error serving %s: %v", r.URL, err)
+	http.Error(w, "Internal server error", http.StatusInternalServerError)
+}
@@ -0,0 +1,127 @@
+package source
+
+import (
+	"io"
+	"log"
+
+	"github.com/blkchain/blkchain/core"
+)
+
+// A BlockSource is where the import gets its blocks from: the block
+// files of a node in the order of its LevelDb index or of the files, a
+// node over P2P, RPC or REST, or the files as the node appends them.
+// Sources returning blocks in chain order can be combined, e.g. the
+// files for the history and RPC for the tip, as each one starts at the
+// height given and says when it has no more blocks.
+
+type BlockSource interface {
+	// Next returns the next block, io.EOF when there are no more (for
+	// now).
+	Next() (*BlockInfo, error)
+	Close() error
+}
+
+type BlockInfo struct {
+	*core.Block
+	Height int  // -1 = unknown, as in the block files
+	Orphan bool // not on the best chain (if the source knows)
+}
+
+// An index which includes the orphans, see coredb.ReadRawBlockHeaderIndex.
+type orphanIndex interface {
+	Orphan() bool
+}
+
+type indexSource struct {
+	bhs         core.BlockHeaderIndex
+	headersOnly bool
+}
+
+// NewIndexSource returns the blocks of the index, only their headers
+// if headersOnly.
+func NewIndexSource(bhs core.BlockHeaderIndex, headersOnly bool) BlockSource {
+	return &indexSource{bhs: bhs, headersOnly: headersOnly}
+}
+
+func (s *indexSource) Next() (*BlockInfo, error) {
+	if !s.bhs.Next() {
+		return nil, io.EOF
+	}
+	bh := s.bhs.BlockHeader()
+	if bh == nil {
+		return nil, io.EOF
+	}
+	bi := &BlockInfo{Height: s.bhs.CurrentHeight()}
+	if o, ok := s.bhs.(orphanIndex); ok {
+		bi.Orphan = o.Orphan()
+	}
+	if s.headersOnly {
+		bi.Block = &core.Block{BlockHeader: bh}
+		return bi, nil
+	}
+	b, err := s.bhs.ReadBlock()
+	if err != nil {
+		return nil, err
+	}
+	bi.Block = b
+	return bi, nil
+}
+
+func (s *indexSource) Close() error {
+	return s.bhs.Close()
+}
+
+// A SourceFunc opens a source of the blocks after those of the hashes,
+// by height (as returned by ChainWriter.HeightAndHashes), of all the
+// blocks if there are none.
+type SourceFunc func(hashes map[int][]core.Uint256) (BlockSource, error)
+
+type failoverSource struct {
+	cur        BlockSource
+	next       []SourceFunc
+	lastHeight int               // of the best chain
+	last       *core.BlockHeader // nil = none yet
+}
+
+// NewFailoverSource returns the blocks of first, then when it has no
+// more (or fails), those of the next sources in turn after the last
+// block of the best chain returned, e.g. the block files up to where
+// the node was when they were copied, then the node for the rest.
+func NewFailoverSource(first BlockSource, next ...SourceFunc) BlockSource {
+	return &failoverSource{cur: first, next: next}
+}
+
+func (s *failoverSource) Next() (*BlockInfo, error) {
+	for {
+		bi, err := s.cur.Next()
+		if err == nil {
+			if !bi.Orphan && bi.Height >= 0 {
+				s.lastHeight, s.last = bi.Height, bi.BlockHeader
+			}
+			return bi, nil
+		}
+		if len(s.next) == 0 {
+			return nil, err
+		}
+		if err != io.EOF {
+			log.Printf("Error reading blocks, switching to the next source: %v", err)
+		}
+		if err := s.cur.Close(); err != nil {
+			log.Printf("Error closing the block source: %v", err)
+		}
+		hashes := make(map[int][]core.Uint256)
+		if s.last != nil {
+			hashes[s.lastHeight] = []core.Uint256{s.last.Hash()}
+			log.Printf("Reading the blocks after height %d from the next source.", s.lastHeight)
+		}
+		src, err := s.next[0](hashes)
+		if err != nil {
+			return nil, err
+		}
+		s.cur, s.next = src, s.next[1:]
+	}
+}
+
+func (s *failoverSource) Close() error {
+	return s.cur.Close()
+}
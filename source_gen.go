@@ -0,0 +1,22 @@
+// Code generated by cmd/aliasgen. DO NOT EDIT.
+
+package blkchain
+
+import (
+	"github.com/blkchain/blkchain/core"
+	"github.com/blkchain/blkchain/source"
+)
+
+type BlockSource = source.BlockSource
+
+type BlockInfo = source.BlockInfo
+
+func NewIndexSource(bhs core.BlockHeaderIndex, headersOnly bool) BlockSource {
+	return source.NewIndexSource(bhs, headersOnly)
+}
+
+type SourceFunc = source.SourceFunc
+
+func NewFailoverSource(first BlockSource, next ...SourceFunc) BlockSource {
+	return source.NewFailoverSource(first, next...)
+}